@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/bench"
+)
+
+// runBench implements `anubisdb bench [flags]` and `anubisdb bench tpcb
+// [flags]`.
+func runBench(args []string) int {
+	if len(args) > 0 && args[0] == "tpcb" {
+		return runTPCB(args[1:])
+	}
+
+	cfg := bench.DefaultConfig()
+
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.StringVar(&cfg.File, "db", "", "database file to create (default: a temporary file)")
+	fs.IntVar(&cfg.Rows, "rows", cfg.Rows, "accounts loaded before the workloads run")
+	fs.IntVar(&cfg.Ops, "ops", cfg.Ops, "statements per workload")
+	fs.IntVar(&cfg.RangeSize, "range", cfg.RangeSize, "accounts covered by each range query")
+	fs.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random seed")
+	workloads := fs.String("workloads", strings.Join(cfg.Workloads, ","), "comma-separated workloads to run")
+	fs.Parse(args)
+
+	cfg.Workloads = strings.Split(*workloads, ",")
+
+	results, err := bench.Run(cfg)
+	if len(results) > 0 {
+		bench.WriteReport(os.Stdout, results)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func runTPCB(args []string) int {
+	cfg := bench.DefaultTPCBConfig()
+
+	fs := flag.NewFlagSet("bench tpcb", flag.ExitOnError)
+	fs.StringVar(&cfg.File, "db", "", "database file to create (default: a temporary file)")
+	fs.IntVar(&cfg.Scale, "scale", cfg.Scale, "number of branches")
+	fs.IntVar(&cfg.Accounts, "accounts", cfg.Accounts, "accounts per branch")
+	fs.IntVar(&cfg.Transactions, "txns", cfg.Transactions, "transactions to run")
+	fs.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random seed")
+	fs.Parse(args)
+
+	result, err := bench.RunTPCB(cfg)
+	if result.Ops > 0 {
+		bench.WriteReport(os.Stdout, []bench.Result{result})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	fmt.Println("consistency check passed")
+	return 0
+}
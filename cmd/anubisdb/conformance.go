@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kithinjibrian/anubisdb/internal/conformance"
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+// runConformance implements `anubisdb conformance [-write FILE] [-verify FILE]`.
+func runConformance(args []string) int {
+	fs := flag.NewFlagSet("conformance", flag.ExitOnError)
+	write := fs.String("write", "", "write the key vectors to a new database `FILE`, to verify on another machine")
+	verify := fs.String("verify", "", "verify a `FILE` written with -write")
+	fs.Parse(args)
+
+	type check struct {
+		name string
+		run  func() error
+	}
+	checks := []check{
+		{"key encodings", conformance.CheckEncodings},
+		{"amd64 fixture", conformance.VerifyFixture},
+		{"format version 2 upgrade", conformance.VerifyUpgrade},
+	}
+	if *write != "" {
+		checks = append(checks, check{"write " + *write, func() error { return conformance.Write(storage.OSFS, *write) }})
+	}
+	if *verify != "" {
+		checks = append(checks, check{"verify " + *verify, func() error { return conformance.Verify(storage.OSFS, *verify) }})
+	}
+
+	status := 0
+	for _, c := range checks {
+		if err := c.run(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: FAIL: %v\n", c.name, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: ok\n", c.name)
+	}
+	return status
+}
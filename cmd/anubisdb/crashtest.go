@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kithinjibrian/anubisdb/internal/crashtest"
+)
+
+// runCrashTest implements `anubisdb crashtest [flags]`.
+func runCrashTest(args []string) int {
+	cfg := crashtest.DefaultConfig()
+
+	fs := flag.NewFlagSet("crashtest", flag.ExitOnError)
+	fs.IntVar(&cfg.Transactions, "txns", cfg.Transactions, "transactions the workload runs")
+	fs.IntVar(&cfg.Rows, "rows", cfg.Rows, "rows each transaction inserts into each table")
+	fs.Int64Var(&cfg.SegmentSize, "segment-size", cfg.SegmentSize, "WAL segment size in bytes")
+	fs.IntVar(&cfg.Step, "step", cfg.Step, "inject a fault at every STEP-th file system call")
+	fs.BoolVar(&cfg.Torn, "torn", cfg.Torn, "also crash each failing write half way through")
+	fs.BoolVar(&cfg.KeepUnsynced, "keep-unsynced", cfg.KeepUnsynced, "keep writes that were not synced when crashing")
+	fs.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random seed")
+	fs.Parse(args)
+
+	result, err := crashtest.Run(cfg, os.Stdout)
+	fmt.Printf("%d file system calls, %d crashes recovered from\n", result.Calls, result.Crashes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// interrupter turns Ctrl-C into cancelling the running statement instead of
+// killing the shell. With nothing running it just prints a fresh prompt.
+type interrupter struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	prompt string
+}
+
+func newInterrupter(prompt string) *interrupter {
+	i := &interrupter{prompt: prompt}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		for range signals {
+			i.mu.Lock()
+			if i.cancel != nil {
+				i.cancel()
+			} else {
+				fmt.Print("\n" + i.prompt)
+			}
+			i.mu.Unlock()
+		}
+	}()
+	return i
+}
+
+// run calls fn with a context that the next Ctrl-C cancels.
+func (i *interrupter) run(fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	i.mu.Lock()
+	i.cancel = cancel
+	i.mu.Unlock()
+
+	defer func() {
+		i.mu.Lock()
+		i.cancel = nil
+		i.mu.Unlock()
+		cancel()
+	}()
+	return fn(ctx)
+}
@@ -2,48 +2,462 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
 	"github.com/kithinjibrian/anubisdb/internal/engine"
 	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/internal/storage"
 )
 
 func main() {
-	fmt.Println("Welcome to AnubisDB! Type 'exit' to quit.")
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "crashtest" {
+		os.Exit(runCrashTest(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		os.Exit(runRestore(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		os.Exit(runMerge(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "conformance" {
+		os.Exit(runConformance(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "upgrade" {
+		os.Exit(runUpgrade(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+	os.Exit(run(os.Args[1:]))
+}
 
+// run implements `anubisdb [flags] [FILE]`: it opens FILE, runs the --init
+// script, and then either the --exec statement or the interactive shell.
+func run(args []string) int {
+	fs := flag.NewFlagSet("anubisdb", flag.ExitOnError)
+	readOnly := fs.Bool("readonly", false, "reject statements that change the database")
+	format := fs.String("format", "", "output format: table, csv or json")
+	initFile := fs.String("init", "", "script of statements to run before anything else")
+	fixtures := fs.String("fixtures", "", "load the .sql and .csv files in this directory after --init")
+	memory := fs.Bool("memory", false, "keep the database in memory instead of FILE, for use with --fixtures")
+	exec := fs.String("exec", "", "run one statement and exit")
+	pageSize := fs.Int("page-size", storage.PageSize, "page size in bytes; this build supports only its built-in size")
+	noHeader := fs.Bool("no-header", false, "omit column names from table and csv results")
+	quiet := fs.Bool("quiet", false, "hide the welcome banner and prompts")
+	walArchive := fs.String("wal-archive", "", "put the database in WAL mode and archive completed segments to this directory")
+	pointerMap := fs.Bool("pointer-map", false, "keep a pointer map in a new database, so that .vacuum can shrink it")
+	warmup := fs.Bool("warmup", false, "load the pages read most before the database was last closed into the page cache, and count reads to save them again on exit")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: anubisdb [flags] [FILE]\n       anubisdb bench [tpcb] [flags]\n       anubisdb crashtest [flags]\n       anubisdb restore [-until TIME] BASE ARCHIVE DEST\n       anubisdb merge DEST FULL [INCREMENTAL...]\n       anubisdb upgrade FILE\n       anubisdb migrate [-db FILE] [-steps N] up|down|status DIR\n       anubisdb conformance [-write FILE] [-verify FILE]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
 	dbName := "anubis.db"
+	if fs.NArg() == 1 {
+		dbName = fs.Arg(0)
+	}
 
-	if len(os.Args) > 1 {
-		dbName = os.Args[1]
+	// The page size is fixed when the binary is built, and every file it
+	// opens or creates uses it.
+	if *pageSize != storage.PageSize {
+		fmt.Fprintf(os.Stderr, "Error: unsupported page size %d: this build supports only %d-byte pages\n", *pageSize, storage.PageSize)
+		return 2
+	}
+	if *readOnly {
+		if _, err := os.Stat(dbName); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: cannot open database read-only:", err)
+			return 1
+		}
 	}
 
-	db, err := engine.NewEngine(dbName)
+	// A read-only shell can run alongside a process writing in WAL mode.
+	open := engine.OpenEngine
+	if *readOnly {
+		open = engine.OpenEngineReadOnly
+	}
+	vfs := storage.OSFS
+	if *memory {
+		vfs = storage.NewMemFS()
+	}
+	db, err := open(vfs, dbName)
 	if err != nil {
 		fmt.Println("Error initializing database:", err)
-		return
+		return 1
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+	}()
+	db.SetLogger(warnLogger{engine.NewTextLogger(os.Stderr)})
+
+	if *pointerMap {
+		if err := db.EnablePointerMap(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+	}
+	if *walArchive != "" {
+		if err := db.EnableWAL(storage.WALConfig{Archive: storage.DirArchive(*walArchive)}); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+	}
+	if *warmup {
+		db.SetHotPageTracking(true)
+		// A cold cache is slower, not wrong, so a bad list is only reported.
+		pages, err := db.Warmup()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Warning:", err)
+		} else if !*quiet {
+			fmt.Printf("%d page(s) warmed up\n", pages)
+		}
+	}
+	db.SetReadOnly(*readOnly)
+	if *format != "" {
+		if err := db.Set("output_format", *format); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+	}
+	if *noHeader {
+		db.Set("headers", "off")
 	}
-	defer db.Close()
 
-	reader := bufio.NewReader(os.Stdin)
+	prompt := "anubis> "
+	if *quiet {
+		prompt = ""
+	}
+	interrupts := newInterrupter(prompt)
 
+	if *initFile != "" {
+		if err := runInit(db, interrupts, *initFile); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+	}
+
+	if *fixtures != "" {
+		rows, err := db.LoadFixtures(os.DirFS(*fixtures), ".")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		if !*quiet {
+			fmt.Printf("%d fixture row(s) loaded\n", rows)
+		}
+	}
+
+	if *exec != "" {
+		if !runLine(db, interrupts, strings.TrimSpace(*exec)) {
+			return 1
+		}
+		return 0
+	}
+
+	if !*quiet {
+		fmt.Println("Welcome to AnubisDB! Type 'exit' to quit.")
+	}
+	if err := runScript(db, interrupts, bufio.NewReader(os.Stdin), prompt); err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// warnLogger passes on only the engine's warnings, such as an index hint
+// naming no index or a slow statement. The shell prints each statement's
+// result and error itself, so the other records would only repeat them.
+type warnLogger struct{ engine.Logger }
+
+func (l warnLogger) Log(level engine.LogLevel, msg string, fields map[string]interface{}) {
+	if level == engine.LogWarn {
+		l.Logger.Log(level, msg, fields)
+	}
+}
+
+// runScript runs one statement or dot command per line of r, showing prompt
+// before each, until exit or the end of the input.
+func runScript(db *engine.Engine, interrupts *interrupter, r *bufio.Reader, prompt string) error {
 	for {
-		fmt.Print("anubis> ")
-		input, _ := reader.ReadString('\n')
-		input = strings.TrimSpace(input)
+		fmt.Print(prompt)
+		line, err := r.ReadString('\n')
+		if err != nil && line == "" {
+			// Ctrl-D or the end of a piped script.
+			if prompt != "" {
+				fmt.Println()
+			}
+			if err != io.EOF {
+				return err
+			}
+			return nil
+		}
+		input := strings.TrimSpace(line)
 
+		if input == "" || strings.HasPrefix(input, "--") {
+			continue
+		}
 		if input == "exit" {
-			break
+			return nil
 		}
+		runLine(db, interrupts, input)
+	}
+}
 
-		ast, err := parser.Parse(input)
-		if err != nil {
-			fmt.Println(err)
+// runInit runs the --init script in file, stopping at the first statement
+// or dot command that fails. Its statements end with ; and may span lines,
+// see parser.SplitScript, and a line starting with . is a dot command.
+func runInit(db *engine.Engine, interrupts *interrupter, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	run := 0
+	flush := func() error {
+		for _, statement := range parser.SplitScript(strings.Join(lines, "\n")) {
+			run++
+			if !runLine(db, interrupts, statement) {
+				return fmt.Errorf("%s: stopped at statement %d", file, run)
+			}
+		}
+		lines = nil
+		return nil
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if command := strings.TrimSpace(line); strings.HasPrefix(command, ".") {
+			if err := flush(); err != nil {
+				return err
+			}
+			run++
+			if !runLine(db, interrupts, command) {
+				return fmt.Errorf("%s: stopped at statement %d", file, run)
+			}
 			continue
 		}
+		lines = append(lines, line)
+	}
+	return flush()
+}
+
+// runLine runs a statement or dot command and prints its result. It reports
+// whether it succeeded.
+func runLine(db *engine.Engine, interrupts *interrupter, input string) bool {
+	if strings.HasPrefix(input, ".") {
+		return runCommand(db, input)
+	}
+
+	ast, err := parser.Parse(input)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	err = interrupts.run(func(ctx context.Context) error {
+		return db.StreamContext(ctx, os.Stdout, ast)
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return false
+	}
+	return true
+}
+
+// runCommand handles the dot commands that inspect the database instead of
+// running SQL. It reports whether the command succeeded.
+func runCommand(db *engine.Engine, input string) bool {
+	fields := strings.Fields(input)
+	switch fields[0] {
+	case ".page":
+		if len(fields) < 2 || len(fields) > 3 || (len(fields) == 3 && fields[2] != "hex") {
+			fmt.Println("usage: .page N [hex]")
+			return false
+		}
+		pageNum, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			fmt.Println("invalid page number:", fields[1])
+			return false
+		}
+		info, err := db.InspectPage(uint32(pageNum))
+		if err != nil {
+			fmt.Println("Error:", err)
+			return false
+		}
+		fmt.Print(info)
+		if len(fields) == 3 {
+			fmt.Print(info.Hexdump())
+		}
+	case ".check":
+		if err := db.CheckIntegrity(); err != nil {
+			fmt.Println("Error:", err)
+			return false
+		}
+		fmt.Println("ok")
+	case ".compact":
+		if len(fields) != 2 {
+			fmt.Println("usage: .compact TABLE")
+			return false
+		}
+		if err := db.Compact(fields[1]); err != nil {
+			fmt.Println("Error:", err)
+			return false
+		}
+		fmt.Println("ok")
+	case ".vacuum":
+		if len(fields) > 2 {
+			fmt.Println("usage: .vacuum [PAGES]")
+			return false
+		}
+		limit := 0
+		if len(fields) == 2 {
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n <= 0 {
+				fmt.Println("invalid page count:", fields[1])
+				return false
+			}
+			limit = n
+		}
+		released, err := db.IncrementalVacuum(limit)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return false
+		}
+		fmt.Printf("%d pages released\n", released)
+	case ".advise":
+		advice, err := db.Advise()
+		if err != nil {
+			fmt.Println("Error:", err)
+			return false
+		}
+		if len(advice) == 0 {
+			fmt.Println("no maintenance needed")
+		}
+		for _, a := range advice {
+			fmt.Printf("%s\n    %s: %s\n", a.Action, a.Reason, a.Benefit)
+		}
+	case ".reindex":
+		if len(fields) != 2 {
+			fmt.Println("usage: .reindex INDEX")
+			return false
+		}
+		if err := db.Reindex(fields[1]); err != nil {
+			fmt.Println("Error:", err)
+			return false
+		}
+		fmt.Println("ok")
+	case ".hot":
+		if len(fields) > 2 {
+			fmt.Println("usage: .hot [N]")
+			return false
+		}
+		limit := 10
+		if len(fields) == 2 {
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n <= 0 {
+				fmt.Println("invalid page count:", fields[1])
+				return false
+			}
+			limit = n
+		}
+		hot := db.HotPages(limit)
+		if len(hot) == 0 {
+			fmt.Println("no page reads counted yet; --warmup turns counting on")
+		}
+		for _, h := range hot {
+			fmt.Printf("page %d: %d read(s)\n", h.Page, h.Reads)
+		}
+	case ".backup":
+		if len(fields) < 2 || len(fields) > 3 {
+			fmt.Println("usage: .backup FILE [PARENT]")
+			return false
+		}
+		var err error
+		if len(fields) == 3 {
+			err = db.BackupIncremental(fields[1], fields[2])
+		} else {
+			err = db.Backup(fields[1])
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			return false
+		}
+		fmt.Println("backup written to", fields[1])
+	case ".load":
+		if len(fields) < 3 || len(fields) > 4 || (len(fields) == 4 && fields[3] != "header") {
+			fmt.Println("usage: .load TABLE FILE.csv [header]")
+			return false
+		}
+		file, err := os.Open(fields[2])
+		if err != nil {
+			fmt.Println("Error:", err)
+			return false
+		}
+		defer file.Close()
+		n, err := db.LoadCSV(fields[1], bufio.NewReaderSize(file, 1<<20), len(fields) == 4)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return false
+		}
+		fmt.Printf("%d row(s) loaded\n", n)
+	case ".csv":
+		usage := "usage: .csv TABLE FILE.csv (COLUMN TYPE, ...) [header]"
+		head, rest, ok := strings.Cut(input, "(")
+		spec, tail, closed := strings.Cut(rest, ")")
+		fields = strings.Fields(head)
+		if !ok || !closed || len(fields) != 3 || (strings.TrimSpace(tail) != "" && strings.TrimSpace(tail) != "header") {
+			fmt.Println(usage)
+			return false
+		}
+		columns, err := parseColumns(spec)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return false
+		}
+		table, err := engine.NewCSVTable(fields[2], strings.TrimSpace(tail) == "header", columns)
+		if err == nil {
+			err = db.RegisterVirtualTable(fields[1], table)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			return false
+		}
+		fmt.Printf("csv table '%s' registered\n", fields[1])
+	default:
+		fmt.Printf("unknown command %s\n", fields[0])
+		return false
+	}
+	return true
+}
 
-		result := db.Execute(ast)
-		fmt.Println(result)
+// parseColumns reads column declarations of the form "id INT, name TEXT".
+func parseColumns(spec string) ([]catalog.Column, error) {
+	var columns []catalog.Column
+	for _, decl := range strings.Split(spec, ",") {
+		parts := strings.Fields(decl)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid column declaration '%s'", strings.TrimSpace(decl))
+		}
+		colType, ok := catalog.ParseColumnType(parts[1])
+		if !ok {
+			return nil, fmt.Errorf("unknown column type '%s'", parts[1])
+		}
+		columns = append(columns, catalog.Column{Name: parts[0], Type: colType})
 	}
+	return columns, nil
 }
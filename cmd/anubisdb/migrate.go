@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kithinjibrian/anubisdb/internal/engine"
+	"github.com/kithinjibrian/anubisdb/internal/migrate"
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+// runMigrate implements `anubisdb migrate [flags] up|down|status DIR`.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dbName := fs.String("db", "anubis.db", "database file to migrate")
+	steps := fs.Int("steps", 0, "apply or revert at most this many migrations; down defaults to 1")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: anubisdb migrate [flags] up|down|status DIR")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	command, dir := fs.Arg(0), fs.Arg(1)
+	if command != "up" && command != "down" && command != "status" {
+		fs.Usage()
+		return 2
+	}
+
+	migrations, err := migrate.Load(os.DirFS(dir), ".")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	db, err := engine.OpenEngine(storage.OSFS, *dbName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	defer db.Close()
+	runner := migrate.NewRunner(db, migrations)
+
+	switch command {
+	case "status":
+		status, err := runner.Status()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Missing {
+				state = "applied " + s.AppliedAt + ", not in " + dir
+			} else if s.AppliedAt != "" {
+				state = "applied " + s.AppliedAt
+			}
+			fmt.Printf("%-40s %s\n", s.Migration, state)
+		}
+		return 0
+	case "up":
+		done, err := runner.Up(*steps)
+		for _, m := range done {
+			fmt.Println("applied", m)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		if len(done) == 0 {
+			fmt.Println("no migrations to apply")
+		}
+		return 0
+	default:
+		if *steps == 0 {
+			*steps = 1
+		}
+		done, err := runner.Down(*steps)
+		for _, m := range done {
+			fmt.Println("reverted", m)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		if len(done) == 0 {
+			fmt.Println("no migrations to revert")
+		}
+		return 0
+	}
+}
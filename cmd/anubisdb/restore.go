@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+// runRestore implements `anubisdb restore [-until TIME] BASE ARCHIVE DEST`.
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	until := fs.String("until", "", "stop before the first commit after this RFC 3339 time (default: replay everything)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: anubisdb restore [-until TIME] BASE ARCHIVE DEST")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 3 {
+		fs.Usage()
+		return 2
+	}
+
+	var target time.Time
+	if *until != "" {
+		var err error
+		if target, err = time.Parse(time.RFC3339Nano, *until); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: invalid -until time:", err)
+			return 2
+		}
+	}
+
+	last, err := storage.Restore(fs.Arg(0), storage.DirArchive(fs.Arg(1)), target, fs.Arg(2))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	if last.IsZero() {
+		fmt.Println("restored the base backup; no WAL records replayed")
+	} else {
+		fmt.Println("restored to", last.Format(time.RFC3339Nano))
+	}
+	return 0
+}
+
+// runMerge implements `anubisdb merge DEST FULL [INCREMENTAL...]`.
+func runMerge(args []string) int {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: anubisdb merge DEST FULL [INCREMENTAL...]")
+		return 2
+	}
+	if err := storage.MergeBackups(args[0], args[1], args[2:]...); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	fmt.Println("merged backup written to", args[0])
+	return 0
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/engine"
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+// runUpgrade implements `anubisdb upgrade FILE`.
+func runUpgrade(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: anubisdb upgrade FILE")
+		return 2
+	}
+	if _, err := os.Stat(args[0]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	version, rebuilt, err := engine.Upgrade(storage.OSFS, args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	if version == storage.FormatVersion && len(rebuilt) == 0 {
+		fmt.Printf("%s is already at format version %d\n", args[0], version)
+		return 0
+	}
+	fmt.Printf("upgraded %s from format version %d to %d\n", args[0], version, storage.FormatVersion)
+	if len(rebuilt) > 0 {
+		fmt.Println("rebuilt indexes:", strings.Join(rebuilt, ", "))
+	}
+	return 0
+}
@@ -0,0 +1,267 @@
+// Package bench runs synthetic workloads against a fresh database and
+// reports their throughput and latency, so storage and planner changes can
+// be measured.
+package bench
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kithinjibrian/anubisdb/internal/engine"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+)
+
+const branches = 10
+
+type Config struct {
+	// File is the database to create; it must not exist yet. A temporary
+	// file is used and removed afterwards when it is empty.
+	File string
+	// Rows is the number of accounts loaded before the workloads run.
+	Rows int
+	// Ops is the number of statements each workload runs.
+	Ops int
+	// RangeSize is the number of accounts each range query covers.
+	RangeSize int
+	Workloads []string
+	Seed      int64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Rows:      10000,
+		Ops:       1000,
+		RangeSize: 100,
+		Workloads: Workloads(),
+		Seed:      1,
+	}
+}
+
+// Result summarizes one workload. Latencies and allocations are per
+// statement, including parsing.
+type Result struct {
+	Workload string
+	Ops      int
+	Elapsed  time.Duration
+	P50      time.Duration
+	P95      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+	// Allocs and Bytes count heap allocations made by the whole process
+	// while the workload ran, divided by Ops.
+	Allocs uint64
+	Bytes  uint64
+}
+
+func (r Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / r.Elapsed.Seconds()
+}
+
+// workload returns the statement for the i-th operation.
+type workload func(b *runner, i int) string
+
+var workloads = map[string]workload{
+	"insert": func(b *runner, i int) string {
+		id := b.cfg.Rows + i
+		return fmt.Sprintf("INSERT INTO bench_accounts VALUES (%d, 'account%d', %d, %d)",
+			id, id, b.rng.Intn(100000), id%branches)
+	},
+	"point": func(b *runner, i int) string {
+		return fmt.Sprintf("SELECT * FROM bench_accounts WHERE id = %d", b.rng.Intn(b.cfg.Rows))
+	},
+	"range": func(b *runner, i int) string {
+		start := b.rng.Intn(b.cfg.Rows)
+		return fmt.Sprintf("SELECT * FROM bench_accounts WHERE id >= %d AND id < %d", start, start+b.cfg.RangeSize)
+	},
+	"join": func(b *runner, i int) string {
+		return fmt.Sprintf("SELECT id, branch_name FROM bench_accounts JOIN bench_branches ON branch = branch_id WHERE id = %d",
+			b.rng.Intn(b.cfg.Rows))
+	},
+}
+
+// Workloads lists the workload names in the order Run runs them.
+func Workloads() []string {
+	return []string{"insert", "point", "range", "join"}
+}
+
+type runner struct {
+	cfg Config
+	db  *engine.Engine
+	rng *rand.Rand
+}
+
+// openDB creates the database a benchmark runs against. The returned func
+// closes it and removes it if it was a temporary file.
+func openDB(file string) (*engine.Engine, func(), error) {
+	cleanup := func() {}
+	if file == "" {
+		dir, err := os.MkdirTemp("", "anubisdb-bench")
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup = func() { os.RemoveAll(dir) }
+		file = filepath.Join(dir, "bench.db")
+	} else if _, err := os.Stat(file); err == nil {
+		return nil, nil, fmt.Errorf("database %s already exists", file)
+	}
+
+	db, err := engine.NewEngine(file)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return db, func() {
+		db.Close()
+		cleanup()
+	}, nil
+}
+
+// Run creates the benchmark tables, loads cfg.Rows accounts and runs each
+// workload in turn, stopping at the first failing statement.
+func Run(cfg Config) ([]Result, error) {
+	if cfg.Rows <= 0 || cfg.Ops <= 0 || cfg.RangeSize <= 0 {
+		return nil, fmt.Errorf("rows, ops and range size must be positive")
+	}
+	for _, name := range cfg.Workloads {
+		if _, ok := workloads[name]; !ok {
+			return nil, fmt.Errorf("unknown workload %q (want one of %s)", name, strings.Join(Workloads(), ", "))
+		}
+	}
+
+	db, closeDB, err := openDB(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDB()
+
+	b := &runner{cfg: cfg, db: db, rng: rand.New(rand.NewSource(cfg.Seed))}
+	if err := b.setup(); err != nil {
+		return nil, fmt.Errorf("setup failed: %w", err)
+	}
+
+	var results []Result
+	for _, name := range cfg.Workloads {
+		next := workloads[name]
+		result, err := measure(name, cfg.Ops, func(i int) error {
+			sql := next(b, i)
+			if err := exec(db, sql); err != nil {
+				return fmt.Errorf("%s: %w", sql, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", name, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (b *runner) setup() error {
+	for _, sql := range []string{
+		"CREATE TABLE bench_accounts (id INT PRIMARY KEY, name TEXT, balance INT, branch INT)",
+		"CREATE TABLE bench_branches (branch_id INT PRIMARY KEY, branch_name TEXT)",
+	} {
+		if err := exec(b.db, sql); err != nil {
+			return err
+		}
+	}
+
+	var csv strings.Builder
+	for i := 0; i < branches; i++ {
+		fmt.Fprintf(&csv, "%d,branch%d\n", i, i)
+	}
+	if _, err := b.db.LoadCSV("bench_branches", strings.NewReader(csv.String()), false); err != nil {
+		return err
+	}
+
+	csv.Reset()
+	for i := 0; i < b.cfg.Rows; i++ {
+		fmt.Fprintf(&csv, "%d,account%d,%d,%d\n", i, i, b.rng.Intn(100000), i%branches)
+	}
+	_, err := b.db.LoadCSV("bench_accounts", strings.NewReader(csv.String()), false)
+	return err
+}
+
+func exec(db *engine.Engine, sql string) error {
+	node, err := parser.Parse(sql)
+	if err != nil {
+		return err
+	}
+	_, err = db.Run(node)
+	return err
+}
+
+// measure runs op ops times and summarizes the latencies.
+func measure(name string, ops int, op func(i int) error) (Result, error) {
+	latencies := make([]time.Duration, ops)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := range latencies {
+		opStart := time.Now()
+		if err := op(i); err != nil {
+			return Result{}, err
+		}
+		latencies[i] = time.Since(opStart)
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return Result{
+		Workload: name,
+		Ops:      len(latencies),
+		Elapsed:  elapsed,
+		P50:      percentile(latencies, 0.50),
+		P95:      percentile(latencies, 0.95),
+		P99:      percentile(latencies, 0.99),
+		Max:      latencies[len(latencies)-1],
+		Allocs:   (after.Mallocs - before.Mallocs) / uint64(ops),
+		Bytes:    (after.TotalAlloc - before.TotalAlloc) / uint64(ops),
+	}, nil
+}
+
+// percentile reads the p-th percentile from sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(p*float64(len(sorted))+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// WriteReport prints results as a table.
+func WriteReport(w io.Writer, results []Result) {
+	fmt.Fprintf(w, "%-10s %8s %12s %10s %10s %10s %10s %10s %10s\n", "workload", "ops", "ops/sec", "p50", "p95", "p99", "max", "allocs/op", "B/op")
+	for _, r := range results {
+		fmt.Fprintf(w, "%-10s %8d %12.1f %10s %10s %10s %10s %10d %10d\n", r.Workload, r.Ops, r.Throughput(),
+			round(r.P50), round(r.P95), round(r.P99), round(r.Max), r.Allocs, r.Bytes)
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(100 * time.Nanosecond)
+	}
+}
@@ -0,0 +1,39 @@
+package bench
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// benchmarkWorkload runs workload name b.N times against accounts loaded as
+// Run loads them, timing only the workload.
+func benchmarkWorkload(b *testing.B, name string) {
+	cfg := DefaultConfig()
+	cfg.File = filepath.Join(b.TempDir(), "bench.db")
+	db, closeDB, err := openDB(cfg.File)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer closeDB()
+
+	r := &runner{cfg: cfg, db: db, rng: rand.New(rand.NewSource(cfg.Seed))}
+	if err := r.setup(); err != nil {
+		b.Fatalf("setup failed: %v", err)
+	}
+
+	next := workloads[name]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sql := next(r, i)
+		if err := exec(db, sql); err != nil {
+			b.Fatalf("%s: %v", sql, err)
+		}
+	}
+}
+
+func BenchmarkInsert(b *testing.B) { benchmarkWorkload(b, "insert") }
+func BenchmarkPoint(b *testing.B)  { benchmarkWorkload(b, "point") }
+func BenchmarkRange(b *testing.B)  { benchmarkWorkload(b, "range") }
+func BenchmarkJoin(b *testing.B)   { benchmarkWorkload(b, "join") }
@@ -0,0 +1,218 @@
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/engine"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+)
+
+const (
+	tellersPerBranch = 10
+	// The engine has no negative literals yet, so balances start high
+	// enough that no run drives them below zero, and history records the
+	// size of each change apart from its direction.
+	initialBalance = 1000000000
+	maxDelta       = 99999
+)
+
+type TPCBConfig struct {
+	// File is the database to create, as in Config.
+	File string
+	// Scale is the number of branches.
+	Scale int
+	// Accounts is the number of accounts per branch.
+	Accounts     int
+	Transactions int
+	Seed         int64
+}
+
+func DefaultTPCBConfig() TPCBConfig {
+	return TPCBConfig{
+		Scale:        1,
+		Accounts:     100000,
+		Transactions: 1000,
+		Seed:         1,
+	}
+}
+
+type tpcb struct {
+	cfg TPCBConfig
+	db  *engine.Engine
+	rng *rand.Rand
+
+	// Balances as the transactions leave them; every balance not listed
+	// is still initialBalance.
+	accounts map[int]int64
+	tellers  map[int]int64
+	branches map[int]int64
+	total    int64
+}
+
+// RunTPCB runs a TPC-B-like workload: each transaction moves a random amount
+// into or out of an account and adds it to the account's teller and branch
+// totals and to the history table, as one batch. Afterwards it checks that
+// the stored totals add up.
+func RunTPCB(cfg TPCBConfig) (Result, error) {
+	if cfg.Scale <= 0 || cfg.Accounts <= 0 || cfg.Transactions <= 0 {
+		return Result{}, fmt.Errorf("scale, accounts and transactions must be positive")
+	}
+
+	db, closeDB, err := openDB(cfg.File)
+	if err != nil {
+		return Result{}, err
+	}
+	defer closeDB()
+
+	t := &tpcb{
+		cfg:      cfg,
+		db:       db,
+		rng:      rand.New(rand.NewSource(cfg.Seed)),
+		accounts: make(map[int]int64),
+		tellers:  make(map[int]int64),
+		branches: make(map[int]int64),
+	}
+	if err := t.setup(); err != nil {
+		return Result{}, fmt.Errorf("setup failed: %w", err)
+	}
+
+	result, err := measure("tpcb", cfg.Transactions, func(int) error {
+		return db.Batch(t.transaction)
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	if err := t.verify(); err != nil {
+		return result, fmt.Errorf("consistency check failed: %w", err)
+	}
+	return result, nil
+}
+
+func (t *tpcb) setup() error {
+	for _, sql := range []string{
+		"CREATE TABLE bench_branches (bid INT PRIMARY KEY, bbalance INT)",
+		"CREATE TABLE bench_tellers (tid INT PRIMARY KEY, tbid INT, tbalance INT)",
+		"CREATE TABLE bench_accounts (aid INT PRIMARY KEY, abid INT, abalance INT)",
+		"CREATE TABLE bench_history (htid INT, hbid INT, haid INT, amount INT, direction TEXT)",
+	} {
+		if err := exec(t.db, sql); err != nil {
+			return err
+		}
+	}
+
+	load := func(table string, n int, row func(i int) string) error {
+		var csv strings.Builder
+		for i := 0; i < n; i++ {
+			csv.WriteString(row(i))
+			csv.WriteString("\n")
+		}
+		_, err := t.db.LoadCSV(table, strings.NewReader(csv.String()), false)
+		return err
+	}
+
+	if err := load("bench_branches", t.cfg.Scale, func(i int) string {
+		return fmt.Sprintf("%d,%d", i, initialBalance)
+	}); err != nil {
+		return err
+	}
+	if err := load("bench_tellers", t.cfg.Scale*tellersPerBranch, func(i int) string {
+		return fmt.Sprintf("%d,%d,%d", i, i/tellersPerBranch, initialBalance)
+	}); err != nil {
+		return err
+	}
+	return load("bench_accounts", t.cfg.Scale*t.cfg.Accounts, func(i int) string {
+		return fmt.Sprintf("%d,%d,%d", i, i/t.cfg.Accounts, initialBalance)
+	})
+}
+
+func (t *tpcb) transaction() error {
+	aid := t.rng.Intn(t.cfg.Scale * t.cfg.Accounts)
+	tid := t.rng.Intn(t.cfg.Scale * tellersPerBranch)
+	bid := tid / tellersPerBranch
+	delta := int64(t.rng.Intn(2*maxDelta+1) - maxDelta)
+
+	direction, amount := "credit", delta
+	if delta < 0 {
+		direction, amount = "debit", -delta
+	}
+
+	for _, sql := range []string{
+		fmt.Sprintf("SELECT abalance FROM bench_accounts WHERE aid = %d", aid),
+		fmt.Sprintf("UPDATE bench_accounts SET abalance = %d WHERE aid = %d", add(t.accounts, aid, delta), aid),
+		fmt.Sprintf("UPDATE bench_tellers SET tbalance = %d WHERE tid = %d", add(t.tellers, tid, delta), tid),
+		fmt.Sprintf("UPDATE bench_branches SET bbalance = %d WHERE bid = %d", add(t.branches, bid, delta), bid),
+		fmt.Sprintf("INSERT INTO bench_history VALUES (%d, %d, %d, %d, '%s')", tid, bid, aid, amount, direction),
+	} {
+		if err := exec(t.db, sql); err != nil {
+			return fmt.Errorf("%s: %w", sql, err)
+		}
+	}
+	t.total += delta
+	return nil
+}
+
+// add applies delta to balances[id] and returns the new balance.
+func add(balances map[int]int64, id int, delta int64) int64 {
+	balance, ok := balances[id]
+	if !ok {
+		balance = initialBalance
+	}
+	balance += delta
+	balances[id] = balance
+	return balance
+}
+
+// verify checks that the account, teller and branch balances each moved by
+// the sum of all deltas and that every transaction left a history row.
+func (t *tpcb) verify() error {
+	checks := []struct {
+		sql  string
+		want int64
+	}{
+		{"SELECT SUM(abalance) FROM bench_accounts", int64(t.cfg.Scale*t.cfg.Accounts)*initialBalance + t.total},
+		{"SELECT SUM(tbalance) FROM bench_tellers", int64(t.cfg.Scale*tellersPerBranch)*initialBalance + t.total},
+		{"SELECT SUM(bbalance) FROM bench_branches", int64(t.cfg.Scale)*initialBalance + t.total},
+		{"SELECT COUNT(*) FROM bench_history", int64(t.cfg.Transactions)},
+	}
+	for _, check := range checks {
+		got, err := queryInt(t.db, check.sql)
+		if err != nil {
+			return err
+		}
+		if got != check.want {
+			return fmt.Errorf("%s returned %d, want %d", check.sql, got, check.want)
+		}
+	}
+	return nil
+}
+
+// queryInt runs a query returning a single integer.
+func queryInt(db *engine.Engine, sql string) (int64, error) {
+	format, err := db.Setting("output_format")
+	if err != nil {
+		return 0, err
+	}
+	if err := db.Set("output_format", "csv"); err != nil {
+		return 0, err
+	}
+	defer db.Set("output_format", format)
+
+	node, err := parser.Parse(sql)
+	if err != nil {
+		return 0, err
+	}
+	result, err := db.Run(node)
+	if err != nil {
+		return 0, err
+	}
+
+	lines := strings.Split(result, "\n")
+	if len(lines) != 2 {
+		return 0, fmt.Errorf("%s: expected one value, got %q", sql, result)
+	}
+	return strconv.ParseInt(lines[1], 10, 64)
+}
@@ -0,0 +1,97 @@
+package catalog
+
+import (
+	"fmt"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/internal/utils"
+)
+
+// A table created WITH (BLOOM_FILTER = ON) has a bloom filter on each of
+// its indexes, held in memory, of the values the index holds, so that a
+// point lookup of a value no row has is usually answered without reading
+// the index. A filter is built from its index by the first lookup that needs
+// it, after which writes add their keys. Deleted keys stay, which only
+// costs false positives, until more keys were added than the filter was
+// sized for and it is built again. Like the other caches, filters are
+// dropped whenever the file may have changed underneath them, and on any
+// schema change.
+
+const (
+	// BloomFalsePositiveRate is the rate filters are sized for.
+	BloomFalsePositiveRate = 0.01
+
+	// A filter is sized for bloomHeadroom times the keys its index holds
+	// when it is built, and for at least minBloomKeys, so that a growing
+	// index is not read again soon.
+	bloomHeadroom = 2
+	minBloomKeys  = 1024
+)
+
+// BloomStats counts the filters and what they saved.
+type BloomStats struct {
+	Filters int
+	Bytes   int
+	// Lookups counts the lookups that consulted a filter, and Skipped
+	// those of them it answered.
+	Lookups uint64
+	Skipped uint64
+}
+
+func (c *Catalog) BloomStats() BloomStats {
+	stats := c.bloomStats
+	stats.Filters = len(c.blooms)
+	for _, f := range c.blooms {
+		stats.Bytes += f.Bytes()
+	}
+	return stats
+}
+
+// indexMayContain reports whether idxMeta may hold key, the values of its
+// columns as columnsKey builds them, reading its tree to build the filter if
+// it has none. Without a filter it always may.
+func (t *Table) indexMayContain(idxMeta *IndexMetadata, key storage.Key) (bool, error) {
+	if !t.schema.BloomFilter {
+		return true, nil
+	}
+
+	f := t.Catalog.blooms[idxMeta.RootPage]
+	if f == nil {
+		tree, err := t.getIndexTree(idxMeta)
+		if err != nil {
+			return false, err
+		}
+		count, err := tree.Count()
+		if err != nil {
+			return false, fmt.Errorf("failed to build bloom filter for index %s: %w", idxMeta.Name, err)
+		}
+		f = utils.NewBloomFilter(max(count*bloomHeadroom, minBloomKeys), BloomFalsePositiveRate)
+		err = tree.ForEach(func(k storage.Key, _ []byte) bool {
+			f.Add(indexValueKey(idxMeta, k).Encode())
+			return true
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to build bloom filter for index %s: %w", idxMeta.Name, err)
+		}
+		t.Catalog.blooms[idxMeta.RootPage] = f
+	}
+
+	t.Catalog.bloomStats.Lookups++
+	if f.MayContain(key.Encode()) {
+		return true, nil
+	}
+	t.Catalog.bloomStats.Skipped++
+	return false, nil
+}
+
+// indexAdded adds a key written to idxMeta to its filter, if it has one.
+func (t *Table) indexAdded(idxMeta *IndexMetadata, key storage.Key) {
+	f := t.Catalog.blooms[idxMeta.RootPage]
+	if f == nil {
+		return
+	}
+	f.Add(indexValueKey(idxMeta, key).Encode())
+	if f.Full() {
+		delete(t.Catalog.blooms, idxMeta.RootPage)
+	}
+}
@@ -1,20 +1,36 @@
 package catalog
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 
 	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/internal/utils"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
 )
 
 const SystemCatalogTable = "anubis_catalog"
 
+// RowIDColumn is the hidden column holding the implicit B-tree key of tables
+// declared without a PRIMARY KEY.
+const RowIDColumn = "_rowid_"
+
 const (
 	MaxCachedTables  = 100
 	MaxCachedIndexes = 500
 )
 
+// Storage engines a table can keep its rows in. Schemas record the B-tree
+// engine as an empty Engine.
+const (
+	EngineBTree = "btree"
+	EngineLSM   = "lsm"
+)
+
 type ColumnType string
 
 const (
@@ -22,6 +38,8 @@ const (
 	TypeText    ColumnType = "TEXT"
 	TypeFloat   ColumnType = "FLOAT"
 	TypeBoolean ColumnType = "BOOLEAN"
+	TypeJSON    ColumnType = "JSON"
+	TypeEnum    ColumnType = "ENUM"
 )
 
 type Column struct {
@@ -30,29 +48,104 @@ type Column struct {
 	PrimaryKey bool       `json:"primary_key"`
 	NotNull    bool       `json:"not_null"`
 	Unique     bool       `json:"unique"`
+	EnumValues []string   `json:"enum_values,omitempty"`
 }
 
 type Schema struct {
-	Name     string   `json:"name"`
-	Columns  []Column `json:"columns"`
-	RootPage uint32   `json:"root_page"`
-	Version  int      `json:"version"`
+	Name       string     `json:"name"`
+	Columns    []Column   `json:"columns"`
+	UniqueKeys [][]string `json:"unique_keys,omitempty"`
+	RootPage   uint32     `json:"root_page"`
+	Engine     string     `json:"engine,omitempty"`
+	Version    int        `json:"version"`
+	// ClusterKey names the columns the rows are stored in the order of,
+	// when that is not the primary key; see Clustered.
+	ClusterKey []string `json:"cluster_key,omitempty"`
+	// FillFactor is the fill factor of the table's B+ tree, zero for the
+	// default; see storage.BTree.SetFillFactor.
+	FillFactor int `json:"fill_factor,omitempty"`
+	// BloomFilter keeps a bloom filter on each of the table's indexes; see
+	// bloom.go.
+	BloomFilter bool `json:"bloom_filter,omitempty"`
 }
 
+// IndexMetadata describes an index. Multi-column indexes list their columns
+// in Columns and carry the comma-joined list in ColumnName, which keeps them
+// out of the single-column lookups that match on ColumnName.
 type IndexMetadata struct {
-	Name       string `json:"name"`
-	TableName  string `json:"table_name"`
-	ColumnName string `json:"column_name"`
-	Unique     bool   `json:"unique"`
-	RootPage   uint32 `json:"root_page"`
+	Name       string   `json:"name"`
+	TableName  string   `json:"table_name"`
+	ColumnName string   `json:"column_name"`
+	Columns    []string `json:"columns,omitempty"`
+	Unique     bool     `json:"unique"`
+	RootPage   uint32   `json:"root_page"`
+	FillFactor int      `json:"fill_factor,omitempty"`
+}
+
+func (idx *IndexMetadata) KeyColumns() []string {
+	if len(idx.Columns) > 0 {
+		return idx.Columns
+	}
+	return []string{idx.ColumnName}
 }
 
 type Catalog struct {
 	pager *storage.Pager
 	tree  *storage.BTree
 
-	tableCache *lruCache
-	indexCache *lruCache
+	tableCache *utils.LRUCache[string, *Schema]
+	indexCache *utils.LRUCache[string, *IndexMetadata]
+
+	// tableIndexes caches the indexes of each table, so writes need not scan
+	// the whole catalog per row. Any catalog write clears it.
+	tableIndexes map[string][]*IndexMetadata
+
+	// rowids caches the last rowid handed out per PK-less table; it is seeded
+	// from the largest key in the table on first use.
+	rowids map[string]int64
+
+	// rowCounts caches the number of rows per table so COUNT(*) need not
+	// walk the tree; it is seeded by counting on first use and kept current
+	// by Insert and Delete.
+	rowCounts map[string]int
+
+	// lsmTrees keeps the LSM trees of tables open by root page, since
+	// opening one replays its log. Handles share them, so resetCaches
+	// invalidates each before dropping it.
+	lsmTrees map[uint32]*storage.LSMTree
+
+	// blooms holds the bloom filters of indexes by root page, and
+	// bloomStats what they saved; see bloom.go.
+	blooms     map[uint32]*utils.BloomFilter
+	bloomStats BloomStats
+
+	// random supplies the salts of user passwords.
+	random io.Reader
+
+	// generation mirrors the schema generation stored in the database header.
+	// Every catalog write bumps it; readers compare it against the on-disk
+	// value to detect DDL performed through another handle.
+	generation uint64
+
+	// version counts the schema changes this handle has seen, and
+	// tableVersions holds the version at which each table, its indexes or
+	// its policies last changed. A change whose tables are unknown, such as
+	// one made through another handle, moves every table to staleVersion.
+	version       uint64
+	staleVersion  uint64
+	tableVersions map[string]uint64
+
+	// dataVersions holds the version at which each table's rows were last
+	// written, counted with version.
+	dataVersions map[string]uint64
+
+	// The hooks of changes.go. The changes of the running batch wait in
+	// pendingChanges until it commits.
+	changeHooks    hooks[func([]Change)]
+	updateHooks    hooks[func(Change)]
+	commitHooks    hooks[func() error]
+	rollbackHooks  hooks[func()]
+	pendingChanges []Change
 }
 
 type metadataEntry struct {
@@ -62,9 +155,18 @@ type metadataEntry struct {
 
 func NewCatalog(pager *storage.Pager) (*Catalog, error) {
 	cat := &Catalog{
-		pager:      pager,
-		tableCache: newLRUCache(MaxCachedTables),
-		indexCache: newLRUCache(MaxCachedIndexes),
+		pager:        pager,
+		tableCache:   utils.NewLRUCache[string, *Schema](MaxCachedTables),
+		indexCache:   utils.NewLRUCache[string, *IndexMetadata](MaxCachedIndexes),
+		tableIndexes: make(map[string][]*IndexMetadata),
+		rowids:       make(map[string]int64),
+		rowCounts:    make(map[string]int),
+		lsmTrees:     make(map[uint32]*storage.LSMTree),
+		blooms:       make(map[uint32]*utils.BloomFilter),
+		random:       rand.Reader,
+
+		tableVersions: make(map[string]uint64),
+		dataVersions:  make(map[string]uint64),
 	}
 
 	if pager.GetNumPages() == 0 {
@@ -82,9 +184,185 @@ func NewCatalog(pager *storage.Pager) (*Catalog, error) {
 		return nil, fmt.Errorf("catalog verification failed: %w", err)
 	}
 
+	gen, err := pager.ReadSchemaGeneration()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema generation: %w", err)
+	}
+	cat.generation = gen
+
 	return cat, nil
 }
 
+// Generation returns the current schema generation, first discarding cached
+// metadata if the file was changed through another handle.
+func (c *Catalog) Generation() uint64 {
+	c.refreshIfStale()
+	return c.generation
+}
+
+// refreshIfStale discards cached metadata if the file was written through
+// another handle: rows, whose counts and rowids the catalog remembers, or
+// the schema.
+func (c *Catalog) refreshIfStale() {
+	changed, err := c.pager.Refresh()
+	if err != nil {
+		fmt.Printf("Warning: failed to refresh page cache: %v\n", err)
+	}
+	gen, err := c.pager.ReadSchemaGeneration()
+	if err != nil || (gen == c.generation && !changed) {
+		return
+	}
+
+	c.resetCaches()
+	if gen != c.generation {
+		if err := c.pager.InvalidateCache(); err != nil {
+			fmt.Printf("Warning: failed to invalidate page cache: %v\n", err)
+		}
+	}
+	c.generation = gen
+}
+
+// resetCaches forgets everything the catalog remembers about the file, for
+// when the file changed or a batch's writes were thrown away.
+func (c *Catalog) resetCaches() {
+	c.tableCache.Clear()
+	c.indexCache.Clear()
+	c.tableIndexes = make(map[string][]*IndexMetadata)
+	c.rowids = make(map[string]int64)
+	c.rowCounts = make(map[string]int)
+	for _, tree := range c.lsmTrees {
+		tree.Invalidate()
+	}
+	c.lsmTrees = make(map[uint32]*storage.LSMTree)
+	c.blooms = make(map[uint32]*utils.BloomFilter)
+	c.version++
+	c.staleVersion = c.version
+}
+
+// TableVersion returns a number that changes whenever the schema, indexes
+// or policies of the named table may have, so that what was derived from
+// them, such as a cached plan, can tell when it is out of date. Changes made
+// through another handle count once Generation has noticed them.
+func (c *Catalog) TableVersion(name string) uint64 {
+	if v := c.tableVersions[name]; v > c.staleVersion {
+		return v
+	}
+	return c.staleVersion
+}
+
+// DataVersion returns a number that changes whenever the rows of the named
+// table may have, as well as whenever TableVersion does, so that a cached
+// query result can tell when it is out of date. Rows written through
+// another handle count once a read-only handle's BeginRead has noticed
+// them; a rolled back batch changes every table's.
+func (c *Catalog) DataVersion(name string) uint64 {
+	if v := c.dataVersions[name]; v > c.TableVersion(name) {
+		return v
+	}
+	return c.TableVersion(name)
+}
+
+// Batch runs fn with page writes held in memory, then writes them out with a
+// single sync, or discards all of them if fn or a commit hook fails. A Batch started inside
+// another joins it, leaving the outer one to commit or roll back.
+func (c *Catalog) Batch(fn func() error) error {
+	if c.pager.InBatch() {
+		return fn()
+	}
+
+	if err := c.pager.Begin(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		c.rollback()
+		return err
+	}
+	if err := c.runCommitHooks(); err != nil {
+		c.rollback()
+		return err
+	}
+	changes := c.pendingChanges
+	c.pendingChanges = nil
+	if err := c.pager.Commit(); err != nil {
+		c.resetCaches()
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+	c.publishChanges(changes)
+	return nil
+}
+
+// Statement runs one write statement. In WAL mode it runs in a batch of its
+// own, so that read-only handles see all of the statement or none of it; the
+// batch is not synced and runs no commit or rollback hooks. Otherwise, or
+// inside a batch, it just runs fn.
+func (c *Catalog) Statement(fn func() error) error {
+	if !c.pager.InWAL() || c.pager.InBatch() {
+		return fn()
+	}
+	return c.runAlone(c.pager.BeginStatement, fn)
+}
+
+// atomically runs fn in a batch of its own, unless one is running, so that
+// if it fails nothing it wrote is left behind, such as the pages of a tree
+// that never made it into the catalog. Like Statement it runs no hooks.
+func (c *Catalog) atomically(fn func() error) error {
+	if c.pager.InBatch() {
+		return fn()
+	}
+	return c.runAlone(c.pager.Begin, fn)
+}
+
+func (c *Catalog) runAlone(begin func() error, fn func() error) error {
+	if err := begin(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		c.pager.Rollback()
+		c.pendingChanges = nil
+		c.resetCaches()
+		return err
+	}
+	changes := c.pendingChanges
+	c.pendingChanges = nil
+	if err := c.pager.Commit(); err != nil {
+		c.resetCaches()
+		return fmt.Errorf("failed to commit statement: %w", err)
+	}
+	c.publishChanges(changes)
+	return nil
+}
+
+// BeginRead starts a statement of a read-only handle, see
+// storage.Pager.BeginRead, and returns the function that ends it.
+func (c *Catalog) BeginRead() (func(), error) {
+	changed, err := c.pager.BeginRead()
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		c.resetCaches()
+		c.generation = c.pager.GetHeader().SchemaGeneration
+	}
+	return c.pager.EndRead, nil
+}
+
+func (c *Catalog) schemaChanged(tables ...string) {
+	c.refreshIfStale()
+	c.tableIndexes = make(map[string][]*IndexMetadata)
+	c.blooms = make(map[uint32]*utils.BloomFilter)
+	c.version++
+	for _, table := range tables {
+		c.tableVersions[table] = c.version
+	}
+
+	gen, err := c.pager.BumpSchemaGeneration()
+	if err != nil {
+		fmt.Printf("Warning: failed to bump schema generation: %v\n", err)
+		return
+	}
+	c.generation = gen
+}
+
 func (c *Catalog) initialize() (*Catalog, error) {
 	tree, err := storage.NewBTree(c.pager, false)
 	if err != nil {
@@ -130,7 +408,7 @@ func (c *Catalog) loadTableFromDisk(name string) (*Schema, error) {
 
 	value, err := c.tree.Search(key)
 	if err != nil {
-		return nil, fmt.Errorf("table '%s' not found in catalog", name)
+		return nil, sqlerr.New(sqlerr.UndefinedTable, "table '%s' not found in catalog", name)
 	}
 
 	var meta metadataEntry
@@ -162,7 +440,7 @@ func (c *Catalog) loadIndexFromDisk(name string) (*IndexMetadata, error) {
 
 	value, err := c.tree.Search(key)
 	if err != nil {
-		return nil, fmt.Errorf("index '%s' not found in catalog", name)
+		return nil, sqlerr.New(sqlerr.UndefinedObject, "index '%s' not found in catalog", name)
 	}
 
 	var meta metadataEntry
@@ -189,35 +467,118 @@ func (c *Catalog) loadIndexFromDisk(name string) (*IndexMetadata, error) {
 	return &index, nil
 }
 
-func (c *Catalog) CreateTable(name string, columns []Column) (*Schema, error) {
+// CreateTable creates a table. Each of uniqueKeys is a table-level
+// UNIQUE(...) constraint over several columns.
+func (c *Catalog) CreateTable(name string, columns []Column, uniqueKeys ...[]string) (*Schema, error) {
+	return c.CreateTableWithEngine(name, EngineBTree, columns, uniqueKeys...)
+}
+
+// CreateTableWithEngine creates a table whose rows are kept by the named
+// storage engine, EngineBTree or EngineLSM. Indexes are B-trees either way.
+func (c *Catalog) CreateTableWithEngine(name, engine string, columns []Column, uniqueKeys ...[]string) (*Schema, error) {
+	return c.CreateTableWithOptions(name, TableOptions{Engine: engine}, columns, uniqueKeys...)
+}
+
+// TableOptions are the storage choices made when a table is created, none
+// of which can be changed afterwards.
+type TableOptions struct {
+	// Engine is EngineBTree, the default, or EngineLSM.
+	Engine string
+	// ClusterKey stores the rows in the order of these columns, which
+	// needs a primary key; see Schema.Clustered.
+	ClusterKey []string
+	// FillFactor is the fill factor of a B+ tree table and of the indexes
+	// created with it, zero for storage.DefaultFillFactor.
+	FillFactor int
+	// BloomFilter keeps bloom filters on the table's indexes, so that
+	// lookups of values they do not hold need not read them.
+	BloomFilter bool
+}
+
+// CreateTableWithOptions creates a table stored as opts says.
+func (c *Catalog) CreateTableWithOptions(name string, opts TableOptions, columns []Column, uniqueKeys ...[]string) (*Schema, error) {
+	var schema *Schema
+	err := c.atomically(func() error {
+		var err error
+		schema, err = c.createTable(name, opts, columns, uniqueKeys)
+		return err
+	})
+	return schema, err
+}
+
+func (c *Catalog) createTable(name string, opts TableOptions, columns []Column, uniqueKeys [][]string) (*Schema, error) {
 	if name == "" {
-		return nil, errors.New("table name cannot be empty")
+		return nil, sqlerr.New(sqlerr.InvalidTableDefinition, "table name cannot be empty")
 	}
 	if c.tableExistsUnsafe(name) {
-		return nil, fmt.Errorf("table '%s' already exists", name)
+		return nil, sqlerr.New(sqlerr.DuplicateTable, "table '%s' already exists", name)
+	}
+	if ns, table := SplitTableName(name); ns != "" {
+		if table == "" || strings.Contains(table, ".") {
+			return nil, sqlerr.New(sqlerr.InvalidTableDefinition, "invalid table name '%s'", name)
+		}
+		if !c.NamespaceExists(ns) {
+			return nil, sqlerr.New(sqlerr.UndefinedObject, "schema '%s' does not exist", ns)
+		}
 	}
 	if len(columns) == 0 {
-		return nil, errors.New("table must have at least one column")
+		return nil, sqlerr.New(sqlerr.InvalidTableDefinition, "table must have at least one column")
 	}
 
 	if err := validateColumns(columns); err != nil {
 		return nil, err
 	}
+	if err := validateClusterKey(columns, opts.ClusterKey); err != nil {
+		return nil, err
+	}
+	if err := storage.ValidFillFactor(opts.FillFactor); err != nil {
+		return nil, sqlerr.Wrap(sqlerr.InvalidParameterValue, err)
+	}
 
-	tree, err := storage.NewBTree(c.pager, false)
+	uniqueKeys, err := normalizeUniqueKeys(columns, uniqueKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := strings.ToLower(opts.Engine)
+	if engine == EngineBTree {
+		engine = ""
+	}
+	if engine != "" && opts.FillFactor != 0 {
+		return nil, sqlerr.New(sqlerr.FeatureNotSupported, "fill factor applies only to B+ tree tables")
+	}
+
+	var tree storage.Store
+	switch engine {
+	case "":
+		tree, err = storage.NewBTree(c.pager, false)
+	case EngineLSM:
+		var lsm *storage.LSMTree
+		if lsm, err = storage.NewLSMTree(c.pager); err == nil {
+			c.lsmTrees[lsm.GetRootPage()] = lsm
+			tree = lsm
+		}
+	default:
+		return nil, sqlerr.New(sqlerr.FeatureNotSupported, "unknown storage engine '%s'", engine)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to allocate tree: %w", err)
 	}
 
 	schema := &Schema{
-		Name:     name,
-		Columns:  columns,
-		RootPage: tree.GetRootPage(),
-		Version:  1,
+		Name:       name,
+		Columns:    columns,
+		UniqueKeys: uniqueKeys,
+		RootPage:   tree.GetRootPage(),
+		Engine:     engine,
+		Version:    1,
+		ClusterKey: opts.ClusterKey,
+		FillFactor: opts.FillFactor,
+
+		BloomFilter: opts.BloomFilter,
 	}
 
 	if err := c.saveTable(schema); err != nil {
-		// TODO: Add pages to freelist when implemented
 		return nil, err
 	}
 
@@ -239,11 +600,19 @@ func validateColumns(columns []Column) error {
 
 	for _, col := range columns {
 		if col.Name == "" {
-			return errors.New("column name cannot be empty")
+			return sqlerr.New(sqlerr.InvalidTableDefinition, "column name cannot be empty")
+		}
+
+		if col.Name == RowIDColumn {
+			return sqlerr.New(sqlerr.InvalidTableDefinition, "column name %s is reserved", RowIDColumn)
 		}
 
 		if names[col.Name] {
-			return fmt.Errorf("duplicate column name: %s", col.Name)
+			return sqlerr.New(sqlerr.InvalidTableDefinition, "duplicate column name: %s", col.Name)
+		}
+
+		if err := validateEnum(col); err != nil {
+			return err
 		}
 		names[col.Name] = true
 
@@ -253,12 +622,54 @@ func validateColumns(columns []Column) error {
 	}
 
 	if pkCount > 1 {
-		return errors.New("table can have at most one primary key")
+		return sqlerr.New(sqlerr.InvalidTableDefinition, "table can have at most one primary key")
 	}
 
 	return nil
 }
 
+// normalizeUniqueKeys checks table-level UNIQUE constraints against the
+// column list. A single-column constraint is folded into the column's own
+// UNIQUE flag so it is indexed the same way.
+func normalizeUniqueKeys(columns []Column, uniqueKeys [][]string) ([][]string, error) {
+	var keys [][]string
+	for _, key := range uniqueKeys {
+		if len(key) == 0 {
+			return nil, sqlerr.New(sqlerr.InvalidTableDefinition, "UNIQUE constraint must name at least one column")
+		}
+
+		seen := make(map[string]bool)
+		for _, name := range key {
+			found := false
+			for _, col := range columns {
+				if col.Name == name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, sqlerr.New(sqlerr.UndefinedColumn, "column '%s' in UNIQUE constraint does not exist", name)
+			}
+			if seen[name] {
+				return nil, sqlerr.New(sqlerr.InvalidTableDefinition, "column '%s' appears twice in UNIQUE constraint", name)
+			}
+			seen[name] = true
+		}
+
+		if len(key) == 1 {
+			for i := range columns {
+				if columns[i].Name == key[0] {
+					columns[i].Unique = true
+				}
+			}
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
 func (c *Catalog) saveTable(schema *Schema) error {
 	data, err := json.Marshal(schema)
 	if err != nil {
@@ -281,6 +692,7 @@ func (c *Catalog) saveTable(schema *Schema) error {
 		return fmt.Errorf("failed to insert table into catalog: %w", err)
 	}
 
+	c.schemaChanged(schema.Name)
 	return nil
 }
 
@@ -299,7 +711,14 @@ func (c *Catalog) createAutoIndexes(schema *Schema) error {
 			continue
 		}
 
-		if _, err := c.createIndexUnsafe(indexName, schema.Name, col.Name, unique); err != nil {
+		if _, err := c.createIndexUnsafe(indexName, schema.Name, []string{col.Name}, unique, schema.FillFactor); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range schema.UniqueKeys {
+		indexName := fmt.Sprintf("uq_%s_%s", schema.Name, strings.Join(key, "_"))
+		if _, err := c.createIndexUnsafe(indexName, schema.Name, key, true, schema.FillFactor); err != nil {
 			return err
 		}
 	}
@@ -308,15 +727,31 @@ func (c *Catalog) createAutoIndexes(schema *Schema) error {
 }
 
 func (c *Catalog) CreateIndex(name, tableName, columnName string, unique bool) (*IndexMetadata, error) {
-	return c.createIndexUnsafe(name, tableName, columnName, unique)
+	return c.CreateCompositeIndex(name, tableName, []string{columnName}, unique)
 }
 
-func (c *Catalog) createIndexUnsafe(name, tableName, columnName string, unique bool) (*IndexMetadata, error) {
+func (c *Catalog) CreateCompositeIndex(name, tableName string, columns []string, unique bool) (*IndexMetadata, error) {
+	return c.CreateIndexWithFillFactor(name, tableName, columns, unique, 0)
+}
+
+// CreateIndexWithFillFactor creates an index whose B+ tree has the given
+// fill factor, zero for storage.DefaultFillFactor.
+func (c *Catalog) CreateIndexWithFillFactor(name, tableName string, columns []string, unique bool, fillFactor int) (*IndexMetadata, error) {
+	var index *IndexMetadata
+	err := c.atomically(func() error {
+		var err error
+		index, err = c.createIndexUnsafe(name, tableName, columns, unique, fillFactor)
+		return err
+	})
+	return index, err
+}
+
+func (c *Catalog) createIndexUnsafe(name, tableName string, columns []string, unique bool, fillFactor int) (*IndexMetadata, error) {
 	if name == "" {
-		return nil, errors.New("index name cannot be empty")
+		return nil, sqlerr.New(sqlerr.InvalidTableDefinition, "index name cannot be empty")
 	}
 	if c.indexExistsUnsafe(name) {
-		return nil, fmt.Errorf("index '%s' already exists", name)
+		return nil, sqlerr.New(sqlerr.DuplicateObject, "index '%s' already exists", name)
 	}
 
 	table, err := c.getTableUnsafe(tableName)
@@ -324,31 +759,31 @@ func (c *Catalog) createIndexUnsafe(name, tableName, columnName string, unique b
 		return nil, err
 	}
 
-	column := table.GetColumn(columnName)
-	if column == nil {
-		return nil, fmt.Errorf("column '%s' not found in table '%s'", columnName, tableName)
+	if len(columns) == 0 {
+		return nil, sqlerr.New(sqlerr.InvalidTableDefinition, "index must cover at least one column")
+	}
+	for _, columnName := range columns {
+		if table.GetColumn(columnName) == nil {
+			return nil, sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found in table '%s'", columnName, tableName)
+		}
 	}
 
 	tree, err := storage.NewBTree(c.pager, true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to allocate index tree: %w", err)
 	}
-
-	index := &IndexMetadata{
-		Name:       name,
-		TableName:  tableName,
-		ColumnName: columnName,
-		Unique:     unique,
-		RootPage:   tree.GetRootPage(),
+	if err := tree.SetFillFactor(fillFactor); err != nil {
+		return nil, sqlerr.Wrap(sqlerr.InvalidParameterValue, err)
 	}
 
+	index := newIndexMetadata(name, tableName, columns, unique, tree.GetRootPage())
+	index.FillFactor = fillFactor
+
 	if err := c.populateIndex(index, table, tree); err != nil {
-		// TODO: Add pages to freelist when implemented
 		return nil, fmt.Errorf("failed to populate index: %w", err)
 	}
 
 	if err := c.saveIndex(index); err != nil {
-		// TODO: Add pages to freelist when implemented
 		return nil, err
 	}
 
@@ -356,6 +791,20 @@ func (c *Catalog) createIndexUnsafe(name, tableName, columnName string, unique b
 	return index, nil
 }
 
+func newIndexMetadata(name, tableName string, columns []string, unique bool, rootPage uint32) *IndexMetadata {
+	index := &IndexMetadata{
+		Name:       name,
+		TableName:  tableName,
+		ColumnName: strings.Join(columns, ","),
+		Unique:     unique,
+		RootPage:   rootPage,
+	}
+	if len(columns) > 1 {
+		index.Columns = columns
+	}
+	return index
+}
+
 func (c *Catalog) saveIndex(index *IndexMetadata) error {
 	data, err := json.Marshal(index)
 	if err != nil {
@@ -377,11 +826,12 @@ func (c *Catalog) saveIndex(index *IndexMetadata) error {
 		return fmt.Errorf("failed to insert index into catalog: %w", err)
 	}
 
+	c.schemaChanged(index.TableName)
 	return nil
 }
 
 func (c *Catalog) populateIndex(index *IndexMetadata, table *Schema, indexTree *storage.BTree) error {
-	dataTree, err := storage.LoadBTree(c.pager, table.RootPage, false)
+	dataTree, err := c.openStore(table)
 	if err != nil {
 		return fmt.Errorf("failed to load table tree: %w", err)
 	}
@@ -392,27 +842,25 @@ func (c *Catalog) populateIndex(index *IndexMetadata, table *Schema, indexTree *
 	}
 
 	for _, entry := range entries {
-		row, err := DeserializeRow(entry.Value)
+		row, err := decodeRow(table, entry.Value)
 		if err != nil {
 			return fmt.Errorf("failed to deserialize row: %w", err)
 		}
 
-		colValue, colType, err := ExtractColumnValue(row, index.ColumnName)
+		key, err := indexKey(table, index, row)
 		if err != nil {
-			return fmt.Errorf("failed to extract column value: %w", err)
+			return err
 		}
-
-		indexKey, err := ValueToKey(colValue, colType)
-		if err != nil {
-			return fmt.Errorf("failed to convert value to key: %w", err)
+		if key == nil {
+			continue
 		}
 
 		indexValue := entry.Key.Encode()
 
-		if err := indexTree.Insert(indexKey, indexValue); err != nil {
+		if err := indexTree.Insert(key, indexValue); err != nil {
 			if index.Unique && err.Error() == "duplicate key" {
-				return fmt.Errorf("duplicate value '%s' for unique index on column %s",
-					indexKey.String(), index.ColumnName)
+				return sqlerr.New(sqlerr.UniqueViolation, "duplicate value '%s' for unique index on column %s",
+					key.String(), index.ColumnName)
 			}
 			return fmt.Errorf("failed to insert into index: %w", err)
 		}
@@ -430,8 +878,10 @@ func (c *Catalog) GetTable(name string) (*Schema, error) {
 }
 
 func (c *Catalog) getTableUnsafe(name string) (*Schema, error) {
+	c.refreshIfStale()
+
 	if cached, exists := c.tableCache.Get(name); exists {
-		return cached.(*Schema), nil
+		return cached, nil
 	}
 
 	table, err := c.loadTableFromDisk(name)
@@ -451,26 +901,54 @@ func (c *Catalog) LoadTable(name string) (*Table, error) {
 		return nil, err
 	}
 
-	btree, err := storage.LoadBTree(c.pager, schema.RootPage, false)
+	store, err := c.openStore(schema)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load table B-tree: %w", err)
+		return nil, err
 	}
 
 	return &Table{
-		Catalog: c,
-		schema:  schema,
-		btree:   btree,
+		Catalog:    c,
+		schema:     schema,
+		store:      store,
+		generation: c.generation,
+		indexTrees: make(map[uint32]*storage.BTree),
 	}, nil
 }
 
+// openStore opens the tree holding schema's rows.
+func (c *Catalog) openStore(schema *Schema) (storage.Store, error) {
+	switch schema.Engine {
+	case "":
+		tree, err := storage.LoadBTree(c.pager, schema.RootPage, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load table B-tree: %w", err)
+		}
+		return tree, tree.SetFillFactor(schema.FillFactor)
+	case EngineLSM:
+		if tree, ok := c.lsmTrees[schema.RootPage]; ok {
+			return tree, nil
+		}
+		tree, err := storage.LoadLSMTree(c.pager, schema.RootPage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load table LSM tree: %w", err)
+		}
+		c.lsmTrees[schema.RootPage] = tree
+		return tree, nil
+	default:
+		return nil, sqlerr.New(sqlerr.FeatureNotSupported, "table %s uses unknown storage engine '%s'", schema.Name, schema.Engine)
+	}
+}
+
 func (c *Catalog) GetIndex(name string) (*IndexMetadata, error) {
 
 	return c.getIndexUnsafe(name)
 }
 
 func (c *Catalog) getIndexUnsafe(name string) (*IndexMetadata, error) {
+	c.refreshIfStale()
+
 	if cached, exists := c.indexCache.Get(name); exists {
-		return cached.(*IndexMetadata), nil
+		return cached, nil
 	}
 
 	index, err := c.loadIndexFromDisk(name)
@@ -489,6 +967,8 @@ func (c *Catalog) TableExists(name string) bool {
 }
 
 func (c *Catalog) tableExistsUnsafe(name string) bool {
+	c.refreshIfStale()
+
 	if _, exists := c.tableCache.Get(name); exists {
 		return true
 	}
@@ -503,6 +983,8 @@ func (c *Catalog) IndexExists(name string) bool {
 }
 
 func (c *Catalog) indexExistsUnsafe(name string) bool {
+	c.refreshIfStale()
+
 	if _, exists := c.indexCache.Get(name); exists {
 		return true
 	}
@@ -570,12 +1052,28 @@ func (c *Catalog) ListIndexes() []string {
 	return indexes
 }
 
+// GetTableIndexes returns the indexes on tableName. The slice is shared with
+// the cache and must not be modified.
 func (c *Catalog) GetTableIndexes(tableName string) []*IndexMetadata {
+	c.refreshIfStale()
 
-	entries, err := c.tree.Scan()
+	if indexes, ok := c.tableIndexes[tableName]; ok {
+		return indexes
+	}
+
+	indexes, err := c.loadTableIndexes(tableName)
 	if err != nil {
 		return []*IndexMetadata{}
 	}
+	c.tableIndexes[tableName] = indexes
+	return indexes
+}
+
+func (c *Catalog) loadTableIndexes(tableName string) ([]*IndexMetadata, error) {
+	entries, err := c.tree.Scan()
+	if err != nil {
+		return nil, err
+	}
 
 	result := make([]*IndexMetadata, 0)
 	for _, entry := range entries {
@@ -598,47 +1096,105 @@ func (c *Catalog) GetTableIndexes(tableName string) []*IndexMetadata {
 		}
 	}
 
-	return result
+	return result, nil
 }
 
+// DropTable removes a table and its indexes, putting their pages on the
+// freelist.
 func (c *Catalog) DropTable(name string) error {
-
 	if name == SystemCatalogTable {
 		return errors.New("cannot drop system catalog")
 	}
-	if !c.tableExistsUnsafe(name) {
-		return fmt.Errorf("table '%s' does not exist", name)
-	}
+	return c.atomically(func() error {
+		schema, err := c.getTableUnsafe(name)
+		if err != nil {
+			return err
+		}
 
-	indexes := c.GetTableIndexes(name)
-	for _, idx := range indexes {
-		if err := c.dropIndexUnsafe(idx.Name); err != nil {
-			return fmt.Errorf("failed to drop index '%s': %w", idx.Name, err)
+		indexes := c.GetTableIndexes(name)
+		for _, idx := range indexes {
+			if err := c.dropIndexUnsafe(idx.Name); err != nil {
+				return fmt.Errorf("failed to drop index '%s': %w", idx.Name, err)
+			}
 		}
-	}
 
-	// TODO: Free all pages in the table's B-tree when freelist is implemented
+		store, err := c.openStore(schema)
+		if err != nil {
+			return err
+		}
+		if err := store.Free(); err != nil {
+			return fmt.Errorf("failed to free table pages: %w", err)
+		}
+		delete(c.lsmTrees, schema.RootPage)
 
-	key := stringToKey(name)
-	if err := c.tree.Delete(key); err != nil {
-		return fmt.Errorf("failed to delete table metadata: %w", err)
-	}
+		key := stringToKey(name)
+		if err := c.tree.Delete(key); err != nil {
+			return fmt.Errorf("failed to delete table metadata: %w", err)
+		}
 
-	c.tableCache.Delete(name)
-	return nil
+		c.deleteTableStats(name)
+		c.deleteTablePolicies(name)
+		delete(c.rowids, name)
+		delete(c.rowCounts, name)
+		c.tableCache.Delete(name)
+		c.schemaChanged(name)
+		return nil
+	})
 }
 
 func (c *Catalog) DropIndex(name string) error {
+	return c.atomically(func() error {
+		return c.dropIndexUnsafe(name)
+	})
+}
+
+// RenameIndex gives an index a new name, which no other index or table may
+// have. Its tree and statistics carry over.
+func (c *Catalog) RenameIndex(name, newName string) error {
+	return c.atomically(func() error {
+		index, err := c.getIndexUnsafe(name)
+		if err != nil {
+			return err
+		}
+		if newName == "" {
+			return sqlerr.New(sqlerr.InvalidTableDefinition, "index name cannot be empty")
+		}
+		if c.indexExistsUnsafe(newName) || c.tableExistsUnsafe(newName) {
+			return sqlerr.New(sqlerr.DuplicateObject, "relation '%s' already exists", newName)
+		}
 
-	return c.dropIndexUnsafe(name)
+		if err := c.tree.Delete(stringToKey(name)); err != nil {
+			return fmt.Errorf("failed to delete index metadata: %w", err)
+		}
+		c.indexCache.Delete(name)
+
+		renamed := *index
+		renamed.Name = newName
+		if err := c.saveIndex(&renamed); err != nil {
+			return err
+		}
+		c.indexCache.Put(newName, &renamed)
+		return c.renameIndexStats(renamed.TableName, name, newName)
+	})
 }
 
 func (c *Catalog) dropIndexUnsafe(name string) error {
 	if !c.indexExistsUnsafe(name) {
-		return fmt.Errorf("index '%s' does not exist", name)
+		return sqlerr.New(sqlerr.UndefinedObject, "index '%s' does not exist", name)
+	}
+	index, err := c.getIndexUnsafe(name)
+	if err != nil {
+		return err
 	}
+	table := index.TableName
 
-	// TODO: Free all pages in the index's B-tree when freelist is implemented
+	tree, err := storage.LoadBTree(c.pager, index.RootPage, true)
+	if err != nil {
+		return fmt.Errorf("failed to load index tree: %w", err)
+	}
+	if err := tree.Free(); err != nil {
+		return fmt.Errorf("failed to free index pages: %w", err)
+	}
 
 	key := stringToKey(name)
 	if err := c.tree.Delete(key); err != nil {
@@ -646,6 +1202,10 @@ func (c *Catalog) dropIndexUnsafe(name string) error {
 	}
 
 	c.indexCache.Delete(name)
+	if err := c.renameIndexStats(table, name, ""); err != nil {
+		return err
+	}
+	c.schemaChanged(table)
 	return nil
 }
 
@@ -655,6 +1215,7 @@ func (c *Catalog) deleteTableUnsafe(name string) error {
 		return fmt.Errorf("failed to delete table from catalog: %w", err)
 	}
 	c.tableCache.Delete(name)
+	c.schemaChanged(name)
 	return nil
 }
 
@@ -676,6 +1237,25 @@ func (t *Schema) GetColumnIndex(name string) int {
 	return -1
 }
 
+// StorageEngine names the engine keeping the table's rows.
+func (t *Schema) StorageEngine() string {
+	if t.Engine == "" {
+		return EngineBTree
+	}
+	return t.Engine
+}
+
+// HasRowID reports whether rows are keyed by the implicit _rowid_ column
+// because the table declares no PRIMARY KEY.
+func (t *Schema) HasRowID() bool {
+	for _, col := range t.Columns {
+		if col.PrimaryKey {
+			return false
+		}
+	}
+	return true
+}
+
 func (t *Schema) ColumnCount() int {
 	return len(t.Columns)
 }
@@ -683,6 +1263,10 @@ func (t *Schema) ColumnCount() int {
 func (c *Catalog) Print() {
 	fmt.Println("\n=== Database Catalog ===")
 
+	if namespaces := c.ListNamespaces(); len(namespaces) > 0 {
+		fmt.Printf("\nSchemas (%d): %s\n", len(namespaces), strings.Join(namespaces, ", "))
+	}
+
 	tables := c.ListTables()
 	fmt.Printf("\nTables (%d):\n", len(tables))
 	for _, name := range tables {
@@ -690,7 +1274,11 @@ func (c *Catalog) Print() {
 		if err != nil {
 			continue
 		}
-		fmt.Printf("  %s (page %d, version %d)\n", name, table.RootPage, table.Version)
+		engine := ""
+		if table.Engine != "" {
+			engine = ", engine " + table.Engine
+		}
+		fmt.Printf("  %s (page %d, version %d%s)\n", name, table.RootPage, table.Version, engine)
 		for _, col := range table.Columns {
 			flags := ""
 			if col.PrimaryKey {
@@ -702,7 +1290,14 @@ func (c *Catalog) Print() {
 			if col.NotNull {
 				flags += " NOT NULL"
 			}
-			fmt.Printf("    - %s %s%s\n", col.Name, col.Type, flags)
+			colType := string(col.Type)
+			if col.Type == TypeEnum {
+				colType = fmt.Sprintf("ENUM('%s')", strings.Join(col.EnumValues, "', '"))
+			}
+			fmt.Printf("    - %s %s%s\n", col.Name, colType, flags)
+		}
+		for _, key := range table.UniqueKeys {
+			fmt.Printf("    - UNIQUE (%s)\n", strings.Join(key, ", "))
 		}
 	}
 
@@ -726,6 +1321,20 @@ func (c *Catalog) Print() {
 	fmt.Println()
 }
 
+type CacheStats struct {
+	Tables  utils.CacheStats
+	Indexes utils.CacheStats
+	Pages   utils.CacheStats
+}
+
+func (c *Catalog) CacheStats() CacheStats {
+	return CacheStats{
+		Tables:  c.tableCache.Stats(),
+		Indexes: c.indexCache.Stats(),
+		Pages:   c.pager.CacheStats(),
+	}
+}
+
 func (c *Catalog) LoadIndexTree(indexName string) (*storage.BTree, error) {
 	index, err := c.getIndexUnsafe(indexName)
 
@@ -738,5 +1347,5 @@ func (c *Catalog) LoadIndexTree(indexName string) (*storage.BTree, error) {
 		return nil, fmt.Errorf("failed to load index B-tree: %w", err)
 	}
 
-	return tree, nil
+	return tree, tree.SetFillFactor(index.FillFactor)
 }
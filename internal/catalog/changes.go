@@ -0,0 +1,129 @@
+package catalog
+
+// ChangeOp is the kind of write a Change records.
+type ChangeOp int
+
+const (
+	ChangeInsert ChangeOp = iota
+	ChangeUpdate
+	ChangeDelete
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeInsert:
+		return "INSERT"
+	case ChangeUpdate:
+		return "UPDATE"
+	default:
+		return "DELETE"
+	}
+}
+
+// Change is one row written to a table. Old is nil for an insert and New
+// for a delete. Hooks must not modify the rows.
+type Change struct {
+	Table string
+	Op    ChangeOp
+	Old   *Row
+	New   *Row
+}
+
+// hooks is a list of callbacks that can be removed again. Each is kept by
+// pointer, since funcs cannot be compared.
+type hooks[F any] struct {
+	list []*F
+}
+
+func (h *hooks[F]) add(fn F) (remove func()) {
+	p := &fn
+	h.list = append(h.list, p)
+	return func() {
+		for i, q := range h.list {
+			if q == p {
+				h.list = append(h.list[:i:i], h.list[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// each returns the callbacks registered now, so one may add or remove
+// hooks while they run.
+func (h *hooks[F]) each() []F {
+	fns := make([]F, len(h.list))
+	for i, p := range h.list {
+		fns[i] = *p
+	}
+	return fns
+}
+
+// OnChange registers fn to receive the row changes of every table once they
+// are committed: all the changes of a batch together when it commits, and
+// each change on its own outside a batch. Changes a rolled back batch made
+// are never seen. The returned function unregisters fn.
+func (c *Catalog) OnChange(fn func([]Change)) (remove func()) {
+	return c.changeHooks.add(fn)
+}
+
+// OnUpdate registers fn to receive every row change as it is written, even
+// inside a batch that later rolls back.
+func (c *Catalog) OnUpdate(fn func(Change)) (remove func()) {
+	return c.updateHooks.add(fn)
+}
+
+// OnCommit registers fn to run before a batch commits. If it returns an
+// error the batch rolls back instead and Batch returns the error.
+func (c *Catalog) OnCommit(fn func() error) (remove func()) {
+	return c.commitHooks.add(fn)
+}
+
+// OnRollback registers fn to run after a batch rolls back.
+func (c *Catalog) OnRollback(fn func()) (remove func()) {
+	return c.rollbackHooks.add(fn)
+}
+
+func (c *Catalog) recordChange(change Change) {
+	c.version++
+	c.dataVersions[change.Table] = c.version
+	for _, fn := range c.updateHooks.each() {
+		fn(change)
+	}
+	if len(c.changeHooks.list) == 0 {
+		return
+	}
+	if c.pager.InBatch() {
+		c.pendingChanges = append(c.pendingChanges, change)
+		return
+	}
+	c.publishChanges([]Change{change})
+}
+
+func (c *Catalog) publishChanges(changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+	for _, fn := range c.changeHooks.each() {
+		fn(changes)
+	}
+}
+
+// runCommitHooks returns the first error a commit hook reports.
+func (c *Catalog) runCommitHooks() error {
+	for _, fn := range c.commitHooks.each() {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback discards the running batch and tells the rollback hooks.
+func (c *Catalog) rollback() {
+	c.pager.Rollback()
+	c.pendingChanges = nil
+	c.resetCaches()
+	for _, fn := range c.rollbackHooks.each() {
+		fn()
+	}
+}
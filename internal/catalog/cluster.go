@@ -0,0 +1,169 @@
+package catalog
+
+import (
+	"fmt"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// A clustered table stores its rows in the order of a key of its own
+// choosing, its ClusterKey, instead of its primary key. Rows are keyed by a
+// composite key of the cluster key columns followed by the primary key,
+// which keeps every key unique. Rows sharing leading cluster key values are
+// then adjacent in the tree, and a range on them is read without a
+// secondary index. The primary key index, which a table keyed by its
+// primary key leaves empty, is kept up to date so that lookups by primary
+// key still find their row.
+
+// Clustered reports whether the table's rows are stored in the order of a
+// cluster key rather than of the primary key.
+func (t *Schema) Clustered() bool {
+	return len(t.ClusterKey) > 0
+}
+
+func validateClusterKey(columns []Column, key []string) error {
+	if len(key) == 0 {
+		return nil
+	}
+
+	hasPK := false
+	for _, col := range columns {
+		hasPK = hasPK || col.PrimaryKey
+	}
+	if !hasPK {
+		return sqlerr.New(sqlerr.InvalidTableDefinition, "a clustered table needs a PRIMARY KEY")
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range key {
+		var col *Column
+		for i := range columns {
+			if columns[i].Name == name {
+				col = &columns[i]
+			}
+		}
+		if col == nil {
+			return sqlerr.New(sqlerr.UndefinedColumn, "column '%s' in CLUSTER BY does not exist", name)
+		}
+		if seen[name] {
+			return sqlerr.New(sqlerr.InvalidTableDefinition, "column '%s' appears twice in CLUSTER BY", name)
+		}
+		seen[name] = true
+
+		switch col.Type {
+		case TypeInt, TypeFloat, TypeText, TypeBoolean:
+		default:
+			return sqlerr.New(sqlerr.FeatureNotSupported, "cannot cluster by %s column '%s'", col.Type, name)
+		}
+	}
+	return nil
+}
+
+// clusterRowKey is the key a row of a clustered table is stored under. A
+// NULL is keyed by an empty composite key, which sorts after every value.
+func clusterRowKey(row *Row, schema *Schema) (storage.Key, error) {
+	var keys []storage.Key
+	for _, name := range schema.ClusterKey {
+		col := schema.GetColumn(name)
+		if col == nil {
+			return nil, fmt.Errorf("cluster key column '%s' not found", name)
+		}
+		key, err := clusterValueKey(row.Values[name].Value, col.Type)
+		if err != nil {
+			return nil, fmt.Errorf("cluster key column '%s': %w", name, err)
+		}
+		keys = append(keys, key)
+	}
+
+	for _, col := range schema.Columns {
+		if col.PrimaryKey {
+			key, err := ValueToKey(row.Values[col.Name].Value, col.Type)
+			if err != nil {
+				return nil, fmt.Errorf("primary key column '%s': %w", col.Name, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	return storage.NewCompositeKey(keys...), nil
+}
+
+// clusterValueKey is the key of value in a cluster key column.
+func clusterValueKey(value interface{}, colType ColumnType) (storage.Key, error) {
+	if value == nil {
+		return storage.NewCompositeKey(), nil
+	}
+	return ValueToKey(value, colType)
+}
+
+// ClusterRangeEach calls fn with each row of a clustered table whose
+// leading cluster key columns equal prefix and, if op is not empty, whose
+// next cluster key column compares to value by op, one of =, <, <=, > and
+// >=, in cluster key order. Only the tree entries in that range are read.
+func (t *Table) ClusterRangeEach(prefix []interface{}, op string, value interface{}, fn func(row *Row) error) error {
+	if err := t.refreshSchema(); err != nil {
+		return err
+	}
+	key := t.schema.ClusterKey
+	if len(prefix) > len(key) || (op != "" && len(prefix) == len(key)) {
+		return fmt.Errorf("too many values for the cluster key of table %s", t.schema.Name)
+	}
+
+	var keys []storage.Key
+	for i, v := range prefix {
+		k, err := clusterValueKey(v, t.schema.GetColumn(key[i]).Type)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, k)
+	}
+	lower, upper := storage.PrefixBounds(keys...)
+
+	if op != "" {
+		k, err := clusterValueKey(value, t.schema.GetColumn(key[len(prefix)]).Type)
+		if err != nil {
+			return err
+		}
+		bound, boundEnd := storage.PrefixBounds(append(keys, k)...)
+		switch op {
+		case "=":
+			lower, upper = bound, boundEnd
+		case ">":
+			lower = boundEnd
+		case ">=":
+			lower = bound
+		case "<":
+			upper = bound
+		case "<=":
+			upper = boundEnd
+		default:
+			return fmt.Errorf("unsupported cluster range operator: %s", op)
+		}
+	}
+
+	it, err := t.store.EntriesFrom(lower)
+	if err != nil {
+		return fmt.Errorf("failed to scan table %s: %w", t.schema.Name, err)
+	}
+
+	for it.HasNext() {
+		k, data, err := it.Next()
+		if err != nil {
+			return fmt.Errorf("failed to scan table %s: %w", t.schema.Name, err)
+		}
+		if k.Compare(upper) >= 0 {
+			return nil
+		}
+		row, err := decodeRow(t.schema, data)
+		if err != nil {
+			return fmt.Errorf("failed to deserialize row in table %s: %w", t.schema.Name, err)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("failed to scan table %s: %w", t.schema.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// ParseColumnType maps a SQL type name to a ColumnType. ok is false for
+// names that are not recognised.
+func ParseColumnType(typeStr string) (ColumnType, bool) {
+	switch strings.ToUpper(typeStr) {
+	case "INT", "INTEGER":
+		return TypeInt, true
+	case "TEXT", "VARCHAR", "STRING", "CHAR":
+		return TypeText, true
+	case "FLOAT", "REAL", "DOUBLE":
+		return TypeFloat, true
+	case "BOOLEAN", "BOOL":
+		return TypeBoolean, true
+	case "JSON":
+		return TypeJSON, true
+	default:
+		return "", false
+	}
+}
+
+// CoerceValue applies the implicit conversions used when a value is stored in
+// or compared against a column of type target: INT and FLOAT convert into
+// each other when no precision is lost, and text converts to INT, FLOAT,
+// BOOLEAN or JSON when it parses as one. NULL stays NULL.
+func CoerceValue(value interface{}, target ColumnType) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch target {
+	case TypeInt:
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case int:
+			return int64(v), nil
+		case float64:
+			if v != math.Trunc(v) {
+				return nil, sqlerr.New(sqlerr.InvalidTextRepresentation, "invalid integer: %v has a fractional part", v)
+			}
+			return int64(v), nil
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i, nil
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f != math.Trunc(f) {
+				return nil, sqlerr.New(sqlerr.InvalidTextRepresentation, "invalid integer: %q", v)
+			}
+			return int64(f), nil
+		}
+
+	case TypeFloat:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		case int:
+			return float64(v), nil
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, sqlerr.New(sqlerr.InvalidTextRepresentation, "invalid float: %q", v)
+			}
+			return f, nil
+		}
+
+	case TypeBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return parseBoolText(v)
+		}
+
+	case TypeText, TypeEnum:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+
+	case TypeJSON:
+		if s, ok := value.(string); ok {
+			if !json.Valid([]byte(s)) {
+				return nil, sqlerr.New(sqlerr.InvalidTextRepresentation, "invalid JSON: %s", s)
+			}
+			return s, nil
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported column type: %s", target)
+	}
+
+	return nil, sqlerr.New(sqlerr.DatatypeMismatch, "cannot convert %T to %s without CAST", value, target)
+}
+
+// CastValue performs an explicit CAST. On top of the implicit rules it
+// renders any value as TEXT, truncates FLOAT to INT, and maps BOOLEAN to and
+// from 1 and 0.
+func CastValue(value interface{}, target ColumnType) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch target {
+	case TypeText:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		default:
+			return fmt.Sprintf("%v", v), nil
+		}
+
+	case TypeInt:
+		switch v := value.(type) {
+		case float64:
+			return int64(v), nil
+		case bool:
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return int64(f), nil
+			}
+		}
+
+	case TypeFloat:
+		if b, ok := value.(bool); ok {
+			if b {
+				return 1.0, nil
+			}
+			return 0.0, nil
+		}
+		if s, ok := value.(string); ok {
+			value = strings.TrimSpace(s)
+		}
+
+	case TypeJSON:
+		switch v := value.(type) {
+		case int64, int, float64, bool:
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			return string(data), nil
+		}
+
+	case TypeBoolean:
+		switch v := value.(type) {
+		case int64:
+			return v != 0, nil
+		case int:
+			return v != 0, nil
+		case float64:
+			return v != 0, nil
+		}
+	}
+
+	result, err := CoerceValue(value, target)
+	if err != nil {
+		return nil, sqlerr.New(sqlerr.InvalidTextRepresentation, "cannot cast %v to %s", value, target)
+	}
+	return result, nil
+}
+
+func parseBoolText(value string) (bool, error) {
+	switch strings.ToUpper(value) {
+	case "TRUE", "1", "T", "YES", "Y":
+		return true, nil
+	case "FALSE", "0", "F", "NO", "N":
+		return false, nil
+	default:
+		return false, sqlerr.New(sqlerr.InvalidTextRepresentation, "invalid boolean: %s", value)
+	}
+}
@@ -0,0 +1,221 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// checkConstraints is the single gate every row passes before it is written.
+// self is the key of the row being replaced on update, or nil on insert.
+func (t *Table) checkConstraints(row *Row, self storage.Key) error {
+	if err := ValidateRow(row, t.schema); err != nil {
+		return err
+	}
+	return t.checkUnique(row, self)
+}
+
+func ValidateRow(row *Row, schema *Schema) error {
+	for _, col := range schema.Columns {
+		rowValue, exists := row.Values[col.Name]
+		if !exists || rowValue.Value == nil {
+			if col.NotNull || col.PrimaryKey {
+				return sqlerr.New(sqlerr.NotNullViolation, "column '%s' cannot be NULL", col.Name)
+			}
+			continue
+		}
+
+		if rowValue.Type != col.Type || !valueMatchesType(rowValue.Value, col.Type) {
+			return sqlerr.New(sqlerr.DatatypeMismatch, "column '%s' type mismatch: expected %s, got %T",
+				col.Name, col.Type, rowValue.Value)
+		}
+
+		if col.Type == TypeEnum {
+			if err := col.checkEnum(rowValue.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// valueMatchesType accepts float64 for integral INT values because rows read
+// back from disk come out of encoding/json.
+func valueMatchesType(value interface{}, colType ColumnType) bool {
+	switch colType {
+	case TypeInt:
+		switch v := value.(type) {
+		case int, int64:
+			return true
+		case float64:
+			return v == math.Trunc(v)
+		}
+	case TypeFloat:
+		switch value.(type) {
+		case float64, int, int64:
+			return true
+		}
+	case TypeText, TypeEnum:
+		_, ok := value.(string)
+		return ok
+	case TypeBoolean:
+		_, ok := value.(bool)
+		return ok
+	case TypeJSON:
+		s, ok := value.(string)
+		return ok && json.Valid([]byte(s))
+	}
+	return false
+}
+
+// uniqueKeys returns the column sets that must hold distinct values, whether
+// they were declared UNIQUE on a column, as a table-level UNIQUE(...) or by a
+// CREATE UNIQUE INDEX. The primary key is left out; the table tree already
+// rejects duplicate keys.
+func (t *Table) uniqueKeys() [][]string {
+	var keys [][]string
+	seen := make(map[string]bool)
+
+	add := func(columns []string) {
+		if len(columns) == 1 {
+			if col := t.schema.GetColumn(columns[0]); col == nil || col.PrimaryKey {
+				return
+			}
+		}
+		name := strings.Join(columns, ",")
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		keys = append(keys, columns)
+	}
+
+	for _, col := range t.schema.Columns {
+		if col.Unique {
+			add([]string{col.Name})
+		}
+	}
+	for _, columns := range t.schema.UniqueKeys {
+		add(columns)
+	}
+	for _, idx := range t.Catalog.GetTableIndexes(t.schema.Name) {
+		if idx.Unique {
+			add(idx.KeyColumns())
+		}
+	}
+
+	return keys
+}
+
+// checkUnique looks each unique value up through an index on the same columns
+// when one exists and falls back to scanning the table otherwise, so dropping
+// the auto-created index does not silently drop the constraint. A key with a
+// NULL in any column never conflicts.
+func (t *Table) checkUnique(row *Row, self storage.Key) error {
+	keys := t.uniqueKeys()
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var selfEncoded []byte
+	if self != nil {
+		selfEncoded = self.Encode()
+	}
+
+	type pending struct {
+		columns []string
+		key     storage.Key
+	}
+	var unindexed []pending
+
+	for _, columns := range keys {
+		key, err := columnsKey(t.schema, columns, row)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			continue
+		}
+
+		idxMeta := t.indexOnColumns(columns)
+		if idxMeta == nil {
+			unindexed = append(unindexed, pending{columns: columns, key: key})
+			continue
+		}
+
+		if ok, err := t.indexMayContain(idxMeta, key); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		idxTree, err := t.getIndexTree(idxMeta)
+		if err != nil {
+			return err
+		}
+
+		existing, err := idxTree.Search(key)
+		if err != nil {
+			continue
+		}
+		if selfEncoded != nil && bytes.Equal(existing, selfEncoded) {
+			continue
+		}
+		return uniqueViolation(columns, row)
+	}
+
+	if len(unindexed) == 0 {
+		return nil
+	}
+
+	entries, err := t.store.Scan()
+	if err != nil {
+		return fmt.Errorf("failed to scan table %s: %w", t.schema.Name, err)
+	}
+
+	for _, entry := range entries {
+		if self != nil && entry.Key.Compare(self) == 0 {
+			continue
+		}
+
+		existing, err := decodeRow(t.schema, entry.Value)
+		if err != nil {
+			return fmt.Errorf("failed to deserialize row: %w", err)
+		}
+
+		for _, p := range unindexed {
+			other, err := columnsKey(t.schema, p.columns, existing)
+			if err != nil || other == nil {
+				continue
+			}
+			if p.key.Compare(other) == 0 {
+				return uniqueViolation(p.columns, row)
+			}
+		}
+	}
+
+	return nil
+}
+
+// indexOnColumns returns the unique index on exactly columns, if any. Only
+// its keys are the column values alone, to be searched for.
+func (t *Table) indexOnColumns(columns []string) *IndexMetadata {
+	for _, idx := range t.Catalog.GetTableIndexes(t.schema.Name) {
+		if idx.Unique && slices.Equal(idx.KeyColumns(), columns) {
+			return idx
+		}
+	}
+	return nil
+}
+
+func uniqueViolation(columns []string, row *Row) error {
+	return sqlerr.New(sqlerr.UniqueViolation, "unique constraint violation on column %s: value '%s' already exists",
+		strings.Join(columns, ", "), columnValues(columns, row))
+}
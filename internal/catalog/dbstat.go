@@ -0,0 +1,107 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+// ObjectStats is the storage footprint of one table or index.
+type ObjectStats struct {
+	Name  string
+	Table string
+	Kind  string // "table" or "index"
+	storage.TreeStats
+}
+
+// treeRef names one table or index recorded in the catalog.
+type treeRef struct {
+	name   string
+	table  string
+	kind   string
+	root   uint32
+	engine string
+}
+
+func (r treeRef) load(pager *storage.Pager) (storage.Store, error) {
+	var tree storage.Store
+	var err error
+	if r.engine == EngineLSM {
+		tree, err = storage.LoadLSMTree(pager, r.root)
+	} else {
+		tree, err = storage.LoadBTree(pager, r.root, r.kind == "index")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s %s: %w", r.kind, r.name, err)
+	}
+	return tree, nil
+}
+
+// trees lists every table and index, the system catalog included, ordered by
+// table and then name with each table before its indexes.
+func (c *Catalog) trees() ([]treeRef, error) {
+	c.refreshIfStale()
+
+	entries, err := c.tree.Scan()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan catalog: %w", err)
+	}
+
+	var refs []treeRef
+	for _, entry := range entries {
+		var meta metadataEntry
+		if err := json.Unmarshal(entry.Value, &meta); err != nil {
+			continue
+		}
+
+		switch meta.Type {
+		case "table":
+			var table Schema
+			if err := json.Unmarshal(meta.Data, &table); err != nil {
+				continue
+			}
+			refs = append(refs, treeRef{name: table.Name, table: table.Name, kind: "table", root: table.RootPage, engine: table.Engine})
+		case "index":
+			var index IndexMetadata
+			if err := json.Unmarshal(meta.Data, &index); err != nil {
+				continue
+			}
+			refs = append(refs, treeRef{name: index.Name, table: index.TableName, kind: "index", root: index.RootPage})
+		}
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].table != refs[j].table {
+			return refs[i].table < refs[j].table
+		}
+		if refs[i].kind != refs[j].kind {
+			return refs[i].kind == "table"
+		}
+		return refs[i].name < refs[j].name
+	})
+	return refs, nil
+}
+
+// StorageStats walks the tree of every table and index.
+func (c *Catalog) StorageStats() ([]ObjectStats, error) {
+	refs, err := c.trees()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ObjectStats, 0, len(refs))
+	for _, ref := range refs {
+		tree, err := ref.load(c.pager)
+		if err != nil {
+			return nil, err
+		}
+		stats, err := tree.Stats()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s %s: %w", ref.kind, ref.name, err)
+		}
+		result = append(result, ObjectStats{Name: ref.name, Table: ref.table, Kind: ref.kind, TreeStats: stats})
+	}
+	return result, nil
+}
@@ -0,0 +1,112 @@
+package catalog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// ENUM columns hold one of a fixed list of strings. The list lives in the
+// schema and rows store the value's ordinal, so on disk each value costs a
+// small integer. Rows are translated at the serialization boundary; every
+// Row handed out by a Table carries the string form.
+
+func (c *Column) enumOrdinal(value string) (int64, bool) {
+	for i, allowed := range c.EnumValues {
+		if allowed == value {
+			return int64(i), true
+		}
+	}
+	return 0, false
+}
+
+func (c *Column) checkEnum(value interface{}) error {
+	s, ok := value.(string)
+	if ok {
+		if _, ok := c.enumOrdinal(s); ok {
+			return nil
+		}
+	}
+	return sqlerr.New(sqlerr.CheckViolation, "value '%v' is not allowed for column '%s' (expected one of: %s)",
+		value, c.Name, strings.Join(c.EnumValues, ", "))
+}
+
+func validateEnum(col Column) error {
+	if col.Type != TypeEnum {
+		if len(col.EnumValues) > 0 {
+			return sqlerr.New(sqlerr.InvalidTableDefinition, "column '%s' has enum values but type %s", col.Name, col.Type)
+		}
+		return nil
+	}
+
+	if len(col.EnumValues) == 0 {
+		return sqlerr.New(sqlerr.InvalidTableDefinition, "ENUM column '%s' needs at least one value", col.Name)
+	}
+	seen := make(map[string]bool)
+	for _, v := range col.EnumValues {
+		if seen[v] {
+			return sqlerr.New(sqlerr.InvalidTableDefinition, "ENUM column '%s' lists '%s' twice", col.Name, v)
+		}
+		seen[v] = true
+	}
+	return nil
+}
+
+func encodeRow(schema *Schema, row *Row) ([]byte, error) {
+	if !schema.hasEnums() {
+		return SerializeRow(row)
+	}
+
+	encoded := &Row{Values: make(map[string]RowValue, len(row.Values))}
+	for name, rv := range row.Values {
+		encoded.Values[name] = rv
+	}
+
+	for i := range schema.Columns {
+		col := &schema.Columns[i]
+		rv, exists := encoded.Values[col.Name]
+		if col.Type != TypeEnum || !exists || rv.Value == nil {
+			continue
+		}
+		s, _ := rv.Value.(string)
+		ordinal, ok := col.enumOrdinal(s)
+		if !ok {
+			return nil, col.checkEnum(rv.Value)
+		}
+		encoded.Values[col.Name] = RowValue{Type: TypeEnum, Value: ordinal}
+	}
+
+	return SerializeRow(encoded)
+}
+
+func decodeRow(schema *Schema, data []byte) (*Row, error) {
+	row, err := DeserializeRow(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range schema.Columns {
+		col := &schema.Columns[i]
+		rv, exists := row.Values[col.Name]
+		if col.Type != TypeEnum || !exists || rv.Value == nil {
+			continue
+		}
+		ordinal, ok := rv.Value.(float64)
+		if !ok || ordinal < 0 || int(ordinal) >= len(col.EnumValues) {
+			return nil, fmt.Errorf("invalid stored value %v for ENUM column '%s'", rv.Value, col.Name)
+		}
+		row.Values[col.Name] = RowValue{Type: TypeEnum, Value: col.EnumValues[int(ordinal)]}
+	}
+
+	return row, nil
+}
+
+func (s *Schema) hasEnums() bool {
+	for _, col := range s.Columns {
+		if col.Type == TypeEnum {
+			return true
+		}
+	}
+	return false
+}
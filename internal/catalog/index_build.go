@@ -0,0 +1,245 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// IndexBuild builds an index a few rows at a time, so that other
+// statements can write the table between steps. The index is not in the
+// catalog until Finish, so those writes do not maintain it; the committed
+// changes to rows it has already indexed are logged instead, and Finish
+// applies them.
+type IndexBuild struct {
+	c      *Catalog
+	index  *IndexMetadata
+	schema *Schema
+	tree   *storage.BTree
+
+	// cursor is the primary key of the last row indexed, nil before any
+	// is. Changes to rows after it need no logging until every row has been
+	// read: a later step reads them as they are then.
+	cursor storage.Key
+	done   bool
+	log    []Change
+
+	stopLogging func()
+}
+
+// BeginIndexBuild checks a new index like CreateIndexWithFillFactor and
+// allocates its tree, leaving the rows to Step.
+func (c *Catalog) BeginIndexBuild(name, tableName string, columns []string, unique bool, fillFactor int) (*IndexBuild, error) {
+	b := &IndexBuild{c: c}
+	err := c.atomically(func() error {
+		if name == "" {
+			return sqlerr.New(sqlerr.InvalidTableDefinition, "index name cannot be empty")
+		}
+		if c.indexExistsUnsafe(name) {
+			return sqlerr.New(sqlerr.DuplicateObject, "index '%s' already exists", name)
+		}
+
+		schema, err := c.getTableUnsafe(tableName)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			return sqlerr.New(sqlerr.InvalidTableDefinition, "index must cover at least one column")
+		}
+		for _, columnName := range columns {
+			if schema.GetColumn(columnName) == nil {
+				return sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found in table '%s'", columnName, tableName)
+			}
+		}
+
+		tree, err := storage.NewBTree(c.pager, true)
+		if err != nil {
+			return fmt.Errorf("failed to allocate index tree: %w", err)
+		}
+		if err := tree.SetFillFactor(fillFactor); err != nil {
+			return sqlerr.Wrap(sqlerr.InvalidParameterValue, err)
+		}
+
+		b.schema, b.tree = schema, tree
+		b.index = newIndexMetadata(name, tableName, columns, unique, tree.GetRootPage())
+		b.index.FillFactor = fillFactor
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.stopLogging = c.OnChange(b.logChanges)
+	return b, nil
+}
+
+func (b *IndexBuild) logChanges(changes []Change) {
+	for _, change := range changes {
+		if change.Table != b.schema.Name {
+			continue
+		}
+		if b.done {
+			b.log = append(b.log, change)
+			continue
+		}
+		if b.cursor == nil {
+			continue
+		}
+		// A row of a clustered table can move across the cursor, so both
+		// where it was and where it is now matter.
+		for _, row := range []*Row{change.Old, change.New} {
+			if row == nil {
+				continue
+			}
+			pk, err := GetPrimaryKeyValue(row, b.schema)
+			if err != nil || pk.Compare(b.cursor) <= 0 {
+				b.log = append(b.log, change)
+				break
+			}
+		}
+	}
+}
+
+// Step indexes up to n more rows and reports whether every row has been
+// read.
+func (b *IndexBuild) Step(n int) (done bool, err error) {
+	if b.done {
+		return true, nil
+	}
+
+	err = b.c.atomically(func() error {
+		store, err := b.c.openStore(b.schema)
+		if err != nil {
+			return fmt.Errorf("failed to load table tree: %w", err)
+		}
+		var it storage.EntryIterator
+		if b.cursor == nil {
+			it, err = store.Entries(false)
+		} else {
+			it, err = store.EntriesFrom(b.cursor)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to scan table: %w", err)
+		}
+
+		cursor := b.cursor
+		for read := 0; read < n; {
+			if !it.HasNext() {
+				if err := it.Err(); err != nil {
+					return fmt.Errorf("failed to scan table: %w", err)
+				}
+				done = true
+				break
+			}
+			key, value, err := it.Next()
+			if err != nil {
+				return fmt.Errorf("failed to scan table: %w", err)
+			}
+			if cursor != nil && key.Compare(cursor) <= 0 {
+				continue
+			}
+
+			row, err := decodeRow(b.schema, value)
+			if err != nil {
+				return fmt.Errorf("failed to deserialize row: %w", err)
+			}
+			if err := b.insert(row, key); err != nil {
+				return err
+			}
+			cursor = key
+			read++
+		}
+		b.cursor = cursor
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	b.done = done
+	return done, nil
+}
+
+func (b *IndexBuild) insert(row *Row, pk storage.Key) error {
+	key, err := indexKey(b.schema, b.index, row)
+	if err != nil || key == nil {
+		return err
+	}
+	if err := b.tree.Insert(key, pk.Encode()); err != nil {
+		if errors.Is(err, storage.ErrDuplicateKey) {
+			return sqlerr.New(sqlerr.UniqueViolation, "duplicate value '%s' for unique index on column %s",
+				key.String(), b.index.ColumnName)
+		}
+		return fmt.Errorf("failed to insert into index: %w", err)
+	}
+	return nil
+}
+
+// Finish applies the logged changes and adds the index to the catalog. It
+// fails if Step has not read every row.
+func (b *IndexBuild) Finish() error {
+	if !b.done {
+		return fmt.Errorf("index %s is still being built", b.index.Name)
+	}
+	b.stop()
+
+	return b.c.atomically(func() error {
+		for _, change := range b.log {
+			if change.Old != nil {
+				key, err := indexKey(b.schema, b.index, change.Old)
+				if err != nil {
+					return err
+				}
+				if key != nil {
+					if err := b.tree.Delete(key); err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+						return fmt.Errorf("failed to update index: %w", err)
+					}
+				}
+			}
+			if change.New != nil {
+				pk, err := GetPrimaryKeyValue(change.New, b.schema)
+				if err != nil {
+					return err
+				}
+				if err := b.insert(change.New, pk); err != nil {
+					return err
+				}
+			}
+		}
+		b.log = nil
+
+		if b.c.indexExistsUnsafe(b.index.Name) {
+			return sqlerr.New(sqlerr.DuplicateObject, "index '%s' already exists", b.index.Name)
+		}
+		if err := b.c.saveIndex(b.index); err != nil {
+			return err
+		}
+		b.c.indexCache.Put(b.index.Name, b.index)
+		return nil
+	})
+}
+
+// Abort abandons a build that has not finished, or whose Finish failed:
+// it stops logging changes and puts the pages of the index's tree back on
+// the freelist, all of them or, if that fails, none.
+func (b *IndexBuild) Abort() error {
+	b.stop()
+	tree := b.tree
+	if tree == nil {
+		return nil
+	}
+	b.tree = nil
+	if err := b.c.atomically(tree.Free); err != nil {
+		return fmt.Errorf("failed to free index pages: %w", err)
+	}
+	return nil
+}
+
+// stop stops logging changes.
+func (b *IndexBuild) stop() {
+	if b.stopLogging != nil {
+		b.stopLogging()
+		b.stopLogging = nil
+	}
+}
@@ -0,0 +1,31 @@
+package catalog
+
+import (
+	"fmt"
+
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// CheckIntegrity validates the tree of every table and index, then the
+// freelist, and returns the first problem found, naming the object it
+// belongs to.
+func (c *Catalog) CheckIntegrity() error {
+	refs, err := c.trees()
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		tree, err := ref.load(c.pager)
+		if err != nil {
+			return sqlerr.Wrap(sqlerr.DataCorrupted, err)
+		}
+		if err := tree.Validate(); err != nil {
+			return sqlerr.Wrap(sqlerr.DataCorrupted, fmt.Errorf("%s %s: %w", ref.kind, ref.name, err))
+		}
+	}
+	if err := c.pager.ValidateFreelist(); err != nil {
+		return sqlerr.Wrap(sqlerr.DataCorrupted, fmt.Errorf("freelist: %w", err))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// Job is a statement run on a cron schedule by a session manager's
+// scheduler.
+type Job struct {
+	Name      string `json:"name"`
+	Schedule  string `json:"schedule"`
+	Statement string `json:"statement"`
+}
+
+func jobKey(name string) storage.Key {
+	return stringToKey("job:" + name)
+}
+
+func (c *Catalog) CreateJob(job *Job) error {
+	if job.Name == "" {
+		return sqlerr.New(sqlerr.InvalidTableDefinition, "job name cannot be empty")
+	}
+
+	key := jobKey(job.Name)
+	if _, err := c.tree.Search(key); err == nil {
+		return sqlerr.New(sqlerr.DuplicateObject, "job '%s' already exists", job.Name)
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	metaBytes, err := json.Marshal(metadataEntry{
+		Type: "job",
+		Data: data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	if err := c.tree.Insert(key, metaBytes); err != nil {
+		return fmt.Errorf("failed to insert job into catalog: %w", err)
+	}
+	return nil
+}
+
+func (c *Catalog) DropJob(name string) error {
+	key := jobKey(name)
+	if _, err := c.tree.Search(key); err != nil {
+		return sqlerr.New(sqlerr.UndefinedObject, "job '%s' does not exist", name)
+	}
+
+	if err := c.tree.Delete(key); err != nil {
+		return fmt.Errorf("failed to delete job: %w", err)
+	}
+	return nil
+}
+
+// ListJobs returns every job, ordered by name.
+func (c *Catalog) ListJobs() []*Job {
+	entries, err := c.tree.Scan()
+	if err != nil {
+		return []*Job{}
+	}
+
+	jobs := make([]*Job, 0)
+	for _, entry := range entries {
+		var meta metadataEntry
+		if err := json.Unmarshal(entry.Value, &meta); err != nil {
+			continue
+		}
+
+		if meta.Type != "job" {
+			continue
+		}
+
+		var job Job
+		if err := json.Unmarshal(meta.Data, &job); err != nil {
+			continue
+		}
+		jobs = append(jobs, &job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs
+}
@@ -0,0 +1,173 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+type loadedRow struct {
+	key  storage.Key
+	data []byte
+	row  *Row
+}
+
+// BulkLoad inserts rows into an empty table by sorting them by primary key
+// and building the table's B-tree, then each index, bottom-up from the
+// sorted entries. A table that already holds rows gets BatchInsert instead.
+// Either way the load is a single batch, so one bad row rejects all of them.
+func (t *Table) BulkLoad(rows [][]interface{}) error {
+	count, err := t.Count()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return t.BatchInsert(rows)
+	}
+
+	return t.Catalog.Batch(func() error {
+		loaded, err := t.prepareLoad(rows)
+		if err != nil {
+			return err
+		}
+
+		entries := make([]storage.Entry, len(loaded))
+		for i, r := range loaded {
+			entries[i] = storage.Entry{Key: r.key, Value: r.data}
+		}
+		if err := t.store.BulkLoad(entries); err != nil {
+			return fmt.Errorf("failed to load table %s: %w", t.schema.Name, err)
+		}
+
+		for _, idxMeta := range t.Catalog.GetTableIndexes(t.schema.Name) {
+			if t.unusedIndex(idxMeta) {
+				continue
+			}
+			if err := t.loadIndex(idxMeta, loaded); err != nil {
+				return err
+			}
+		}
+
+		t.Catalog.rowCounts[t.schema.Name] = len(loaded)
+		for _, r := range loaded {
+			t.Catalog.recordChange(Change{Table: t.schema.Name, Op: ChangeInsert, New: r.row})
+		}
+		return nil
+	})
+}
+
+// prepareLoad validates and encodes rows and sorts them by primary key.
+func (t *Table) prepareLoad(rows [][]interface{}) ([]loadedRow, error) {
+	loaded := make([]loadedRow, 0, len(rows))
+	for i, values := range rows {
+		row, err := CreateRow(t.schema, values)
+		if err != nil {
+			return nil, fmt.Errorf("bulk load failed at row %d: invalid row: %w", i, err)
+		}
+		if err := ValidateRow(row, t.schema); err != nil {
+			return nil, fmt.Errorf("bulk load failed at row %d: %w", i, err)
+		}
+
+		if t.schema.HasRowID() {
+			rowid, err := t.nextRowID()
+			if err != nil {
+				return nil, fmt.Errorf("failed to allocate rowid: %w", err)
+			}
+			row.Values[RowIDColumn] = RowValue{Type: TypeInt, Value: rowid}
+		}
+
+		key, err := GetPrimaryKeyValue(row, t.schema)
+		if err != nil {
+			return nil, fmt.Errorf("bulk load failed at row %d: failed to get primary key: %w", i, err)
+		}
+		data, err := encodeRow(t.schema, row)
+		if err != nil {
+			return nil, fmt.Errorf("bulk load failed at row %d: failed to serialize row: %w", i, err)
+		}
+		loaded = append(loaded, loadedRow{key: key, data: data, row: row})
+	}
+
+	if err := t.checkLoadUnique(loaded); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(loaded, func(i, j int) bool {
+		return loaded[i].key.Compare(loaded[j].key) < 0
+	})
+	for i := 1; i < len(loaded); i++ {
+		if loaded[i-1].key.Compare(loaded[i].key) == 0 {
+			return nil, sqlerr.Wrap(sqlerr.UniqueViolation, fmt.Errorf("failed to insert into table %s: key %s: %w",
+				t.schema.Name, loaded[i].key, storage.ErrDuplicateKey))
+		}
+	}
+	return loaded, nil
+}
+
+// checkLoadUnique enforces the table's unique keys among the loaded rows,
+// which is all checkUnique would see in an empty table.
+func (t *Table) checkLoadUnique(loaded []loadedRow) error {
+	for _, columns := range t.uniqueKeys() {
+		seen := make(map[string]bool, len(loaded))
+		for _, r := range loaded {
+			key, err := columnsKey(t.schema, columns, r.row)
+			if err != nil {
+				return err
+			}
+			if key == nil {
+				continue
+			}
+			encoded := string(key.Encode())
+			if seen[encoded] {
+				return uniqueViolation(columns, r.row)
+			}
+			seen[encoded] = true
+		}
+	}
+	return nil
+}
+
+func (t *Table) loadIndex(idxMeta *IndexMetadata, loaded []loadedRow) error {
+	type indexEntry struct {
+		entry storage.Entry
+		row   *Row
+	}
+
+	var entries []indexEntry
+	for _, r := range loaded {
+		key, err := indexKey(t.schema, idxMeta, r.row)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			continue
+		}
+		entries = append(entries, indexEntry{storage.Entry{Key: key, Value: r.key.Encode()}, r.row})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].entry.Key.Compare(entries[j].entry.Key) < 0
+	})
+	sorted := make([]storage.Entry, len(entries))
+	for i, e := range entries {
+		if i > 0 && entries[i-1].entry.Key.Compare(e.entry.Key) == 0 {
+			if idxMeta.Unique {
+				return sqlerr.New(sqlerr.UniqueViolation, "unique constraint violation on index %s: value '%v' already exists",
+					idxMeta.Name, indexValues(idxMeta, e.row))
+			}
+			return fmt.Errorf("failed to insert into index %s: %w", idxMeta.Name, storage.ErrDuplicateKey)
+		}
+		sorted[i] = e.entry
+	}
+
+	tree, err := t.getIndexTree(idxMeta)
+	if err != nil {
+		return err
+	}
+	if err := tree.BulkLoad(sorted); err != nil {
+		return fmt.Errorf("failed to load index %s: %w", idxMeta.Name, err)
+	}
+	delete(t.Catalog.blooms, idxMeta.RootPage)
+	return nil
+}
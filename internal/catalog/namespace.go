@@ -0,0 +1,95 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// Namespace is a SQL schema: a named group of tables. A table in a schema is
+// cataloged under its qualified name, schema.table, so two schemas can each
+// hold a table of the same name.
+type Namespace struct {
+	Name string `json:"name"`
+}
+
+func namespaceKey(name string) storage.Key {
+	return stringToKey("schema:" + name)
+}
+
+// SplitTableName separates the schema of a qualified table name from the
+// table. schema is empty for an unqualified name.
+func SplitTableName(name string) (schema, table string) {
+	if dot := strings.IndexByte(name, '.'); dot >= 0 {
+		return name[:dot], name[dot+1:]
+	}
+	return "", name
+}
+
+func (c *Catalog) CreateNamespace(name string) error {
+	if name == "" {
+		return sqlerr.New(sqlerr.InvalidTableDefinition, "schema name cannot be empty")
+	}
+	if strings.Contains(name, ".") {
+		return sqlerr.New(sqlerr.InvalidTableDefinition, "invalid schema name '%s'", name)
+	}
+	if c.NamespaceExists(name) {
+		return sqlerr.New(sqlerr.DuplicateObject, "schema '%s' already exists", name)
+	}
+
+	data, err := json.Marshal(&Namespace{Name: name})
+	if err != nil {
+		return fmt.Errorf("failed to marshal schema: %w", err)
+	}
+
+	metaBytes, err := json.Marshal(metadataEntry{
+		Type: "schema",
+		Data: data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	if err := c.tree.Insert(namespaceKey(name), metaBytes); err != nil {
+		return fmt.Errorf("failed to insert schema into catalog: %w", err)
+	}
+
+	c.schemaChanged()
+	return nil
+}
+
+func (c *Catalog) NamespaceExists(name string) bool {
+	_, err := c.tree.Search(namespaceKey(name))
+	return err == nil
+}
+
+func (c *Catalog) ListNamespaces() []string {
+	entries, err := c.tree.Scan()
+	if err != nil {
+		return []string{}
+	}
+
+	namespaces := make([]string, 0)
+	for _, entry := range entries {
+		var meta metadataEntry
+		if err := json.Unmarshal(entry.Value, &meta); err != nil {
+			continue
+		}
+
+		if meta.Type != "schema" {
+			continue
+		}
+
+		var ns Namespace
+		if err := json.Unmarshal(meta.Data, &ns); err != nil {
+			continue
+		}
+
+		namespaces = append(namespaces, ns.Name)
+	}
+
+	return namespaces
+}
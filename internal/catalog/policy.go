@@ -0,0 +1,130 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// Policy is a row-level security rule: rows of Table that a user may see or
+// write must satisfy every condition. User limits the policy to one user;
+// empty applies it to every logged-in user.
+type Policy struct {
+	Name       string            `json:"name"`
+	Table      string            `json:"table"`
+	User       string            `json:"user,omitempty"`
+	Conditions []PolicyCondition `json:"conditions"`
+}
+
+type PolicyCondition struct {
+	Column   string `json:"column"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+func (p *Policy) AppliesTo(user string) bool {
+	return p.User == "" || p.User == user
+}
+
+// Policy names are scoped to their table, as in PostgreSQL.
+func policyKey(table, name string) storage.Key {
+	return stringToKey("policy:" + table + ":" + name)
+}
+
+func (c *Catalog) CreatePolicy(policy *Policy) error {
+	if policy.Name == "" {
+		return sqlerr.New(sqlerr.InvalidTableDefinition, "policy name cannot be empty")
+	}
+	if len(policy.Conditions) == 0 {
+		return sqlerr.New(sqlerr.InvalidTableDefinition, "policy '%s' has no conditions", policy.Name)
+	}
+
+	schema, err := c.GetTable(policy.Table)
+	if err != nil {
+		return err
+	}
+	for _, cond := range policy.Conditions {
+		if schema.GetColumn(cond.Column) == nil {
+			return sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found in table '%s'", cond.Column, policy.Table)
+		}
+	}
+
+	key := policyKey(policy.Table, policy.Name)
+	if _, err := c.tree.Search(key); err == nil {
+		return sqlerr.New(sqlerr.DuplicateObject, "policy '%s' already exists on table '%s'", policy.Name, policy.Table)
+	}
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy: %w", err)
+	}
+
+	metaBytes, err := json.Marshal(metadataEntry{
+		Type: "policy",
+		Data: data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	if err := c.tree.Insert(key, metaBytes); err != nil {
+		return fmt.Errorf("failed to insert policy into catalog: %w", err)
+	}
+
+	c.schemaChanged(policy.Table)
+	return nil
+}
+
+func (c *Catalog) DropPolicy(table, name string) error {
+	key := policyKey(table, name)
+	if _, err := c.tree.Search(key); err != nil {
+		return sqlerr.New(sqlerr.UndefinedObject, "policy '%s' does not exist on table '%s'", name, table)
+	}
+
+	if err := c.tree.Delete(key); err != nil {
+		return fmt.Errorf("failed to delete policy: %w", err)
+	}
+
+	c.schemaChanged(table)
+	return nil
+}
+
+func (c *Catalog) deleteTablePolicies(table string) {
+	for _, policy := range c.TablePolicies(table) {
+		if err := c.tree.Delete(policyKey(table, policy.Name)); err != nil {
+			fmt.Printf("Warning: failed to delete policy %s on %s: %v\n", policy.Name, table, err)
+		}
+	}
+}
+
+func (c *Catalog) TablePolicies(table string) []*Policy {
+	entries, err := c.tree.Scan()
+	if err != nil {
+		return []*Policy{}
+	}
+
+	policies := make([]*Policy, 0)
+	for _, entry := range entries {
+		var meta metadataEntry
+		if err := json.Unmarshal(entry.Value, &meta); err != nil {
+			continue
+		}
+
+		if meta.Type != "policy" {
+			continue
+		}
+
+		var policy Policy
+		if err := json.Unmarshal(meta.Data, &policy); err != nil {
+			continue
+		}
+
+		if policy.Table == table {
+			policies = append(policies, &policy)
+		}
+	}
+
+	return policies
+}
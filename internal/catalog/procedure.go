@@ -0,0 +1,83 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// Procedure is a stored procedure. Source is its CREATE PROCEDURE statement
+// as written, which is parsed again each time it is called.
+type Procedure struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+func procedureKey(name string) storage.Key {
+	return stringToKey("procedure:" + name)
+}
+
+func (c *Catalog) CreateProcedure(proc *Procedure) error {
+	if proc.Name == "" {
+		return sqlerr.New(sqlerr.InvalidTableDefinition, "procedure name cannot be empty")
+	}
+
+	key := procedureKey(proc.Name)
+	if _, err := c.tree.Search(key); err == nil {
+		return sqlerr.New(sqlerr.DuplicateObject, "procedure '%s' already exists", proc.Name)
+	}
+
+	data, err := json.Marshal(proc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal procedure: %w", err)
+	}
+
+	metaBytes, err := json.Marshal(metadataEntry{
+		Type: "procedure",
+		Data: data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	if err := c.tree.Insert(key, metaBytes); err != nil {
+		return fmt.Errorf("failed to insert procedure into catalog: %w", err)
+	}
+	return nil
+}
+
+func (c *Catalog) GetProcedure(name string) (*Procedure, error) {
+	value, err := c.tree.Search(procedureKey(name))
+	if err != nil {
+		return nil, sqlerr.New(sqlerr.UndefinedFunction, "procedure '%s' does not exist", name)
+	}
+
+	var meta metadataEntry
+	if err := json.Unmarshal(value, &meta); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+
+	if meta.Type != "procedure" {
+		return nil, fmt.Errorf("entry for '%s' is not a procedure", name)
+	}
+
+	var proc Procedure
+	if err := json.Unmarshal(meta.Data, &proc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal procedure: %w", err)
+	}
+	return &proc, nil
+}
+
+func (c *Catalog) DropProcedure(name string) error {
+	key := procedureKey(name)
+	if _, err := c.tree.Search(key); err != nil {
+		return sqlerr.New(sqlerr.UndefinedFunction, "procedure '%s' does not exist", name)
+	}
+
+	if err := c.tree.Delete(key); err != nil {
+		return fmt.Errorf("failed to delete procedure: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+// Reindex rebuilds the index name from its table into a new tree, built
+// bottom-up from the sorted keys and packed to the index's fill factor, and
+// frees the old one, in one batch. An index whose pages splits and deletes
+// have left half empty comes out smaller.
+func (c *Catalog) Reindex(name string) error {
+	return c.atomically(func() error {
+		index, err := c.getIndexUnsafe(name)
+		if err != nil {
+			return err
+		}
+		table, err := c.getTableUnsafe(index.TableName)
+		if err != nil {
+			return err
+		}
+
+		tree, err := storage.NewBTree(c.pager, true)
+		if err != nil {
+			return err
+		}
+		if err := tree.SetFillFactor(index.FillFactor); err != nil {
+			return err
+		}
+		if !(&Table{schema: table}).unusedIndex(index) {
+			entries, err := c.indexEntries(index, table)
+			if err != nil {
+				return err
+			}
+			if err := tree.BulkLoad(entries); err != nil {
+				return fmt.Errorf("failed to load index %s: %w", name, err)
+			}
+		}
+
+		old, err := storage.LoadBTree(c.pager, index.RootPage, true)
+		if err != nil {
+			return err
+		}
+		if err := old.Free(); err != nil {
+			return err
+		}
+		ref := treeRef{name: index.Name, table: index.TableName, kind: "index", root: index.RootPage}
+		if err := c.moveRoot(ref, tree.GetRootPage()); err != nil {
+			return err
+		}
+		c.schemaChanged(index.TableName)
+		return nil
+	})
+}
+
+// indexEntries reads the entries of index from every row of table, sorted
+// by key.
+func (c *Catalog) indexEntries(index *IndexMetadata, table *Schema) ([]storage.Entry, error) {
+	dataTree, err := c.openStore(table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load table tree: %w", err)
+	}
+	rows, err := dataTree.Scan()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan table: %w", err)
+	}
+
+	var entries []storage.Entry
+	for _, entry := range rows {
+		row, err := decodeRow(table, entry.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to deserialize row: %w", err)
+		}
+		key, err := indexKey(table, index, row)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			entries = append(entries, storage.Entry{Key: key, Value: entry.Key.Encode()})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key.Compare(entries[j].Key) < 0
+	})
+	return entries, nil
+}
@@ -0,0 +1,161 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+type TableStatistics struct {
+	TableName     string         `json:"table_name"`
+	RowCount      int            `json:"row_count"`
+	IndexDistinct map[string]int `json:"index_distinct"`
+	AnalyzedAt    int64          `json:"analyzed_at"`
+}
+
+// Statistics live in the catalog tree next to the schema they describe. The
+// ':' separator cannot appear in an identifier, so the key never collides
+// with a table or index name.
+func statsKey(tableName string) storage.Key {
+	return stringToKey("stats:" + tableName)
+}
+
+func (c *Catalog) GetTableStats(tableName string) (*TableStatistics, error) {
+	value, err := c.tree.Search(statsKey(tableName))
+	if err != nil {
+		return nil, fmt.Errorf("no statistics for table '%s'", tableName)
+	}
+
+	var meta metadataEntry
+	if err := json.Unmarshal(value, &meta); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+
+	if meta.Type != "stats" {
+		return nil, fmt.Errorf("entry for '%s' is not a statistics entry", tableName)
+	}
+
+	var stats TableStatistics
+	if err := json.Unmarshal(meta.Data, &stats); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal statistics: %w", err)
+	}
+
+	return &stats, nil
+}
+
+func (c *Catalog) SaveTableStats(stats *TableStatistics) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("failed to marshal statistics: %w", err)
+	}
+
+	metaBytes, err := json.Marshal(metadataEntry{
+		Type: "stats",
+		Data: data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	key := statsKey(stats.TableName)
+	if _, err := c.tree.Search(key); err == nil {
+		if err := c.tree.Update(key, metaBytes); err != nil {
+			return fmt.Errorf("failed to update statistics: %w", err)
+		}
+		return nil
+	}
+
+	if err := c.tree.Insert(key, metaBytes); err != nil {
+		return fmt.Errorf("failed to insert statistics: %w", err)
+	}
+	return nil
+}
+
+func (c *Catalog) deleteTableStats(tableName string) {
+	key := statsKey(tableName)
+	if _, err := c.tree.Search(key); err != nil {
+		return
+	}
+	if err := c.tree.Delete(key); err != nil {
+		fmt.Printf("Warning: failed to delete statistics for %s: %v\n", tableName, err)
+	}
+}
+
+// renameIndexStats moves the distinct count ANALYZE saved for an index to
+// its new name, or forgets it when newName is empty.
+func (c *Catalog) renameIndexStats(tableName, name, newName string) error {
+	stats, err := c.GetTableStats(tableName)
+	if err != nil {
+		return nil
+	}
+	distinct, ok := stats.IndexDistinct[name]
+	if !ok {
+		return nil
+	}
+	delete(stats.IndexDistinct, name)
+	if newName != "" {
+		stats.IndexDistinct[newName] = distinct
+	}
+	return c.SaveTableStats(stats)
+}
+
+// AnalyzeTable counts the rows of a table and the distinct keys of each of
+// its indexes, then persists the result so it survives restarts.
+func (c *Catalog) AnalyzeTable(tableName string) (*TableStatistics, error) {
+	table, err := c.LoadTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	rowCount, err := table.Count()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count rows of %s: %w", tableName, err)
+	}
+
+	stats := &TableStatistics{
+		TableName:     tableName,
+		RowCount:      rowCount,
+		IndexDistinct: make(map[string]int),
+		AnalyzedAt:    c.pager.Clock().Now().Unix(),
+	}
+
+	for _, idx := range c.GetTableIndexes(tableName) {
+		tree, err := storage.LoadBTree(c.pager, idx.RootPage, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load index %s: %w", idx.Name, err)
+		}
+		distinct, err := distinctValues(idx, tree)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count index %s: %w", idx.Name, err)
+		}
+		stats.IndexDistinct[idx.Name] = distinct
+	}
+
+	if err := c.SaveTableStats(stats); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// distinctValues counts the distinct values in tree, the tree of idx. The
+// keys of an index that is not unique end with the primary key, so the
+// values are counted as they change from one key to the next, in order.
+func distinctValues(idx *IndexMetadata, tree *storage.BTree) (int, error) {
+	if idx.Unique {
+		return tree.Count()
+	}
+
+	distinct := 0
+	var last storage.Key
+	err := tree.ForEach(func(k storage.Key, _ []byte) bool {
+		value := indexValueKey(idx, k)
+		if last == nil || value.Compare(last) != 0 {
+			distinct++
+		}
+		last = value
+		return true
+	})
+	return distinct, err
+}
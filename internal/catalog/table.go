@@ -3,34 +3,162 @@ package catalog
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
 )
 
 type Table struct {
-	Catalog *Catalog
-	schema  *Schema
-	btree   *storage.BTree
+	Catalog    *Catalog
+	schema     *Schema
+	store      storage.Store
+	generation uint64
+
+	// indexTrees holds the index B-trees opened through this handle, keyed
+	// by root page. It is dropped whenever the schema generation moves.
+	indexTrees map[uint32]*storage.BTree
+
+	// lastInserted is the row Insert last added through this handle.
+	lastInserted *Row
 }
 
-func NewTable(catalog *Catalog, schema *Schema, btree *storage.BTree) *Table {
+func NewTable(catalog *Catalog, schema *Schema, store storage.Store) *Table {
 	return &Table{
-		Catalog: catalog,
-		schema:  schema,
-		btree:   btree,
+		Catalog:    catalog,
+		schema:     schema,
+		store:      store,
+		generation: catalog.Generation(),
+		indexTrees: make(map[uint32]*storage.BTree),
 	}
 }
 
+// refreshSchema reloads the schema if any DDL happened since this handle was
+// loaded, so a long-lived Table never reads or writes with a stale schema.
+func (t *Table) refreshSchema() error {
+	gen := t.Catalog.Generation()
+	if gen == t.generation {
+		return nil
+	}
+
+	schema, err := t.Catalog.getTableUnsafe(t.schema.Name)
+	if err != nil {
+		return fmt.Errorf("table %s is no longer valid: %w", t.schema.Name, err)
+	}
+
+	if schema.RootPage != t.schema.RootPage || schema.Engine != t.schema.Engine {
+		store, err := t.Catalog.openStore(schema)
+		if err != nil {
+			return fmt.Errorf("failed to reload table: %w", err)
+		}
+		t.store = store
+	}
+
+	t.schema = schema
+	t.generation = gen
+	t.ClearIndexCache()
+	return nil
+}
+
 func (t *Table) getIndexTree(idxMeta *IndexMetadata) (*storage.BTree, error) {
+	if idxTree, ok := t.indexTrees[idxMeta.RootPage]; ok {
+		return idxTree, nil
+	}
 
 	idxTree, err := storage.LoadBTree(t.Catalog.pager, idxMeta.RootPage, true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load index %s: %w", idxMeta.Name, err)
 	}
+	if err := idxTree.SetFillFactor(idxMeta.FillFactor); err != nil {
+		return nil, fmt.Errorf("failed to load index %s: %w", idxMeta.Name, err)
+	}
 
+	t.indexTrees[idxMeta.RootPage] = idxTree
 	return idxTree, nil
 }
 
+// ClearIndexCache forgets the index B-trees opened through this handle.
+func (t *Table) ClearIndexCache() {
+	t.indexTrees = make(map[uint32]*storage.BTree)
+}
+
+// indexKey builds the key row is stored under in idxMeta: the values of its
+// columns, followed, unless the index is unique, by the row's primary key so
+// that rows with equal values get keys of their own. The key is nil when any
+// indexed column is NULL, since NULLs are not indexed.
+func indexKey(schema *Schema, idxMeta *IndexMetadata, row *Row) (storage.Key, error) {
+	keys, err := columnKeys(schema, idxMeta.KeyColumns(), row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create index key for %s: %w", idxMeta.Name, err)
+	}
+	if keys == nil || idxMeta.Unique {
+		return compositeKey(keys), nil
+	}
+
+	pk, err := GetPrimaryKeyValue(row, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create index key for %s: %w", idxMeta.Name, err)
+	}
+	return storage.NewCompositeKey(append(keys, pk)...), nil
+}
+
+// indexValueKey returns the part of key, a key of idxMeta, that holds the
+// values of its columns, as columnsKey builds it.
+func indexValueKey(idxMeta *IndexMetadata, key storage.Key) storage.Key {
+	if idxMeta.Unique {
+		return key
+	}
+	components := key.(*storage.CompositeKey).Components
+	return compositeKey(components[:len(components)-1])
+}
+
+// columnsKey builds the key of the values of columns in row, nil when any
+// of them is NULL.
+func columnsKey(schema *Schema, columns []string, row *Row) (storage.Key, error) {
+	keys, err := columnKeys(schema, columns, row)
+	if err != nil {
+		return nil, err
+	}
+	return compositeKey(keys), nil
+}
+
+// columnKeys returns the key of each of columns in row, nil when any of
+// them is NULL.
+func columnKeys(schema *Schema, columns []string, row *Row) ([]storage.Key, error) {
+	keys := make([]storage.Key, 0, len(columns))
+
+	for _, name := range columns {
+		col := schema.GetColumn(name)
+		if col == nil {
+			return nil, sqlerr.New(sqlerr.UndefinedColumn, "column %s not found in schema", name)
+		}
+
+		val := row.Values[name]
+		if val.Value == nil {
+			return nil, nil
+		}
+
+		key, err := ValueToKey(val.Value, col.Type)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func indexValues(idxMeta *IndexMetadata, row *Row) string {
+	return columnValues(idxMeta.KeyColumns(), row)
+}
+
+func columnValues(columns []string, row *Row) string {
+	values := make([]string, len(columns))
+	for i, name := range columns {
+		values[i] = fmt.Sprintf("%v", row.Values[name].Value)
+	}
+	return strings.Join(values, ", ")
+}
+
 func (t *Table) getPrimaryKeyColumnName() string {
 	for _, col := range t.schema.Columns {
 		if col.PrimaryKey {
@@ -40,36 +168,60 @@ func (t *Table) getPrimaryKeyColumnName() string {
 	return ""
 }
 
+// unusedIndex reports whether idxMeta is the primary key index of a table
+// keyed by its primary key, which is never written since the table tree
+// already answers lookups by primary key.
+func (t *Table) unusedIndex(idxMeta *IndexMetadata) bool {
+	return !t.schema.Clustered() && idxMeta.ColumnName == t.getPrimaryKeyColumnName()
+}
+
 func (t *Table) Insert(values []interface{}) error {
+	if err := t.refreshSchema(); err != nil {
+		return err
+	}
+	return t.insert(values)
+}
+
+func (t *Table) insert(values []interface{}) error {
 	row, err := CreateRow(t.schema, values)
 	if err != nil {
 		return fmt.Errorf("invalid row: %w", err)
 	}
 
-	if err := ValidateRow(row, t.schema); err != nil {
+	if err := t.checkConstraints(row, nil); err != nil {
 		return fmt.Errorf("row validation failed: %w", err)
 	}
 
+	if t.schema.HasRowID() {
+		rowid, err := t.nextRowID()
+		if err != nil {
+			return fmt.Errorf("failed to allocate rowid: %w", err)
+		}
+		row.Values[RowIDColumn] = RowValue{Type: TypeInt, Value: rowid}
+	}
+
 	primaryKey, err := GetPrimaryKeyValue(row, t.schema)
 	if err != nil {
 		return fmt.Errorf("failed to get primary key: %w", err)
 	}
 
-	rowData, err := SerializeRow(row)
+	rowData, err := encodeRow(t.schema, row)
 	if err != nil {
 		return fmt.Errorf("failed to serialize row: %w", err)
 	}
 
-	if err := t.btree.Insert(primaryKey, rowData); err != nil {
+	if err := t.store.Insert(primaryKey, rowData); err != nil {
+		if errors.Is(err, storage.ErrDuplicateKey) {
+			return sqlerr.Wrap(sqlerr.UniqueViolation, fmt.Errorf("failed to insert into table %s: %w", t.schema.Name, err))
+		}
 		return fmt.Errorf("failed to insert into table %s: %w", t.schema.Name, err)
 	}
 
 	var insertedIndexes []string
 
-	indexes := t.Catalog.GetTableIndexes(t.schema.Name)
-	for _, idxMeta := range indexes {
+	for _, idxMeta := range t.Catalog.GetTableIndexes(t.schema.Name) {
 
-		if idxMeta.ColumnName == t.getPrimaryKeyColumnName() {
+		if t.unusedIndex(idxMeta) {
 			continue
 		}
 
@@ -79,37 +231,73 @@ func (t *Table) Insert(values []interface{}) error {
 			return err
 		}
 
-		val := row.Values[idxMeta.ColumnName]
-		col := t.schema.GetColumn(idxMeta.ColumnName)
-		if col == nil {
-			t.rollbackInsert(primaryKey, insertedIndexes, row)
-			return fmt.Errorf("column %s not found in schema", idxMeta.ColumnName)
-		}
-
-		idxKey, err := ValueToKey(val.Value, col.Type)
+		idxKey, err := indexKey(t.schema, idxMeta, row)
 		if err != nil {
 			t.rollbackInsert(primaryKey, insertedIndexes, row)
-			return fmt.Errorf("failed to create index key for %s: %w", idxMeta.Name, err)
+			return err
+		}
+		if idxKey == nil {
+			continue
 		}
 
 		if err := idxTree.Insert(idxKey, primaryKey.Encode()); err != nil {
 			t.rollbackInsert(primaryKey, insertedIndexes, row)
 			if idxMeta.Unique {
-				return fmt.Errorf("unique constraint violation on index %s: value '%v' already exists",
-					idxMeta.Name, val.Value)
+				return sqlerr.New(sqlerr.UniqueViolation, "unique constraint violation on index %s: value '%v' already exists",
+					idxMeta.Name, indexValues(idxMeta, row))
 			}
 			return fmt.Errorf("failed to insert into index %s: %w", idxMeta.Name, err)
 		}
+		t.indexAdded(idxMeta, idxKey)
 
 		insertedIndexes = append(insertedIndexes, idxMeta.Name)
 	}
 
+	t.adjustCount(1)
+	t.lastInserted = row
+	t.Catalog.recordChange(Change{Table: t.schema.Name, Op: ChangeInsert, New: row})
 	return nil
 }
 
+// LastInsertID returns the rowid of the row Insert last added through this
+// handle, or its primary key if that is an integer column. It reports false
+// if no row was inserted or its key is not an integer.
+func (t *Table) LastInsertID() (int64, bool) {
+	if t.lastInserted == nil {
+		return 0, false
+	}
+	column := RowIDColumn
+	if !t.schema.HasRowID() {
+		column = t.getPrimaryKeyColumnName()
+	}
+	value, ok := t.lastInserted.Values[column]
+	if !ok || value.Type != TypeInt {
+		return 0, false
+	}
+	id, ok := value.Value.(int64)
+	return id, ok
+}
+
+func (t *Table) nextRowID() (int64, error) {
+	last, ok := t.Catalog.rowids[t.schema.Name]
+	if !ok {
+		key, err := t.store.LastKey()
+		if err != nil {
+			return 0, err
+		}
+		if intKey, isInt := key.(*storage.IntKey); isInt {
+			last = intKey.Value
+		}
+	}
+
+	last++
+	t.Catalog.rowids[t.schema.Name] = last
+	return last, nil
+}
+
 func (t *Table) rollbackInsert(primaryKey storage.Key, insertedIndexes []string, row *Row) {
 
-	if err := t.btree.Delete(primaryKey); err != nil {
+	if err := t.store.Delete(primaryKey); err != nil {
 		fmt.Printf("Warning: failed to rollback main table insert: %v\n", err)
 	}
 
@@ -133,17 +321,14 @@ func (t *Table) rollbackInsert(primaryKey storage.Key, insertedIndexes []string,
 			continue
 		}
 
-		val := row.Values[idxMeta.ColumnName]
-		col := t.schema.GetColumn(idxMeta.ColumnName)
-		if col == nil {
-			continue
-		}
-
-		idxKey, err := ValueToKey(val.Value, col.Type)
+		idxKey, err := indexKey(t.schema, idxMeta, row)
 		if err != nil {
 			fmt.Printf("Warning: failed to create index key during rollback: %v\n", err)
 			continue
 		}
+		if idxKey == nil {
+			continue
+		}
 
 		if err := idxTree.Delete(idxKey); err != nil {
 			fmt.Printf("Warning: failed to delete from index %s during rollback: %v\n", idxMeta.Name, err)
@@ -152,12 +337,12 @@ func (t *Table) rollbackInsert(primaryKey storage.Key, insertedIndexes []string,
 }
 
 func (t *Table) Get(key storage.Key) (*Row, error) {
-	rowData, err := t.btree.Search(key)
+	rowData, err := t.store.Search(key)
 	if err != nil {
 		return nil, fmt.Errorf("row not found in table %s: %w", t.schema.Name, err)
 	}
 
-	row, err := DeserializeRow(rowData)
+	row, err := decodeRow(t.schema, rowData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to deserialize row: %w", err)
 	}
@@ -166,6 +351,9 @@ func (t *Table) Get(key storage.Key) (*Row, error) {
 }
 
 func (t *Table) Delete(key storage.Key) error {
+	if err := t.refreshSchema(); err != nil {
+		return err
+	}
 
 	row, err := t.Get(key)
 	if err != nil {
@@ -177,7 +365,7 @@ func (t *Table) Delete(key storage.Key) error {
 
 	for _, idxMeta := range indexes {
 
-		if idxMeta.ColumnName == t.getPrimaryKeyColumnName() {
+		if t.unusedIndex(idxMeta) {
 			continue
 		}
 
@@ -188,18 +376,14 @@ func (t *Table) Delete(key storage.Key) error {
 			continue
 		}
 
-		val := row.Values[idxMeta.ColumnName]
-		col := t.schema.GetColumn(idxMeta.ColumnName)
-		if col == nil {
-			fmt.Printf("Warning: column %s not found during delete\n", idxMeta.ColumnName)
-			continue
-		}
-
-		idxKey, err := ValueToKey(val.Value, col.Type)
+		idxKey, err := indexKey(t.schema, idxMeta, row)
 		if err != nil {
 			fmt.Printf("Warning: failed to create index key during delete: %v\n", err)
 			continue
 		}
+		if idxKey == nil {
+			continue
+		}
 
 		if err := idxTree.Delete(idxKey); err != nil {
 			fmt.Printf("Warning: failed to delete from index %s: %v\n", idxMeta.Name, err)
@@ -208,12 +392,14 @@ func (t *Table) Delete(key storage.Key) error {
 		}
 	}
 
-	if err := t.btree.Delete(key); err != nil {
+	if err := t.store.Delete(key); err != nil {
 
 		t.rollbackDelete(key, row, deletedIndexes)
 		return fmt.Errorf("failed to delete row from table %s: %w", t.schema.Name, err)
 	}
 
+	t.adjustCount(-1)
+	t.Catalog.recordChange(Change{Table: t.schema.Name, Op: ChangeDelete, Old: row})
 	return nil
 }
 
@@ -237,14 +423,8 @@ func (t *Table) rollbackDelete(primaryKey storage.Key, row *Row, deletedIndexes
 			continue
 		}
 
-		val := row.Values[idxMeta.ColumnName]
-		col := t.schema.GetColumn(idxMeta.ColumnName)
-		if col == nil {
-			continue
-		}
-
-		idxKey, err := ValueToKey(val.Value, col.Type)
-		if err != nil {
+		idxKey, err := indexKey(t.schema, idxMeta, row)
+		if err != nil || idxKey == nil {
 			continue
 		}
 
@@ -255,6 +435,9 @@ func (t *Table) rollbackDelete(primaryKey storage.Key, row *Row, deletedIndexes
 }
 
 func (t *Table) Update(key storage.Key, newValues []interface{}) error {
+	if err := t.refreshSchema(); err != nil {
+		return err
+	}
 
 	oldRow, err := t.Get(key)
 	if err != nil {
@@ -266,7 +449,11 @@ func (t *Table) Update(key storage.Key, newValues []interface{}) error {
 		return fmt.Errorf("invalid row: %w", err)
 	}
 
-	if err := ValidateRow(newRow, t.schema); err != nil {
+	if rowid, exists := oldRow.Values[RowIDColumn]; exists {
+		newRow.Values[RowIDColumn] = rowid
+	}
+
+	if err := t.checkConstraints(newRow, key); err != nil {
 		return fmt.Errorf("row validation failed: %w", err)
 	}
 
@@ -276,60 +463,62 @@ func (t *Table) Update(key storage.Key, newValues []interface{}) error {
 	}
 
 	if key.Compare(newPK) != 0 {
-		return errors.New("cannot update primary key value - use delete and insert instead")
+		if !t.schema.Clustered() {
+			return sqlerr.New(sqlerr.FeatureNotSupported, "cannot update primary key value - use delete and insert instead")
+		}
+		return t.moveRow(key, newPK, oldRow, newRow)
 	}
 
 	indexes := t.Catalog.GetTableIndexes(t.schema.Name)
 	var updatedIndexes []indexUpdate
 
 	for _, idxMeta := range indexes {
-		if idxMeta.ColumnName == t.getPrimaryKeyColumnName() {
+		if t.unusedIndex(idxMeta) {
 			continue
 		}
 
-		oldVal := oldRow.Values[idxMeta.ColumnName]
-		newVal := newRow.Values[idxMeta.ColumnName]
-
-		if ValuesEqual(oldVal.Value, newVal.Value) {
-			continue
+		oldKey, err := indexKey(t.schema, idxMeta, oldRow)
+		if err != nil {
+			t.rollbackUpdate(updatedIndexes)
+			return fmt.Errorf("failed to create old index key: %w", err)
 		}
 
-		idxTree, err := t.getIndexTree(idxMeta)
+		newKey, err := indexKey(t.schema, idxMeta, newRow)
 		if err != nil {
 			t.rollbackUpdate(updatedIndexes)
-			return err
+			return fmt.Errorf("failed to create new index key: %w", err)
 		}
 
-		col := t.schema.GetColumn(idxMeta.ColumnName)
-		if col == nil {
-			t.rollbackUpdate(updatedIndexes)
-			return fmt.Errorf("column %s not found", idxMeta.ColumnName)
+		if oldKey == nil && newKey == nil {
+			continue
+		}
+		if oldKey != nil && newKey != nil && oldKey.Compare(newKey) == 0 {
+			continue
 		}
 
-		oldKey, err := ValueToKey(oldVal.Value, col.Type)
+		idxTree, err := t.getIndexTree(idxMeta)
 		if err != nil {
 			t.rollbackUpdate(updatedIndexes)
-			return fmt.Errorf("failed to create old index key: %w", err)
+			return err
 		}
 
-		if err := idxTree.Delete(oldKey); err != nil {
+		if oldKey != nil {
+			if err := idxTree.Delete(oldKey); err != nil {
 
-			fmt.Printf("Warning: failed to delete old index entry from %s: %v\n", idxMeta.Name, err)
-		}
-
-		newKey, err := ValueToKey(newVal.Value, col.Type)
-		if err != nil {
-			t.rollbackUpdate(updatedIndexes)
-			return fmt.Errorf("failed to create new index key: %w", err)
+				fmt.Printf("Warning: failed to delete old index entry from %s: %v\n", idxMeta.Name, err)
+			}
 		}
 
-		if err := idxTree.Insert(newKey, key.Encode()); err != nil {
-			t.rollbackUpdate(updatedIndexes)
-			if idxMeta.Unique {
-				return fmt.Errorf("unique constraint violation on index %s: value '%v' already exists",
-					idxMeta.Name, newVal.Value)
+		if newKey != nil {
+			if err := idxTree.Insert(newKey, key.Encode()); err != nil {
+				t.rollbackUpdate(updatedIndexes)
+				if idxMeta.Unique {
+					return sqlerr.New(sqlerr.UniqueViolation, "unique constraint violation on index %s: value '%v' already exists",
+						idxMeta.Name, indexValues(idxMeta, newRow))
+				}
+				return fmt.Errorf("failed to insert into index %s: %w", idxMeta.Name, err)
 			}
-			return fmt.Errorf("failed to insert into index %s: %w", idxMeta.Name, err)
+			t.indexAdded(idxMeta, newKey)
 		}
 
 		updatedIndexes = append(updatedIndexes, indexUpdate{
@@ -339,17 +528,95 @@ func (t *Table) Update(key storage.Key, newValues []interface{}) error {
 		})
 	}
 
-	rowData, err := SerializeRow(newRow)
+	rowData, err := encodeRow(t.schema, newRow)
 	if err != nil {
 		t.rollbackUpdate(updatedIndexes)
 		return fmt.Errorf("failed to serialize row: %w", err)
 	}
 
-	if err := t.btree.Update(key, rowData); err != nil {
+	if err := t.store.Update(key, rowData); err != nil {
 		t.rollbackUpdate(updatedIndexes)
 		return fmt.Errorf("failed to update row in table %s: %w", t.schema.Name, err)
 	}
 
+	t.Catalog.recordChange(Change{Table: t.schema.Name, Op: ChangeUpdate, Old: oldRow, New: newRow})
+	return nil
+}
+
+// moveRow stores a row of a clustered table whose cluster key changed under
+// its new key. Every index entry points at the row's key, so each is
+// rewritten, not only those whose indexed columns changed.
+func (t *Table) moveRow(key, newKey storage.Key, oldRow, newRow *Row) error {
+	rowData, err := encodeRow(t.schema, newRow)
+	if err != nil {
+		return fmt.Errorf("failed to serialize row: %w", err)
+	}
+	if err := t.store.Insert(newKey, rowData); err != nil {
+		return fmt.Errorf("failed to update row in table %s: %w", t.schema.Name, err)
+	}
+
+	var moved []*IndexMetadata
+	undo := func() {
+		for _, idxMeta := range moved {
+			idxTree, err := t.getIndexTree(idxMeta)
+			if err != nil {
+				continue
+			}
+			if newIdxKey, err := indexKey(t.schema, idxMeta, newRow); err == nil && newIdxKey != nil {
+				idxTree.Delete(newIdxKey)
+			}
+			if oldIdxKey, err := indexKey(t.schema, idxMeta, oldRow); err == nil && oldIdxKey != nil {
+				idxTree.Insert(oldIdxKey, key.Encode())
+			}
+		}
+		t.store.Delete(newKey)
+	}
+
+	for _, idxMeta := range t.Catalog.GetTableIndexes(t.schema.Name) {
+		idxTree, err := t.getIndexTree(idxMeta)
+		if err != nil {
+			undo()
+			return err
+		}
+		oldIdxKey, err := indexKey(t.schema, idxMeta, oldRow)
+		if err != nil {
+			undo()
+			return fmt.Errorf("failed to create old index key: %w", err)
+		}
+		newIdxKey, err := indexKey(t.schema, idxMeta, newRow)
+		if err != nil {
+			undo()
+			return fmt.Errorf("failed to create new index key: %w", err)
+		}
+
+		if oldIdxKey != nil {
+			if err := idxTree.Delete(oldIdxKey); err != nil {
+				fmt.Printf("Warning: failed to delete old index entry from %s: %v\n", idxMeta.Name, err)
+			}
+		}
+		if newIdxKey != nil {
+			if err := idxTree.Insert(newIdxKey, newKey.Encode()); err != nil {
+				if oldIdxKey != nil {
+					idxTree.Insert(oldIdxKey, key.Encode())
+				}
+				undo()
+				if idxMeta.Unique {
+					return sqlerr.New(sqlerr.UniqueViolation, "unique constraint violation on index %s: value '%v' already exists",
+						idxMeta.Name, indexValues(idxMeta, newRow))
+				}
+				return fmt.Errorf("failed to insert into index %s: %w", idxMeta.Name, err)
+			}
+			t.indexAdded(idxMeta, newIdxKey)
+		}
+		moved = append(moved, idxMeta)
+	}
+
+	if err := t.store.Delete(key); err != nil {
+		undo()
+		return fmt.Errorf("failed to update row in table %s: %w", t.schema.Name, err)
+	}
+
+	t.Catalog.recordChange(Change{Table: t.schema.Name, Op: ChangeUpdate, Old: oldRow, New: newRow})
 	return nil
 }
 
@@ -378,12 +645,16 @@ func (t *Table) rollbackUpdate(updates []indexUpdate) {
 			continue
 		}
 
-		idxTree.Delete(update.newKey)
+		if update.newKey != nil {
+			idxTree.Delete(update.newKey)
+		}
 
 	}
 }
 
-func (t *Table) GetByIndex(indexName string, value interface{}) (*Row, error) {
+// GetByIndex returns the rows whose indexed column holds value, none if no
+// row does.
+func (t *Table) GetByIndex(indexName string, value interface{}) ([]*Row, error) {
 
 	indexes := t.Catalog.GetTableIndexes(t.schema.Name)
 	var idxMeta *IndexMetadata
@@ -395,7 +666,7 @@ func (t *Table) GetByIndex(indexName string, value interface{}) (*Row, error) {
 	}
 
 	if idxMeta == nil {
-		return nil, fmt.Errorf("index %s not found on table %s", indexName, t.schema.Name)
+		return nil, sqlerr.New(sqlerr.UndefinedObject, "index %s not found on table %s", indexName, t.schema.Name)
 	}
 
 	col := t.schema.GetColumn(idxMeta.ColumnName)
@@ -408,34 +679,65 @@ func (t *Table) GetByIndex(indexName string, value interface{}) (*Row, error) {
 		return nil, fmt.Errorf("failed to create index key: %w", err)
 	}
 
-	idxTree, err := t.getIndexTree(idxMeta)
-	if err != nil {
+	if ok, err := t.indexMayContain(idxMeta, idxKey); err != nil || !ok {
 		return nil, err
 	}
 
-	pkBytes, err := idxTree.Search(idxKey)
+	idxTree, err := t.getIndexTree(idxMeta)
 	if err != nil {
-		return nil, fmt.Errorf("value not found in index: %w", err)
+		return nil, err
 	}
 
-	pk, err := storage.DecodeKey(pkBytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode primary key from index: %w", err)
+	var entries []storage.Entry
+	if idxMeta.Unique {
+		pkBytes, err := idxTree.Search(idxKey)
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("index lookup failed: %w", err)
+		}
+		entries = []storage.Entry{{Key: idxKey, Value: pkBytes}}
+	} else {
+		start, end := storage.PrefixBounds(idxKey)
+		if entries, err = idxTree.RangeSearch(start, end); err != nil {
+			return nil, fmt.Errorf("index lookup failed: %w", err)
+		}
 	}
 
-	return t.Get(pk)
+	rows := make([]*Row, 0, len(entries))
+	for _, entry := range entries {
+		pk, err := storage.DecodeKey(entry.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode primary key from index: %w", err)
+		}
+
+		row, err := t.Get(pk)
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			// Unlike a value missing from the index, this is not a miss.
+			return nil, sqlerr.New(sqlerr.DataCorrupted, "index %s points to a row missing from table %s", indexName, t.schema.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
 }
 
 func (t *Table) Scan() ([]*Row, error) {
+	if err := t.refreshSchema(); err != nil {
+		return nil, err
+	}
 
-	entries, err := t.btree.Scan()
+	entries, err := t.store.Scan()
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan table %s: %w", t.schema.Name, err)
 	}
 
 	rows := make([]*Row, 0, len(entries))
 	for _, entry := range entries {
-		row, err := DeserializeRow(entry.Value)
+		row, err := decodeRow(t.schema, entry.Value)
 		if err != nil {
 
 			fmt.Printf("Warning: failed to deserialize row in table %s: %v\n", t.schema.Name, err)
@@ -447,9 +749,134 @@ func (t *Table) Scan() ([]*Row, error) {
 	return rows, nil
 }
 
+// ScanEach calls fn with each row in key order, as Scan would return them,
+// without reading the whole table first. An error from fn stops the scan
+// and is returned.
+func (t *Table) ScanEach(fn func(row *Row) error) error {
+	return t.ScanOrderedEach("", false, fn)
+}
+
+// ScanOrdered returns every row ordered by the named index, or by the primary
+// key when indexName is empty, largest first if descending is set. Rows with
+// a NULL in an indexed column are not in the index and so are not returned.
+func (t *Table) ScanOrdered(indexName string, descending bool) ([]*Row, error) {
+	var rows []*Row
+	err := t.ScanOrderedEach(indexName, descending, func(row *Row) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// ScanOrderedEach calls fn with each row ScanOrdered would return, in
+// turn. An error from fn stops the scan and is returned.
+func (t *Table) ScanOrderedEach(indexName string, descending bool, fn func(row *Row) error) error {
+	it, err := t.orderedIterator(indexName, descending)
+	if err != nil {
+		return err
+	}
+
+	for it.HasNext() {
+		_, value, err := it.Next()
+		if err != nil {
+			return fmt.Errorf("failed to scan table %s: %w", t.schema.Name, err)
+		}
+		row, err := t.entryRow(indexName, value)
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("failed to scan table %s: %w", t.schema.Name, err)
+	}
+	return nil
+}
+
+// Endpoint returns the first row in the order of the named index, or of the
+// primary key when indexName is empty, or the last row if last is set. It
+// returns nil when the index is empty.
+func (t *Table) Endpoint(indexName string, last bool) (*Row, error) {
+	it, err := t.orderedIterator(indexName, last)
+	if err != nil {
+		return nil, err
+	}
+	if !it.HasNext() {
+		if err := it.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", t.schema.Name, err)
+		}
+		return nil, nil
+	}
+
+	_, value, err := it.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", t.schema.Name, err)
+	}
+	return t.entryRow(indexName, value)
+}
+
+func (t *Table) orderedIterator(indexName string, descending bool) (storage.EntryIterator, error) {
+	if err := t.refreshSchema(); err != nil {
+		return nil, err
+	}
+
+	var tree storage.Store = t.store
+	if indexName != "" {
+		var idxMeta *IndexMetadata
+		for _, idx := range t.Catalog.GetTableIndexes(t.schema.Name) {
+			if idx.Name == indexName {
+				idxMeta = idx
+				break
+			}
+		}
+		if idxMeta == nil {
+			return nil, sqlerr.New(sqlerr.UndefinedObject, "index %s not found on table %s", indexName, t.schema.Name)
+		}
+
+		var err error
+		if tree, err = t.getIndexTree(idxMeta); err != nil {
+			return nil, err
+		}
+	}
+
+	it, err := tree.Entries(descending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan table %s: %w", t.schema.Name, err)
+	}
+	return it, nil
+}
+
+// entryRow turns an entry of the table's tree, or of the named index, into
+// its row.
+func (t *Table) entryRow(indexName string, value []byte) (*Row, error) {
+	if indexName == "" {
+		row, err := decodeRow(t.schema, value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to deserialize row in table %s: %w", t.schema.Name, err)
+		}
+		return row, nil
+	}
+
+	pk, err := storage.DecodeKey(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PK from index: %w", err)
+	}
+	row, err := t.Get(pk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get row by PK from index: %w", err)
+	}
+	return row, nil
+}
+
 func (t *Table) ScanLimit(offset, limit int) ([]*Row, error) {
 
-	entries, err := t.btree.Scan()
+	entries, err := t.store.Scan()
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan table %s: %w", t.schema.Name, err)
 	}
@@ -469,7 +896,7 @@ func (t *Table) ScanLimit(offset, limit int) ([]*Row, error) {
 
 	rows := make([]*Row, 0, end-start)
 	for i := start; i < end; i++ {
-		row, err := DeserializeRow(entries[i].Value)
+		row, err := decodeRow(t.schema, entries[i].Value)
 		if err != nil {
 			fmt.Printf("Warning: failed to deserialize row in table %s: %v\n", t.schema.Name, err)
 			continue
@@ -480,18 +907,64 @@ func (t *Table) ScanLimit(offset, limit int) ([]*Row, error) {
 	return rows, nil
 }
 
+// Count returns the number of rows, counting the tree only the first time.
 func (t *Table) Count() (int, error) {
+	if err := t.refreshSchema(); err != nil {
+		return 0, err
+	}
+	if count, ok := t.Catalog.rowCounts[t.schema.Name]; ok {
+		return count, nil
+	}
 
-	return t.btree.Count()
+	count, err := t.store.Count()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count rows of %s: %w", t.schema.Name, err)
+	}
+	t.Catalog.rowCounts[t.schema.Name] = count
+	return count, nil
+}
+
+func (t *Table) adjustCount(delta int) {
+	if count, ok := t.Catalog.rowCounts[t.schema.Name]; ok {
+		t.Catalog.rowCounts[t.schema.Name] = count + delta
+	}
+}
+
+// Compact merges the runs of an LSM table into one, dropping deleted rows,
+// as a single batch.
+func (t *Table) Compact() error {
+	if err := t.refreshSchema(); err != nil {
+		return err
+	}
+	lsm, ok := t.store.(*storage.LSMTree)
+	if !ok {
+		return sqlerr.New(sqlerr.FeatureNotSupported, "table %s does not use the %s engine", t.schema.Name, EngineLSM)
+	}
+	return t.Catalog.Batch(lsm.Compact)
+}
+
+// Runs returns the number of runs of an LSM table, which Compact merges
+// into one, and 0 for any other table.
+func (t *Table) Runs() (int, error) {
+	if err := t.refreshSchema(); err != nil {
+		return 0, err
+	}
+	if lsm, ok := t.store.(*storage.LSMTree); ok {
+		return lsm.Runs()
+	}
+	return 0, nil
 }
 
 func (t *Table) GetSchema() *Schema {
+	if err := t.refreshSchema(); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
 	return t.schema
 }
 
 func (t *Table) Exists(key storage.Key) (bool, error) {
 
-	_, err := t.btree.Search(key)
+	_, err := t.store.Search(key)
 	if err != nil {
 		if err.Error() == "key not found" {
 			return false, nil
@@ -501,19 +974,44 @@ func (t *Table) Exists(key storage.Key) (bool, error) {
 	return true, nil
 }
 
+// BatchInsert inserts rows as one batch: nothing reaches the disk until every
+// row is in, so a failing row leaves the table as it was. Called inside a
+// batch already in progress, it joins it.
 func (t *Table) BatchInsert(rows [][]interface{}) error {
+	if err := t.refreshSchema(); err != nil {
+		return err
+	}
 
-	insertedCount := 0
-
-	for i, values := range rows {
-		if err := t.Insert(values); err != nil {
-			return fmt.Errorf("batch insert failed at row %d (inserted %d rows): %w",
-				i, insertedCount, err)
+	return t.Catalog.Batch(func() error {
+		for i, values := range rows {
+			if err := t.insert(values); err != nil {
+				return fmt.Errorf("batch insert failed at row %d: %w", i, err)
+			}
 		}
-		insertedCount++
+		return nil
+	})
+}
+
+func (t *Table) rangeByPrimaryKey(startKey, endKey storage.Key) ([]*Row, error) {
+	if err := t.refreshSchema(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	entries, err := t.store.RangeSearch(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("range search failed: %w", err)
+	}
+
+	rows := make([]*Row, 0, len(entries))
+	for _, entry := range entries {
+		row, err := decodeRow(t.schema, entry.Value)
+		if err != nil {
+			fmt.Printf("Warning: failed to deserialize row in table %s: %v\n", t.schema.Name, err)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
 }
 
 func (t *Table) RangeByIndex(indexName string, startValue, endValue interface{}) ([]*Row, error) {
@@ -528,7 +1026,7 @@ func (t *Table) RangeByIndex(indexName string, startValue, endValue interface{})
 	}
 
 	if idxMeta == nil {
-		return nil, fmt.Errorf("index %s not found on table %s", indexName, t.schema.Name)
+		return nil, sqlerr.New(sqlerr.UndefinedObject, "index %s not found on table %s", indexName, t.schema.Name)
 	}
 
 	col := t.schema.GetColumn(idxMeta.ColumnName)
@@ -546,11 +1044,21 @@ func (t *Table) RangeByIndex(indexName string, startValue, endValue interface{})
 		return nil, fmt.Errorf("failed to create end key: %w", err)
 	}
 
+	if t.unusedIndex(idxMeta) {
+		return t.rangeByPrimaryKey(startKey, endKey)
+	}
+
 	idxTree, err := t.getIndexTree(idxMeta)
 	if err != nil {
 		return nil, err
 	}
 
+	if !idxMeta.Unique {
+		// Keys of an index that is not unique end with the primary key.
+		startKey, _ = storage.PrefixBounds(startKey)
+		_, endKey = storage.PrefixBounds(endKey)
+	}
+
 	entries, err := idxTree.RangeSearch(startKey, endKey)
 	if err != nil {
 		return nil, fmt.Errorf("range search failed: %w", err)
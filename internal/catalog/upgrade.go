@@ -0,0 +1,141 @@
+package catalog
+
+import (
+	"fmt"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+// Upgrade converts the database, opened with storage.OpenPagerToUpgrade,
+// to storage.FormatVersion in place and in one batch. A file older than
+// format version 3 has the int and float keys of its tables rewritten, see
+// storage.Pager.MigrateKeys. One older than version 4 has every index
+// rebuilt from its table, which stores multi-column keys, and the keys of
+// indexes that are not unique, as composite keys and gives index pages
+// written before version 2 key prefixes. The rows of its clustered tables
+// are first stored again under composite keys. It returns the names of the
+// indexes rebuilt.
+func (c *Catalog) Upgrade() ([]string, error) {
+	var rebuilt []string
+	err := c.atomically(func() error {
+		version := c.pager.FormatVersion()
+		if version >= 4 {
+			return c.pager.RaiseFormatVersion()
+		}
+
+		refs, err := c.trees()
+		if err != nil {
+			return err
+		}
+		var lsmRoots []uint32
+		for _, ref := range refs {
+			if ref.kind == "table" && ref.engine == EngineLSM {
+				lsmRoots = append(lsmRoots, ref.root)
+			}
+		}
+		if version < 3 {
+			if err := c.pager.MigrateKeys(lsmRoots); err != nil {
+				return err
+			}
+		}
+
+		var tables []string
+		// Each table comes before its indexes, which hold its row keys.
+		for _, ref := range refs {
+			if ref.kind == "table" {
+				if err := c.rekeyClustered(ref); err != nil {
+					return fmt.Errorf("failed to rekey table %s: %w", ref.name, err)
+				}
+				continue
+			}
+			if err := c.rebuildIndex(ref); err != nil {
+				return fmt.Errorf("failed to rebuild index %s: %w", ref.name, err)
+			}
+			rebuilt = append(rebuilt, ref.name)
+			tables = append(tables, ref.table)
+		}
+		c.schemaChanged(tables...)
+		return c.pager.RaiseFormatVersion()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rebuilt, nil
+}
+
+// rebuildIndex fills a new tree for the index ref from its table and frees
+// the old one.
+func (c *Catalog) rebuildIndex(ref treeRef) error {
+	index, err := c.getIndexUnsafe(ref.name)
+	if err != nil {
+		return err
+	}
+	table, err := c.getTableUnsafe(index.TableName)
+	if err != nil {
+		return err
+	}
+
+	tree, err := storage.NewBTree(c.pager, true)
+	if err != nil {
+		return err
+	}
+	if err := tree.SetFillFactor(index.FillFactor); err != nil {
+		return err
+	}
+	// The primary key index of a table keyed by its primary key stays empty.
+	if !(&Table{schema: table}).unusedIndex(index) {
+		if err := c.populateIndex(index, table, tree); err != nil {
+			return err
+		}
+	}
+
+	old, err := storage.LoadBTree(c.pager, ref.root, true)
+	if err != nil {
+		return err
+	}
+	if err := old.Free(); err != nil {
+		return err
+	}
+	return c.moveRoot(ref, tree.GetRootPage())
+}
+
+// rekeyClustered stores the rows of the table ref, if it is clustered, under
+// the composite keys of clusterRowKey, in place of the text keys files
+// before version 4 encoded their values in.
+func (c *Catalog) rekeyClustered(ref treeRef) error {
+	schema, err := c.getTableUnsafe(ref.name)
+	if err != nil {
+		return err
+	}
+	if !schema.Clustered() {
+		return nil
+	}
+
+	store, err := c.openStore(schema)
+	if err != nil {
+		return err
+	}
+	entries, err := store.Scan()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		row, err := decodeRow(schema, entry.Value)
+		if err != nil {
+			return fmt.Errorf("failed to deserialize row: %w", err)
+		}
+		key, err := clusterRowKey(row, schema)
+		if err != nil {
+			return err
+		}
+		// The old text keys sort before every composite key, so the
+		// two never meet.
+		if err := store.Delete(entry.Key); err != nil {
+			return err
+		}
+		if err := store.Insert(key, entry.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
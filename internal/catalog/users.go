@@ -0,0 +1,206 @@
+package catalog
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+type Privilege string
+
+const (
+	PrivSelect Privilege = "SELECT"
+	PrivInsert Privilege = "INSERT"
+	PrivUpdate Privilege = "UPDATE"
+	PrivDelete Privilege = "DELETE"
+	PrivDDL    Privilege = "DDL"
+)
+
+var AllPrivileges = []Privilege{PrivSelect, PrivInsert, PrivUpdate, PrivDelete, PrivDDL}
+
+// AllTables is the grant target that covers every table, including ones
+// created after the grant.
+const AllTables = "*"
+
+const passwordHashRounds = 100000
+
+func ParsePrivilege(s string) (Privilege, bool) {
+	priv := Privilege(strings.ToUpper(s))
+	return priv, slices.Contains(AllPrivileges, priv)
+}
+
+// User is a database account. Privileges maps a table name, or AllTables, to
+// what the user may do with it. Passwords are kept only as a salted hash.
+type User struct {
+	Name       string                 `json:"name"`
+	Salt       string                 `json:"salt"`
+	Hash       string                 `json:"hash"`
+	Privileges map[string][]Privilege `json:"privileges,omitempty"`
+}
+
+func (u *User) Can(priv Privilege, table string) bool {
+	return slices.Contains(u.Privileges[table], priv) || slices.Contains(u.Privileges[AllTables], priv)
+}
+
+func userKey(name string) storage.Key {
+	return stringToKey("user:" + name)
+}
+
+func hashPassword(password string, salt []byte) []byte {
+	sum := []byte(password)
+	for i := 0; i < passwordHashRounds; i++ {
+		h := sha256.New()
+		h.Write(salt)
+		h.Write(sum)
+		sum = h.Sum(nil)
+	}
+	return sum
+}
+
+// SetRandom sets the source of password salts, crypto/rand by default. A
+// seeded source makes CREATE USER repeatable.
+func (c *Catalog) SetRandom(r io.Reader) {
+	c.random = r
+}
+
+func (c *Catalog) CreateUser(name, password string) error {
+	if name == "" {
+		return sqlerr.New(sqlerr.InvalidTableDefinition, "user name cannot be empty")
+	}
+	if password == "" {
+		return sqlerr.New(sqlerr.InvalidPassword, "password cannot be empty")
+	}
+	if _, err := c.GetUser(name); err == nil {
+		return sqlerr.New(sqlerr.DuplicateObject, "user '%s' already exists", name)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(c.random, salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	user := &User{
+		Name: name,
+		Salt: hex.EncodeToString(salt),
+		Hash: hex.EncodeToString(hashPassword(password, salt)),
+	}
+
+	return c.saveUser(user, true)
+}
+
+func (c *Catalog) GetUser(name string) (*User, error) {
+	value, err := c.tree.Search(userKey(name))
+	if err != nil {
+		return nil, sqlerr.New(sqlerr.UndefinedObject, "user '%s' does not exist", name)
+	}
+
+	var meta metadataEntry
+	if err := json.Unmarshal(value, &meta); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+
+	if meta.Type != "user" {
+		return nil, fmt.Errorf("entry for '%s' is not a user", name)
+	}
+
+	var user User
+	if err := json.Unmarshal(meta.Data, &user); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// Authenticate returns the user if password matches. The error does not say
+// whether the user exists.
+func (c *Catalog) Authenticate(name, password string) (*User, error) {
+	user, err := c.GetUser(name)
+	if err != nil {
+		return nil, sqlerr.New(sqlerr.InvalidPassword, "authentication failed")
+	}
+
+	salt, err := hex.DecodeString(user.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt salt for user '%s': %w", name, err)
+	}
+	hash, err := hex.DecodeString(user.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt password hash for user '%s': %w", name, err)
+	}
+
+	if subtle.ConstantTimeCompare(hashPassword(password, salt), hash) != 1 {
+		return nil, sqlerr.New(sqlerr.InvalidPassword, "authentication failed")
+	}
+
+	return user, nil
+}
+
+func (c *Catalog) Grant(name, table string, privs []Privilege) error {
+	user, err := c.GetUser(name)
+	if err != nil {
+		return err
+	}
+
+	if user.Privileges == nil {
+		user.Privileges = make(map[string][]Privilege)
+	}
+	for _, priv := range privs {
+		if !slices.Contains(user.Privileges[table], priv) {
+			user.Privileges[table] = append(user.Privileges[table], priv)
+		}
+	}
+
+	return c.saveUser(user, false)
+}
+
+func (c *Catalog) Revoke(name, table string, privs []Privilege) error {
+	user, err := c.GetUser(name)
+	if err != nil {
+		return err
+	}
+
+	kept := slices.DeleteFunc(user.Privileges[table], func(p Privilege) bool {
+		return slices.Contains(privs, p)
+	})
+	if len(kept) == 0 {
+		delete(user.Privileges, table)
+	} else {
+		user.Privileges[table] = kept
+	}
+
+	return c.saveUser(user, false)
+}
+
+func (c *Catalog) saveUser(user *User, create bool) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to marshal user: %w", err)
+	}
+
+	metaBytes, err := json.Marshal(metadataEntry{
+		Type: "user",
+		Data: data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	key := userKey(user.Name)
+	if create {
+		err = c.tree.Insert(key, metaBytes)
+	} else {
+		err = c.tree.Update(key, metaBytes)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save user '%s': %w", user.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package catalog
+
+import (
+	"fmt"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+// EnablePointerMap makes the database keep the pointer map that
+// IncrementalVacuum needs. Only a database without tables can start
+// keeping one.
+func (c *Catalog) EnablePointerMap() error {
+	return c.atomically(c.pager.EnablePointerMap)
+}
+
+// IncrementalVacuum shrinks the file by up to limit pages, or as far as it
+// can if limit is 0, by moving pages at its end into free pages; see
+// storage.Pager.IncrementalVacuum. The roots of tables, indexes and the
+// trees inside LSM tables move too, and their new places are recorded. It
+// returns the number of pages released.
+func (c *Catalog) IncrementalVacuum(limit int) (int, error) {
+	var released int
+	err := c.atomically(func() error {
+		refs, err := c.trees()
+		if err != nil {
+			return err
+		}
+		owned := make(map[uint32]bool)
+		lsms := make(map[string]*storage.LSMTree)
+		for _, ref := range refs {
+			if ref.name == SystemCatalogTable {
+				continue
+			}
+			owned[ref.root] = true
+			if ref.engine != EngineLSM {
+				continue
+			}
+			tree, err := storage.LoadLSMTree(c.pager, ref.root)
+			if err != nil {
+				return fmt.Errorf("failed to load table %s: %w", ref.name, err)
+			}
+			roots, err := tree.Roots()
+			if err != nil {
+				return fmt.Errorf("failed to load table %s: %w", ref.name, err)
+			}
+			for _, root := range roots {
+				owned[root] = true
+			}
+			lsms[ref.name] = tree
+		}
+
+		var moved map[uint32]uint32
+		released, moved, err = c.pager.IncrementalVacuum(limit, func(root uint32) bool { return owned[root] })
+		if err != nil || released == 0 {
+			return err
+		}
+
+		for _, tree := range c.lsmTrees {
+			tree.Invalidate()
+		}
+		c.lsmTrees = make(map[uint32]*storage.LSMTree)
+		var tables []string
+		for _, ref := range refs {
+			if lsm := lsms[ref.name]; lsm != nil {
+				if err := lsm.RootsMoved(moved); err != nil {
+					return fmt.Errorf("failed to move table %s: %w", ref.name, err)
+				}
+			}
+			to, ok := moved[ref.root]
+			if !ok {
+				continue
+			}
+			if err := c.moveRoot(ref, to); err != nil {
+				return err
+			}
+			tables = append(tables, ref.table)
+		}
+		// Other handles must not keep the moved pages cached.
+		c.schemaChanged(tables...)
+		return nil
+	})
+	return released, err
+}
+
+// moveRoot records that the root of the table or index ref is now at to.
+func (c *Catalog) moveRoot(ref treeRef, to uint32) error {
+	if ref.kind == "index" {
+		index, err := c.getIndexUnsafe(ref.name)
+		if err != nil {
+			return err
+		}
+		if err := c.tree.Delete(stringToKey(ref.name)); err != nil {
+			return fmt.Errorf("failed to delete index metadata: %w", err)
+		}
+		moved := *index
+		moved.RootPage = to
+		if err := c.saveIndex(&moved); err != nil {
+			return err
+		}
+		c.indexCache.Put(ref.name, &moved)
+		return nil
+	}
+
+	schema, err := c.getTableUnsafe(ref.name)
+	if err != nil {
+		return err
+	}
+	if err := c.tree.Delete(stringToKey(ref.name)); err != nil {
+		return fmt.Errorf("failed to delete table metadata: %w", err)
+	}
+	moved := *schema
+	moved.RootPage = to
+	if err := c.saveTable(&moved); err != nil {
+		return err
+	}
+	c.tableCache.Put(ref.name, &moved)
+	return nil
+}
@@ -50,10 +50,6 @@ func CreateRow(schema *Schema, values []interface{}) (*Row, error) {
 	}
 
 	for i, col := range schema.Columns {
-		if col.NotNull && values[i] == nil {
-			return nil, fmt.Errorf("column '%s' cannot be NULL", col.Name)
-		}
-
 		row.Values[col.Name] = RowValue{
 			Type:  col.Type,
 			Value: values[i],
@@ -63,7 +59,13 @@ func CreateRow(schema *Schema, values []interface{}) (*Row, error) {
 	return row, nil
 }
 
+// GetPrimaryKeyValue returns the key row is stored under in its table: its
+// primary key or rowid, or for a clustered table its cluster key followed by
+// its primary key.
 func GetPrimaryKeyValue(row *Row, schema *Schema) (storage.Key, error) {
+	if schema.Clustered() {
+		return clusterRowKey(row, schema)
+	}
 	for _, col := range schema.Columns {
 		if col.PrimaryKey {
 			value, colType, err := ExtractColumnValue(row, col.Name)
@@ -73,30 +75,25 @@ func GetPrimaryKeyValue(row *Row, schema *Schema) (storage.Key, error) {
 			return ValueToKey(value, colType)
 		}
 	}
-	return nil, errors.New("no primary key column found")
-}
 
-func ValidateRow(row *Row, schema *Schema) error {
-	for _, col := range schema.Columns {
-		rowValue, exists := row.Values[col.Name]
-		if !exists {
-			if col.NotNull {
-				return fmt.Errorf("column '%s' is required (NOT NULL)", col.Name)
-			}
-			continue
-		}
+	if rowid, exists := row.Values[RowIDColumn]; exists {
+		return ValueToKey(rowid.Value, TypeInt)
+	}
 
-		if rowValue.Type != col.Type {
-			return fmt.Errorf("column '%s' type mismatch: expected %s, got %s",
-				col.Name, col.Type, rowValue.Type)
-		}
+	return nil, errors.New("no primary key column found")
+}
 
-		if col.NotNull && rowValue.Value == nil {
-			return fmt.Errorf("column '%s' cannot be NULL", col.Name)
-		}
+// compositeKey packs the keys of the columns of an index key into one key,
+// which sorts by the columns in turn. A single column keeps its own key, and
+// no keys, for a NULL column, make no key.
+func compositeKey(keys []storage.Key) storage.Key {
+	switch len(keys) {
+	case 0:
+		return nil
+	case 1:
+		return keys[0]
 	}
-
-	return nil
+	return storage.NewCompositeKey(keys...)
 }
 
 func ValueToKey(value interface{}, columnType ColumnType) (storage.Key, error) {
@@ -112,7 +109,7 @@ func ValueToKey(value interface{}, columnType ColumnType) (storage.Key, error) {
 		default:
 			return nil, fmt.Errorf("invalid int value type: %T", value)
 		}
-	case TypeText:
+	case TypeText, TypeJSON, TypeEnum:
 		str, ok := value.(string)
 		if !ok {
 			return nil, fmt.Errorf("invalid text value type: %T", value)
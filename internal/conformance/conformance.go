@@ -0,0 +1,308 @@
+// Package conformance pins down the on-disk encoding of keys, which must
+// be the same whatever machine writes or reads a file: every number is
+// big-endian, and nothing depends on the host's byte order or word size.
+// Vectors lists keys of each type with the bytes they encode to, and
+// keys.db is a file of them written on amd64, which every other
+// architecture must read back byte for byte. keys-v2.db is the same file
+// written by format version 2, before int and float keys were encoded to
+// sort in order and without composite keys, which must upgrade to keys.db.
+package conformance
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+// Vector is a key and the hex of its encoding.
+type Vector struct {
+	Key     storage.Key
+	Encoded string
+}
+
+// Vectors lists keys in ascending order, the types by their tags. Each
+// type gets one tree in a file written by Write.
+var Vectors = []Vector{
+	{storage.NewIntKey(math.MinInt64), "010000000000000000"},
+	{storage.NewIntKey(-256), "017fffffffffffff00"},
+	{storage.NewIntKey(-1), "017fffffffffffffff"},
+	{storage.NewIntKey(0), "018000000000000000"},
+	{storage.NewIntKey(1), "018000000000000001"},
+	{storage.NewIntKey(256), "018000000000000100"},
+	{storage.NewIntKey(math.MaxInt64), "01ffffffffffffffff"},
+
+	{storage.NewTextKey(""), "0200000000"},
+	{storage.NewTextKey("A"), "020000000141"},
+	{storage.NewTextKey("a"), "020000000161"},
+	{storage.NewTextKey("ab"), "02000000026162"},
+	{storage.NewTextKey("b"), "020000000162"},
+	{storage.NewTextKey("é"), "0200000002c3a9"},
+
+	{storage.NewFloatKey(math.Inf(-1)), "03000fffffffffffff"},
+	{storage.NewFloatKey(-math.MaxFloat64), "030010000000000000"},
+	{storage.NewFloatKey(-1.5), "034007ffffffffffff"},
+	{storage.NewFloatKey(-math.SmallestNonzeroFloat64), "037ffffffffffffffe"},
+	{storage.NewFloatKey(0), "038000000000000000"},
+	{storage.NewFloatKey(math.SmallestNonzeroFloat64), "038000000000000001"},
+	{storage.NewFloatKey(1.5), "03bff8000000000000"},
+	{storage.NewFloatKey(math.MaxFloat64), "03ffefffffffffffff"},
+	{storage.NewFloatKey(math.Inf(1)), "03fff0000000000000"},
+	{storage.NewFloatKey(math.NaN()), "03fff8000000000000"},
+
+	{storage.NewBooleanKey(false), "0400"},
+	{storage.NewBooleanKey(true), "0401"},
+
+	{storage.NewCompositeKey(), "0500"},
+	{storage.NewCompositeKey(storage.NewIntKey(-1)), "05017fffffffffffffff00"},
+	{storage.NewCompositeKey(storage.NewIntKey(-1), storage.NewTextKey("")), "05017fffffffffffffff02000100"},
+	{storage.NewCompositeKey(storage.NewIntKey(-1), storage.NewTextKey("a")), "05017fffffffffffffff0261000100"},
+	{storage.NewCompositeKey(storage.NewIntKey(-1), storage.NewTextKey("a\x00")), "05017fffffffffffffff026100ff000100"},
+	{storage.NewCompositeKey(storage.NewIntKey(-1), storage.NewTextKey("ab")), "05017fffffffffffffff026162000100"},
+	{storage.NewCompositeKey(storage.NewIntKey(-1), storage.NewFloatKey(math.Inf(-1))), "05017fffffffffffffff03000fffffffffffff00"},
+	{storage.NewCompositeKey(storage.NewIntKey(2), storage.NewBooleanKey(false)), "05018000000000000002040000"},
+	{storage.NewCompositeKey(storage.NewTextKey("b"), storage.NewIntKey(0)), "050262000101800000000000000000"},
+	{storage.NewCompositeKey(storage.NewCompositeKey(storage.NewIntKey(1)), storage.NewIntKey(2)), "05050180000000000000010001800000000000000200"},
+	{storage.NewCompositeKey(storage.NewCompositeKey(storage.NewIntKey(1), storage.NewIntKey(0))), "05050180000000000000010180000000000000000000"},
+}
+
+// Aliases are keys that equal one in Vectors and must encode like it.
+var Aliases = []Vector{
+	{storage.NewFloatKey(math.Copysign(0, -1)), "038000000000000000"},
+	{storage.NewFloatKey(math.Float64frombits(0x7ff0000000000001)), "03fff8000000000000"},
+	{storage.NewFloatKey(math.Float64frombits(0xfff8000000000000)), "03fff8000000000000"},
+}
+
+var (
+	//go:embed keys.db
+	fixture []byte
+	//go:embed keys-v2.db
+	fixtureV2 []byte
+)
+
+// CheckEncodings checks that every vector and alias encodes to its bytes
+// and decodes to an equal key that encodes the same, and that the vectors
+// sort in the order listed. The encodings of all but text keys must sort
+// in that order too; those of text keys need not, since they start with
+// the text's length.
+func CheckEncodings() error {
+	for _, v := range append(append([]Vector(nil), Vectors...), Aliases...) {
+		want, err := hex.DecodeString(v.Encoded)
+		if err != nil {
+			return fmt.Errorf("%s: bad vector %q: %w", v.Key, v.Encoded, err)
+		}
+		if got := v.Key.Encode(); !bytes.Equal(got, want) {
+			return fmt.Errorf("%s encodes to %x, want %s", v.Key, got, v.Encoded)
+		}
+		decoded, err := storage.DecodeKey(want)
+		if err != nil {
+			return fmt.Errorf("%s: %w", v.Encoded, err)
+		}
+		if decoded.Compare(v.Key) != 0 || v.Key.Compare(decoded) != 0 {
+			return fmt.Errorf("%s decodes to %s, which does not equal %s", v.Encoded, decoded, v.Key)
+		}
+		if got := decoded.Encode(); !bytes.Equal(got, want) {
+			return fmt.Errorf("%s decodes to %s, which encodes to %x", v.Encoded, decoded, got)
+		}
+	}
+
+	for i := 1; i < len(Vectors); i++ {
+		a, b := Vectors[i-1].Key, Vectors[i].Key
+		if a.Compare(b) >= 0 || b.Compare(a) <= 0 {
+			return fmt.Errorf("%s does not sort before %s", a, b)
+		}
+		if a.Type() != storage.KeyTypeText && bytes.Compare(a.Encode(), b.Encode()) >= 0 {
+			return fmt.Errorf("the encoding of %s does not sort before that of %s", a, b)
+		}
+	}
+	return nil
+}
+
+// groups splits Vectors into one list per key type, in order.
+func groups() [][]Vector {
+	var groups [][]Vector
+	for i, v := range Vectors {
+		if i == 0 || v.Key.Type() != Vectors[i-1].Key.Type() {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], v)
+	}
+	return groups
+}
+
+// Write creates the database file path in vfs with one tree per key type,
+// rooted at pages 1 onwards, each holding that type's vectors. The keys
+// are inserted in descending order.
+func Write(vfs storage.VFS, path string) error {
+	if exists, err := vfs.Exists(path); err != nil || exists {
+		if err == nil {
+			err = fmt.Errorf("%s already exists", path)
+		}
+		return err
+	}
+	pager, err := storage.OpenPager(vfs, path)
+	if err != nil {
+		return err
+	}
+	if err := pager.Begin(); err != nil {
+		pager.Close()
+		return err
+	}
+	for _, group := range groups() {
+		tree, err := storage.NewBTree(pager, false)
+		if err == nil {
+			for i := len(group) - 1; i >= 0 && err == nil; i-- {
+				err = tree.Insert(group[i].Key, []byte(group[i].Key.String()))
+			}
+		}
+		if err != nil {
+			pager.Rollback()
+			pager.Close()
+			return err
+		}
+	}
+	if err := pager.Commit(); err != nil {
+		pager.Close()
+		return err
+	}
+	return pager.Close()
+}
+
+// Verify checks a file written by Write: each tree's single leaf must hold
+// its type's vectors in order, every key stored as exactly its encoding.
+func Verify(vfs storage.VFS, path string) error {
+	return verify(vfs, path, groups())
+}
+
+func verify(vfs storage.VFS, path string, groups [][]Vector) error {
+	pager, err := storage.OpenPager(vfs, path)
+	if err != nil {
+		return err
+	}
+	defer pager.Close()
+
+	for i, group := range groups {
+		root := uint32(i + 1)
+		if err := verifyTree(pager, root, group); err != nil {
+			return fmt.Errorf("tree at page %d: %w", root, err)
+		}
+	}
+	return nil
+}
+
+func verifyTree(pager *storage.Pager, root uint32, group []Vector) error {
+	page, err := pager.ReadPage(root)
+	if err != nil {
+		return err
+	}
+	defer pager.ReleasePage(page)
+	if page.Header.PageType != storage.PageTypeLeafTable {
+		return fmt.Errorf("page type is %s, want a table leaf", page.Header.PageType)
+	}
+	if int(page.Header.NumCells) != len(group) {
+		return fmt.Errorf("%d cells, want %d", page.Header.NumCells, len(group))
+	}
+
+	for i, v := range group {
+		off, err := page.GetCellPointer(uint16(i))
+		if err != nil {
+			return err
+		}
+		if int(off)+4 > len(page.Data) {
+			return fmt.Errorf("cell %d is out of bounds", i)
+		}
+		keyLen := int(binary.BigEndian.Uint32(page.Data[off : off+4]))
+		start := int(off) + 4
+		if start+keyLen > len(page.Data) {
+			return fmt.Errorf("cell %d is out of bounds", i)
+		}
+		if got := hex.EncodeToString(page.Data[start : start+keyLen]); got != v.Encoded {
+			return fmt.Errorf("cell %d holds key %s, want %s (%s)", i, got, v.Encoded, v.Key)
+		}
+	}
+
+	tree, err := storage.LoadBTree(pager, root, false)
+	if err != nil {
+		return err
+	}
+	entries, err := tree.Scan()
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		if entry.Key.Compare(group[i].Key) != 0 {
+			return fmt.Errorf("entry %d is %s, want %s", i, entry.Key, group[i].Key)
+		}
+	}
+	return nil
+}
+
+// VerifyFixture runs Verify on keys.db, the file Write made on amd64.
+func VerifyFixture() error {
+	vfs, err := load(fixture)
+	if err != nil {
+		return err
+	}
+	return Verify(vfs, fixturePath)
+}
+
+// VerifyUpgrade migrates the keys of keys-v2.db, see
+// storage.Pager.MigrateKeys, and runs Verify on the result, for the key
+// types it has.
+func VerifyUpgrade() error {
+	vfs, err := load(fixtureV2)
+	if err != nil {
+		return err
+	}
+	pager, err := storage.OpenPagerToUpgrade(vfs, fixturePath)
+	if err != nil {
+		return err
+	}
+	if pager.FormatVersion() != 2 {
+		pager.Close()
+		return fmt.Errorf("keys-v2.db has format version %d", pager.FormatVersion())
+	}
+	err = pager.Begin()
+	if err == nil {
+		if err = pager.MigrateKeys(nil); err == nil {
+			err = pager.RaiseFormatVersion()
+		}
+		if err == nil {
+			err = pager.Commit()
+		} else {
+			pager.Rollback()
+		}
+	}
+	if closeErr := pager.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	var v2 [][]Vector
+	for _, group := range groups() {
+		if group[0].Key.Type() <= storage.KeyTypeBoolean {
+			v2 = append(v2, group)
+		}
+	}
+	return verify(vfs, fixturePath, v2)
+}
+
+const fixturePath = "/keys.db"
+
+// load puts a fixture in a new MemFS at fixturePath.
+func load(data []byte) (storage.VFS, error) {
+	vfs := storage.NewMemFS()
+	file, err := vfs.Create(fixturePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return nil, err
+	}
+	return vfs, file.Close()
+}
@@ -0,0 +1,303 @@
+// Package crashtest checks that a database in WAL mode survives a crash at
+// any point. It runs a workload in a storage.FaultFS once to count the
+// file system calls it makes, then once more for each of them with a fault
+// injected there, and after every crash reopens the database and checks
+// that it holds exactly the transactions that committed.
+package crashtest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/engine"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+const dbFile = "/crashtest/crash.db"
+
+type Config struct {
+	// Transactions is the number of transactions the workload runs, each
+	// as one batch.
+	Transactions int
+	// Rows is the number of rows each transaction inserts into each table.
+	Rows int
+	// SegmentSize is the WAL segment size, small so that the workload
+	// goes through several checkpoints.
+	SegmentSize int64
+	// Step injects a fault at every Step-th call only.
+	Step int
+	// Torn runs every fault point a second time with a torn write.
+	Torn bool
+	// KeepUnsynced keeps unsynced writes when crashing instead of losing
+	// them.
+	KeepUnsynced bool
+	Seed         int64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Transactions: 40,
+		Rows:         5,
+		SegmentSize:  64 * 1024,
+		Step:         1,
+		Torn:         true,
+		Seed:         1,
+	}
+}
+
+// Result counts the file system calls the workload makes and the crashes
+// recovered from.
+type Result struct {
+	Calls   int
+	Crashes int
+}
+
+// Run counts the workload's calls and then crashes it at each one in turn,
+// writing a line to progress, if not nil, per hundred crashes. It stops at
+// the first crash the database does not recover from.
+func Run(cfg Config, progress io.Writer) (Result, error) {
+	if cfg.Transactions <= 0 || cfg.Rows <= 0 || cfg.SegmentSize <= 0 || cfg.Step <= 0 {
+		return Result{}, fmt.Errorf("transactions, rows, segment size and step must be positive")
+	}
+
+	var result Result
+	fs, err := setup(cfg)
+	if err != nil {
+		return result, fmt.Errorf("setup failed: %w", err)
+	}
+	faults := storage.NewFaultFS(fs, storage.Fault{})
+	if _, err := workload(cfg, faults); err != nil {
+		return result, fmt.Errorf("workload failed without a fault: %w", err)
+	}
+	if err := verify(cfg, fs, cfg.Transactions, cfg.Transactions); err != nil {
+		return result, fmt.Errorf("workload without a fault: %w", err)
+	}
+	result.Calls = faults.Calls()
+
+	modes := []bool{false}
+	if cfg.Torn {
+		modes = append(modes, true)
+	}
+	for at := 1; at <= result.Calls; at += cfg.Step {
+		for _, torn := range modes {
+			if err := crash(cfg, storage.Fault{At: at, Torn: torn, KeepUnsynced: cfg.KeepUnsynced}); err != nil {
+				return result, err
+			}
+			result.Crashes++
+			if progress != nil && result.Crashes%100 == 0 {
+				fmt.Fprintf(progress, "%d crashes recovered from\n", result.Crashes)
+			}
+		}
+	}
+	return result, nil
+}
+
+// crash runs the workload until fault fires and checks the database that
+// is left.
+func crash(cfg Config, fault storage.Fault) error {
+	fs, err := setup(cfg)
+	if err != nil {
+		return fmt.Errorf("setup failed: %w", err)
+	}
+	faults := storage.NewFaultFS(fs, fault)
+	committed, err := workload(cfg, faults)
+	if err != nil && !errors.Is(err, storage.ErrFault) {
+		return fmt.Errorf("fault at call %d (%s): %w", fault.At, faults.Fired(), err)
+	}
+	if err := faults.Crash(); err != nil {
+		return err
+	}
+
+	// The transaction running at the fault may have reached the WAL.
+	if err := verify(cfg, fs, committed, min(committed+1, cfg.Transactions)); err != nil {
+		desc := faults.Fired()
+		if fault.Torn {
+			desc = "torn " + desc
+		}
+		return fmt.Errorf("fault at call %d (%s) after %d transactions: %w", fault.At, desc, committed, err)
+	}
+	return nil
+}
+
+// setup creates the database in WAL mode in a new MemFS. A crash while
+// creating it is not tested, since creating tables outside WAL mode is not
+// atomic.
+func setup(cfg Config) (*storage.MemFS, error) {
+	fs := storage.NewMemFS()
+	db, err := engine.OpenEngine(fs, dbFile)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	for _, sql := range []string{
+		"CREATE TABLE counter (id INT PRIMARY KEY, n INT)",
+		"CREATE TABLE items (id INT PRIMARY KEY, txn INT, code INT, data TEXT)",
+		"CREATE INDEX idx_items_code ON items (code)",
+		"CREATE TABLE events (id INT PRIMARY KEY, txn INT, data TEXT) ENGINE = lsm",
+		"INSERT INTO counter VALUES (1, 0)",
+	} {
+		if err := exec(db, sql); err != nil {
+			return nil, err
+		}
+	}
+	if err := db.EnableWAL(storage.WALConfig{SegmentSize: cfg.SegmentSize}); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// workload runs the transactions against the database in fs and closes
+// it. It returns how many transactions committed before the first error.
+func workload(cfg Config, fs storage.VFS) (int, error) {
+	db, err := engine.OpenEngine(fs, dbFile)
+	if err != nil {
+		return 0, err
+	}
+	// The segment size is not stored in the database.
+	if err := db.EnableWAL(storage.WALConfig{SegmentSize: cfg.SegmentSize}); err != nil {
+		db.Close()
+		return 0, err
+	}
+	rng := rand.New(rand.NewSource(cfg.Seed))
+
+	committed := 0
+	for txn := 1; txn <= cfg.Transactions; txn++ {
+		err := db.Batch(func() error {
+			for _, sql := range transaction(cfg, rng, txn) {
+				if err := exec(db, sql); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return committed, err
+		}
+		committed++
+	}
+	return committed, db.Close()
+}
+
+// transaction returns the statements of transaction txn. It inserts Rows
+// rows into each table, deletes those of the transaction three before it
+// when txn is a multiple of five, and records txn in the counter.
+func transaction(cfg Config, rng *rand.Rand, txn int) []string {
+	var stmts []string
+	for i := 0; i < cfg.Rows; i++ {
+		id := txn*cfg.Rows + i
+		data := strings.Repeat("x", rng.Intn(400))
+		stmts = append(stmts,
+			fmt.Sprintf("INSERT INTO items VALUES (%d, %d, %d, '%s')", id, txn, code(id), data),
+			fmt.Sprintf("INSERT INTO events VALUES (%d, %d, '%s')", id, txn, data))
+	}
+	if txn%5 == 0 {
+		stmts = append(stmts,
+			fmt.Sprintf("DELETE FROM items WHERE txn = %d", txn-3),
+			fmt.Sprintf("DELETE FROM events WHERE txn = %d", txn-3))
+	}
+	return append(stmts, fmt.Sprintf("UPDATE counter SET n = %d WHERE id = 1", txn))
+}
+
+// code is the indexed column of item id.
+func code(id int) int {
+	return id * 7
+}
+
+// live reports whether the rows of transaction txn are in the database once
+// n transactions have committed.
+func live(txn, n int) bool {
+	return txn <= n && !((txn+3)%5 == 0 && txn+3 <= n)
+}
+
+// verify reopens the database in fs and checks that its trees are valid
+// and that it holds the first n transactions, for some n from least to
+// most. It then commits one more change to check the database still
+// takes writes.
+func verify(cfg Config, fs storage.VFS, least, most int) error {
+	db, err := engine.OpenEngine(fs, dbFile)
+	if err != nil {
+		return fmt.Errorf("failed to reopen: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.CheckIntegrity(); err != nil {
+		return err
+	}
+	n, err := queryInt(db, "SELECT n FROM counter WHERE id = 1")
+	if err != nil {
+		return err
+	}
+	if n < int64(least) || n > int64(most) {
+		return fmt.Errorf("counter is %d, want %d to %d", n, least, most)
+	}
+
+	var rows int64
+	for txn := 1; txn <= int(n); txn++ {
+		if live(txn, int(n)) {
+			rows += int64(cfg.Rows)
+		}
+	}
+	type check struct {
+		sql  string
+		want int64
+	}
+	var checks []check
+	for _, table := range []string{"items", "events"} {
+		checks = append(checks,
+			check{fmt.Sprintf("SELECT COUNT(*) FROM %s", table), rows},
+			check{fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE txn > %d", table, n), 0})
+		if n > 0 {
+			// The last transaction's rows are not deleted yet.
+			checks = append(checks, check{fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE txn = %d", table, n), int64(cfg.Rows)})
+		}
+	}
+	if n > 0 {
+		checks = append(checks, check{fmt.Sprintf("SELECT COUNT(*) FROM items WHERE code = %d", code(int(n)*cfg.Rows)), 1})
+	}
+	for _, check := range checks {
+		got, err := queryInt(db, check.sql)
+		if err != nil {
+			return err
+		}
+		if got != check.want {
+			return fmt.Errorf("%s returned %d, want %d", check.sql, got, check.want)
+		}
+	}
+
+	return exec(db, fmt.Sprintf("UPDATE counter SET n = %d WHERE id = 1", n))
+}
+
+func exec(db *engine.Engine, sql string) error {
+	node, err := parser.Parse(sql)
+	if err != nil {
+		return err
+	}
+	_, err = db.Run(node)
+	return err
+}
+
+// queryInt runs a query returning a single integer.
+func queryInt(db *engine.Engine, sql string) (int64, error) {
+	node, err := parser.Parse(sql)
+	if err != nil {
+		return 0, err
+	}
+	cursor, err := db.Query(node)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	rows := cursor.Fetch(2)
+	if len(rows) != 1 || len(rows[0]) != 1 {
+		return 0, fmt.Errorf("%s: expected one value, got %v", sql, rows)
+	}
+	return strconv.ParseInt(fmt.Sprint(rows[0][0]), 10, 64)
+}
@@ -0,0 +1,22 @@
+package crashtest
+
+import "testing"
+
+// TestRun crashes a short workload at a sample of its calls, so that go
+// test catches a recovery bug without the full run of anubisdb crashtest.
+func TestRun(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Transactions = 20
+	cfg.Rows = 3
+	cfg.SegmentSize = 8 * 1024
+	cfg.Step = 3
+
+	result, err := Run(cfg, nil)
+	if err != nil {
+		t.Fatalf("after %d crashes: %v", result.Crashes, err)
+	}
+	if result.Crashes == 0 {
+		t.Fatalf("%d calls but no crashes tried", result.Calls)
+	}
+	t.Logf("%d calls, %d crashes recovered from", result.Calls, result.Crashes)
+}
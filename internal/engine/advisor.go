@@ -0,0 +1,220 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// Advice is a maintenance action Advise recommends.
+type Advice struct {
+	// Action is the statement or CLI command that carries it out, such as
+	// "ANALYZE orders" or ".reindex idx_orders_day".
+	Action string
+	// Object is the table or index it applies to, empty for the file.
+	Object string
+	Reason string
+	// Benefit estimates what carrying it out gains.
+	Benefit string
+	// Pages estimates the pages it frees, 0 for one that only makes
+	// queries faster.
+	Pages int
+}
+
+const (
+	// adviseMinPages keeps trees too small to matter from being rebuilt.
+	adviseMinPages = 8
+
+	// adviseMinRuns is the number of runs from which an LSM table is worth
+	// compacting; merges keep a few runs in any table that is written.
+	adviseMinRuns = 3
+)
+
+// Advise inspects the tables and indexes of the main database and
+// recommends, table by table, the maintenance that would pay off:
+//
+//   - ANALYZE for a table whose row count has drifted from its statistics
+//     by at least auto_analyze's default of 10% and 50 rows, or that has a
+//     non-unique index and was never analyzed
+//   - .reindex for an index of at least 8 pages that rebuilding would
+//     shrink by a quarter
+//   - .compact for an LSM table with 3 runs or more
+//   - .vacuum for the free pages of a file with a pointer map
+//
+// It changes nothing. Like dbstat it reads every page of every tree.
+func (e *Engine) Advise() ([]Advice, error) {
+	if e.closed {
+		return nil, errClosed
+	}
+	if e.user != "" {
+		return nil, sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: maintenance advice requires the database owner")
+	}
+	endRead, err := e.beginRead()
+	if err != nil {
+		return nil, err
+	}
+	defer endRead()
+
+	stats, err := e.catalog.StorageStats()
+	if err != nil {
+		return nil, err
+	}
+
+	var advice []Advice
+	for _, obj := range stats {
+		if obj.Table == catalog.SystemCatalogTable {
+			continue
+		}
+		checks := []func() (*Advice, error){func() (*Advice, error) { return e.adviseReindex(obj) }}
+		if obj.Kind == "table" {
+			checks = []func() (*Advice, error){
+				func() (*Advice, error) { return e.adviseAnalyze(obj.Name) },
+				func() (*Advice, error) { return e.adviseCompact(obj.Name) },
+			}
+		}
+		for _, check := range checks {
+			a, err := check()
+			if err != nil {
+				return nil, fmt.Errorf("failed to inspect %s %s: %w", obj.Kind, obj.Name, err)
+			}
+			if a != nil {
+				advice = append(advice, *a)
+			}
+		}
+	}
+
+	header := e.storage.Pager.GetHeader()
+	if header.PointerMap && header.FreePages > 0 {
+		advice = append(advice, Advice{
+			Action:  ".vacuum",
+			Reason:  fmt.Sprintf("%d free page(s)", header.FreePages),
+			Benefit: fmt.Sprintf("the file shrinks by %s", formatBytes(int(header.FreePages)*storage.PageSize)),
+			Pages:   int(header.FreePages),
+		})
+	}
+	return advice, nil
+}
+
+// adviseAnalyze compares the rows of table with those its statistics
+// recorded.
+func (e *Engine) adviseAnalyze(name string) (*Advice, error) {
+	table, err := e.catalog.LoadTable(name)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := table.Count()
+	if err != nil {
+		return nil, err
+	}
+
+	analyzed, err := e.catalog.GetTableStats(name)
+	if err != nil {
+		for _, index := range e.catalog.GetTableIndexes(name) {
+			if !index.Unique && rows >= autoAnalyzeMinChanges {
+				return &Advice{
+					Action:  "ANALYZE " + name,
+					Object:  name,
+					Reason:  "never analyzed",
+					Benefit: fmt.Sprintf("index %s is assumed to match 10%% of %d rows instead of its real selectivity", index.Name, rows),
+				}, nil
+			}
+		}
+		return nil, nil
+	}
+
+	drift := rows - analyzed.RowCount
+	if drift < 0 {
+		drift = -drift
+	}
+	if drift < autoAnalyzeMinChanges || float64(drift) < defaultAutoAnalyze/100.0*float64(rows) {
+		return nil, nil
+	}
+	return &Advice{
+		Action:  "ANALYZE " + name,
+		Object:  name,
+		Reason:  fmt.Sprintf("analyzed with %d rows, now has %d", analyzed.RowCount, rows),
+		Benefit: fmt.Sprintf("row and selectivity estimates off by up to %d%% are corrected", percentOf(drift, rows)),
+	}, nil
+}
+
+// adviseCompact counts the runs of an LSM table.
+func (e *Engine) adviseCompact(name string) (*Advice, error) {
+	table, err := e.catalog.LoadTable(name)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := table.Runs()
+	if err != nil || runs < adviseMinRuns {
+		return nil, err
+	}
+	return &Advice{
+		Action:  ".compact " + name,
+		Object:  name,
+		Reason:  fmt.Sprintf("%d runs", runs),
+		Benefit: fmt.Sprintf("a lookup searches 1 run instead of %d, and deleted rows stop taking space", runs),
+	}, nil
+}
+
+// adviseReindex estimates the pages of index obj rebuilt bottom-up at its
+// fill factor, from the space its cells take.
+func (e *Engine) adviseReindex(obj catalog.ObjectStats) (*Advice, error) {
+	pages := obj.Pages()
+	if pages < adviseMinPages {
+		return nil, nil
+	}
+	index, err := e.catalog.GetIndex(obj.Name)
+	if err != nil {
+		return nil, err
+	}
+	fill := float64(index.FillFactor) / 100
+	if fill == 0 {
+		fill = float64(storage.DefaultFillFactor) / 100
+	}
+	perPage := float64(obj.PayloadBytes+obj.UnusedBytes) / float64(pages)
+	rebuilt := int(math.Ceil(float64(obj.PayloadBytes) / (perPage * fill)))
+	saved := max(pages-rebuilt, 0)
+
+	if saved*4 < pages {
+		return nil, nil
+	}
+	return &Advice{
+		Action:  ".reindex " + obj.Name,
+		Object:  obj.Name,
+		Reason:  fmt.Sprintf("%d pages %d%% full", pages, percentOf(obj.PayloadBytes, obj.PayloadBytes+obj.UnusedBytes)),
+		Benefit: fmt.Sprintf("about %d page(s), %s, freed", saved, formatBytes(saved*storage.PageSize)),
+		Pages:   saved,
+	}, nil
+}
+
+func percentOf(n, total int) int {
+	if total == 0 {
+		return 0
+	}
+	return int(math.Round(100 * float64(n) / float64(total)))
+}
+
+// formatBytes shows n bytes in the largest unit that keeps it at least 1.
+func formatBytes(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// Reindex rebuilds an index of the main database so that its pages are
+// packed to its fill factor, see catalog.Catalog.Reindex.
+func (e *Engine) Reindex(index string) error {
+	if e.closed {
+		return errClosed
+	}
+	if e.user != "" {
+		return sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: reindexing requires the database owner")
+	}
+	return e.catalog.Reindex(index)
+}
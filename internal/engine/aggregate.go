@@ -0,0 +1,268 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+)
+
+// groupRows buckets the input rows by the GROUP BY columns and computes every
+// aggregate the query references, so HAVING can filter on them by their
+// canonical name. Groups come out in order of first appearance.
+func groupRows(e *Engine, plan *GroupByPlan, input *ResultSet) *ResultSet {
+	keyColumns := input.columnIndexes(plan.Columns)
+
+	type aggregateArg struct {
+		fn, arg string
+		col     int
+		ok      bool
+	}
+	args := make([]aggregateArg, len(plan.Aggregates))
+	for i, agg := range plan.Aggregates {
+		fn, arg, ok := parser.SplitAggregate(agg)
+		args[i] = aggregateArg{fn: fn, arg: arg, col: -1, ok: ok}
+		if col, found := input.ColumnIndex(arg); found {
+			args[i].col = col
+		}
+	}
+
+	groups := make(map[string][][]interface{})
+	var order []string
+
+	for _, row := range input.Rows {
+		keyParts := make([]string, len(keyColumns))
+		for i, col := range keyColumns {
+			keyParts[i] = fmt.Sprintf("%v", valueAt(row, col))
+		}
+		groupKey := strings.Join(keyParts, "|")
+
+		if _, exists := groups[groupKey]; !exists {
+			order = append(order, groupKey)
+		}
+		groups[groupKey] = append(groups[groupKey], row)
+	}
+
+	// Aggregates without GROUP BY describe the whole input, even when empty.
+	if len(plan.Columns) == 0 && len(order) == 0 {
+		order = append(order, "")
+	}
+
+	grouped := &ResultSet{
+		Schema: groupSchema(plan),
+		Rows:   make([][]interface{}, 0, len(order)),
+	}
+	for _, groupKey := range order {
+		members := groups[groupKey]
+
+		groupRow := make([]interface{}, 0, len(grouped.Schema))
+		for _, col := range keyColumns {
+			groupRow = append(groupRow, valueAt(members[0], col))
+		}
+		for _, a := range args {
+			var value interface{}
+			if a.ok {
+				value = computeAggregate(a.fn, a.arg, a.col, members)
+			}
+			groupRow = append(groupRow, value)
+		}
+
+		if plan.Having != nil && !grouped.matches(e, groupRow, plan.Having) {
+			continue
+		}
+		grouped.Rows = append(grouped.Rows, groupRow)
+	}
+
+	return grouped
+}
+
+func groupResultSet(e *Engine, plan *GroupByPlan) (*ResultSet, error) {
+	if count, ok, err := countTable(e, plan); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return &ResultSet{
+			Schema: groupSchema(plan),
+			Rows:   [][]interface{}{{int64(count)}},
+		}, nil
+	}
+
+	inputResult, err := executePlanToResultSet(e, plan.Input)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupRows(e, plan, inputResult), nil
+}
+
+// countTable answers a lone COUNT(*) over a whole table from the table's row
+// count, without reading any rows. It reports false when plan is anything
+// else, including a scan narrowed by WHERE, a row-level security policy or
+// TABLESAMPLE.
+func countTable(e *Engine, plan *GroupByPlan) (int, bool, error) {
+	scan, ok := plan.Input.(*ScanPlan)
+	if !ok || len(plan.Columns) > 0 || plan.Having != nil ||
+		len(plan.Aggregates) != 1 || plan.Aggregates[0] != "COUNT(*)" {
+		return 0, false, nil
+	}
+	if !scan.Filter.empty() || scan.Sample != nil || scan.Function || scan.Virtual || e.isDBStat(scan.Table) {
+		return 0, false, nil
+	}
+
+	table, err := e.loadTable(scan.Table)
+	if err != nil {
+		return 0, true, err
+	}
+	count, err := table.Count()
+	return count, true, err
+}
+
+func executeIndexAggregate(e *Engine, plan *IndexAggregatePlan) (string, error) {
+	resultSet, err := indexAggregateResultSet(e, plan)
+	if err != nil {
+		return "", err
+	}
+
+	return e.formatResults(resultSet), nil
+}
+
+func indexAggregateResultSet(e *Engine, plan *IndexAggregatePlan) (*ResultSet, error) {
+	if !plan.Scan.Filter.empty() {
+		return groupResultSet(e, &GroupByPlan{Aggregates: plan.Aggregates, Input: plan.Scan})
+	}
+
+	table, err := e.loadTable(plan.Scan.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]interface{}, len(plan.Aggregates))
+	for i, agg := range plan.Aggregates {
+		fn, arg, _ := parser.SplitAggregate(agg)
+		column, _ := scanColumn(plan.Scan, arg)
+
+		row, err := table.Endpoint(plan.Indexes[i], fn == "MAX")
+		if err != nil {
+			return nil, err
+		}
+		if row != nil {
+			result[i] = row.Values[column].Value
+		}
+	}
+
+	return &ResultSet{
+		Schema: append([]string(nil), plan.Aggregates...),
+		Rows:   [][]interface{}{result},
+	}, nil
+}
+
+func groupSchema(plan *GroupByPlan) []string {
+	schema := make([]string, 0, len(plan.Columns)+len(plan.Aggregates))
+	schema = append(schema, plan.Columns...)
+	return append(schema, plan.Aggregates...)
+}
+
+// computeAggregate applies fn to the values at position col of rows; col is
+// negative when arg is not a column of the input.
+func computeAggregate(fn, arg string, col int, rows [][]interface{}) interface{} {
+	switch fn {
+	case "COUNT":
+		if arg == "*" {
+			return int64(len(rows))
+		}
+		var count int64
+		for _, row := range rows {
+			if valueAt(row, col) != nil {
+				count++
+			}
+		}
+		return count
+
+	case "SUM", "AVG":
+		var sum float64
+		var count int64
+		integral := true
+		for _, row := range rows {
+			var v float64
+			switch n := valueAt(row, col).(type) {
+			case int64:
+				v = float64(n)
+			case int:
+				v = float64(n)
+			case float64:
+				v = n
+				if n != math.Trunc(n) {
+					integral = false
+				}
+			default:
+				continue
+			}
+			sum += v
+			count++
+		}
+		if count == 0 {
+			return nil
+		}
+		if fn == "AVG" {
+			return sum / float64(count)
+		}
+		if integral {
+			return int64(sum)
+		}
+		return sum
+
+	case "MIN", "MAX":
+		var best interface{}
+		for _, row := range rows {
+			v := valueAt(row, col)
+			if v == nil {
+				continue
+			}
+			if best == nil {
+				best = v
+				continue
+			}
+			cmp := compareValues(v, best)
+			if (fn == "MIN" && cmp < 0) || (fn == "MAX" && cmp > 0) {
+				best = v
+			}
+		}
+		return best
+	}
+
+	return nil
+}
+
+// queryAggregates lists the aggregates a SELECT needs computed: COUNT(*) for
+// any grouped query, plus whatever the select list and HAVING reference.
+func queryAggregates(stmt *parser.SelectStmt) []string {
+	var aggregates []string
+	seen := make(map[string]bool)
+
+	add := func(expr string) {
+		if _, _, ok := parser.SplitAggregate(expr); ok && !seen[expr] {
+			seen[expr] = true
+			aggregates = append(aggregates, expr)
+		}
+	}
+
+	if len(stmt.GroupBy) > 0 {
+		add("COUNT(*)")
+	}
+	for _, col := range stmt.Columns {
+		add(col)
+	}
+	if stmt.Having != nil {
+		for _, cond := range stmt.Having.Conditions {
+			add(cond.Column)
+		}
+		for _, pred := range stmt.Having.Predicates {
+			for _, cond := range pred.Conditions() {
+				add(cond.Column)
+			}
+		}
+	}
+
+	return aggregates
+}
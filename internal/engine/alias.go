@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// resolveAliases returns stmt with the column aliases named by its GROUP BY,
+// HAVING and ORDER BY replaced by the select items they stand for; an alias
+// hides a column of the same name. Ordering by the alias of an expression
+// has to wait for the projection to compute it, so the ORDER BY is then
+// taken out of the statement and returned instead, naming the result's
+// columns. stmt itself is not changed, since a parsed statement may run
+// again.
+func resolveAliases(stmt *parser.SelectStmt) (*parser.SelectStmt, []*parser.OrderItem, error) {
+	if stmt.Aliases == nil {
+		return stmt, nil, nil
+	}
+	resolved := *stmt
+	var err error
+
+	if len(stmt.GroupBy) > 0 {
+		resolved.GroupBy = make([]string, len(stmt.GroupBy))
+		for i, col := range stmt.GroupBy {
+			resolved.GroupBy[i] = col
+			if j, ok := aliasIndex(stmt, col); ok {
+				if selectExpr(stmt, j) != nil || isAggregateColumn(stmt.Columns[j]) {
+					return nil, nil, sqlerr.New(sqlerr.FeatureNotSupported, "cannot GROUP BY %s, the alias of %s", col, stmt.Columns[j])
+				}
+				resolved.GroupBy[i] = stmt.Columns[j]
+			}
+		}
+	}
+
+	if stmt.Having != nil {
+		resolve := func(cond parser.Condition) (parser.Condition, error) {
+			j, ok := aliasIndex(stmt, cond.Column)
+			if !ok || cond.Expr != nil {
+				return cond, nil
+			}
+			if selectExpr(stmt, j) != nil {
+				return cond, sqlerr.New(sqlerr.FeatureNotSupported, "cannot use %s, the alias of %s, in HAVING", cond.Column, stmt.Columns[j])
+			}
+			cond.Column = stmt.Columns[j]
+			return cond, nil
+		}
+		having := &parser.WhereClause{
+			Conditions: make([]parser.Condition, len(stmt.Having.Conditions)),
+			Predicates: make([]parser.Predicate, len(stmt.Having.Predicates)),
+		}
+		for i, cond := range stmt.Having.Conditions {
+			if having.Conditions[i], err = resolve(cond); err != nil {
+				return nil, nil, err
+			}
+		}
+		for i, pred := range stmt.Having.Predicates {
+			if having.Predicates[i], err = mapPredicate(pred, resolve); err != nil {
+				return nil, nil, err
+			}
+		}
+		resolved.Having = having
+	}
+
+	if len(stmt.OrderBy) == 0 {
+		return &resolved, nil, nil
+	}
+	onOutput := false
+	for _, item := range stmt.OrderBy {
+		if j, ok := aliasIndex(stmt, item.Column); ok && selectExpr(stmt, j) != nil {
+			onOutput = true
+		}
+	}
+
+	order := make([]*parser.OrderItem, len(stmt.OrderBy))
+	for i, item := range stmt.OrderBy {
+		c := *item
+		j, ok := aliasIndex(stmt, item.Column)
+		switch {
+		case ok && onOutput:
+			c.Column = stmt.Aliases[j]
+		case ok:
+			c.Column = stmt.Columns[j]
+		case onOutput:
+			j, ok = selectIndex(stmt, item.Column)
+			if !ok {
+				return nil, nil, sqlerr.New(sqlerr.FeatureNotSupported,
+					"cannot ORDER BY %s with the alias of an expression unless it is selected too", item.Column)
+			}
+			c.Column = outputColumn(stmt, j)
+		}
+		order[i] = &c
+	}
+	if onOutput {
+		resolved.OrderBy = nil
+		return &resolved, order, nil
+	}
+	resolved.OrderBy = order
+	return &resolved, nil, nil
+}
+
+// mapPredicate returns a copy of pred with each condition replaced by what
+// fn makes of it.
+func mapPredicate(pred parser.Predicate, fn func(parser.Condition) (parser.Condition, error)) (parser.Predicate, error) {
+	if pred.Op == "" {
+		cond, err := fn(pred.Cond)
+		return parser.Predicate{Cond: cond}, err
+	}
+	mapped := parser.Predicate{Op: pred.Op, Args: make([]parser.Predicate, len(pred.Args))}
+	for i, arg := range pred.Args {
+		var err error
+		if mapped.Args[i], err = mapPredicate(arg, fn); err != nil {
+			return mapped, err
+		}
+	}
+	return mapped, nil
+}
+
+// aliasIndex returns the position of the select item aliased name.
+func aliasIndex(stmt *parser.SelectStmt, name string) (int, bool) {
+	for i, alias := range stmt.Aliases {
+		if alias != "" && alias == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// selectIndex returns the position of the select item written as name.
+func selectIndex(stmt *parser.SelectStmt, name string) (int, bool) {
+	for i, col := range stmt.Columns {
+		if col == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func selectExpr(stmt *parser.SelectStmt, i int) parser.Expr {
+	if i < len(stmt.Exprs) {
+		return stmt.Exprs[i]
+	}
+	return nil
+}
+
+func isAggregateColumn(col string) bool {
+	_, _, ok := parser.SplitAggregate(col)
+	return ok
+}
+
+// outputColumn is the name of the i-th column of the result: its alias if
+// it has one.
+func outputColumn(stmt *parser.SelectStmt, i int) string {
+	if i < len(stmt.Aliases) && stmt.Aliases[i] != "" {
+		return stmt.Aliases[i]
+	}
+	return stmt.Columns[i]
+}
@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+// mainDatabase names the database the engine was opened with; main.t and t
+// are the same table.
+const mainDatabase = "main"
+
+type attachedDB struct {
+	file    string
+	storage *storage.Storage
+	catalog *catalog.Catalog
+}
+
+// resolveTable splits an optionally qualified table name into the catalog
+// that owns it and the name the table has there. A qualifier that is neither
+// main nor an attached alias names a schema of the main database.
+func resolveTable(main *catalog.Catalog, attached map[string]*attachedDB, name string) (*catalog.Catalog, string, error) {
+	db, table, qualified := strings.Cut(name, ".")
+	if !qualified {
+		return main, name, nil
+	}
+	if db == mainDatabase {
+		return main, table, nil
+	}
+	if a, ok := attached[db]; ok {
+		return a.catalog, table, nil
+	}
+	return main, name, nil
+}
+
+func (e *Engine) catalogFor(name string) (*catalog.Catalog, string, error) {
+	return resolveTable(e.catalog, e.attached, name)
+}
+
+func (e *Engine) loadTable(name string) (*catalog.Table, error) {
+	cat, table, err := e.catalogFor(name)
+	if err != nil {
+		return nil, err
+	}
+	return cat.LoadTable(table)
+}
+
+func executeAttach(e *Engine, plan *AttachPlan) (string, error) {
+	if plan.Alias == mainDatabase {
+		return "", fmt.Errorf("database alias '%s' is reserved", mainDatabase)
+	}
+	if _, exists := e.attached[plan.Alias]; exists {
+		return "", fmt.Errorf("database alias '%s' is already in use", plan.Alias)
+	}
+	if e.catalog.NamespaceExists(plan.Alias) {
+		return "", fmt.Errorf("database alias '%s' clashes with a schema of the same name", plan.Alias)
+	}
+
+	file, err := filepath.Abs(plan.File)
+	if err != nil {
+		return "", fmt.Errorf("invalid database file %s: %w", plan.File, err)
+	}
+	if file == e.file {
+		return "", fmt.Errorf("database %s is already open as %s", plan.File, mainDatabase)
+	}
+	for alias, a := range e.attached {
+		if a.file == file {
+			return "", fmt.Errorf("database %s is already attached as %s", plan.File, alias)
+		}
+	}
+
+	open := storage.OpenStorage
+	if e.storage.Pager.ReadOnly() {
+		open = storage.OpenStorageReadOnly
+	}
+	store, err := open(e.vfs, file)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", plan.File, err)
+	}
+	if _, err := store.Pager.BeginRead(); err != nil {
+		store.Close()
+		return "", fmt.Errorf("failed to open %s: %w", plan.File, err)
+	}
+	defer store.Pager.EndRead()
+
+	cat, err := catalog.NewCatalog(store.Pager)
+	if err != nil {
+		store.Close()
+		return "", fmt.Errorf("failed to load catalog of %s: %w", plan.File, err)
+	}
+
+	e.applyClock(store, cat)
+	store.Pager.TrackHotPages(e.hotPages)
+	e.attached[plan.Alias] = &attachedDB{file: file, storage: store, catalog: cat}
+	e.forgetPlans()
+
+	for _, name := range cat.ListTables() {
+		if err := e.planner.RefreshTable(plan.Alias + "." + name); err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("Database '%s' attached as %s", plan.File, plan.Alias), nil
+}
+
+func executeDetach(e *Engine, plan *DetachPlan) (string, error) {
+	a, exists := e.attached[plan.Alias]
+	if !exists {
+		return "", fmt.Errorf("no database attached as '%s'", plan.Alias)
+	}
+
+	delete(e.attached, plan.Alias)
+	e.planner.DropDatabase(plan.Alias)
+	e.forgetPlans()
+
+	if err := a.storage.Close(); err != nil {
+		return "", fmt.Errorf("failed to close %s: %w", plan.Alias, err)
+	}
+
+	return fmt.Sprintf("Database '%s' detached", plan.Alias), nil
+}
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditConfig enables the audit log. Once the file grows past MaxSize bytes
+// it is renamed to Path.1, older files shift up, and at most MaxFiles rotated
+// files are kept. A MaxSize of 0 never rotates.
+type AuditConfig struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+}
+
+type auditEntry struct {
+	Time       string  `json:"time"`
+	User       string  `json:"user"`
+	Statement  string  `json:"statement"`
+	Rows       int     `json:"rows"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// auditLog appends one JSON object per executed statement.
+type auditLog struct {
+	mu     sync.Mutex
+	config AuditConfig
+	file   *os.File
+	size   int64
+}
+
+func openAuditLog(config AuditConfig) (*auditLog, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("audit log path cannot be empty")
+	}
+
+	a := &auditLog{config: config}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	file, err := os.OpenFile(a.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+
+	a.file = file
+	a.size = info.Size()
+	return nil
+}
+
+func (a *auditLog) record(entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.config.MaxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.config.MaxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+func (a *auditLog) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+
+	path := a.config.Path
+	if a.config.MaxFiles > 0 {
+		os.Remove(path + "." + strconv.Itoa(a.config.MaxFiles))
+		for i := a.config.MaxFiles - 1; i >= 1; i-- {
+			os.Rename(path+"."+strconv.Itoa(i), path+"."+strconv.Itoa(i+1))
+		}
+		if err := os.Rename(path, path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	} else if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+
+	return a.open()
+}
+
+func (a *auditLog) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// EnableAudit starts recording every statement the engine executes, replacing
+// any audit log already open.
+func (e *Engine) EnableAudit(config AuditConfig) error {
+	audit, err := openAuditLog(config)
+	if err != nil {
+		return err
+	}
+	if err := e.DisableAudit(); err != nil {
+		audit.close()
+		return err
+	}
+	e.audit = audit
+	return nil
+}
+
+func (e *Engine) DisableAudit() error {
+	if e.audit == nil {
+		return nil
+	}
+	err := e.audit.close()
+	e.audit = nil
+	return err
+}
+
+func (e *Engine) auditStatement(statement string, start time.Time, err error) {
+	if e.audit == nil {
+		return
+	}
+
+	user := e.user
+	if user == "" {
+		user = "owner"
+	}
+
+	entry := auditEntry{
+		Time:       start.UTC().Format(time.RFC3339Nano),
+		User:       user,
+		Statement:  statement,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Rows = e.rowCount
+	}
+
+	if err := e.audit.record(entry); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+}
@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// Login authenticates against the users of the main database and runs every
+// later statement as that user. An engine nobody has logged into acts as the
+// database owner and is not restricted.
+func (e *Engine) Login(name, password string) error {
+	user, err := e.catalog.Authenticate(name, password)
+	if err != nil {
+		return err
+	}
+	e.user = user.Name
+	return nil
+}
+
+// User returns the logged-in user, or "" for the owner.
+func (e *Engine) User() string {
+	return e.user
+}
+
+type privilegeCheck struct {
+	priv  catalog.Privilege
+	table string
+}
+
+// requiredPrivileges lists what running plan touches. ownerOnly is set for
+// statements no grant can allow.
+func requiredPrivileges(plan PlanNode) (checks []privilegeCheck, ownerOnly bool) {
+	switch p := plan.(type) {
+	case *ScanPlan:
+		if p.Function {
+			return nil, false
+		}
+		return []privilegeCheck{{catalog.PrivSelect, p.Table}}, false
+	case *JoinPlan:
+		checks, _ = requiredPrivileges(p.Left)
+		rightChecks, _ := requiredPrivileges(p.Right)
+		return append(checks, rightChecks...), false
+	case *SemiJoinPlan:
+		checks, _ = requiredPrivileges(p.Input)
+		subChecks, _ := requiredPrivileges(p.Subquery)
+		return append(checks, subChecks...), false
+	case *ProjectPlan:
+		return requiredPrivileges(p.Input)
+	case *GroupByPlan:
+		return requiredPrivileges(p.Input)
+	case *IndexAggregatePlan:
+		return requiredPrivileges(p.Scan)
+	case *SortPlan:
+		return requiredPrivileges(p.Input)
+	case *LimitPlan:
+		return requiredPrivileges(p.Input)
+	case *ExplainPlan:
+		return requiredPrivileges(p.Input)
+	case *InsertPlan:
+		return []privilegeCheck{{catalog.PrivInsert, p.Table}}, false
+	case *UpdatePlan:
+		return []privilegeCheck{{catalog.PrivUpdate, p.Table}}, false
+	case *DeletePlan:
+		return []privilegeCheck{{catalog.PrivDelete, p.Scan.Table}}, false
+	case *CreateTablePlan:
+		return []privilegeCheck{{catalog.PrivDDL, p.Table}}, false
+	case *CreateIndexPlan:
+		return []privilegeCheck{{catalog.PrivDDL, p.TableName}}, false
+	case *DropIndexPlan:
+		if p.Table == "" {
+			return nil, false
+		}
+		return []privilegeCheck{{catalog.PrivDDL, p.Table}}, false
+	case *RenameIndexPlan:
+		if p.Table == "" {
+			return nil, false
+		}
+		return []privilegeCheck{{catalog.PrivDDL, p.Table}}, false
+	case *AnalyzePlan:
+		table := p.Table
+		if table == "" {
+			table = catalog.AllTables
+		}
+		return []privilegeCheck{{catalog.PrivDDL, table}}, false
+	case *CreateSchemaPlan:
+		return []privilegeCheck{{catalog.PrivDDL, catalog.AllTables}}, false
+	case *SetPlan, *ShowPlan:
+		return nil, false
+	case *CallPlan:
+		// Each statement of the procedure is checked as it runs.
+		return nil, false
+	case *ProcessListPlan, *KillPlan, *ShowJobsPlan:
+		// Checked against the session's user when they run.
+		return nil, false
+	default:
+		return nil, true
+	}
+}
+
+// writes reports whether plan may change the database. Statements only the
+// owner can run all change it.
+func writes(plan PlanNode) bool {
+	checks, ownerOnly := requiredPrivileges(plan)
+	if ownerOnly {
+		return true
+	}
+	for _, check := range checks {
+		if check.priv != catalog.PrivSelect {
+			return true
+		}
+	}
+	return false
+}
+
+// grantTarget names a table the way grants store it; main.t and t are the
+// same table.
+func grantTarget(table string) string {
+	return strings.TrimPrefix(table, mainDatabase+".")
+}
+
+func (e *Engine) authorize(plan PlanNode) error {
+	if (e.readOnly || e.storage.Pager.ReadOnly()) && writes(plan) {
+		return sqlerr.New(sqlerr.ReadOnlySQLTransaction, "cannot execute %s in read-only mode", plan.Type())
+	}
+	if e.user == "" {
+		return nil
+	}
+
+	user, err := e.catalog.GetUser(e.user)
+	if err != nil {
+		return sqlerr.Wrap(sqlerr.InsufficientPrivilege, fmt.Errorf("permission denied: %w", err))
+	}
+
+	checks, ownerOnly := requiredPrivileges(plan)
+	if ownerOnly {
+		return sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: %s requires the database owner", plan.Type())
+	}
+
+	for _, check := range checks {
+		table := grantTarget(check.table)
+		if !user.Can(check.priv, table) {
+			return sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: %s on %s for user %s", check.priv, table, user.Name)
+		}
+	}
+	return nil
+}
+
+type CreateUserPlan struct {
+	Name     string
+	Password string
+}
+
+func (c *CreateUserPlan) Type() string  { return "CreateUser" }
+func (c *CreateUserPlan) Cost() float64 { return 0 }
+func (c *CreateUserPlan) String() string {
+	return fmt.Sprintf("CreateUser(%s)", c.Name)
+}
+
+type GrantPlan struct {
+	Revoke     bool
+	Privileges []catalog.Privilege
+	Table      string
+	User       string
+}
+
+func (g *GrantPlan) Type() string {
+	if g.Revoke {
+		return "Revoke"
+	}
+	return "Grant"
+}
+func (g *GrantPlan) Cost() float64 { return 0 }
+func (g *GrantPlan) String() string {
+	return fmt.Sprintf("%s(%v ON %s, user=%s)", g.Type(), g.Privileges, g.Table, g.User)
+}
+
+func parsePrivileges(names []string) ([]catalog.Privilege, error) {
+	if len(names) == 1 && names[0] == "ALL" {
+		return catalog.AllPrivileges, nil
+	}
+
+	privs := make([]catalog.Privilege, 0, len(names))
+	for _, name := range names {
+		priv, ok := catalog.ParsePrivilege(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown privilege %s", name)
+		}
+		privs = append(privs, priv)
+	}
+	return privs, nil
+}
+
+func executeCreateUser(e *Engine, plan *CreateUserPlan) (string, error) {
+	if err := e.catalog.CreateUser(plan.Name, plan.Password); err != nil {
+		return "", fmt.Errorf("failed to create user: %w", err)
+	}
+	return fmt.Sprintf("User '%s' created successfully", plan.Name), nil
+}
+
+func executeGrant(e *Engine, plan *GrantPlan) (string, error) {
+	table := grantTarget(plan.Table)
+
+	if plan.Revoke {
+		if err := e.catalog.Revoke(plan.User, table, plan.Privileges); err != nil {
+			return "", fmt.Errorf("revoke failed: %w", err)
+		}
+		return fmt.Sprintf("Revoked %v on %s from %s", plan.Privileges, table, plan.User), nil
+	}
+
+	if err := e.catalog.Grant(plan.User, table, plan.Privileges); err != nil {
+		return "", fmt.Errorf("grant failed: %w", err)
+	}
+	return fmt.Sprintf("Granted %v on %s to %s", plan.Privileges, table, plan.User), nil
+}
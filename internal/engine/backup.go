@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// EnableWAL puts the main database in WAL mode, archiving completed
+// segments to config.Archive, see Pager.EnableWAL.
+func (e *Engine) EnableWAL(config storage.WALConfig) error {
+	if e.closed {
+		return errClosed
+	}
+	return e.storage.Pager.EnableWAL(config)
+}
+
+// SwitchWAL completes the current WAL segment and archives it.
+func (e *Engine) SwitchWAL() error {
+	if e.closed {
+		return errClosed
+	}
+	if e.user != "" {
+		return sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: switching the WAL requires the database owner")
+	}
+	return e.storage.Pager.SwitchWAL()
+}
+
+// Backup copies the main database file to dest; in WAL mode the copy is a
+// base backup for storage.Restore. Like page inspection it bypasses
+// privileges, so only the owner may take one.
+func (e *Engine) Backup(dest string) error {
+	if e.closed {
+		return errClosed
+	}
+	if e.user != "" {
+		return sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: backups require the database owner")
+	}
+	return e.storage.Pager.Backup(dest)
+}
+
+// BackupIncremental writes to dest only the pages changed since the backup
+// in parent, see Pager.BackupIncremental.
+func (e *Engine) BackupIncremental(dest, parent string) error {
+	if e.closed {
+		return errClosed
+	}
+	if e.user != "" {
+		return sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: backups require the database owner")
+	}
+	return e.storage.Pager.BackupIncremental(dest, parent)
+}
@@ -0,0 +1,151 @@
+package engine
+
+import "github.com/kithinjibrian/anubisdb/internal/parser"
+
+// Operators work through their input a batch of batchSize rows at a time.
+// The rows an operator builds for a batch share one allocation, and work
+// that does not depend on the row, such as resolving column names, is done
+// once rather than for every row.
+const batchSize = 1024
+
+// eachBatch calls fn with successive batches of at most batchSize rows,
+// stopping at the first error.
+func eachBatch(rows [][]interface{}, fn func(batch [][]interface{}) error) error {
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		if err := fn(rows[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowAllocator hands out rows of a fixed width carved from blocks of
+// batchSize rows, so building n rows costs n/batchSize allocations.
+type rowAllocator struct {
+	width int
+	block []interface{}
+}
+
+func newRowAllocator(width int) *rowAllocator {
+	return &rowAllocator{width: width}
+}
+
+// row returns a zeroed row. Its capacity is its width, so appending to it
+// never spills into the next row.
+func (a *rowAllocator) row() []interface{} {
+	if len(a.block) < a.width {
+		a.block = make([]interface{}, a.width*batchSize)
+	}
+	row := a.block[:a.width:a.width]
+	a.block = a.block[a.width:]
+	return row
+}
+
+// rowCursor resolves column names against whichever row of rs it is set to,
+// so a batch is evaluated with one columnGetter instead of one per row.
+type rowCursor struct {
+	row []interface{}
+	get columnGetter
+}
+
+func newRowCursor(rs *ResultSet) *rowCursor {
+	c := &rowCursor{}
+	c.get = func(name string) (interface{}, bool) {
+		i, ok := rs.ColumnIndex(name)
+		if !ok {
+			return nil, false
+		}
+		return c.row[i], true
+	}
+	return c
+}
+
+// batchFilter evaluates a FilterPlan against the rows of a ResultSet with
+// the positions of its columns resolved up front. It matches exactly the
+// rows ResultSet.matches does.
+type batchFilter struct {
+	conds []Condition
+	// cols holds the position of each condition's column and refs that of
+	// the column its value names, -1 where there is none. A value that is
+	// not a column is converted into lits once.
+	cols, refs []int
+	lits       []mapLiteral
+	cursor     *rowCursor
+	e          *Engine
+	// filter evaluates the predicates, which are not resolved up front.
+	filter *FilterPlan
+}
+
+func newBatchFilter(e *Engine, rs *ResultSet, filter *FilterPlan) *batchFilter {
+	f := &batchFilter{
+		conds:  filter.Conditions,
+		cols:   make([]int, len(filter.Conditions)),
+		refs:   make([]int, len(filter.Conditions)),
+		lits:   make([]mapLiteral, len(filter.Conditions)),
+		cursor: newRowCursor(rs),
+		e:      e,
+		filter: filter,
+	}
+	for k, cond := range filter.Conditions {
+		f.cols[k], f.refs[k] = -1, -1
+		if i, ok := rs.ColumnIndex(cond.Column); ok && cond.Expr == nil {
+			f.cols[k] = i
+		}
+		if cond.Value.Kind == parser.Identifier {
+			if i, ok := rs.ColumnIndex(cond.Value.Text); ok {
+				f.refs[k] = i
+			}
+		}
+		if f.refs[k] < 0 {
+			f.lits[k] = bindMapLiteral(cond.Value)
+		}
+	}
+	return f
+}
+
+// apply keeps the rows of batch that match, reusing its storage.
+func (f *batchFilter) apply(batch [][]interface{}) [][]interface{} {
+	kept := batch[:0]
+	for _, row := range batch {
+		if f.matches(row) {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
+
+func (f *batchFilter) matches(row []interface{}) bool {
+	f.cursor.row = row
+	for k, cond := range f.conds {
+		if cond.Expr != nil {
+			if !matchesExprCondition(f.e, f.cursor.get, cond) {
+				return false
+			}
+			continue
+		}
+		if f.cols[k] < 0 {
+			return false
+		}
+
+		ref := f.refs[k]
+		switch {
+		case ref >= 0:
+			if row[ref] == nil || !evaluateConditionMap(row[f.cols[k]], cond.Operator, parser.ValueOf(row[ref])) {
+				return false
+			}
+		case cond.Value.Kind == parser.NullLiteral:
+			return false
+		default:
+			if row[f.cols[k]] == nil || !f.lits[k].matches(row[f.cols[k]], cond.Operator) {
+				return false
+			}
+		}
+	}
+	return f.filter.predicatesHold(func(cond Condition) bool {
+		return matchesGetter(f.e, f.cursor.get, cond)
+	})
+}
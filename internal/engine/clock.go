@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/internal/utils"
+)
+
+// SetClock sets the clock behind the times the main and attached databases
+// store: WAL commit times, which point-in-time restores go by, and when
+// ANALYZE last ran. Timeouts, logs and the audit trail keep real time.
+func (e *Engine) SetClock(clock utils.Clock) {
+	e.clock = clock
+	e.storage.Pager.SetClock(clock)
+	for _, a := range e.attached {
+		a.storage.Pager.SetClock(clock)
+	}
+}
+
+// SetRandom sets the source of the random salts of CREATE USER for the
+// main and attached databases, and of RANDOM() and TABLESAMPLE without
+// REPEATABLE.
+func (e *Engine) SetRandom(r io.Reader) {
+	e.random = r
+	e.catalog.SetRandom(r)
+	for _, a := range e.attached {
+		a.catalog.SetRandom(r)
+	}
+}
+
+// SetDeterministic makes every stored time and random value repeatable: a
+// manual clock starts at the Unix epoch and moves a millisecond per
+// reading, and salts and random numbers come from seed. Rowids already
+// only depend on the rows written.
+func (e *Engine) SetDeterministic(seed int64) {
+	e.SetClock(utils.NewManualClock(time.Unix(0, 0).UTC(), time.Millisecond))
+	e.SetRandom(rand.New(rand.NewSource(seed)))
+}
+
+// randomFloat draws a number uniformly from [0, 1) from the source set by
+// SetRandom, or from math/rand's if none was.
+func (e *Engine) randomFloat() (float64, error) {
+	if e.random == nil {
+		return rand.Float64(), nil
+	}
+	var buf [8]byte
+	if _, err := io.ReadFull(e.random, buf[:]); err != nil {
+		return 0, fmt.Errorf("failed to read random source: %w", err)
+	}
+	return float64(binary.BigEndian.Uint64(buf[:])>>11) / (1 << 53), nil
+}
+
+// applyClock gives a newly attached database the engine's clock and random
+// source, if they were set.
+func (e *Engine) applyClock(store *storage.Storage, cat *catalog.Catalog) {
+	if e.clock != nil {
+		store.Pager.SetClock(e.clock)
+	}
+	if e.random != nil {
+		cat.SetRandom(e.random)
+	}
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+)
+
+// clusterRange is the part of a clustered table's tree that a scan's
+// conditions confine it to: the rows whose leading cluster key columns
+// equal prefix and, when op is set, whose next column compares to value by
+// op. conditions are the ones it was built from.
+type clusterRange struct {
+	prefix     []interface{}
+	op         string
+	value      interface{}
+	conditions []Condition
+}
+
+// clusterRangeOf finds the range of schema's cluster key that conditions
+// select: equalities on its leading columns, then at most one comparison
+// on the next. It reports false when they select no narrower range than
+// the whole table.
+func clusterRangeOf(schema *catalog.Schema, conditions []Condition) (*clusterRange, bool) {
+	if schema == nil || !schema.Clustered() {
+		return nil, false
+	}
+
+	find := func(column string, ops ...string) (Condition, interface{}, bool) {
+		col := schema.GetColumn(column)
+		for _, cond := range conditions {
+			if cond.Expr != nil || cond.Column != column || !cond.literal() || col == nil {
+				continue
+			}
+			for _, op := range ops {
+				if cond.Operator != op {
+					continue
+				}
+				if value, err := literalValue(cond.Value, col.Type); err == nil && value != nil {
+					return cond, value, true
+				}
+			}
+		}
+		return Condition{}, nil, false
+	}
+
+	rng := &clusterRange{}
+	for _, column := range schema.ClusterKey {
+		if cond, value, ok := find(column, "="); ok {
+			rng.prefix = append(rng.prefix, value)
+			rng.conditions = append(rng.conditions, cond)
+			continue
+		}
+		if cond, value, ok := find(column, ">", ">=", "<", "<="); ok {
+			rng.op, rng.value = cond.Operator, value
+			rng.conditions = append(rng.conditions, cond)
+		}
+		break
+	}
+	return rng, len(rng.conditions) > 0
+}
+
+// executeClusterScan reads the rows in the cluster key range of scan's
+// conditions and keeps those that match all of them.
+func executeClusterScan(e *Engine, table *catalog.Table, scan *ScanPlan) ([]*catalog.Row, bool, error) {
+	rng, ok := clusterRangeOf(table.GetSchema(), scan.Filter.Conditions)
+	if !ok {
+		return nil, false, nil
+	}
+	rows, err := e.collectRows(table, scan.Filter, -1, func(fn func(*catalog.Row) error) error {
+		return table.ClusterRangeEach(rng.prefix, rng.op, rng.value, fn)
+	})
+	return rows, true, err
+}
@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// checkSelectColumns rejects a name in stmt's WHERE or ORDER BY that is not
+// a column of the tables it reads. Rows hold no value for such a name, so a
+// condition on it would match no row and an ordering by it would leave the
+// rows as they come. ORDER BY may also name a select item, by its alias or
+// as written, but WHERE filters rows before the select items are computed
+// and cannot.
+func (p *Planner) checkSelectColumns(stmt *parser.SelectStmt) error {
+	tables := queryTables(stmt)
+	for _, name := range whereColumns(stmt.Where) {
+		if err := p.checkColumn(tables, name); err != nil {
+			if _, ok := aliasIndex(stmt, name); ok && sqlerr.CodeOf(err) == sqlerr.UndefinedColumn {
+				return sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found; WHERE cannot refer to a select item by its alias", name)
+			}
+			return err
+		}
+	}
+	for _, item := range stmt.OrderBy {
+		if _, ok := aliasIndex(stmt, item.Column); ok {
+			continue
+		}
+		if _, ok := selectIndex(stmt, item.Column); ok {
+			continue
+		}
+		if err := p.checkColumn(tables, item.Column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTableColumns is checkSelectColumns for the WHERE and ORDER BY of an
+// UPDATE or DELETE of table, and for the bare words its SET assigns.
+func (p *Planner) checkTableColumns(table string, where *parser.WhereClause, orderBy []*parser.OrderItem, assignments []parser.Assignment) error {
+	tables := []*parser.TableRef{{Name: table}}
+	names := whereColumns(where)
+	for _, a := range assignments {
+		if a.Value.Kind == parser.Identifier {
+			names = append(names, a.Value.Text)
+		}
+	}
+	for _, name := range names {
+		if err := p.checkColumn(tables, name); err != nil {
+			return err
+		}
+	}
+	for _, item := range orderBy {
+		if err := p.checkColumn(tables, item.Column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkInsertValues rejects a bare word among values. A row being inserted
+// has no columns to read yet, so the word can only be a misspelled one or
+// text missing its quotes.
+func checkInsertValues(values []parser.Value) error {
+	for _, v := range values {
+		if v.Kind == parser.Identifier {
+			return sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found; quote it to insert it as text", v.Text)
+		}
+	}
+	return nil
+}
+
+// checkColumn rejects name, bare or qualified, unless it is a column of one
+// of tables. A table function, or a table whose schema cannot be loaded,
+// may have any column; a missing table is reported when it is scanned.
+func (p *Planner) checkColumn(tables []*parser.TableRef, name string) error {
+	named := false
+	for _, t := range tables {
+		if t == nil {
+			continue
+		}
+		column, ok := scanColumn(&ScanPlan{Table: t.Name, Alias: t.Alias}, name)
+		if !ok {
+			continue
+		}
+		named = true
+		schema := p.tableSchema(t.Name)
+		if t.Function || schema == nil || schema.GetColumn(column) != nil ||
+			column == catalog.RowIDColumn && schema.HasRowID() {
+			return nil
+		}
+	}
+	if !named {
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			return sqlerr.New(sqlerr.UndefinedTable, "missing FROM-clause entry for table '%s'", name[:i])
+		}
+		// A statement that reads no table has no columns to check.
+		return nil
+	}
+	return sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found", name)
+}
+
+// whereColumns lists the columns the conditions of where compare, on
+// either side of their operators, leaving out those of their subqueries,
+// which are checked when they are planned.
+func whereColumns(where *parser.WhereClause) []string {
+	var names []string
+	for _, cond := range whereConditions(where) {
+		if cond.Expr != nil {
+			names = exprColumns(cond.Expr, names)
+		} else if cond.Column != "" {
+			names = append(names, cond.Column)
+		}
+		if cond.Value.Kind == parser.Identifier {
+			names = append(names, cond.Value.Text)
+		}
+	}
+	return names
+}
+
+// whereConditions lists the conditions of where, those under AND, OR and
+// NOT included.
+func whereConditions(where *parser.WhereClause) []parser.Condition {
+	if where == nil {
+		return nil
+	}
+	conds := where.Conditions
+	for _, pred := range where.Predicates {
+		conds = append(conds[:len(conds):len(conds)], pred.Conditions()...)
+	}
+	return conds
+}
+
+// exprColumns appends the columns expr reads to names.
+func exprColumns(expr parser.Expr, names []string) []string {
+	switch e := expr.(type) {
+	case *parser.ColumnExpr:
+		names = append(names, e.Name)
+	case *parser.FuncExpr:
+		for _, arg := range e.Args {
+			names = exprColumns(arg, names)
+		}
+	case *parser.JSONPathExpr:
+		names = exprColumns(e.Expr, names)
+	case *parser.CastExpr:
+		names = exprColumns(e.Expr, names)
+	}
+	return names
+}
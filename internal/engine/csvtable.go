@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// CSVTable is a virtual table over a CSV file, with columns declared up
+// front. The file is read afresh by every scan, so edits to it show in the
+// next query. Fields are converted to their column's type like LoadCSV
+// converts them, with an empty field read as NULL.
+type CSVTable struct {
+	path    string
+	header  bool
+	columns []catalog.Column
+}
+
+// csvBytesPerField is the guess at the size of a field that EstimateRows
+// divides the file's size by.
+const csvBytesPerField = 8
+
+// NewCSVTable returns a virtual table reading path, whose records hold the
+// values of columns in order. When header is set the first record is
+// skipped. The file need not exist until the table is queried.
+func NewCSVTable(path string, header bool, columns []catalog.Column) (*CSVTable, error) {
+	if len(columns) == 0 {
+		return nil, sqlerr.New(sqlerr.InvalidTableDefinition, "csv table %s declares no columns", path)
+	}
+	seen := make(map[string]bool)
+	for _, col := range columns {
+		if col.Name == "" {
+			return nil, sqlerr.New(sqlerr.InvalidTableDefinition, "csv table %s has a column with no name", path)
+		}
+		if seen[col.Name] {
+			return nil, sqlerr.New(sqlerr.InvalidTableDefinition, "column '%s' specified more than once", col.Name)
+		}
+		seen[col.Name] = true
+		if _, ok := catalog.ParseColumnType(string(col.Type)); !ok && col.Type != catalog.TypeEnum {
+			return nil, sqlerr.New(sqlerr.InvalidTableDefinition, "unknown column type '%s'", col.Type)
+		}
+	}
+	file, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv file %s: %w", path, err)
+	}
+	return &CSVTable{path: file, header: header, columns: columns}, nil
+}
+
+func (t *CSVTable) Columns() []string {
+	names := make([]string, len(t.columns))
+	for i, col := range t.columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+func (t *CSVTable) EstimateRows() int {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return defaultVirtualRows
+	}
+	return int(info.Size()/int64(csvBytesPerField*len(t.columns))) + 1
+}
+
+func (t *CSVTable) Scan(fn func(row []interface{}) error) error {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return fmt.Errorf("failed to open csv table: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(bufio.NewReaderSize(file, 1<<20))
+	reader.FieldsPerRecord = len(t.columns)
+	reader.ReuseRecord = true
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return sqlerr.New(sqlerr.SyntaxError, "invalid csv in %s: %v", t.path, err)
+		}
+		if t.header && line == 1 {
+			continue
+		}
+
+		values := make([]interface{}, len(record))
+		for i, field := range record {
+			if field == "" {
+				continue
+			}
+			col := t.columns[i]
+			if values[i], err = convertValue(field, col.Type); err != nil {
+				return fmt.Errorf("%s line %d: invalid value for column '%s': %w", t.path, line, col.Name, err)
+			}
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+	}
+}
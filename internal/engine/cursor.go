@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+)
+
+// Cursor holds the result of a query so a client can fetch it in pages
+// without running the query again. The rows are read when the query runs;
+// later writes do not change them.
+type Cursor struct {
+	rs  *ResultSet
+	pos int
+
+	// returnsRows is set when the statement produced a result set.
+	returnsRows bool
+}
+
+// Query runs a statement like Run but returns its rows as a Cursor instead
+// of formatting them. A statement without a result set, such as INSERT,
+// gives a cursor with no columns and no rows.
+func (e *Engine) Query(node parser.Node) (*Cursor, error) {
+	return e.QueryContext(context.Background(), node)
+}
+
+// QueryContext is Query with cancellation, see RunContext.
+func (e *Engine) QueryContext(ctx context.Context, node parser.Node) (*Cursor, error) {
+	c := &Cursor{rs: &ResultSet{}}
+	e.cursor = c
+	_, err := e.RunContext(ctx, node)
+	e.cursor = nil
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Exec runs a statement for its effect, such as INSERT, UPDATE or CREATE
+// TABLE, and returns the number of rows it inserted, updated or deleted and
+// the last insert id, see LastInsertID, as database/sql's Result does. A
+// statement that returns rows runs too, but its rows are dropped and none
+// count as affected; run those with Query.
+func (e *Engine) Exec(node parser.Node) (rowsAffected, lastInsertID int64, err error) {
+	return e.ExecContext(context.Background(), node)
+}
+
+// ExecContext is Exec with cancellation, see RunContext.
+func (e *Engine) ExecContext(ctx context.Context, node parser.Node) (int64, int64, error) {
+	c, err := e.QueryContext(ctx, node)
+	if err != nil {
+		return 0, 0, err
+	}
+	c.Close()
+	if c.returnsRows {
+		return 0, e.lastInsertID, nil
+	}
+	return int64(e.rowCount), e.lastInsertID, nil
+}
+
+// LastInsertID returns the rowid of the last row INSERT added, or its
+// primary key if that is an integer column, or 0 if there is none yet.
+// Inserting into a table keyed by any other type leaves it unchanged. Each
+// session has its own.
+func (e *Engine) LastInsertID() int64 {
+	return e.lastInsertID
+}
+
+// ReturnsRows reports whether the statement returned a result set, even an
+// empty one, as a query does, rather than a count of the rows it changed.
+func (c *Cursor) ReturnsRows() bool {
+	return c.returnsRows
+}
+
+// Columns returns the names of the result columns.
+func (c *Cursor) Columns() []string {
+	if c.rs == nil {
+		return nil
+	}
+	return append([]string(nil), c.rs.Schema...)
+}
+
+// Fetch returns up to n of the rows not yet fetched, or all of them when n
+// is 0 or less. It returns no rows once the cursor is exhausted or closed.
+func (c *Cursor) Fetch(n int) [][]interface{} {
+	if c.rs == nil || c.pos >= len(c.rs.Rows) {
+		return nil
+	}
+
+	end := len(c.rs.Rows)
+	if n > 0 && c.pos+n < end {
+		end = c.pos + n
+	}
+
+	width := len(c.rs.Schema)
+	page := make([][]interface{}, 0, end-c.pos)
+	for _, row := range c.rs.Rows[c.pos:end] {
+		page = append(page, row[:width:width])
+	}
+	c.pos = end
+	return page
+}
+
+// Remaining returns the number of rows Fetch has not returned yet.
+func (c *Cursor) Remaining() int {
+	if c.rs == nil {
+		return 0
+	}
+	return len(c.rs.Rows) - c.pos
+}
+
+// Value returns the value of the named column in a row fetched from c. A
+// bare name also matches a qualified column, as in WHERE.
+func (c *Cursor) Value(row []interface{}, column string) (interface{}, bool) {
+	if c.rs == nil {
+		return nil, false
+	}
+	i, ok := c.rs.ColumnIndex(column)
+	if !ok || i >= len(row) {
+		return nil, false
+	}
+	return row[i], true
+}
+
+// Close releases the rows. Fetch returns nothing afterwards.
+func (c *Cursor) Close() {
+	c.rs = nil
+	c.pos = 0
+}
+
+// Keyset pages through a query in the order of a unique column without
+// OFFSET. Each page starts after the key of the last row of the previous
+// one, so a late page costs no more than the first and rows inserted or
+// deleted in between do not shift the pages.
+type Keyset struct {
+	Column string
+	Desc   bool
+	Size   int
+}
+
+// Page returns a copy of stmt that selects the page after the row whose key
+// is after; a nil after selects the first page. It replaces any ORDER BY and
+// LIMIT in stmt. The key column must be selected for Cursor.Value to read
+// it from the page.
+func (k Keyset) Page(stmt *parser.SelectStmt, after interface{}) *parser.SelectStmt {
+	page := *stmt
+
+	if after != nil {
+		op := ">"
+		if k.Desc {
+			op = "<"
+		}
+		where := &parser.WhereClause{}
+		if stmt.Where != nil {
+			where.Conditions = append(where.Conditions, stmt.Where.Conditions...)
+			where.Predicates = stmt.Where.Predicates
+		}
+		where.Conditions = append(where.Conditions, parser.Condition{
+			Column:   k.Column,
+			Operator: op,
+			Value:    parser.ValueOf(after),
+		})
+		page.Where = where
+	}
+
+	direction := "ASC"
+	if k.Desc {
+		direction = "DESC"
+	}
+	page.OrderBy = []*parser.OrderItem{{Column: k.Column, Direction: direction}}
+	page.Limit = &parser.LimitClause{Count: fmt.Sprint(k.Size)}
+	return &page
+}
@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// dbstatTable is a read-only virtual table with one row per table and index
+// describing its pages. A real table of the same name hides it.
+const dbstatTable = "dbstat"
+
+var dbstatColumns = []string{
+	"name", "tbl_name", "type", "root_page", "pages", "leaf_pages", "interior_pages",
+	"depth", "entries", "payload_bytes", "unused_bytes", "fragmented_bytes",
+	"fill_factor", "fragmentation",
+}
+
+func (e *Engine) isDBStat(table string) bool {
+	return strings.EqualFold(table, dbstatTable) && !e.catalog.TableExists(table)
+}
+
+// StorageStats reports the pages of every table and index in the main
+// database and, named alias.name, in each attached one.
+func (e *Engine) StorageStats() ([]catalog.ObjectStats, error) {
+	stats, err := e.catalog.StorageStats()
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := make([]string, 0, len(e.attached))
+	for alias := range e.attached {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	for _, alias := range aliases {
+		attached, err := e.attached[alias].catalog.StorageStats()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", alias, err)
+		}
+		for _, obj := range attached {
+			obj.Name = alias + "." + obj.Name
+			obj.Table = alias + "." + obj.Table
+			stats = append(stats, obj)
+		}
+	}
+	return stats, nil
+}
+
+// CheckIntegrity validates every B-tree of the main database and of each
+// attached one, returning the first problem found.
+func (e *Engine) CheckIntegrity() error {
+	if err := e.catalog.CheckIntegrity(); err != nil {
+		return err
+	}
+	for alias, a := range e.attached {
+		if err := a.catalog.CheckIntegrity(); err != nil {
+			return fmt.Errorf("%s: %w", alias, err)
+		}
+	}
+	return nil
+}
+
+// Compact merges the runs of an LSM table into one, so lookups search a
+// single run and deleted rows stop taking space.
+func (e *Engine) Compact(table string) error {
+	if e.user != "" {
+		return sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: compaction requires the database owner")
+	}
+	t, err := e.loadTable(table)
+	if err != nil {
+		return err
+	}
+	return t.Compact()
+}
+
+// EnablePointerMap makes the main database keep a pointer map, which
+// IncrementalVacuum needs. The database must not have any tables yet.
+func (e *Engine) EnablePointerMap() error {
+	if e.closed {
+		return errClosed
+	}
+	if e.user != "" {
+		return sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: enabling the pointer map requires the database owner")
+	}
+	return e.catalog.EnablePointerMap()
+}
+
+// IncrementalVacuum releases up to limit free pages from the end of the
+// main database file, all it can if limit is 0, and returns how many it
+// released. The database must keep a pointer map.
+func (e *Engine) IncrementalVacuum(limit int) (int, error) {
+	if e.closed {
+		return 0, errClosed
+	}
+	if e.user != "" {
+		return 0, sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: vacuum requires the database owner")
+	}
+	return e.catalog.IncrementalVacuum(limit)
+}
+
+// InspectPage decodes a page of the main database file. Raw pages bypass
+// privileges and row-level security, so only the owner may read them.
+func (e *Engine) InspectPage(pageNum uint32) (*storage.PageInfo, error) {
+	if e.user != "" {
+		return nil, sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: page inspection requires the database owner")
+	}
+	return e.storage.Pager.InspectPage(pageNum)
+}
+
+func scanDBStat(e *Engine, plan *ScanPlan) (*ResultSet, error) {
+	if plan.Sample != nil {
+		return nil, sqlerr.New(sqlerr.FeatureNotSupported, "%s cannot be sampled", dbstatTable)
+	}
+	stats, err := e.StorageStats()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := dbstatTable
+	if plan.Alias != "" {
+		prefix = plan.Alias
+	}
+
+	rs := &ResultSet{Schema: make([]string, len(dbstatColumns))}
+	for i, col := range dbstatColumns {
+		rs.Schema[i] = prefix + "." + col
+	}
+
+	for _, obj := range stats {
+		values := []interface{}{
+			obj.Name, obj.Table, obj.Kind, int64(obj.RootPage), int64(obj.Pages()),
+			int64(obj.LeafPages), int64(obj.InteriorPages), int64(obj.Depth),
+			int64(obj.Entries), int64(obj.PayloadBytes), int64(obj.UnusedBytes),
+			int64(obj.FragmentedBytes), round2(obj.FillFactor()), round2(obj.Fragmentation()),
+		}
+
+		if plan.Filter != nil && !rs.matches(e, values, plan.Filter) {
+			continue
+		}
+		rs.Rows = append(rs.Rows, values)
+	}
+	return rs, nil
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
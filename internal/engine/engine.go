@@ -1,24 +1,167 @@
 package engine
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"path/filepath"
+	"time"
 
 	"github.com/kithinjibrian/anubisdb/internal/catalog"
 	"github.com/kithinjibrian/anubisdb/internal/parser"
 	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/internal/utils"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
 )
 
+// sessionState is what each session of an engine keeps to itself: its
+// settings, see settings.go, and who is logged in.
+type sessionState struct {
+	strict       bool
+	outputFormat string
+	hideHeaders  bool
+	timeout      time.Duration
+	logLevel     LogLevel
+	slowQuery    time.Duration
+	autoAnalyze  float64
+	costs        CostModel
+
+	// user is the logged-in user; "" is the unrestricted owner.
+	user string
+
+	// lastInsertID is the rowid, or integer primary key, of the last row
+	// INSERT added.
+	lastInsertID int64
+
+	// readOnly rejects every statement that could change the database.
+	readOnly bool
+}
+
 type Engine struct {
 	catalog *catalog.Catalog
 	storage *storage.Storage
 	planner *Planner
+
+	// plans caches the plans of recent statements; see plancache.go.
+	plans *utils.LRUCache[string, *cachedPlan]
+
+	// results caches the results of recent queries once
+	// SetResultCacheSize turns it on; see resultcache.go. formatted is the
+	// result set the running statement formatted last.
+	results   *utils.LRUCache[string, *cachedResult]
+	formatted *ResultSet
+
+	sessionState
+	deadline time.Time
+
+	// ctx is the context of the running statement, if it was given one.
+	// Cancelling it stops the statement like a timeout.
+	ctx context.Context
+
+	// rowCount is the number of rows the last statement returned or changed.
+	rowCount int
+
+	// output receives result sets while Stream runs a statement.
+	output io.Writer
+
+	// cursor takes the result set instead while Query runs a statement.
+	cursor *Cursor
+
+	// vfs holds the database file and any attached to it.
+	vfs      storage.VFS
+	file     string
+	attached map[string]*attachedDB
+
+	// virtual holds the tables registered with RegisterVirtualTable. The
+	// planner shares it.
+	virtual map[string]VirtualTable
+
+	// clock and random are set by SetClock and SetRandom, and given to
+	// databases attached later.
+	clock  utils.Clock
+	random io.Reader
+
+	// hotPages is set by SetHotPageTracking, and given to databases
+	// attached later.
+	hotPages bool
+
+	// sessions is set when the engine serves several sessions.
+	sessions *SessionManager
+
+	audit *auditLog
+
+	logger Logger
+
+	tracer  Tracer
+	spanCtx context.Context
+
+	// callDepth counts the procedures running, so that runaway recursion
+	// fails instead of exhausting the stack.
+	callDepth int
+
+	// closed is set by Close; every later statement fails.
+	closed bool
 }
 
 func NewEngine(dbFile string) (*Engine, error) {
-	store, err := storage.NewStorage(dbFile)
+	return OpenEngine(storage.OSFS, dbFile)
+}
+
+// OpenEngine opens dbFile in vfs, such as a storage.MemFS for a database
+// that lives only in memory. Databases attached later are opened in vfs too.
+func OpenEngine(vfs storage.VFS, dbFile string) (*Engine, error) {
+	store, err := storage.OpenStorage(vfs, dbFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	return newEngine(vfs, dbFile, store)
+}
+
+// OpenEngineReadOnly opens dbFile in vfs for reading while another process
+// writes it in WAL mode. Each statement sees every commit made before it
+// starts and none made while it runs, and statements that write fail.
+// Databases attached later are opened read-only too.
+func OpenEngineReadOnly(vfs storage.VFS, dbFile string) (*Engine, error) {
+	store, err := storage.OpenStorageReadOnly(vfs, dbFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open storage: %w", err)
 	}
+	return newEngine(vfs, dbFile, store)
+}
+
+// Upgrade converts dbFile in vfs to the format version of this build in
+// place, see catalog.Catalog.Upgrade, and returns the version it had and
+// the indexes it rebuilt. Nothing else may have the file open.
+func Upgrade(vfs storage.VFS, dbFile string) (uint32, []string, error) {
+	pager, err := storage.OpenPagerToUpgrade(vfs, dbFile)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	version := pager.FormatVersion()
+	cat, err := catalog.NewCatalog(pager)
+	if err != nil {
+		pager.Close()
+		return 0, nil, fmt.Errorf("failed to initialize catalog: %w", err)
+	}
+	rebuilt, err := cat.Upgrade()
+	if closeErr := pager.Close(); err == nil {
+		err = closeErr
+	}
+	return version, rebuilt, err
+}
+
+func newEngine(vfs storage.VFS, dbFile string, store *storage.Storage) (*Engine, error) {
+	file, err := filepath.Abs(dbFile)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("invalid database file %s: %w", dbFile, err)
+	}
+
+	if _, err := store.Pager.BeginRead(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Pager.EndRead()
 
 	cat, err := catalog.NewCatalog(store.Pager)
 	if err != nil {
@@ -26,33 +169,299 @@ func NewEngine(dbFile string) (*Engine, error) {
 		return nil, fmt.Errorf("failed to initialize catalog: %w", err)
 	}
 
+	attached := make(map[string]*attachedDB)
+	virtual := make(map[string]VirtualTable)
+	planner := NewPlanner(cat)
+	planner.attached = attached
+	planner.virtual = virtual
+	if err := planner.LoadStats(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to load statistics: %w", err)
+	}
+
 	return &Engine{
 		catalog: cat,
 		storage: store,
-		planner: NewPlanner(cat),
+		planner: planner,
+		plans:   utils.NewLRUCache[string, *cachedPlan](DefaultPlanCacheSize),
+		sessionState: sessionState{
+			outputFormat: "table",
+			logLevel:     LogInfo,
+			autoAnalyze:  defaultAutoAnalyze,
+			costs:        DefaultCostModel,
+		},
+		vfs:      vfs,
+		file:     file,
+		attached: attached,
+		virtual:  virtual,
 	}, nil
 }
 
+// errClosed is returned for a statement run after Close.
+var errClosed = sqlerr.New(sqlerr.AdminShutdown, "the database is closed")
+
+// Close shuts the engine down: it ends any sessions, waiting for a running
+// statement to finish, closes the audit log, and closes each database, which
+// checkpoints it if it is in WAL mode or syncs it otherwise and releases its
+// locks. Statements run afterwards fail with AdminShutdown. Close cannot be
+// called inside Batch; closing twice does nothing.
 func (e *Engine) Close() error {
+	if e.closed {
+		return nil
+	}
+	if e.storage.Pager.InBatch() {
+		return fmt.Errorf("cannot close the database while a batch is running")
+	}
+	if m := e.sessions; m != nil {
+		m.Close()
+		m.exec.Lock()
+		defer m.exec.Unlock()
+	}
+	e.closed = true
+
+	if err := e.DisableAudit(); err != nil {
+		fmt.Printf("Warning: failed to close audit log: %v\n", err)
+	}
+
+	// Every database is closed even if one fails, and the first error is
+	// returned.
+	var closeErr error
+	for alias, a := range e.attached {
+		if err := a.storage.Close(); err != nil && closeErr == nil {
+			closeErr = fmt.Errorf("failed to close %s: %w", alias, err)
+		}
+		delete(e.attached, alias)
+	}
 	if err := e.storage.Close(); err != nil {
 		return fmt.Errorf("failed to close storage: %w", err)
 	}
-	return nil
+	return closeErr
 }
 
-func (e *Engine) Execute(node parser.Node) string {
+// SetStrict turns strict mode on or off. In strict mode CREATE TABLE rejects
+// unknown column types instead of storing them as TEXT. It is the
+// strict_types setting.
+func (e *Engine) SetStrict(strict bool) {
+	e.strict = strict
+}
+
+// SetReadOnly turns read-only mode on or off. In read-only mode statements
+// that write, such as INSERT, CREATE TABLE or GRANT, fail with a
+// ReadOnlySQLTransaction error; queries, EXPLAIN, SET and SHOW still run. An
+// engine opened with OpenEngineReadOnly stays read-only either way.
+func (e *Engine) SetReadOnly(readOnly bool) {
+	e.readOnly = readOnly
+}
+
+// BloomStats reports how many index lookups the bloom filters of tables
+// created WITH (BLOOM_FILTER = ON) answered without reading the index.
+func (e *Engine) BloomStats() catalog.BloomStats {
+	return e.catalog.BloomStats()
+}
 
-	plan, err := e.planner.Plan(node)
+func (e *Engine) Execute(node parser.Node) string {
+	result, err := e.Run(node)
 	if err != nil {
 		return formatError(err)
 	}
+	return result
+}
+
+// Run executes a statement like Execute but returns the error instead of
+// formatting it, so callers can branch on its code with sqlerr.CodeOf.
+func (e *Engine) Run(node parser.Node) (string, error) {
+	start := time.Now()
+	result, err := e.execute(node)
+	if e.audit != nil || e.logger != nil {
+		statement := node.String()
+		e.auditStatement(statement, start, err)
+		e.logStatement(statement, start, err)
+	}
+	return result, err
+}
+
+// RunContext executes a statement like Run, aborting it with a
+// QueryCanceled error if ctx is cancelled before it finishes. With a tracer
+// set, its spans are children of ctx's, or of RunSQL's statement span.
+func (e *Engine) RunContext(ctx context.Context, node parser.Node) (string, error) {
+	e.ctx = ctx
+	defer func() { e.ctx = nil }()
+	if e.spanCtx == nil {
+		e.spanCtx = ctx
+		defer func() { e.spanCtx = nil }()
+	}
+	return e.Run(node)
+}
+
+// Batch runs fn, typically a series of Run calls, with the main database's
+// writes held in one batch: they reach the file together with a single
+// sync, or not at all if fn returns an error.
+func (e *Engine) Batch(fn func() error) error {
+	if e.closed {
+		return errClosed
+	}
+	return e.catalog.Batch(fn)
+}
+
+// BatchResult is the outcome of one statement run by ExecuteBatch.
+type BatchResult struct {
+	// Statement is the statement as given, or for ExecuteBatchNodes as
+	// the node prints it.
+	Statement string
+
+	// Rows holds the result set of a statement that returns one, and is
+	// nil otherwise.
+	Rows *Cursor
+
+	// RowsAffected and LastInsertID are as Exec returns them.
+	RowsAffected int64
+	LastInsertID int64
+
+	Err error
+}
+
+// ExecuteBatch parses and runs statements in order, each a single
+// statement, in one batch, see Batch. If one fails the batch stops there
+// and nothing it wrote is kept: the results end with the failed statement,
+// whose Err is also returned. If any fails to parse, none runs.
+func (e *Engine) ExecuteBatch(statements []string) ([]BatchResult, error) {
+	return e.ExecuteBatchContext(context.Background(), statements)
+}
+
+// ExecuteBatchContext is ExecuteBatch with cancellation, see RunContext.
+func (e *Engine) ExecuteBatchContext(ctx context.Context, statements []string) ([]BatchResult, error) {
+	nodes := make([]parser.Node, len(statements))
+	for i, statement := range statements {
+		node, err := parser.Parse(statement)
+		if err != nil {
+			err = fmt.Errorf("statement %d: %w", i+1, err)
+			return []BatchResult{{Statement: statement, Err: err}}, err
+		}
+		nodes[i] = node
+	}
+	results, err := e.ExecuteBatchNodes(ctx, nodes)
+	for i := range results {
+		results[i].Statement = statements[i]
+	}
+	return results, err
+}
 
-	result, err := ExecutePlan(e, plan)
+// ExecuteBatchNodes is ExecuteBatchContext for statements already parsed.
+func (e *Engine) ExecuteBatchNodes(ctx context.Context, nodes []parser.Node) ([]BatchResult, error) {
+	results := make([]BatchResult, 0, len(nodes))
+	lastInsertID := e.lastInsertID
+	err := e.Batch(func() error {
+		for i, node := range nodes {
+			result := BatchResult{Statement: node.String()}
+			cursor, err := e.QueryContext(ctx, node)
+			if err != nil {
+				result.Err = fmt.Errorf("statement %d: %w", i+1, err)
+				results = append(results, result)
+				return result.Err
+			}
+			if cursor.ReturnsRows() {
+				result.Rows = cursor
+			} else {
+				result.RowsAffected = int64(e.rowCount)
+			}
+			result.LastInsertID = e.lastInsertID
+			results = append(results, result)
+		}
+		return nil
+	})
 	if err != nil {
-		return formatError(err)
+		// Nothing the batch inserted is kept, and the row counts its
+		// statements adjusted are read again.
+		e.lastInsertID = lastInsertID
+		if statsErr := e.planner.LoadStats(); statsErr != nil {
+			e.log(LogWarn, "failed to reload statistics", map[string]interface{}{"error": statsErr.Error()})
+		}
 	}
+	return results, err
+}
 
-	return result
+func (e *Engine) execute(node parser.Node) (string, error) {
+	if e.closed {
+		return "", errClosed
+	}
+	e.deadline = time.Time{}
+	e.rowCount = 0
+	if e.timeout > 0 {
+		e.deadline = time.Now().Add(e.timeout)
+	}
+
+	endRead, err := e.beginRead()
+	if err != nil {
+		return "", err
+	}
+	defer endRead()
+
+	return e.runStatement(node)
+}
+
+// runStatement plans, authorizes and runs one statement, within the
+// deadline and read of the statement that started it.
+func (e *Engine) runStatement(node parser.Node) (string, error) {
+	planSpan := e.startSpan("anubisdb.plan")
+	plan, err := e.plan(node)
+	if err != nil {
+		planSpan.end(err)
+		return "", err
+	}
+	planSpan.set("plan", plan.String())
+	planSpan.set("cost", plan.Cost())
+	planSpan.end(nil)
+	e.logPlan(node, plan)
+	e.logIndexHints(plan)
+
+	if err := e.authorize(plan); err != nil {
+		return "", err
+	}
+
+	if plan, err = e.applyPolicies(plan); err != nil {
+		return "", err
+	}
+
+	key, keep := e.resultKey(node, plan)
+	var versions map[string]uint64
+	var cached *ResultSet
+	if keep {
+		if rs, ok := e.cachedResultFor(key); ok {
+			cached, keep = rs, false
+		} else {
+			versions, keep = e.dataVersions(plan)
+		}
+	}
+
+	execSpan := e.startSpan("anubisdb.execute")
+	execSpan.set("operator", plan.Type())
+	execSpan.set("cached", cached != nil)
+	var result string
+	run := func() error {
+		if cached != nil {
+			result = e.formatResults(cached)
+			return nil
+		}
+		e.formatted = nil
+		var err error
+		result, err = ExecutePlan(e, plan)
+		if err == nil && keep && e.formatted != nil {
+			e.keepResult(key, versions, e.formatted)
+		}
+		e.formatted = nil
+		return err
+	}
+	if writes(plan) {
+		err = e.catalog.Statement(run)
+	} else {
+		err = run()
+	}
+	execSpan.set("rows", e.rowCount)
+	execSpan.end(err)
+	if err == nil && writes(plan) {
+		e.autoAnalyzeTables()
+	}
+	return result, err
 }
 
 func formatError(err error) string {
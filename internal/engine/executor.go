@@ -1,13 +1,17 @@
 package engine
 
 import (
+	"container/heap"
+	"errors"
 	"fmt"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
 	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
 )
 
 func ExecutePlan(e *Engine, plan PlanNode) (string, error) {
@@ -16,6 +20,12 @@ func ExecutePlan(e *Engine, plan PlanNode) (string, error) {
 		return executeCreateTable(e, p)
 	case *CreateIndexPlan:
 		return executeCreateIndex(e, p)
+	case *DropIndexPlan:
+		return executeDropIndex(e, p)
+	case *RenameIndexPlan:
+		return executeRenameIndex(e, p)
+	case *CreateSchemaPlan:
+		return executeCreateSchema(e, p)
 	case *InsertPlan:
 		return executeInsert(e, p)
 	case *ScanPlan:
@@ -24,8 +34,12 @@ func ExecutePlan(e *Engine, plan PlanNode) (string, error) {
 		return executeProject(e, p)
 	case *JoinPlan:
 		return executeJoin(e, p)
+	case *SemiJoinPlan:
+		return executeSemiJoin(e, p)
 	case *GroupByPlan:
 		return executeGroupBy(e, p)
+	case *IndexAggregatePlan:
+		return executeIndexAggregate(e, p)
 	case *SortPlan:
 		return executeSort(e, p)
 	case *LimitPlan:
@@ -34,40 +48,188 @@ func ExecutePlan(e *Engine, plan PlanNode) (string, error) {
 		return executeUpdate(e, p)
 	case *DeletePlan:
 		return executeDelete(e, p)
+	case *AnalyzePlan:
+		return executeAnalyze(e, p)
+	case *CreateUserPlan:
+		return executeCreateUser(e, p)
+	case *GrantPlan:
+		return executeGrant(e, p)
+	case *CreatePolicyPlan:
+		return executeCreatePolicy(e, p)
+	case *DropPolicyPlan:
+		return executeDropPolicy(e, p)
+	case *CreateProcedurePlan:
+		return executeCreateProcedure(e, p)
+	case *DropProcedurePlan:
+		return executeDropProcedure(e, p)
+	case *CallPlan:
+		return executeCall(e, p)
+	case *CreateJobPlan:
+		return executeCreateJob(e, p)
+	case *DropJobPlan:
+		return executeDropJob(e, p)
+	case *ShowJobsPlan:
+		return executeShowJobs(e, p)
+	case *SetPlan:
+		return executeSet(e, p)
+	case *ShowPlan:
+		return executeShow(e, p)
+	case *ProcessListPlan:
+		return executeProcessList(e, p)
+	case *KillPlan:
+		return executeKill(e, p)
+	case *AttachPlan:
+		return executeAttach(e, p)
+	case *DetachPlan:
+		return executeDetach(e, p)
+	case *ExplainPlan:
+		return p.String(), nil
 	default:
 		return "", fmt.Errorf("unsupported plan type: %T", plan)
 	}
 }
 
-// ResultSet represents query results with schema
+// ResultSet represents query results with schema. Each row holds the values
+// of Schema in order, followed by those of Hidden.
 type ResultSet struct {
-	Schema  []string
-	Rows    []map[string]interface{}
+	Schema []string
+	// Hidden names values that can be referenced but are not displayed,
+	// such as the implicit rowid.
+	Hidden  []string
+	Rows    [][]interface{}
 	Aliases map[string]string // table alias -> table name
+
+	positions map[string]int
+}
+
+// ColumnIndex returns the position of name in each row. A bare name also
+// matches a qualified column; when several match, the last one wins.
+func (rs *ResultSet) ColumnIndex(name string) (int, bool) {
+	if rs.positions == nil {
+		rs.positions = make(map[string]int, 2*(len(rs.Schema)+len(rs.Hidden)))
+		columns := append(append([]string(nil), rs.Schema...), rs.Hidden...)
+		for i, col := range columns {
+			if dot := strings.LastIndexByte(col, '.'); dot >= 0 {
+				rs.positions[col[dot+1:]] = i
+			}
+		}
+		for i, col := range columns {
+			rs.positions[col] = i
+		}
+	}
+	i, ok := rs.positions[name]
+	return i, ok
+}
+
+// columnIndexes resolves names with ColumnIndex, using -1 for a name that
+// is not in the result.
+func (rs *ResultSet) columnIndexes(names []string) []int {
+	indexes := make([]int, len(names))
+	for i, name := range names {
+		idx, ok := rs.ColumnIndex(name)
+		if !ok {
+			idx = -1
+		}
+		indexes[i] = idx
+	}
+	return indexes
+}
+
+// getter resolves column names against row for expression evaluation.
+func (rs *ResultSet) getter(row []interface{}) columnGetter {
+	return func(name string) (interface{}, bool) {
+		i, ok := rs.ColumnIndex(name)
+		if !ok {
+			return nil, false
+		}
+		return row[i], true
+	}
+}
+
+func (rs *ResultSet) matches(e *Engine, row []interface{}, filter *FilterPlan) bool {
+	get := rs.getter(row)
+	for _, cond := range filter.Conditions {
+		if !matchesGetter(e, get, cond) {
+			return false
+		}
+	}
+	return filter.predicatesHold(func(cond Condition) bool {
+		return matchesGetter(e, get, cond)
+	})
+}
+
+// matchesGetter evaluates cond against the row whose columns get reads.
+func matchesGetter(e *Engine, get columnGetter, cond Condition) bool {
+	if cond.Expr != nil {
+		return matchesExprCondition(e, get, cond)
+	}
+	rowValue, exists := get(cond.Column)
+	if !exists {
+		return false
+	}
+	value, ok := conditionValue(cond, get)
+	return ok && evaluateConditionMap(rowValue, cond.Operator, value)
+}
+
+func valueAt(row []interface{}, i int) interface{} {
+	if i < 0 {
+		return nil
+	}
+	return row[i]
 }
 
 func executeCreateTable(e *Engine, plan *CreateTablePlan) (string, error) {
 	columns := make([]catalog.Column, len(plan.Columns))
 	for i, col := range plan.Columns {
+		colType := catalog.TypeEnum
+		if len(col.EnumValues) == 0 {
+			var err error
+			colType, err = columnType(col.Type, e.strict)
+			if err != nil {
+				return "", err
+			}
+		}
 		columns[i] = catalog.Column{
 			Name:       col.Name,
-			Type:       parseColumnType(col.Type),
+			Type:       colType,
 			PrimaryKey: col.PrimaryKey,
 			NotNull:    col.NotNull,
 			Unique:     col.Unique,
+			EnumValues: col.EnumValues,
 		}
 	}
 
-	_, err := e.catalog.CreateTable(plan.Table, columns)
+	if _, exists := e.virtual[plan.Table]; exists {
+		return "", sqlerr.New(sqlerr.DuplicateTable, "table '%s' already exists", plan.Table)
+	}
+	cat, tableName, err := e.catalogFor(plan.Table)
 	if err != nil {
+		return "", err
+	}
+
+	opts := catalog.TableOptions{
+		Engine:      plan.Engine,
+		ClusterKey:  plan.ClusterKey,
+		FillFactor:  plan.FillFactor,
+		BloomFilter: plan.BloomFilter,
+	}
+	if _, err := cat.CreateTableWithOptions(tableName, opts, columns, plan.Unique...); err != nil {
 		return "", fmt.Errorf("failed to create table: %w", err)
 	}
 
+	if err := e.planner.RefreshTable(plan.Table); err != nil {
+		return "", err
+	}
+
 	return fmt.Sprintf("Table '%s' created successfully", plan.Table), nil
 }
 
 func executeCreateIndex(e *Engine, plan *CreateIndexPlan) (string, error) {
-	table, err := e.catalog.LoadTable(plan.TableName)
+	if plan.Concurrently {
+		return executeCreateIndexConcurrently(e, plan)
+	}
+
+	table, err := e.loadTable(plan.TableName)
 	if err != nil {
 		return "", fmt.Errorf("table not found: %w", err)
 	}
@@ -76,25 +238,86 @@ func executeCreateIndex(e *Engine, plan *CreateIndexPlan) (string, error) {
 
 	for _, colName := range plan.Columns {
 		if schema.GetColumn(colName) == nil {
-			return "", fmt.Errorf("column '%s' not found in table '%s'", colName, plan.TableName)
+			return "", sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found in table '%s'", colName, plan.TableName)
 		}
 	}
 
 	if len(plan.Columns) > 0 {
-		if _, err := e.catalog.CreateIndex(plan.IndexName, plan.TableName, plan.Columns[0], plan.Unique); err != nil {
+		if _, err := table.Catalog.CreateIndexWithFillFactor(plan.IndexName, schema.Name, plan.Columns, plan.Unique, plan.FillFactor); err != nil {
 			return "", fmt.Errorf("failed to create index: %w", err)
 		}
 	}
 
+	if err := e.planner.RefreshTable(plan.TableName); err != nil {
+		return "", err
+	}
+
+	return indexCreated(plan), nil
+}
+
+func indexCreated(plan *CreateIndexPlan) string {
 	indexType := "INDEX"
 	if plan.Unique {
 		indexType = "UNIQUE INDEX"
 	}
-	return fmt.Sprintf("%s '%s' created successfully on %s(%v)", indexType, plan.IndexName, plan.TableName, plan.Columns), nil
+	return fmt.Sprintf("%s '%s' created successfully on %s(%v)", indexType, plan.IndexName, plan.TableName, plan.Columns)
+}
+
+func executeDropIndex(e *Engine, plan *DropIndexPlan) (string, error) {
+	if plan.Table == "" {
+		return fmt.Sprintf("Index '%s' does not exist, skipping", plan.Name), nil
+	}
+
+	cat, name, err := e.catalogFor(plan.Name)
+	if err != nil {
+		return "", err
+	}
+	if err := cat.DropIndex(name); err != nil {
+		return "", fmt.Errorf("failed to drop index: %w", err)
+	}
+	if err := e.planner.RefreshTable(plan.Table); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Index '%s' dropped", plan.Name), nil
+}
+
+func executeRenameIndex(e *Engine, plan *RenameIndexPlan) (string, error) {
+	if plan.Table == "" {
+		return fmt.Sprintf("Index '%s' does not exist, skipping", plan.Name), nil
+	}
+
+	cat, name, err := e.catalogFor(plan.Name)
+	if err != nil {
+		return "", err
+	}
+	if err := cat.RenameIndex(name, plan.NewName); err != nil {
+		return "", fmt.Errorf("failed to rename index: %w", err)
+	}
+	if err := e.planner.RefreshTable(plan.Table); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Index '%s' renamed to '%s'", plan.Name, plan.NewName), nil
+}
+
+func executeCreateSchema(e *Engine, plan *CreateSchemaPlan) (string, error) {
+	cat, name, err := e.catalogFor(plan.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if _, attached := e.attached[name]; name == mainDatabase || attached {
+		return "", fmt.Errorf("schema name '%s' is reserved for a database", name)
+	}
+
+	if err := cat.CreateNamespace(name); err != nil {
+		return "", fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	return fmt.Sprintf("Schema '%s' created successfully", plan.Name), nil
 }
 
 func executeInsert(e *Engine, plan *InsertPlan) (string, error) {
-	table, err := e.catalog.LoadTable(plan.Table)
+	table, err := e.loadTable(plan.Table)
 	if err != nil {
 		return "", fmt.Errorf("table not found: %w", err)
 	}
@@ -102,7 +325,7 @@ func executeInsert(e *Engine, plan *InsertPlan) (string, error) {
 	schema := table.GetSchema()
 
 	if len(plan.Values) != schema.ColumnCount() {
-		return "", fmt.Errorf("column count mismatch: expected %d, got %d",
+		return "", sqlerr.New(sqlerr.SyntaxError, "column count mismatch: expected %d, got %d",
 			schema.ColumnCount(), len(plan.Values))
 	}
 
@@ -111,25 +334,131 @@ func executeInsert(e *Engine, plan *InsertPlan) (string, error) {
 		return "", fmt.Errorf("failed to convert values: %w", err)
 	}
 
+	row, err := catalog.CreateRow(schema, values)
+	if err != nil {
+		return "", fmt.Errorf("insert failed: %w", err)
+	}
+	if err := e.checkPolicies(plan.Table, row); err != nil {
+		return "", fmt.Errorf("insert failed: %w", err)
+	}
+
 	if err := table.Insert(values); err != nil {
 		return "", fmt.Errorf("insert failed: %w", err)
 	}
+	e.planner.AdjustRowCount(plan.Table, 1)
+	e.rowCount = 1
+	if id, ok := table.LastInsertID(); ok {
+		e.lastInsertID = id
+	}
 
 	return "1 row inserted", nil
 }
 
+func executeAnalyze(e *Engine, plan *AnalyzePlan) (string, error) {
+	tables := []string{plan.Table}
+	if plan.Table == "" {
+		tables = e.catalog.ListTables()
+	}
+
+	for _, name := range tables {
+		if err := e.analyzeTable(name); err != nil {
+			return "", err
+		}
+	}
+	// Fresh statistics can change the best plan of any statement.
+	e.forgetPlans()
+
+	return fmt.Sprintf("%d table(s) analyzed", len(tables)), nil
+}
+
+func (e *Engine) analyzeTable(name string) error {
+	cat, tableName, err := e.catalogFor(name)
+	if err != nil {
+		return err
+	}
+	if _, err := cat.AnalyzeTable(tableName); err != nil {
+		return fmt.Errorf("failed to analyze %s: %w", name, err)
+	}
+	return e.planner.RefreshTable(name)
+}
+
+// autoAnalyzeMinChanges keeps small tables from being analyzed again after
+// every few rows, whatever auto_analyze_threshold says.
+const autoAnalyzeMinChanges = 50
+
+// defaultAutoAnalyze is the default auto_analyze_threshold, in percent.
+const defaultAutoAnalyze = 10
+
+// autoAnalyzeTables analyzes the tables whose statistics the rows written
+// since they were read have made stale. It runs once a write has succeeded,
+// so a failure is logged rather than returned.
+func (e *Engine) autoAnalyzeTables() {
+	if e.autoAnalyze <= 0 {
+		return
+	}
+	tables := e.planner.StaleTables(e.autoAnalyze, autoAnalyzeMinChanges)
+	for _, name := range tables {
+		err := e.catalog.Statement(func() error { return e.analyzeTable(name) })
+		if err != nil {
+			e.log(LogWarn, "auto-analyze failed", map[string]interface{}{"table": name, "error": err.Error()})
+			continue
+		}
+		e.log(LogDebug, "auto-analyze", map[string]interface{}{"table": name})
+	}
+	if len(tables) > 0 {
+		e.forgetPlans()
+	}
+}
+
 func executeScan(e *Engine, plan *ScanPlan) (string, error) {
-	table, err := e.catalog.LoadTable(plan.Table)
+	if plan.Function {
+		rs, err := scanFunction(e, plan)
+		if err != nil {
+			return "", err
+		}
+		rs.Schema = []string{functionColumn(plan)}
+		return e.formatResults(rs), nil
+	}
+	if plan.Virtual {
+		rs, err := scanVirtual(e, plan)
+		if err != nil {
+			return "", err
+		}
+		rs.Schema = e.virtual[plan.Table].Columns()
+		return e.formatResults(rs), nil
+	}
+	if e.isDBStat(plan.Table) {
+		rs, err := scanDBStat(e, plan)
+		if err != nil {
+			return "", err
+		}
+		rs.Schema = dbstatColumns
+		return e.formatResults(rs), nil
+	}
+
+	table, err := e.loadTable(plan.Table)
 	if err != nil {
 		return "", fmt.Errorf("table not found: %w", err)
 	}
 
-	rows, err := executeFilteredScan(table, plan.Filter)
+	rows, err := scanRows(e, table, plan)
 	if err != nil {
 		return "", fmt.Errorf("scan failed: %w", err)
 	}
 
-	return formatTableResults(rows, table.GetSchema()), nil
+	schema := table.GetSchema()
+	rs := &ResultSet{
+		Schema: make([]string, len(schema.Columns)),
+		Rows:   make([][]interface{}, len(rows)),
+	}
+	for i, col := range schema.Columns {
+		rs.Schema[i] = col.Name
+	}
+	for i, row := range rows {
+		rs.Rows[i] = rowValues(row, schema)
+	}
+
+	return e.formatResults(rs), nil
 }
 
 func executeProject(e *Engine, plan *ProjectPlan) (string, error) {
@@ -139,176 +468,140 @@ func executeProject(e *Engine, plan *ProjectPlan) (string, error) {
 		return "", err
 	}
 
-	// Handle SELECT *
-	if len(plan.Columns) == 1 && plan.Columns[0] == "*" {
-		if plan.Distinct {
-			resultSet.Rows = distinctRows(resultSet.Rows)
-		}
-		return formatResultSet(resultSet), nil
-	}
-
-	// Project specific columns
-	projectedRows := make([]map[string]interface{}, 0, len(resultSet.Rows))
-	for _, row := range resultSet.Rows {
-		projectedRow := make(map[string]interface{})
-		for _, col := range plan.Columns {
-			if val, exists := row[col]; exists {
-				projectedRow[col] = val
-			} else {
-				return "", fmt.Errorf("column '%s' not found", col)
-			}
-		}
-		projectedRows = append(projectedRows, projectedRow)
-	}
-
-	if plan.Distinct {
-		projectedRows = distinctRows(projectedRows)
+	resultSet, err = projectResultSet(e, plan, resultSet)
+	if err != nil {
+		return "", err
 	}
-
-	resultSet.Schema = plan.Columns
-	resultSet.Rows = projectedRows
-	return formatResultSet(resultSet), nil
+	return e.formatResults(resultSet), nil
 }
 
 func executeJoin(e *Engine, plan *JoinPlan) (string, error) {
-	// Execute left side
-	leftResult, err := executePlanToResultSet(e, plan.Left)
+	resultSet, err := joinResultSet(e, plan)
 	if err != nil {
 		return "", err
 	}
+	return e.formatResults(resultSet), nil
+}
 
-	// Execute right side (scan)
-	rightTable, err := e.catalog.LoadTable(plan.Right.Table)
+// joinResultSet joins the rows of plan's two sides with a nested loop. LEFT
+// and FULL joins keep the left rows that matched nothing, RIGHT and FULL
+// joins the right ones, with NULLs for the other side's columns.
+func joinResultSet(e *Engine, plan *JoinPlan) (*ResultSet, error) {
+	leftResult, err := executePlanToResultSet(e, plan.Left)
 	if err != nil {
-		return "", fmt.Errorf("right table not found: %w", err)
+		return nil, err
 	}
-
-	rightRows, err := executeFilteredScan(rightTable, plan.Right.Filter)
+	rightResult, err := executePlanToResultSet(e, plan.Right)
 	if err != nil {
-		return "", fmt.Errorf("right scan failed: %w", err)
+		return nil, fmt.Errorf("right scan failed: %w", err)
 	}
 
-	// Convert right rows to map format
-	rightResult := catalogRowsToResultSet(rightRows, rightTable.GetSchema(), plan.Right.Table, plan.Right.Alias)
-
-	// Perform join
-	joinedRows := make([]map[string]interface{}, 0)
+	join, err := newRowJoiner(e, leftResult, rightResult, plan.Conditions)
+	if err != nil {
+		return nil, err
+	}
+	keepLeft := plan.JoinType == "LEFT" || plan.JoinType == "FULL"
+	keepRight := plan.JoinType == "RIGHT" || plan.JoinType == "FULL"
 
+	var rightMatched []bool
+	if keepRight {
+		rightMatched = make([]bool, len(rightResult.Rows))
+	}
+	var joinedRows [][]interface{}
 	for _, leftRow := range leftResult.Rows {
+		if err := e.checkDeadline(); err != nil {
+			return nil, err
+		}
 		matched := false
-		for _, rightRow := range rightResult.Rows {
-			if evaluateJoinCondition(leftRow, rightRow, plan.Condition) {
+		for i, rightRow := range rightResult.Rows {
+			if join.matches(leftRow, rightRow) {
 				matched = true
-				// Merge rows
-				joinedRow := make(map[string]interface{})
-				for k, v := range leftRow {
-					joinedRow[k] = v
-				}
-				for k, v := range rightRow {
-					joinedRow[k] = v
+				if keepRight {
+					rightMatched[i] = true
 				}
-				joinedRows = append(joinedRows, joinedRow)
+				joinedRows = append(joinedRows, join.row(leftRow, rightRow))
 			}
 		}
-
-		// For LEFT/RIGHT/FULL joins, handle unmatched rows
-		if !matched && (plan.JoinType == "LEFT" || plan.JoinType == "FULL") {
-			joinedRow := make(map[string]interface{})
-			for k, v := range leftRow {
-				joinedRow[k] = v
-			}
-			for _, col := range rightResult.Schema {
-				joinedRow[col] = nil
-			}
-			joinedRows = append(joinedRows, joinedRow)
+		if !matched && keepLeft {
+			joinedRows = append(joinedRows, join.row(leftRow, nil))
 		}
 	}
-
-	// For RIGHT/FULL joins, add unmatched right rows
-	if plan.JoinType == "RIGHT" || plan.JoinType == "FULL" {
-		for _, rightRow := range rightResult.Rows {
-			matched := false
-			for _, leftRow := range leftResult.Rows {
-				if evaluateJoinCondition(leftRow, rightRow, plan.Condition) {
-					matched = true
-					break
-				}
-			}
-			if !matched {
-				joinedRow := make(map[string]interface{})
-				for _, col := range leftResult.Schema {
-					joinedRow[col] = nil
-				}
-				for k, v := range rightRow {
-					joinedRow[k] = v
-				}
-				joinedRows = append(joinedRows, joinedRow)
-			}
+	for i, rightRow := range rightResult.Rows {
+		if keepRight && !rightMatched[i] {
+			joinedRows = append(joinedRows, join.row(nil, rightRow))
 		}
 	}
 
-	// Combine schemas
-	combinedSchema := append(leftResult.Schema, rightResult.Schema...)
-
-	resultSet := &ResultSet{
-		Schema: combinedSchema,
-		Rows:   joinedRows,
+	result := join.resultSet(joinedRows)
+	if len(plan.Using) > 0 {
+		result = mergeUsing(result, plan)
 	}
-
-	return formatResultSet(resultSet), nil
+	filterResultSet(e, result, plan.Filter)
+	return result, nil
 }
 
-func executeGroupBy(e *Engine, plan *GroupByPlan) (string, error) {
-	// Execute input
-	resultSet, err := executePlanToResultSet(e, plan.Input)
-	if err != nil {
-		return "", err
-	}
-
-	// Group rows by specified columns
-	groups := make(map[string][]map[string]interface{})
-
-	for _, row := range resultSet.Rows {
-		// Create group key
-		keyParts := make([]string, len(plan.Columns))
-		for i, col := range plan.Columns {
-			keyParts[i] = fmt.Sprintf("%v", row[col])
-		}
-		groupKey := strings.Join(keyParts, "|")
-
-		groups[groupKey] = append(groups[groupKey], row)
-	}
-
-	// Create result rows (one per group)
-	groupedRows := make([]map[string]interface{}, 0, len(groups))
-	for _, groupRows := range groups {
-		if len(groupRows) > 0 {
-			// For now, just take the first row of each group
-			// In a real implementation, we'd compute aggregates here
-			groupRow := make(map[string]interface{})
-			for _, col := range plan.Columns {
-				groupRow[col] = groupRows[0][col]
+// mergeUsing replaces the two columns each USING or NATURAL condition of
+// plan compares with one named by the bare column, placed first. It holds
+// the left value, or the right one in rows the left side did not match.
+// The replaced columns can still be referenced by their qualified names.
+func mergeUsing(rs *ResultSet, plan *JoinPlan) *ResultSet {
+	sources := make([][2]int, len(plan.Conditions))
+	replaced := make(map[int]bool)
+	for i, cond := range plan.Conditions {
+		l, _ := rs.ColumnIndex(cond.Column)
+		r, _ := rs.ColumnIndex(cond.Value.Text)
+		sources[i] = [2]int{l, r}
+		replaced[l], replaced[r] = true, true
+	}
+
+	merged := &ResultSet{Schema: append([]string(nil), plan.Using...), Aliases: rs.Aliases}
+	var visible, hidden []int
+	for i, col := range rs.Schema {
+		switch {
+		case !replaced[i]:
+			merged.Schema = append(merged.Schema, col)
+			visible = append(visible, i)
+		case strings.Contains(col, "."):
+			// A column merged by an earlier join is dropped; its
+			// sources are already hidden.
+			merged.Hidden = append(merged.Hidden, col)
+			hidden = append(hidden, i)
+		}
+	}
+	for i, col := range rs.Hidden {
+		merged.Hidden = append(merged.Hidden, col)
+		hidden = append(hidden, len(rs.Schema)+i)
+	}
+
+	alloc := newRowAllocator(len(merged.Schema) + len(merged.Hidden))
+	merged.Rows = make([][]interface{}, len(rs.Rows))
+	for n, row := range rs.Rows {
+		out := alloc.row()[:0]
+		for _, src := range sources {
+			value := row[src[0]]
+			if value == nil {
+				value = row[src[1]]
 			}
-			groupRow["COUNT(*)"] = len(groupRows)
-			groupedRows = append(groupedRows, groupRow)
+			out = append(out, value)
 		}
-	}
-
-	// Apply HAVING filter if present
-	if plan.Having != nil {
-		filteredRows := make([]map[string]interface{}, 0)
-		for _, row := range groupedRows {
-			if matchesFilterMap(row, plan.Having) {
-				filteredRows = append(filteredRows, row)
-			}
+		for _, i := range visible {
+			out = append(out, row[i])
+		}
+		for _, i := range hidden {
+			out = append(out, row[i])
 		}
-		groupedRows = filteredRows
+		merged.Rows[n] = out
 	}
+	return merged
+}
 
-	resultSet.Rows = groupedRows
-	resultSet.Schema = append(plan.Columns, "COUNT(*)")
+func executeGroupBy(e *Engine, plan *GroupByPlan) (string, error) {
+	resultSet, err := groupResultSet(e, plan)
+	if err != nil {
+		return "", err
+	}
 
-	return formatResultSet(resultSet), nil
+	return e.formatResults(resultSet), nil
 }
 
 func executeSort(e *Engine, plan *SortPlan) (string, error) {
@@ -318,25 +611,9 @@ func executeSort(e *Engine, plan *SortPlan) (string, error) {
 		return "", err
 	}
 
-	// Sort rows
-	sort.Slice(resultSet.Rows, func(i, j int) bool {
-		for _, orderItem := range plan.OrderBy {
-			vi := resultSet.Rows[i][orderItem.Column]
-			vj := resultSet.Rows[j][orderItem.Column]
-
-			cmp := compareValues(vi, vj)
-
-			if cmp != 0 {
-				if orderItem.Direction == "DESC" {
-					return cmp > 0
-				}
-				return cmp < 0
-			}
-		}
-		return false
-	})
+	plan.sort(resultSet)
 
-	return formatResultSet(resultSet), nil
+	return e.formatResults(resultSet), nil
 }
 
 func executeLimit(e *Engine, plan *LimitPlan) (string, error) {
@@ -346,129 +623,70 @@ func executeLimit(e *Engine, plan *LimitPlan) (string, error) {
 		return "", err
 	}
 
-	limit, err := strconv.Atoi(plan.Count)
-	if err != nil {
-		return "", fmt.Errorf("invalid LIMIT value: %w", err)
-	}
-
-	offset := 0
-	if plan.Offset != "" {
-		offset, err = strconv.Atoi(plan.Offset)
-		if err != nil {
-			return "", fmt.Errorf("invalid OFFSET value: %w", err)
-		}
-	}
+	resultSet.Rows = plan.apply(resultSet.Rows)
+	return e.formatResults(resultSet), nil
+}
 
-	// Apply offset and limit
-	start := offset
-	if start > len(resultSet.Rows) {
-		start = len(resultSet.Rows)
+// apply returns the rows the plan keeps.
+func (l *LimitPlan) apply(rows [][]interface{}) [][]interface{} {
+	start := l.Offset
+	if start > len(rows) {
+		start = len(rows)
 	}
-
-	end := start + limit
-	if end > len(resultSet.Rows) {
-		end = len(resultSet.Rows)
+	end := len(rows)
+	if l.Count >= 0 && l.Count < end-start {
+		end = start + l.Count
 	}
-
-	resultSet.Rows = resultSet.Rows[start:end]
-
-	return formatResultSet(resultSet), nil
+	return rows[start:end]
 }
 
 // Helper function to execute a plan and return ResultSet
 func executePlanToResultSet(e *Engine, plan PlanNode) (*ResultSet, error) {
+	span := e.startSpan("anubisdb." + plan.Type())
+	if scan, ok := plan.(*ScanPlan); ok {
+		span.set("table", scan.Table)
+	}
+	rs, err := buildResultSet(e, plan)
+	if err == nil {
+		span.set("rows", len(rs.Rows))
+	}
+	span.end(err)
+	return rs, err
+}
+
+func buildResultSet(e *Engine, plan PlanNode) (*ResultSet, error) {
 	switch p := plan.(type) {
 	case *ScanPlan:
-		table, err := e.catalog.LoadTable(p.Table)
-		if err != nil {
-			return nil, err
+		if p.Function {
+			return scanFunction(e, p)
 		}
-		rows, err := executeFilteredScan(table, p.Filter)
-		if err != nil {
-			return nil, err
+		if p.Virtual {
+			return scanVirtual(e, p)
 		}
-		return catalogRowsToResultSet(rows, table.GetSchema(), p.Table, p.Alias), nil
-
-	case *JoinPlan:
-		// Execute join recursively
-		leftResult, err := executePlanToResultSet(e, p.Left)
-		if err != nil {
-			return nil, err
+		if e.isDBStat(p.Table) {
+			return scanDBStat(e, p)
 		}
-
-		rightTable, err := e.catalog.LoadTable(p.Right.Table)
+		table, err := e.loadTable(p.Table)
 		if err != nil {
 			return nil, err
 		}
-		rightRows, err := executeFilteredScan(rightTable, p.Right.Filter)
+		rows, err := scanRows(e, table, p)
 		if err != nil {
 			return nil, err
 		}
-		rightResult := catalogRowsToResultSet(rightRows, rightTable.GetSchema(), p.Right.Table, p.Right.Alias)
+		return catalogRowsToResultSet(rows, table.GetSchema(), p.Table, p.Alias), nil
 
-		joinedRows := make([]map[string]interface{}, 0)
-		for _, leftRow := range leftResult.Rows {
-			for _, rightRow := range rightResult.Rows {
-				if evaluateJoinCondition(leftRow, rightRow, p.Condition) {
-					joinedRow := make(map[string]interface{})
-					for k, v := range leftRow {
-						joinedRow[k] = v
-					}
-					for k, v := range rightRow {
-						joinedRow[k] = v
-					}
-					joinedRows = append(joinedRows, joinedRow)
-				}
-			}
-		}
+	case *JoinPlan:
+		return joinResultSet(e, p)
 
-		return &ResultSet{
-			Schema: append(leftResult.Schema, rightResult.Schema...),
-			Rows:   joinedRows,
-		}, nil
+	case *SemiJoinPlan:
+		return semiJoinResultSet(e, p)
 
 	case *GroupByPlan:
-		inputResult, err := executePlanToResultSet(e, p.Input)
-		if err != nil {
-			return nil, err
-		}
+		return groupResultSet(e, p)
 
-		groups := make(map[string][]map[string]interface{})
-		for _, row := range inputResult.Rows {
-			keyParts := make([]string, len(p.Columns))
-			for i, col := range p.Columns {
-				keyParts[i] = fmt.Sprintf("%v", row[col])
-			}
-			groupKey := strings.Join(keyParts, "|")
-			groups[groupKey] = append(groups[groupKey], row)
-		}
-
-		groupedRows := make([]map[string]interface{}, 0, len(groups))
-		for _, groupRows := range groups {
-			if len(groupRows) > 0 {
-				groupRow := make(map[string]interface{})
-				for _, col := range p.Columns {
-					groupRow[col] = groupRows[0][col]
-				}
-				groupRow["COUNT(*)"] = len(groupRows)
-				groupedRows = append(groupedRows, groupRow)
-			}
-		}
-
-		if p.Having != nil {
-			filteredRows := make([]map[string]interface{}, 0)
-			for _, row := range groupedRows {
-				if matchesFilterMap(row, p.Having) {
-					filteredRows = append(filteredRows, row)
-				}
-			}
-			groupedRows = filteredRows
-		}
-
-		return &ResultSet{
-			Schema: append(p.Columns, "COUNT(*)"),
-			Rows:   groupedRows,
-		}, nil
+	case *IndexAggregatePlan:
+		return indexAggregateResultSet(e, p)
 
 	case *SortPlan:
 		inputResult, err := executePlanToResultSet(e, p.Input)
@@ -476,21 +694,7 @@ func executePlanToResultSet(e *Engine, plan PlanNode) (*ResultSet, error) {
 			return nil, err
 		}
 
-		sort.Slice(inputResult.Rows, func(i, j int) bool {
-			for _, orderItem := range p.OrderBy {
-				vi := inputResult.Rows[i][orderItem.Column]
-				vj := inputResult.Rows[j][orderItem.Column]
-				cmp := compareValues(vi, vj)
-				if cmp != 0 {
-					if orderItem.Direction == "DESC" {
-						return cmp > 0
-					}
-					return cmp < 0
-				}
-			}
-			return false
-		})
-
+		p.sort(inputResult)
 		return inputResult, nil
 
 	case *LimitPlan:
@@ -499,22 +703,7 @@ func executePlanToResultSet(e *Engine, plan PlanNode) (*ResultSet, error) {
 			return nil, err
 		}
 
-		limit, _ := strconv.Atoi(p.Count)
-		offset := 0
-		if p.Offset != "" {
-			offset, _ = strconv.Atoi(p.Offset)
-		}
-
-		start := offset
-		if start > len(inputResult.Rows) {
-			start = len(inputResult.Rows)
-		}
-		end := start + limit
-		if end > len(inputResult.Rows) {
-			end = len(inputResult.Rows)
-		}
-
-		inputResult.Rows = inputResult.Rows[start:end]
+		inputResult.Rows = p.apply(inputResult.Rows)
 		return inputResult, nil
 
 	case *ProjectPlan:
@@ -523,128 +712,409 @@ func executePlanToResultSet(e *Engine, plan PlanNode) (*ResultSet, error) {
 			return nil, err
 		}
 
-		if len(p.Columns) == 1 && p.Columns[0] == "*" {
-			if p.Distinct {
-				inputResult.Rows = distinctRows(inputResult.Rows)
-			}
-			return inputResult, nil
-		}
-
-		projectedRows := make([]map[string]interface{}, 0, len(inputResult.Rows))
-		for _, row := range inputResult.Rows {
-			projectedRow := make(map[string]interface{})
-			for _, col := range p.Columns {
-				projectedRow[col] = row[col]
-			}
-			projectedRows = append(projectedRows, projectedRow)
-		}
-
-		if p.Distinct {
-			projectedRows = distinctRows(projectedRows)
-		}
-
-		return &ResultSet{
-			Schema: p.Columns,
-			Rows:   projectedRows,
-		}, nil
+		return projectResultSet(e, p, inputResult)
 
 	default:
 		return nil, fmt.Errorf("cannot convert plan type %T to ResultSet", plan)
 	}
 }
 
-func catalogRowsToResultSet(rows []*catalog.Row, schema *catalog.Schema, tableName, alias string) *ResultSet {
-	prefix := tableName
+// tablePrefix returns the name that qualifies the columns of a table in a
+// result: its alias, or else its name. A table from an attached database is
+// addressed by its own name.
+func tablePrefix(tableName, alias string) string {
 	if alias != "" {
-		prefix = alias
+		return alias
+	}
+	if dot := strings.LastIndexByte(tableName, '.'); dot >= 0 {
+		return tableName[dot+1:]
 	}
+	return tableName
+}
 
-	resultRows := make([]map[string]interface{}, len(rows))
-	schemaNames := make([]string, len(schema.Columns))
+func catalogRowsToResultSet(rows []*catalog.Row, schema *catalog.Schema, tableName, alias string) *ResultSet {
+	prefix := tablePrefix(tableName, alias)
 
+	rs := &ResultSet{
+		Schema: make([]string, len(schema.Columns)),
+		Rows:   make([][]interface{}, len(rows)),
+	}
 	for i, col := range schema.Columns {
-		schemaNames[i] = prefix + "." + col.Name
+		rs.Schema[i] = prefix + "." + col.Name
+	}
+	// The implicit rowid is addressable by name but left out of the schema
+	// so SELECT * does not show it.
+	if schema.HasRowID() {
+		rs.Hidden = []string{prefix + "." + catalog.RowIDColumn}
 	}
 
+	alloc := newRowAllocator(rowWidth(schema))
 	for i, row := range rows {
-		resultRow := make(map[string]interface{})
-		for _, col := range schema.Columns {
-			key := prefix + "." + col.Name
-			if rv, exists := row.Values[col.Name]; exists {
-				resultRow[key] = rv.Value
-			} else {
-				resultRow[key] = nil
-			}
-			// Also add without prefix for convenience
-			resultRow[col.Name] = resultRow[key]
-		}
-		resultRows[i] = resultRow
+		rs.Rows[i] = fillRowValues(alloc.row(), row, schema)
 	}
 
-	return &ResultSet{
-		Schema: schemaNames,
-		Rows:   resultRows,
+	return rs
+}
+
+// rowValues lays row out in schema order, followed by its rowid if the table
+// has one.
+func rowValues(row *catalog.Row, schema *catalog.Schema) []interface{} {
+	return fillRowValues(make([]interface{}, rowWidth(schema)), row, schema)
+}
+
+// rowWidth is the number of values rowValues lays out for schema.
+func rowWidth(schema *catalog.Schema) int {
+	if schema.HasRowID() {
+		return len(schema.Columns) + 1
 	}
+	return len(schema.Columns)
 }
 
-func evaluateJoinCondition(leftRow, rightRow map[string]interface{}, cond Condition) bool {
-	leftVal := leftRow[cond.Column]
-	rightVal := rightRow[cond.Value]
+// fillRowValues lays row out like rowValues into values, which holds
+// rowWidth(schema) values.
+func fillRowValues(values []interface{}, row *catalog.Row, schema *catalog.Schema) []interface{} {
+	for i, col := range schema.Columns {
+		values[i] = row.Values[col.Name].Value
+	}
+	if len(values) > len(schema.Columns) {
+		values[len(values)-1] = row.Values[catalog.RowIDColumn].Value
+	}
+	return values
+}
 
-	if leftVal == nil || rightVal == nil {
-		return false
+// rowJoiner matches and concatenates the rows of two result sets. Joined
+// rows keep the layout of a ResultSet: both sides' visible values first,
+// then both sides' hidden ones.
+type rowJoiner struct {
+	left, right *ResultSet
+	conds       []joinCondition
+	// joined resolves names to positions in joined rows.
+	joined *ResultSet
+	alloc  *rowAllocator
+	e      *Engine
+}
+
+// joinCondition is one condition rows must meet to be joined. lhs and rhs
+// are the positions of the columns it compares, with rhs -1 when it
+// compares against a literal.
+type joinCondition struct {
+	cond     Condition
+	lhs, rhs int
+	lit      mapLiteral
+}
+
+// newRowJoiner prepares to join on conds, all of which must hold, or on
+// none for a cross join. The column and, if it is a column reference, the
+// value of each condition may come from either side.
+func newRowJoiner(e *Engine, left, right *ResultSet, conds []Condition) (*rowJoiner, error) {
+	j := &rowJoiner{left: left, right: right, e: e}
+	j.joined = j.resultSet(nil)
+	j.alloc = newRowAllocator(len(j.joined.Schema) + len(j.joined.Hidden))
+	for _, cond := range conds {
+		c := joinCondition{cond: cond, lhs: -1, rhs: -1}
+		if cond.Expr == nil {
+			i, ok := j.joined.ColumnIndex(cond.Column)
+			if !ok {
+				return nil, sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found", cond.Column)
+			}
+			c.lhs = i
+		}
+		if cond.Value.Kind == parser.Identifier {
+			i, ok := j.joined.ColumnIndex(cond.Value.Text)
+			if !ok && strings.Contains(cond.Value.Text, ".") {
+				return nil, sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found", cond.Value.Text)
+			}
+			if ok {
+				c.rhs = i
+			}
+		}
+		if c.rhs < 0 {
+			c.lit = bindMapLiteral(cond.Value)
+		}
+		j.conds = append(j.conds, c)
 	}
+	return j, nil
+}
 
-	return compareValues(leftVal, rightVal) == 0
+func (j *rowJoiner) matches(leftRow, rightRow []interface{}) bool {
+	for i := range j.conds {
+		if !j.conds[i].matches(j, leftRow, rightRow) {
+			return false
+		}
+	}
+	return true
 }
 
-func matchesFilterMap(row map[string]interface{}, filter *FilterPlan) bool {
-	for _, cond := range filter.Conditions {
-		val, exists := row[cond.Column]
-		if !exists {
+func (c *joinCondition) matches(j *rowJoiner, leftRow, rightRow []interface{}) bool {
+	var leftVal interface{}
+	if c.cond.Expr != nil {
+		value, err := evalExpr(j.e, c.cond.Expr, func(name string) (interface{}, bool) {
+			i, ok := j.joined.ColumnIndex(name)
+			if !ok {
+				return nil, false
+			}
+			return j.at(leftRow, rightRow, i), true
+		})
+		if err != nil {
 			return false
 		}
+		leftVal = value
+	} else {
+		leftVal = j.at(leftRow, rightRow, c.lhs)
+	}
+	if leftVal == nil {
+		return false
+	}
 
-		// Convert condition value to appropriate type
-		condVal := cond.Value
-		if !evaluateConditionMap(val, cond.Operator, condVal) {
+	if c.rhs < 0 {
+		if c.cond.Value.Kind == parser.NullLiteral {
 			return false
 		}
+		return c.lit.matches(leftVal, c.cond.Operator)
 	}
-	return true
+	rightVal := j.at(leftRow, rightRow, c.rhs)
+	if rightVal == nil {
+		return false
+	}
+	if patternOperator(c.cond.Operator) {
+		a, ok := leftVal.(string)
+		b, ok2 := rightVal.(string)
+		return ok && ok2 && compareString(a, c.cond.Operator, b)
+	}
+	return compareResult(compareValues(leftVal, rightVal), c.cond.Operator)
 }
 
-func evaluateConditionMap(rowValue interface{}, operator, condValue string) bool {
+// at returns the value at position i of the row joining leftRow and
+// rightRow, without building it.
+func (j *rowJoiner) at(leftRow, rightRow []interface{}, i int) interface{} {
+	ls, rs, lh := len(j.left.Schema), len(j.right.Schema), len(j.left.Hidden)
+	switch {
+	case i < ls:
+		return valueAt(leftRow, i)
+	case i < ls+rs:
+		return valueAt(rightRow, i-ls)
+	case i < ls+rs+lh:
+		return valueAt(leftRow, i-rs)
+	default:
+		return valueAt(rightRow, i-ls-lh)
+	}
+}
+
+// filterResultSet drops the rows of rs that do not match filter.
+func filterResultSet(e *Engine, rs *ResultSet, filter *FilterPlan) {
+	if filter == nil {
+		return
+	}
+	f := newBatchFilter(e, rs, filter)
+	kept := rs.Rows[:0]
+	eachBatch(rs.Rows, func(batch [][]interface{}) error {
+		kept = append(kept, f.apply(batch)...)
+		return nil
+	})
+	rs.Rows = kept
+}
+
+// row joins leftRow and rightRow; a nil side is filled with NULLs.
+func (j *rowJoiner) row(leftRow, rightRow []interface{}) []interface{} {
+	ls, rs := len(j.left.Schema), len(j.right.Schema)
+	lh := len(j.left.Hidden)
+
+	joined := j.alloc.row()
+	if leftRow != nil {
+		copy(joined, leftRow[:ls])
+		copy(joined[ls+rs:], leftRow[ls:])
+	}
+	if rightRow != nil {
+		copy(joined[ls:], rightRow[:rs])
+		copy(joined[ls+rs+lh:], rightRow[rs:])
+	}
+	return joined
+}
+
+func (j *rowJoiner) resultSet(rows [][]interface{}) *ResultSet {
+	rs := &ResultSet{
+		Schema: append(append([]string(nil), j.left.Schema...), j.right.Schema...),
+		Rows:   rows,
+	}
+	if len(j.left.Hidden)+len(j.right.Hidden) > 0 {
+		rs.Hidden = append(append([]string(nil), j.left.Hidden...), j.right.Hidden...)
+	}
+	return rs
+}
+
+func sortRows(rs *ResultSet, orderBy []OrderItem) {
+	less := rowLess(rs, orderBy)
+	sort.SliceStable(rs.Rows, func(i, j int) bool {
+		return less(rs.Rows[i], rs.Rows[j])
+	})
+}
+
+// rowLess returns the comparison that orders the rows of rs by orderBy.
+func rowLess(rs *ResultSet, orderBy []OrderItem) func(a, b []interface{}) bool {
+	columns := make([]string, len(orderBy))
+	for i, item := range orderBy {
+		columns[i] = item.Column
+	}
+	positions := rs.columnIndexes(columns)
+
+	return func(a, b []interface{}) bool {
+		for k, orderItem := range orderBy {
+			cmp := compareValues(valueAt(a, positions[k]), valueAt(b, positions[k]))
+			if cmp != 0 {
+				if orderItem.Direction == "DESC" {
+					return cmp > 0
+				}
+				return cmp < 0
+			}
+		}
+		return false
+	}
+}
+
+// topRows leaves the first k rows of rs in orderBy order.
+func topRows(rs *ResultSet, orderBy []OrderItem, k int) {
+	if k >= len(rs.Rows) {
+		sortRows(rs, orderBy)
+		return
+	}
+	less := rowLess(rs, orderBy)
+	top := topIndexes(len(rs.Rows), k, func(a, b int) bool {
+		return less(rs.Rows[a], rs.Rows[b])
+	})
+	rows := make([][]interface{}, len(top))
+	for i, n := range top {
+		rows[i] = rs.Rows[n]
+	}
+	rs.Rows = rows
+}
+
+// topIndexes returns the positions of the first k of n rows, in the order
+// less gives for their positions. It keeps them in a heap whose root is the
+// last of them, so each other row costs at most a comparison and a log k
+// sift instead of a place in a full sort.
+func topIndexes(n, k int, less func(a, b int) bool) []int {
+	// Ties go to the earlier row, matching the stable full sort.
+	h := &rowHeap{less: func(a, b int) bool {
+		if less(a, b) {
+			return true
+		}
+		return !less(b, a) && a < b
+	}, idx: make([]int, 0, k)}
+	for i := 0; i < n; i++ {
+		if len(h.idx) < k {
+			heap.Push(h, i)
+		} else if h.less(i, h.idx[0]) {
+			h.idx[0] = i
+			heap.Fix(h, 0)
+		}
+	}
+	sort.Slice(h.idx, func(i, j int) bool {
+		return h.less(h.idx[i], h.idx[j])
+	})
+	return h.idx
+}
+
+// rowHeap is a max-heap of row positions under less.
+type rowHeap struct {
+	less func(a, b int) bool
+	idx  []int
+}
+
+func (h *rowHeap) Len() int           { return len(h.idx) }
+func (h *rowHeap) Less(i, j int) bool { return h.less(h.idx[j], h.idx[i]) }
+func (h *rowHeap) Swap(i, j int)      { h.idx[i], h.idx[j] = h.idx[j], h.idx[i] }
+func (h *rowHeap) Push(x interface{}) { h.idx = append(h.idx, x.(int)) }
+func (h *rowHeap) Pop() interface{} {
+	n := h.idx[len(h.idx)-1]
+	h.idx = h.idx[:len(h.idx)-1]
+	return n
+}
+
+// sort orders rs, keeping only the first Limit rows for a top-K sort.
+func (s *SortPlan) sort(rs *ResultSet) {
+	if s.Limit > 0 {
+		topRows(rs, s.OrderBy, s.Limit)
+		return
+	}
+	sortRows(rs, s.OrderBy)
+}
+
+func evaluateConditionMap(rowValue interface{}, operator string, condValue parser.Value) bool {
 	if rowValue == nil {
 		return false
 	}
 
 	switch v := rowValue.(type) {
 	case int64:
-		condInt, _ := strconv.ParseInt(condValue, 10, 64)
+		condInt, ok := condValue.Literal.(int64)
+		if !ok {
+			condInt, _ = strconv.ParseInt(condValue.Text, 10, 64)
+		}
 		return compareInt(v, operator, condInt)
 	case float64:
-		condFloat, _ := strconv.ParseFloat(condValue, 64)
+		condFloat, _ := literalFloat(condValue)
 		return compareFloat(v, operator, condFloat)
 	case string:
-		return compareString(v, operator, condValue)
+		return compareString(v, operator, condValue.Text)
 	case bool:
-		condBool, _ := parseBool(condValue)
+		condBool, ok := condValue.Literal.(bool)
+		if !ok {
+			condBool, _ = parseBool(condValue.Text)
+		}
 		return compareBool(v, operator, condBool)
 	default:
 		return false
 	}
 }
 
-func distinctRows(rows []map[string]interface{}) []map[string]interface{} {
+// mapLiteral is a condition value converted ahead of time for each type of
+// value evaluateConditionMap may compare it with.
+type mapLiteral struct {
+	text  string
+	int   int64
+	float float64
+	bool  bool
+}
+
+func bindMapLiteral(condValue parser.Value) mapLiteral {
+	lit := mapLiteral{text: condValue.Text}
+	var ok bool
+	if lit.int, ok = condValue.Literal.(int64); !ok {
+		lit.int, _ = strconv.ParseInt(condValue.Text, 10, 64)
+	}
+	lit.float, _ = literalFloat(condValue)
+	if lit.bool, ok = condValue.Literal.(bool); !ok {
+		lit.bool, _ = parseBool(condValue.Text)
+	}
+	return lit
+}
+
+// matches compares rowValue with the literal as evaluateConditionMap does.
+func (lit *mapLiteral) matches(rowValue interface{}, operator string) bool {
+	switch v := rowValue.(type) {
+	case int64:
+		return compareInt(v, operator, lit.int)
+	case float64:
+		return compareFloat(v, operator, lit.float)
+	case string:
+		return compareString(v, operator, lit.text)
+	case bool:
+		return compareBool(v, operator, lit.bool)
+	default:
+		return false
+	}
+}
+
+// distinctRows drops rows whose visible values repeat an earlier row's.
+func distinctRows(rs *ResultSet) [][]interface{} {
 	seen := make(map[string]bool)
-	result := make([]map[string]interface{}, 0)
+	result := make([][]interface{}, 0)
 
-	for _, row := range rows {
+	for _, row := range rs.Rows {
 		// Create a key from all values
-		keyParts := make([]string, 0, len(row))
-		for _, v := range row {
-			keyParts = append(keyParts, fmt.Sprintf("%v", v))
+		keyParts := make([]string, len(rs.Schema))
+		for i, v := range row[:len(rs.Schema)] {
+			keyParts[i] = fmt.Sprintf("%v", v)
 		}
 		key := strings.Join(keyParts, "|")
 
@@ -668,6 +1138,18 @@ func compareValues(a, b interface{}) int {
 		return 1
 	}
 
+	// Rows read from disk carry integers as float64, so compare mixed
+	// numeric types by value.
+	_, aInt := a.(int64)
+	_, bInt := b.(int64)
+	if !(aInt && bInt) {
+		if af, ok := toFloat(a); ok {
+			if bf, ok := toFloat(b); ok {
+				return compareFloats(af, bf)
+			}
+		}
+	}
+
 	switch av := a.(type) {
 	case int64:
 		if bv, ok := b.(int64); ok {
@@ -706,123 +1188,240 @@ func compareValues(a, b interface{}) int {
 	return 0
 }
 
-func formatResultSet(rs *ResultSet) string {
-	if len(rs.Rows) == 0 {
-		return "No rows found"
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// compareResult applies the comparison operator op to the result of
+// compareValues.
+func compareResult(cmp int, op string) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=", "<>":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	default:
+		return false
 	}
+}
 
-	result := ""
+func compareFloats(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
 
-	// Header
-	for i, col := range rs.Schema {
-		if i > 0 {
-			result += " | "
-		}
-		result += fmt.Sprintf("%-15s", col)
+func scanRows(e *Engine, table *catalog.Table, plan *ScanPlan) ([]*catalog.Row, error) {
+	if plan.ScanType != OrderedScan {
+		return executeFilteredScan(e, table, plan, -1)
 	}
-	result += "\n"
 
-	// Separator
-	for range rs.Schema {
-		result += "----------------"
+	var filter *FilterPlan
+	if !plan.Filter.empty() {
+		filter = plan.Filter
 	}
-	result += "\n"
+	return e.collectRows(table, filter, -1, func(fn func(*catalog.Row) error) error {
+		return table.ScanOrderedEach(plan.IndexName, plan.Order.Direction == "DESC", fn)
+	})
+}
 
-	// Rows
-	for _, row := range rs.Rows {
-		for i, col := range rs.Schema {
-			if i > 0 {
-				result += " | "
-			}
+// deadlineEvery is how many rows a scan reads between checks of the
+// statement's deadline.
+const deadlineEvery = 256
+
+// errScanLimit stops a scan that has collected all the rows it needs.
+var errScanLimit = errors.New("scan limit reached")
 
-			value := "NULL"
-			if v, exists := row[col]; exists && v != nil {
-				value = fmt.Sprintf("%v", v)
+// collectRows gathers the rows scan passes on that match filter, which may
+// be nil, checking the deadline as it goes so that a long scan stops once
+// the statement times out or is cancelled. A limit that is not negative
+// stops the scan once that many rows matched.
+func (e *Engine) collectRows(table *catalog.Table, filter *FilterPlan, limit int, scan func(fn func(*catalog.Row) error) error) ([]*catalog.Row, error) {
+	var bound boundFilter
+	if filter != nil {
+		bound = bindFilter(e, filter, table.GetSchema())
+	}
+
+	if limit == 0 {
+		return nil, e.checkDeadline()
+	}
+
+	var rows []*catalog.Row
+	n := 0
+	err := scan(func(row *catalog.Row) error {
+		if n++; n%deadlineEvery == 0 {
+			if err := e.checkDeadline(); err != nil {
+				return err
 			}
+		}
+		if filter == nil || bound.matches(row) {
+			rows = append(rows, row)
+			if len(rows) == limit {
+				return errScanLimit
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errScanLimit) {
+		return nil, err
+	}
+	return rows, e.checkDeadline()
+}
+
+// executeFilteredScan reads the rows of table that match scan's filter. A
+// limit that is not negative lets it stop once that many rows matched, and
+// it may return more.
+func executeFilteredScan(e *Engine, table *catalog.Table, scan *ScanPlan, limit int) ([]*catalog.Row, error) {
+	schema := table.GetSchema()
+	filter := scan.Filter
+
+	if scan.Sample != nil {
+		rows, err := e.collectRows(table, nil, -1, table.ScanEach)
+		if err != nil {
+			return nil, err
+		}
+		if rows, err = sampleRows(e, rows, scan.Sample); err != nil {
+			return nil, err
+		}
+		return filterRows(e, rows, filter, schema), nil
+	}
+
+	if filter.empty() {
+		return e.collectRows(table, nil, limit, table.ScanEach)
+	}
 
-			result += fmt.Sprintf("%-15s", value)
+	if scan.ScanType == ClusterScan {
+		if rows, ok, err := executeClusterScan(e, table, scan); ok {
+			return rows, err
 		}
-		result += "\n"
 	}
 
-	result += fmt.Sprintf("\n%d row(s) returned", len(rs.Rows))
-	return result
+	if len(filter.Conditions) == 1 && filter.Conditions[0].literal() {
+		if rows, ok, err := lookupRows(e, table, scan); ok {
+			if err != nil || len(filter.Predicates) == 0 {
+				return rows, err
+			}
+			return filterRows(e, rows, &FilterPlan{Predicates: filter.Predicates}, schema), nil
+		}
+	}
+
+	return e.collectRows(table, filter, limit, table.ScanEach)
 }
 
-func executeFilteredScan(table *catalog.Table, filter *FilterPlan) ([]*catalog.Row, error) {
+// lookupRows finds the rows matching the single condition of scan's filter
+// by its primary key, row ID or an index. It reports false when none of
+// them can look the condition up.
+func lookupRows(e *Engine, table *catalog.Table, scan *ScanPlan) ([]*catalog.Row, bool, error) {
 	schema := table.GetSchema()
+	cond := scan.Filter.Conditions[0]
 
-	if filter == nil || len(filter.Conditions) == 0 {
-		return table.Scan()
+	if cond.Operator == "=" && cond.Column == catalog.RowIDColumn && schema.HasRowID() {
+		key, err := createKeyFromValue(cond.Value, catalog.TypeInt)
+		if err == nil {
+			rows, err := lookupRow(table.Get(key))
+			return rows, true, err
+		}
 	}
 
-	if len(filter.Conditions) == 1 {
-		cond := filter.Conditions[0]
-
-		if cond.Operator == "=" {
-			pkCol := getPrimaryKeyColumn(schema)
-			if pkCol != nil && cond.Column == pkCol.Name {
-				key, err := createKeyFromValue(cond.Value, pkCol.Type)
-				if err == nil {
-					row, err := table.Get(key)
-					if err != nil {
-						return []*catalog.Row{}, nil
-					}
-					return []*catalog.Row{row}, nil
-				}
+	if cond.Operator == "=" && !schema.Clustered() {
+		pkCol := getPrimaryKeyColumn(schema)
+		if pkCol != nil && cond.Column == pkCol.Name {
+			key, err := createKeyFromValue(cond.Value, pkCol.Type)
+			if err == nil {
+				rows, err := lookupRow(table.Get(key))
+				return rows, true, err
 			}
 		}
+	}
+
+	indexes := table.Catalog.GetTableIndexes(schema.Name)
+	for _, idx := range indexes {
+		if idx.ColumnName == cond.Column && scan.Hint.Allows(idx.Name) {
+			col := schema.GetColumn(cond.Column)
+			if col == nil {
+				continue
+			}
 
-		indexes := table.Catalog.GetTableIndexes(schema.Name)
-		for _, idx := range indexes {
-			if idx.ColumnName == cond.Column {
-				col := schema.GetColumn(cond.Column)
-				if col == nil {
+			switch cond.Operator {
+			case "=":
+				value, err := literalValue(cond.Value, col.Type)
+				if err != nil {
 					continue
 				}
+				rows, err := table.GetByIndex(idx.Name, value)
+				return rows, true, err
 
-				switch cond.Operator {
-				case "=":
-					value, err := convertValue(cond.Value, col.Type)
-					if err != nil {
-						continue
-					}
-					row, err := table.GetByIndex(idx.Name, value)
-					if err != nil {
-						return []*catalog.Row{}, nil
-					}
-					return []*catalog.Row{row}, nil
+			case ">", ">=", "<", "<=":
+				if _, err := literalValue(cond.Value, col.Type); err != nil {
+					continue
+				}
+				rows, err := executeIndexRangeScan(e, table, idx, cond, col.Type)
+				return rows, true, err
 
-				case ">", ">=", "<", "<=":
-					rows, err := executeIndexRangeScan(table, idx, cond, col.Type)
-					if err == nil {
-						return rows, nil
-					}
+			case "LIKE", "ILIKE":
+				if _, ok := likePrefix(cond.Value.Text, cond.Operator == "ILIKE"); !ok || col.Type != catalog.TypeText {
+					continue
 				}
+				rows, err := executeIndexRangeScan(e, table, idx, cond, col.Type)
+				return rows, true, err
 			}
 		}
 	}
+	return nil, false, nil
+}
 
-	rows, err := table.Scan()
+// lookupRow turns the result of a point lookup into rows: none if the key
+// is not there, and the error if the lookup failed for any other reason, so
+// that UPDATE and DELETE never mistake a failed lookup for no match.
+func lookupRow(row *catalog.Row, err error) ([]*catalog.Row, error) {
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return []*catalog.Row{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-
-	return filterRows(rows, filter), nil
+	return []*catalog.Row{row}, nil
 }
 
 func executeUpdate(e *Engine, plan *UpdatePlan) (string, error) {
-	table, err := e.catalog.LoadTable(plan.Table)
+	table, err := e.loadTable(plan.Table)
 	if err != nil {
 		return "", fmt.Errorf("table not found: %w", err)
 	}
 
 	schema := table.GetSchema()
 
-	rows, err := executeFilteredScan(table, plan.Scan.Filter)
+	rows, err := executeFilteredScan(e, table, plan.Scan, scanLimit(plan.OrderBy, plan.Limit))
 	if err != nil {
 		return "", fmt.Errorf("scan failed: %w", err)
 	}
+	rows, err = limitRows(rows, schema, plan.Table, plan.OrderBy, plan.Limit)
+	if err != nil {
+		return "", err
+	}
+
+	if err := e.checkDeadline(); err != nil {
+		return "", err
+	}
 
 	updatedCount := 0
 	var updateErrors []string
@@ -839,14 +1438,14 @@ func executeUpdate(e *Engine, plan *UpdatePlan) (string, error) {
 		for _, assignment := range plan.Assignments {
 			col := schema.GetColumn(assignment.Column)
 			if col == nil {
-				return "", fmt.Errorf("column '%s' not found", assignment.Column)
+				return "", sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found", assignment.Column)
 			}
 
 			if col.PrimaryKey {
 				return "", fmt.Errorf("cannot update primary key column '%s'", assignment.Column)
 			}
 
-			typedValue, err := convertValue(assignment.Value, col.Type)
+			typedValue, err := assignedValue(assignment, row, col.Type)
 			if err != nil {
 				return "", fmt.Errorf("invalid value for column '%s': %w", assignment.Column, err)
 			}
@@ -866,15 +1465,17 @@ func executeUpdate(e *Engine, plan *UpdatePlan) (string, error) {
 		for i, col := range schema.Columns {
 			rv, exists := newRow.Values[col.Name]
 			if !exists {
-				if col.NotNull {
-					return "", fmt.Errorf("missing value for NOT NULL column '%s'", col.Name)
-				}
 				newValues[i] = nil
 			} else {
 				newValues[i] = rv.Value
 			}
 		}
 
+		if err := e.checkPolicies(plan.Table, newRow); err != nil {
+			updateErrors = append(updateErrors, fmt.Sprintf("row %v: %v", primaryKey, err))
+			continue
+		}
+
 		if err := table.Update(primaryKey, newValues); err != nil {
 			updateErrors = append(updateErrors, fmt.Sprintf("row %v: %v", primaryKey, err))
 			continue
@@ -882,6 +1483,8 @@ func executeUpdate(e *Engine, plan *UpdatePlan) (string, error) {
 
 		updatedCount++
 	}
+	e.planner.CountChanges(plan.Table, updatedCount)
+	e.rowCount = updatedCount
 
 	if len(updateErrors) > 0 {
 		errMsg := fmt.Sprintf("%d row(s) updated, %d error(s): %s",
@@ -893,17 +1496,26 @@ func executeUpdate(e *Engine, plan *UpdatePlan) (string, error) {
 }
 
 func executeDelete(e *Engine, plan *DeletePlan) (string, error) {
-	table, err := e.catalog.LoadTable(plan.Scan.Table)
+	table, err := e.loadTable(plan.Scan.Table)
 	if err != nil {
 		return "", fmt.Errorf("table not found: %w", err)
 	}
 
 	schema := table.GetSchema()
 
-	rows, err := executeFilteredScan(table, plan.Scan.Filter)
+	rows, err := executeFilteredScan(e, table, plan.Scan, scanLimit(plan.OrderBy, plan.Limit))
 	if err != nil {
 		return "", fmt.Errorf("scan failed: %w", err)
 	}
+	rows, err = limitRows(rows, schema, plan.Scan.Table, plan.OrderBy, plan.Limit)
+	if err != nil {
+		return "", err
+	}
+
+	// Past this point rows change, so a timeout must hit before it.
+	if err := e.checkDeadline(); err != nil {
+		return "", err
+	}
 
 	var keysToDelete []storage.Key
 	for _, row := range rows {
@@ -924,6 +1536,8 @@ func executeDelete(e *Engine, plan *DeletePlan) (string, error) {
 		}
 		deletedCount++
 	}
+	e.planner.AdjustRowCount(plan.Scan.Table, -deletedCount)
+	e.rowCount = deletedCount
 
 	if len(deleteErrors) > 0 {
 		errMsg := fmt.Sprintf("%d row(s) deleted, %d error(s): %s",
@@ -934,36 +1548,98 @@ func executeDelete(e *Engine, plan *DeletePlan) (string, error) {
 	return fmt.Sprintf("%d row(s) deleted", deletedCount), nil
 }
 
+// limitRows orders the rows an UPDATE or DELETE of table matched by
+// orderBy, ties keeping the order they were found in, and keeps the first
+// limit of them, all of them when limit is negative.
+func limitRows(rows []*catalog.Row, schema *catalog.Schema, table string, orderBy []OrderItem, limit int) ([]*catalog.Row, error) {
+	if len(orderBy) > 0 {
+		columns := make([]string, len(orderBy))
+		for i, item := range orderBy {
+			columns[i] = strings.TrimPrefix(item.Column, table+".")
+			if schema.GetColumn(columns[i]) == nil {
+				return nil, sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found", item.Column)
+			}
+		}
+		less := func(i, j int) bool {
+			for k, item := range orderBy {
+				cmp := compareValues(rows[i].Values[columns[k]].Value, rows[j].Values[columns[k]].Value)
+				if cmp != 0 {
+					if item.Direction == "DESC" {
+						return cmp > 0
+					}
+					return cmp < 0
+				}
+			}
+			return false
+		}
+		if limit >= 0 && limit < len(rows) {
+			top := make([]*catalog.Row, 0, limit)
+			for _, i := range topIndexes(len(rows), limit, less) {
+				top = append(top, rows[i])
+			}
+			return top, nil
+		}
+		sort.SliceStable(rows, less)
+	}
+	if limit >= 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows, nil
+}
+
+// scanLimit is how many matching rows a scan for a write with orderBy and
+// limit needs: limit when the rows are taken in scan order, and all of them,
+// -1, when they must be sorted first.
+func scanLimit(orderBy []OrderItem, limit int) int {
+	if len(orderBy) > 0 {
+		return -1
+	}
+	return limit
+}
+
+// assignedValue is the value a SET assignment gives row: its literal, or
+// the row's old value of the column it names.
+func assignedValue(assignment Assignment, row *catalog.Row, colType catalog.ColumnType) (interface{}, error) {
+	if assignment.Value.Kind == parser.Identifier {
+		if rv, ok := row.Values[assignment.Value.Text]; ok {
+			return literalValue(parser.ValueOf(rv.Value), colType)
+		}
+	}
+	return literalValue(assignment.Value, colType)
+}
+
 // Utility functions from original executor
 
-func executeIndexRangeScan(table *catalog.Table, idx *catalog.IndexMetadata,
+func executeIndexRangeScan(e *Engine, table *catalog.Table, idx *catalog.IndexMetadata,
 	cond Condition, colType catalog.ColumnType) ([]*catalog.Row, error) {
 
 	col := table.GetSchema().GetColumn(idx.ColumnName)
 	if col == nil {
-		return nil, fmt.Errorf("column not found")
+		return nil, sqlerr.New(sqlerr.UndefinedColumn, "column not found")
 	}
 
-	value, err := convertValue(cond.Value, colType)
+	value, err := literalValue(cond.Value, colType)
 	if err != nil {
 		return nil, err
 	}
 
+	// The bounds are inclusive; the filter below drops the value itself for
+	// a strict comparison.
 	var startValue, endValue interface{}
 
 	switch cond.Operator {
-	case ">":
-		startValue = getNextValue(value, colType)
-		endValue = getMaxValue(colType)
-	case ">=":
+	case ">", ">=":
 		startValue = value
 		endValue = getMaxValue(colType)
-	case "<":
-		startValue = getMinValue(colType)
-		endValue = getPrevValue(value, colType)
-	case "<=":
+	case "<", "<=":
 		startValue = getMinValue(colType)
 		endValue = value
+	case "LIKE", "ILIKE":
+		// Every match sorts between the pattern's literal prefix and
+		// that prefix followed by the largest bytes.
+		prefix, _ := likePrefix(cond.Value.Text, cond.Operator == "ILIKE")
+		startValue = prefix
+		endValue = prefix + getMaxValue(colType).(string)
 	default:
 		return nil, fmt.Errorf("unsupported range operator: %s", cond.Operator)
 	}
@@ -973,14 +1649,7 @@ func executeIndexRangeScan(table *catalog.Table, idx *catalog.IndexMetadata,
 		return nil, err
 	}
 
-	filtered := make([]*catalog.Row, 0, len(rows))
-	for _, row := range rows {
-		if matchesCondition(row, cond) {
-			filtered = append(filtered, row)
-		}
-	}
-
-	return filtered, nil
+	return filterRows(e, rows, &FilterPlan{Conditions: []Condition{cond}}, table.GetSchema()), nil
 }
 
 func getPrimaryKeyColumn(schema *catalog.Schema) *catalog.Column {
@@ -992,8 +1661,8 @@ func getPrimaryKeyColumn(schema *catalog.Schema) *catalog.Column {
 	return nil
 }
 
-func createKeyFromValue(value string, colType catalog.ColumnType) (storage.Key, error) {
-	typedValue, err := convertValue(value, colType)
+func createKeyFromValue(value parser.Value, colType catalog.ColumnType) (storage.Key, error) {
+	typedValue, err := literalValue(value, colType)
 	if err != nil {
 		return nil, err
 	}
@@ -1006,7 +1675,7 @@ func getMinValue(colType catalog.ColumnType) interface{} {
 		return int64(-9223372036854775808)
 	case catalog.TypeFloat:
 		return float64(-1.7976931348623157e+308)
-	case catalog.TypeText:
+	case catalog.TypeText, catalog.TypeJSON, catalog.TypeEnum:
 		return ""
 	case catalog.TypeBoolean:
 		return false
@@ -1021,7 +1690,7 @@ func getMaxValue(colType catalog.ColumnType) interface{} {
 		return int64(9223372036854775807)
 	case catalog.TypeFloat:
 		return float64(1.7976931348623157e+308)
-	case catalog.TypeText:
+	case catalog.TypeText, catalog.TypeJSON, catalog.TypeEnum:
 		return string([]byte{0xFF, 0xFF, 0xFF, 0xFF})
 	case catalog.TypeBoolean:
 		return true
@@ -1030,66 +1699,32 @@ func getMaxValue(colType catalog.ColumnType) interface{} {
 	}
 }
 
-func getNextValue(value interface{}, colType catalog.ColumnType) interface{} {
-	switch colType {
-	case catalog.TypeInt:
-		if v, ok := value.(int64); ok {
-			return v + 1
-		}
-	case catalog.TypeFloat:
-		if v, ok := value.(float64); ok {
-			return v + 0.0000000001
-		}
-	case catalog.TypeText:
-		if v, ok := value.(string); ok {
-			return v + string([]byte{0x00})
-		}
-	}
-	return value
-}
-
-func getPrevValue(value interface{}, colType catalog.ColumnType) interface{} {
-	switch colType {
-	case catalog.TypeInt:
-		if v, ok := value.(int64); ok {
-			return v - 1
-		}
-	case catalog.TypeFloat:
-		if v, ok := value.(float64); ok {
-			return v - 0.0000000001
-		}
-	case catalog.TypeText:
-		if v, ok := value.(string); ok && len(v) > 0 {
-			return v[:len(v)-1]
-		}
+func matchesCondition(e *Engine, row *catalog.Row, cond Condition) bool {
+	if cond.Expr != nil {
+		return matchesExprCondition(e, catalogRowGetter(row), cond)
 	}
-	return value
-}
 
-func matchesCondition(row *catalog.Row, cond Condition) bool {
 	rowValue, exists := row.Values[cond.Column]
 	if !exists {
 		return false
 	}
-	return evaluateCondition(rowValue.Value, cond.Operator, cond.Value, rowValue.Type)
+	value, ok := conditionValue(cond, catalogRowGetter(row))
+	return ok && evaluateCondition(rowValue.Value, cond.Operator, value, rowValue.Type)
 }
 
-func parseColumnType(typeStr string) catalog.ColumnType {
-	switch strings.ToUpper(typeStr) {
-	case "INT", "INTEGER":
-		return catalog.TypeInt
-	case "TEXT", "VARCHAR", "STRING", "CHAR":
-		return catalog.TypeText
-	case "FLOAT", "REAL", "DOUBLE":
-		return catalog.TypeFloat
-	case "BOOLEAN", "BOOL":
-		return catalog.TypeBoolean
-	default:
-		return catalog.TypeText
+// columnType resolves a declared column type. Unknown names fall back to
+// TEXT unless the engine is in strict mode.
+func columnType(typeStr string, strict bool) (catalog.ColumnType, error) {
+	if colType, ok := catalog.ParseColumnType(typeStr); ok {
+		return colType, nil
 	}
+	if strict {
+		return "", fmt.Errorf("unknown column type '%s'", typeStr)
+	}
+	return catalog.TypeText, nil
 }
 
-func convertValues(values []string, schema *catalog.Schema) ([]interface{}, error) {
+func convertValues(values []parser.Value, schema *catalog.Schema) ([]interface{}, error) {
 	result := make([]interface{}, len(values))
 
 	for i, col := range schema.Columns {
@@ -1097,15 +1732,7 @@ func convertValues(values []string, schema *catalog.Schema) ([]interface{}, erro
 			return nil, fmt.Errorf("missing value for column '%s'", col.Name)
 		}
 
-		if strings.ToUpper(values[i]) == "NULL" {
-			if col.NotNull {
-				return nil, fmt.Errorf("NULL value not allowed for NOT NULL column '%s'", col.Name)
-			}
-			result[i] = nil
-			continue
-		}
-
-		typedValue, err := convertValue(values[i], col.Type)
+		typedValue, err := literalValue(values[i], col.Type)
 		if err != nil {
 			return nil, fmt.Errorf("invalid value for column '%s': %w", col.Name, err)
 		}
@@ -1121,72 +1748,194 @@ func convertValue(value string, colType catalog.ColumnType) (interface{}, error)
 		return nil, nil
 	}
 
-	switch colType {
-	case catalog.TypeInt:
-		intVal, err := strconv.ParseInt(value, 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid integer: %w", err)
-		}
-		return intVal, nil
+	return catalog.CoerceValue(value, colType)
+}
 
-	case catalog.TypeFloat:
-		floatVal, err := strconv.ParseFloat(value, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid float: %w", err)
+// literalValue converts a value written in a statement. Unlike convertValue
+// only an unquoted NULL is NULL; 'NULL' is text. Numbers and booleans the
+// parser already read are used as they are when colType fits them, and
+// anything else converts from the text as written, so 42 stores as "42" in
+// a TEXT column.
+func literalValue(value parser.Value, colType catalog.ColumnType) (interface{}, error) {
+	switch lit := value.Literal.(type) {
+	case nil:
+		if value.Kind == parser.NullLiteral {
+			return nil, nil
 		}
-		return floatVal, nil
-
-	case catalog.TypeBoolean:
-		switch strings.ToUpper(value) {
-		case "TRUE", "1", "T", "YES", "Y":
-			return true, nil
-		case "FALSE", "0", "F", "NO", "N":
-			return false, nil
-		default:
-			return nil, fmt.Errorf("invalid boolean: %s", value)
+	case int64:
+		switch colType {
+		case catalog.TypeInt:
+			return lit, nil
+		case catalog.TypeFloat:
+			return float64(lit), nil
 		}
+	case float64:
+		if colType == catalog.TypeFloat {
+			return lit, nil
+		}
+	case bool:
+		if colType == catalog.TypeBoolean {
+			return lit, nil
+		}
+	}
+	return catalog.CoerceValue(value.Text, colType)
+}
 
-	case catalog.TypeText:
-		return value, nil
-
-	default:
-		return nil, fmt.Errorf("unsupported column type: %s", colType)
+// conditionValue is the value that cond compares a row against: its
+// literal, or the row's value of the column it names. It reports false when
+// nothing can match, as with NULL.
+func conditionValue(cond Condition, row columnGetter) (parser.Value, bool) {
+	switch cond.Value.Kind {
+	case parser.NullLiteral:
+		return cond.Value, false
+	case parser.Identifier:
+		value, ok := row(cond.Value.Text)
+		if !ok {
+			break
+		}
+		if value == nil {
+			return cond.Value, false
+		}
+		return parser.ValueOf(value), true
 	}
+	return cond.Value, true
 }
 
-func filterRows(rows []*catalog.Row, filter *FilterPlan) []*catalog.Row {
+func filterRows(e *Engine, rows []*catalog.Row, filter *FilterPlan, schema *catalog.Schema) []*catalog.Row {
 	if filter == nil {
 		return rows
 	}
 
+	bound := bindFilter(e, filter, schema)
 	var filtered []*catalog.Row
 	for _, row := range rows {
-		if matchesFilter(row, filter) {
+		if bound.matches(row) {
 			filtered = append(filtered, row)
 		}
 	}
 	return filtered
 }
 
-func matchesFilter(row *catalog.Row, filter *FilterPlan) bool {
-	for _, cond := range filter.Conditions {
-		rowValue, exists := row.Values[cond.Column]
-		if !exists {
+// boundFilter is a FilterPlan prepared for the rows of one table: each
+// literal is converted once for the type of its column, rather than for
+// every row it is compared with. The conditions of its predicates are
+// left unbound.
+type boundFilter struct {
+	conds  []boundCondition
+	filter *FilterPlan
+	e      *Engine
+}
+
+type boundCondition struct {
+	Condition
+	// bound is set when the literal has been converted to lit, and never
+	// when it cannot match the column. Expressions and column references
+	// depend on the row and are left unbound.
+	bound, never bool
+	colType      catalog.ColumnType
+	lit          interface{}
+}
+
+func bindFilter(e *Engine, filter *FilterPlan, schema *catalog.Schema) boundFilter {
+	conds := make([]boundCondition, len(filter.Conditions))
+	for i, cond := range filter.Conditions {
+		conds[i].Condition = cond
+		if cond.Expr != nil || cond.Value.Kind == parser.Identifier {
+			continue
+		}
+
+		var colType catalog.ColumnType
+		if col := schema.GetColumn(cond.Column); col != nil {
+			colType = col.Type
+		} else if cond.Column == catalog.RowIDColumn && schema.HasRowID() {
+			colType = catalog.TypeInt
+		} else {
+			continue
+		}
+
+		ok := cond.Value.Kind != parser.NullLiteral
+		if ok {
+			conds[i].lit, ok = typedLiteral(cond.Value, colType)
+		}
+		conds[i].bound, conds[i].never, conds[i].colType = true, !ok, colType
+	}
+	return boundFilter{conds: conds, filter: filter, e: e}
+}
+
+func (f boundFilter) matches(row *catalog.Row) bool {
+	for i := range f.conds {
+		cond := &f.conds[i]
+		if !cond.bound {
+			if !matchesCondition(f.e, row, cond.Condition) {
+				return false
+			}
+			continue
+		}
+		if cond.never {
 			return false
 		}
 
-		if rowValue.Value == nil {
+		rowValue, exists := row.Values[cond.Column]
+		if !exists || !compareTyped(rowValue.Value, cond.Operator, cond.lit, cond.colType) {
 			return false
 		}
+	}
+	return f.filter.predicatesHold(func(cond Condition) bool {
+		return matchesCondition(f.e, row, cond)
+	})
+}
 
-		if !evaluateCondition(rowValue.Value, cond.Operator, cond.Value, rowValue.Type) {
+func matchesFilter(e *Engine, row *catalog.Row, filter *FilterPlan) bool {
+	for _, cond := range filter.Conditions {
+		if !matchesCondition(e, row, cond) {
 			return false
 		}
 	}
-	return true
+	return filter.predicatesHold(func(cond Condition) bool {
+		return matchesCondition(e, row, cond)
+	})
+}
+
+func evaluateCondition(rowValue interface{}, operator string, condValue parser.Value, colType catalog.ColumnType) bool {
+	if rowValue == nil {
+		return false
+	}
+	lit, ok := typedLiteral(condValue, colType)
+	return ok && compareTyped(rowValue, operator, lit, colType)
+}
+
+// typedLiteral converts condValue for comparison with a column of colType,
+// reporting false when it cannot match any value of that type.
+func typedLiteral(condValue parser.Value, colType catalog.ColumnType) (interface{}, bool) {
+	switch colType {
+	case catalog.TypeInt:
+		if condInt, ok := condValue.Literal.(int64); ok {
+			return condInt, true
+		}
+		condInt, err := strconv.ParseInt(condValue.Text, 10, 64)
+		return condInt, err == nil
+
+	case catalog.TypeFloat:
+		return literalFloat(condValue)
+
+	case catalog.TypeText, catalog.TypeJSON, catalog.TypeEnum:
+		return condValue.Text, true
+
+	case catalog.TypeBoolean:
+		if condBool, ok := condValue.Literal.(bool); ok {
+			return condBool, true
+		}
+		condBool, err := parseBool(condValue.Text)
+		return condBool, err == nil
+
+	default:
+		return nil, false
+	}
 }
 
-func evaluateCondition(rowValue interface{}, operator, condValue string, colType catalog.ColumnType) bool {
+// compareTyped compares rowValue, from a column of colType, with lit as
+// converted by typedLiteral.
+func compareTyped(rowValue interface{}, operator string, lit interface{}, colType catalog.ColumnType) bool {
 	if rowValue == nil {
 		return false
 	}
@@ -1202,12 +1951,7 @@ func evaluateCondition(rowValue interface{}, operator, condValue string, colType
 			}
 		}
 
-		condInt, err := strconv.ParseInt(condValue, 10, 64)
-		if err != nil {
-			return false
-		}
-
-		return compareInt(rowInt, operator, condInt)
+		return compareInt(rowInt, operator, lit.(int64))
 
 	case catalog.TypeFloat:
 		rowFloat, ok := rowValue.(float64)
@@ -1215,20 +1959,15 @@ func evaluateCondition(rowValue interface{}, operator, condValue string, colType
 			return false
 		}
 
-		condFloat, err := strconv.ParseFloat(condValue, 64)
-		if err != nil {
-			return false
-		}
-
-		return compareFloat(rowFloat, operator, condFloat)
+		return compareFloat(rowFloat, operator, lit.(float64))
 
-	case catalog.TypeText:
+	case catalog.TypeText, catalog.TypeJSON, catalog.TypeEnum:
 		rowStr, ok := rowValue.(string)
 		if !ok {
 			rowStr = fmt.Sprintf("%v", rowValue)
 		}
 
-		return compareString(rowStr, operator, condValue)
+		return compareString(rowStr, operator, lit.(string))
 
 	case catalog.TypeBoolean:
 		rowBool, ok := rowValue.(bool)
@@ -1236,18 +1975,25 @@ func evaluateCondition(rowValue interface{}, operator, condValue string, colType
 			return false
 		}
 
-		condBool, err := parseBool(condValue)
-		if err != nil {
-			return false
-		}
-
-		return compareBool(rowBool, operator, condBool)
+		return compareBool(rowBool, operator, lit.(bool))
 
 	default:
 		return false
 	}
 }
 
+// literalFloat reads value as a float, from its number if it is one.
+func literalFloat(value parser.Value) (float64, bool) {
+	switch lit := value.Literal.(type) {
+	case float64:
+		return lit, true
+	case int64:
+		return float64(lit), true
+	}
+	f, err := strconv.ParseFloat(value.Text, 64)
+	return f, err == nil
+}
+
 func parseBool(value string) (bool, error) {
 	switch strings.ToUpper(value) {
 	case "TRUE", "1", "T", "YES", "Y":
@@ -1320,6 +2066,10 @@ func compareString(a, op, b string) bool {
 		return a < b
 	case "<=":
 		return a <= b
+	case "LIKE", "NOT LIKE", "ILIKE", "NOT ILIKE":
+		return matchLike(a, op, b)
+	case "REGEXP", "NOT REGEXP":
+		return matchRegexp(a, op, b)
 	default:
 		return false
 	}
@@ -1335,43 +2085,3 @@ func compareBool(a bool, op string, b bool) bool {
 		return false
 	}
 }
-
-func formatTableResults(rows []*catalog.Row, schema *catalog.Schema) string {
-	if len(rows) == 0 {
-		return "No rows found"
-	}
-
-	result := ""
-
-	for i, col := range schema.Columns {
-		if i > 0 {
-			result += " | "
-		}
-		result += fmt.Sprintf("%-15s", col.Name)
-	}
-	result += "\n"
-
-	for range schema.Columns {
-		result += "----------------"
-	}
-	result += "\n"
-
-	for _, row := range rows {
-		for i, col := range schema.Columns {
-			if i > 0 {
-				result += " | "
-			}
-
-			value := "NULL"
-			if rv, exists := row.Values[col.Name]; exists && rv.Value != nil {
-				value = fmt.Sprintf("%v", rv.Value)
-			}
-
-			result += fmt.Sprintf("%-15s", value)
-		}
-		result += "\n"
-	}
-
-	result += fmt.Sprintf("\n%d row(s) returned", len(rows))
-	return result
-}
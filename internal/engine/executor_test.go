@@ -0,0 +1,344 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// newIndexedTable creates t, with a secondary index on name, in e.
+func newIndexedTable(t *testing.T, e *Engine) {
+	t.Helper()
+	run(t, e,
+		"CREATE TABLE t (id INT PRIMARY KEY, name TEXT, n INT)",
+		"CREATE INDEX t_name ON t (name)",
+		"INSERT INTO t VALUES (1, 'a', 10)",
+		"INSERT INTO t VALUES (2, 'b', 20)",
+		"INSERT INTO t VALUES (3, 'c', 30)",
+	)
+}
+
+// exec runs a write statement and returns how many rows it affected.
+func exec(t *testing.T, e *Engine, sql string) int64 {
+	t.Helper()
+	node, err := parser.Parse(sql)
+	if err != nil {
+		t.Fatalf("parse %q: %v", sql, err)
+	}
+	affected, _, err := e.Exec(node)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	return affected
+}
+
+// query runs a SELECT and returns its rows, printed.
+func query(t *testing.T, e *Engine, sql string) string {
+	t.Helper()
+	node, err := parser.Parse(sql)
+	if err != nil {
+		t.Fatalf("parse %q: %v", sql, err)
+	}
+	cursor, err := e.Query(node)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	defer cursor.Close()
+	return fmt.Sprint(cursor.Fetch(0))
+}
+
+func TestIndexedWriteMiss(t *testing.T) {
+	e := newTestEngine(t)
+	newIndexedTable(t, e)
+
+	if n := exec(t, e, "UPDATE t SET n = 0 WHERE name = 'z'"); n != 0 {
+		t.Errorf("UPDATE of a missing name affected %d rows", n)
+	}
+	if n := exec(t, e, "DELETE FROM t WHERE name = 'z'"); n != 0 {
+		t.Errorf("DELETE of a missing name affected %d rows", n)
+	}
+	if got, want := query(t, e, "SELECT id, n FROM t ORDER BY id"), "[[1 10] [2 20] [3 30]]"; got != want {
+		t.Errorf("rows are %s, want %s", got, want)
+	}
+}
+
+func TestIndexedWriteHit(t *testing.T) {
+	e := newTestEngine(t)
+	newIndexedTable(t, e)
+
+	if n := exec(t, e, "UPDATE t SET n = 99 WHERE name = 'b'"); n != 1 {
+		t.Errorf("UPDATE affected %d rows, want 1", n)
+	}
+	if got, want := query(t, e, "SELECT id, n FROM t ORDER BY id"), "[[1 10] [2 99] [3 30]]"; got != want {
+		t.Errorf("after UPDATE rows are %s, want %s", got, want)
+	}
+
+	if n := exec(t, e, "DELETE FROM t WHERE name = 'a'"); n != 1 {
+		t.Errorf("DELETE affected %d rows, want 1", n)
+	}
+	if got, want := query(t, e, "SELECT id, n FROM t ORDER BY id"), "[[2 99] [3 30]]"; got != want {
+		t.Errorf("after DELETE rows are %s, want %s", got, want)
+	}
+}
+
+// TestIndexedWriteBrokenIndex drops a row from under its index entry, which
+// the lookup must report rather than take for a miss.
+func TestIndexedWriteBrokenIndex(t *testing.T) {
+	for _, sql := range []string{
+		"UPDATE t SET n = 0 WHERE name = 'b'",
+		"DELETE FROM t WHERE name = 'b'",
+	} {
+		e := newTestEngine(t)
+		newIndexedTable(t, e)
+		schema, err := e.catalog.GetTable("t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := storage.LoadBTree(e.storage.Pager, schema.RootPage, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := catalog.ValueToKey(int64(2), catalog.TypeInt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+
+		node, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := e.Exec(node); sqlerr.CodeOf(err) != sqlerr.DataCorrupted {
+			t.Errorf("%s: got error %v, want %s", sql, err, sqlerr.DataCorrupted)
+		}
+	}
+}
+
+func TestIndexDuplicateValues(t *testing.T) {
+	e := newTestEngine(t)
+	newIndexedTable(t, e)
+	run(t, e,
+		"INSERT INTO t VALUES (4, 'b', 40)",
+		"INSERT INTO t VALUES (5, 'b', 50)",
+	)
+
+	if got, want := query(t, e, "SELECT id FROM t WHERE name = 'b'"), "[[2] [4] [5]]"; got != want {
+		t.Errorf("lookup got %s, want %s", got, want)
+	}
+	if got, want := query(t, e, "SELECT id FROM t WHERE name <= 'b'"), "[[1] [2] [4] [5]]"; got != want {
+		t.Errorf("range got %s, want %s", got, want)
+	}
+	if n := exec(t, e, "DELETE FROM t WHERE name = 'b' AND n > 20"); n != 2 {
+		t.Errorf("DELETE affected %d rows, want 2", n)
+	}
+	if got, want := query(t, e, "SELECT id FROM t WHERE name = 'b'"), "[[2]]"; got != want {
+		t.Errorf("lookup after DELETE got %s, want %s", got, want)
+	}
+}
+
+func TestClusterRange(t *testing.T) {
+	e := newTestEngine(t)
+	run(t, e,
+		"CREATE TABLE m (id INT PRIMARY KEY, s TEXT, ts INT) CLUSTER BY (s, ts)",
+		"INSERT INTO m VALUES (1, 'x', 5)",
+		"INSERT INTO m VALUES (2, 'x', 3)",
+		"INSERT INTO m VALUES (3, 'y', 1)",
+		"INSERT INTO m VALUES (4, 'x', NULL)",
+		"INSERT INTO m VALUES (5, 'w', 9)",
+		"INSERT INTO m VALUES (6, 'x', 3)",
+	)
+
+	for _, tc := range []struct{ where, want string }{
+		{"s = 'x'", "[[2] [6] [1] [4]]"},
+		{"s = 'x' AND ts = 3", "[[2] [6]]"},
+		{"s = 'x' AND ts > 3", "[[1]]"},
+		{"s = 'x' AND ts <= 3", "[[2] [6]]"},
+		{"s > 'w'", "[[2] [6] [1] [4] [3]]"},
+	} {
+		if got := query(t, e, "SELECT id FROM m WHERE "+tc.where); got != tc.want {
+			t.Errorf("WHERE %s got %s, want %s", tc.where, got, tc.want)
+		}
+	}
+}
+
+func TestWriteLimit(t *testing.T) {
+	e := newTestEngine(t)
+	newIndexedTable(t, e)
+	run(t, e,
+		"INSERT INTO t VALUES (4, 'd', 20)",
+		"INSERT INTO t VALUES (5, 'e', 5)",
+	)
+
+	if n := exec(t, e, "UPDATE t SET name = 'x' WHERE n >= 10 LIMIT 2"); n != 2 {
+		t.Errorf("UPDATE LIMIT 2 affected %d rows", n)
+	}
+	if got, want := query(t, e, "SELECT id FROM t WHERE name = 'x'"), "[[1] [2]]"; got != want {
+		t.Errorf("UPDATE LIMIT changed %s, want the first matches %s", got, want)
+	}
+
+	if n := exec(t, e, "DELETE FROM t ORDER BY n DESC, id LIMIT 2"); n != 2 {
+		t.Errorf("DELETE ORDER BY LIMIT 2 affected %d rows", n)
+	}
+	if got, want := query(t, e, "SELECT id FROM t"), "[[1] [4] [5]]"; got != want {
+		t.Errorf("DELETE ORDER BY n DESC LIMIT 2 left %s, want %s", got, want)
+	}
+
+	if n := exec(t, e, "DELETE FROM t LIMIT 0"); n != 0 {
+		t.Errorf("DELETE LIMIT 0 affected %d rows", n)
+	}
+}
+
+func TestWhereAlias(t *testing.T) {
+	e := newTestEngine(t)
+	newIndexedTable(t, e)
+
+	for _, sql := range []string{
+		"SELECT n AS x FROM t WHERE x > 1",
+		"SELECT n AS x FROM t WHERE id = 1 OR x > 1",
+		"SELECT n AS x FROM t WHERE n = x",
+	} {
+		node, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q: %v", sql, err)
+		}
+		if _, err := e.Query(node); sqlerr.CodeOf(err) != sqlerr.UndefinedColumn {
+			t.Errorf("%s: got %v, want UndefinedColumn", sql, err)
+		}
+	}
+
+	// An alias that is also a column's name filters by the column.
+	if got, want := query(t, e, "SELECT n AS id FROM t WHERE id = 2"), "[[20]]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestUnknownColumn(t *testing.T) {
+	e := newTestEngine(t)
+	newIndexedTable(t, e)
+
+	for _, sql := range []string{
+		"SELECT id FROM t WHERE nmae = 'a'",
+		"SELECT id FROM t AS u WHERE u.nmae = 'a'",
+		"SELECT id FROM t WHERE id = 1 OR nmae = 'a'",
+		"SELECT id FROM t WHERE UPPER(nmae) = 'A'",
+		"SELECT id FROM t ORDER BY nmae",
+		"SELECT t.id FROM t JOIN t AS u ON t.id = u.id WHERE u.nmae = 'a'",
+		"UPDATE t SET n = 0 WHERE nmae = 'a'",
+		"UPDATE t SET n = 0 WHERE id > 0 ORDER BY nmae LIMIT 1",
+		"DELETE FROM t WHERE t.nmae = 'a'",
+		"DELETE FROM t WHERE id > 0 ORDER BY nmae LIMIT 1",
+	} {
+		node, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q: %v", sql, err)
+		}
+		if _, err := e.Run(node); sqlerr.CodeOf(err) != sqlerr.UndefinedColumn {
+			t.Errorf("%s: got %v, want UndefinedColumn", sql, err)
+		}
+	}
+	if got, want := query(t, e, "SELECT id FROM t"), "[[1] [2] [3]]"; got != want {
+		t.Errorf("rows changed: got %s, want %s", got, want)
+	}
+
+	// ORDER BY may name a select item by its alias.
+	if got, want := query(t, e, "SELECT n AS x FROM t ORDER BY x DESC"), "[[30] [20] [10]]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestUnknownWord(t *testing.T) {
+	e := newTestEngine(t)
+	newIndexedTable(t, e)
+
+	for _, sql := range []string{
+		"SELECT id FROM t WHERE name = nmae",
+		"SELECT id FROM t AS u WHERE name = u.nmae",
+		"UPDATE t SET name = nmae WHERE id = 1",
+		"DELETE FROM t WHERE name = nmae",
+		"INSERT INTO t VALUES (4, nmae, 40)",
+	} {
+		node, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q: %v", sql, err)
+		}
+		if _, err := e.Run(node); sqlerr.CodeOf(err) != sqlerr.UndefinedColumn {
+			t.Errorf("%s: got %v, want UndefinedColumn", sql, err)
+		}
+	}
+	if got, want := query(t, e, "SELECT id, name FROM t"), "[[1 a] [2 b] [3 c]]"; got != want {
+		t.Errorf("rows changed: got %s, want %s", got, want)
+	}
+
+	// A bare word naming a column reads it.
+	if n := exec(t, e, "UPDATE t SET n = id WHERE id = 2"); n != 1 {
+		t.Errorf("UPDATE affected %d rows, want 1", n)
+	}
+	if got, want := query(t, e, "SELECT id FROM t WHERE n = id"), "[[2]]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestTwoHandles(t *testing.T) {
+	fs := storage.NewMemFS()
+	a, err := OpenEngine(fs, "test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	newIndexedTable(t, a)
+
+	b, err := OpenEngine(fs, "test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if got, want := query(t, b, "SELECT id, n FROM t WHERE id = 1"), "[[1 10]]"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	run(t, a,
+		"UPDATE t SET n = 11 WHERE id = 1",
+		"INSERT INTO t VALUES (4, 'd', 40)",
+	)
+	if got, want := query(t, b, "SELECT id, n FROM t WHERE id = 1"), "[[1 11]]"; got != want {
+		t.Errorf("b read %s after a's update, want %s", got, want)
+	}
+	if got, want := query(t, b, "SELECT COUNT(*) FROM t"), "[[4]]"; got != want {
+		t.Errorf("b counted %s after a's insert, want %s", got, want)
+	}
+
+	// b's write is based on what a wrote, and a sees it.
+	run(t, b, "INSERT INTO t VALUES (5, 'e', 50)")
+	if got, want := query(t, a, "SELECT id FROM t WHERE n >= 40"), "[[4] [5]]"; got != want {
+		t.Errorf("a read %s after b's insert, want %s", got, want)
+	}
+}
+
+func TestAbandonedIndexBuildFreesPages(t *testing.T) {
+	e := newTestEngine(t)
+	run(t, e, "CREATE TABLE t (id INT PRIMARY KEY, name TEXT)")
+	for i := 1; i <= 1500; i++ {
+		run(t, e, fmt.Sprintf("INSERT INTO t VALUES (%d, 'name %d')", i, i%1200))
+	}
+	free := e.storage.Pager.GetHeader().FreePages
+
+	node, err := parser.Parse("CREATE UNIQUE INDEX CONCURRENTLY t_name ON t (name)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Run(node); sqlerr.CodeOf(err) != sqlerr.UniqueViolation {
+		t.Fatalf("got %v, want UniqueViolation", err)
+	}
+	if got := e.storage.Pager.GetHeader().FreePages; got <= free {
+		t.Errorf("the abandoned build left %d free pages, %d before it", got, free)
+	}
+	if err := e.CheckIntegrity(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+type scalarFunc func(args []interface{}) (interface{}, error)
+
+// scalarFunctions holds the built-in functions callable from expressions,
+// keyed by upper-case name.
+var scalarFunctions = map[string]scalarFunc{
+	"COALESCE":     fnCoalesce,
+	"IFNULL":       fnIfNull,
+	"NULLIF":       fnNullIf,
+	"JSON_EXTRACT": fnJSONExtract,
+	"JSON_VALID":   fnJSONValid,
+}
+
+// engineFunc is a built-in function that reads the state of the engine it
+// runs in.
+type engineFunc func(e *Engine, args []interface{}) (interface{}, error)
+
+// engineFunctions holds the built-in functions that draw from the engine's
+// random source, keyed by upper-case name.
+var engineFunctions = map[string]engineFunc{
+	"RANDOM": fnRandom,
+	"RAND":   fnRandom,
+}
+
+// columnGetter reads a column of the row an expression is evaluated against.
+type columnGetter func(name string) (interface{}, bool)
+
+// evalExpr evaluates a scalar expression against a result row in e.
+func evalExpr(e *Engine, expr parser.Expr, row columnGetter) (interface{}, error) {
+	switch ex := expr.(type) {
+	case *parser.ColumnExpr:
+		return lookupColumn(row, ex.Name)
+
+	case *parser.LiteralExpr:
+		if ex.Null {
+			return nil, nil
+		}
+		if ex.Quoted {
+			return ex.Value, nil
+		}
+		return parseNumber(ex.Value)
+
+	case *parser.CastExpr:
+		target, ok := catalog.ParseColumnType(ex.Type)
+		if !ok {
+			return nil, fmt.Errorf("unknown type %s in CAST", ex.Type)
+		}
+		value, err := evalExpr(e, ex.Expr, row)
+		if err != nil {
+			return nil, err
+		}
+		return catalog.CastValue(value, target)
+
+	case *parser.JSONPathExpr:
+		doc, err := evalExpr(e, ex.Expr, row)
+		if err != nil {
+			return nil, err
+		}
+		path, err := evalExpr(e, ex.Path, row)
+		if err != nil {
+			return nil, err
+		}
+		return evalJSONPath(doc, path, ex.AsText)
+
+	case *parser.FuncExpr:
+		fn, ok := scalarFunctions[ex.Name]
+		engineFn, isEngineFn := engineFunctions[ex.Name]
+		if !ok && !isEngineFn {
+			return nil, sqlerr.New(sqlerr.UndefinedFunction, "unknown function %s", ex.Name)
+		}
+		args := make([]interface{}, len(ex.Args))
+		for i, arg := range ex.Args {
+			value, err := evalExpr(e, arg, row)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = value
+		}
+		if isEngineFn {
+			return engineFn(e, args)
+		}
+		return fn(args)
+
+	default:
+		return nil, fmt.Errorf("unsupported expression %T", expr)
+	}
+}
+
+// lookupColumn resolves a possibly qualified name, falling back to the bare
+// column name when the row carries no qualified key for it.
+func lookupColumn(row columnGetter, name string) (interface{}, error) {
+	if value, exists := row(name); exists {
+		return value, nil
+	}
+	if dot := strings.LastIndexByte(name, '.'); dot >= 0 {
+		if value, exists := row(name[dot+1:]); exists {
+			return value, nil
+		}
+	}
+	return nil, sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found", name)
+}
+
+func parseNumber(text string) (interface{}, error) {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number: %s", text)
+	}
+	return f, nil
+}
+
+func catalogRowGetter(row *catalog.Row) columnGetter {
+	return func(name string) (interface{}, bool) {
+		rv, exists := row.Values[name]
+		return rv.Value, exists
+	}
+}
+
+// matchesExprCondition evaluates a condition whose left-hand side is an
+// expression. A failed evaluation never matches.
+func matchesExprCondition(e *Engine, row columnGetter, cond Condition) bool {
+	value, err := evalExpr(e, cond.Expr, row)
+	if err != nil {
+		return false
+	}
+	other, ok := conditionValue(cond, row)
+	return ok && evaluateConditionMap(value, cond.Operator, other)
+}
+
+// outputColumns names the columns the plan projects: their aliases, or the
+// columns themselves.
+func (p *ProjectPlan) outputColumns() []string {
+	if p.Aliases == nil {
+		return p.Columns
+	}
+	names := make([]string, len(p.Columns))
+	for i, col := range p.Columns {
+		names[i] = col
+		if p.Aliases[i] != "" {
+			names[i] = p.Aliases[i]
+		}
+	}
+	return names
+}
+
+func projectResultSet(e *Engine, plan *ProjectPlan, input *ResultSet) (*ResultSet, error) {
+	if len(plan.Columns) == 1 && plan.Columns[0] == "*" {
+		if plan.Distinct {
+			input.Rows = distinctRows(input)
+		}
+		return input, nil
+	}
+
+	positions := input.columnIndexes(plan.Columns)
+	for i, col := range plan.Columns {
+		if positions[i] < 0 && (i >= len(plan.Exprs) || plan.Exprs[i] == nil) && len(input.Rows) > 0 {
+			return nil, sqlerr.New(sqlerr.UndefinedColumn, "column '%s' not found", col)
+		}
+	}
+	projected := &ResultSet{
+		Schema:  plan.outputColumns(),
+		Rows:    make([][]interface{}, 0, len(input.Rows)),
+		Aliases: input.Aliases,
+	}
+	alloc := newRowAllocator(len(plan.Columns))
+	cursor := newRowCursor(input)
+	err := eachBatch(input.Rows, func(batch [][]interface{}) error {
+		for _, row := range batch {
+			cursor.row = row
+			projectedRow := alloc.row()
+			for i := range plan.Columns {
+				if i < len(plan.Exprs) && plan.Exprs[i] != nil {
+					value, err := evalExpr(e, plan.Exprs[i], cursor.get)
+					if err != nil {
+						return err
+					}
+					projectedRow[i] = value
+					continue
+				}
+				projectedRow[i] = row[positions[i]]
+			}
+			projected.Rows = append(projected.Rows, projectedRow)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if plan.Distinct {
+		projected.Rows = distinctRows(projected)
+	}
+
+	return projected, nil
+}
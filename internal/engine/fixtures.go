@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+)
+
+// LoadFixtures fills the database from the files in dir of fsys, such as an
+// os.DirFS or an embed.FS, for the tests of an application: typically a
+// fresh database opened in a storage.MemFS. Files are loaded in name order.
+// A .sql file is a script of statements, see parser.SplitScript, and a
+// .csv file is bulk loaded into the table it is named after, see LoadCSV;
+// its first line is a header and its columns are in the table's order.
+// Other files are ignored. Everything is loaded in one batch, so a failure leaves the
+// database as it was. It returns the number of rows inserted.
+func (e *Engine) LoadFixtures(fsys fs.FS, dir string) (int, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return 0, err
+	}
+
+	rows := 0
+	err = e.Batch(func() error {
+		for _, entry := range entries {
+			name := entry.Name()
+			ext := path.Ext(name)
+			if entry.IsDir() || (ext != ".sql" && ext != ".csv") {
+				continue
+			}
+			n, err := e.loadFixture(fsys, path.Join(dir, name), strings.TrimSuffix(name, ext), ext)
+			if err != nil {
+				return fmt.Errorf("fixture %s: %w", name, err)
+			}
+			rows += n
+		}
+		return nil
+	})
+	if err != nil {
+		// Row counts adjusted by the loads that were rolled back are read
+		// again.
+		if statsErr := e.planner.LoadStats(); statsErr != nil {
+			e.log(LogWarn, "failed to reload statistics", map[string]interface{}{"error": statsErr.Error()})
+		}
+		return 0, err
+	}
+	return rows, nil
+}
+
+func (e *Engine) loadFixture(fsys fs.FS, file, table, ext string) (int, error) {
+	if ext == ".csv" {
+		f, err := fsys.Open(file)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		return e.LoadCSV(table, f, true)
+	}
+
+	data, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return 0, err
+	}
+	rows := 0
+	for i, statement := range parser.SplitScript(string(data)) {
+		node, err := parser.Parse(statement)
+		if err != nil {
+			return 0, fmt.Errorf("statement %d: %w", i+1, err)
+		}
+		n, _, err := e.Exec(node)
+		if err != nil {
+			return 0, fmt.Errorf("statement %d: %w", i+1, err)
+		}
+		rows += int(n)
+	}
+	return rows, nil
+}
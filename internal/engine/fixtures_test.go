@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadFixtures(t *testing.T) {
+	e := newTestEngine(t)
+	fixtures := fstest.MapFS{
+		"testdata/00_schema.sql": {Data: []byte(`CREATE TABLE users (
+  id INT PRIMARY KEY,
+  name TEXT
+);
+-- seeded below
+CREATE INDEX users_name
+  ON users (name);
+INSERT INTO users VALUES (1, 'ann'); INSERT INTO users VALUES (2, 'b;c');
+`)},
+		"testdata/users.csv": {Data: []byte("id,name\n3,dan\n")},
+	}
+
+	n, err := e.LoadFixtures(fixtures, "testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("loaded %d rows, want 3", n)
+	}
+	if got, want := query(t, e, "SELECT id, name FROM users"), "[[1 ann] [2 b;c] [3 dan]]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
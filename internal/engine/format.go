@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// Stream executes node like Run but writes the result to w as it is
+// formatted, so a large result starts printing at once and its text is never
+// built up in memory. Each result ends with a newline.
+func (e *Engine) Stream(w io.Writer, node parser.Node) error {
+	return e.StreamContext(context.Background(), w, node)
+}
+
+// StreamContext is Stream with cancellation, see RunContext.
+func (e *Engine) StreamContext(ctx context.Context, w io.Writer, node parser.Node) error {
+	out := bufio.NewWriter(ctxWriter{ctx, w})
+	e.output = out
+	result, err := e.RunContext(ctx, node)
+	e.output = nil
+
+	if err == nil {
+		out.WriteString(result)
+		out.WriteString("\n")
+	}
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// ctxWriter stops writing once ctx is cancelled, so cancelling a statement
+// also cuts off a long result that is still being printed.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, sqlerr.New(sqlerr.QueryCanceled, "statement canceled: %v", err)
+	}
+	return w.w.Write(p)
+}
+
+// formatResults renders a result set in the session's output_format. While
+// streaming it writes the result out and returns "", and under Query it
+// hands the result set to the cursor unformatted.
+func (e *Engine) formatResults(rs *ResultSet) string {
+	e.rowCount = len(rs.Rows)
+	e.formatted = rs
+	if e.cursor != nil {
+		e.cursor.rs, e.cursor.returnsRows = rs, true
+		return ""
+	}
+	if e.output != nil {
+		writeResults(e.output, rs, e.outputFormat, !e.hideHeaders)
+		return ""
+	}
+
+	var b strings.Builder
+	writeResults(&b, rs, e.outputFormat, !e.hideHeaders)
+	return b.String()
+}
+
+func writeResults(w io.Writer, rs *ResultSet, format string, headers bool) {
+	switch format {
+	case "csv":
+		writeCSV(w, rs, headers)
+	case "json":
+		writeJSON(w, rs)
+	default:
+		writeTable(w, rs, headers)
+	}
+}
+
+func writeTable(w io.Writer, rs *ResultSet, headers bool) {
+	if len(rs.Rows) == 0 {
+		io.WriteString(w, "No rows found")
+		return
+	}
+
+	if headers {
+		for i, col := range rs.Schema {
+			if i > 0 {
+				io.WriteString(w, " | ")
+			}
+			fmt.Fprintf(w, "%-15s", col)
+		}
+		io.WriteString(w, "\n")
+
+		io.WriteString(w, strings.Repeat("----------------", len(rs.Schema)))
+		io.WriteString(w, "\n")
+	}
+
+	// Rows
+	for _, row := range rs.Rows {
+		for i := range rs.Schema {
+			if i > 0 {
+				io.WriteString(w, " | ")
+			}
+
+			value := "NULL"
+			if v := row[i]; v != nil {
+				value = fmt.Sprintf("%v", v)
+			}
+
+			fmt.Fprintf(w, "%-15s", value)
+		}
+		io.WriteString(w, "\n")
+	}
+
+	fmt.Fprintf(w, "\n%d row(s) returned", len(rs.Rows))
+}
+
+// writeCSV writes a header record, unless headers is false, and one record
+// per row, without a newline after the last one.
+func writeCSV(w io.Writer, rs *ResultSet, headers bool) {
+	var line strings.Builder
+	cw := csv.NewWriter(&line)
+	first := true
+	writeRecord := func(record []string) {
+		if !first {
+			io.WriteString(w, "\n")
+		}
+		first = false
+		line.Reset()
+		cw.Write(record)
+		cw.Flush()
+		io.WriteString(w, strings.TrimSuffix(line.String(), "\n"))
+	}
+
+	if headers {
+		writeRecord(rs.Schema)
+	}
+	record := make([]string, len(rs.Schema))
+	for _, row := range rs.Rows {
+		for i, v := range row[:len(rs.Schema)] {
+			record[i] = ""
+			if v != nil {
+				record[i] = fmt.Sprintf("%v", v)
+			}
+		}
+		writeRecord(record)
+	}
+}
+
+// writeJSON writes one object per row. Keys follow the schema order, which
+// encoding/json cannot do for a map.
+func writeJSON(w io.Writer, rs *ResultSet) {
+	keys := make([][]byte, len(rs.Schema))
+	for i, col := range rs.Schema {
+		keys[i], _ = json.Marshal(col)
+	}
+
+	io.WriteString(w, "[")
+	for i, row := range rs.Rows {
+		if i > 0 {
+			io.WriteString(w, ",")
+		}
+		io.WriteString(w, "\n  {")
+		for j := range rs.Schema {
+			if j > 0 {
+				io.WriteString(w, ", ")
+			}
+			value, err := json.Marshal(row[j])
+			if err != nil {
+				value, _ = json.Marshal(fmt.Sprintf("%v", row[j]))
+			}
+			w.Write(keys[j])
+			io.WriteString(w, ": ")
+			w.Write(value)
+		}
+		io.WriteString(w, "}")
+	}
+	if len(rs.Rows) > 0 {
+		io.WriteString(w, "\n")
+	}
+	io.WriteString(w, "]")
+}
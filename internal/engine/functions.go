@@ -0,0 +1,43 @@
+package engine
+
+import "fmt"
+
+func fnCoalesce(args []interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("COALESCE requires at least one argument")
+	}
+	for _, arg := range args {
+		if arg != nil {
+			return arg, nil
+		}
+	}
+	return nil, nil
+}
+
+func fnIfNull(args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("IFNULL requires 2 arguments, got %d", len(args))
+	}
+	return fnCoalesce(args)
+}
+
+// fnNullIf returns NULL when both arguments are equal and the first one
+// otherwise.
+func fnNullIf(args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("NULLIF requires 2 arguments, got %d", len(args))
+	}
+	if args[0] != nil && args[1] != nil && compareValues(args[0], args[1]) == 0 {
+		return nil, nil
+	}
+	return args[0], nil
+}
+
+// fnRandom returns a number drawn uniformly from [0, 1) from the engine's
+// random source, a new one for every row.
+func fnRandom(e *Engine, args []interface{}) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("RANDOM takes no arguments, got %d", len(args))
+	}
+	return e.randomFloat()
+}
@@ -0,0 +1,27 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestRandomFromEngineSource runs the same query in two engines seeded
+// alike, which must draw the same numbers and the same sample.
+func TestRandomFromEngineSource(t *testing.T) {
+	var results [2]string
+	for i := range results {
+		e := newTestEngine(t)
+		e.SetDeterministic(7)
+		run(t, e, "CREATE TABLE t (id INT PRIMARY KEY)")
+		for id := 0; id < 20; id++ {
+			run(t, e, fmt.Sprintf("INSERT INTO t VALUES (%d)", id))
+		}
+		results[i] = query(t, e, "SELECT id, RANDOM() FROM t TABLESAMPLE BERNOULLI (50)")
+	}
+	if results[0] != results[1] {
+		t.Errorf("runs seeded alike differ:\n%s\n%s", results[0], results[1])
+	}
+	if results[0] == "[]" {
+		t.Error("the sample kept no rows")
+	}
+}
@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+)
+
+// RowChange is a row written to a table of the main database, as hooks see
+// it. Old and New hold the values of Columns, which end with _rowid_ for a
+// table without a primary key; Old is nil for an insert and New for a
+// delete.
+type RowChange struct {
+	Table   string
+	Op      catalog.ChangeOp
+	Columns []string
+	Old     []interface{}
+	New     []interface{}
+}
+
+// OnUpdate registers fn to be called with every row inserted, updated or
+// deleted in the main database, as it is written. Inside a batch that later
+// rolls back fn has already seen the rows; OnChange only sees committed
+// ones. Hooks run on the goroutine running the statement and must not run
+// statements on the engine. The returned function unregisters fn.
+func (e *Engine) OnUpdate(fn func(RowChange)) (remove func()) {
+	return e.catalog.OnUpdate(func(change catalog.Change) {
+		fn(e.rowChange(change))
+	})
+}
+
+// OnChange registers fn to be called with the rows written by each commit:
+// every row of a batch together once it has committed, and each row on its
+// own outside a batch, where writes commit as they happen.
+func (e *Engine) OnChange(fn func([]RowChange)) (remove func()) {
+	return e.catalog.OnChange(func(changes []catalog.Change) {
+		rows := make([]RowChange, len(changes))
+		for i, change := range changes {
+			rows[i] = e.rowChange(change)
+		}
+		fn(rows)
+	})
+}
+
+// OnCommit registers fn to be called before a batch commits. Returning an
+// error rolls the batch back, and Batch returns that error.
+func (e *Engine) OnCommit(fn func() error) (remove func()) {
+	return e.catalog.OnCommit(fn)
+}
+
+// OnRollback registers fn to be called after a batch rolls back, because
+// its function or a commit hook failed.
+func (e *Engine) OnRollback(fn func()) (remove func()) {
+	return e.catalog.OnRollback(fn)
+}
+
+func (e *Engine) rowChange(change catalog.Change) RowChange {
+	rc := RowChange{Table: change.Table, Op: change.Op}
+
+	schema, err := e.catalog.GetTable(change.Table)
+	if err != nil {
+		// The table is gone; fall back to the row's own columns, which
+		// include _rowid_ if it has one.
+		row := change.New
+		if row == nil {
+			row = change.Old
+		}
+		schema = &catalog.Schema{}
+		for name := range row.Values {
+			schema.Columns = append(schema.Columns, catalog.Column{Name: name, PrimaryKey: true})
+		}
+		sort.Slice(schema.Columns, func(i, j int) bool {
+			return schema.Columns[i].Name < schema.Columns[j].Name
+		})
+	}
+
+	for _, col := range schema.Columns {
+		rc.Columns = append(rc.Columns, col.Name)
+	}
+	if schema.HasRowID() {
+		rc.Columns = append(rc.Columns, catalog.RowIDColumn)
+	}
+	if change.Old != nil {
+		rc.Old = rowValues(change.Old, schema)
+	}
+	if change.New != nil {
+		rc.New = rowValues(change.New, schema)
+	}
+	return rc
+}
@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/internal/utils"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+type CreateJobPlan struct {
+	Job *catalog.Job
+}
+
+func (c *CreateJobPlan) Type() string  { return "CreateJob" }
+func (c *CreateJobPlan) Cost() float64 { return 0 }
+func (c *CreateJobPlan) String() string {
+	return fmt.Sprintf("CreateJob(%s '%s')", c.Job.Name, c.Job.Schedule)
+}
+
+type DropJobPlan struct {
+	Name string
+}
+
+func (d *DropJobPlan) Type() string  { return "DropJob" }
+func (d *DropJobPlan) Cost() float64 { return 0 }
+func (d *DropJobPlan) String() string {
+	return fmt.Sprintf("DropJob(%s)", d.Name)
+}
+
+// ShowJobsPlan is SHOW JOBS.
+type ShowJobsPlan struct{}
+
+func (s *ShowJobsPlan) Type() string   { return "ShowJobs" }
+func (s *ShowJobsPlan) Cost() float64  { return 0 }
+func (s *ShowJobsPlan) String() string { return "ShowJobs()" }
+
+func planCreateJob(stmt *parser.CreateJobStmt) (PlanNode, error) {
+	if _, err := utils.ParseCron(stmt.Schedule); err != nil {
+		return nil, sqlerr.Wrap(sqlerr.InvalidParameterValue, fmt.Errorf("invalid schedule for job '%s': %w", stmt.Name, err))
+	}
+	return &CreateJobPlan{Job: &catalog.Job{Name: stmt.Name, Schedule: stmt.Schedule, Statement: stmt.Statement}}, nil
+}
+
+func executeCreateJob(e *Engine, plan *CreateJobPlan) (string, error) {
+	if err := e.catalog.CreateJob(plan.Job); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Job '%s' created", plan.Job.Name), nil
+}
+
+func executeDropJob(e *Engine, plan *DropJobPlan) (string, error) {
+	if err := e.catalog.DropJob(plan.Name); err != nil {
+		return "", err
+	}
+	if e.sessions != nil {
+		e.sessions.mu.Lock()
+		delete(e.sessions.jobRuns, plan.Name)
+		e.sessions.mu.Unlock()
+	}
+	return fmt.Sprintf("Job '%s' dropped", plan.Name), nil
+}
+
+// executeShowJobs lists the jobs with when they run next and, when this
+// engine's session manager runs them, how their last run went.
+func executeShowJobs(e *Engine, plan *ShowJobsPlan) (string, error) {
+	if e.user != "" {
+		return "", sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: %s requires the database owner", plan.Type())
+	}
+
+	now := time.Now()
+	rs := &ResultSet{Schema: []string{"name", "schedule", "statement", "next_run", "last_run", "last_error"}}
+	for _, job := range e.catalog.ListJobs() {
+		var next, lastRun, lastError interface{}
+		if schedule, err := utils.ParseCron(job.Schedule); err == nil {
+			if t := schedule.Next(now); !t.IsZero() {
+				next = t.Format(time.RFC3339)
+			}
+		}
+		if e.sessions != nil {
+			if status, ok := e.sessions.jobStatus(job.Name); ok {
+				lastRun = status.lastRun.Format(time.RFC3339)
+				if status.lastError != "" {
+					lastError = status.lastError
+				}
+			}
+		}
+		rs.Rows = append(rs.Rows, []interface{}{job.Name, job.Schedule, job.Statement, next, lastRun, lastError})
+	}
+	return e.formatResults(rs), nil
+}
+
+// jobRun records the last run of a job by the scheduler.
+type jobRun struct {
+	lastRun   time.Time
+	lastError string
+}
+
+func (m *SessionManager) jobStatus(name string) (jobRun, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run, ok := m.jobRuns[name]
+	return run, ok
+}
+
+// runJobs is the scheduler. At the start of each minute it runs every job
+// whose schedule fell due since it last looked. Jobs due while no
+// scheduler was running are not caught up.
+func (m *SessionManager) runJobs() {
+	m.mu.Lock()
+	stop := m.stop
+	m.mu.Unlock()
+
+	last := time.Now()
+	for {
+		timer := time.NewTimer(time.Until(last.Truncate(time.Minute).Add(time.Minute)))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		m.exec.Lock()
+		jobs := m.engine.catalog.ListJobs()
+		m.exec.Unlock()
+
+		for _, job := range jobs {
+			schedule, err := utils.ParseCron(job.Schedule)
+			if err != nil {
+				continue
+			}
+			if due := schedule.Next(last); due.IsZero() || due.After(now) {
+				continue
+			}
+			m.runJob(job)
+		}
+		last = now
+	}
+}
+
+// runJob runs a job's statement as the owner in a session of its own,
+// which SHOW PROCESSLIST lists while it runs and Close cancels.
+func (m *SessionManager) runJob(job *catalog.Job) {
+	m.mu.Lock()
+	if m.stop == nil {
+		m.mu.Unlock()
+		return
+	}
+	s := m.openLocked(time.Now())
+	s.state.user = ""
+	m.mu.Unlock()
+	defer s.Close()
+
+	started := time.Now()
+	node, err := parser.Parse(job.Statement)
+	if err == nil {
+		_, err = s.Run(context.Background(), node)
+	}
+
+	run := jobRun{lastRun: started}
+	if err != nil {
+		run.lastError = err.Error()
+	}
+	m.mu.Lock()
+	m.jobRuns[job.Name] = run
+	m.mu.Unlock()
+}
@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonLookup decodes doc and returns the element at path. Paths follow the
+// SQLite form: "$", "$.a.b", "$.items[0]". A path without the leading "$" is
+// a single object key, and a number is an array index. found is false when
+// the path does not exist.
+func jsonLookup(doc, path interface{}) (value interface{}, found bool, err error) {
+	text, ok := doc.(string)
+	if !ok {
+		return nil, false, fmt.Errorf("JSON document must be text, got %T", doc)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var current interface{}
+	if err := decoder.Decode(&current); err != nil {
+		return nil, false, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	steps, err := jsonPathSteps(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, step := range steps {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			next, exists := node[step]
+			if !exists {
+				return nil, false, nil
+			}
+			current = next
+		case []interface{}:
+			idx, err := strconv.Atoi(step)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false, nil
+			}
+			current = node[idx]
+		default:
+			return nil, false, nil
+		}
+	}
+
+	return current, true, nil
+}
+
+func jsonPathSteps(path interface{}) ([]string, error) {
+	switch p := path.(type) {
+	case int64:
+		return []string{strconv.FormatInt(p, 10)}, nil
+	case string:
+		if !strings.HasPrefix(p, "$") {
+			return []string{p}, nil
+		}
+
+		var steps []string
+		rest := p[1:]
+		for rest != "" {
+			switch rest[0] {
+			case '.':
+				rest = rest[1:]
+				end := strings.IndexAny(rest, ".[")
+				if end < 0 {
+					end = len(rest)
+				}
+				if end == 0 {
+					return nil, fmt.Errorf("invalid JSON path: %s", p)
+				}
+				steps = append(steps, rest[:end])
+				rest = rest[end:]
+			case '[':
+				end := strings.IndexByte(rest, ']')
+				if end < 0 {
+					return nil, fmt.Errorf("invalid JSON path: %s", p)
+				}
+				steps = append(steps, rest[1:end])
+				rest = rest[end+1:]
+			default:
+				return nil, fmt.Errorf("invalid JSON path: %s", p)
+			}
+		}
+		return steps, nil
+	}
+	return nil, fmt.Errorf("JSON path must be text or an integer, got %T", path)
+}
+
+// jsonToSQL turns a decoded JSON element into the value ->> and
+// json_extract return: scalars become SQL values, containers stay JSON text.
+func jsonToSQL(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string, bool:
+		return v, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	default:
+		return jsonText(v)
+	}
+}
+
+func jsonText(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func evalJSONPath(doc, path interface{}, asText bool) (interface{}, error) {
+	if doc == nil || path == nil {
+		return nil, nil
+	}
+	value, found, err := jsonLookup(doc, path)
+	if err != nil || !found {
+		return nil, err
+	}
+	if asText {
+		return jsonToSQL(value)
+	}
+	return jsonText(value)
+}
+
+func fnJSONExtract(args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("JSON_EXTRACT requires 2 arguments, got %d", len(args))
+	}
+	return evalJSONPath(args[0], args[1], true)
+}
+
+func fnJSONValid(args []interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("JSON_VALID requires 1 argument, got %d", len(args))
+	}
+	text, ok := args[0].(string)
+	return ok && json.Valid([]byte(text)), nil
+}
@@ -0,0 +1,87 @@
+package engine
+
+import "strings"
+
+// patternOperator reports whether op is one of the pattern-matching
+// operators, which compare text only.
+func patternOperator(op string) bool {
+	switch op {
+	case "LIKE", "NOT LIKE", "ILIKE", "NOT ILIKE", "REGEXP", "NOT REGEXP":
+		return true
+	}
+	return false
+}
+
+// matchLike applies a LIKE or ILIKE operator, possibly negated, to s.
+// The parser has already rewritten any ESCAPE clause to a backslash.
+func matchLike(s, op, pattern string) bool {
+	negated := strings.HasPrefix(op, "NOT ")
+	if strings.HasSuffix(op, "ILIKE") {
+		s, pattern = strings.ToLower(s), strings.ToLower(pattern)
+	}
+	return likeMatch(s, pattern) != negated
+}
+
+// likeMatch reports whether s matches pattern, in which % stands for any
+// run of characters, _ for any one character, and a backslash makes the
+// character after it literal.
+func likeMatch(s, pattern string) bool {
+	str, pat := []rune(s), []rune(pattern)
+	si, pi := 0, 0
+	// star is the position in pat after the last %, and mark the position
+	// in str that % has been tried up to, for backtracking.
+	star, mark := -1, 0
+	for si < len(str) {
+		if pi < len(pat) {
+			c := pat[pi]
+			if c == '%' {
+				star, mark = pi+1, si
+				pi++
+				continue
+			}
+			if c == '\\' && pi+1 < len(pat) {
+				if pat[pi+1] == str[si] {
+					si, pi = si+1, pi+2
+					continue
+				}
+			} else if c == '_' || c == str[si] {
+				si, pi = si+1, pi+1
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		mark++
+		si, pi = mark, star
+	}
+	for pi < len(pat) && pat[pi] == '%' {
+		pi++
+	}
+	return pi == len(pat)
+}
+
+// likePrefix returns the literal text a pattern starts with, which every
+// match starts with too, so that an index can find the matches by range.
+// A case-insensitive pattern has a usable prefix only if folding case
+// cannot change it.
+func likePrefix(pattern string, fold bool) (string, bool) {
+	var b strings.Builder
+	pat := []rune(pattern)
+	for i := 0; i < len(pat); i++ {
+		c := pat[i]
+		if c == '%' || c == '_' {
+			break
+		}
+		if c == '\\' && i+1 < len(pat) {
+			i++
+			c = pat[i]
+		}
+		b.WriteRune(c)
+	}
+	prefix := b.String()
+	if prefix == "" || (fold && strings.ToLower(prefix) != strings.ToUpper(prefix)) {
+		return "", false
+	}
+	return prefix, true
+}
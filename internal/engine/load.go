@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// LoadCSV bulk loads CSV records from r into table and returns the number of
+// rows loaded. Fields are converted like INSERT values, with an empty field
+// read as NULL. When header is set the first record is skipped. The load is
+// all-or-nothing; into an empty table it builds the B-trees bottom-up.
+func (e *Engine) LoadCSV(table string, r io.Reader, header bool) (int, error) {
+	if err := e.authorize(&InsertPlan{Table: table}); err != nil {
+		return 0, err
+	}
+
+	t, err := e.loadTable(table)
+	if err != nil {
+		return 0, fmt.Errorf("table not found: %w", err)
+	}
+	schema := t.GetSchema()
+
+	policy, err := e.policyFilter(table)
+	if err != nil {
+		return 0, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = schema.ColumnCount()
+	reader.ReuseRecord = true
+
+	var rows [][]interface{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, sqlerr.New(sqlerr.SyntaxError, "invalid csv: %v", err)
+		}
+		if header && line == 1 {
+			continue
+		}
+
+		values := make([]interface{}, len(record))
+		for i, field := range record {
+			if field == "" {
+				continue
+			}
+			col := schema.Columns[i]
+			if values[i], err = convertValue(field, col.Type); err != nil {
+				return 0, fmt.Errorf("line %d: invalid value for column '%s': %w", line, col.Name, err)
+			}
+		}
+
+		if policy != nil {
+			row, err := catalog.CreateRow(schema, values)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: %w", line, err)
+			}
+			if !matchesFilter(e, row, policy) {
+				return 0, sqlerr.New(sqlerr.InsufficientPrivilege, "line %d: row violates row-level security policy on %s", line, table)
+			}
+		}
+		rows = append(rows, values)
+	}
+
+	if err := t.BulkLoad(rows); err != nil {
+		return 0, fmt.Errorf("load failed: %w", err)
+	}
+	e.planner.AdjustRowCount(table, len(rows))
+	e.autoAnalyzeTables()
+	return len(rows), nil
+}
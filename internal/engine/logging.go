@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %q: expected debug, info, warn or error", s)
+}
+
+// Logger receives the engine's log records. The engine only calls it for
+// records at or above the log_level setting.
+type Logger interface {
+	Log(level LogLevel, msg string, fields map[string]interface{})
+}
+
+// textLogger writes one "time LEVEL msg key=value ..." line per record.
+type textLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTextLogger returns a Logger that writes plain text lines to w.
+func NewTextLogger(w io.Writer) Logger {
+	return &textLogger{w: w}
+}
+
+func (l *textLogger) Log(level LogLevel, msg string, fields map[string]interface{}) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(time.Now().UTC().Format(time.RFC3339Nano))
+	sb.WriteByte(' ')
+	sb.WriteString(strings.ToUpper(level.String()))
+	sb.WriteByte(' ')
+	sb.WriteString(msg)
+	for _, k := range keys {
+		value := fmt.Sprint(fields[k])
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&sb, " %s=%s", k, value)
+	}
+	sb.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write([]byte(sb.String()))
+}
+
+// SetLogger installs the logger for statements, plans and slow queries; nil
+// turns logging off.
+func (e *Engine) SetLogger(logger Logger) {
+	e.logger = logger
+}
+
+func (e *Engine) log(level LogLevel, msg string, fields map[string]interface{}) {
+	if e.logger == nil || level < e.logLevel {
+		return
+	}
+	e.logger.Log(level, msg, fields)
+}
+
+func (e *Engine) logPlan(node parser.Node, plan PlanNode) {
+	if e.logger == nil || e.logLevel > LogDebug {
+		return
+	}
+	fields := map[string]interface{}{
+		"statement": node.String(),
+		"plan":      plan.String(),
+		"cost":      plan.Cost(),
+	}
+	// The paths the planner passed over explain a surprising choice.
+	var alternatives []string
+	for _, scan := range planScans(plan) {
+		for _, alt := range scan.Alternatives {
+			entry := scan.Table + ": " + alt.String()
+			if alt.Chosen {
+				entry += " chosen"
+			}
+			alternatives = append(alternatives, entry)
+		}
+	}
+	if len(alternatives) > 1 {
+		fields["alternatives"] = alternatives
+	}
+	e.log(LogDebug, "plan", fields)
+}
+
+// logIndexHints warns about the indexes USE INDEX and IGNORE INDEX name
+// that their tables do not have, since such a hint does nothing.
+func (e *Engine) logIndexHints(plan PlanNode) {
+	if e.logger == nil || e.logLevel > LogWarn {
+		return
+	}
+	for _, scan := range planScans(plan) {
+		for _, name := range scan.UnknownIndexes {
+			e.log(LogWarn, "index hint names no such index", map[string]interface{}{
+				"table": scan.Table,
+				"index": name,
+			})
+		}
+	}
+}
+
+// logStatement logs a finished statement at info, or at error if it failed,
+// and warns when it ran longer than the slow_query_threshold setting.
+func (e *Engine) logStatement(statement string, start time.Time, err error) {
+	if e.logger == nil {
+		return
+	}
+
+	elapsed := time.Since(start)
+	fields := map[string]interface{}{
+		"statement":   statement,
+		"rows":        e.rowCount,
+		"duration_ms": float64(elapsed.Microseconds()) / 1000,
+	}
+	if e.user != "" {
+		fields["user"] = e.user
+	}
+
+	if err != nil {
+		fields["error"] = err.Error()
+		fields["code"] = sqlerr.CodeOf(err)
+		e.log(LogError, "statement failed", fields)
+	} else {
+		e.log(LogInfo, "statement", fields)
+	}
+
+	if e.slowQuery > 0 && elapsed >= e.slowQuery {
+		e.log(LogWarn, "slow statement", fields)
+	}
+}
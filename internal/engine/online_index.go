@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+)
+
+// indexBuildRows is how many rows CREATE INDEX CONCURRENTLY indexes at a
+// time. In a session, other sessions' statements run between these steps.
+const indexBuildRows = 1000
+
+// beginIndexBuild checks a concurrent CREATE INDEX against the table and
+// starts building it.
+func (e *Engine) beginIndexBuild(plan *CreateIndexPlan) (*catalog.IndexBuild, error) {
+	table, err := e.loadTable(plan.TableName)
+	if err != nil {
+		return nil, fmt.Errorf("table not found: %w", err)
+	}
+	schema := table.GetSchema()
+
+	build, err := table.Catalog.BeginIndexBuild(plan.IndexName, schema.Name, plan.Columns, plan.Unique, plan.FillFactor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create index: %w", err)
+	}
+	return build, nil
+}
+
+// finishIndexBuild adds a fully read index to the catalog.
+func (e *Engine) finishIndexBuild(plan *CreateIndexPlan, build *catalog.IndexBuild) (string, error) {
+	if err := build.Finish(); err != nil {
+		return "", fmt.Errorf("failed to create index: %w", err)
+	}
+	if err := e.planner.RefreshTable(plan.TableName); err != nil {
+		return "", err
+	}
+	return indexCreated(plan), nil
+}
+
+// abortIndexBuild abandons a failed build. Failing to free its pages only
+// leaves them unused, so that is logged rather than returned.
+func (e *Engine) abortIndexBuild(build *catalog.IndexBuild) {
+	if err := build.Abort(); err != nil {
+		e.log(LogWarn, "failed to abandon index build", map[string]interface{}{"error": err.Error()})
+	}
+}
+
+// executeCreateIndexConcurrently builds the index in steps within one
+// statement, as an engine without sessions has nothing to run between
+// them. The statement timeout is checked after each step.
+func executeCreateIndexConcurrently(e *Engine, plan *CreateIndexPlan) (string, error) {
+	build, err := e.beginIndexBuild(plan)
+	if err != nil {
+		return "", err
+	}
+	for done := false; !done; {
+		if done, err = build.Step(indexBuildRows); err == nil {
+			err = e.checkDeadline()
+		}
+		if err != nil {
+			e.abortIndexBuild(build)
+			return "", err
+		}
+	}
+	result, err := e.finishIndexBuild(plan, build)
+	if err != nil {
+		e.abortIndexBuild(build)
+	}
+	return result, err
+}
+
+// createIndexConcurrently runs CREATE INDEX CONCURRENTLY as a series of
+// steps, each waiting its turn like a statement of its own, so the table
+// stays writable while the index is built. KILL QUERY stops it between
+// steps and the statement timeout covers the whole build.
+func (s *Session) createIndexConcurrently(ctx context.Context, node *parser.CreateIndexStmt) (string, error) {
+	e := s.manager.engine
+	statement := node.String()
+	start := time.Now()
+
+	var plan *CreateIndexPlan
+	var build *catalog.IndexBuild
+	var deadline time.Time
+	err := s.do(ctx, statement, func(ctx context.Context) error {
+		if e.closed {
+			return errClosed
+		}
+		e.rowCount = 0
+		if e.timeout > 0 {
+			deadline = start.Add(e.timeout)
+		}
+		p, err := e.plan(node)
+		if err != nil {
+			return err
+		}
+		if err := e.authorize(p); err != nil {
+			return err
+		}
+		plan = p.(*CreateIndexPlan)
+		build, err = e.beginIndexBuild(plan)
+		return err
+	})
+
+	for done := false; err == nil && !done; {
+		err = s.do(ctx, statement, func(ctx context.Context) error {
+			if e.closed {
+				return errClosed
+			}
+			e.deadline, e.ctx = deadline, ctx
+			defer func() { e.deadline, e.ctx = time.Time{}, nil }()
+			var err error
+			if done, err = build.Step(indexBuildRows); err == nil {
+				err = e.checkDeadline()
+			}
+			return err
+		})
+	}
+
+	var result string
+	if err == nil {
+		err = s.do(ctx, statement, func(ctx context.Context) error {
+			if e.closed {
+				return errClosed
+			}
+			var err error
+			result, err = e.finishIndexBuild(plan, build)
+			return err
+		})
+	}
+	// The build is abandoned even if KILL closed the session, which would
+	// leave s.do running nothing.
+	if err != nil && build != nil {
+		s.manager.exec.Lock()
+		if !e.closed {
+			e.abortIndexBuild(build)
+		}
+		s.manager.exec.Unlock()
+	}
+
+	if e.audit != nil || e.logger != nil {
+		s.do(context.Background(), statement, func(ctx context.Context) error {
+			e.auditStatement(statement, start, err)
+			e.logStatement(statement, start, err)
+			return nil
+		})
+	}
+	return result, err
+}
@@ -0,0 +1,318 @@
+package engine
+
+import (
+	"strconv"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/internal/utils"
+)
+
+// DefaultPlanCacheSize is the number of plans an engine keeps unless
+// SetPlanCacheSize says otherwise.
+const DefaultPlanCacheSize = 256
+
+// cachedPlan is a plan kept in the plan cache, with the TableVersion of
+// each table it reads or writes at the time it was made and the cost model
+// it was priced with.
+type cachedPlan struct {
+	plan     PlanNode
+	versions map[string]uint64
+	costs    CostModel
+}
+
+// SetPlanCacheSize sets how many plans the engine keeps for statements it
+// may run again, dropping those it kept so far; 0 turns the cache off.
+func (e *Engine) SetPlanCacheSize(size int) {
+	e.plans = nil
+	if size > 0 {
+		e.plans = utils.NewLRUCache[string, *cachedPlan](size)
+	}
+}
+
+// PlanCacheStats reports how often statements found their plan cached.
+func (e *Engine) PlanCacheStats() utils.CacheStats {
+	if e.plans == nil {
+		return utils.CacheStats{}
+	}
+	return e.plans.Stats()
+}
+
+// plan plans node with the session's cost model, reusing the plan made for
+// the same statement text as long as none of the tables it uses has changed
+// since. Only queries, UPDATE and DELETE are kept; other statements are
+// cheap to plan or run once.
+func (e *Engine) plan(node parser.Node) (PlanNode, error) {
+	e.planner.SetCostModel(e.costs)
+	switch node.(type) {
+	case *parser.SelectStmt, *parser.UpdateStmt, *parser.DeleteStmt:
+	default:
+		return e.planner.Plan(node)
+	}
+	if e.plans == nil {
+		return e.planner.Plan(node)
+	}
+
+	key, ok := planKey(node)
+	if !ok {
+		return e.planner.Plan(node)
+	}
+	if cached, ok := e.plans.Peek(key); ok && !e.planCurrent(cached) {
+		e.plans.Delete(key)
+	}
+	if cached, ok := e.plans.Get(key); ok {
+		return cached.plan, nil
+	}
+
+	plan, err := e.planner.Plan(node)
+	if err != nil {
+		return nil, err
+	}
+	if versions, ok := e.tableVersions(plan); ok {
+		e.plans.Put(key, &cachedPlan{plan: plan, versions: versions, costs: e.costs})
+	}
+	return plan, nil
+}
+
+// planKey identifies node by everything it says. String is no use here: it
+// shows values by their text alone, so 'NULL' and NULL or '5' and 5 would
+// share a plan. It reports false for a statement it cannot describe, which
+// is then planned every time.
+func planKey(node parser.Node) (string, bool) {
+	k := planKeyWriter{ok: true}
+	switch stmt := node.(type) {
+	case *parser.SelectStmt:
+		k.selectStmt(stmt)
+	case *parser.UpdateStmt:
+		k.tag('U')
+		k.str(stmt.Table)
+		k.int(len(stmt.Assignments))
+		for _, a := range stmt.Assignments {
+			k.str(a.Column)
+			k.value(a.Value)
+		}
+		k.where(stmt.Where)
+		k.orderLimit(stmt.OrderBy, stmt.Limit)
+	case *parser.DeleteStmt:
+		k.tag('D')
+		k.str(stmt.Table)
+		k.where(stmt.Where)
+		k.orderLimit(stmt.OrderBy, stmt.Limit)
+	default:
+		return "", false
+	}
+	return string(k.buf), k.ok
+}
+
+// planKeyWriter builds a plan cache key. Strings are written with their
+// length so that no two statements run together into the same key.
+type planKeyWriter struct {
+	buf []byte
+	ok  bool
+}
+
+func (k *planKeyWriter) selectStmt(stmt *parser.SelectStmt) {
+	k.tag('S')
+	k.flag(stmt.Distinct)
+	k.strs(stmt.Columns)
+	k.strs(stmt.Aliases)
+	k.int(len(stmt.Exprs))
+	for _, expr := range stmt.Exprs {
+		k.expr(expr)
+	}
+	k.table(stmt.Table)
+	k.int(len(stmt.Joins))
+	for _, join := range stmt.Joins {
+		k.str(join.Type)
+		k.table(join.Table)
+		k.cond(join.Condition)
+		k.flag(join.Natural)
+		k.strs(join.Using)
+	}
+	k.where(stmt.Where)
+	k.strs(stmt.GroupBy)
+	k.where(stmt.Having)
+	k.orderLimit(stmt.OrderBy, stmt.Limit)
+}
+
+func (k *planKeyWriter) orderLimit(orderBy []*parser.OrderItem, limit *parser.LimitClause) {
+	k.int(len(orderBy))
+	for _, item := range orderBy {
+		k.str(item.Column)
+		k.str(item.Direction)
+	}
+	k.flag(limit != nil)
+	if limit != nil {
+		k.str(limit.Count)
+		k.str(limit.Offset)
+	}
+}
+
+func (k *planKeyWriter) tag(c byte) {
+	k.buf = append(k.buf, c)
+}
+
+func (k *planKeyWriter) int(n int) {
+	k.buf = strconv.AppendInt(k.buf, int64(n), 10)
+	k.buf = append(k.buf, ';')
+}
+
+func (k *planKeyWriter) flag(b bool) {
+	if b {
+		k.tag('1')
+	} else {
+		k.tag('0')
+	}
+}
+
+func (k *planKeyWriter) str(s string) {
+	k.int(len(s))
+	k.buf = append(k.buf, s...)
+}
+
+func (k *planKeyWriter) strs(list []string) {
+	k.int(len(list))
+	for _, s := range list {
+		k.str(s)
+	}
+}
+
+func (k *planKeyWriter) table(t *parser.TableRef) {
+	k.flag(t != nil)
+	if t == nil {
+		return
+	}
+	k.str(t.Name)
+	k.str(t.Alias)
+	k.flag(t.Hint != nil)
+	if t.Hint != nil {
+		k.flag(t.Hint.Ignore)
+		k.strs(t.Hint.Indexes)
+	}
+	k.flag(t.Sample != nil)
+	if t.Sample != nil {
+		k.str(t.Sample.String())
+	}
+	k.flag(t.Function)
+	k.int(len(t.Args))
+	for _, arg := range t.Args {
+		k.value(arg)
+	}
+}
+
+func (k *planKeyWriter) value(v parser.Value) {
+	k.int(int(v.Kind))
+	k.str(v.Text)
+}
+
+func (k *planKeyWriter) where(w *parser.WhereClause) {
+	k.flag(w != nil)
+	if w == nil {
+		return
+	}
+	k.int(len(w.Conditions))
+	for _, cond := range w.Conditions {
+		k.cond(cond)
+	}
+	k.int(len(w.Predicates))
+	for _, pred := range w.Predicates {
+		k.predicate(pred)
+	}
+}
+
+func (k *planKeyWriter) predicate(p parser.Predicate) {
+	k.str(p.Op)
+	if p.Op == "" {
+		k.cond(p.Cond)
+		return
+	}
+	k.int(len(p.Args))
+	for _, arg := range p.Args {
+		k.predicate(arg)
+	}
+}
+
+func (k *planKeyWriter) cond(c parser.Condition) {
+	k.str(c.Column)
+	k.expr(c.Expr)
+	k.str(c.Operator)
+	k.value(c.Value)
+	k.flag(c.Subquery != nil)
+	if c.Subquery != nil {
+		k.selectStmt(c.Subquery)
+	}
+}
+
+func (k *planKeyWriter) expr(expr parser.Expr) {
+	switch e := expr.(type) {
+	case nil:
+		k.tag('n')
+	case *parser.ColumnExpr:
+		k.tag('c')
+		k.str(e.Name)
+	case *parser.LiteralExpr:
+		k.tag('l')
+		k.flag(e.Quoted)
+		k.flag(e.Null)
+		k.str(e.Value)
+	case *parser.FuncExpr:
+		k.tag('f')
+		k.str(e.Name)
+		k.int(len(e.Args))
+		for _, arg := range e.Args {
+			k.expr(arg)
+		}
+	case *parser.JSONPathExpr:
+		k.tag('j')
+		k.flag(e.AsText)
+		k.expr(e.Expr)
+		k.expr(e.Path)
+	case *parser.CastExpr:
+		k.tag('x')
+		k.str(e.Type)
+		k.expr(e.Expr)
+	default:
+		k.ok = false
+	}
+}
+
+// tableVersions records the version of every table plan uses. It reports
+// false when one cannot be resolved, and the plan is then not kept.
+func (e *Engine) tableVersions(plan PlanNode) (map[string]uint64, bool) {
+	checks, _ := requiredPrivileges(plan)
+	versions := make(map[string]uint64, len(checks))
+	for _, check := range checks {
+		if _, virtual := e.virtual[check.table]; virtual || e.isDBStat(check.table) {
+			continue
+		}
+		cat, name, err := e.catalogFor(check.table)
+		if err != nil {
+			return nil, false
+		}
+		versions[check.table] = cat.TableVersion(name)
+	}
+	return versions, true
+}
+
+func (e *Engine) planCurrent(cached *cachedPlan) bool {
+	if cached.costs != e.costs {
+		return false
+	}
+	for table, version := range cached.versions {
+		cat, name, err := e.catalogFor(table)
+		if err != nil || cat.TableVersion(name) != version {
+			return false
+		}
+	}
+	return true
+}
+
+// forgetPlans drops every cached plan, and every cached result, for changes
+// that can alter how any statement is planned, such as attaching a database.
+func (e *Engine) forgetPlans() {
+	if e.plans != nil {
+		e.plans.Clear()
+	}
+	if e.results != nil {
+		e.results.Clear()
+	}
+}
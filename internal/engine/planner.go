@@ -2,9 +2,14 @@ package engine
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/kithinjibrian/anubisdb/internal/catalog"
 	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
 )
 
 type PlanNode interface {
@@ -19,6 +24,14 @@ const (
 	FullScan        ScanType = "FullScan"
 	IndexScan       ScanType = "IndexScan"
 	UniqueIndexScan ScanType = "UniqueIndexScan"
+
+	// OrderedScan reads every row in the order of the primary key, or of
+	// IndexName when set, so a query ordered by that column needs no sort.
+	OrderedScan ScanType = "OrderedScan"
+
+	// ClusterScan reads only the range of a clustered table's tree that
+	// the conditions on its cluster key select.
+	ClusterScan ScanType = "ClusterScan"
 )
 
 type ScanPlan struct {
@@ -26,15 +39,65 @@ type ScanPlan struct {
 	Alias     string
 	ScanType  ScanType
 	IndexName string
+	Order     *OrderItem
 	Filter    *FilterPlan
 	EstRows   int
 	EstCost   float64
+
+	// Hint is the query's USE INDEX or IGNORE INDEX for the table, and
+	// UnknownIndexes the indexes it names that the table does not have.
+	Hint           *parser.IndexHint
+	UnknownIndexes []string
+
+	// Sample is the query's TABLESAMPLE for the table. A sampled table is
+	// always read in full.
+	Sample *parser.TableSample
+
+	// Function is set when Table names a table function, called with Args.
+	Function bool
+	Args     []parser.Value
+
+	// Virtual is set when Table names a table registered with
+	// RegisterVirtualTable.
+	Virtual bool
+
+	// Alternatives are the ways of reading the table the planner weighed,
+	// for EXPLAIN VERBOSE.
+	Alternatives []Alternative
+}
+
+// Alternative is one way of reading a table that the planner costed, or
+// ruled out before costing, with the reason it was or was not chosen.
+type Alternative struct {
+	Path    string
+	EstRows int
+	EstCost float64
+	Costed  bool
+	Chosen  bool
+	Reason  string
+}
+
+func (a Alternative) String() string {
+	result := a.Path
+	if a.Costed {
+		result += fmt.Sprintf(": rows=%d, cost=%.2f", a.EstRows, a.EstCost)
+	}
+	if a.Reason != "" {
+		result += " (" + a.Reason + ")"
+	}
+	return result
 }
 
 func (s *ScanPlan) Type() string  { return "Scan" }
 func (s *ScanPlan) Cost() float64 { return s.EstCost }
 func (s *ScanPlan) String() string {
 	result := fmt.Sprintf("Scan(%s", s.Table)
+	if s.Function {
+		result = fmt.Sprintf("FunctionScan(%s", &parser.TableRef{Name: s.Table, Function: true, Args: s.Args})
+	}
+	if s.Virtual {
+		result = fmt.Sprintf("VirtualScan(%s", s.Table)
+	}
 	if s.Alias != "" {
 		result += fmt.Sprintf(" AS %s", s.Alias)
 	}
@@ -42,20 +105,66 @@ func (s *ScanPlan) String() string {
 	if s.IndexName != "" {
 		result += fmt.Sprintf(", index=%s", s.IndexName)
 	}
+	if s.Order != nil {
+		result += fmt.Sprintf(", order=%s %s", s.Order.Column, s.Order.Direction)
+	}
+	if s.Hint != nil {
+		result += fmt.Sprintf(", hint=%s", s.Hint)
+	}
+	if len(s.UnknownIndexes) > 0 {
+		result += fmt.Sprintf(", unknown index=%s", strings.Join(s.UnknownIndexes, ", "))
+	}
+	if s.Sample != nil {
+		result += fmt.Sprintf(", sample=%s", s.Sample)
+	}
 	if s.Filter != nil {
-		result += fmt.Sprintf(", filter=%v", s.Filter.Conditions)
+		result += fmt.Sprintf(", filter=%v", s.Filter)
 	}
 	result += fmt.Sprintf(", rows=%d, cost=%.2f)", s.EstRows, s.EstCost)
 	return result
 }
 
+// planScans returns the table scans of a query plan.
+func planScans(plan PlanNode) []*ScanPlan {
+	switch p := plan.(type) {
+	case *ScanPlan:
+		return []*ScanPlan{p}
+	case *JoinPlan:
+		return append(planScans(p.Left), p.Right)
+	case *SemiJoinPlan:
+		return append(planScans(p.Input), planScans(p.Subquery)...)
+	case *ProjectPlan:
+		return planScans(p.Input)
+	case *GroupByPlan:
+		return planScans(p.Input)
+	case *IndexAggregatePlan:
+		return planScans(p.Scan)
+	case *SortPlan:
+		return planScans(p.Input)
+	case *LimitPlan:
+		return planScans(p.Input)
+	case *ExplainPlan:
+		return planScans(p.Input)
+	case *UpdatePlan:
+		return planScans(p.Scan)
+	case *DeletePlan:
+		return planScans(p.Scan)
+	}
+	return nil
+}
+
+// FilterPlan keeps the rows that match all of its Conditions and
+// Predicates.
 type FilterPlan struct {
 	Conditions  []Condition
+	Predicates  []Predicate
 	Selectivity float64
 }
 
 type ProjectPlan struct {
 	Columns  []string
+	Exprs    []parser.Expr
+	Aliases  []string // aligned with Columns, see parser.SelectStmt
 	Distinct bool
 	Input    PlanNode
 	EstCost  float64
@@ -68,27 +177,62 @@ func (p *ProjectPlan) String() string {
 	if p.Distinct {
 		distinct = "DISTINCT "
 	}
-	return fmt.Sprintf("Project(%s%v, cost=%.2f) <- %s", distinct, p.Columns, p.EstCost, p.Input.String())
+	columns := p.Columns
+	if p.Aliases != nil {
+		columns = make([]string, len(p.Columns))
+		for i, col := range p.Columns {
+			columns[i] = col
+			if p.Aliases[i] != "" {
+				columns[i] += " AS " + p.Aliases[i]
+			}
+		}
+	}
+	return fmt.Sprintf("Project(%s%v, cost=%.2f) <- %s", distinct, columns, p.EstCost, p.Input.String())
 }
 
 type JoinPlan struct {
-	JoinType  string
-	Left      PlanNode
-	Right     *ScanPlan
-	Condition Condition
-	EstRows   int
-	EstCost   float64
+	JoinType   string
+	Left       PlanNode
+	Right      *ScanPlan
+	Conditions []Condition
+	// Using names the columns of a USING or NATURAL join. Conditions holds
+	// one equality for each, and the joined rows carry a single column of
+	// that name in place of the two it compares.
+	Using []string
+	// Filter holds the WHERE conditions that cannot be applied to the
+	// leftmost table before the join.
+	Filter  *FilterPlan
+	EstRows int
+	EstCost float64
 }
 
 func (j *JoinPlan) Type() string  { return "Join" }
 func (j *JoinPlan) Cost() float64 { return j.EstCost }
 func (j *JoinPlan) String() string {
-	return fmt.Sprintf("Join(%s, on=%s, rows=%d, cost=%.2f)\n  Left: %s\n  Right: %s",
-		j.JoinType, j.Condition, j.EstRows, j.EstCost, j.Left.String(), j.Right.String())
+	on := make([]string, len(j.Conditions))
+	for i, cond := range j.Conditions {
+		on[i] = cond.String()
+	}
+	if len(on) == 0 {
+		on = []string{"TRUE"}
+	}
+	using := ""
+	if len(j.Using) > 0 {
+		using = fmt.Sprintf(", using=%v", j.Using)
+	}
+	filter := ""
+	if j.Filter != nil {
+		filter = fmt.Sprintf(", filter=%v", j.Filter)
+	}
+	return fmt.Sprintf("Join(%s, on=%s%s%s, rows=%d, cost=%.2f)\n  Left: %s\n  Right: %s",
+		j.JoinType, strings.Join(on, " AND "), using, filter, j.EstRows, j.EstCost, j.Left.String(), j.Right.String())
 }
 
+// SortPlan orders its input. With a Limit, set when a LIMIT follows the
+// sort, it is a top-K sort that keeps only the first Limit rows.
 type SortPlan struct {
 	OrderBy []OrderItem
+	Limit   int
 	Input   PlanNode
 	EstCost float64
 }
@@ -101,12 +245,17 @@ type OrderItem struct {
 func (s *SortPlan) Type() string  { return "Sort" }
 func (s *SortPlan) Cost() float64 { return s.EstCost }
 func (s *SortPlan) String() string {
+	if s.Limit > 0 {
+		return fmt.Sprintf("TopK(%v, k=%d, cost=%.2f) <- %s", s.OrderBy, s.Limit, s.EstCost, s.Input.String())
+	}
 	return fmt.Sprintf("Sort(%v, cost=%.2f) <- %s", s.OrderBy, s.EstCost, s.Input.String())
 }
 
+// LimitPlan keeps Count rows, all of them when Count is negative, after
+// skipping Offset.
 type LimitPlan struct {
-	Count   string
-	Offset  string
+	Count   int
+	Offset  int
 	Input   PlanNode
 	EstCost float64
 }
@@ -114,37 +263,82 @@ type LimitPlan struct {
 func (l *LimitPlan) Type() string  { return "Limit" }
 func (l *LimitPlan) Cost() float64 { return l.EstCost }
 func (l *LimitPlan) String() string {
-	result := fmt.Sprintf("Limit(%s", l.Count)
-	if l.Offset != "" {
-		result += fmt.Sprintf(", offset=%s", l.Offset)
+	result := "Limit(ALL"
+	if l.Count >= 0 {
+		result = fmt.Sprintf("Limit(%d", l.Count)
+	}
+	if l.Offset > 0 {
+		result += fmt.Sprintf(", offset=%d", l.Offset)
 	}
 	result += fmt.Sprintf(", cost=%.2f) <- %s", l.EstCost, l.Input.String())
 	return result
 }
 
 type GroupByPlan struct {
-	Columns []string
-	Input   PlanNode
-	Having  *FilterPlan
-	EstRows int
-	EstCost float64
+	Columns    []string
+	Aggregates []string
+	Input      PlanNode
+	Having     *FilterPlan
+	EstRows    int
+	EstCost    float64
 }
 
 func (g *GroupByPlan) Type() string  { return "GroupBy" }
 func (g *GroupByPlan) Cost() float64 { return g.EstCost }
 func (g *GroupByPlan) String() string {
-	result := fmt.Sprintf("GroupBy(%v, rows=%d, cost=%.2f)", g.Columns, g.EstRows, g.EstCost)
+	result := fmt.Sprintf("GroupBy(%v, aggregates=%v, rows=%d, cost=%.2f)", g.Columns, g.Aggregates, g.EstRows, g.EstCost)
 	if g.Having != nil {
-		result += fmt.Sprintf(" HAVING %v", g.Having.Conditions)
+		result += fmt.Sprintf(" HAVING %v", g.Having)
 	}
 	result += fmt.Sprintf(" <- %s", g.Input.String())
 	return result
 }
 
+// IndexAggregatePlan answers MIN and MAX over a whole table by reading the
+// first or last entry of a B-tree ordered by each column. Scan is the table
+// it stands for; when a policy narrows it, the aggregates are computed over
+// the scan instead.
+type IndexAggregatePlan struct {
+	Aggregates []string
+	Indexes    []string // aligned with Aggregates; "" is the primary key
+	Scan       *ScanPlan
+	EstCost    float64
+}
+
+func (a *IndexAggregatePlan) Type() string  { return "IndexAggregate" }
+func (a *IndexAggregatePlan) Cost() float64 { return a.EstCost }
+func (a *IndexAggregatePlan) String() string {
+	parts := make([]string, len(a.Aggregates))
+	for i, agg := range a.Aggregates {
+		source := "primary key"
+		if a.Indexes[i] != "" {
+			source = a.Indexes[i]
+		}
+		parts[i] = fmt.Sprintf("%s via %s", agg, source)
+	}
+	return fmt.Sprintf("IndexAggregate(%s, [%s], cost=%.2f)", a.Scan.Table, strings.Join(parts, ", "), a.EstCost)
+}
+
+type ExplainPlan struct {
+	Input PlanNode
+
+	// Verbose adds the access paths the planner weighed for each table.
+	Verbose bool
+}
+
+func (e *ExplainPlan) Type() string  { return "Explain" }
+func (e *ExplainPlan) Cost() float64 { return e.Input.Cost() }
+func (e *ExplainPlan) String() string {
+	if e.Verbose {
+		return Explain(e.Input) + "\n" + ExplainAlternatives(e.Input)
+	}
+	return Explain(e.Input)
+}
+
 type InsertPlan struct {
 	Table   string
 	Columns []string
-	Values  []string
+	Values  []parser.Value
 	EstCost float64
 }
 
@@ -155,35 +349,61 @@ func (i *InsertPlan) String() string {
 		i.Table, i.Columns, i.Values, i.EstCost)
 }
 
+// DeletePlan deletes the rows Scan finds, or only the first Limit of them
+// in OrderBy order; Limit is -1 without a LIMIT.
 type DeletePlan struct {
 	Scan    *ScanPlan
+	OrderBy []OrderItem
+	Limit   int
 	EstCost float64
 }
 
 func (d *DeletePlan) Type() string  { return "Delete" }
 func (d *DeletePlan) Cost() float64 { return d.EstCost }
 func (d *DeletePlan) String() string {
-	return fmt.Sprintf("Delete(cost=%.2f) <- %s", d.EstCost, d.Scan.String())
+	return fmt.Sprintf("Delete(%scost=%.2f) <- %s", rowLimitString(d.OrderBy, d.Limit), d.EstCost, d.Scan.String())
 }
 
+// UpdatePlan updates the rows Scan finds, or only the first Limit of them
+// in OrderBy order; Limit is -1 without a LIMIT.
 type UpdatePlan struct {
 	Table       string
 	Assignments []Assignment
 	Scan        *ScanPlan
+	OrderBy     []OrderItem
+	Limit       int
 	EstCost     float64
 }
 
 func (u *UpdatePlan) Type() string  { return "Update" }
 func (u *UpdatePlan) Cost() float64 { return u.EstCost }
 func (u *UpdatePlan) String() string {
-	return fmt.Sprintf("Update(%s, assignments=%v, cost=%.2f) <- %s",
-		u.Table, u.Assignments, u.EstCost, u.Scan.String())
+	return fmt.Sprintf("Update(%s, assignments=%v, %scost=%.2f) <- %s",
+		u.Table, u.Assignments, rowLimitString(u.OrderBy, u.Limit), u.EstCost, u.Scan.String())
+}
+
+// rowLimitString shows the ORDER BY and LIMIT of an UPDATE or DELETE plan.
+func rowLimitString(orderBy []OrderItem, limit int) string {
+	result := ""
+	if len(orderBy) > 0 {
+		result += fmt.Sprintf("order=%v, ", orderBy)
+	}
+	if limit >= 0 {
+		result += fmt.Sprintf("limit=%d, ", limit)
+	}
+	return result
 }
 
 type CreateTablePlan struct {
-	Table   string
-	Columns []parser.ColumnDef
-	EstCost float64
+	Table      string
+	Columns    []parser.ColumnDef
+	Unique     [][]string
+	Engine     string
+	ClusterKey []string
+	FillFactor int
+	// BloomFilter keeps bloom filters on the table's indexes.
+	BloomFilter bool
+	EstCost     float64
 }
 
 func (c *CreateTablePlan) Type() string  { return "CreateTable" }
@@ -194,11 +414,13 @@ func (c *CreateTablePlan) String() string {
 }
 
 type CreateIndexPlan struct {
-	IndexName string
-	TableName string
-	Columns   []string
-	Unique    bool
-	EstCost   float64
+	IndexName    string
+	TableName    string
+	Columns      []string
+	Unique       bool
+	Concurrently bool
+	FillFactor   int
+	EstCost      float64
 }
 
 func (c *CreateIndexPlan) Type() string  { return "CreateIndex" }
@@ -208,14 +430,118 @@ func (c *CreateIndexPlan) String() string {
 	if c.Unique {
 		unique = "UNIQUE "
 	}
+	if c.Concurrently {
+		unique += "CONCURRENTLY "
+	}
 	return fmt.Sprintf("CreateIndex(%s%s ON %s(%v), cost=%.2f)",
 		unique, c.IndexName, c.TableName, c.Columns, c.EstCost)
 }
 
+// DropIndexPlan is DROP INDEX. Table is the table the index is on, or ""
+// when the index does not exist and IF EXISTS makes that no error.
+type DropIndexPlan struct {
+	Name     string
+	Table    string
+	IfExists bool
+}
+
+func (d *DropIndexPlan) Type() string  { return "DropIndex" }
+func (d *DropIndexPlan) Cost() float64 { return 0 }
+func (d *DropIndexPlan) String() string {
+	return fmt.Sprintf("DropIndex(%s ON %s)", d.Name, d.Table)
+}
+
+// RenameIndexPlan is ALTER INDEX ... RENAME TO, with Table as for
+// DropIndexPlan.
+type RenameIndexPlan struct {
+	Name     string
+	NewName  string
+	Table    string
+	IfExists bool
+}
+
+func (r *RenameIndexPlan) Type() string  { return "RenameIndex" }
+func (r *RenameIndexPlan) Cost() float64 { return 0 }
+func (r *RenameIndexPlan) String() string {
+	return fmt.Sprintf("RenameIndex(%s TO %s ON %s)", r.Name, r.NewName, r.Table)
+}
+
+type AnalyzePlan struct {
+	Table   string
+	EstCost float64
+}
+
+func (a *AnalyzePlan) Type() string  { return "Analyze" }
+func (a *AnalyzePlan) Cost() float64 { return a.EstCost }
+func (a *AnalyzePlan) String() string {
+	table := a.Table
+	if table == "" {
+		table = "*"
+	}
+	return fmt.Sprintf("Analyze(%s, cost=%.2f)", table, a.EstCost)
+}
+
+type CreateSchemaPlan struct {
+	Name string
+}
+
+func (c *CreateSchemaPlan) Type() string  { return "CreateSchema" }
+func (c *CreateSchemaPlan) Cost() float64 { return 0 }
+func (c *CreateSchemaPlan) String() string {
+	return fmt.Sprintf("CreateSchema(%s)", c.Name)
+}
+
+type AttachPlan struct {
+	File  string
+	Alias string
+}
+
+func (a *AttachPlan) Type() string  { return "Attach" }
+func (a *AttachPlan) Cost() float64 { return 0 }
+func (a *AttachPlan) String() string {
+	return fmt.Sprintf("Attach(%s AS %s)", a.File, a.Alias)
+}
+
+type DetachPlan struct {
+	Alias string
+}
+
+func (d *DetachPlan) Type() string  { return "Detach" }
+func (d *DetachPlan) Cost() float64 { return 0 }
+func (d *DetachPlan) String() string {
+	return fmt.Sprintf("Detach(%s)", d.Alias)
+}
+
 type Condition struct {
 	Column   string
+	Expr     parser.Expr
 	Operator string
-	Value    string
+	// Value has kind parser.Identifier when it names a column of the row
+	// rather than being a literal.
+	Value parser.Value
+}
+
+// literal reports whether cond compares against a non-NULL constant, which
+// an index lookup can find.
+func (c Condition) literal() bool {
+	return c.Value.Kind != parser.Identifier && c.Value.Kind != parser.NullLiteral
+}
+
+// indexable reports whether an index on the column of cond can find the
+// rows it matches: it compares with a literal, and a pattern must start
+// with literal text.
+func (c Condition) indexable() bool {
+	if !c.literal() {
+		return false
+	}
+	switch c.Operator {
+	case "NOT LIKE", "NOT ILIKE", "REGEXP", "NOT REGEXP":
+		return false
+	case "LIKE", "ILIKE":
+		_, ok := likePrefix(c.Value.Text, c.Operator == "ILIKE")
+		return ok
+	}
+	return true
 }
 
 func (c Condition) String() string {
@@ -224,13 +550,16 @@ func (c Condition) String() string {
 
 type Assignment struct {
 	Column string
-	Value  string
+	Value  parser.Value
 }
 
 type TableStats struct {
 	Name     string
 	RowCount int
 	Indexes  map[string]*IndexInfo
+	// Changes counts the rows inserted, updated or deleted since the
+	// statistics were read.
+	Changes int
 }
 
 type IndexInfo struct {
@@ -240,16 +569,152 @@ type IndexInfo struct {
 	Selectivity float64
 }
 
+// CostModel holds the constants the planner prices plans with. Costs have
+// no unit; only how they compare matters. The planner estimates rows, not
+// pages, so each constant is the cost of handling one row.
+type CostModel struct {
+	// SeqRowCost is the cost of reading one row in a scan of the whole
+	// table.
+	SeqRowCost float64
+	// IndexProbeCost is the cost of reading one row found through an
+	// index.
+	IndexProbeCost float64
+	// CPURowCost is the cost of projecting one row or comparing one pair
+	// of rows in a join.
+	CPURowCost float64
+}
+
+// DefaultCostModel is the cost model a planner starts with.
+var DefaultCostModel = CostModel{
+	SeqRowCost:     1.0,
+	IndexProbeCost: 0.1,
+	CPURowCost:     0.01,
+}
+
 type Planner struct {
-	stats map[string]*TableStats
+	catalog  *catalog.Catalog
+	attached map[string]*attachedDB
+	virtual  map[string]VirtualTable
+	stats    map[string]*TableStats
+	costs    CostModel
 }
 
 func NewPlanner(catalog *catalog.Catalog) *Planner {
 	return &Planner{
-		stats: make(map[string]*TableStats),
+		catalog: catalog,
+		stats:   make(map[string]*TableStats),
+		costs:   DefaultCostModel,
 	}
 }
 
+// SetCostModel sets the constants later plans are priced with.
+func (p *Planner) SetCostModel(costs CostModel) {
+	p.costs = costs
+}
+
+// LoadStats registers every table and index in the catalog with the planner,
+// using persisted statistics where ANALYZE has run and live counts otherwise.
+func (p *Planner) LoadStats() error {
+	if p.catalog == nil {
+		return nil
+	}
+
+	p.stats = make(map[string]*TableStats)
+	for _, name := range p.catalog.ListTables() {
+		if err := p.RefreshTable(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Planner) RefreshTable(name string) error {
+	if p.catalog == nil {
+		return nil
+	}
+
+	cat, tableName, err := resolveTable(p.catalog, p.attached, name)
+	if err != nil {
+		return err
+	}
+
+	if !cat.TableExists(tableName) {
+		delete(p.stats, name)
+		return nil
+	}
+
+	persisted, err := cat.GetTableStats(tableName)
+	if err != nil {
+		table, err := cat.LoadTable(tableName)
+		if err != nil {
+			return fmt.Errorf("failed to load table %s for statistics: %w", name, err)
+		}
+		count, err := table.Count()
+		if err != nil {
+			return fmt.Errorf("failed to count rows of %s: %w", name, err)
+		}
+		persisted = &catalog.TableStatistics{TableName: name, RowCount: count}
+	}
+
+	p.RegisterTable(name, persisted.RowCount)
+
+	for _, idx := range cat.GetTableIndexes(tableName) {
+		p.RegisterIndex(name, idx.Name, []string{idx.ColumnName}, idx.Unique)
+		if distinct := persisted.IndexDistinct[idx.Name]; distinct > 0 && !idx.Unique {
+			p.stats[name].Indexes[idx.Name].Selectivity = 1.0 / float64(distinct)
+		}
+	}
+
+	return nil
+}
+
+// DropDatabase forgets the statistics of every table in a detached database.
+func (p *Planner) DropDatabase(alias string) {
+	for name := range p.stats {
+		if strings.HasPrefix(name, alias+".") {
+			delete(p.stats, name)
+		}
+	}
+}
+
+// AdjustRowCount keeps the in-memory row estimate roughly current between
+// ANALYZE runs as DML adds and removes rows.
+func (p *Planner) AdjustRowCount(table string, delta int) {
+	if stats, ok := p.stats[table]; ok {
+		stats.RowCount += delta
+		if stats.RowCount < 0 {
+			stats.RowCount = 0
+		}
+	}
+	if delta < 0 {
+		delta = -delta
+	}
+	p.CountChanges(table, delta)
+}
+
+// CountChanges records that n rows of table changed, for StaleTables.
+func (p *Planner) CountChanges(table string, n int) {
+	if stats, ok := p.stats[table]; ok {
+		stats.Changes += n
+	}
+}
+
+// StaleTables returns, in name order, the tables in which at least
+// minChanges rows and percent percent of the rows have changed since their
+// statistics were read, and starts counting their changes afresh.
+func (p *Planner) StaleTables(percent float64, minChanges int) []string {
+	var stale []string
+	for name, stats := range p.stats {
+		if stats.Changes < minChanges || float64(stats.Changes) < percent/100*float64(stats.RowCount) {
+			continue
+		}
+		stats.Changes = 0
+		stale = append(stale, name)
+	}
+	sort.Strings(stale)
+	return stale
+}
+
 func (p *Planner) RegisterTable(name string, rowCount int) {
 	p.stats[name] = &TableStats{
 		Name:     name,
@@ -262,7 +727,7 @@ func (p *Planner) RegisterIndex(table, indexName string, columns []string, uniqu
 	if stats, ok := p.stats[table]; ok {
 		selectivity := 0.1
 		if unique {
-			selectivity = 1.0 / float64(stats.RowCount)
+			selectivity = 1.0 / float64(max(stats.RowCount, 1))
 		}
 		stats.Indexes[indexName] = &IndexInfo{
 			Name:        indexName,
@@ -285,64 +750,192 @@ func (p *Planner) Plan(node parser.Node) (PlanNode, error) {
 		return p.planCreateTable(stmt)
 	case *parser.CreateIndexStmt:
 		return p.planCreateIndex(stmt)
+	case *parser.DropIndexStmt:
+		table, err := p.indexTable(stmt.Name, stmt.IfExists)
+		if err != nil {
+			return nil, err
+		}
+		return &DropIndexPlan{Name: stmt.Name, Table: table, IfExists: stmt.IfExists}, nil
+	case *parser.RenameIndexStmt:
+		table, err := p.indexTable(stmt.Name, stmt.IfExists)
+		if err != nil {
+			return nil, err
+		}
+		return &RenameIndexPlan{Name: stmt.Name, NewName: stmt.NewName, Table: table, IfExists: stmt.IfExists}, nil
 	case *parser.UpdateStmt:
 		return p.planUpdate(stmt)
+	case *parser.AnalyzeStmt:
+		return p.planAnalyze(stmt)
+	case *parser.CreateSchemaStmt:
+		return &CreateSchemaPlan{Name: stmt.Name}, nil
+	case *parser.CreateUserStmt:
+		return &CreateUserPlan{Name: stmt.Name, Password: stmt.Password}, nil
+	case *parser.GrantStmt:
+		privs, err := parsePrivileges(stmt.Privileges)
+		if err != nil {
+			return nil, err
+		}
+		return &GrantPlan{Revoke: stmt.Revoke, Privileges: privs, Table: stmt.Table, User: stmt.User}, nil
+	case *parser.CreatePolicyStmt:
+		policy := &catalog.Policy{Name: stmt.Name, Table: stmt.Table, User: stmt.User}
+		if hasSubquery(stmt.Conditions) {
+			return nil, errSubqueryPlace
+		}
+		for _, cond := range stmt.Conditions {
+			policy.Conditions = append(policy.Conditions, catalog.PolicyCondition{
+				Column:   cond.Column,
+				Operator: cond.Operator,
+				Value:    cond.Value.Text,
+			})
+		}
+		return &CreatePolicyPlan{Policy: policy}, nil
+	case *parser.DropPolicyStmt:
+		return &DropPolicyPlan{Name: stmt.Name, Table: stmt.Table}, nil
+	case *parser.CreateProcedureStmt:
+		if err := checkProcedure(stmt); err != nil {
+			return nil, err
+		}
+		return &CreateProcedurePlan{Procedure: &catalog.Procedure{Name: stmt.Name, Source: stmt.Source}}, nil
+	case *parser.DropProcedureStmt:
+		return &DropProcedurePlan{Name: stmt.Name}, nil
+	case *parser.CallStmt:
+		return &CallPlan{Name: stmt.Name, Args: stmt.Args}, nil
+	case *parser.CreateJobStmt:
+		return planCreateJob(stmt)
+	case *parser.DropJobStmt:
+		return &DropJobPlan{Name: stmt.Name}, nil
+	case *parser.SetStmt:
+		return &SetPlan{Name: stmt.Name, Value: stmt.Value}, nil
+	case *parser.ShowStmt:
+		if stmt.Name == "processlist" {
+			return &ProcessListPlan{}, nil
+		}
+		if stmt.Name == "jobs" {
+			return &ShowJobsPlan{}, nil
+		}
+		return &ShowPlan{Name: stmt.Name}, nil
+	case *parser.KillStmt:
+		return &KillPlan{ID: stmt.ID, Query: stmt.Query}, nil
+	case *parser.AttachStmt:
+		return &AttachPlan{File: stmt.File, Alias: stmt.Alias}, nil
+	case *parser.DetachStmt:
+		return &DetachPlan{Alias: stmt.Alias}, nil
+	case *parser.ExplainStmt:
+		input, err := p.Plan(stmt.Stmt)
+		if err != nil {
+			return nil, err
+		}
+		return &ExplainPlan{Input: input, Verbose: stmt.Verbose}, nil
 	default:
 		return nil, fmt.Errorf("unsupported statement type for planning")
 	}
 }
 
 func (p *Planner) planSelect(stmt *parser.SelectStmt) (PlanNode, error) {
+	if whereHasSubquery(stmt.Having) {
+		return nil, errSubqueryPlace
+	}
+	if stmt.Where != nil && predicateHasSubquery(stmt.Where.Predicates) {
+		return nil, errSubqueryOr
+	}
+	if err := p.checkSelectColumns(stmt); err != nil {
+		return nil, err
+	}
+	stmt, outputOrder, err := resolveAliases(stmt)
+	if err != nil {
+		return nil, err
+	}
+	stmt, subqueries := splitSubqueries(stmt)
+	where, after := p.splitJoinWhere(stmt)
 
-	scan, err := p.planScanWithAlias(stmt.Table, stmt.Where)
+	scan, err := p.planScanWithAlias(stmt.Table, where)
 	if err != nil {
 		return nil, err
 	}
+	if len(stmt.Joins) == 0 {
+		if err := checkQualified(scan); err != nil {
+			return nil, err
+		}
+	}
 
 	var currentPlan PlanNode = scan
 
 	if len(stmt.Joins) > 0 {
+		var joinPlan *JoinPlan
+		tables := []*parser.TableRef{stmt.Table}
+		merged := make(map[string]bool)
 		for _, join := range stmt.Joins {
-			joinPlan, err := p.planJoin(currentPlan, join)
+			joinPlan, err = p.planJoin(currentPlan, join, tables, merged)
 			if err != nil {
 				return nil, err
 			}
 			currentPlan = joinPlan
+			tables = append(tables, join.Table)
+		}
+		if after != nil {
+			filter := planFilter(after)
+			filter.Selectivity = p.estimateSelectivity(filter.Conditions) * predicateSelectivity(filter.Predicates)
+			joinPlan.Filter = filter
+			joinPlan.EstRows = int(float64(joinPlan.EstRows) * filter.Selectivity)
 		}
 	}
 
-	if len(stmt.GroupBy) > 0 {
-		groupPlan, err := p.planGroupBy(stmt.GroupBy, stmt.Having, currentPlan)
+	for _, cond := range subqueries {
+		if currentPlan, err = p.planSemiJoin(stmt, currentPlan, cond); err != nil {
+			return nil, err
+		}
+	}
+
+	aggregates := queryAggregates(stmt)
+	var indexAggregate *IndexAggregatePlan
+	if len(subqueries) == 0 {
+		indexAggregate = p.planIndexAggregate(stmt, aggregates, scan)
+	}
+	if indexAggregate != nil {
+		currentPlan = indexAggregate
+	} else if len(stmt.GroupBy) > 0 || len(aggregates) > 0 {
+		groupPlan, err := p.planGroupBy(stmt.GroupBy, aggregates, stmt.Having, currentPlan)
 		if err != nil {
 			return nil, err
 		}
 		currentPlan = groupPlan
 	}
 
-	if len(stmt.OrderBy) > 0 {
-		sortPlan := p.planSort(stmt.OrderBy, currentPlan)
+	var sortPlan *SortPlan
+	if len(stmt.OrderBy) > 0 && !p.planOrderedScan(stmt, scan) {
+		sortPlan = p.planSort(stmt.OrderBy, currentPlan)
 		currentPlan = sortPlan
 	}
 
-	projectCost := currentPlan.Cost() + p.estimateRows(currentPlan)*0.01
+	projectCost := currentPlan.Cost() + p.estimateRows(currentPlan)*p.costs.CPURowCost
 	if stmt.Distinct {
 
 		projectCost += p.estimateRows(currentPlan) * 0.5
 	}
 	project := &ProjectPlan{
 		Columns:  stmt.Columns,
+		Exprs:    stmt.Exprs,
+		Aliases:  stmt.Aliases,
 		Distinct: stmt.Distinct,
 		Input:    currentPlan,
 		EstCost:  projectCost,
 	}
 	currentPlan = project
 
+	if outputOrder != nil {
+		sortPlan = p.planSort(outputOrder, currentPlan)
+		currentPlan = sortPlan
+	}
+
 	if stmt.Limit != nil {
-		limitPlan := &LimitPlan{
-			Count:   stmt.Limit.Count,
-			Offset:  stmt.Limit.Offset,
-			Input:   currentPlan,
-			EstCost: currentPlan.Cost() * 0.1,
+		limitPlan, err := planLimit(stmt.Limit, currentPlan)
+		if err != nil {
+			return nil, err
+		}
+		// DISTINCT runs after a sort below the projection and may drop
+		// some of the first rows, so it needs them all.
+		if sortPlan != nil && (!stmt.Distinct || outputOrder != nil) {
+			p.planTopK(sortPlan, limitPlan)
 		}
 		currentPlan = limitPlan
 	}
@@ -350,8 +943,52 @@ func (p *Planner) planSelect(stmt *parser.SelectStmt) (PlanNode, error) {
 	return currentPlan, nil
 }
 
+func planLimit(limit *parser.LimitClause, input PlanNode) (*LimitPlan, error) {
+	plan := &LimitPlan{Count: -1, Input: input, EstCost: input.Cost() * 0.1}
+	if limit.Count != "" {
+		count, err := strconv.Atoi(limit.Count)
+		if err != nil {
+			return nil, sqlerr.New(sqlerr.InvalidLimitValue, "LIMIT must be a whole number, got %s", limit.Count)
+		}
+		if count < 0 {
+			return nil, sqlerr.New(sqlerr.InvalidLimitValue, "LIMIT must not be negative")
+		}
+		plan.Count = count
+	}
+	if limit.Offset != "" {
+		offset, err := strconv.Atoi(limit.Offset)
+		if err != nil {
+			return nil, sqlerr.New(sqlerr.InvalidOffsetValue, "OFFSET must be a whole number, got %s", limit.Offset)
+		}
+		if offset < 0 {
+			return nil, sqlerr.New(sqlerr.InvalidOffsetValue, "OFFSET must not be negative")
+		}
+		plan.Offset = offset
+	}
+	return plan, nil
+}
+
 func (p *Planner) planScanWithAlias(tableRef *parser.TableRef, where *parser.WhereClause) (*ScanPlan, error) {
+	if whereHasSubquery(where) {
+		return nil, errSubqueryPlace
+	}
 	stats, ok := p.stats[tableRef.Name]
+	if tableRef.Function {
+		fn, err := lookupTableFunc(tableRef.Name)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := fn.estimate(tableRef.Args)
+		if err != nil {
+			return nil, err
+		}
+		stats, ok = &TableStats{Name: tableRef.Name, RowCount: rows, Indexes: make(map[string]*IndexInfo)}, true
+	}
+	vt, virtual := p.virtual[tableRef.Name]
+	virtual = virtual && !tableRef.Function
+	if virtual {
+		stats, ok = &TableStats{Name: tableRef.Name, RowCount: virtualRows(vt), Indexes: make(map[string]*IndexInfo)}, true
+	}
 	if !ok {
 
 		stats = &TableStats{
@@ -362,89 +999,569 @@ func (p *Planner) planScanWithAlias(tableRef *parser.TableRef, where *parser.Whe
 	}
 
 	scan := &ScanPlan{
-		Table:   tableRef.Name,
-		Alias:   tableRef.Alias,
-		EstRows: stats.RowCount,
+		Table:    tableRef.Name,
+		Alias:    tableRef.Alias,
+		EstRows:  stats.RowCount,
+		Hint:     tableRef.Hint,
+		Sample:   tableRef.Sample,
+		Function: tableRef.Function,
+		Args:     tableRef.Args,
+		Virtual:  virtual,
+	}
+	if hint := tableRef.Hint; hint != nil {
+		for _, name := range hint.Indexes {
+			if _, ok := stats.Indexes[name]; !ok {
+				scan.UnknownIndexes = append(scan.UnknownIndexes, name)
+			}
+		}
+	}
+
+	// Rows hold their values by bare column name, so qualifiers naming
+	// this table are dropped here. Any others are left for the caller.
+	schema := p.tableSchema(tableRef.Name)
+	convert := func(c parser.Condition) Condition {
+		cond := plainCondition(c)
+		if name, ok := scanColumn(scan, c.Column); ok && c.Expr == nil {
+			cond.Column = name
+		}
+		if c.Value.Kind == parser.Identifier {
+			// A bare word names a column, which the statement was
+			// checked to have.
+			if name, ok := scanColumn(scan, c.Value.Text); ok {
+				cond.Value.Text = name
+			}
+		}
+		return cond
 	}
 
+	var predicates []Predicate
+	if where != nil {
+		predicates = planPredicates(where.Predicates, convert)
+	}
 	if where == nil || len(where.Conditions) == 0 {
 		scan.ScanType = FullScan
-		scan.EstCost = float64(stats.RowCount) * 1.0
+		scan.EstCost = float64(stats.RowCount) * p.costs.SeqRowCost
+		scan.Alternatives = []Alternative{{
+			Path:    string(FullScan),
+			EstRows: scan.EstRows,
+			EstCost: scan.EstCost,
+			Costed:  true,
+			Chosen:  true,
+			Reason:  "no conditions to look up",
+		}}
+		if len(predicates) > 0 {
+			selectivity := predicateSelectivity(predicates)
+			scan.EstRows = int(float64(stats.RowCount) * selectivity)
+			scan.Filter = &FilterPlan{Predicates: predicates, Selectivity: selectivity}
+		}
+		scan.EstRows = sampledRows(scan)
 		return scan, nil
 	}
 
 	conditions := make([]Condition, len(where.Conditions))
 	for i, c := range where.Conditions {
-		conditions[i] = Condition{
-			Column:   c.Column,
-			Operator: c.Operator,
-			Value:    c.Value,
-		}
+		conditions[i] = convert(c)
 	}
 
-	bestIndex := p.findBestIndex(stats, conditions)
+	var bestIndex *IndexInfo
+	if scan.Sample != nil {
+		// The sample is drawn from the rows in table order, so that the
+		// same seed keeps the same rows whatever the conditions.
+		scan.Alternatives = []Alternative{{
+			Path:    string(FullScan),
+			EstRows: int(float64(stats.RowCount) * p.estimateSelectivity(conditions)),
+			EstCost: float64(stats.RowCount) * p.costs.SeqRowCost,
+			Costed:  true,
+			Chosen:  true,
+			Reason:  "TABLESAMPLE reads every row",
+		}}
+	} else {
+		bestIndex, scan.Alternatives = p.chooseAccessPath(stats, conditions, scan.Hint)
+	}
 
-	if bestIndex != nil {
+	var cluster *Alternative
+	if rng, ok := clusterRangeOf(schema, conditions); ok && scan.Sample == nil {
+		cluster = p.chooseClusterScan(scan, stats, rng)
+		if cluster != nil {
+			bestIndex = nil
+		}
+	}
+
+	if cluster != nil {
+		scan.ScanType = ClusterScan
+		scan.EstRows = cluster.EstRows
+		scan.EstCost = cluster.EstCost
+	} else if bestIndex != nil {
 		scan.ScanType = IndexScan
 		scan.IndexName = bestIndex.Name
 		if bestIndex.Unique {
 			scan.ScanType = UniqueIndexScan
 		}
-		scan.EstRows = int(float64(stats.RowCount) * bestIndex.Selectivity)
-		scan.EstCost = float64(scan.EstRows) * 0.1
+		scan.EstRows = p.indexRows(stats, bestIndex)
+		scan.EstCost = float64(scan.EstRows) * p.costs.IndexProbeCost
 	} else {
 		scan.ScanType = FullScan
 		selectivity := p.estimateSelectivity(conditions)
 		scan.EstRows = int(float64(stats.RowCount) * selectivity)
-		scan.EstCost = float64(stats.RowCount) * 1.0
+		scan.EstCost = float64(stats.RowCount) * p.costs.SeqRowCost
 	}
 
+	scan.EstRows = int(float64(scan.EstRows) * predicateSelectivity(predicates))
 	scan.Filter = &FilterPlan{
 		Conditions:  conditions,
+		Predicates:  predicates,
 		Selectivity: float64(scan.EstRows) / float64(stats.RowCount),
 	}
+	scan.EstRows = sampledRows(scan)
 
 	return scan, nil
 }
 
+// chooseClusterScan weighs reading the cluster key range rng selects
+// against the path chooseAccessPath picked, and returns the range's
+// alternative if it is cheaper. Like a full scan, it is ruled out by USE
+// INDEX.
+func (p *Planner) chooseClusterScan(scan *ScanPlan, stats *TableStats, rng *clusterRange) *Alternative {
+	rows := int(float64(stats.RowCount) * p.estimateSelectivity(rng.conditions))
+	alt := Alternative{
+		Path:    string(ClusterScan),
+		EstRows: rows,
+		EstCost: float64(rows) * p.costs.SeqRowCost,
+		Costed:  true,
+	}
+
+	chosen := -1
+	for i := range scan.Alternatives {
+		if scan.Alternatives[i].Chosen {
+			chosen = i
+		}
+	}
+	switch {
+	case scan.Hint != nil && !scan.Hint.Ignore:
+		alt.Costed = false
+		alt.Reason = "ruled out by USE INDEX"
+	case chosen < 0 || alt.EstCost < scan.Alternatives[chosen].EstCost:
+		if chosen >= 0 {
+			scan.Alternatives[chosen].Chosen = false
+			scan.Alternatives[chosen].Reason = "costs more than the cluster key range"
+		}
+		alt.Chosen = true
+		alt.Reason = "reads only the cluster key range the conditions select"
+	}
+	scan.Alternatives = append(scan.Alternatives, alt)
+	if !alt.Chosen {
+		return nil
+	}
+	return &scan.Alternatives[len(scan.Alternatives)-1]
+}
+
+// sampledRows estimates how many of the rows scan would read its sample
+// keeps.
+func sampledRows(scan *ScanPlan) int {
+	if scan.Sample == nil {
+		return scan.EstRows
+	}
+	return int(float64(scan.EstRows) * scan.Sample.Percent / 100)
+}
+
 func (p *Planner) planScan(table string, where *parser.WhereClause) (*ScanPlan, error) {
 	tableRef := &parser.TableRef{Name: table}
-	return p.planScanWithAlias(tableRef, where)
+	scan, err := p.planScanWithAlias(tableRef, where)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkQualified(scan); err != nil {
+		return nil, err
+	}
+	return scan, nil
+}
+
+// splitJoinWhere divides the WHERE clause of a join between the leftmost
+// table, whose rows it filters before they are joined, and the joined rows.
+// A condition, or an OR all of whose conditions do, goes to the table when
+// it reads only that table's columns and every join is INNER or LEFT, so
+// that filtering early drops nothing the join would have kept.
+func (p *Planner) splitJoinWhere(stmt *parser.SelectStmt) (*parser.WhereClause, *parser.WhereClause) {
+	if len(stmt.Joins) == 0 || stmt.Where == nil {
+		return stmt.Where, nil
+	}
+	for _, join := range stmt.Joins {
+		if t := strings.ToUpper(join.Type); t != "" && t != "INNER" && t != "LEFT" {
+			return nil, stmt.Where
+		}
+	}
+
+	scan := &ScanPlan{Table: stmt.Table.Name, Alias: stmt.Table.Alias}
+	schema := p.tableSchema(stmt.Table.Name)
+	reads := func(column string) bool {
+		name, ok := scanColumn(scan, column)
+		return ok && schema != nil && schema.GetColumn(name) != nil
+	}
+	early := func(c parser.Condition) bool {
+		return c.Expr == nil && reads(c.Column) && (c.Value.Kind != parser.Identifier || reads(c.Value.Text))
+	}
+
+	where, after := &parser.WhereClause{}, &parser.WhereClause{}
+	for _, c := range stmt.Where.Conditions {
+		if early(c) {
+			where.Conditions = append(where.Conditions, c)
+		} else {
+			after.Conditions = append(after.Conditions, c)
+		}
+	}
+	for _, pred := range stmt.Where.Predicates {
+		if allConditions(pred.Conditions(), early) {
+			where.Predicates = append(where.Predicates, pred)
+		} else {
+			after.Predicates = append(after.Predicates, pred)
+		}
+	}
+	if len(after.Conditions) == 0 && len(after.Predicates) == 0 {
+		after = nil
+	}
+	return where, after
 }
 
-func (p *Planner) planJoin(left PlanNode, join *parser.JoinClause) (*JoinPlan, error) {
+// allConditions reports whether every one of conds is ok.
+func allConditions(conds []parser.Condition, ok func(parser.Condition) bool) bool {
+	for _, c := range conds {
+		if !ok(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// planJoin plans join of left, the join of the tables before it. merged
+// holds the columns earlier USING and NATURAL joins have merged, to which
+// planJoin adds its own.
+func (p *Planner) planJoin(left PlanNode, join *parser.JoinClause, tables []*parser.TableRef, merged map[string]bool) (*JoinPlan, error) {
+	if join.Condition.Subquery != nil {
+		return nil, errSubqueryPlace
+	}
 
 	rightScan, err := p.planScanWithAlias(join.Table, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	var conditions []Condition
+	var using []string
+	if join.Natural || len(join.Using) > 0 {
+		if conditions, using, err = p.usingConditions(join, tables, merged); err != nil {
+			return nil, err
+		}
+	} else {
+		conditions = []Condition{{
+			Column:   join.Condition.Column,
+			Expr:     join.Condition.Expr,
+			Operator: join.Condition.Operator,
+			Value:    join.Condition.Value,
+		}}
+	}
+
 	leftRows := p.estimateRows(left)
 	rightRows := float64(rightScan.EstRows)
 
 	joinRows := int(leftRows * rightRows * 0.1)
+	if len(conditions) == 0 {
+		joinRows = int(leftRows * rightRows)
+	}
 
-	joinCost := left.Cost() + rightScan.Cost() + (leftRows * rightRows * 0.01)
+	joinCost := left.Cost() + rightScan.Cost() + (leftRows * rightRows * p.costs.CPURowCost)
 
-	joinType := join.Type
+	joinType := strings.ToUpper(join.Type)
 	if joinType == "" {
 		joinType = "INNER"
 	}
+	// An outer join keeps every row of its preserved sides.
+	if (joinType == "LEFT" || joinType == "FULL") && joinRows < int(leftRows) {
+		joinRows = int(leftRows)
+	}
+	if (joinType == "RIGHT" || joinType == "FULL") && joinRows < int(rightRows) {
+		joinRows = int(rightRows)
+	}
 
 	return &JoinPlan{
-		JoinType: joinType,
-		Left:     left,
-		Right:    rightScan,
-		Condition: Condition{
-			Column:   join.Condition.Column,
-			Operator: join.Condition.Operator,
-			Value:    join.Condition.Value,
-		},
-		EstRows: joinRows,
-		EstCost: joinCost,
+		JoinType:   joinType,
+		Left:       left,
+		Right:      rightScan,
+		Conditions: conditions,
+		Using:      using,
+		EstRows:    joinRows,
+		EstCost:    joinCost,
 	}, nil
 }
 
+// usingConditions returns the equalities of a USING or NATURAL join and the
+// columns they merge. A NATURAL join compares every column of its table that
+// the tables before it also have, and is a cross join when there are none.
+// Each column is read on the left from the column an earlier join merged,
+// or else from the one table before the join that has it.
+func (p *Planner) usingConditions(join *parser.JoinClause, tables []*parser.TableRef, merged map[string]bool) ([]Condition, []string, error) {
+	right := p.tableSchema(join.Table.Name)
+	if right == nil {
+		return nil, nil, sqlerr.New(sqlerr.UndefinedTable, "table '%s' does not exist", join.Table.Name)
+	}
+
+	leftColumn := func(column string) (string, error) {
+		if merged[column] {
+			return column, nil
+		}
+		ref := ""
+		for _, t := range tables {
+			schema := p.tableSchema(t.Name)
+			if schema == nil || schema.GetColumn(column) == nil {
+				continue
+			}
+			if ref != "" {
+				return "", sqlerr.New(sqlerr.AmbiguousColumn, "column '%s' in join is ambiguous", column)
+			}
+			ref = tablePrefix(t.Name, t.Alias) + "." + column
+		}
+		return ref, nil
+	}
+
+	columns := join.Using
+	if join.Natural {
+		columns = nil
+		for _, col := range right.Columns {
+			ref, err := leftColumn(col.Name)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ref != "" {
+				columns = append(columns, col.Name)
+			}
+		}
+	}
+
+	rightPrefix := tablePrefix(join.Table.Name, join.Table.Alias)
+	conditions := make([]Condition, 0, len(columns))
+	for _, column := range columns {
+		if right.GetColumn(column) == nil {
+			return nil, nil, sqlerr.New(sqlerr.UndefinedColumn,
+				"column '%s' in USING does not exist in table '%s'", column, join.Table.Name)
+		}
+		ref, err := leftColumn(column)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ref == "" {
+			return nil, nil, sqlerr.New(sqlerr.UndefinedColumn,
+				"column '%s' in USING does not exist in the tables before the join", column)
+		}
+		conditions = append(conditions, Condition{
+			Column:   ref,
+			Operator: "=",
+			Value:    parser.Value{Kind: parser.Identifier, Text: rightPrefix + "." + column},
+		})
+	}
+	for _, column := range columns {
+		merged[column] = true
+	}
+	return conditions, columns, nil
+}
+
+// planOrderedScan turns a full scan of a single table ordered by one column
+// into an OrderedScan when a B-tree already holds the rows in that order,
+// read backwards for DESC, and reports whether the sort can be dropped.
+func (p *Planner) planOrderedScan(stmt *parser.SelectStmt, scan *ScanPlan) bool {
+	if len(stmt.Joins) > 0 || len(stmt.GroupBy) > 0 || len(queryAggregates(stmt)) > 0 ||
+		len(stmt.OrderBy) != 1 || scan.ScanType != FullScan || scan.Sample != nil || scan.Function || scan.Virtual {
+		return false
+	}
+
+	if scan.Filter != nil {
+		for _, cond := range scan.Filter.allConditions() {
+			if cond.Column == catalog.RowIDColumn {
+				return false
+			}
+		}
+	}
+
+	item := stmt.OrderBy[0]
+	column, ok := scanColumn(scan, item.Column)
+	if !ok {
+		return false
+	}
+
+	indexName, ok := p.orderingIndex(scan.Table, column, false, scan.Hint)
+	if !ok {
+		return false
+	}
+
+	direction := strings.ToUpper(item.Direction)
+	if direction == "" {
+		direction = "ASC"
+	}
+	scan.ScanType = OrderedScan
+	scan.IndexName = indexName
+	scan.Order = &OrderItem{Column: item.Column, Direction: direction}
+	if indexName != "" {
+		scan.EstCost += float64(scan.EstRows) * p.costs.IndexProbeCost
+	}
+
+	path := string(OrderedScan)
+	if indexName != "" {
+		path += "(" + indexName + ")"
+	}
+	for i := range scan.Alternatives {
+		if scan.Alternatives[i].Chosen {
+			scan.Alternatives[i].Chosen = false
+			scan.Alternatives[i].Reason = "would need a sort"
+		}
+	}
+	scan.Alternatives = append(scan.Alternatives, Alternative{
+		Path:    path,
+		EstRows: scan.EstRows,
+		EstCost: scan.EstCost,
+		Costed:  true,
+		Chosen:  true,
+		Reason:  "reads rows in ORDER BY order, so no sort is needed",
+	})
+	return true
+}
+
+// planIndexAggregate plans a query whose only results are MIN and MAX of
+// columns with an ordering index over the whole table, which needs one
+// B-tree descent per aggregate instead of a full scan. It returns nil for
+// any other query.
+func (p *Planner) planIndexAggregate(stmt *parser.SelectStmt, aggregates []string, scan *ScanPlan) *IndexAggregatePlan {
+	if len(aggregates) == 0 || len(stmt.Joins) > 0 || len(stmt.GroupBy) > 0 ||
+		stmt.Having != nil || scan.ScanType != FullScan || scan.Filter != nil || scan.Sample != nil ||
+		scan.Function || scan.Virtual {
+		return nil
+	}
+
+	plan := &IndexAggregatePlan{Aggregates: aggregates, Scan: scan}
+	for _, agg := range aggregates {
+		fn, arg, _ := parser.SplitAggregate(agg)
+		if fn != "MIN" && fn != "MAX" {
+			return nil
+		}
+		column, ok := scanColumn(scan, arg)
+		if !ok {
+			return nil
+		}
+		// MIN and MAX ignore NULLs, so an index that leaves them out will do.
+		indexName, ok := p.orderingIndex(scan.Table, column, true, scan.Hint)
+		if !ok {
+			return nil
+		}
+		plan.Indexes = append(plan.Indexes, indexName)
+	}
+	plan.EstCost = float64(len(aggregates))
+	return plan
+}
+
+// scanColumn strips a qualifier naming scan's table or alias from column,
+// and reports false for a qualifier naming anything else. A table in a
+// schema may be named with or without its schema.
+func scanColumn(scan *ScanPlan, column string) (string, bool) {
+	i := strings.LastIndex(column, ".")
+	if i < 0 {
+		return column, true
+	}
+	qualifier, name := column[:i], column[i+1:]
+	if qualifier == scan.Alias {
+		return name, true
+	}
+	qualifier = strings.TrimPrefix(qualifier, mainDatabase+".")
+	table := strings.TrimPrefix(scan.Table, mainDatabase+".")
+	if j := strings.LastIndex(table, "."); j >= 0 && qualifier == table[j+1:] {
+		return name, true
+	}
+	if qualifier != table {
+		return "", false
+	}
+	return name, true
+}
+
+// checkQualified rejects a filter column of a single-table query whose
+// qualifier names neither the table nor its alias.
+func checkQualified(scan *ScanPlan) error {
+	if scan.Filter == nil {
+		return nil
+	}
+	for _, cond := range scan.Filter.allConditions() {
+		if i := strings.LastIndex(cond.Column, "."); i >= 0 && cond.Expr == nil {
+			return sqlerr.New(sqlerr.UndefinedTable, "missing FROM-clause entry for table '%s'", cond.Column[:i])
+		}
+	}
+	return nil
+}
+
+// tableSchema returns the schema of table, or nil if it cannot be loaded.
+func (p *Planner) tableSchema(table string) *catalog.Schema {
+	if vt, ok := p.virtual[table]; ok {
+		return virtualSchema(table, vt)
+	}
+	if p.catalog == nil {
+		return nil
+	}
+	cat, name, err := resolveTable(p.catalog, p.attached, table)
+	if err != nil {
+		return nil
+	}
+	schema, err := cat.GetTable(name)
+	if err != nil {
+		return nil
+	}
+	return schema
+}
+
+// orderingIndex finds a B-tree holding the rows of table sorted by column:
+// the table's own tree, named by "", when column is the primary key, or a
+// single-column index. Indexes leave out NULLs, so unless allowNull is set
+// the column must be NOT NULL, and only types whose key order matches the
+// sort order qualify, and an index only if hint allows it.
+func (p *Planner) orderingIndex(table, column string, allowNull bool, hint *parser.IndexHint) (string, bool) {
+	if p.catalog == nil {
+		return "", false
+	}
+	cat, name, err := resolveTable(p.catalog, p.attached, table)
+	if err != nil {
+		return "", false
+	}
+	schema, err := cat.GetTable(name)
+	if err != nil {
+		return "", false
+	}
+
+	if column == catalog.RowIDColumn && schema.HasRowID() {
+		return "", true
+	}
+	col := schema.GetColumn(column)
+	if col == nil {
+		return "", false
+	}
+	switch col.Type {
+	case catalog.TypeInt, catalog.TypeFloat, catalog.TypeText:
+	default:
+		return "", false
+	}
+	if schema.Clustered() {
+		// The tree holds NULLs too, sorted last, so even MIN and MAX need
+		// the column NOT NULL.
+		if column == schema.ClusterKey[0] && col.NotNull {
+			return "", true
+		}
+	} else if col.PrimaryKey {
+		return "", true
+	}
+	if !col.NotNull && !col.PrimaryKey && !allowNull {
+		return "", false
+	}
+
+	for _, idx := range cat.GetTableIndexes(name) {
+		if keys := idx.KeyColumns(); len(keys) == 1 && keys[0] == column && hint.Allows(idx.Name) {
+			return idx.Name, true
+		}
+	}
+	return "", false
+}
+
 func (p *Planner) planSort(orderBy []*parser.OrderItem, input PlanNode) *SortPlan {
 	inputRows := p.estimateRows(input)
 
@@ -469,7 +1586,22 @@ func (p *Planner) planSort(orderBy []*parser.OrderItem, input PlanNode) *SortPla
 	}
 }
 
-func (p *Planner) planGroupBy(groupBy []string, having *parser.WhereClause, input PlanNode) (*GroupByPlan, error) {
+// maxTopK is the largest LIMIT, plus OFFSET, that sorts with a bounded heap
+// rather than sorting the whole input.
+const maxTopK = 10000
+
+// planTopK turns sort into a top-K sort when the limit above it only needs
+// a few of its first rows.
+func (p *Planner) planTopK(sort *SortPlan, limit *LimitPlan) {
+	k := limit.Count + limit.Offset
+	if limit.Count < 0 || k == 0 || k > maxTopK {
+		return
+	}
+	sort.Limit = k
+	sort.EstCost = sort.Input.Cost() + p.estimateRows(sort.Input)*math.Log2(float64(k)+1)*0.1
+}
+
+func (p *Planner) planGroupBy(groupBy, aggregates []string, having *parser.WhereClause, input PlanNode) (*GroupByPlan, error) {
 	inputRows := p.estimateRows(input)
 
 	groupRows := int(inputRows / 10)
@@ -479,29 +1611,22 @@ func (p *Planner) planGroupBy(groupBy []string, having *parser.WhereClause, inpu
 
 	groupCost := input.Cost() + inputRows*1.5
 
-	plan := &GroupByPlan{
-		Columns: groupBy,
-		Input:   input,
-		EstRows: groupRows,
-		EstCost: groupCost,
+	if len(groupBy) == 0 {
+		groupRows = 1
 	}
 
-	if having != nil && len(having.Conditions) > 0 {
-		conditions := make([]Condition, len(having.Conditions))
-		for i, c := range having.Conditions {
-			conditions[i] = Condition{
-				Column:   c.Column,
-				Operator: c.Operator,
-				Value:    c.Value,
-			}
-		}
+	plan := &GroupByPlan{
+		Columns:    groupBy,
+		Aggregates: aggregates,
+		Input:      input,
+		EstRows:    groupRows,
+		EstCost:    groupCost,
+	}
 
-		selectivity := p.estimateSelectivity(conditions)
-		plan.EstRows = int(float64(groupRows) * selectivity)
-		plan.Having = &FilterPlan{
-			Conditions:  conditions,
-			Selectivity: selectivity,
-		}
+	if having != nil && (len(having.Conditions) > 0 || len(having.Predicates) > 0) {
+		plan.Having = planFilter(having)
+		plan.Having.Selectivity = p.estimateSelectivity(plan.Having.Conditions) * predicateSelectivity(plan.Having.Predicates)
+		plan.EstRows = int(float64(groupRows) * plan.Having.Selectivity)
 	}
 
 	return plan, nil
@@ -513,8 +1638,12 @@ func (p *Planner) estimateRows(plan PlanNode) float64 {
 		return float64(n.EstRows)
 	case *JoinPlan:
 		return float64(n.EstRows)
+	case *SemiJoinPlan:
+		return float64(n.EstRows)
 	case *GroupByPlan:
 		return float64(n.EstRows)
+	case *IndexAggregatePlan:
+		return 1
 	case *ProjectPlan:
 		return p.estimateRows(n.Input)
 	case *SortPlan:
@@ -526,20 +1655,113 @@ func (p *Planner) estimateRows(plan PlanNode) float64 {
 	}
 }
 
-func (p *Planner) findBestIndex(stats *TableStats, conditions []Condition) *IndexInfo {
-	var bestIndex *IndexInfo
+// chooseAccessPath picks the index to read a table through for conditions,
+// or nil for a full scan, and returns every path it weighed. A unique index
+// matching a condition wins outright, since it finds at most one row per
+// value; otherwise the cheapest path wins, an index on a tie. USE INDEX
+// keeps the full scan out of the running while a named index fits.
+func (p *Planner) chooseAccessPath(stats *TableStats, conditions []Condition, hint *parser.IndexHint) (*IndexInfo, []Alternative) {
+	names := make([]string, 0, len(stats.Indexes))
+	for name := range stats.Indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	full := Alternative{
+		Path:    string(FullScan),
+		EstRows: int(float64(stats.RowCount) * p.estimateSelectivity(conditions)),
+		EstCost: float64(stats.RowCount) * p.costs.SeqRowCost,
+		Costed:  true,
+	}
+	alternatives := []Alternative{full}
+	var usable []*IndexInfo
+	var positions []int
+	for _, name := range names {
+		idx := stats.Indexes[name]
+		if !indexMatches(idx, conditions) {
+			continue
+		}
+		alt := Alternative{Path: indexPath(idx)}
+		if !hint.Allows(name) {
+			alt.Reason = "ruled out by USE INDEX"
+			if hint.Ignore {
+				alt.Reason = "ruled out by IGNORE INDEX"
+			}
+			alternatives = append(alternatives, alt)
+			continue
+		}
+		alt.EstRows = p.indexRows(stats, idx)
+		alt.EstCost = float64(alt.EstRows) * p.costs.IndexProbeCost
+		alt.Costed = true
+		usable = append(usable, idx)
+		positions = append(positions, len(alternatives))
+		alternatives = append(alternatives, alt)
+	}
+
+	best := -1
+	reason := "lowest cost"
+	for i, idx := range usable {
+		if idx.Unique {
+			best = i
+			reason = "unique index, at most one row per value"
+			break
+		}
+	}
+	if best < 0 {
+		bestCost := full.EstCost
+		if hint != nil && !hint.Ignore && len(usable) > 0 {
+			bestCost = math.Inf(1)
+			alternatives[0].Reason = "ruled out by USE INDEX"
+		}
+		for i := range usable {
+			cost := alternatives[positions[i]].EstCost
+			if cost < bestCost || (best < 0 && cost == bestCost) {
+				best = i
+				bestCost = cost
+			}
+		}
+	}
+
+	if best < 0 {
+		alternatives[0].Chosen = true
+		alternatives[0].Reason = reason
+		if len(alternatives) == 1 {
+			alternatives[0].Reason = "no index fits the conditions"
+		} else if len(usable) == 0 {
+			alternatives[0].Reason = "the only path the index hint leaves"
+		}
+		return nil, alternatives
+	}
+	alternatives[positions[best]].Chosen = true
+	alternatives[positions[best]].Reason = reason
+	return usable[best], alternatives
+}
+
+// indexMatches reports whether idx covers a column compared with a literal
+// in conditions.
+func indexMatches(idx *IndexInfo, conditions []Condition) bool {
 	for _, cond := range conditions {
-		for _, idx := range stats.Indexes {
-			for _, col := range idx.Columns {
-				if col == cond.Column {
-					if bestIndex == nil || idx.Unique {
-						bestIndex = idx
-					}
-				}
+		if !cond.indexable() {
+			continue
+		}
+		for _, col := range idx.Columns {
+			if col == cond.Column {
+				return true
 			}
 		}
 	}
-	return bestIndex
+	return false
+}
+
+func indexPath(idx *IndexInfo) string {
+	if idx.Unique {
+		return fmt.Sprintf("%s(%s)", UniqueIndexScan, idx.Name)
+	}
+	return fmt.Sprintf("%s(%s)", IndexScan, idx.Name)
+}
+
+func (p *Planner) indexRows(stats *TableStats, idx *IndexInfo) int {
+	return int(float64(stats.RowCount) * idx.Selectivity)
 }
 
 func (p *Planner) estimateSelectivity(conditions []Condition) float64 {
@@ -557,6 +1779,9 @@ func (p *Planner) estimateSelectivity(conditions []Condition) float64 {
 }
 
 func (p *Planner) planInsert(stmt *parser.InsertStmt) (PlanNode, error) {
+	if err := checkInsertValues(stmt.Values); err != nil {
+		return nil, err
+	}
 	stats, ok := p.stats[stmt.Table]
 	baseCost := float64(len(stmt.Values)) * 1.0
 
@@ -574,28 +1799,74 @@ func (p *Planner) planInsert(stmt *parser.InsertStmt) (PlanNode, error) {
 }
 
 func (p *Planner) planDelete(stmt *parser.DeleteStmt) (PlanNode, error) {
+	if err := p.checkTableColumns(stmt.Table, stmt.Where, stmt.OrderBy, nil); err != nil {
+		return nil, err
+	}
 	scan, err := p.planScan(stmt.Table, stmt.Where)
 	if err != nil {
 		return nil, err
 	}
+	orderBy, limit, err := planRowLimit("DELETE", scan, stmt.OrderBy, stmt.Limit)
+	if err != nil {
+		return nil, err
+	}
 
-	deleteCost := scan.Cost() + float64(scan.EstRows)*2.0
+	rows := changedRows(scan, limit)
+	deleteCost := scan.Cost() + float64(rows)*2.0
 	stats, ok := p.stats[stmt.Table]
 	if ok {
-		deleteCost += float64(scan.EstRows) * float64(len(stats.Indexes)) * 0.5
+		deleteCost += float64(rows) * float64(len(stats.Indexes)) * 0.5
 	}
 
 	return &DeletePlan{
 		Scan:    scan,
+		OrderBy: orderBy,
+		Limit:   limit,
 		EstCost: deleteCost,
 	}, nil
 }
 
+// planRowLimit checks the ORDER BY and LIMIT of an UPDATE or DELETE and
+// returns the number of rows it may change, -1 for all of them. An OFFSET
+// is refused: skipping rows that match is no use for a change.
+func planRowLimit(verb string, scan *ScanPlan, orderBy []*parser.OrderItem, limit *parser.LimitClause) ([]OrderItem, int, error) {
+	items := make([]OrderItem, len(orderBy))
+	for i, item := range orderBy {
+		items[i] = OrderItem{Column: item.Column, Direction: item.Direction}
+	}
+	if limit == nil {
+		return items, -1, nil
+	}
+	if limit.Offset != "" {
+		return nil, 0, sqlerr.New(sqlerr.FeatureNotSupported, "OFFSET is not supported in %s", verb)
+	}
+	plan, err := planLimit(limit, scan)
+	if err != nil {
+		return nil, 0, err
+	}
+	return items, plan.Count, nil
+}
+
+// changedRows estimates the rows an UPDATE or DELETE changes.
+func changedRows(scan *ScanPlan, limit int) int {
+	if limit >= 0 && limit < scan.EstRows {
+		return limit
+	}
+	return scan.EstRows
+}
+
 func (p *Planner) planUpdate(stmt *parser.UpdateStmt) (PlanNode, error) {
+	if err := p.checkTableColumns(stmt.Table, stmt.Where, stmt.OrderBy, stmt.Assignments); err != nil {
+		return nil, err
+	}
 	scan, err := p.planScan(stmt.Table, stmt.Where)
 	if err != nil {
 		return nil, err
 	}
+	orderBy, limit, err := planRowLimit("UPDATE", scan, stmt.OrderBy, stmt.Limit)
+	if err != nil {
+		return nil, err
+	}
 
 	engineAssignments := make([]Assignment, len(stmt.Assignments))
 	for i, a := range stmt.Assignments {
@@ -605,11 +1876,13 @@ func (p *Planner) planUpdate(stmt *parser.UpdateStmt) (PlanNode, error) {
 		}
 	}
 
-	updateCost := scan.Cost() + float64(scan.EstRows)*3.0
+	updateCost := scan.Cost() + float64(changedRows(scan, limit))*3.0
 	return &UpdatePlan{
 		Table:       stmt.Table,
 		Assignments: engineAssignments,
 		Scan:        scan,
+		OrderBy:     orderBy,
+		Limit:       limit,
 		EstCost:     updateCost,
 	}, nil
 }
@@ -628,10 +1901,18 @@ func (p *Planner) planCreateTable(stmt *parser.CreateTableStmt) (PlanNode, error
 		}
 	}
 
+	constraintCost += float64(len(stmt.Unique)) * 3.0
+
 	return &CreateTablePlan{
-		Table:   stmt.Table,
-		Columns: stmt.Columns,
-		EstCost: baseCost + columnCost + constraintCost,
+		Table:      stmt.Table,
+		Columns:    stmt.Columns,
+		Unique:     stmt.Unique,
+		Engine:     stmt.Engine,
+		ClusterKey: stmt.ClusterKey,
+		FillFactor: stmt.FillFactor,
+
+		BloomFilter: stmt.BloomFilter,
+		EstCost:     baseCost + columnCost + constraintCost,
 	}, nil
 }
 
@@ -645,11 +1926,48 @@ func (p *Planner) planCreateIndex(stmt *parser.CreateIndexStmt) (PlanNode, error
 	}
 
 	return &CreateIndexPlan{
-		IndexName: stmt.IndexName,
-		TableName: stmt.TableName,
-		Columns:   stmt.Columns,
-		Unique:    stmt.Unique,
-		EstCost:   baseCost,
+		IndexName:    stmt.IndexName,
+		TableName:    stmt.TableName,
+		Columns:      stmt.Columns,
+		Unique:       stmt.Unique,
+		Concurrently: stmt.Concurrently,
+		FillFactor:   stmt.FillFactor,
+		EstCost:      baseCost,
+	}, nil
+}
+
+// indexTable names the table an index is on, qualified like the index, so
+// that dropping or renaming it can be checked against the privileges on that
+// table. A missing index is "" when ifExists is set.
+func (p *Planner) indexTable(name string, ifExists bool) (string, error) {
+	if p.catalog == nil {
+		return "", fmt.Errorf("no catalog to look up index '%s' in", name)
+	}
+	cat, indexName, err := resolveTable(p.catalog, p.attached, name)
+	if err != nil {
+		return "", err
+	}
+	index, err := cat.GetIndex(indexName)
+	if err != nil {
+		if ifExists && sqlerr.CodeOf(err) == sqlerr.UndefinedObject {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(name, indexName) + index.TableName, nil
+}
+
+func (p *Planner) planAnalyze(stmt *parser.AnalyzeStmt) (PlanNode, error) {
+	cost := 0.0
+	for name, stats := range p.stats {
+		if stmt.Table == "" || stmt.Table == name {
+			cost += float64(stats.RowCount) * 1.0
+		}
+	}
+
+	return &AnalyzePlan{
+		Table:   stmt.Table,
+		EstCost: cost,
 	}, nil
 }
 
@@ -657,3 +1975,25 @@ func Explain(plan PlanNode) string {
 	return fmt.Sprintf("Execution Plan:\n%s\nTotal Cost: %.2f",
 		plan.String(), plan.Cost())
 }
+
+// ExplainAlternatives lists, for each table plan reads, the access paths
+// the planner weighed, marking the chosen one with an asterisk.
+func ExplainAlternatives(plan PlanNode) string {
+	var b strings.Builder
+	b.WriteString("Alternatives:")
+	for _, scan := range planScans(plan) {
+		name := scan.Table
+		if scan.Alias != "" {
+			name += " AS " + scan.Alias
+		}
+		fmt.Fprintf(&b, "\n  %s:", name)
+		for _, alt := range scan.Alternatives {
+			mark := " "
+			if alt.Chosen {
+				mark = "*"
+			}
+			fmt.Fprintf(&b, "\n    %s %s", mark, alt)
+		}
+	}
+	return b.String()
+}
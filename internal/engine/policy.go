@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+type CreatePolicyPlan struct {
+	Policy *catalog.Policy
+}
+
+func (c *CreatePolicyPlan) Type() string  { return "CreatePolicy" }
+func (c *CreatePolicyPlan) Cost() float64 { return 0 }
+func (c *CreatePolicyPlan) String() string {
+	return fmt.Sprintf("CreatePolicy(%s ON %s)", c.Policy.Name, c.Policy.Table)
+}
+
+type DropPolicyPlan struct {
+	Name  string
+	Table string
+}
+
+func (d *DropPolicyPlan) Type() string  { return "DropPolicy" }
+func (d *DropPolicyPlan) Cost() float64 { return 0 }
+func (d *DropPolicyPlan) String() string {
+	return fmt.Sprintf("DropPolicy(%s ON %s)", d.Name, d.Table)
+}
+
+func executeCreatePolicy(e *Engine, plan *CreatePolicyPlan) (string, error) {
+	cat, table, err := e.catalogFor(plan.Policy.Table)
+	if err != nil {
+		return "", err
+	}
+
+	if plan.Policy.User != "" {
+		if _, err := e.catalog.GetUser(plan.Policy.User); err != nil {
+			return "", err
+		}
+	}
+
+	policy := *plan.Policy
+	policy.Table = table
+	if err := cat.CreatePolicy(&policy); err != nil {
+		return "", fmt.Errorf("failed to create policy: %w", err)
+	}
+
+	return fmt.Sprintf("Policy '%s' created on %s", policy.Name, plan.Policy.Table), nil
+}
+
+func executeDropPolicy(e *Engine, plan *DropPolicyPlan) (string, error) {
+	cat, table, err := e.catalogFor(plan.Table)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cat.DropPolicy(table, plan.Name); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Policy '%s' dropped from %s", plan.Name, plan.Table), nil
+}
+
+// policyFilter gathers the conditions of every policy on table that applies
+// to the logged-in user. It is nil for the owner and for unrestricted tables.
+func (e *Engine) policyFilter(table string) (*FilterPlan, error) {
+	if e.user == "" {
+		return nil, nil
+	}
+
+	cat, name, err := e.catalogFor(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var conditions []Condition
+	for _, policy := range cat.TablePolicies(name) {
+		if !policy.AppliesTo(e.user) {
+			continue
+		}
+		for _, cond := range policy.Conditions {
+			conditions = append(conditions, Condition{
+				Column:   cond.Column,
+				Operator: cond.Operator,
+				Value:    parser.ValueOf(cond.Value),
+			})
+		}
+	}
+
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+	return &FilterPlan{Conditions: conditions, Selectivity: 1.0}, nil
+}
+
+// applyPolicies narrows every table scan in plan to the rows the user's
+// policies allow, so SELECT, UPDATE and DELETE never reach other rows. It
+// returns a narrowed copy and leaves plan as it is, since plan may be a
+// cached one that other users run too.
+func (e *Engine) applyPolicies(plan PlanNode) (PlanNode, error) {
+	if e.user == "" {
+		return plan, nil
+	}
+
+	var err error
+	switch p := plan.(type) {
+	case *ScanPlan:
+		return e.restrictScan(p)
+	case *JoinPlan:
+		c := *p
+		if c.Left, err = e.applyPolicies(p.Left); err != nil {
+			return nil, err
+		}
+		c.Right, err = e.restrictScan(p.Right)
+		return &c, err
+	case *SemiJoinPlan:
+		c := *p
+		if c.Input, err = e.applyPolicies(p.Input); err != nil {
+			return nil, err
+		}
+		c.Subquery, err = e.applyPolicies(p.Subquery)
+		return &c, err
+	case *ProjectPlan:
+		c := *p
+		c.Input, err = e.applyPolicies(p.Input)
+		return &c, err
+	case *GroupByPlan:
+		c := *p
+		c.Input, err = e.applyPolicies(p.Input)
+		return &c, err
+	case *IndexAggregatePlan:
+		c := *p
+		c.Scan, err = e.restrictScan(p.Scan)
+		return &c, err
+	case *SortPlan:
+		c := *p
+		c.Input, err = e.applyPolicies(p.Input)
+		return &c, err
+	case *LimitPlan:
+		c := *p
+		c.Input, err = e.applyPolicies(p.Input)
+		return &c, err
+	case *ExplainPlan:
+		c := *p
+		c.Input, err = e.applyPolicies(p.Input)
+		return &c, err
+	case *UpdatePlan:
+		c := *p
+		c.Scan, err = e.restrictScan(p.Scan)
+		return &c, err
+	case *DeletePlan:
+		c := *p
+		c.Scan, err = e.restrictScan(p.Scan)
+		return &c, err
+	}
+	return plan, nil
+}
+
+// restrictScan returns scan with the user's policies on its table added to
+// its filter.
+func (e *Engine) restrictScan(scan *ScanPlan) (*ScanPlan, error) {
+	if scan.Function {
+		return scan, nil
+	}
+	policy, err := e.policyFilter(scan.Table)
+	if err != nil || policy == nil {
+		return scan, err
+	}
+
+	restricted := *scan
+	if scan.Filter != nil {
+		filter := *scan.Filter
+		filter.Conditions = append(append([]Condition(nil), scan.Filter.Conditions...), policy.Conditions...)
+		policy = &filter
+	}
+	restricted.Filter = policy
+	return &restricted, nil
+}
+
+// checkPolicies rejects a row the user would not be allowed to see, so an
+// INSERT or UPDATE cannot move data out of the user's reach.
+func (e *Engine) checkPolicies(table string, row *catalog.Row) error {
+	policy, err := e.policyFilter(table)
+	if err != nil || policy == nil {
+		return err
+	}
+	if !matchesFilter(e, row, policy) {
+		return sqlerr.New(sqlerr.InsufficientPrivilege, "row violates row-level security policy on %s", table)
+	}
+	return nil
+}
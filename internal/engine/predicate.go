@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+)
+
+// Predicate is an OR of a filter, kept apart from its Conditions since no
+// index can look it up: the AND or OR of Args, or the single condition
+// Cond when Op is empty, as in parser.Predicate.
+type Predicate struct {
+	Op   string
+	Args []Predicate
+	Cond Condition
+}
+
+func (p Predicate) String() string {
+	if p.Op == "" {
+		return p.Cond.String()
+	}
+	args := make([]string, len(p.Args))
+	for i, arg := range p.Args {
+		args[i] = arg.String()
+	}
+	return "(" + strings.Join(args, " "+p.Op+" ") + ")"
+}
+
+// holds evaluates p, leaf telling whether each of its conditions holds.
+func (p *Predicate) holds(leaf func(Condition) bool) bool {
+	switch p.Op {
+	case "":
+		return leaf(p.Cond)
+	case "AND":
+		for i := range p.Args {
+			if !p.Args[i].holds(leaf) {
+				return false
+			}
+		}
+		return true
+	default:
+		for i := range p.Args {
+			if p.Args[i].holds(leaf) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// conditions appends the conditions of p to conds.
+func (p *Predicate) conditions(conds []Condition) []Condition {
+	if p.Op == "" {
+		return append(conds, p.Cond)
+	}
+	for i := range p.Args {
+		conds = p.Args[i].conditions(conds)
+	}
+	return conds
+}
+
+// planPredicates converts preds, each condition by convert.
+func planPredicates(preds []parser.Predicate, convert func(parser.Condition) Condition) []Predicate {
+	if len(preds) == 0 {
+		return nil
+	}
+	planned := make([]Predicate, len(preds))
+	for i, pred := range preds {
+		planned[i] = Predicate{Op: pred.Op, Args: planPredicates(pred.Args, convert)}
+		if pred.Op == "" {
+			planned[i].Cond = convert(pred.Cond)
+		}
+	}
+	return planned
+}
+
+// planFilter converts the conditions of where as they are written, for
+// rows whose columns keep their qualified names.
+func planFilter(where *parser.WhereClause) *FilterPlan {
+	filter := &FilterPlan{Predicates: planPredicates(where.Predicates, plainCondition)}
+	for _, c := range where.Conditions {
+		filter.Conditions = append(filter.Conditions, plainCondition(c))
+	}
+	return filter
+}
+
+// plainCondition converts c as it is written.
+func plainCondition(c parser.Condition) Condition {
+	return Condition{Column: c.Column, Expr: c.Expr, Operator: c.Operator, Value: c.Value}
+}
+
+// predicateSelectivity estimates the fraction of rows that match all of
+// preds, each condition matching 10% of them as in estimateSelectivity.
+func predicateSelectivity(preds []Predicate) float64 {
+	selectivity := 1.0
+	for i := range preds {
+		selectivity *= preds[i].selectivity()
+	}
+	return selectivity
+}
+
+func (p *Predicate) selectivity() float64 {
+	switch p.Op {
+	case "":
+		return 0.1
+	case "AND":
+		return predicateSelectivity(p.Args)
+	default:
+		miss := 1.0
+		for i := range p.Args {
+			miss *= 1 - p.Args[i].selectivity()
+		}
+		return 1 - miss
+	}
+}
+
+// empty reports whether f, which may be nil, lets every row through.
+func (f *FilterPlan) empty() bool {
+	return f == nil || len(f.Conditions) == 0 && len(f.Predicates) == 0
+}
+
+// allConditions returns the conditions of f and of its predicates.
+func (f *FilterPlan) allConditions() []Condition {
+	conds := append([]Condition(nil), f.Conditions...)
+	for i := range f.Predicates {
+		conds = f.Predicates[i].conditions(conds)
+	}
+	return conds
+}
+
+// predicatesHold reports whether every predicate of f holds, leaf telling
+// whether each condition does.
+func (f *FilterPlan) predicatesHold(leaf func(Condition) bool) bool {
+	for i := range f.Predicates {
+		if !f.Predicates[i].holds(leaf) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *FilterPlan) String() string {
+	terms := make([]string, 0, len(f.Conditions)+len(f.Predicates))
+	for _, cond := range f.Conditions {
+		terms = append(terms, cond.String())
+	}
+	for _, pred := range f.Predicates {
+		terms = append(terms, pred.String())
+	}
+	return "[" + strings.Join(terms, " ") + "]"
+}
@@ -0,0 +1,460 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// maxCallDepth bounds how deeply procedures may call one another.
+const maxCallDepth = 32
+
+type CreateProcedurePlan struct {
+	Procedure *catalog.Procedure
+}
+
+func (c *CreateProcedurePlan) Type() string  { return "CreateProcedure" }
+func (c *CreateProcedurePlan) Cost() float64 { return 0 }
+func (c *CreateProcedurePlan) String() string {
+	return fmt.Sprintf("CreateProcedure(%s)", c.Procedure.Name)
+}
+
+type DropProcedurePlan struct {
+	Name string
+}
+
+func (d *DropProcedurePlan) Type() string  { return "DropProcedure" }
+func (d *DropProcedurePlan) Cost() float64 { return 0 }
+func (d *DropProcedurePlan) String() string {
+	return fmt.Sprintf("DropProcedure(%s)", d.Name)
+}
+
+// CallPlan runs a stored procedure. The call needs no privileges itself:
+// each statement the procedure runs is checked as the caller's own.
+type CallPlan struct {
+	Name string
+	Args []parser.Value
+}
+
+func (c *CallPlan) Type() string  { return "Call" }
+func (c *CallPlan) Cost() float64 { return 0 }
+func (c *CallPlan) String() string {
+	return (&parser.CallStmt{Name: c.Name, Args: c.Args}).String()
+}
+
+// checkProcedure checks the variables of a procedure when it is created:
+// their types, that none is declared twice and that SET only assigns
+// declared ones. Variables are visible from anywhere in the body, and the
+// row of a FOR loop inside the loop.
+func checkProcedure(stmt *parser.CreateProcedureStmt) error {
+	vars := make(map[string]bool)
+	declare := func(name, typ string) error {
+		if vars[name] {
+			return sqlerr.New(sqlerr.DuplicateObject, "variable '%s' is declared more than once", name)
+		}
+		if _, ok := catalog.ParseColumnType(typ); !ok {
+			return sqlerr.New(sqlerr.DatatypeMismatch, "unknown type '%s' for variable '%s'", typ, name)
+		}
+		vars[name] = true
+		return nil
+	}
+	for _, param := range stmt.Params {
+		if param.PrimaryKey || param.Unique || param.NotNull || param.AutoIncrement {
+			return sqlerr.New(sqlerr.SyntaxError, "parameter '%s' cannot have constraints", param.Name)
+		}
+		if err := declare(param.Name, param.Type); err != nil {
+			return err
+		}
+	}
+
+	var walk func(body []parser.ProcStmt) error
+	walk = func(body []parser.ProcStmt) error {
+		for _, s := range body {
+			switch s := s.(type) {
+			case *parser.DeclareStmt:
+				if err := declare(s.Name, s.Type); err != nil {
+					return err
+				}
+			case *parser.IfStmt:
+				for _, branch := range s.Branches {
+					if err := walk(branch.Body); err != nil {
+						return err
+					}
+				}
+				if err := walk(s.Else); err != nil {
+					return err
+				}
+			case *parser.ForStmt:
+				if err := walk(s.Body); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(stmt.Body); err != nil {
+		return err
+	}
+
+	var assigns func(body []parser.ProcStmt) error
+	assigns = func(body []parser.ProcStmt) error {
+		for _, s := range body {
+			switch s := s.(type) {
+			case *parser.AssignStmt:
+				if !vars[s.Name] {
+					return sqlerr.New(sqlerr.UndefinedObject, "variable '%s' is not declared", s.Name)
+				}
+			case *parser.IfStmt:
+				for _, branch := range s.Branches {
+					if err := assigns(branch.Body); err != nil {
+						return err
+					}
+				}
+				if err := assigns(s.Else); err != nil {
+					return err
+				}
+			case *parser.ForStmt:
+				if err := assigns(s.Body); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return assigns(stmt.Body)
+}
+
+func executeCreateProcedure(e *Engine, plan *CreateProcedurePlan) (string, error) {
+	if err := e.catalog.CreateProcedure(plan.Procedure); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Procedure '%s' created", plan.Procedure.Name), nil
+}
+
+func executeDropProcedure(e *Engine, plan *DropProcedurePlan) (string, error) {
+	if err := e.catalog.DropProcedure(plan.Name); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Procedure '%s' dropped", plan.Name), nil
+}
+
+// executeCall runs a procedure. Its statements run in one batch, so that
+// if any fails none of their changes are kept, unless the database is
+// read-only. Their results are discarded.
+func executeCall(e *Engine, plan *CallPlan) (string, error) {
+	if e.callDepth >= maxCallDepth {
+		return "", fmt.Errorf("procedures nested more than %d deep", maxCallDepth)
+	}
+
+	proc, err := e.catalog.GetProcedure(plan.Name)
+	if err != nil {
+		return "", err
+	}
+	node, err := parser.Parse(proc.Source)
+	if err != nil {
+		return "", fmt.Errorf("procedure '%s': %w", plan.Name, err)
+	}
+	stmt, ok := node.(*parser.CreateProcedureStmt)
+	if !ok {
+		return "", fmt.Errorf("procedure '%s' is not a CREATE PROCEDURE statement", plan.Name)
+	}
+	if len(plan.Args) != len(stmt.Params) {
+		return "", sqlerr.New(sqlerr.UndefinedFunction, "procedure '%s' takes %d arguments, got %d", plan.Name, len(stmt.Params), len(plan.Args))
+	}
+
+	frame := &procFrame{vars: make(map[string]*procVar), rows: make(map[string]*procRow)}
+	for i, param := range stmt.Params {
+		colType, _ := catalog.ParseColumnType(param.Type)
+		value, err := literalValue(plan.Args[i], colType)
+		if err != nil {
+			return "", fmt.Errorf("invalid value for parameter '%s': %w", param.Name, err)
+		}
+		frame.vars[param.Name] = &procVar{colType: colType, value: value}
+	}
+
+	output, cursor := e.output, e.cursor
+	e.output, e.cursor = nil, nil
+	e.callDepth++
+	defer func() {
+		e.output, e.cursor = output, cursor
+		e.callDepth--
+	}()
+
+	run := func() error {
+		return e.runProcBody(frame, stmt.Body)
+	}
+	if e.storage.Pager.ReadOnly() {
+		err = run()
+	} else {
+		err = e.catalog.Batch(run)
+	}
+	if err != nil {
+		if e.callDepth > 1 {
+			// The outermost call names the procedure that was called.
+			return "", err
+		}
+		return "", fmt.Errorf("procedure '%s': %w", plan.Name, err)
+	}
+	e.rowCount = 0
+	return fmt.Sprintf("Procedure '%s' completed", plan.Name), nil
+}
+
+// procFrame holds the variables of a running procedure, and the current
+// row of each FOR loop it is in.
+type procFrame struct {
+	vars map[string]*procVar
+	rows map[string]*procRow
+}
+
+type procVar struct {
+	colType catalog.ColumnType
+	value   interface{}
+}
+
+type procRow struct {
+	rs  *ResultSet
+	row []interface{}
+}
+
+// get returns the value of a variable, or of a column of a loop's row
+// named as row.column.
+func (f *procFrame) get(name string) (interface{}, bool) {
+	if v, ok := f.vars[name]; ok {
+		return v.value, true
+	}
+	if loop, column, ok := strings.Cut(name, "."); ok {
+		if r, ok := f.rows[loop]; ok {
+			return r.rs.getter(r.row)(column)
+		}
+	}
+	return nil, false
+}
+
+func (e *Engine) runProcBody(f *procFrame, body []parser.ProcStmt) error {
+	for _, stmt := range body {
+		if err := e.checkDeadline(); err != nil {
+			return err
+		}
+		if err := e.runProcStmt(f, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Engine) runProcStmt(f *procFrame, stmt parser.ProcStmt) error {
+	switch s := stmt.(type) {
+	case *parser.DeclareStmt:
+		colType, _ := catalog.ParseColumnType(s.Type)
+		v := &procVar{colType: colType}
+		f.vars[s.Name] = v
+		if s.Default != nil {
+			return f.assign(s.Name, v, *s.Default)
+		}
+		return nil
+
+	case *parser.AssignStmt:
+		v, ok := f.vars[s.Name]
+		if !ok {
+			return sqlerr.New(sqlerr.UndefinedObject, "variable '%s' is not declared", s.Name)
+		}
+		if s.Query == "" {
+			return f.assign(s.Name, v, s.Value)
+		}
+		rs, err := e.procQuery(f, s.Query)
+		if err != nil {
+			return err
+		}
+		var value interface{}
+		if len(rs.Rows) > 0 && len(rs.Schema) > 0 {
+			value = rs.Rows[0][0]
+		}
+		if v.value, err = catalog.CoerceValue(value, v.colType); err != nil {
+			return fmt.Errorf("invalid value for variable '%s': %w", s.Name, err)
+		}
+		return nil
+
+	case *parser.IfStmt:
+		for _, branch := range s.Branches {
+			holds, err := e.procConditions(f, branch.Conditions)
+			if err != nil {
+				return err
+			}
+			if holds {
+				return e.runProcBody(f, branch.Body)
+			}
+		}
+		return e.runProcBody(f, s.Else)
+
+	case *parser.ForStmt:
+		rs, err := e.procQuery(f, s.Query)
+		if err != nil {
+			return err
+		}
+		saved, shadows := f.rows[s.Var]
+		defer func() {
+			if shadows {
+				f.rows[s.Var] = saved
+			} else {
+				delete(f.rows, s.Var)
+			}
+		}()
+		for _, row := range rs.Rows {
+			f.rows[s.Var] = &procRow{rs: rs, row: row}
+			if err := e.runProcBody(f, s.Body); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *parser.SQLStmt:
+		node, err := f.parse(s.Source)
+		if err != nil {
+			return err
+		}
+		_, err = e.runStatement(node)
+		return err
+	}
+	return fmt.Errorf("unsupported procedure statement: %s", stmt)
+}
+
+func (f *procFrame) assign(name string, v *procVar, value parser.Value) error {
+	var err error
+	if value.Kind == parser.Identifier {
+		current, ok := f.get(value.Text)
+		if !ok {
+			return sqlerr.New(sqlerr.UndefinedObject, "variable '%s' is not declared", value.Text)
+		}
+		v.value, err = catalog.CoerceValue(current, v.colType)
+	} else {
+		v.value, err = literalValue(value, v.colType)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for variable '%s': %w", name, err)
+	}
+	return nil
+}
+
+// procConditions reports whether every one of conditions holds.
+func (e *Engine) procConditions(f *procFrame, conditions []parser.ProcCondition) (bool, error) {
+	for _, c := range conditions {
+		if c.Query != "" {
+			rs, err := e.procQuery(f, c.Query)
+			if err != nil {
+				return false, err
+			}
+			if (len(rs.Rows) > 0) != (c.Operator == "EXISTS") {
+				return false, nil
+			}
+			continue
+		}
+
+		cond := Condition{Column: c.Column, Expr: c.Expr, Operator: c.Operator, Value: c.Value}
+		if cond.Expr != nil {
+			if !matchesExprCondition(e, f.get, cond) {
+				return false, nil
+			}
+			continue
+		}
+		value, ok := f.get(cond.Column)
+		if !ok {
+			return false, sqlerr.New(sqlerr.UndefinedObject, "variable '%s' is not declared", cond.Column)
+		}
+		other, ok := conditionValue(cond, f.get)
+		if !ok || !evaluateConditionMap(value, cond.Operator, other) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// procQuery runs a query of a procedure and returns its rows.
+func (e *Engine) procQuery(f *procFrame, query string) (*ResultSet, error) {
+	node, err := f.parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := node.(*parser.SelectStmt); !ok {
+		return nil, fmt.Errorf("expected SELECT, got %s", query)
+	}
+	plan, err := e.plan(node)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.authorize(plan); err != nil {
+		return nil, err
+	}
+	if plan, err = e.applyPolicies(plan); err != nil {
+		return nil, err
+	}
+	return buildResultSet(e, plan)
+}
+
+// parse parses sql after replacing the variables in it with their values.
+// A word names a variable unless it follows a dot or is called as a
+// function; row.column names a column of a loop's row.
+func (f *procFrame) parse(sql string) (parser.Node, error) {
+	lexer := parser.NewLexer(sql)
+	var b strings.Builder
+	last := 0
+	var prev parser.Token
+	tok := lexer.NextToken()
+	for tok.Type != parser.EOF && tok.Type != parser.ILLEGAL {
+		next := lexer.NextToken()
+		if tok.Type == parser.IDENTIFIER && prev.Type != parser.DOT && next.Type != parser.LPAREN {
+			name, start, end := tok.Literal, tok.Pos, tok.Pos+len(tok.Literal)
+			if _, ok := f.rows[name]; ok && next.Type == parser.DOT {
+				if column := lexer.NextToken(); column.Type == parser.IDENTIFIER {
+					name, end = name+"."+column.Literal, column.Pos+len(column.Literal)
+					tok, next = column, lexer.NextToken()
+				}
+			}
+			if value, ok := f.get(name); ok {
+				literal, err := sqlLiteral(value)
+				if err != nil {
+					return nil, fmt.Errorf("cannot use %s in a statement: %w", name, err)
+				}
+				b.WriteString(sql[last:start])
+				b.WriteString(literal)
+				last = end
+			}
+		}
+		prev, tok = tok, next
+	}
+	b.WriteString(sql[last:])
+	return parser.Parse(b.String())
+}
+
+// sqlLiteral writes v as a literal that parses back to it.
+func sqlLiteral(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "NULL", nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "", fmt.Errorf("%v has no literal", v)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case string:
+		switch {
+		case !strings.Contains(v, "'"):
+			return "'" + v + "'", nil
+		case !strings.Contains(v, `"`):
+			return `"` + v + `"`, nil
+		}
+		return "", fmt.Errorf("text containing both ' and \" has no literal")
+	}
+	return "", fmt.Errorf("unsupported value type %T", v)
+}
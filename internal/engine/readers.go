@@ -0,0 +1,39 @@
+package engine
+
+import "fmt"
+
+// beginRead starts a statement on the main and attached databases opened
+// read-only, so that it reads each as of the latest commit, and returns the
+// function that ends it. Statistics are reloaded if the schema changed
+// since the last statement, and every catalog looks for changes made
+// through other handles, so that cached plans see them.
+func (e *Engine) beginRead() (func(), error) {
+	gen := e.catalog.Generation()
+	end, err := e.catalog.BeginRead()
+	if err != nil {
+		return nil, err
+	}
+	ends := []func(){end}
+	endAll := func() {
+		for _, end := range ends {
+			end()
+		}
+	}
+	for alias, a := range e.attached {
+		end, err := a.catalog.BeginRead()
+		if err != nil {
+			endAll()
+			return nil, fmt.Errorf("failed to read %s: %w", alias, err)
+		}
+		ends = append(ends, end)
+		a.catalog.Generation()
+	}
+
+	if e.storage.Pager.ReadOnly() && e.catalog.Generation() != gen {
+		if err := e.planner.LoadStats(); err != nil {
+			endAll()
+			return nil, err
+		}
+	}
+	return endAll, nil
+}
@@ -0,0 +1,27 @@
+package engine
+
+import (
+	"regexp"
+
+	"github.com/kithinjibrian/anubisdb/internal/utils"
+)
+
+// regexps holds compiled REGEXP patterns, so that a pattern is compiled
+// once and not for every row it is tested against. Compiled patterns are
+// safe to share between sessions.
+var regexps = utils.NewLRUCache[string, *regexp.Regexp](256)
+
+// matchRegexp applies a REGEXP operator, possibly negated, to s. The match
+// may be anywhere in s unless the pattern anchors it. A pattern that does
+// not compile, which only a column's value can be, matches nothing.
+func matchRegexp(s, op, pattern string) bool {
+	re, ok := regexps.Get(pattern)
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return false
+		}
+		regexps.Put(pattern, re)
+	}
+	return re.MatchString(s) != (op == "NOT REGEXP")
+}
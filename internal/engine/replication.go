@@ -0,0 +1,264 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// Publication is a set of tables of the main database whose committed row
+// changes are replicated to subscribers, typically read models kept in other
+// databases. Only tables with a primary key can be published, since changes
+// are applied to the subscriber by key.
+type Publication struct {
+	name   string
+	source *Engine
+	tables map[string]bool
+	subs   []*Subscription
+}
+
+// Subscription keeps the published tables of a target engine in step with
+// a Publication.
+type Subscription struct {
+	pub    *Publication
+	target *Engine
+	remove func()
+
+	// err is why the subscription stopped, if it did.
+	err error
+}
+
+// Publish creates a publication of the named tables.
+func (e *Engine) Publish(name string, tables ...string) (*Publication, error) {
+	if e.user != "" {
+		return nil, sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: publications require the database owner")
+	}
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("publication %s needs at least one table", name)
+	}
+
+	p := &Publication{name: name, source: e, tables: make(map[string]bool)}
+	for _, table := range tables {
+		schema, err := e.catalog.GetTable(table)
+		if err != nil {
+			return nil, err
+		}
+		if schema.HasRowID() {
+			return nil, sqlerr.New(sqlerr.FeatureNotSupported, "table %s has no primary key; only tables with one can be published", table)
+		}
+		p.tables[table] = true
+	}
+	return p, nil
+}
+
+func (p *Publication) Name() string {
+	return p.name
+}
+
+// Tables returns the published tables in name order.
+func (p *Publication) Tables() []string {
+	tables := make([]string, 0, len(p.tables))
+	for table := range p.tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// Subscribe copies the published tables into target, creating the ones it
+// lacks, and from then on applies every change committed to them, each
+// source commit as one batch. A table target already has must have the same
+// columns and be empty. Indexes other than the primary key and UNIQUE
+// constraints are not copied, so a read model can index the tables its own
+// way. Writing to the replicated tables in target makes them diverge.
+//
+// Changes are applied on the goroutine that writes to the source. If target
+// serves sessions, each batch waits for their running statement.
+func (p *Publication) Subscribe(target *Engine) (*Subscription, error) {
+	if target == p.source {
+		return nil, fmt.Errorf("publication %s cannot subscribe its own database", p.name)
+	}
+	if target.user != "" {
+		return nil, sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: subscriptions require the owner of the target database")
+	}
+
+	for _, table := range p.Tables() {
+		if err := p.prepareTable(target, table); err != nil {
+			return nil, err
+		}
+	}
+
+	err := target.Batch(func() error {
+		for _, table := range p.Tables() {
+			if err := p.copyTable(target, table); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy publication %s: %w", p.name, err)
+	}
+
+	s := &Subscription{pub: p, target: target}
+	s.remove = p.source.catalog.OnChange(s.apply)
+	p.subs = append(p.subs, s)
+	return s, nil
+}
+
+// prepareTable creates table in target, or checks that the one there can
+// receive it.
+func (p *Publication) prepareTable(target *Engine, table string) error {
+	schema, err := p.source.catalog.GetTable(table)
+	if err != nil {
+		return err
+	}
+
+	if !target.catalog.TableExists(table) {
+		if ns, _ := catalog.SplitTableName(table); ns != "" && !target.catalog.NamespaceExists(ns) {
+			if err := target.catalog.CreateNamespace(ns); err != nil {
+				return err
+			}
+		}
+		opts := catalog.TableOptions{
+			Engine:      schema.StorageEngine(),
+			ClusterKey:  schema.ClusterKey,
+			FillFactor:  schema.FillFactor,
+			BloomFilter: schema.BloomFilter,
+		}
+		_, err := target.catalog.CreateTableWithOptions(table, opts, schema.Columns, schema.UniqueKeys...)
+		return err
+	}
+
+	existing, err := target.catalog.GetTable(table)
+	if err != nil {
+		return err
+	}
+	if len(existing.Columns) != len(schema.Columns) {
+		return fmt.Errorf("table %s in the subscriber has different columns", table)
+	}
+	for i, col := range schema.Columns {
+		other := existing.Columns[i]
+		if other.Name != col.Name || other.Type != col.Type || other.PrimaryKey != col.PrimaryKey {
+			return fmt.Errorf("table %s in the subscriber has different columns", table)
+		}
+	}
+
+	dst, err := target.catalog.LoadTable(table)
+	if err != nil {
+		return err
+	}
+	count, err := dst.Count()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("table %s in the subscriber is not empty", table)
+	}
+	return nil
+}
+
+func (p *Publication) copyTable(target *Engine, table string) error {
+	src, err := p.source.catalog.LoadTable(table)
+	if err != nil {
+		return err
+	}
+	dst, err := target.catalog.LoadTable(table)
+	if err != nil {
+		return err
+	}
+
+	rows, err := src.Scan()
+	if err != nil {
+		return err
+	}
+	values := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = rowValues(row, dst.GetSchema())
+	}
+	return dst.BulkLoad(values)
+}
+
+// Close stops every subscription of the publication.
+func (p *Publication) Close() {
+	for _, s := range append([]*Subscription(nil), p.subs...) {
+		s.Close()
+	}
+}
+
+// Close stops the subscription. The target keeps the tables and rows it has.
+func (s *Subscription) Close() {
+	s.remove()
+	subs := s.pub.subs
+	for i, other := range subs {
+		if other == s {
+			s.pub.subs = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+}
+
+// Err returns the error that stopped the subscription, or nil while it is
+// running. A change the target rejects, for example because a replicated
+// table was dropped there, stops it.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+func (s *Subscription) apply(changes []catalog.Change) {
+	var published []catalog.Change
+	for _, change := range changes {
+		if s.pub.tables[change.Table] {
+			published = append(published, change)
+		}
+	}
+	if len(published) == 0 {
+		return
+	}
+
+	if m := s.target.sessions; m != nil {
+		m.exec.Lock()
+		defer m.exec.Unlock()
+	}
+
+	tables := make(map[string]*catalog.Table)
+	err := s.target.Batch(func() error {
+		for _, change := range published {
+			table, ok := tables[change.Table]
+			if !ok {
+				var err error
+				if table, err = s.target.catalog.LoadTable(change.Table); err != nil {
+					return err
+				}
+				tables[change.Table] = table
+			}
+			if err := applyChange(table, change); err != nil {
+				return fmt.Errorf("%s on %s: %w", change.Op, change.Table, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.err = fmt.Errorf("subscription to publication %s stopped: %w", s.pub.name, err)
+		fmt.Printf("Warning: %v\n", s.err)
+		s.Close()
+	}
+}
+
+func applyChange(table *catalog.Table, change catalog.Change) error {
+	schema := table.GetSchema()
+	if change.Op == catalog.ChangeInsert {
+		return table.Insert(rowValues(change.New, schema))
+	}
+
+	key, err := catalog.GetPrimaryKeyValue(change.Old, schema)
+	if err != nil {
+		return err
+	}
+	if change.Op == catalog.ChangeUpdate {
+		return table.Update(key, rowValues(change.New, schema))
+	}
+	return table.Delete(key)
+}
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/internal/utils"
+)
+
+// MaxCachedResultRows is the largest result the result cache keeps; bigger
+// ones are cheap to recompute next to the memory they would hold.
+const MaxCachedResultRows = 10000
+
+// cachedResult is a query result kept in the result cache, with the
+// DataVersion of each table the query read when it ran.
+type cachedResult struct {
+	rs       *ResultSet
+	versions map[string]uint64
+}
+
+// SetResultCacheSize sets how many query results the engine keeps, dropping
+// those it kept so far; 0, the default, turns the cache off. A SELECT run
+// again with the same text by the same user returns the kept rows without
+// running, as long as no table it reads has been written since, nor its
+// schema, indexes or policies changed. Queries over virtual tables, dbstat
+// or a TABLESAMPLE without REPEATABLE, queries calling RANDOM or another
+// non-deterministic function, and results of more than MaxCachedResultRows
+// rows, are never kept.
+func (e *Engine) SetResultCacheSize(size int) {
+	e.results = nil
+	if size > 0 {
+		e.results = utils.NewLRUCache[string, *cachedResult](size)
+	}
+}
+
+// ResultCacheStats reports how often queries found their result cached.
+func (e *Engine) ResultCacheStats() utils.CacheStats {
+	if e.results == nil {
+		return utils.CacheStats{}
+	}
+	return e.results.Stats()
+}
+
+// resultKey identifies the result of node run as plan by the session's
+// user, whose policies shape it. It reports false for a statement whose
+// result is not kept.
+func (e *Engine) resultKey(node parser.Node, plan PlanNode) (string, bool) {
+	stmt, ok := node.(*parser.SelectStmt)
+	if e.results == nil || !ok || writes(plan) || !repeatable(stmt) {
+		return "", false
+	}
+	key, ok := planKey(stmt)
+	if !ok {
+		return "", false
+	}
+	return e.user + "\x00" + key, true
+}
+
+// nonDeterministic holds the scalar functions that may return a different
+// value each call, keyed by upper-case name.
+var nonDeterministic = map[string]bool{
+	"RANDOM": true,
+	"RAND":   true,
+}
+
+// repeatable reports whether stmt returns the same rows from the same data,
+// which neither a TABLESAMPLE without REPEATABLE nor a call of a
+// non-deterministic function, in stmt or any subquery of it, does.
+func repeatable(stmt *parser.SelectStmt) bool {
+	tables := []*parser.TableRef{stmt.Table}
+	for _, join := range stmt.Joins {
+		tables = append(tables, join.Table)
+	}
+	for _, t := range tables {
+		if t != nil && t.Sample != nil && !t.Sample.Repeatable {
+			return false
+		}
+	}
+
+	for _, expr := range stmt.Exprs {
+		if !deterministic(expr) {
+			return false
+		}
+	}
+	var conds []parser.Condition
+	for _, join := range stmt.Joins {
+		conds = append(conds, join.Condition)
+	}
+	for _, where := range []*parser.WhereClause{stmt.Where, stmt.Having} {
+		if where == nil {
+			continue
+		}
+		conds = append(conds, where.Conditions...)
+		for _, pred := range where.Predicates {
+			conds = append(conds, pred.Conditions()...)
+		}
+	}
+	for _, cond := range conds {
+		if !deterministic(cond.Expr) || cond.Subquery != nil && !repeatable(cond.Subquery) {
+			return false
+		}
+	}
+	return true
+}
+
+// deterministic reports whether expr, which may be nil, calls no
+// non-deterministic function.
+func deterministic(expr parser.Expr) bool {
+	switch ex := expr.(type) {
+	case *parser.FuncExpr:
+		if nonDeterministic[strings.ToUpper(ex.Name)] {
+			return false
+		}
+		for _, arg := range ex.Args {
+			if !deterministic(arg) {
+				return false
+			}
+		}
+	case *parser.CastExpr:
+		return deterministic(ex.Expr)
+	case *parser.JSONPathExpr:
+		return deterministic(ex.Expr) && deterministic(ex.Path)
+	}
+	return true
+}
+
+// cachedResultFor returns the result kept under key if no table it read has
+// changed since.
+func (e *Engine) cachedResultFor(key string) (*ResultSet, bool) {
+	if cached, ok := e.results.Peek(key); ok && !e.resultCurrent(cached) {
+		e.results.Delete(key)
+	}
+	cached, ok := e.results.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return copyResults(cached.rs), true
+}
+
+func (e *Engine) resultCurrent(cached *cachedResult) bool {
+	for table, version := range cached.versions {
+		cat, name, err := e.catalogFor(table)
+		if err != nil || cat.DataVersion(name) != version {
+			return false
+		}
+	}
+	return true
+}
+
+// keepResult keeps rs, the result of plan, under key, with the versions of
+// the tables plan read before it ran.
+func (e *Engine) keepResult(key string, versions map[string]uint64, rs *ResultSet) {
+	if len(rs.Rows) > MaxCachedResultRows {
+		return
+	}
+	e.results.Put(key, &cachedResult{rs: copyResults(rs), versions: versions})
+}
+
+// dataVersions records the DataVersion of every table plan reads. It
+// reports false when one cannot be resolved or is virtual, and the result
+// is then not kept.
+func (e *Engine) dataVersions(plan PlanNode) (map[string]uint64, bool) {
+	checks, _ := requiredPrivileges(plan)
+	versions := make(map[string]uint64, len(checks))
+	for _, check := range checks {
+		if _, virtual := e.virtual[check.table]; virtual || e.isDBStat(check.table) {
+			return nil, false
+		}
+		cat, name, err := e.catalogFor(check.table)
+		if err != nil {
+			return nil, false
+		}
+		versions[check.table] = cat.DataVersion(name)
+	}
+	return versions, true
+}
+
+// copyResults copies rs down to its rows, so that neither the cache nor a
+// caller holding a Cursor sees the other change them.
+func copyResults(rs *ResultSet) *ResultSet {
+	c := &ResultSet{Schema: rs.Schema, Hidden: rs.Hidden, Aliases: rs.Aliases}
+	c.Rows = make([][]interface{}, len(rs.Rows))
+	for i, row := range rs.Rows {
+		c.Rows[i] = append([]interface{}(nil), row...)
+	}
+	return c
+}
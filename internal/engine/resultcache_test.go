@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+// newTestEngine opens a fresh database in memory, closed when the test ends.
+func newTestEngine(tb testing.TB) *Engine {
+	tb.Helper()
+	e, err := OpenEngine(storage.NewMemFS(), "test.db")
+	if err != nil {
+		tb.Fatalf("open engine: %v", err)
+	}
+	tb.Cleanup(func() { e.Close() })
+	return e
+}
+
+// run executes each of statements, failing the test on the first error,
+// and returns the output of the last.
+func run(tb testing.TB, e *Engine, statements ...string) string {
+	tb.Helper()
+	var out string
+	for _, sql := range statements {
+		node, err := parser.Parse(sql)
+		if err != nil {
+			tb.Fatalf("parse %q: %v", sql, err)
+		}
+		if out, err = e.Run(node); err != nil {
+			tb.Fatalf("%s: %v", sql, err)
+		}
+	}
+	return out
+}
+
+func TestResultCacheSkipsRandom(t *testing.T) {
+	e := newTestEngine(t)
+	e.SetResultCacheSize(16)
+	run(t, e, "CREATE TABLE t (id INT PRIMARY KEY, n INT)", "INSERT INTO t VALUES (1, 1)")
+
+	for _, sql := range []string{
+		"SELECT RANDOM() FROM t",
+		"SELECT n FROM t WHERE RANDOM() < 2",
+		"SELECT CAST(RAND() AS TEXT) FROM t",
+		"SELECT n FROM t WHERE n IN (SELECT n FROM t WHERE random() >= 0)",
+	} {
+		before := e.ResultCacheStats()
+		run(t, e, sql)
+		run(t, e, sql)
+		if after := e.ResultCacheStats(); after.Hits != before.Hits {
+			t.Errorf("%s: served from the result cache", sql)
+		}
+	}
+
+	before := e.ResultCacheStats()
+	run(t, e, "SELECT n FROM t", "SELECT n FROM t")
+	if after := e.ResultCacheStats(); after.Hits != before.Hits+1 {
+		t.Errorf("a deterministic query missed the result cache")
+	}
+}
@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"math/rand"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+)
+
+// sampleRows keeps each row with the chance the sample gives, deciding in
+// the order the rows come.
+func sampleRows(e *Engine, rows []*catalog.Row, sample *parser.TableSample) ([]*catalog.Row, error) {
+	keep := sampler(e, sample)
+	var kept []*catalog.Row
+	for _, row := range rows {
+		ok, err := keep()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			kept = append(kept, row)
+		}
+	}
+	return kept, nil
+}
+
+// sampler returns a function that decides, one row at a time, whether the
+// sample keeps the row. Without REPEATABLE every sampler draws anew from the
+// engine's random source.
+func sampler(e *Engine, sample *parser.TableSample) func() (bool, error) {
+	draw := e.randomFloat
+	if sample.Repeatable {
+		seeded := rand.New(rand.NewSource(sample.Seed))
+		draw = func() (float64, error) { return seeded.Float64(), nil }
+	}
+	return func() (bool, error) {
+		f, err := draw()
+		return f*100 < sample.Percent, err
+	}
+}
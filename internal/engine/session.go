@@ -0,0 +1,529 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// SessionConfig limits the sessions of a SessionManager. Zero values mean no
+// limit.
+type SessionConfig struct {
+	MaxSessions int
+	// IdleTimeout closes a session that has not run a statement for this
+	// long.
+	IdleTimeout time.Duration
+	// RunJobs starts a scheduler that runs the jobs of CREATE JOB when
+	// their schedules fall due.
+	RunJobs bool
+}
+
+// SessionManager lets one engine serve several clients, the way a server
+// serves its connections. Each Session has its own settings and login;
+// statements from all of them run on the engine one at a time.
+type SessionManager struct {
+	engine *Engine
+	config SessionConfig
+
+	// exec is held while a session's statement runs on the engine.
+	exec sync.Mutex
+
+	// mu guards the fields below and the bookkeeping of every Session. It
+	// may be taken while holding exec, never the other way round.
+	mu       sync.Mutex
+	sessions map[int64]*Session
+	nextID   int64
+	defaults sessionState
+	stop     chan struct{}
+	jobRuns  map[string]jobRun
+}
+
+// NewSessionManager starts managing sessions on e. New sessions start with
+// the settings e has now.
+func NewSessionManager(e *Engine, config SessionConfig) *SessionManager {
+	m := &SessionManager{
+		engine:   e,
+		config:   config,
+		sessions: make(map[int64]*Session),
+		defaults: e.sessionState,
+		stop:     make(chan struct{}),
+		jobRuns:  make(map[string]jobRun),
+	}
+	m.defaults.lastInsertID = 0
+	e.sessions = m
+
+	if config.IdleTimeout > 0 {
+		go m.reapIdle()
+	}
+	if config.RunJobs {
+		go m.runJobs()
+	}
+	return m
+}
+
+// Session is one client of a SessionManager.
+type Session struct {
+	manager *SessionManager
+	id      int64
+	state   sessionState
+
+	connected  time.Time
+	lastActive time.Time
+
+	// statement is the text of the statement the session is running or
+	// waiting to run, and cancel stops it.
+	statement string
+	status    string
+	since     time.Time
+	cancel    context.CancelFunc
+
+	// closed is returned by every call once the session has ended.
+	closed error
+}
+
+// Open starts a session. It fails with TooManyConnections when MaxSessions
+// are already open.
+func (m *SessionManager) Open() (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stop == nil {
+		return nil, sqlerr.New(sqlerr.AdminShutdown, "session manager is closed")
+	}
+
+	now := time.Now()
+	m.reapIdleLocked(now)
+	if m.config.MaxSessions > 0 && len(m.sessions) >= m.config.MaxSessions {
+		return nil, sqlerr.New(sqlerr.TooManyConnections, "too many sessions: the limit is %d", m.config.MaxSessions)
+	}
+
+	return m.openLocked(now), nil
+}
+
+func (m *SessionManager) openLocked(now time.Time) *Session {
+	m.nextID++
+	s := &Session{
+		manager:    m,
+		id:         m.nextID,
+		state:      m.defaults,
+		connected:  now,
+		lastActive: now,
+		status:     "idle",
+		since:      now,
+	}
+	m.sessions[s.id] = s
+	return s
+}
+
+// Close ends every session, cancelling their statements, and stops the idle
+// timeout and the job scheduler.
+func (m *SessionManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		s.endLocked(sqlerr.New(sqlerr.AdminShutdown, "session %d was closed by the server", id))
+	}
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+}
+
+func (m *SessionManager) reapIdle() {
+	interval := m.config.IdleTimeout / 2
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	m.mu.Lock()
+	stop := m.stop
+	m.mu.Unlock()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			m.reapIdleLocked(now)
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *SessionManager) reapIdleLocked(now time.Time) {
+	if m.config.IdleTimeout <= 0 {
+		return
+	}
+	for id, s := range m.sessions {
+		if s.status == "idle" && now.Sub(s.lastActive) >= m.config.IdleTimeout {
+			s.endLocked(sqlerr.New(sqlerr.IdleSessionTimeout, "session %d closed after %s idle", id, m.config.IdleTimeout))
+		}
+	}
+}
+
+// Kill cancels the statement session id is running. Unless queryOnly is
+// set it also ends the session.
+func (m *SessionManager) Kill(id int64, queryOnly bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return sqlerr.New(sqlerr.UndefinedObject, "session %d not found", id)
+	}
+	if queryOnly {
+		if s.cancel != nil {
+			s.cancel()
+		}
+		return nil
+	}
+	s.endLocked(sqlerr.New(sqlerr.AdminShutdown, "session %d was terminated", id))
+	return nil
+}
+
+// ProcessInfo describes a session for SHOW PROCESSLIST.
+type ProcessInfo struct {
+	ID   int64
+	User string
+	// State is idle, waiting for another session's statement, or running.
+	State     string
+	Connected time.Time
+	// Since is when the session entered State.
+	Since     time.Time
+	Statement string
+}
+
+// ProcessList returns the open sessions in the order they were opened.
+func (m *SessionManager) ProcessList() []ProcessInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]ProcessInfo, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		list = append(list, ProcessInfo{
+			ID:        s.id,
+			User:      s.state.user,
+			State:     s.status,
+			Connected: s.connected,
+			Since:     s.since,
+			Statement: s.statement,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func (s *Session) ID() int64 {
+	return s.id
+}
+
+// Login authenticates the session like Engine.Login.
+func (s *Session) Login(name, password string) error {
+	return s.do(context.Background(), "LOGIN "+name, func(ctx context.Context) error {
+		return s.manager.engine.Login(name, password)
+	})
+}
+
+// admin runs SHOW PROCESSLIST and KILL straight away instead of waiting for
+// the running statement, since their point is to inspect or stop it. ok is
+// false for any other statement. rs is nil for KILL.
+func (s *Session) admin(node parser.Node) (rs *ResultSet, result string, ok bool, err error) {
+	m := s.manager
+	m.mu.Lock()
+	closed, state := s.closed, s.state
+	m.mu.Unlock()
+
+	switch stmt := node.(type) {
+	case *parser.ShowStmt:
+		if stmt.Name != "processlist" {
+			return nil, "", false, nil
+		}
+		if closed != nil {
+			return nil, "", true, closed
+		}
+		return m.processList(state.user), "", true, nil
+	case *parser.KillStmt:
+		if closed != nil {
+			return nil, "", true, closed
+		}
+		result, err := m.kill(state.user, &KillPlan{ID: stmt.ID, Query: stmt.Query})
+		return nil, result, true, err
+	}
+	return nil, "", false, nil
+}
+
+// format renders rs with the session's output settings.
+func (s *Session) format(w io.Writer, rs *ResultSet) {
+	s.manager.mu.Lock()
+	state := s.state
+	s.manager.mu.Unlock()
+	writeResults(w, rs, state.outputFormat, !state.hideHeaders)
+}
+
+// Run executes a statement in the session, see Engine.RunContext.
+func (s *Session) Run(ctx context.Context, node parser.Node) (string, error) {
+	if rs, result, ok, err := s.admin(node); ok {
+		if rs != nil {
+			var b strings.Builder
+			s.format(&b, rs)
+			result = b.String()
+		}
+		return result, err
+	}
+	if stmt, ok := node.(*parser.CreateIndexStmt); ok && stmt.Concurrently {
+		return s.createIndexConcurrently(ctx, stmt)
+	}
+
+	var result string
+	err := s.do(ctx, node.String(), func(ctx context.Context) error {
+		var err error
+		result, err = s.manager.engine.RunContext(ctx, node)
+		return err
+	})
+	return result, err
+}
+
+// Stream executes a statement in the session, see Engine.StreamContext.
+func (s *Session) Stream(ctx context.Context, w io.Writer, node parser.Node) error {
+	if rs, result, ok, err := s.admin(node); ok {
+		if err != nil {
+			return err
+		}
+		if rs != nil {
+			s.format(w, rs)
+		}
+		_, err = io.WriteString(w, result+"\n")
+		return err
+	}
+	if stmt, ok := node.(*parser.CreateIndexStmt); ok && stmt.Concurrently {
+		result, err := s.createIndexConcurrently(ctx, stmt)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, result+"\n")
+		return err
+	}
+
+	return s.do(ctx, node.String(), func(ctx context.Context) error {
+		return s.manager.engine.StreamContext(ctx, w, node)
+	})
+}
+
+// Query executes a statement in the session, see Engine.QueryContext.
+func (s *Session) Query(ctx context.Context, node parser.Node) (*Cursor, error) {
+	if rs, _, ok, err := s.admin(node); ok {
+		if err != nil {
+			return nil, err
+		}
+		if rs == nil {
+			return &Cursor{rs: &ResultSet{}}, nil
+		}
+		return &Cursor{rs: rs, returnsRows: true}, nil
+	}
+	if stmt, ok := node.(*parser.CreateIndexStmt); ok && stmt.Concurrently {
+		if _, err := s.createIndexConcurrently(ctx, stmt); err != nil {
+			return nil, err
+		}
+		return &Cursor{rs: &ResultSet{}}, nil
+	}
+
+	var cursor *Cursor
+	err := s.do(ctx, node.String(), func(ctx context.Context) error {
+		var err error
+		cursor, err = s.manager.engine.QueryContext(ctx, node)
+		return err
+	})
+	return cursor, err
+}
+
+// Exec executes a statement in the session, see Engine.ExecContext. The
+// last insert id is the session's own.
+func (s *Session) Exec(ctx context.Context, node parser.Node) (int64, int64, error) {
+	if _, _, ok, err := s.admin(node); ok {
+		return 0, s.lastInsertID(), err
+	}
+	if stmt, ok := node.(*parser.CreateIndexStmt); ok && stmt.Concurrently {
+		_, err := s.createIndexConcurrently(ctx, stmt)
+		return 0, s.lastInsertID(), err
+	}
+
+	var affected, id int64
+	err := s.do(ctx, node.String(), func(ctx context.Context) error {
+		var err error
+		affected, id, err = s.manager.engine.ExecContext(ctx, node)
+		return err
+	})
+	return affected, id, err
+}
+
+// ExecuteBatch runs statements in one batch in the session, see
+// Engine.ExecuteBatch. No other session's statement runs in between.
+func (s *Session) ExecuteBatch(ctx context.Context, statements []string) ([]BatchResult, error) {
+	var results []BatchResult
+	err := s.do(ctx, fmt.Sprintf("batch of %d statements", len(statements)), func(ctx context.Context) error {
+		var err error
+		results, err = s.manager.engine.ExecuteBatchContext(ctx, statements)
+		return err
+	})
+	return results, err
+}
+
+func (s *Session) lastInsertID() int64 {
+	s.manager.mu.Lock()
+	defer s.manager.mu.Unlock()
+	return s.state.lastInsertID
+}
+
+// Close ends the session, cancelling its statement if one is running.
+func (s *Session) Close() {
+	m := s.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.endLocked(sqlerr.New(sqlerr.AdminShutdown, "session %d is closed", s.id))
+}
+
+func (s *Session) endLocked(reason error) {
+	if s.closed != nil {
+		return
+	}
+	s.closed = reason
+	if s.cancel != nil {
+		s.cancel()
+	}
+	delete(s.manager.sessions, s.id)
+}
+
+// do runs fn on the engine with the session's state swapped in, once no
+// other session's statement is running.
+func (s *Session) do(ctx context.Context, statement string, fn func(ctx context.Context) error) error {
+	m := s.manager
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m.mu.Lock()
+	if s.closed != nil {
+		m.mu.Unlock()
+		return s.closed
+	}
+	s.statement, s.status, s.since, s.cancel = statement, "waiting", time.Now(), cancel
+	m.mu.Unlock()
+
+	m.exec.Lock()
+	m.mu.Lock()
+	s.status, s.since = "running", time.Now()
+	err, state := s.closed, s.state
+	m.mu.Unlock()
+
+	if err == nil && ctx.Err() != nil {
+		err = sqlerr.New(sqlerr.QueryCanceled, "statement canceled: %v", ctx.Err())
+	}
+	if err == nil {
+		e := m.engine
+		saved := e.sessionState
+		e.sessionState = state
+		err = fn(ctx)
+		state = e.sessionState
+		e.sessionState = saved
+	}
+	m.exec.Unlock()
+
+	m.mu.Lock()
+	now := time.Now()
+	s.state = state
+	s.statement, s.status, s.since, s.cancel = "", "idle", now, nil
+	s.lastActive = now
+	m.mu.Unlock()
+	return err
+}
+
+// ProcessListPlan is SHOW PROCESSLIST.
+type ProcessListPlan struct{}
+
+func (p *ProcessListPlan) Type() string   { return "ProcessList" }
+func (p *ProcessListPlan) Cost() float64  { return 0 }
+func (p *ProcessListPlan) String() string { return "ProcessList()" }
+
+// KillPlan is KILL [QUERY] id.
+type KillPlan struct {
+	ID    int64
+	Query bool
+}
+
+func (k *KillPlan) Type() string  { return "Kill" }
+func (k *KillPlan) Cost() float64 { return 0 }
+func (k *KillPlan) String() string {
+	if k.Query {
+		return fmt.Sprintf("Kill(query %d)", k.ID)
+	}
+	return fmt.Sprintf("Kill(%d)", k.ID)
+}
+
+func noSessions() error {
+	return sqlerr.New(sqlerr.FeatureNotSupported, "this engine has no sessions; see NewSessionManager")
+}
+
+func executeProcessList(e *Engine, plan *ProcessListPlan) (string, error) {
+	if e.sessions == nil {
+		return "", noSessions()
+	}
+	return e.formatResults(e.sessions.processList(e.user)), nil
+}
+
+func executeKill(e *Engine, plan *KillPlan) (string, error) {
+	if e.sessions == nil {
+		return "", noSessions()
+	}
+	return e.sessions.kill(e.user, plan)
+}
+
+// processList shows every session to the owner and other users only their
+// own.
+func (m *SessionManager) processList(user string) *ResultSet {
+	now := time.Now()
+	rs := &ResultSet{Schema: []string{"id", "user", "state", "time", "statement"}}
+	for _, p := range m.ProcessList() {
+		if user != "" && p.User != user {
+			continue
+		}
+		var name, statement interface{}
+		if p.User != "" {
+			name = p.User
+		}
+		if p.Statement != "" {
+			statement = p.Statement
+		}
+		elapsed := now.Sub(p.Since).Round(time.Millisecond).String()
+		rs.Rows = append(rs.Rows, []interface{}{p.ID, name, p.State, elapsed, statement})
+	}
+	return rs
+}
+
+// kill lets the owner kill any session and other users only their own.
+func (m *SessionManager) kill(user string, plan *KillPlan) (string, error) {
+	if user != "" {
+		for _, p := range m.ProcessList() {
+			if p.ID == plan.ID && p.User != user {
+				return "", sqlerr.New(sqlerr.InsufficientPrivilege, "permission denied: session %d belongs to another user", plan.ID)
+			}
+		}
+	}
+
+	if err := m.Kill(plan.ID, plan.Query); err != nil {
+		return "", err
+	}
+	if plan.Query {
+		return fmt.Sprintf("Statement of session %d canceled", plan.ID), nil
+	}
+	return fmt.Sprintf("Session %d terminated", plan.ID), nil
+}
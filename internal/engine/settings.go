@@ -0,0 +1,260 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// setting is a run-time option changed with SET and read with SHOW. Values
+// live on the Engine, so each engine, and therefore each session, has its
+// own.
+type setting struct {
+	description string
+	get         func(e *Engine) string
+	set         func(e *Engine, value string) error
+}
+
+var settings = map[string]setting{
+	"output_format": {
+		description: "Format of query results: table, csv or json",
+		get:         func(e *Engine) string { return e.outputFormat },
+		set: func(e *Engine, value string) error {
+			switch format := strings.ToLower(value); format {
+			case "table", "csv", "json":
+				e.outputFormat = format
+				return nil
+			}
+			return fmt.Errorf("invalid output_format %q: expected table, csv or json", value)
+		},
+	},
+	"headers": {
+		description: "Print column names above table and csv results",
+		get: func(e *Engine) string {
+			if e.hideHeaders {
+				return "off"
+			}
+			return "on"
+		},
+		set: func(e *Engine, value string) error {
+			switch strings.ToLower(value) {
+			case "on", "true", "1":
+				e.hideHeaders = false
+			case "off", "false", "0":
+				e.hideHeaders = true
+			default:
+				return fmt.Errorf("invalid headers %q: expected on or off", value)
+			}
+			return nil
+		},
+	},
+	"strict_types": {
+		description: "Reject unknown column types in CREATE TABLE",
+		get: func(e *Engine) string {
+			if e.strict {
+				return "on"
+			}
+			return "off"
+		},
+		set: func(e *Engine, value string) error {
+			switch strings.ToLower(value) {
+			case "on", "true", "1":
+				e.strict = true
+			case "off", "false", "0":
+				e.strict = false
+			default:
+				return fmt.Errorf("invalid strict_types %q: expected on or off", value)
+			}
+			return nil
+		},
+	},
+	"timeout": {
+		description: "Abort statements running longer than this, e.g. '5s'; a bare number is milliseconds, 0 disables",
+		get:         func(e *Engine) string { return e.timeout.String() },
+		set: func(e *Engine, value string) error {
+			timeout, err := parseSettingDuration("timeout", value)
+			if err != nil {
+				return err
+			}
+			e.timeout = timeout
+			return nil
+		},
+	},
+	// statement_timeout is the PostgreSQL name of timeout.
+	"statement_timeout": {
+		description: "Same as timeout",
+		get:         func(e *Engine) string { return e.timeout.String() },
+		set: func(e *Engine, value string) error {
+			timeout, err := parseSettingDuration("statement_timeout", value)
+			if err != nil {
+				return err
+			}
+			e.timeout = timeout
+			return nil
+		},
+	},
+	"log_level": {
+		description: "Lowest level passed to the logger: debug (adds plans), info, warn or error",
+		get:         func(e *Engine) string { return e.logLevel.String() },
+		set: func(e *Engine, value string) error {
+			level, err := ParseLogLevel(value)
+			if err != nil {
+				return err
+			}
+			e.logLevel = level
+			return nil
+		},
+	},
+	"slow_query_threshold": {
+		description: "Log a warning for statements running at least this long; a bare number is milliseconds, 0 disables",
+		get:         func(e *Engine) string { return e.slowQuery.String() },
+		set: func(e *Engine, value string) error {
+			threshold, err := parseSettingDuration("slow_query_threshold", value)
+			if err != nil {
+				return err
+			}
+			e.slowQuery = threshold
+			return nil
+		},
+	},
+	"auto_analyze_threshold": {
+		description: "Analyze a table again once this percentage of its rows has changed since its statistics were read; 0 disables",
+		get:         func(e *Engine) string { return strconv.FormatFloat(e.autoAnalyze, 'g', -1, 64) },
+		set: func(e *Engine, value string) error {
+			percent, err := strconv.ParseFloat(value, 64)
+			if err != nil || percent < 0 {
+				return fmt.Errorf("invalid auto_analyze_threshold %q: expected a percentage such as 10", value)
+			}
+			e.autoAnalyze = percent
+			return nil
+		},
+	},
+	"seq_row_cost": costSetting("seq_row_cost",
+		"Planner cost of reading one row in a scan of a whole table",
+		func(c *CostModel) *float64 { return &c.SeqRowCost }),
+	"index_probe_cost": costSetting("index_probe_cost",
+		"Planner cost of reading one row found through an index",
+		func(c *CostModel) *float64 { return &c.IndexProbeCost }),
+	"cpu_row_cost": costSetting("cpu_row_cost",
+		"Planner cost of projecting one row or comparing one pair of rows in a join",
+		func(c *CostModel) *float64 { return &c.CPURowCost }),
+}
+
+// costSetting makes a setting for the planner cost constant field picks.
+func costSetting(name, description string, field func(c *CostModel) *float64) setting {
+	return setting{
+		description: description,
+		get:         func(e *Engine) string { return strconv.FormatFloat(*field(&e.costs), 'g', -1, 64) },
+		set: func(e *Engine, value string) error {
+			cost, err := strconv.ParseFloat(value, 64)
+			if err != nil || cost < 0 || math.IsInf(cost, 0) {
+				return fmt.Errorf("invalid %s %q: expected a number such as 0.1", name, value)
+			}
+			*field(&e.costs) = cost
+			return nil
+		},
+	}
+}
+
+// parseSettingDuration accepts a Go duration such as 5s or a bare number of
+// milliseconds.
+func parseSettingDuration(name, value string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil && ms >= 0 {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a duration such as 5s", name, value)
+	}
+	return d, nil
+}
+
+// Set changes a setting, as SET name = value does.
+func (e *Engine) Set(name, value string) error {
+	s, ok := settings[strings.ToLower(name)]
+	if !ok {
+		return sqlerr.New(sqlerr.UndefinedObject, "unknown setting %s", name)
+	}
+	return s.set(e, value)
+}
+
+// Setting returns the current value of a setting, as SHOW name does.
+func (e *Engine) Setting(name string) (string, error) {
+	s, ok := settings[strings.ToLower(name)]
+	if !ok {
+		return "", sqlerr.New(sqlerr.UndefinedObject, "unknown setting %s", name)
+	}
+	return s.get(e), nil
+}
+
+type SetPlan struct {
+	Name  string
+	Value string
+}
+
+func (s *SetPlan) Type() string  { return "Set" }
+func (s *SetPlan) Cost() float64 { return 0 }
+func (s *SetPlan) String() string {
+	return fmt.Sprintf("Set(%s = %s)", s.Name, s.Value)
+}
+
+// ShowPlan shows one setting, or all of them when Name is empty.
+type ShowPlan struct {
+	Name string
+}
+
+func (s *ShowPlan) Type() string  { return "Show" }
+func (s *ShowPlan) Cost() float64 { return 0 }
+func (s *ShowPlan) String() string {
+	name := s.Name
+	if name == "" {
+		name = "ALL"
+	}
+	return fmt.Sprintf("Show(%s)", name)
+}
+
+func executeSet(e *Engine, plan *SetPlan) (string, error) {
+	if err := e.Set(plan.Name, plan.Value); err != nil {
+		return "", err
+	}
+	return "SET", nil
+}
+
+func executeShow(e *Engine, plan *ShowPlan) (string, error) {
+	names := []string{strings.ToLower(plan.Name)}
+	if plan.Name == "" {
+		names = names[:0]
+		for name := range settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	rs := &ResultSet{Schema: []string{"name", "setting", "description"}}
+	for _, name := range names {
+		s, ok := settings[name]
+		if !ok {
+			return "", sqlerr.New(sqlerr.UndefinedObject, "unknown setting %s", plan.Name)
+		}
+		rs.Rows = append(rs.Rows, []interface{}{name, s.get(e), s.description})
+	}
+
+	return e.formatResults(rs), nil
+}
+
+// checkDeadline reports whether the running statement has used up its
+// timeout or had its context cancelled. Long loops call it between rows.
+func (e *Engine) checkDeadline() error {
+	if !e.deadline.IsZero() && time.Now().After(e.deadline) {
+		return sqlerr.New(sqlerr.QueryCanceled, "statement timed out after %s", e.timeout)
+	}
+	if e.ctx != nil && e.ctx.Err() != nil {
+		return sqlerr.New(sqlerr.QueryCanceled, "statement canceled: %v", e.ctx.Err())
+	}
+	return nil
+}
@@ -0,0 +1,395 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// SemiJoinPlan keeps the rows of Input that have a match in Subquery, or
+// for NOT IN and NOT EXISTS those that have none. Subquery runs once and
+// its rows go into a hash table each row of Input is looked up in, rather
+// than running again for every row.
+type SemiJoinPlan struct {
+	// Operator is IN, NOT IN, EXISTS or NOT EXISTS.
+	Operator string
+	Input    PlanNode
+	Subquery PlanNode
+	// Outer pairs with the columns of Subquery's rows in order: a row of
+	// Input matches a row of Subquery when each value of Outer equals the
+	// column at its position. For IN and NOT IN the last pair is the IN
+	// operand and the subquery's column; the others come from conditions
+	// of the subquery that refer to Input, which make it correlated.
+	Outer   []parser.Expr
+	EstRows int
+	EstCost float64
+}
+
+func (s *SemiJoinPlan) Type() string  { return "SemiJoin" }
+func (s *SemiJoinPlan) Cost() float64 { return s.EstCost }
+func (s *SemiJoinPlan) String() string {
+	name := "SemiJoin"
+	if s.anti() {
+		name = "AntiJoin"
+	}
+	keys := make([]string, len(s.Outer))
+	for i, expr := range s.Outer {
+		keys[i] = expr.String()
+	}
+	return fmt.Sprintf("%s(%s, keys=[%s], rows=%d, cost=%.2f)\n  Input: %s\n  Subquery: %s",
+		name, s.Operator, strings.Join(keys, ", "), s.EstRows, s.EstCost, s.Input.String(), s.Subquery.String())
+}
+
+func (s *SemiJoinPlan) anti() bool {
+	return strings.HasPrefix(s.Operator, "NOT ")
+}
+
+func (s *SemiJoinPlan) in() bool {
+	return strings.HasSuffix(s.Operator, "IN")
+}
+
+// errSubqueryPlace rejects a subquery anywhere but the WHERE clause of a
+// SELECT.
+var errSubqueryPlace = sqlerr.New(sqlerr.FeatureNotSupported, "subqueries are only supported in the WHERE clause of a SELECT")
+
+func hasSubquery(conditions []parser.Condition) bool {
+	for _, c := range conditions {
+		if c.Subquery != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// errSubqueryOr rejects a subquery in a condition under OR, which cannot
+// run as a semi-join of the rows.
+var errSubqueryOr = sqlerr.New(sqlerr.FeatureNotSupported, "subqueries are not supported in a condition under OR")
+
+// predicateHasSubquery reports whether a condition of preds takes a
+// subquery.
+func predicateHasSubquery(preds []parser.Predicate) bool {
+	for _, pred := range preds {
+		if hasSubquery(pred.Conditions()) {
+			return true
+		}
+	}
+	return false
+}
+
+// whereHasSubquery reports whether a condition of where, which may be nil,
+// takes a subquery.
+func whereHasSubquery(where *parser.WhereClause) bool {
+	return where != nil && (hasSubquery(where.Conditions) || predicateHasSubquery(where.Predicates))
+}
+
+// splitSubqueries returns stmt without the conditions of its WHERE clause
+// that take a subquery, and those conditions. stmt itself is left as it is.
+func splitSubqueries(stmt *parser.SelectStmt) (*parser.SelectStmt, []parser.Condition) {
+	if stmt.Where == nil || !hasSubquery(stmt.Where.Conditions) {
+		return stmt, nil
+	}
+	var subqueries []parser.Condition
+	where := &parser.WhereClause{Predicates: stmt.Where.Predicates}
+	for _, c := range stmt.Where.Conditions {
+		if c.Subquery != nil {
+			subqueries = append(subqueries, c)
+		} else {
+			where.Conditions = append(where.Conditions, c)
+		}
+	}
+	rest := *stmt
+	rest.Where = nil
+	if len(where.Conditions) > 0 || len(where.Predicates) > 0 {
+		rest.Where = where
+	}
+	return &rest, subqueries
+}
+
+// queryTables lists the tables stmt reads, for telling which columns a
+// subquery takes from it.
+func queryTables(stmt *parser.SelectStmt) []*parser.TableRef {
+	tables := []*parser.TableRef{stmt.Table}
+	for _, join := range stmt.Joins {
+		tables = append(tables, join.Table)
+	}
+	return tables
+}
+
+// hasColumn reports whether column, bare or qualified, names a column of
+// one of tables.
+func (p *Planner) hasColumn(tables []*parser.TableRef, column string) bool {
+	for _, t := range tables {
+		name, ok := scanColumn(&ScanPlan{Table: t.Name, Alias: t.Alias}, column)
+		if !ok {
+			continue
+		}
+		if name == catalog.RowIDColumn {
+			return true
+		}
+		if schema := p.tableSchema(t.Name); schema != nil && schema.GetColumn(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// planSemiJoin plans cond, a condition of the WHERE clause of outer that
+// takes a subquery, as a semi-join or anti-join of input. A condition of
+// the subquery comparing one of its columns with a column of outer becomes
+// a key of the join; a name both queries have belongs to the subquery.
+func (p *Planner) planSemiJoin(outer *parser.SelectStmt, input PlanNode, cond parser.Condition) (*SemiJoinPlan, error) {
+	sub := *cond.Subquery
+	outerTables, innerTables := queryTables(outer), queryTables(&sub)
+
+	var outerKeys []parser.Expr
+	var innerColumns []string
+	if sub.Where != nil {
+		where := &parser.WhereClause{Predicates: sub.Where.Predicates}
+		for _, pred := range sub.Where.Predicates {
+			for _, c := range pred.Conditions() {
+				_, _, correlated, err := p.correlation(c, innerTables, outerTables)
+				if err != nil {
+					return nil, err
+				}
+				if correlated {
+					return nil, sqlerr.New(sqlerr.FeatureNotSupported, "subquery condition %s under OR cannot refer to the outer query", c)
+				}
+			}
+		}
+		for _, c := range sub.Where.Conditions {
+			inner, outerColumn, correlated, err := p.correlation(c, innerTables, outerTables)
+			if err != nil {
+				return nil, err
+			}
+			if !correlated {
+				where.Conditions = append(where.Conditions, c)
+				continue
+			}
+			outerKeys = append(outerKeys, &parser.ColumnExpr{Name: outerColumn})
+			innerColumns = append(innerColumns, inner)
+		}
+		sub.Where = nil
+		if len(where.Conditions) > 0 || len(where.Predicates) > 0 {
+			sub.Where = where
+		}
+	}
+
+	if len(outerKeys) > 0 && (len(sub.GroupBy) > 0 || sub.Having != nil || sub.Limit != nil || len(queryAggregates(&sub)) > 0) {
+		return nil, sqlerr.New(sqlerr.FeatureNotSupported, "a subquery that refers to the outer query cannot use GROUP BY, HAVING, LIMIT or aggregates")
+	}
+
+	in := cond.Operator == "IN" || cond.Operator == "NOT IN"
+	if in {
+		if len(sub.Columns) != 1 || sub.Columns[0] == "*" {
+			return nil, sqlerr.New(sqlerr.SyntaxError, "subquery for %s must return exactly one column", cond.Operator)
+		}
+		var operand parser.Expr = &parser.ColumnExpr{Name: cond.Column}
+		if cond.Expr != nil {
+			operand = cond.Expr
+		}
+		outerKeys = append(outerKeys, operand)
+	}
+
+	// Only the key columns are needed from the subquery. Without keys, an
+	// EXISTS only needs to know whether there is a row at all.
+	switch {
+	case in && len(innerColumns) == 0:
+	case in:
+		sub.Columns = append(innerColumns, sub.Columns[0])
+		if sub.Exprs != nil {
+			sub.Exprs = append(make([]parser.Expr, len(innerColumns)), sub.Exprs[0])
+		}
+		if sub.Aliases != nil {
+			sub.Aliases = append(make([]string, len(innerColumns)), sub.Aliases[0])
+		}
+		sub.OrderBy = nil
+	case len(innerColumns) == 0:
+		if sub.Limit == nil {
+			sub.Limit = &parser.LimitClause{Count: "1"}
+		}
+	default:
+		sub.Columns = innerColumns
+		sub.Exprs = nil
+		sub.Aliases = nil
+		sub.Distinct = true
+		sub.OrderBy = nil
+	}
+
+	subPlan, err := p.planSelect(&sub)
+	if err != nil {
+		return nil, err
+	}
+
+	inputRows := p.estimateRows(input)
+	return &SemiJoinPlan{
+		Operator: cond.Operator,
+		Input:    input,
+		Subquery: subPlan,
+		Outer:    outerKeys,
+		EstRows:  int(inputRows * 0.5),
+		EstCost:  input.Cost() + subPlan.Cost() + (inputRows+p.estimateRows(subPlan))*p.costs.CPURowCost,
+	}, nil
+}
+
+// correlation reports whether c, a condition of a subquery, compares a
+// column of the subquery with a column of the outer query, and if so
+// returns both.
+func (p *Planner) correlation(c parser.Condition, inner, outer []*parser.TableRef) (string, string, bool, error) {
+	if c.Subquery != nil || c.Expr != nil {
+		return "", "", false, nil
+	}
+	innerColumn, outerColumn := c.Column, ""
+	if c.Value.Kind == parser.Identifier && !p.hasColumn(inner, c.Value.Text) && p.hasColumn(outer, c.Value.Text) {
+		outerColumn = c.Value.Text
+	}
+	if !p.hasColumn(inner, c.Column) && p.hasColumn(outer, c.Column) {
+		if outerColumn != "" || c.Value.Kind != parser.Identifier || !p.hasColumn(inner, c.Value.Text) {
+			return "", "", false, sqlerr.New(sqlerr.FeatureNotSupported, "subquery condition %s must compare a column of the subquery with one of the outer query", c)
+		}
+		innerColumn, outerColumn = c.Value.Text, c.Column
+	}
+	if outerColumn == "" {
+		return "", "", false, nil
+	}
+	if c.Operator != "=" {
+		return "", "", false, sqlerr.New(sqlerr.FeatureNotSupported, "subquery condition %s must compare with the outer query using =", c)
+	}
+	return innerColumn, outerColumn, true, nil
+}
+
+func executeSemiJoin(e *Engine, plan *SemiJoinPlan) (string, error) {
+	resultSet, err := semiJoinResultSet(e, plan)
+	if err != nil {
+		return "", err
+	}
+	return e.formatResults(resultSet), nil
+}
+
+func semiJoinResultSet(e *Engine, plan *SemiJoinPlan) (*ResultSet, error) {
+	input, err := executePlanToResultSet(e, plan.Input)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := executePlanToResultSet(e, plan.Subquery)
+	if err != nil {
+		return nil, err
+	}
+
+	// groups holds the correlation keys the subquery has rows for, and for
+	// IN, members the keys followed by the IN value and nulls the keys
+	// whose rows include a NULL IN value.
+	in := plan.in()
+	correlated := len(plan.Outer)
+	if in {
+		correlated--
+	}
+	groups := make(map[string]bool)
+	members := make(map[string]bool)
+	nulls := make(map[string]bool)
+	var buf []byte
+	for _, row := range sub.Rows {
+		var ok bool
+		if buf, ok = appendHashKeys(buf[:0], row[:correlated]); !ok {
+			continue
+		}
+		groups[string(buf)] = true
+		if !in {
+			continue
+		}
+		if row[correlated] == nil {
+			nulls[string(buf)] = true
+			continue
+		}
+		buf = appendHashKey(buf, row[correlated])
+		members[string(buf)] = true
+	}
+
+	values := make([]interface{}, len(plan.Outer))
+	kept := input.Rows[:0]
+	for _, row := range input.Rows {
+		if err := e.checkDeadline(); err != nil {
+			return nil, err
+		}
+		for i, expr := range plan.Outer {
+			if values[i], err = evalExpr(e, expr, input.getter(row)); err != nil {
+				return nil, err
+			}
+		}
+		var ok bool
+		buf, ok = appendHashKeys(buf[:0], values[:correlated])
+		group := ok && groups[string(buf)]
+
+		var match bool
+		switch plan.Operator {
+		case "EXISTS":
+			match = group
+		case "NOT EXISTS":
+			match = !group
+		case "IN":
+			match = group && values[correlated] != nil && members[string(appendHashKey(buf, values[correlated]))]
+		case "NOT IN":
+			// x NOT IN a set holding NULL is never true, but NOT IN an
+			// empty set always is, even for a NULL x.
+			match = !group || (values[correlated] != nil && !nulls[string(buf)] &&
+				!members[string(appendHashKey(buf, values[correlated]))])
+		}
+		if match {
+			kept = append(kept, row)
+		}
+	}
+	input.Rows = kept
+	return input, nil
+}
+
+// appendHashKeys appends the hash key of each of values to key, reporting
+// false if one is NULL, which equals nothing.
+func appendHashKeys(key []byte, values []interface{}) ([]byte, bool) {
+	for _, v := range values {
+		if v == nil {
+			return key, false
+		}
+		key = appendHashKey(key, v)
+	}
+	return key, true
+}
+
+// appendHashKey appends to key a form of v that is the same for values
+// equal under =. Rows read from disk carry integers as float64, so a whole
+// number is written the same whatever its type.
+func appendHashKey(key []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case int64:
+		key = append(key, 'n')
+		key = strconv.AppendInt(key, x, 10)
+	case float64:
+		key = append(key, 'n')
+		if x == math.Trunc(x) && math.Abs(x) < 1<<63 {
+			key = strconv.AppendInt(key, int64(x), 10)
+		} else {
+			key = strconv.AppendFloat(key, x, 'g', -1, 64)
+		}
+	case string:
+		key = append(key, 's')
+		key = strconv.AppendInt(key, int64(len(x)), 10)
+		key = append(key, ':')
+		key = append(key, x...)
+	case bool:
+		if x {
+			key = append(key, 't')
+		} else {
+			key = append(key, 'f')
+		}
+	default:
+		s := fmt.Sprint(x)
+		key = append(key, 'o')
+		key = strconv.AppendInt(key, int64(len(s)), 10)
+		key = append(key, ':')
+		key = append(key, s...)
+	}
+	return append(key, ';')
+}
@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// tableFunc is a function called in FROM in place of a table. It returns
+// one column, named after the function or after the alias of the call.
+type tableFunc struct {
+	// estimate checks the arguments and returns the number of rows the
+	// call will return, for the planner.
+	estimate func(args []parser.Value) (int, error)
+	values   func(e *Engine, args []parser.Value) ([]interface{}, error)
+}
+
+// tableFunctions holds the built-in table functions, keyed by upper-case
+// name.
+var tableFunctions = map[string]tableFunc{
+	"GENERATE_SERIES": {estimate: seriesLength, values: seriesValues},
+}
+
+func lookupTableFunc(name string) (tableFunc, error) {
+	fn, ok := tableFunctions[strings.ToUpper(name)]
+	if !ok {
+		return fn, sqlerr.New(sqlerr.UndefinedFunction, "unknown table function %s", name)
+	}
+	return fn, nil
+}
+
+// functionColumn returns the name of the column of a table function call.
+func functionColumn(scan *ScanPlan) string {
+	if scan.Alias != "" {
+		return scan.Alias
+	}
+	return strings.ToLower(scan.Table)
+}
+
+// scanFunction calls the table function of scan and keeps the rows that
+// pass its filter.
+func scanFunction(e *Engine, scan *ScanPlan) (*ResultSet, error) {
+	if scan.Sample != nil {
+		return nil, sqlerr.New(sqlerr.FeatureNotSupported, "%s cannot be sampled", scan.Table)
+	}
+	fn, err := lookupTableFunc(scan.Table)
+	if err != nil {
+		return nil, err
+	}
+	values, err := fn.values(e, scan.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &ResultSet{Schema: []string{tablePrefix(scan.Table, scan.Alias) + "." + functionColumn(scan)}}
+	for _, value := range values {
+		row := []interface{}{value}
+		if scan.Filter != nil && !rs.matches(e, row, scan.Filter) {
+			continue
+		}
+		rs.Rows = append(rs.Rows, row)
+	}
+	return rs, nil
+}
+
+// series is the sequence generate_series(start, stop[, step]) returns:
+// integers when every argument is one, floats otherwise.
+type series struct {
+	ints              bool
+	start, stop, step int64
+	fstart, fstop     float64
+	fstep             float64
+	// null is set when an argument is NULL, which makes the series empty.
+	null bool
+}
+
+func parseSeries(args []parser.Value) (*series, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("generate_series takes 2 or 3 arguments, got %d", len(args))
+	}
+	s := &series{ints: true, step: 1, fstep: 1}
+	ints := []*int64{&s.start, &s.stop, &s.step}
+	floats := []*float64{&s.fstart, &s.fstop, &s.fstep}
+	for i, arg := range args {
+		switch arg.Kind {
+		case parser.NullLiteral:
+			s.null = true
+		case parser.IntLiteral:
+			*ints[i] = arg.Literal.(int64)
+			*floats[i] = float64(*ints[i])
+		case parser.FloatLiteral:
+			s.ints = false
+			*floats[i] = arg.Literal.(float64)
+		default:
+			return nil, sqlerr.New(sqlerr.DatatypeMismatch, "generate_series takes numbers, got '%s'", arg.Text)
+		}
+	}
+	if (s.ints && s.step == 0) || (!s.ints && s.fstep == 0) {
+		return nil, fmt.Errorf("step of generate_series cannot be zero")
+	}
+	return s, nil
+}
+
+// len returns the number of values in the series. It may not fit an int.
+func (s *series) len() uint64 {
+	if s.null {
+		return 0
+	}
+	if !s.ints {
+		n := math.Floor((s.fstop-s.fstart)/s.fstep) + 1
+		if n < 1 {
+			return 0
+		}
+		return uint64(n)
+	}
+	// The differences are taken unsigned, where they cannot overflow.
+	switch {
+	case s.step > 0 && s.start <= s.stop:
+		return (uint64(s.stop)-uint64(s.start))/uint64(s.step) + 1
+	case s.step < 0 && s.start >= s.stop:
+		return (uint64(s.start)-uint64(s.stop))/uint64(-s.step) + 1
+	}
+	return 0
+}
+
+func seriesLength(args []parser.Value) (int, error) {
+	s, err := parseSeries(args)
+	if err != nil {
+		return 0, err
+	}
+	if n := s.len(); n < math.MaxInt32 {
+		return int(n), nil
+	}
+	return math.MaxInt32, nil
+}
+
+func seriesValues(e *Engine, args []parser.Value) ([]interface{}, error) {
+	s, err := parseSeries(args)
+	if err != nil {
+		return nil, err
+	}
+	n := s.len()
+	var values []interface{}
+	for i := uint64(0); i < n; i++ {
+		if i%batchSize == 0 {
+			if err := e.checkDeadline(); err != nil {
+				return nil, err
+			}
+		}
+		if s.ints {
+			// Stepping by count rather than comparing with stop keeps the
+			// last step from overflowing.
+			values = append(values, s.start+int64(i)*s.step)
+		} else {
+			values = append(values, s.fstart+float64(i)*s.fstep)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+)
+
+// Tracer starts spans. It mirrors the shape of OpenTelemetry's trace.Tracer
+// so an adapter is a few lines, without the engine depending on it.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// SetTracer installs the tracer for parse, plan and operator spans; nil turns
+// tracing off.
+func (e *Engine) SetTracer(tracer Tracer) {
+	e.tracer = tracer
+}
+
+// span is an active Span. While it is open its context is the parent of new
+// spans; ending it restores the previous one, nil outside any statement. A nil *span does nothing, which
+// is what startSpan returns when tracing is off.
+type span struct {
+	Span
+	e      *Engine
+	parent context.Context
+}
+
+func (e *Engine) startSpan(name string) *span {
+	if e.tracer == nil {
+		return nil
+	}
+
+	parent := e.spanCtx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, s := e.tracer.Start(parent, name)
+	saved := e.spanCtx
+	e.spanCtx = ctx
+	return &span{Span: s, e: e, parent: saved}
+}
+
+func (s *span) set(key string, value interface{}) {
+	if s != nil {
+		s.SetAttribute(key, value)
+	}
+}
+
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.RecordError(err)
+	}
+	s.End()
+	s.e.spanCtx = s.parent
+}
+
+// RunSQL parses and executes sql, tracing the whole statement as a child of
+// ctx. Cancelling ctx aborts the statement, see RunContext.
+func (e *Engine) RunSQL(ctx context.Context, sql string) (string, error) {
+	e.spanCtx = ctx
+	defer func() { e.spanCtx = nil }()
+
+	stmt := e.startSpan("anubisdb.statement")
+	stmt.set("db.system", "anubisdb")
+	stmt.set("db.statement", sql)
+
+	parse := e.startSpan("anubisdb.parse")
+	node, err := parser.Parse(sql)
+	parse.end(err)
+	if err != nil {
+		stmt.end(err)
+		return "", err
+	}
+
+	result, err := e.RunContext(ctx, node)
+	stmt.set("db.rows", e.rowCount)
+	stmt.end(err)
+	return result, err
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+)
+
+type spanKey struct{}
+
+// parentTracer records, for each span it starts, the name of the span or
+// caller context it was started under.
+type parentTracer struct {
+	parents map[string]string
+}
+
+func (t *parentTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(string)
+	t.parents[name] = parent
+	return context.WithValue(ctx, spanKey{}, name), nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(string, interface{}) {}
+func (nopSpan) RecordError(error)                {}
+func (nopSpan) End()                             {}
+
+func TestSpanParent(t *testing.T) {
+	e := newTestEngine(t)
+	newIndexedTable(t, e)
+	tracer := &parentTracer{parents: make(map[string]string)}
+	e.SetTracer(tracer)
+	m := NewSessionManager(e, SessionConfig{})
+	defer m.Close()
+	s, err := m.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := parser.Parse("SELECT id FROM t WHERE n > 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, run := range map[string]func(ctx context.Context) error{
+		"QueryContext": func(ctx context.Context) error {
+			_, err := e.QueryContext(ctx, node)
+			return err
+		},
+		"ExecContext": func(ctx context.Context) error {
+			_, _, err := e.ExecContext(ctx, node)
+			return err
+		},
+		"Session.Query": func(ctx context.Context) error {
+			_, err := s.Query(ctx, node)
+			return err
+		},
+		"Session.Run": func(ctx context.Context) error {
+			_, err := s.Run(ctx, node)
+			return err
+		},
+	} {
+		// A call without a context first, whose spans have no parent,
+		// must not leave one behind for the next.
+		if _, err := e.Run(node); err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.WithValue(context.Background(), spanKey{}, name)
+		if err := run(ctx); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, span := range []string{"anubisdb.plan", "anubisdb.execute"} {
+			if got := tracer.parents[span]; got != name {
+				t.Errorf("%s: %s span started under %q, want the caller's", name, span, got)
+			}
+		}
+	}
+
+	if _, err := e.RunSQL(context.WithValue(context.Background(), spanKey{}, "RunSQL"), "SELECT id FROM t"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tracer.parents["anubisdb.statement"]; got != "RunSQL" {
+		t.Errorf("statement span started under %q, want the caller's", got)
+	}
+	if got := tracer.parents["anubisdb.plan"]; got != "anubisdb.statement" {
+		t.Errorf("RunSQL plan span started under %q, want the statement span", got)
+	}
+}
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kithinjibrian/anubisdb/internal/catalog"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// VirtualTable is a read-only table whose rows come from outside the
+// database, such as a file, a remote service or the embedder's own data.
+// Once registered with RegisterVirtualTable it can be queried, joined and
+// aggregated like a stored table, but not written to or indexed.
+type VirtualTable interface {
+	// Columns names the columns of the table, in the order Scan gives
+	// their values.
+	Columns() []string
+
+	// Scan calls fn with each row of the table. A value may be nil, a
+	// string, a bool, a float, or an integer of any type but uint and
+	// uint64; []byte is read as text. fn must not be kept after Scan returns, and an error from it
+	// must be returned by Scan, which stops the statement.
+	Scan(fn func(row []interface{}) error) error
+}
+
+// RowEstimator is implemented by virtual tables that know roughly how many
+// rows they hold, which the planner uses to order joins. Others are taken
+// to hold defaultVirtualRows.
+type RowEstimator interface {
+	EstimateRows() int
+}
+
+const defaultVirtualRows = 1000
+
+// RegisterVirtualTable makes table queryable under name in every session
+// of the engine. The name must not be taken by a stored table or by
+// another virtual table, and a stored table cannot be created under it
+// while it is registered.
+func (e *Engine) RegisterVirtualTable(name string, table VirtualTable) error {
+	if m := e.sessions; m != nil {
+		m.exec.Lock()
+		defer m.exec.Unlock()
+	}
+
+	if name == "" || strings.Contains(name, ".") {
+		return sqlerr.New(sqlerr.InvalidTableDefinition, "invalid virtual table name '%s'", name)
+	}
+	if len(table.Columns()) == 0 {
+		return sqlerr.New(sqlerr.InvalidTableDefinition, "virtual table '%s' has no columns", name)
+	}
+	if _, exists := e.virtual[name]; exists || e.catalog.TableExists(name) {
+		return sqlerr.New(sqlerr.DuplicateTable, "table '%s' already exists", name)
+	}
+	e.virtual[name] = table
+	e.forgetPlans()
+	return nil
+}
+
+// UnregisterVirtualTable removes the virtual table registered under name.
+func (e *Engine) UnregisterVirtualTable(name string) error {
+	if m := e.sessions; m != nil {
+		m.exec.Lock()
+		defer m.exec.Unlock()
+	}
+
+	if _, exists := e.virtual[name]; !exists {
+		return sqlerr.New(sqlerr.UndefinedTable, "virtual table '%s' does not exist", name)
+	}
+	delete(e.virtual, name)
+	e.forgetPlans()
+	return nil
+}
+
+// virtualSchema describes a virtual table to the planner, which needs its
+// column names to resolve joins.
+func virtualSchema(name string, table VirtualTable) *catalog.Schema {
+	schema := &catalog.Schema{Name: name}
+	for _, col := range table.Columns() {
+		schema.Columns = append(schema.Columns, catalog.Column{Name: col})
+	}
+	return schema
+}
+
+func virtualRows(table VirtualTable) int {
+	if est, ok := table.(RowEstimator); ok {
+		return est.EstimateRows()
+	}
+	return defaultVirtualRows
+}
+
+// scanVirtual reads the rows of the virtual table of scan and keeps the
+// ones that pass its sample and filter.
+func scanVirtual(e *Engine, scan *ScanPlan) (*ResultSet, error) {
+	table, ok := e.virtual[scan.Table]
+	if !ok {
+		return nil, sqlerr.New(sqlerr.UndefinedTable, "virtual table '%s' does not exist", scan.Table)
+	}
+	columns := table.Columns()
+
+	prefix := tablePrefix(scan.Table, scan.Alias)
+	rs := &ResultSet{Schema: make([]string, len(columns))}
+	for i, col := range columns {
+		rs.Schema[i] = prefix + "." + col
+	}
+
+	var keep func() (bool, error)
+	if scan.Sample != nil {
+		keep = sampler(e, scan.Sample)
+	}
+	n := 0
+	err := table.Scan(func(values []interface{}) error {
+		if n%batchSize == 0 {
+			if err := e.checkDeadline(); err != nil {
+				return err
+			}
+		}
+		n++
+		if len(values) != len(columns) {
+			return fmt.Errorf("virtual table '%s' returned %d values for %d columns", scan.Table, len(values), len(columns))
+		}
+		row := make([]interface{}, len(values))
+		for i, v := range values {
+			value, err := virtualValue(v)
+			if err != nil {
+				return fmt.Errorf("virtual table '%s', column '%s': %w", scan.Table, columns[i], err)
+			}
+			row[i] = value
+		}
+		if keep != nil {
+			if ok, err := keep(); err != nil || !ok {
+				return err
+			}
+		}
+		if scan.Filter != nil && !rs.matches(e, row, scan.Filter) {
+			return nil
+		}
+		rs.Rows = append(rs.Rows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// virtualValue converts a value from a virtual table to the types rows
+// hold: int64, float64, string, bool or nil.
+func virtualValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, int64, float64, string, bool:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case float32:
+		return float64(v), nil
+	case []byte:
+		return string(v), nil
+	}
+	return nil, sqlerr.New(sqlerr.DatatypeMismatch, "unsupported value type %T", v)
+}
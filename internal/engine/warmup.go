@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+// SetHotPageTracking turns on or off the counting of page reads in the main
+// and attached databases, and the saving of the hottest pages on Close for
+// Warmup; it is off by default. See storage.Pager.TrackHotPages.
+func (e *Engine) SetHotPageTracking(on bool) {
+	e.hotPages = on
+	e.storage.Pager.TrackHotPages(on)
+	for _, a := range e.attached {
+		a.storage.Pager.TrackHotPages(on)
+	}
+}
+
+// HotPages returns the n pages of the main database read most since
+// SetHotPageTracking turned counting on, hottest first, or all of them when
+// n is 0 or less. Closing the database saves them for Warmup.
+func (e *Engine) HotPages(n int) []storage.PageHeat {
+	return e.storage.Pager.HotPages(n)
+}
+
+// Warmup loads the pages the main and attached databases read most before
+// they were last closed into their page caches, so that the first
+// statements after a restart do not wait on the disk, see
+// storage.Pager.Warmup. It returns the number of pages loaded.
+func (e *Engine) Warmup() (int, error) {
+	if e.closed {
+		return 0, errClosed
+	}
+	endRead, err := e.beginRead()
+	if err != nil {
+		return 0, err
+	}
+	defer endRead()
+
+	loaded, err := e.storage.Pager.Warmup()
+	if err != nil {
+		return loaded, fmt.Errorf("failed to warm up: %w", err)
+	}
+	for alias, a := range e.attached {
+		n, err := a.storage.Pager.Warmup()
+		loaded += n
+		if err != nil {
+			return loaded, fmt.Errorf("failed to warm up %s: %w", alias, err)
+		}
+	}
+	return loaded, nil
+}
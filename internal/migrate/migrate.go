@@ -0,0 +1,260 @@
+// Package migrate evolves a database's schema with numbered SQL scripts. A
+// migration is a pair of files in one directory, VERSION_NAME.up.sql and
+// VERSION_NAME.down.sql, such as 0001_create_users.up.sql; the down script
+// undoes the up one and may be left out. Statements in a script end with
+// a semicolon and may span lines, see parser.SplitScript.
+//
+// The versions applied are recorded in the anubis_migrations table. Each
+// migration runs in one batch with the row that records it, see
+// engine.Engine.ExecuteBatch, so a migration that fails leaves neither its
+// changes nor its row behind.
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kithinjibrian/anubisdb/internal/engine"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
+
+// Table is the table recording the migrations applied.
+const Table = "anubis_migrations"
+
+type Migration struct {
+	Version int64
+	Name    string
+	// Up and Down hold the statements of each script. Down is nil when
+	// there is no down script.
+	Up, Down []string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in dir of fsys, such as an os.DirFS or an
+// embed.FS, in order of version. Files not ending in .sql are ignored. Every
+// migration needs an up script, and no two may share a version.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%s: migration files are named VERSION_NAME.up.sql or VERSION_NAME.down.sql", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid version: %w", entry.Name(), err)
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("%s: version %d is also %s", entry.Name(), version, m)
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		statements := parser.SplitScript(string(data))
+		if match[3] == "up" {
+			m.Up = statements
+		} else {
+			m.Down = statements
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == nil {
+			return nil, fmt.Errorf("migration %s has no up script", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Status is a migration and when it was applied.
+type Status struct {
+	Migration
+	// AppliedAt is empty for a pending migration.
+	AppliedAt string
+	// Missing is set for a version the database records but the
+	// directory no longer has; only its version and name are known.
+	Missing bool
+}
+
+// Runner applies migrations to a database.
+type Runner struct {
+	db         *engine.Engine
+	migrations []Migration
+
+	// Now gives the time recorded for each migration applied.
+	Now func() time.Time
+}
+
+func NewRunner(db *engine.Engine, migrations []Migration) *Runner {
+	return &Runner{db: db, migrations: migrations, Now: time.Now}
+}
+
+// Status lists every migration, applied or not, by version.
+func (r *Runner) Status() ([]Status, error) {
+	applied, err := r.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Status
+	for _, m := range r.migrations {
+		s := Status{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			s.AppliedAt = a.AppliedAt
+			delete(applied, m.Version)
+		}
+		list = append(list, s)
+	}
+	for _, a := range applied {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Up applies up to steps pending migrations, or all of them when steps is
+// 0 or less, in order of version, and returns those applied. It stops at
+// the first that fails; the ones before it stay applied.
+func (r *Runner) Up(steps int) ([]Migration, error) {
+	if err := r.createTable(); err != nil {
+		return nil, err
+	}
+	applied, err := r.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range r.migrations {
+		if steps > 0 && len(done) == steps {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		record := fmt.Sprintf("INSERT INTO %s VALUES (%d, '%s', '%s')",
+			Table, m.Version, m.Name, r.Now().UTC().Format(time.RFC3339))
+		if err := r.run(m, m.Up, record); err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Down reverts up to steps applied migrations, or all of them when steps is
+// 0 or less, newest first, and returns those reverted. It stops at the
+// first that fails or has no down script.
+func (r *Runner) Down(steps int) ([]Migration, error) {
+	status, err := r.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(status) - 1; i >= 0; i-- {
+		if steps > 0 && len(done) == steps {
+			break
+		}
+		s := status[i]
+		if s.AppliedAt == "" {
+			continue
+		}
+		if s.Missing {
+			return done, fmt.Errorf("migration %s is applied but not found", s.Migration)
+		}
+		if s.Down == nil {
+			return done, fmt.Errorf("migration %s has no down script", s.Migration)
+		}
+		forget := fmt.Sprintf("DELETE FROM %s WHERE version = %d", Table, s.Version)
+		if err := r.run(s.Migration, s.Down, forget); err != nil {
+			return done, err
+		}
+		done = append(done, s.Migration)
+	}
+	return done, nil
+}
+
+// run runs a script of m and the statement that records it in one batch.
+func (r *Runner) run(m Migration, script []string, record string) error {
+	statements := append(append([]string{}, script...), record)
+	if _, err := r.db.ExecuteBatch(statements); err != nil {
+		return fmt.Errorf("migration %s: %w", m, err)
+	}
+	return nil
+}
+
+func (r *Runner) createTable() error {
+	create := fmt.Sprintf("CREATE TABLE %s (version INT PRIMARY KEY, name TEXT, applied_at TEXT)", Table)
+	node, err := parser.Parse(create)
+	if err != nil {
+		return err
+	}
+	if _, _, err := r.db.Exec(node); err != nil && !sqlerr.IsConflict(err) {
+		return fmt.Errorf("failed to create %s: %w", Table, err)
+	}
+	return nil
+}
+
+// applied reads the migrations the database records, by version. A
+// database without the table has applied none.
+func (r *Runner) applied() (map[int64]Status, error) {
+	node, err := parser.Parse(fmt.Sprintf("SELECT version, name, applied_at FROM %s", Table))
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := r.db.Query(node)
+	if sqlerr.CodeOf(err) == sqlerr.UndefinedTable {
+		return map[int64]Status{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", Table, err)
+	}
+	defer cursor.Close()
+
+	applied := make(map[int64]Status)
+	for _, row := range cursor.Fetch(0) {
+		version, err := strconv.ParseInt(fmt.Sprint(row[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s holds an invalid version: %w", Table, err)
+		}
+		name, _ := row[1].(string)
+		appliedAt, _ := row[2].(string)
+		applied[version] = Status{
+			Migration: Migration{Version: version, Name: name},
+			AppliedAt: appliedAt,
+			Missing:   true,
+		}
+	}
+	return applied, nil
+}
@@ -0,0 +1,62 @@
+package migrate
+
+import (
+	"fmt"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/kithinjibrian/anubisdb/internal/engine"
+	"github.com/kithinjibrian/anubisdb/internal/parser"
+	"github.com/kithinjibrian/anubisdb/internal/storage"
+)
+
+func TestMultiLineMigration(t *testing.T) {
+	scripts := fstest.MapFS{
+		"migrations/0001_create_users.up.sql": {Data: []byte(`-- users and their names
+CREATE TABLE users (
+  id INT PRIMARY KEY,
+  name TEXT
+);
+CREATE INDEX idx_users_name
+  ON users (name);
+INSERT INTO users VALUES (1, 'a;b'); INSERT INTO users VALUES (2, 'c')
+`)},
+		"migrations/0001_create_users.down.sql": {Data: []byte("DROP INDEX idx_users_name;\nDELETE FROM users;\n")},
+	}
+	migrations, err := Load(scripts, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(migrations[0].Up); got != 4 {
+		t.Fatalf("up script split into %d statements, want 4: %q", got, migrations[0].Up)
+	}
+
+	db, err := engine.OpenEngine(storage.NewMemFS(), "test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	runner := NewRunner(db, migrations)
+	runner.Now = func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) }
+	if _, err := runner.Up(0); err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := parser.Parse("SELECT id, name FROM users ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := db.Query(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cursor.Close()
+	if got, want := fmt.Sprint(cursor.Fetch(0)), "[[1 a;b] [2 c]]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if _, err := runner.Down(0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,220 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expr is a scalar expression in a select list or on the left-hand side of
+// a condition. String renders the canonical text that names its result
+// column.
+type Expr interface {
+	String() string
+}
+
+type ColumnExpr struct {
+	Name string
+}
+
+func (c *ColumnExpr) String() string {
+	return c.Name
+}
+
+type LiteralExpr struct {
+	Value  string
+	Quoted bool
+	Null   bool
+}
+
+func (l *LiteralExpr) String() string {
+	if l.Null {
+		return "NULL"
+	}
+	if l.Quoted {
+		return "'" + l.Value + "'"
+	}
+	return l.Value
+}
+
+type FuncExpr struct {
+	Name string
+	Args []Expr
+}
+
+func (f *FuncExpr) String() string {
+	args := make([]string, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", f.Name, strings.Join(args, ", "))
+}
+
+// JSONPathExpr is doc->path, which yields the JSON text at path, or
+// doc->>path, which yields it as a plain SQL value.
+type JSONPathExpr struct {
+	Expr   Expr
+	Path   Expr
+	AsText bool
+}
+
+func (j *JSONPathExpr) String() string {
+	op := "->"
+	if j.AsText {
+		op = "->>"
+	}
+	return j.Expr.String() + op + j.Path.String()
+}
+
+type CastExpr struct {
+	Expr Expr
+	Type string
+}
+
+func (c *CastExpr) String() string {
+	return fmt.Sprintf("CAST(%s AS %s)", c.Expr.String(), c.Type)
+}
+
+// isCallStart reports whether the current token opens a CAST or scalar
+// function call rather than naming a column.
+func (p *Parser) isCallStart() bool {
+	return p.curTok.Type == IDENTIFIER && p.peekTok.Type == LPAREN && !IsAggregate(p.curTok.Literal)
+}
+
+func (p *Parser) parseExpr() (Expr, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, fmt.Errorf("expression nested too deeply")
+	}
+
+	expr, err := p.parsePrimaryExpr()
+	if err != nil {
+		return nil, err
+	}
+	return p.parsePostfix(expr)
+}
+
+func (p *Parser) curIsArrow() bool {
+	return p.curTok.Type == OPERATOR && (p.curTok.Literal == "->" || p.curTok.Literal == "->>")
+}
+
+// parsePostfix applies any -> and ->> operators following expr.
+func (p *Parser) parsePostfix(expr Expr) (Expr, error) {
+	for p.curIsArrow() {
+		asText := p.curTok.Literal == "->>"
+		p.nextToken()
+
+		var path Expr
+		switch p.curTok.Type {
+		case STRING:
+			path = &LiteralExpr{Value: p.curTok.Literal, Quoted: true}
+		case NUMBER:
+			path = &LiteralExpr{Value: p.curTok.Literal}
+		default:
+			return nil, fmt.Errorf("expected JSON path after arrow, got %s", p.curTok.Literal)
+		}
+		p.nextToken()
+
+		expr = &JSONPathExpr{Expr: expr, Path: path, AsText: asText}
+	}
+	return expr, nil
+}
+
+func (p *Parser) parsePrimaryExpr() (Expr, error) {
+	switch {
+	case p.curTok.Type == STRING:
+		lit := &LiteralExpr{Value: p.curTok.Literal, Quoted: true}
+		p.nextToken()
+		return lit, nil
+
+	case p.curTok.Type == NUMBER:
+		lit := &LiteralExpr{Value: p.curTok.Literal}
+		p.nextToken()
+		return lit, nil
+
+	case p.curKeywordIs("NULL"):
+		p.nextToken()
+		return &LiteralExpr{Null: true}, nil
+
+	case p.isCallStart() && strings.EqualFold(p.curTok.Literal, "CAST"):
+		return p.parseCast()
+
+	case p.isCallStart():
+		return p.parseFuncCall()
+
+	case p.curTok.Type == IDENTIFIER:
+		name := p.curTok.Literal
+		p.nextToken()
+
+		if p.curTok.Type == DOT {
+			p.nextToken()
+			if p.curTok.Type != IDENTIFIER {
+				return nil, fmt.Errorf("expected column name after dot, got %s", p.curTok.Literal)
+			}
+			name = name + "." + p.curTok.Literal
+			p.nextToken()
+		}
+		return &ColumnExpr{Name: name}, nil
+	}
+
+	return nil, fmt.Errorf("expected expression, got %s", p.curTok.Literal)
+}
+
+func (p *Parser) parseCast() (Expr, error) {
+	p.nextToken()
+	p.nextToken()
+
+	inner, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.curKeywordIs("AS") {
+		return nil, fmt.Errorf("expected AS in CAST, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	if p.curTok.Type != KEYWORD && p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected type name in CAST, got %s", p.curTok.Literal)
+	}
+	typeName := strings.ToUpper(p.curTok.Literal)
+	p.nextToken()
+
+	if p.curTok.Type != RPAREN {
+		return nil, fmt.Errorf("expected ) after CAST, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	return &CastExpr{Expr: inner, Type: typeName}, nil
+}
+
+func (p *Parser) parseFuncCall() (Expr, error) {
+	fn := &FuncExpr{Name: strings.ToUpper(p.curTok.Literal)}
+	p.nextToken()
+	p.nextToken()
+
+	if p.curTok.Type == RPAREN {
+		p.nextToken()
+		return fn, nil
+	}
+
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		fn.Args = append(fn.Args, arg)
+
+		if p.curTok.Type != COMMA {
+			break
+		}
+		p.nextToken()
+	}
+
+	if p.curTok.Type != RPAREN {
+		return nil, fmt.Errorf("expected ) after arguments to %s, got %s", fn.Name, p.curTok.Literal)
+	}
+	p.nextToken()
+
+	return fn, nil
+}
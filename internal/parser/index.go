@@ -0,0 +1,102 @@
+package parser
+
+import "fmt"
+
+// DropIndexStmt is DROP INDEX [IF EXISTS] name.
+type DropIndexStmt struct {
+	Name     string
+	IfExists bool
+}
+
+func (d *DropIndexStmt) String() string {
+	if d.IfExists {
+		return fmt.Sprintf("DROP INDEX IF EXISTS %s", d.Name)
+	}
+	return fmt.Sprintf("DROP INDEX %s", d.Name)
+}
+
+// RenameIndexStmt is ALTER INDEX [IF EXISTS] name RENAME TO new_name. The
+// index stays in its database, so NewName is never qualified.
+type RenameIndexStmt struct {
+	Name     string
+	NewName  string
+	IfExists bool
+}
+
+func (r *RenameIndexStmt) String() string {
+	ifExists := ""
+	if r.IfExists {
+		ifExists = "IF EXISTS "
+	}
+	return fmt.Sprintf("ALTER INDEX %s%s RENAME TO %s", ifExists, r.Name, r.NewName)
+}
+
+// parseIfExists skips IF EXISTS and reports whether it was there.
+func (p *Parser) parseIfExists() (bool, error) {
+	if !p.curWordIs("IF") {
+		return false, nil
+	}
+	p.nextToken()
+	if !p.curWordIs("EXISTS") {
+		return false, fmt.Errorf("expected EXISTS after IF, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+	return true, nil
+}
+
+// parseIndexName reads an index name, qualified by a database alias for an
+// index of an attached database.
+func (p *Parser) parseIndexName() (string, error) {
+	if p.curTok.Type != IDENTIFIER {
+		return "", fmt.Errorf("expected index name, got %s", p.curTok.Literal)
+	}
+	return p.parseTableName()
+}
+
+func (p *Parser) parseDropIndex() (*DropIndexStmt, error) {
+	p.nextToken()
+
+	ifExists, err := p.parseIfExists()
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.parseIndexName()
+	if err != nil {
+		return nil, err
+	}
+	return &DropIndexStmt{Name: name, IfExists: ifExists}, nil
+}
+
+func (p *Parser) parseAlter() (Node, error) {
+	p.nextToken()
+
+	if !p.curKeywordIs("INDEX") {
+		return nil, fmt.Errorf("expected INDEX after ALTER, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	ifExists, err := p.parseIfExists()
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.parseIndexName()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.curWordIs("RENAME") {
+		return nil, fmt.Errorf("expected RENAME TO after index name, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+	if !p.curWordIs("TO") {
+		return nil, fmt.Errorf("expected TO after RENAME, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected new index name, got %s", p.curTok.Literal)
+	}
+	stmt := &RenameIndexStmt{Name: name, NewName: p.curTok.Literal, IfExists: ifExists}
+	p.nextToken()
+	return stmt, nil
+}
@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CreateJobStmt is CREATE JOB name SCHEDULE 'cron' AS statement. Statement
+// is kept as written and parsed again each time the job runs.
+type CreateJobStmt struct {
+	Name      string
+	Schedule  string
+	Statement string
+}
+
+func (c *CreateJobStmt) String() string {
+	return fmt.Sprintf("CREATE JOB %s SCHEDULE '%s' AS %s", c.Name, c.Schedule, c.Statement)
+}
+
+type DropJobStmt struct {
+	Name string
+}
+
+func (d *DropJobStmt) String() string {
+	return fmt.Sprintf("DROP JOB %s", d.Name)
+}
+
+func (p *Parser) parseCreateJob() (*CreateJobStmt, error) {
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected job name, got %s", p.curTok.Literal)
+	}
+	stmt := &CreateJobStmt{Name: p.curTok.Literal}
+	p.nextToken()
+
+	if !p.curWordIs("SCHEDULE") {
+		return nil, fmt.Errorf("expected SCHEDULE after job name, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+	if p.curTok.Type != STRING {
+		return nil, fmt.Errorf("expected quoted schedule, got %s", p.curTok.Literal)
+	}
+	stmt.Schedule = p.curTok.Literal
+	p.nextToken()
+
+	if !p.curKeywordIs("AS") {
+		return nil, fmt.Errorf("expected AS before the job's statement, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	start := p.curTok.Pos
+	depth := 0
+	for p.curTok.Type != EOF && (depth > 0 || p.curTok.Type != SEMICOLON) {
+		switch p.curTok.Type {
+		case LPAREN:
+			depth++
+		case RPAREN:
+			depth--
+		}
+		p.nextToken()
+	}
+	stmt.Statement = strings.TrimSpace(p.lexer.input[start:p.curTok.Pos])
+
+	node, err := Parse(stmt.Statement)
+	if err != nil {
+		return nil, err
+	}
+	switch node.(type) {
+	case *CreateJobStmt, *CreateProcedureStmt:
+		return nil, fmt.Errorf("a job cannot create a job or a procedure; CALL a procedure instead")
+	}
+	return stmt, nil
+}
+
+func (p *Parser) parseDropJob() (*DropJobStmt, error) {
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected job name, got %s", p.curTok.Literal)
+	}
+	stmt := &DropJobStmt{Name: p.curTok.Literal}
+	p.nextToken()
+	return stmt, nil
+}
@@ -1,8 +1,10 @@
 package parser
 
 import (
+	"fmt"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type TokenType int
@@ -20,12 +22,15 @@ const (
 	LPAREN
 	RPAREN
 	ASTERISK
+	ILLEGAL
 )
 
 type Token struct {
 	Type    TokenType
 	Value   string
 	Literal string
+	// Pos is the offset in the input of the token's first byte.
+	Pos int
 }
 
 type Lexer struct {
@@ -33,6 +38,7 @@ type Lexer struct {
 	pos     int
 	readPos int
 	ch      byte
+	err     error // first malformed input seen, reported by Parse
 }
 
 func NewLexer(input string) *Lexer {
@@ -58,9 +64,19 @@ func (l *Lexer) peekChar() byte {
 	return l.input[l.readPos]
 }
 
+// skipWhitespace also skips -- comments, which run to the end of the line.
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
-		l.readChar()
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+		case l.ch == '-' && l.peekChar() == '-':
+			for l.ch != '\n' && l.pos < len(l.input) {
+				l.readChar()
+			}
+		default:
+			return
+		}
 	}
 }
 
@@ -80,25 +96,50 @@ func (l *Lexer) readNumber() string {
 	return l.input[pos:l.pos]
 }
 
-func (l *Lexer) readString() string {
+// readString reads a quoted string. ok is false when the input ends before
+// the closing quote.
+func (l *Lexer) readString() (s string, ok bool) {
 	quote := l.ch
 	l.readChar()
 	pos := l.pos
-	for l.ch != quote && l.ch != 0 {
+	for l.ch != quote && l.pos < len(l.input) {
 		l.readChar()
 	}
+	if l.pos >= len(l.input) {
+		return l.input[pos:], false
+	}
 	result := l.input[pos:l.pos]
 	l.readChar()
-	return result
+	return result, true
 }
 
-func (l *Lexer) NextToken() Token {
-	var tok Token
+// illegal consumes the current byte and returns it as an ILLEGAL token, so
+// the lexer always makes progress on bytes it does not understand.
+func (l *Lexer) illegal() Token {
+	literal := l.input[l.pos:l.readPos]
+	if l.err == nil {
+		l.err = fmt.Errorf("unexpected character %q at position %d", literal, l.pos)
+	}
+	l.readChar()
+	return Token{Type: ILLEGAL, Literal: literal}
+}
 
+func (l *Lexer) NextToken() Token {
 	l.skipWhitespace()
+	pos := l.pos
+	tok := l.readToken()
+	tok.Pos = pos
+	return tok
+}
+
+func (l *Lexer) readToken() Token {
+	var tok Token
 
 	switch l.ch {
 	case 0:
+		if l.pos < len(l.input) {
+			return l.illegal()
+		}
 		tok = Token{Type: EOF, Literal: ""}
 	case '*':
 		tok = Token{Type: ASTERISK, Literal: string(l.ch)}
@@ -120,16 +161,45 @@ func (l *Lexer) NextToken() Token {
 		l.readChar()
 	case '=', '!', '<', '>':
 		op := string(l.ch)
-		if l.peekChar() == '=' {
+		if l.peekChar() == '=' || (l.ch == '!' && l.peekChar() == '~') {
 			l.readChar()
 			op += string(l.ch)
 		}
 		tok = Token{Type: OPERATOR, Literal: op}
 		l.readChar()
+	case '~':
+		tok = Token{Type: OPERATOR, Literal: string(l.ch)}
+		l.readChar()
+	case '-':
+		if isDigit(l.peekChar()) {
+			pos := l.pos
+			l.readChar()
+			l.readNumber()
+			return Token{Type: NUMBER, Literal: l.input[pos:l.pos]}
+		}
+		if l.peekChar() != '>' {
+			return l.illegal()
+		}
+		l.readChar()
+		op := "->"
+		if l.peekChar() == '>' {
+			l.readChar()
+			op = "->>"
+		}
+		tok = Token{Type: OPERATOR, Literal: op}
+		l.readChar()
 	case '\'', '"':
-		tok = Token{Type: STRING, Literal: l.readString()}
+		pos := l.pos
+		literal, ok := l.readString()
+		if !ok {
+			if l.err == nil {
+				l.err = fmt.Errorf("unterminated string starting at position %d", pos)
+			}
+			return Token{Type: ILLEGAL, Literal: l.input[pos:]}
+		}
+		tok = Token{Type: STRING, Literal: literal}
 	default:
-		if isLetter(l.ch) {
+		if isLetter(l.ch) || l.ch == '_' {
 			literal := l.readIdentifier()
 			tok = Token{Literal: literal}
 			if isKeyword(literal) {
@@ -144,16 +214,17 @@ func (l *Lexer) NextToken() Token {
 			tok = Token{Type: NUMBER, Literal: l.readNumber()}
 			return tok
 		} else {
-			tok = Token{Type: EOF, Literal: string(l.ch)}
-			l.readChar()
+			return l.illegal()
 		}
 	}
 
 	return tok
 }
 
+// isLetter treats every non-ASCII byte as a letter so UTF-8 identifiers
+// lex as a single token.
 func isLetter(ch byte) bool {
-	return unicode.IsLetter(rune(ch))
+	return ch >= utf8.RuneSelf || unicode.IsLetter(rune(ch))
 }
 
 func isDigit(ch byte) bool {
@@ -168,7 +239,9 @@ func isKeyword(s string) bool {
 		"INT", "PRIMARY", "KEY", "VARCHAR", "TEXT", "INDEX",
 		"UNIQUE", "INNER", "LEFT", "RIGHT", "FULL", "OUTER",
 		"DISTINCT", "GROUP", "HAVING", "ASC", "DESC", "OFFSET",
-		"FLOAT",
+		"FLOAT", "ANALYZE", "BOOLEAN", "NOT", "NULL", "AUTO_INCREMENT",
+		"ATTACH", "DETACH", "DATABASE", "SCHEMA",
+		"GRANT", "REVOKE",
 	}
 	upper := strings.ToUpper(s)
 	for _, kw := range keywords {
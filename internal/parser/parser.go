@@ -2,8 +2,13 @@ package parser
 
 /*
 statement     = select_stmt | insert_stmt | delete_stmt | create_table_stmt | update_stmt | create_index_stmt
+              | create_schema_stmt | analyze_stmt | attach_stmt | detach_stmt
+              | create_user_stmt | grant_stmt | revoke_stmt
+              | create_policy_stmt | drop_policy_stmt | set_stmt | show_stmt
+              | kill_stmt | explain_stmt | create_procedure_stmt | drop_procedure_stmt | call_stmt
+              | create_job_stmt | drop_job_stmt | drop_index_stmt | alter_index_stmt
 
-select_stmt   = "SELECT" [ "DISTINCT" ] column_list "FROM" table_ref
+select_stmt   = "SELECT" [ "DISTINCT" ] select_list "FROM" table_ref
                 [ join_clause ]
                 [ where_clause ]
                 [ group_by_clause ]
@@ -11,35 +16,119 @@ select_stmt   = "SELECT" [ "DISTINCT" ] column_list "FROM" table_ref
                 [ order_by_clause ]
                 [ limit_clause ]
 
-insert_stmt   = "INSERT" "INTO" identifier [ "(" column_list ")" ] "VALUES" "(" value_list ")"
+insert_stmt   = "INSERT" "INTO" table_name [ "(" column_list ")" ] "VALUES" "(" value_list ")"
 
-delete_stmt   = "DELETE" "FROM" identifier [ where_clause ]
+delete_stmt   = "DELETE" "FROM" table_name [ where_clause ] [ order_by_clause ] [ limit_clause ]
 
-update_stmt   = "UPDATE" identifier "SET" assignment_list [ where_clause ]
+update_stmt   = "UPDATE" table_name "SET" assignment_list [ where_clause ] [ order_by_clause ] [ limit_clause ]
 
-create_table_stmt = "CREATE" "TABLE" identifier "(" column_def { "," column_def } ")"
+create_table_stmt = "CREATE" "TABLE" table_name "(" column_def { "," column_def } { "," table_constraint } ")"
+                    { table_option }
 
-create_index_stmt = "CREATE" [ "UNIQUE" ] "INDEX" identifier "ON" identifier "(" column_list ")"
+table_option  = "ENGINE" [ "=" ] identifier | "CLUSTER" "BY" "(" column_list ")" | storage_params
 
-table_ref     = identifier [ [ "AS" ] identifier ]
+create_index_stmt = "CREATE" [ "UNIQUE" ] "INDEX" [ "CONCURRENTLY" ] identifier "ON" table_name "(" column_list ")"
+                    [ storage_params ]
 
-join_clause   = join_type "JOIN" table_ref "ON" condition
+storage_params = "WITH" "(" storage_param { "," storage_param } ")"
+storage_param  = "FILLFACTOR" "=" number | "BLOOM_FILTER" "=" ( "ON" | "OFF" | "TRUE" | "FALSE" )
 
-join_type     = [ "INNER" | "LEFT" | "RIGHT" | "FULL" ]
+create_schema_stmt = "CREATE" "SCHEMA" [ identifier "." ] identifier
 
-where_clause  = "WHERE" condition { ( "AND" | "OR" ) condition }
+analyze_stmt  = "ANALYZE" [ table_name ]
+
+attach_stmt   = "ATTACH" [ "DATABASE" ] string "AS" identifier
+
+detach_stmt   = "DETACH" [ "DATABASE" ] identifier
+
+create_user_stmt = "CREATE" "USER" identifier "WITH" "PASSWORD" string
+
+grant_stmt    = "GRANT" privilege_list "ON" ( table_name | "*" ) "TO" identifier
+
+revoke_stmt   = "REVOKE" privilege_list "ON" ( table_name | "*" ) "FROM" identifier
+
+create_policy_stmt = "CREATE" "POLICY" identifier "ON" table_name [ "FOR" identifier ]
+                     "USING" "(" condition { "AND" condition } ")"
+
+drop_policy_stmt = "DROP" "POLICY" identifier "ON" table_name
+
+set_stmt      = "SET" identifier ( "=" | "TO" ) value
+
+show_stmt     = "SHOW" ( "ALL" | "PROCESSLIST" | "JOBS" | identifier )
+
+kill_stmt     = "KILL" [ "QUERY" ] number
+
+explain_stmt  = "EXPLAIN" [ "VERBOSE" ] ( select_stmt | insert_stmt | update_stmt | delete_stmt )
+
+create_procedure_stmt = "CREATE" "PROCEDURE" identifier "(" [ column_def { "," column_def } ] ")"
+                        [ "AS" ] "BEGIN" { proc_stmt } "END"
+
+proc_stmt     = "DECLARE" identifier data_type [ ( "DEFAULT" | "=" ) value ] ";"
+              | "SET" identifier "=" ( value | subquery ) ";"
+              | "IF" proc_conds "THEN" { proc_stmt } { "ELSIF" proc_conds "THEN" { proc_stmt } }
+                [ "ELSE" { proc_stmt } ] "END" "IF" ";"
+              | "FOR" identifier "IN" ( select_stmt | subquery ) "LOOP" { proc_stmt } "END" "LOOP" ";"
+              | statement ";"
+
+proc_conds    = proc_cond { "AND" proc_cond }
+
+proc_cond     = condition | [ "NOT" ] "EXISTS" subquery
+
+drop_procedure_stmt = "DROP" "PROCEDURE" identifier
+
+call_stmt     = "CALL" identifier "(" [ value { "," value } ] ")"
+
+create_job_stmt = "CREATE" "JOB" identifier "SCHEDULE" string "AS" statement
+
+drop_job_stmt = "DROP" "JOB" identifier
+
+drop_index_stmt = "DROP" "INDEX" [ "IF" "EXISTS" ] index_name
+
+alter_index_stmt = "ALTER" "INDEX" [ "IF" "EXISTS" ] index_name "RENAME" "TO" identifier
+
+index_name    = [ identifier "." ] identifier
+
+privilege_list = ( "ALL" | privilege { "," privilege } )
+
+privilege     = "SELECT" | "INSERT" | "UPDATE" | "DELETE" | "DDL"
+
+table_name    = [ identifier "." ] [ identifier "." ] identifier
+
+table_ref     = table_name [ "(" [ value { "," value } ] ")" ] [ [ "AS" ] identifier ] [ index_hint ] [ tablesample ]
+
+index_hint    = ( "USE" | "IGNORE" ) "INDEX" "(" identifier { "," identifier } ")"
+
+tablesample   = "TABLESAMPLE" "BERNOULLI" "(" number ")" [ "REPEATABLE" "(" number ")" ]
+
+join_clause   = join_type "JOIN" table_ref ( "ON" condition | "USING" "(" identifier { "," identifier } ")" )
+              | "NATURAL" join_type "JOIN" table_ref
+
+join_type     = [ "INNER" | ( "LEFT" | "RIGHT" | "FULL" ) [ "OUTER" ] ]
+
+where_clause  = "WHERE" predicate
+
+predicate     = conjunction { "OR" conjunction }
+
+conjunction   = negation { "AND" negation }
+
+negation      = "NOT" negation | "(" predicate ")" | condition
 
 group_by_clause = "GROUP" "BY" column_list
 
-having_clause = "HAVING" condition { ( "AND" | "OR" ) condition }
+having_clause = "HAVING" predicate
+
+aggregate     = ( "COUNT" "(" "*" ")" ) | ( "COUNT" | "SUM" | "AVG" | "MIN" | "MAX" ) "(" identifier ")"
 
 order_by_clause = "ORDER" "BY" order_item { "," order_item }
 
 order_item    = identifier [ "ASC" | "DESC" ]
 
-limit_clause  = "LIMIT" number [ "OFFSET" number ]
+limit_clause  = "LIMIT" number [ "OFFSET" number | "," number ] | "OFFSET" number [ "LIMIT" number ]
+
+condition     = ( identifier | aggregate | expr ) ( operator value | like_op value [ "ESCAPE" string ] | [ "NOT" ] "IN" subquery )
+              | [ "NOT" ] "EXISTS" subquery
 
-condition     = identifier operator value
+subquery      = "(" select_stmt ")"
 
 assignment_list = assignment { "," assignment }
 
@@ -47,19 +136,41 @@ assignment    = identifier "=" value
 
 column_list   = ( "*" | identifier { "," identifier } )
 
+select_list   = ( "*" | select_item { "," select_item } )
+
+select_item   = ( identifier | aggregate | expr ) [ [ "AS" ] identifier ]
+
+expr          = primary { ( "->" | "->>" ) ( string | number ) }
+
+primary       = identifier | value | "CAST" "(" expr "AS" data_type ")" | identifier "(" [ expr { "," expr } ] ")"
+
 value_list    = value { "," value }
 
 column_def    = identifier data_type { constraint }
 
 constraint    = "PRIMARY" "KEY" | "UNIQUE" | "NOT" "NULL" | "AUTO_INCREMENT"
 
-value         = string | number | identifier
-operator      = "=" | "!=" | "<" | ">" | "<=" | ">=" | "LIKE" | "IN"
-data_type     = "INT" | "VARCHAR" | "TEXT" | "BOOLEAN" | "DATE" | "DECIMAL" | "FLOAT"
+table_constraint = "UNIQUE" "(" column_list ")"
+
+value         = string | number | "TRUE" | "FALSE" | "NULL" | identifier
+operator      = "=" | "!=" | "<" | ">" | "<=" | ">=" | "~" | "!~" | [ "NOT" ] "REGEXP"
+like_op       = [ "NOT" ] ( "LIKE" | "ILIKE" )
+json_operator = "->" | "->>"
+data_type     = "INT" | "VARCHAR" | "TEXT" | "BOOLEAN" | "FLOAT" | "JSON" | enum_type | identifier
+enum_type     = "ENUM" "(" string { "," string } ")"
 identifier    = letter { letter | digit | "_" }
 */
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kithinjibrian/anubisdb/pkg/sqlerr"
+)
 
 type Node interface {
 	String() string
@@ -68,6 +179,8 @@ type Node interface {
 type SelectStmt struct {
 	Distinct bool
 	Columns  []string
+	Exprs    []Expr   // aligned with Columns; nil for plain columns and aggregates
+	Aliases  []string // aligned with Columns; "" keeps a column's name, nil without aliases
 	Table    *TableRef
 	Joins    []*JoinClause
 	Where    *WhereClause
@@ -82,14 +195,24 @@ func (s *SelectStmt) String() string {
 	if s.Distinct {
 		result += "DISTINCT "
 	}
-	result += fmt.Sprintf("%v FROM %s", s.Columns, s.Table)
+	columns := s.Columns
+	if s.Aliases != nil {
+		columns = make([]string, len(s.Columns))
+		for i, col := range s.Columns {
+			columns[i] = col
+			if s.Aliases[i] != "" {
+				columns[i] += " AS " + s.Aliases[i]
+			}
+		}
+	}
+	result += fmt.Sprintf("%v FROM %s", columns, s.Table)
 
 	for _, join := range s.Joins {
 		result += fmt.Sprintf(" %s", join)
 	}
 
 	if s.Where != nil {
-		result += fmt.Sprintf(" WHERE %v", s.Where.Conditions)
+		result += fmt.Sprintf(" WHERE %v", s.Where)
 	}
 
 	if len(s.GroupBy) > 0 {
@@ -97,7 +220,7 @@ func (s *SelectStmt) String() string {
 	}
 
 	if s.Having != nil {
-		result += fmt.Sprintf(" HAVING %v", s.Having.Conditions)
+		result += fmt.Sprintf(" HAVING %v", s.Having)
 	}
 
 	if len(s.OrderBy) > 0 {
@@ -112,21 +235,95 @@ func (s *SelectStmt) String() string {
 }
 
 type TableRef struct {
-	Name  string
-	Alias string
+	Name   string
+	Alias  string
+	Hint   *IndexHint
+	Sample *TableSample
+
+	// Function is set for a call of a table function, such as
+	// generate_series(1, 10), with Name the function and Args its
+	// arguments.
+	Function bool
+	Args     []Value
 }
 
 func (t *TableRef) String() string {
+	result := t.Name
+	if t.Function {
+		args := make([]string, len(t.Args))
+		for i, arg := range t.Args {
+			args[i] = arg.String()
+		}
+		result += "(" + strings.Join(args, ", ") + ")"
+	}
 	if t.Alias != "" {
-		return fmt.Sprintf("%s AS %s", t.Name, t.Alias)
+		result += fmt.Sprintf(" AS %s", t.Alias)
+	}
+	if t.Hint != nil {
+		result += fmt.Sprintf(" %s", t.Hint)
+	}
+	if t.Sample != nil {
+		result += fmt.Sprintf(" %s", t.Sample)
+	}
+	return result
+}
+
+// TableSample reads a sample of a table: each row is kept with a chance of
+// Percent in 100. With Repeatable, the sample is drawn from Seed and is the
+// same for as long as the table is unchanged.
+type TableSample struct {
+	Percent    float64
+	Repeatable bool
+	Seed       int64
+}
+
+func (s *TableSample) String() string {
+	result := fmt.Sprintf("TABLESAMPLE BERNOULLI (%s)", strconv.FormatFloat(s.Percent, 'g', -1, 64))
+	if s.Repeatable {
+		result += fmt.Sprintf(" REPEATABLE (%d)", s.Seed)
+	}
+	return result
+}
+
+// IndexHint limits the indexes a query may read a table through: only
+// Indexes with USE INDEX, any but them with IGNORE INDEX.
+type IndexHint struct {
+	Ignore  bool
+	Indexes []string
+}
+
+func (h *IndexHint) String() string {
+	verb := "USE"
+	if h.Ignore {
+		verb = "IGNORE"
+	}
+	return fmt.Sprintf("%s INDEX (%s)", verb, strings.Join(h.Indexes, ", "))
+}
+
+// Allows reports whether the hint lets a query use the named index. A nil
+// hint allows every index.
+func (h *IndexHint) Allows(index string) bool {
+	if h == nil {
+		return true
+	}
+	for _, name := range h.Indexes {
+		if name == index {
+			return !h.Ignore
+		}
 	}
-	return t.Name
+	return h.Ignore
 }
 
 type JoinClause struct {
 	Type      string
 	Table     *TableRef
 	Condition Condition
+
+	// Using lists the columns of USING (...), and Natural is set for a
+	// NATURAL JOIN. Either takes the place of Condition: the join matches
+	// rows equal in those columns, or in every column both sides have.
+	Using   []string
+	Natural bool
 }
 
 func (j *JoinClause) String() string {
@@ -134,6 +331,12 @@ func (j *JoinClause) String() string {
 	if joinType == "" {
 		joinType = "INNER"
 	}
+	switch {
+	case j.Natural:
+		return fmt.Sprintf("NATURAL %s JOIN %s", joinType, j.Table)
+	case j.Using != nil:
+		return fmt.Sprintf("%s JOIN %s USING (%s)", joinType, j.Table, strings.Join(j.Using, ", "))
+	}
 	return fmt.Sprintf("%s JOIN %s ON %s", joinType, j.Table, j.Condition)
 }
 
@@ -149,12 +352,17 @@ func (o *OrderItem) String() string {
 	return o.Column
 }
 
+// LimitClause holds LIMIT and OFFSET as written. Count is empty for OFFSET
+// without LIMIT; the planner checks that both are whole and not negative.
 type LimitClause struct {
 	Count  string
 	Offset string
 }
 
 func (l *LimitClause) String() string {
+	if l.Count == "" {
+		return fmt.Sprintf("OFFSET %s", l.Offset)
+	}
 	result := fmt.Sprintf("LIMIT %s", l.Count)
 	if l.Offset != "" {
 		result += fmt.Sprintf(" OFFSET %s", l.Offset)
@@ -165,33 +373,68 @@ func (l *LimitClause) String() string {
 type InsertStmt struct {
 	Table   string
 	Columns []string
-	Values  []string
+	Values  []Value
 }
 
 func (i *InsertStmt) String() string {
 	return fmt.Sprintf("INSERT INTO %s (%v) VALUES (%v)", i.Table, i.Columns, i.Values)
 }
 
+// DeleteStmt deletes the rows of Table matching Where. With a LIMIT it
+// deletes only the first Limit.Count of them, in OrderBy order when given.
 type DeleteStmt struct {
-	Table string
-	Where *WhereClause
+	Table   string
+	Where   *WhereClause
+	OrderBy []*OrderItem
+	Limit   *LimitClause
 }
 
 func (d *DeleteStmt) String() string {
 	result := fmt.Sprintf("DELETE FROM %s", d.Table)
 	if d.Where != nil {
-		result += fmt.Sprintf(" WHERE %v", d.Where.Conditions)
+		result += fmt.Sprintf(" WHERE %v", d.Where)
 	}
-	return result
+	return result + orderLimitString(d.OrderBy, d.Limit)
 }
 
 type CreateTableStmt struct {
 	Table   string
 	Columns []ColumnDef
+	Unique  [][]string
+	Engine  string
+	// ClusterKey orders the table's rows by these columns instead of by
+	// its primary key.
+	ClusterKey []string
+	// FillFactor is the percentage of its pages the table fills when rows
+	// arrive in key order, zero for the default.
+	FillFactor int
+	// BloomFilter keeps bloom filters on the table's indexes.
+	BloomFilter bool
 }
 
 func (c *CreateTableStmt) String() string {
-	return fmt.Sprintf("CREATE TABLE %s (%v)", c.Table, c.Columns)
+	result := fmt.Sprintf("CREATE TABLE %s (%v", c.Table, c.Columns)
+	for _, cols := range c.Unique {
+		result += fmt.Sprintf(", UNIQUE %v", cols)
+	}
+	result += ")"
+	if c.Engine != "" {
+		result += " ENGINE " + c.Engine
+	}
+	if len(c.ClusterKey) > 0 {
+		result += fmt.Sprintf(" CLUSTER BY %v", c.ClusterKey)
+	}
+	var params []string
+	if c.FillFactor != 0 {
+		params = append(params, fmt.Sprintf("FILLFACTOR = %d", c.FillFactor))
+	}
+	if c.BloomFilter {
+		params = append(params, "BLOOM_FILTER = ON")
+	}
+	if len(params) > 0 {
+		result += " WITH (" + strings.Join(params, ", ") + ")"
+	}
+	return result
 }
 
 type CreateIndexStmt struct {
@@ -199,6 +442,9 @@ type CreateIndexStmt struct {
 	TableName string
 	Columns   []string
 	Unique    bool
+	// Concurrently builds the index without holding up other sessions.
+	Concurrently bool
+	FillFactor   int
 }
 
 func (c *CreateIndexStmt) String() string {
@@ -206,24 +452,180 @@ func (c *CreateIndexStmt) String() string {
 	if c.Unique {
 		unique = "UNIQUE "
 	}
-	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%v)", unique, c.IndexName, c.TableName, c.Columns)
+	concurrently := ""
+	if c.Concurrently {
+		concurrently = "CONCURRENTLY "
+	}
+	result := fmt.Sprintf("CREATE %sINDEX %s%s ON %s (%v)", unique, concurrently, c.IndexName, c.TableName, c.Columns)
+	if c.FillFactor != 0 {
+		result += fmt.Sprintf(" WITH (FILLFACTOR = %d)", c.FillFactor)
+	}
+	return result
+}
+
+type AnalyzeStmt struct {
+	Table string
+}
+
+func (a *AnalyzeStmt) String() string {
+	if a.Table == "" {
+		return "ANALYZE"
+	}
+	return fmt.Sprintf("ANALYZE %s", a.Table)
+}
+
+type CreateSchemaStmt struct {
+	Name string
+}
+
+func (c *CreateSchemaStmt) String() string {
+	return fmt.Sprintf("CREATE SCHEMA %s", c.Name)
+}
+
+type CreateUserStmt struct {
+	Name     string
+	Password string
+}
+
+func (c *CreateUserStmt) String() string {
+	return fmt.Sprintf("CREATE USER %s WITH PASSWORD '***'", c.Name)
+}
+
+type SetStmt struct {
+	Name  string
+	Value string
+}
+
+func (s *SetStmt) String() string {
+	return fmt.Sprintf("SET %s = %s", s.Name, s.Value)
+}
+
+// ShowStmt is SHOW name, or SHOW ALL when Name is empty.
+type ShowStmt struct {
+	Name string
+}
+
+func (s *ShowStmt) String() string {
+	if s.Name == "" {
+		return "SHOW ALL"
+	}
+	return "SHOW " + s.Name
+}
+
+// KillStmt is KILL id, which ends a session, or KILL QUERY id, which only
+// cancels its running statement.
+type KillStmt struct {
+	ID    int64
+	Query bool
+}
+
+func (k *KillStmt) String() string {
+	if k.Query {
+		return fmt.Sprintf("KILL QUERY %d", k.ID)
+	}
+	return fmt.Sprintf("KILL %d", k.ID)
+}
+
+type CreatePolicyStmt struct {
+	Name       string
+	Table      string
+	User       string
+	Conditions []Condition
+}
+
+func (c *CreatePolicyStmt) String() string {
+	result := fmt.Sprintf("CREATE POLICY %s ON %s", c.Name, c.Table)
+	if c.User != "" {
+		result += " FOR " + c.User
+	}
+	return result + fmt.Sprintf(" USING %v", c.Conditions)
+}
+
+type DropPolicyStmt struct {
+	Name  string
+	Table string
+}
+
+func (d *DropPolicyStmt) String() string {
+	return fmt.Sprintf("DROP POLICY %s ON %s", d.Name, d.Table)
+}
+
+// GrantStmt is a GRANT, or a REVOKE when Revoke is set. Table is "*" for
+// every table.
+type GrantStmt struct {
+	Revoke     bool
+	Privileges []string
+	Table      string
+	User       string
+}
+
+func (g *GrantStmt) String() string {
+	if g.Revoke {
+		return fmt.Sprintf("REVOKE %s ON %s FROM %s", strings.Join(g.Privileges, ", "), g.Table, g.User)
+	}
+	return fmt.Sprintf("GRANT %s ON %s TO %s", strings.Join(g.Privileges, ", "), g.Table, g.User)
+}
+
+type AttachStmt struct {
+	File  string
+	Alias string
+}
+
+func (a *AttachStmt) String() string {
+	return fmt.Sprintf("ATTACH '%s' AS %s", a.File, a.Alias)
+}
+
+type DetachStmt struct {
+	Alias string
+}
+
+func (d *DetachStmt) String() string {
+	return fmt.Sprintf("DETACH %s", d.Alias)
+}
+
+type ExplainStmt struct {
+	Stmt    Node
+	Verbose bool
+}
+
+func (e *ExplainStmt) String() string {
+	if e.Verbose {
+		return fmt.Sprintf("EXPLAIN VERBOSE %s", e.Stmt)
+	}
+	return fmt.Sprintf("EXPLAIN %s", e.Stmt)
 }
 
+// UpdateStmt updates the rows of Table matching Where, or with a LIMIT the
+// first Limit.Count of them, in OrderBy order when given.
 type UpdateStmt struct {
 	Table       string
 	Assignments []Assignment
 	Where       *WhereClause
+	OrderBy     []*OrderItem
+	Limit       *LimitClause
 }
 
 type Assignment struct {
 	Column string
-	Value  string
+	Value  Value
 }
 
 func (u *UpdateStmt) String() string {
 	result := fmt.Sprintf("UPDATE %s SET %v", u.Table, u.Assignments)
 	if u.Where != nil {
-		result += fmt.Sprintf(" WHERE %v", u.Where.Conditions)
+		result += fmt.Sprintf(" WHERE %v", u.Where)
+	}
+	return result + orderLimitString(u.OrderBy, u.Limit)
+}
+
+// orderLimitString shows the ORDER BY and LIMIT of an UPDATE or DELETE.
+func orderLimitString(orderBy []*OrderItem, limit *LimitClause) string {
+	result := ""
+	if len(orderBy) > 0 {
+		result += fmt.Sprintf(" ORDER BY %v", orderBy)
+	}
+	if limit != nil {
+		result += " " + limit.String()
 	}
 	return result
 }
@@ -239,10 +641,14 @@ type ColumnDef struct {
 	Unique        bool
 	NotNull       bool
 	AutoIncrement bool
+	EnumValues    []string
 }
 
 func (c ColumnDef) String() string {
 	result := fmt.Sprintf("%s %s", c.Name, c.Type)
+	if len(c.EnumValues) > 0 {
+		result += fmt.Sprintf("('%s')", strings.Join(c.EnumValues, "', '"))
+	}
 	if c.PrimaryKey {
 		result += " PRIMARY KEY"
 	}
@@ -258,24 +664,122 @@ func (c ColumnDef) String() string {
 	return result
 }
 
+// WhereClause is a WHERE or HAVING clause, the AND of its Conditions and
+// Predicates. The conditions ANDed at its top level are in Conditions,
+// where the planner can look them up in an index, and each OR in
+// Predicates.
 type WhereClause struct {
 	Conditions []Condition
+	Predicates []Predicate
 }
 
 type Condition struct {
 	Column   string
+	Expr     Expr // set when the left-hand side is computed; Column holds its text
 	Operator string
-	Value    string
+	Value    Value
+
+	// Subquery is set for the operators IN, NOT IN, EXISTS and NOT
+	// EXISTS, which take it in place of Value. EXISTS has no Column.
+	Subquery *SelectStmt
+}
+
+// LiteralKind records how a value was written, since its text alone cannot
+// tell 'NULL' from NULL, '5' from 5 or 'name' from name.
+type LiteralKind int
+
+const (
+	StringLiteral LiteralKind = iota
+	IntLiteral
+	FloatLiteral
+	BoolLiteral
+	NullLiteral
+	// Identifier is a bare word, possibly qualified, naming a column. The
+	// planner rejects one that names no column in scope.
+	Identifier
+)
+
+// Value is a value written in a statement. Literal holds it parsed: a
+// string, int64, float64 or bool, or nil for NULL and identifiers. Text is
+// how it was written, without quotes.
+type Value struct {
+	Kind    LiteralKind
+	Text    string
+	Literal interface{}
+}
+
+func (v Value) String() string {
+	return v.Text
+}
+
+// ValueOf returns v, a string, int64, float64, bool or nil, as a Value.
+func ValueOf(v interface{}) Value {
+	switch lit := v.(type) {
+	case nil:
+		return Value{Kind: NullLiteral, Text: "NULL"}
+	case int64:
+		return Value{Kind: IntLiteral, Text: strconv.FormatInt(lit, 10), Literal: lit}
+	case float64:
+		return Value{Kind: FloatLiteral, Text: strconv.FormatFloat(lit, 'g', -1, 64), Literal: lit}
+	case bool:
+		return Value{Kind: BoolLiteral, Text: strconv.FormatBool(lit), Literal: lit}
+	case string:
+		return Value{Kind: StringLiteral, Text: lit, Literal: lit}
+	}
+	text := fmt.Sprint(v)
+	return Value{Kind: StringLiteral, Text: text, Literal: text}
+}
+
+// parseValue parses the value at the current token, and reports false if
+// there is none.
+func (p *Parser) parseValue() (Value, bool, error) {
+	text := p.curTok.Literal
+	v := Value{Text: text}
+	switch {
+	case p.curTok.Type == STRING:
+		v.Kind, v.Literal = StringLiteral, text
+	case p.curTok.Type == NUMBER:
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			v.Kind, v.Literal = IntLiteral, i
+		} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+			v.Kind, v.Literal = FloatLiteral, f
+		} else {
+			return v, true, fmt.Errorf("invalid number %s", text)
+		}
+	case p.curKeywordIs("NULL"):
+		v.Kind = NullLiteral
+	case p.curTok.Type == IDENTIFIER && (strings.EqualFold(text, "TRUE") || strings.EqualFold(text, "FALSE")):
+		v.Kind, v.Literal = BoolLiteral, strings.EqualFold(text, "TRUE")
+	case p.curTok.Type == IDENTIFIER:
+		v.Kind = Identifier
+	default:
+		return v, false, nil
+	}
+	p.nextToken()
+	return v, true, nil
 }
 
 func (c Condition) String() string {
+	if c.Subquery != nil {
+		if c.Column == "" {
+			return fmt.Sprintf("%s (%s)", c.Operator, c.Subquery)
+		}
+		return fmt.Sprintf("%s %s (%s)", c.Column, c.Operator, c.Subquery)
+	}
 	return fmt.Sprintf("%s %s %s", c.Column, c.Operator, c.Value)
 }
 
+// maxDepth bounds how deeply expressions may nest, so hostile input fails
+// with an error instead of exhausting the stack.
+const maxDepth = 200
+
 type Parser struct {
 	lexer   *Lexer
 	curTok  Token
 	peekTok Token
+	depth   int
+	// loops names the rows of the FOR loops being parsed in a procedure.
+	loops []string
 }
 
 func NewParser(input string) *Parser {
@@ -298,7 +802,34 @@ func (p *Parser) peekKeywordIs(keyword string) bool {
 	return p.peekTok.Type == KEYWORD && p.peekTok.Value == keyword
 }
 
+func (p *Parser) peekWordIs(word string) bool {
+	return p.peekTok.Type == IDENTIFIER && strings.EqualFold(p.peekTok.Literal, word)
+}
+
+// Parse parses a single statement, optionally followed by a semicolon, and
+// rejects anything left over.
 func (p *Parser) Parse() (Node, error) {
+	node, err := p.parseStatement()
+	if p.lexer.err != nil {
+		return nil, p.lexer.err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.curTok.Type == SEMICOLON {
+		p.nextToken()
+	}
+	if p.curTok.Type != EOF {
+		if p.lexer.err != nil {
+			return nil, p.lexer.err
+		}
+		return nil, fmt.Errorf("unexpected %s after end of statement", p.curTok.Literal)
+	}
+	return node, nil
+}
+
+func (p *Parser) parseStatement() (Node, error) {
 	switch {
 	case p.curKeywordIs("SELECT"):
 		return p.parseSelect()
@@ -310,6 +841,28 @@ func (p *Parser) Parse() (Node, error) {
 		return p.parseCreate()
 	case p.curKeywordIs("UPDATE"):
 		return p.parseUpdate()
+	case p.curKeywordIs("ANALYZE"):
+		return p.parseAnalyze()
+	case p.curKeywordIs("DROP"):
+		return p.parseDrop()
+	case p.curKeywordIs("SET"):
+		return p.parseSet()
+	case p.curWordIs("SHOW"):
+		return p.parseShow()
+	case p.curWordIs("EXPLAIN"):
+		return p.parseExplain()
+	case p.curWordIs("KILL"):
+		return p.parseKill()
+	case p.curWordIs("CALL"):
+		return p.parseCall()
+	case p.curWordIs("ALTER"):
+		return p.parseAlter()
+	case p.curKeywordIs("GRANT"), p.curKeywordIs("REVOKE"):
+		return p.parseGrant()
+	case p.curKeywordIs("ATTACH"):
+		return p.parseAttach()
+	case p.curKeywordIs("DETACH"):
+		return p.parseDetach()
 	default:
 		return nil, fmt.Errorf("unsupported statement: %s", p.curTok.Literal)
 	}
@@ -328,11 +881,13 @@ func (p *Parser) parseSelect() (*SelectStmt, error) {
 		stmt.Columns = []string{"*"}
 		p.nextToken()
 	} else {
-		cols, err := p.parseColumnList()
+		cols, exprs, aliases, err := p.parseSelectList()
 		if err != nil {
 			return nil, err
 		}
 		stmt.Columns = cols
+		stmt.Exprs = exprs
+		stmt.Aliases = aliases
 	}
 
 	if !p.curKeywordIs("FROM") {
@@ -347,7 +902,7 @@ func (p *Parser) parseSelect() (*SelectStmt, error) {
 	stmt.Table = tableRef
 
 	for p.curKeywordIs("JOIN") || p.curKeywordIs("INNER") || p.curKeywordIs("LEFT") ||
-		p.curKeywordIs("RIGHT") || p.curKeywordIs("FULL") {
+		p.curKeywordIs("RIGHT") || p.curKeywordIs("FULL") || p.curIsNatural() {
 		join, err := p.parseJoin()
 		if err != nil {
 			return nil, err
@@ -399,7 +954,7 @@ func (p *Parser) parseSelect() (*SelectStmt, error) {
 		stmt.OrderBy = orderBy
 	}
 
-	if p.curKeywordIs("LIMIT") {
+	if p.curKeywordIs("LIMIT") || p.curKeywordIs("OFFSET") {
 		limit, err := p.parseLimit()
 		if err != nil {
 			return nil, err
@@ -411,12 +966,19 @@ func (p *Parser) parseSelect() (*SelectStmt, error) {
 }
 
 func (p *Parser) parseTableRef() (*TableRef, error) {
-	if p.curTok.Type != IDENTIFIER {
-		return nil, fmt.Errorf("expected table name, got %s", p.curTok.Literal)
+	name, err := p.parseTableName()
+	if err != nil {
+		return nil, err
 	}
+	tableRef := &TableRef{Name: name}
 
-	tableRef := &TableRef{Name: p.curTok.Literal}
-	p.nextToken()
+	if p.curTok.Type == LPAREN {
+		args, err := p.parseFunctionArgs(name)
+		if err != nil {
+			return nil, err
+		}
+		tableRef.Function, tableRef.Args = true, args
+	}
 
 	if p.curKeywordIs("AS") {
 		p.nextToken()
@@ -425,95 +987,443 @@ func (p *Parser) parseTableRef() (*TableRef, error) {
 	if p.curTok.Type == IDENTIFIER && !p.curKeywordIs("WHERE") && !p.curKeywordIs("JOIN") &&
 		!p.curKeywordIs("INNER") && !p.curKeywordIs("LEFT") && !p.curKeywordIs("RIGHT") &&
 		!p.curKeywordIs("ORDER") && !p.curKeywordIs("GROUP") && !p.curKeywordIs("LIMIT") &&
-		!p.curKeywordIs("HAVING") {
+		!p.curKeywordIs("HAVING") && !p.curIsIndexHint() && !p.curIsNatural() &&
+		!(p.curWordIs("USING") && p.peekTok.Type == LPAREN) && !p.curIsTableSample() {
 		tableRef.Alias = p.curTok.Literal
 		p.nextToken()
 	}
 
+	if p.curIsIndexHint() {
+		hint, err := p.parseIndexHint()
+		if err != nil {
+			return nil, err
+		}
+		tableRef.Hint = hint
+	}
+
+	if p.curIsTableSample() {
+		sample, err := p.parseTableSample()
+		if err != nil {
+			return nil, err
+		}
+		tableRef.Sample = sample
+	}
+
 	return tableRef, nil
 }
 
-func (p *Parser) parseJoin() (*JoinClause, error) {
-	join := &JoinClause{}
-
-	if p.curKeywordIs("INNER") || p.curKeywordIs("LEFT") || p.curKeywordIs("RIGHT") || p.curKeywordIs("FULL") {
-		join.Type = p.curTok.Literal
-		p.nextToken()
-	}
+// curIsTableSample reports whether TABLESAMPLE, which is not reserved,
+// starts a sampling clause here rather than naming an alias.
+func (p *Parser) curIsTableSample() bool {
+	return p.curWordIs("TABLESAMPLE") && p.peekTok.Type == IDENTIFIER
+}
 
-	if !p.curKeywordIs("JOIN") {
-		return nil, fmt.Errorf("expected JOIN, got %s", p.curTok.Literal)
+func (p *Parser) parseTableSample() (*TableSample, error) {
+	p.nextToken()
+	if !p.curWordIs("BERNOULLI") {
+		return nil, fmt.Errorf("unsupported sampling method %s, only BERNOULLI is supported", p.curTok.Literal)
 	}
 	p.nextToken()
 
-	tableRef, err := p.parseTableRef()
+	percent, err := p.parseParenNumber("BERNOULLI")
 	if err != nil {
 		return nil, err
 	}
-	join.Table = tableRef
-
-	if !p.curKeywordIs("ON") {
-		return nil, fmt.Errorf("expected ON, got %s", p.curTok.Literal)
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("sample percentage must be between 0 and 100, got %s", strconv.FormatFloat(percent, 'g', -1, 64))
 	}
-	p.nextToken()
+	sample := &TableSample{Percent: percent}
 
-	cond, err := p.parseCondition()
-	if err != nil {
-		return nil, err
+	if p.curWordIs("REPEATABLE") {
+		p.nextToken()
+		seed, err := p.parseParenNumber("REPEATABLE")
+		if err != nil {
+			return nil, err
+		}
+		if seed != math.Trunc(seed) {
+			return nil, fmt.Errorf("REPEATABLE takes a whole number, got %s", strconv.FormatFloat(seed, 'g', -1, 64))
+		}
+		sample.Repeatable, sample.Seed = true, int64(seed)
 	}
-	join.Condition = cond
-
-	return join, nil
+	return sample, nil
 }
 
-func (p *Parser) parseCondition() (Condition, error) {
-	cond := Condition{}
-
-	if p.curTok.Type != IDENTIFIER {
-		return cond, fmt.Errorf("expected column name, got %s", p.curTok.Literal)
+// parseParenNumber parses the parenthesized number that is the argument of
+// the clause named by clause.
+func (p *Parser) parseParenNumber(clause string) (float64, error) {
+	if p.curTok.Type != LPAREN {
+		return 0, fmt.Errorf("expected ( after %s, got %s", clause, p.curTok.Literal)
 	}
-
-	colName := p.curTok.Literal
 	p.nextToken()
-
-	if p.curTok.Type == DOT {
-		p.nextToken()
-		if p.curTok.Type != IDENTIFIER {
-			return cond, fmt.Errorf("expected column name after dot, got %s", p.curTok.Literal)
-		}
-		colName = colName + "." + p.curTok.Literal
-		p.nextToken()
+	if p.curTok.Type != NUMBER {
+		return 0, fmt.Errorf("expected number in %s, got %s", clause, p.curTok.Literal)
 	}
-
-	cond.Column = colName
-
-	if p.curTok.Type != OPERATOR {
-		return cond, fmt.Errorf("expected operator, got %s", p.curTok.Literal)
+	n, err := strconv.ParseFloat(p.curTok.Literal, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %s", p.curTok.Literal)
 	}
-	cond.Operator = p.curTok.Literal
 	p.nextToken()
+	if p.curTok.Type != RPAREN {
+		return 0, fmt.Errorf("expected ) after %s argument, got %s", clause, p.curTok.Literal)
+	}
+	p.nextToken()
+	return n, nil
+}
 
-	if p.curTok.Type == STRING || p.curTok.Type == NUMBER || p.curTok.Type == IDENTIFIER {
-		valueName := p.curTok.Literal
-		p.nextToken()
-
-		if p.curTok.Type == DOT {
+// parseFunctionArgs parses the parenthesized arguments of a table
+// function, which are literal values.
+func (p *Parser) parseFunctionArgs(name string) ([]Value, error) {
+	p.nextToken()
+	args := []Value{}
+	for p.curTok.Type != RPAREN {
+		if len(args) > 0 {
+			if p.curTok.Type != COMMA {
+				return nil, fmt.Errorf("expected , or ) in arguments of %s, got %s", name, p.curTok.Literal)
+			}
+			p.nextToken()
+		}
+		value, ok, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("expected value as argument of %s, got %s", name, p.curTok.Literal)
+		}
+		if value.Kind == Identifier {
+			return nil, fmt.Errorf("arguments of %s must be literals, got %s", name, value.Text)
+		}
+		args = append(args, value)
+	}
+	p.nextToken()
+	return args, nil
+}
+
+// curIsIndexHint reports whether USE INDEX or IGNORE INDEX starts here. USE
+// and IGNORE are not reserved, so a table may still be aliased as either.
+func (p *Parser) curIsIndexHint() bool {
+	return (p.curWordIs("USE") || p.curWordIs("IGNORE")) && p.peekKeywordIs("INDEX")
+}
+
+func (p *Parser) parseIndexHint() (*IndexHint, error) {
+	hint := &IndexHint{Ignore: p.curWordIs("IGNORE")}
+	p.nextToken()
+	p.nextToken()
+
+	if p.curTok.Type != LPAREN {
+		return nil, fmt.Errorf("expected ( after INDEX, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	indexes, err := p.parseColumnList()
+	if err != nil {
+		return nil, err
+	}
+	hint.Indexes = indexes
+
+	if p.curTok.Type != RPAREN {
+		return nil, fmt.Errorf("expected ), got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	return hint, nil
+}
+
+// curIsNatural reports whether NATURAL starts a join here. NATURAL is not
+// reserved, so a table may still be aliased so.
+func (p *Parser) curIsNatural() bool {
+	return p.curWordIs("NATURAL") && (p.peekKeywordIs("JOIN") || p.peekKeywordIs("INNER") ||
+		p.peekKeywordIs("LEFT") || p.peekKeywordIs("RIGHT") || p.peekKeywordIs("FULL"))
+}
+
+func (p *Parser) parseJoin() (*JoinClause, error) {
+	join := &JoinClause{}
+
+	if p.curIsNatural() {
+		join.Natural = true
+		p.nextToken()
+	}
+
+	if p.curKeywordIs("INNER") || p.curKeywordIs("LEFT") || p.curKeywordIs("RIGHT") || p.curKeywordIs("FULL") {
+		join.Type = p.curTok.Value
+		p.nextToken()
+		if join.Type != "INNER" && p.curKeywordIs("OUTER") {
+			p.nextToken()
+		}
+	}
+
+	if !p.curKeywordIs("JOIN") {
+		return nil, fmt.Errorf("expected JOIN, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	tableRef, err := p.parseTableRef()
+	if err != nil {
+		return nil, err
+	}
+	join.Table = tableRef
+
+	if join.Natural {
+		return join, nil
+	}
+
+	if p.curWordIs("USING") {
+		p.nextToken()
+		if p.curTok.Type != LPAREN {
+			return nil, fmt.Errorf("expected ( after USING, got %s", p.curTok.Literal)
+		}
+		p.nextToken()
+		columns, err := p.parseColumnList()
+		if err != nil {
+			return nil, err
+		}
+		for _, column := range columns {
+			if strings.Contains(column, ".") {
+				return nil, fmt.Errorf("USING takes column names without a table, got %s", column)
+			}
+		}
+		if p.curTok.Type != RPAREN {
+			return nil, fmt.Errorf("expected ), got %s", p.curTok.Literal)
+		}
+		p.nextToken()
+		join.Using = columns
+		return join, nil
+	}
+
+	if !p.curKeywordIs("ON") {
+		return nil, fmt.Errorf("expected ON or USING, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	cond, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	join.Condition = cond
+
+	return join, nil
+}
+
+func (p *Parser) parseCondition() (Condition, error) {
+	cond := Condition{}
+
+	// EXISTS and IN are not reserved, so a column may still be named so.
+	if (p.curWordIs("EXISTS") && p.peekTok.Type == LPAREN) || (p.curKeywordIs("NOT") && p.peekWordIs("EXISTS")) {
+		cond.Operator = "EXISTS"
+		if p.curKeywordIs("NOT") {
+			cond.Operator = "NOT EXISTS"
+			p.nextToken()
+		}
+		p.nextToken()
+		subquery, err := p.parseSubquery()
+		if err != nil {
+			return cond, err
+		}
+		cond.Subquery = subquery
+		return cond, nil
+	}
+
+	if p.curTok.Type != IDENTIFIER {
+		return cond, fmt.Errorf("expected column name, got %s", p.curTok.Literal)
+	}
+
+	if IsAggregate(p.curTok.Literal) && p.peekTok.Type == LPAREN {
+		agg, err := p.parseAggregate()
+		if err != nil {
+			return cond, err
+		}
+		return p.parseConditionRest(cond, agg)
+	}
+
+	if p.isCallStart() {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return cond, err
+		}
+		cond.Expr = expr
+		return p.parseConditionRest(cond, expr.String())
+	}
+
+	colName := p.curTok.Literal
+	p.nextToken()
+
+	for p.curTok.Type == DOT {
+		p.nextToken()
+		if p.curTok.Type != IDENTIFIER {
+			return cond, fmt.Errorf("expected column name after dot, got %s", p.curTok.Literal)
+		}
+		colName = colName + "." + p.curTok.Literal
+		p.nextToken()
+	}
+
+	if p.curIsArrow() {
+		expr, err := p.parsePostfix(&ColumnExpr{Name: colName})
+		if err != nil {
+			return cond, err
+		}
+		cond.Expr = expr
+		return p.parseConditionRest(cond, expr.String())
+	}
+
+	return p.parseConditionRest(cond, colName)
+}
+
+func (p *Parser) parseConditionRest(cond Condition, colName string) (Condition, error) {
+	cond.Column = colName
+
+	if p.curWordIs("IN") || (p.curKeywordIs("NOT") && p.peekWordIs("IN")) {
+		cond.Operator = "IN"
+		if p.curKeywordIs("NOT") {
+			cond.Operator = "NOT IN"
+			p.nextToken()
+		}
+		p.nextToken()
+		subquery, err := p.parseSubquery()
+		if err != nil {
+			return cond, err
+		}
+		cond.Subquery = subquery
+		return cond, nil
+	}
+
+	if op, ok := p.parseMatchOperator(); ok {
+		cond.Operator = op
+	} else if p.curTok.Type != OPERATOR {
+		return cond, fmt.Errorf("expected operator, got %s", p.curTok.Literal)
+	} else {
+		cond.Operator = p.curTok.Literal
+		switch cond.Operator {
+		case "~":
+			cond.Operator = "REGEXP"
+		case "!~":
+			cond.Operator = "NOT REGEXP"
+		}
+		p.nextToken()
+	}
+
+	value, ok, err := p.parseValue()
+	if err != nil {
+		return cond, err
+	}
+	if ok {
+		if value.Kind == Identifier && p.curTok.Type == DOT {
 			p.nextToken()
 			if p.curTok.Type != IDENTIFIER {
 				return cond, fmt.Errorf("expected identifier after dot, got %s", p.curTok.Literal)
 			}
-			valueName = valueName + "." + p.curTok.Literal
+			value.Text = value.Text + "." + p.curTok.Literal
 			p.nextToken()
 		}
 
-		cond.Value = valueName
+		cond.Value = value
 	} else {
 		return cond, fmt.Errorf("expected value, got %s", p.curTok.Literal)
 	}
 
+	if strings.HasSuffix(cond.Operator, "REGEXP") && cond.Value.Kind == StringLiteral {
+		if _, err := regexp.Compile(cond.Value.Text); err != nil {
+			return cond, fmt.Errorf("invalid regular expression '%s': %w", cond.Value.Text, err)
+		}
+	}
+
+	if strings.HasSuffix(cond.Operator, "LIKE") && p.curWordIs("ESCAPE") {
+		p.nextToken()
+		if p.curTok.Type != STRING {
+			return cond, fmt.Errorf("expected escape character after ESCAPE, got %s", p.curTok.Literal)
+		}
+		if cond.Value.Kind != StringLiteral {
+			return cond, fmt.Errorf("ESCAPE needs a quoted pattern, got %s", cond.Value.Text)
+		}
+		pattern, err := escapeLikePattern(cond.Value.Text, p.curTok.Literal)
+		if err != nil {
+			return cond, err
+		}
+		cond.Value = ValueOf(pattern)
+		p.nextToken()
+	}
+
 	return cond, nil
 }
 
+// parseMatchOperator consumes [NOT] LIKE, [NOT] ILIKE or [NOT] REGEXP,
+// which are not reserved words, and returns the operator.
+func (p *Parser) parseMatchOperator() (string, bool) {
+	isMatch := func(word func(string) bool) bool {
+		return word("LIKE") || word("ILIKE") || word("REGEXP")
+	}
+	not := p.curKeywordIs("NOT") && isMatch(p.peekWordIs)
+	if !not && !isMatch(p.curWordIs) {
+		return "", false
+	}
+	if not {
+		p.nextToken()
+	}
+	op := strings.ToUpper(p.curTok.Literal)
+	if not {
+		op = "NOT " + op
+	}
+	p.nextToken()
+	return op, true
+}
+
+// escapeLikePattern rewrites a LIKE pattern written with the given ESCAPE
+// character to use the default one, a backslash, so the engine matches
+// every pattern the same way. An empty escape turns escaping off.
+func escapeLikePattern(pattern, escape string) (string, error) {
+	if utf8.RuneCountInString(escape) > 1 {
+		return "", fmt.Errorf("ESCAPE takes a single character, got '%s'", escape)
+	}
+	esc, _ := utf8.DecodeRuneInString(escape)
+	if escape == "" {
+		esc = -1
+	}
+
+	var b strings.Builder
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == esc:
+			if i+1 == len(runes) {
+				return "", fmt.Errorf("LIKE pattern '%s' ends with its escape character", pattern)
+			}
+			i++
+			b.WriteRune('\\')
+			b.WriteRune(runes[i])
+		case r == '\\':
+			b.WriteString(`\\`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), nil
+}
+
+// parseSubquery parses a parenthesized SELECT, the operand of IN and
+// EXISTS.
+func (p *Parser) parseSubquery() (*SelectStmt, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, fmt.Errorf("subqueries nested too deeply")
+	}
+
+	if p.curTok.Type != LPAREN {
+		return nil, fmt.Errorf("expected ( before subquery, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+	if !p.curKeywordIs("SELECT") {
+		return nil, fmt.Errorf("expected SELECT in subquery, got %s", p.curTok.Literal)
+	}
+	stmt, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	if p.curTok.Type != RPAREN {
+		return nil, fmt.Errorf("expected ) after subquery, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+	return stmt, nil
+}
+
 func (p *Parser) parseOrderBy() ([]*OrderItem, error) {
 	items := []*OrderItem{}
 
@@ -525,6 +1435,15 @@ func (p *Parser) parseOrderBy() ([]*OrderItem, error) {
 		item := &OrderItem{Column: p.curTok.Literal}
 		p.nextToken()
 
+		if p.curTok.Type == DOT {
+			p.nextToken()
+			if p.curTok.Type != IDENTIFIER {
+				return nil, fmt.Errorf("expected column name after dot, got %s", p.curTok.Literal)
+			}
+			item.Column = item.Column + "." + p.curTok.Literal
+			p.nextToken()
+		}
+
 		if p.curKeywordIs("ASC") || p.curKeywordIs("DESC") {
 			item.Direction = p.curTok.Literal
 			p.nextToken()
@@ -541,28 +1460,57 @@ func (p *Parser) parseOrderBy() ([]*OrderItem, error) {
 	return items, nil
 }
 
+// parseLimit parses LIMIT n, LIMIT n OFFSET m, MySQL's LIMIT m, n and
+// OFFSET m with or without a LIMIT after it.
 func (p *Parser) parseLimit() (*LimitClause, error) {
-	p.nextToken()
-
-	if p.curTok.Type != NUMBER {
-		return nil, fmt.Errorf("expected number after LIMIT, got %s", p.curTok.Literal)
+	limit := &LimitClause{}
+	if p.curKeywordIs("OFFSET") {
+		offset, err := p.parseLimitNumber("OFFSET")
+		if err != nil {
+			return nil, err
+		}
+		limit.Offset = offset
+		if p.curKeywordIs("LIMIT") {
+			if limit.Count, err = p.parseLimitNumber("LIMIT"); err != nil {
+				return nil, err
+			}
+		}
+		return limit, nil
 	}
 
-	limit := &LimitClause{Count: p.curTok.Literal}
-	p.nextToken()
+	count, err := p.parseLimitNumber("LIMIT")
+	if err != nil {
+		return nil, err
+	}
+	limit.Count = count
 
-	if p.curKeywordIs("OFFSET") {
-		p.nextToken()
-		if p.curTok.Type != NUMBER {
-			return nil, fmt.Errorf("expected number after OFFSET, got %s", p.curTok.Literal)
+	switch {
+	case p.curKeywordIs("OFFSET"):
+		if limit.Offset, err = p.parseLimitNumber("OFFSET"); err != nil {
+			return nil, err
 		}
-		limit.Offset = p.curTok.Literal
-		p.nextToken()
+	case p.curTok.Type == COMMA:
+		if limit.Count, err = p.parseLimitNumber(","); err != nil {
+			return nil, err
+		}
+		limit.Offset = count
 	}
 
 	return limit, nil
 }
 
+// parseLimitNumber skips the current token, after, and reads the number
+// that follows it.
+func (p *Parser) parseLimitNumber(after string) (string, error) {
+	p.nextToken()
+	if p.curTok.Type != NUMBER {
+		return "", fmt.Errorf("expected number after %s, got %s", after, p.curTok.Literal)
+	}
+	n := p.curTok.Literal
+	p.nextToken()
+	return n, nil
+}
+
 func (p *Parser) parseInsert() (*InsertStmt, error) {
 	stmt := &InsertStmt{}
 	p.nextToken()
@@ -572,11 +1520,11 @@ func (p *Parser) parseInsert() (*InsertStmt, error) {
 	}
 	p.nextToken()
 
-	if p.curTok.Type != IDENTIFIER {
-		return nil, fmt.Errorf("expected table name, got %s", p.curTok.Literal)
+	table, err := p.parseTableName()
+	if err != nil {
+		return nil, err
 	}
-	stmt.Table = p.curTok.Literal
-	p.nextToken()
+	stmt.Table = table
 
 	if p.curTok.Type == LPAREN {
 		p.nextToken()
@@ -625,11 +1573,11 @@ func (p *Parser) parseDelete() (*DeleteStmt, error) {
 	}
 	p.nextToken()
 
-	if p.curTok.Type != IDENTIFIER {
-		return nil, fmt.Errorf("expected table name, got %s", p.curTok.Literal)
+	table, err := p.parseTableName()
+	if err != nil {
+		return nil, err
 	}
-	stmt.Table = p.curTok.Literal
-	p.nextToken()
+	stmt.Table = table
 
 	if p.curKeywordIs("WHERE") {
 		where, err := p.parseWhere()
@@ -639,48 +1587,414 @@ func (p *Parser) parseDelete() (*DeleteStmt, error) {
 		stmt.Where = where
 	}
 
+	stmt.OrderBy, stmt.Limit, err = p.parseOrderLimit()
+	if err != nil {
+		return nil, err
+	}
 	return stmt, nil
 }
 
+// parseOrderLimit parses the ORDER BY and LIMIT that may end an UPDATE or
+// DELETE.
+func (p *Parser) parseOrderLimit() ([]*OrderItem, *LimitClause, error) {
+	var orderBy []*OrderItem
+	if p.curKeywordIs("ORDER") {
+		p.nextToken()
+		if !p.curKeywordIs("BY") {
+			return nil, nil, fmt.Errorf("expected BY after ORDER, got %s", p.curTok.Literal)
+		}
+		p.nextToken()
+
+		var err error
+		if orderBy, err = p.parseOrderBy(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if p.curKeywordIs("LIMIT") || p.curKeywordIs("OFFSET") {
+		limit, err := p.parseLimit()
+		if err != nil {
+			return nil, nil, err
+		}
+		return orderBy, limit, nil
+	}
+	return orderBy, nil, nil
+}
+
 func (p *Parser) parseCreate() (Node, error) {
+	start := p.curTok.Pos
 	p.nextToken()
 
 	if p.curKeywordIs("TABLE") {
 		return p.parseCreateTable()
 	} else if p.curKeywordIs("INDEX") || p.curKeywordIs("UNIQUE") {
 		return p.parseCreateIndex()
+	} else if p.curKeywordIs("SCHEMA") {
+		return p.parseCreateSchema()
+	} else if p.curWordIs("USER") {
+		return p.parseCreateUser()
+	} else if p.curWordIs("POLICY") {
+		return p.parseCreatePolicy()
+	} else if p.curWordIs("PROCEDURE") {
+		return p.parseCreateProcedure(start)
+	} else if p.curWordIs("JOB") {
+		return p.parseCreateJob()
+	}
+
+	return nil, fmt.Errorf("expected TABLE, INDEX, SCHEMA, USER, POLICY, PROCEDURE or JOB after CREATE, got %s", p.curTok.Literal)
+}
+
+func (p *Parser) parseSet() (*SetStmt, error) {
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected setting name, got %s", p.curTok.Literal)
+	}
+	stmt := &SetStmt{Name: strings.ToLower(p.curTok.Literal)}
+	p.nextToken()
+
+	if (p.curTok.Type == OPERATOR && p.curTok.Literal == "=") || p.curWordIs("TO") {
+		p.nextToken()
+	} else {
+		return nil, fmt.Errorf("expected = or TO, got %s", p.curTok.Literal)
+	}
+
+	// Values like table or on are keywords, so any single token is accepted.
+	if p.curTok.Type == EOF || p.curTok.Type == SEMICOLON {
+		return nil, fmt.Errorf("expected value for %s", stmt.Name)
+	}
+	stmt.Value = p.curTok.Literal
+	p.nextToken()
+
+	return stmt, nil
+}
+
+func (p *Parser) parseShow() (*ShowStmt, error) {
+	p.nextToken()
+
+	if p.curWordIs("ALL") {
+		p.nextToken()
+		return &ShowStmt{}, nil
+	}
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected setting name or ALL, got %s", p.curTok.Literal)
+	}
+	stmt := &ShowStmt{Name: strings.ToLower(p.curTok.Literal)}
+	p.nextToken()
+
+	return stmt, nil
+}
+
+func (p *Parser) parseKill() (*KillStmt, error) {
+	p.nextToken()
+
+	stmt := &KillStmt{}
+	if p.curWordIs("QUERY") {
+		stmt.Query = true
+		p.nextToken()
+	}
+
+	if p.curTok.Type != NUMBER {
+		return nil, fmt.Errorf("expected session id after KILL, got %s", p.curTok.Literal)
+	}
+	id, err := strconv.ParseInt(p.curTok.Literal, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session id %s", p.curTok.Literal)
+	}
+	stmt.ID = id
+	p.nextToken()
+
+	return stmt, nil
+}
+
+func (p *Parser) parseDrop() (Node, error) {
+	p.nextToken()
+
+	if p.curWordIs("POLICY") {
+		return p.parseDropPolicy()
+	}
+	if p.curWordIs("PROCEDURE") {
+		return p.parseDropProcedure()
+	}
+	if p.curWordIs("JOB") {
+		return p.parseDropJob()
+	}
+	if p.curKeywordIs("INDEX") {
+		return p.parseDropIndex()
+	}
+
+	return nil, fmt.Errorf("expected POLICY, PROCEDURE, JOB or INDEX after DROP, got %s", p.curTok.Literal)
+}
+
+// parsePolicyTarget reads "name ON table", shared by CREATE and DROP POLICY.
+func (p *Parser) parsePolicyTarget() (string, string, error) {
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return "", "", fmt.Errorf("expected policy name, got %s", p.curTok.Literal)
+	}
+	name := p.curTok.Literal
+	p.nextToken()
+
+	if !p.curKeywordIs("ON") {
+		return "", "", fmt.Errorf("expected ON, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	table, err := p.parseTableName()
+	if err != nil {
+		return "", "", err
+	}
+
+	return name, table, nil
+}
+
+func (p *Parser) parseCreatePolicy() (*CreatePolicyStmt, error) {
+	name, table, err := p.parsePolicyTarget()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &CreatePolicyStmt{Name: name, Table: table}
+
+	if p.curWordIs("FOR") {
+		p.nextToken()
+		if p.curTok.Type != IDENTIFIER {
+			return nil, fmt.Errorf("expected user name after FOR, got %s", p.curTok.Literal)
+		}
+		stmt.User = p.curTok.Literal
+		p.nextToken()
+	}
+
+	if !p.curWordIs("USING") {
+		return nil, fmt.Errorf("expected USING, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	if p.curTok.Type != LPAREN {
+		return nil, fmt.Errorf("expected ( after USING, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	for {
+		cond, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		if cond.Expr != nil {
+			return nil, fmt.Errorf("policy conditions must compare a column to a value, got %s", cond.Column)
+		}
+		stmt.Conditions = append(stmt.Conditions, cond)
+
+		if !p.curKeywordIs("AND") {
+			break
+		}
+		p.nextToken()
+	}
+
+	if p.curTok.Type != RPAREN {
+		return nil, fmt.Errorf("expected ) after policy conditions, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	return stmt, nil
+}
+
+func (p *Parser) parseDropPolicy() (*DropPolicyStmt, error) {
+	name, table, err := p.parsePolicyTarget()
+	if err != nil {
+		return nil, err
+	}
+	return &DropPolicyStmt{Name: name, Table: table}, nil
+}
+
+// curWordIs matches a contextual keyword. Words such as USER and TO are only
+// special inside a few statements, so they stay usable as identifiers.
+func (p *Parser) curWordIs(word string) bool {
+	return p.curTok.Type == IDENTIFIER && strings.EqualFold(p.curTok.Literal, word)
+}
+
+func (p *Parser) parseCreateUser() (*CreateUserStmt, error) {
+	stmt := &CreateUserStmt{}
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected user name, got %s", p.curTok.Literal)
+	}
+	stmt.Name = p.curTok.Literal
+	p.nextToken()
+
+	if !p.curWordIs("WITH") {
+		return nil, fmt.Errorf("expected WITH PASSWORD, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	if !p.curWordIs("PASSWORD") {
+		return nil, fmt.Errorf("expected PASSWORD, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	if p.curTok.Type != STRING {
+		return nil, fmt.Errorf("expected password string, got %s", p.curTok.Literal)
+	}
+	stmt.Password = p.curTok.Literal
+	p.nextToken()
+
+	return stmt, nil
+}
+
+func (p *Parser) parseGrant() (*GrantStmt, error) {
+	stmt := &GrantStmt{Revoke: p.curKeywordIs("REVOKE")}
+	p.nextToken()
+
+	for {
+		if p.curTok.Type != KEYWORD && p.curTok.Type != IDENTIFIER {
+			return nil, fmt.Errorf("expected privilege, got %s", p.curTok.Literal)
+		}
+		stmt.Privileges = append(stmt.Privileges, strings.ToUpper(p.curTok.Literal))
+		p.nextToken()
+
+		if p.curTok.Type != COMMA {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.curKeywordIs("ON") {
+		return nil, fmt.Errorf("expected ON, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	if p.curTok.Type == ASTERISK {
+		stmt.Table = "*"
+		p.nextToken()
+	} else {
+		table, err := p.parseTableName()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Table = table
+	}
+
+	if stmt.Revoke && !p.curKeywordIs("FROM") {
+		return nil, fmt.Errorf("expected FROM, got %s", p.curTok.Literal)
+	}
+	if !stmt.Revoke && !p.curWordIs("TO") {
+		return nil, fmt.Errorf("expected TO, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected user name, got %s", p.curTok.Literal)
+	}
+	stmt.User = p.curTok.Literal
+	p.nextToken()
+
+	return stmt, nil
+}
+
+func (p *Parser) parseCreateSchema() (*CreateSchemaStmt, error) {
+	p.nextToken()
+
+	name, err := p.parseTableName()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("expected TABLE or INDEX after CREATE, got %s", p.curTok.Literal)
+	return &CreateSchemaStmt{Name: name}, nil
 }
 
 func (p *Parser) parseCreateTable() (*CreateTableStmt, error) {
 	stmt := &CreateTableStmt{}
 	p.nextToken()
 
-	if p.curTok.Type != IDENTIFIER {
-		return nil, fmt.Errorf("expected table name, got %s", p.curTok.Literal)
+	table, err := p.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = table
+
+	if p.curTok.Type != LPAREN {
+		return nil, fmt.Errorf("expected (, got %s", p.curTok.Literal)
 	}
-	stmt.Table = p.curTok.Literal
 	p.nextToken()
 
-	if p.curTok.Type != LPAREN {
-		return nil, fmt.Errorf("expected (, got %s", p.curTok.Literal)
-	}
-	p.nextToken()
+	cols, err := p.parseColumnDefList()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Columns = cols
+
+	for p.curKeywordIs("UNIQUE") {
+		p.nextToken()
+		if p.curTok.Type != LPAREN {
+			return nil, fmt.Errorf("expected ( after UNIQUE, got %s", p.curTok.Literal)
+		}
+		p.nextToken()
+
+		uniqueCols, err := p.parseColumnList()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Unique = append(stmt.Unique, uniqueCols)
+
+		if p.curTok.Type != RPAREN {
+			return nil, fmt.Errorf("expected ), got %s", p.curTok.Literal)
+		}
+		p.nextToken()
 
-	cols, err := p.parseColumnDefList()
-	if err != nil {
-		return nil, err
+		if p.curTok.Type != COMMA {
+			break
+		}
+		p.nextToken()
 	}
-	stmt.Columns = cols
 
 	if p.curTok.Type != RPAREN {
 		return nil, fmt.Errorf("expected ), got %s", p.curTok.Literal)
 	}
 	p.nextToken()
 
-	return stmt, nil
+	for {
+		switch {
+		case p.curWordIs("ENGINE") && stmt.Engine == "":
+			p.nextToken()
+			if p.curTok.Type == OPERATOR && p.curTok.Literal == "=" {
+				p.nextToken()
+			}
+			if p.curTok.Type != IDENTIFIER {
+				return nil, fmt.Errorf("expected storage engine name, got %s", p.curTok.Literal)
+			}
+			stmt.Engine = p.curTok.Literal
+			p.nextToken()
+		case p.curWordIs("CLUSTER") && stmt.ClusterKey == nil:
+			p.nextToken()
+			if !p.curKeywordIs("BY") {
+				return nil, fmt.Errorf("expected BY after CLUSTER, got %s", p.curTok.Literal)
+			}
+			p.nextToken()
+			if p.curTok.Type != LPAREN {
+				return nil, fmt.Errorf("expected ( after CLUSTER BY, got %s", p.curTok.Literal)
+			}
+			p.nextToken()
+			cols, err := p.parseColumnList()
+			if err != nil {
+				return nil, err
+			}
+			if p.curTok.Type != RPAREN {
+				return nil, fmt.Errorf("expected ), got %s", p.curTok.Literal)
+			}
+			p.nextToken()
+			stmt.ClusterKey = cols
+		case p.curWordIs("WITH") && stmt.FillFactor == 0 && !stmt.BloomFilter:
+			params, err := p.parseStorageParams()
+			if err != nil {
+				return nil, err
+			}
+			stmt.FillFactor, stmt.BloomFilter = params.fillFactor, params.bloomFilter
+		default:
+			return stmt, nil
+		}
+	}
 }
 
 func (p *Parser) parseCreateIndex() (*CreateIndexStmt, error) {
@@ -696,6 +2010,11 @@ func (p *Parser) parseCreateIndex() (*CreateIndexStmt, error) {
 	}
 	p.nextToken()
 
+	if p.curWordIs("CONCURRENTLY") && p.peekTok.Type == IDENTIFIER {
+		stmt.Concurrently = true
+		p.nextToken()
+	}
+
 	if p.curTok.Type != IDENTIFIER {
 		return nil, fmt.Errorf("expected index name, got %s", p.curTok.Literal)
 	}
@@ -707,11 +2026,11 @@ func (p *Parser) parseCreateIndex() (*CreateIndexStmt, error) {
 	}
 	p.nextToken()
 
-	if p.curTok.Type != IDENTIFIER {
-		return nil, fmt.Errorf("expected table name, got %s", p.curTok.Literal)
+	table, err := p.parseTableName()
+	if err != nil {
+		return nil, err
 	}
-	stmt.TableName = p.curTok.Literal
-	p.nextToken()
+	stmt.TableName = table
 
 	if p.curTok.Type != LPAREN {
 		return nil, fmt.Errorf("expected (, got %s", p.curTok.Literal)
@@ -729,27 +2048,113 @@ func (p *Parser) parseCreateIndex() (*CreateIndexStmt, error) {
 	}
 	p.nextToken()
 
+	if p.curWordIs("WITH") {
+		params, err := p.parseStorageParams()
+		if err != nil {
+			return nil, err
+		}
+		if params.bloomFilter {
+			return nil, fmt.Errorf("BLOOM_FILTER is set on the table, not on an index")
+		}
+		stmt.FillFactor = params.fillFactor
+	}
+
 	return stmt, nil
 }
 
+// storageParams are the parameters of WITH (...) after a table or index.
+type storageParams struct {
+	fillFactor  int
+	bloomFilter bool
+}
+
+// parseStorageParams parses WITH (name = value, ...), the storage
+// parameters of tables and indexes.
+func (p *Parser) parseStorageParams() (storageParams, error) {
+	var params storageParams
+	p.nextToken()
+	if p.curTok.Type != LPAREN {
+		return params, fmt.Errorf("expected ( after WITH, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	for {
+		name := strings.ToUpper(p.curTok.Literal)
+		if p.curTok.Type != IDENTIFIER || (name != "FILLFACTOR" && name != "BLOOM_FILTER") {
+			return params, fmt.Errorf("expected FILLFACTOR or BLOOM_FILTER, got %s", p.curTok.Literal)
+		}
+		p.nextToken()
+		if p.curTok.Type != OPERATOR || p.curTok.Literal != "=" {
+			return params, fmt.Errorf("expected = after %s, got %s", name, p.curTok.Literal)
+		}
+		p.nextToken()
+
+		switch name {
+		case "FILLFACTOR":
+			if p.curTok.Type != NUMBER {
+				return params, fmt.Errorf("expected a number for FILLFACTOR, got %s", p.curTok.Literal)
+			}
+			fillFactor, err := strconv.Atoi(p.curTok.Literal)
+			if err != nil || fillFactor == 0 {
+				return params, fmt.Errorf("invalid FILLFACTOR %s", p.curTok.Literal)
+			}
+			params.fillFactor = fillFactor
+		case "BLOOM_FILTER":
+			switch {
+			case p.curKeywordIs("ON") || p.curWordIs("TRUE"):
+				params.bloomFilter = true
+			case p.curWordIs("OFF") || p.curWordIs("FALSE"):
+				params.bloomFilter = false
+			default:
+				return params, fmt.Errorf("expected ON or OFF for BLOOM_FILTER, got %s", p.curTok.Literal)
+			}
+		}
+		p.nextToken()
+
+		if p.curTok.Type != COMMA {
+			break
+		}
+		p.nextToken()
+	}
+
+	if p.curTok.Type != RPAREN {
+		return params, fmt.Errorf("expected ), got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+	return params, nil
+}
+
 func (p *Parser) parseColumnDefList() ([]ColumnDef, error) {
 	cols := []ColumnDef{}
 
 	for {
 		colDef := ColumnDef{}
 
+		if p.curKeywordIs("UNIQUE") && p.peekTok.Type == LPAREN {
+			break
+		}
+
 		if p.curTok.Type != IDENTIFIER {
 			return nil, fmt.Errorf("expected column name, got %s", p.curTok.Literal)
 		}
 		colDef.Name = p.curTok.Literal
 		p.nextToken()
 
-		if p.curTok.Type != KEYWORD {
+		if p.curTok.Type != KEYWORD && p.curTok.Type != IDENTIFIER {
 			return nil, fmt.Errorf("expected data type, got %s", p.curTok.Literal)
 		}
 		colDef.Type = p.curTok.Literal
 		p.nextToken()
 
+		if strings.EqualFold(colDef.Type, "ENUM") {
+			values, err := p.parseEnumValues()
+			if err != nil {
+				return nil, err
+			}
+			colDef.Type = "ENUM"
+			colDef.EnumValues = values
+		}
+
 		for {
 			if p.curKeywordIs("PRIMARY") && p.peekKeywordIs("KEY") {
 				colDef.PrimaryKey = true
@@ -781,6 +2186,34 @@ func (p *Parser) parseColumnDefList() ([]ColumnDef, error) {
 	return cols, nil
 }
 
+func (p *Parser) parseEnumValues() ([]string, error) {
+	if p.curTok.Type != LPAREN {
+		return nil, fmt.Errorf("expected ( after ENUM, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	values := []string{}
+	for {
+		if p.curTok.Type != STRING {
+			return nil, fmt.Errorf("expected string value in ENUM, got %s", p.curTok.Literal)
+		}
+		values = append(values, p.curTok.Literal)
+		p.nextToken()
+
+		if p.curTok.Type != COMMA {
+			break
+		}
+		p.nextToken()
+	}
+
+	if p.curTok.Type != RPAREN {
+		return nil, fmt.Errorf("expected ) after ENUM values, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	return values, nil
+}
+
 func (p *Parser) parseColumnList() ([]string, error) {
 	cols := []string{}
 
@@ -812,16 +2245,141 @@ func (p *Parser) parseColumnList() ([]string, error) {
 	return cols, nil
 }
 
-func (p *Parser) parseValueList() ([]string, error) {
-	vals := []string{}
+// parseSelectList is a column list that may also contain aggregate calls,
+// kept in their canonical text form such as "SUM(amount)", and scalar
+// expressions, which are returned alongside their text in exprs. Each item
+// may be followed by an alias, with or without AS; aliases is nil when none
+// is.
+func (p *Parser) parseSelectList() ([]string, []Expr, []string, error) {
+	cols := []string{}
+	exprs := []Expr{}
+	aliases := []string{}
+	aliased := false
 
 	for {
-		if p.curTok.Type == STRING || p.curTok.Type == NUMBER || p.curTok.Type == IDENTIFIER {
-			vals = append(vals, p.curTok.Literal)
+		switch {
+		case p.curTok.Type == IDENTIFIER && IsAggregate(p.curTok.Literal) && p.peekTok.Type == LPAREN:
+			agg, err := p.parseAggregate()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			cols = append(cols, agg)
+			exprs = append(exprs, nil)
+
+		default:
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			cols = append(cols, expr.String())
+			if _, plain := expr.(*ColumnExpr); plain {
+				exprs = append(exprs, nil)
+			} else {
+				exprs = append(exprs, expr)
+			}
+		}
+
+		alias := ""
+		if p.curKeywordIs("AS") {
 			p.nextToken()
-		} else {
+			if p.curTok.Type != IDENTIFIER {
+				return nil, nil, nil, fmt.Errorf("expected column alias after AS, got %s", p.curTok.Literal)
+			}
+		}
+		if p.curTok.Type == IDENTIFIER {
+			alias = p.curTok.Literal
+			aliased = true
+			p.nextToken()
+		}
+		aliases = append(aliases, alias)
+
+		if p.curTok.Type != COMMA {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !aliased {
+		aliases = nil
+	}
+	return cols, exprs, aliases, nil
+}
+
+func (p *Parser) parseAggregate() (string, error) {
+	name := strings.ToUpper(p.curTok.Literal)
+	p.nextToken()
+	p.nextToken()
+
+	var arg string
+	if p.curTok.Type == ASTERISK {
+		if name != "COUNT" {
+			return "", fmt.Errorf("%s(*) is not supported", name)
+		}
+		arg = "*"
+		p.nextToken()
+	} else {
+		if p.curTok.Type != IDENTIFIER {
+			return "", fmt.Errorf("expected column name in %s, got %s", name, p.curTok.Literal)
+		}
+		arg = p.curTok.Literal
+		p.nextToken()
+
+		if p.curTok.Type == DOT {
+			p.nextToken()
+			if p.curTok.Type != IDENTIFIER {
+				return "", fmt.Errorf("expected column name after dot, got %s", p.curTok.Literal)
+			}
+			arg = arg + "." + p.curTok.Literal
+			p.nextToken()
+		}
+	}
+
+	if p.curTok.Type != RPAREN {
+		return "", fmt.Errorf("expected ) after %s argument, got %s", name, p.curTok.Literal)
+	}
+	p.nextToken()
+
+	return name + "(" + arg + ")", nil
+}
+
+var aggregateNames = []string{"COUNT", "SUM", "AVG", "MIN", "MAX"}
+
+func IsAggregate(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, agg := range aggregateNames {
+		if upper == agg {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitAggregate breaks a canonical aggregate such as "SUM(amount)" into its
+// function name and argument.
+func SplitAggregate(expr string) (string, string, bool) {
+	open := strings.IndexByte(expr, '(')
+	if open <= 0 || !strings.HasSuffix(expr, ")") {
+		return "", "", false
+	}
+	name := expr[:open]
+	if !IsAggregate(name) {
+		return "", "", false
+	}
+	return name, expr[open+1 : len(expr)-1], true
+}
+
+func (p *Parser) parseValueList() ([]Value, error) {
+	vals := []Value{}
+
+	for {
+		value, ok, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
 			return nil, fmt.Errorf("expected value, got %s", p.curTok.Literal)
 		}
+		vals = append(vals, value)
 
 		if p.curTok.Type != COMMA {
 			break
@@ -836,11 +2394,11 @@ func (p *Parser) parseUpdate() (*UpdateStmt, error) {
 	stmt := &UpdateStmt{}
 	p.nextToken()
 
-	if p.curTok.Type != IDENTIFIER {
-		return nil, fmt.Errorf("expected table name, got %s", p.curTok.Literal)
+	table, err := p.parseTableName()
+	if err != nil {
+		return nil, err
 	}
-	stmt.Table = p.curTok.Literal
-	p.nextToken()
+	stmt.Table = table
 
 	if !p.curKeywordIs("SET") {
 		return nil, fmt.Errorf("expected SET, got %s", p.curTok.Literal)
@@ -860,12 +2418,14 @@ func (p *Parser) parseUpdate() (*UpdateStmt, error) {
 		}
 		p.nextToken()
 
-		if p.curTok.Type == STRING || p.curTok.Type == NUMBER || p.curTok.Type == IDENTIFIER {
-			asgn.Value = p.curTok.Literal
-			p.nextToken()
-		} else {
+		value, ok, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
 			return nil, fmt.Errorf("expected value in SET, got %s", p.curTok.Literal)
 		}
+		asgn.Value = value
 
 		stmt.Assignments = append(stmt.Assignments, asgn)
 
@@ -883,31 +2443,150 @@ func (p *Parser) parseUpdate() (*UpdateStmt, error) {
 		stmt.Where = where
 	}
 
+	stmt.OrderBy, stmt.Limit, err = p.parseOrderLimit()
+	if err != nil {
+		return nil, err
+	}
 	return stmt, nil
 }
 
-func (p *Parser) parseWhere() (*WhereClause, error) {
-	where := &WhereClause{}
+func (p *Parser) parseExplain() (*ExplainStmt, error) {
 	p.nextToken()
+	verbose := p.curWordIs("VERBOSE")
+	if verbose {
+		p.nextToken()
+	}
 
-	for {
-		cond, err := p.parseCondition()
+	var stmt Node
+	var err error
+	switch {
+	case p.curKeywordIs("SELECT"):
+		stmt, err = p.parseSelect()
+	case p.curKeywordIs("INSERT"):
+		stmt, err = p.parseInsert()
+	case p.curKeywordIs("UPDATE"):
+		stmt, err = p.parseUpdate()
+	case p.curKeywordIs("DELETE"):
+		stmt, err = p.parseDelete()
+	default:
+		return nil, fmt.Errorf("expected SELECT, INSERT, UPDATE or DELETE after EXPLAIN, got %s", p.curTok.Literal)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ExplainStmt{Stmt: stmt, Verbose: verbose}, nil
+}
+
+func (p *Parser) parseAnalyze() (*AnalyzeStmt, error) {
+	stmt := &AnalyzeStmt{}
+	p.nextToken()
+
+	if p.curTok.Type == IDENTIFIER {
+		table, err := p.parseTableName()
 		if err != nil {
 			return nil, err
 		}
+		stmt.Table = table
+	}
 
-		where.Conditions = append(where.Conditions, cond)
+	return stmt, nil
+}
 
-		if !p.curKeywordIs("AND") && !p.curKeywordIs("OR") {
-			break
+// parseTableName reads a table name, optionally qualified by a schema, the
+// alias of an attached database, or both (db.schema.table).
+func (p *Parser) parseTableName() (string, error) {
+	if p.curTok.Type != IDENTIFIER {
+		return "", fmt.Errorf("expected table name, got %s", p.curTok.Literal)
+	}
+	name := p.curTok.Literal
+	p.nextToken()
+
+	for p.curTok.Type == DOT {
+		p.nextToken()
+		if p.curTok.Type != IDENTIFIER {
+			return "", fmt.Errorf("expected table name after dot, got %s", p.curTok.Literal)
 		}
+		name = name + "." + p.curTok.Literal
+		p.nextToken()
+	}
+
+	return name, nil
+}
+
+func (p *Parser) parseAttach() (*AttachStmt, error) {
+	stmt := &AttachStmt{}
+	p.nextToken()
+
+	if p.curKeywordIs("DATABASE") {
+		p.nextToken()
+	}
+
+	if p.curTok.Type != STRING {
+		return nil, fmt.Errorf("expected database file name, got %s", p.curTok.Literal)
+	}
+	stmt.File = p.curTok.Literal
+	p.nextToken()
+
+	if !p.curKeywordIs("AS") {
+		return nil, fmt.Errorf("expected AS, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected database alias, got %s", p.curTok.Literal)
+	}
+	stmt.Alias = p.curTok.Literal
+	p.nextToken()
+
+	return stmt, nil
+}
+
+func (p *Parser) parseDetach() (*DetachStmt, error) {
+	stmt := &DetachStmt{}
+	p.nextToken()
+
+	if p.curKeywordIs("DATABASE") {
 		p.nextToken()
 	}
 
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected database alias, got %s", p.curTok.Literal)
+	}
+	stmt.Alias = p.curTok.Literal
+	p.nextToken()
+
+	return stmt, nil
+}
+
+// parseWhere parses the conditions of WHERE or HAVING, combined with AND,
+// OR, NOT and parentheses, see Predicate.
+func (p *Parser) parseWhere() (*WhereClause, error) {
+	p.nextToken()
+	pred, err := p.parseOr(false)
+	if err != nil {
+		return nil, err
+	}
+	where := &WhereClause{}
+	where.add(pred)
 	return where, nil
 }
 
-func Parse(input string) (Node, error) {
+func Parse(input string) (node Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			node, err = nil, sqlerr.New(sqlerr.SyntaxError, "malformed statement: %v", r)
+		}
+		if err != nil {
+			if feature := unsupportedFeature(input); feature != "" {
+				node, err = nil, sqlerr.New(sqlerr.FeatureNotSupported, "not supported yet: %s", feature)
+			}
+		}
+	}()
+
 	parser := NewParser(input)
-	return parser.Parse()
+	node, err = parser.Parse()
+	if err != nil {
+		return nil, sqlerr.Wrap(sqlerr.SyntaxError, err)
+	}
+	return node, nil
 }
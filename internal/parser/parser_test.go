@@ -0,0 +1,31 @@
+package parser
+
+import "testing"
+
+// FuzzParse calls the parser without the panic recovery in Parse, so that
+// a crash fails the fuzz run instead of becoming a syntax error.
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"SELECT id, name FROM users WHERE age > 25 ORDER BY name DESC LIMIT 10 OFFSET 5",
+		"SELECT u.name, COUNT(*) AS n FROM users u JOIN orders o ON u.id = o.user_id GROUP BY u.name HAVING COUNT(*) > 1",
+		"SELECT name FROM users WHERE id IN (SELECT user_id FROM orders WHERE total > 100)",
+		"SELECT name FROM users u WHERE NOT EXISTS (SELECT id FROM orders o WHERE o.user_id = u.id)",
+		"SELECT doc->>'a', CAST(n AS TEXT), UPPER(name) FROM t WHERE (a = 1 OR b = 'x') AND NOT c IS NULL",
+		"SELECT value FROM generate_series(1, 10) AS g",
+		"INSERT INTO users (id, name) VALUES (1, 'alice');",
+		"UPDATE users SET age = 26, name = 'bob' WHERE id = 1 ORDER BY id LIMIT 1",
+		"DELETE FROM users WHERE name = 'a;b' -- trailing comment",
+		"CREATE TABLE t (id INT PRIMARY KEY, name TEXT NOT NULL UNIQUE, n INT, UNIQUE (name, n))",
+		"CREATE UNIQUE INDEX CONCURRENTLY t_name ON t (name)",
+		"CREATE PROCEDURE p(n INT) BEGIN DECLARE x INT DEFAULT 0; IF n > 1 THEN DELETE FROM t; END IF; END",
+		"EXPLAIN VERBOSE SELECT * FROM t WHERE a = 10",
+		"'unterminated",
+		"SELECT ((((((((((a))))))))) FROM t",
+		"",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, sql string) {
+		NewParser(sql).Parse()
+	})
+}
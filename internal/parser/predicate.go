@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Predicate is the AND or OR of Args, or the single condition Cond when Op
+// is empty. It holds no NOT: parseWhere moves each NOT down to the
+// conditions under it, by De Morgan's laws, and negates their operators.
+// A condition on NULL is never true either way, so NOT (a = 1) and a != 1
+// keep the same rows.
+type Predicate struct {
+	Op   string
+	Args []Predicate
+	Cond Condition
+}
+
+func (p Predicate) String() string {
+	if p.Op == "" {
+		return p.Cond.String()
+	}
+	args := make([]string, len(p.Args))
+	for i, arg := range p.Args {
+		args[i] = arg.String()
+	}
+	return "(" + strings.Join(args, " "+p.Op+" ") + ")"
+}
+
+// negatedOperators maps each condition operator to its negation.
+var negatedOperators = map[string]string{
+	"=":          "!=",
+	"!=":         "=",
+	"<>":         "=",
+	"<":          ">=",
+	">=":         "<",
+	">":          "<=",
+	"<=":         ">",
+	"LIKE":       "NOT LIKE",
+	"NOT LIKE":   "LIKE",
+	"ILIKE":      "NOT ILIKE",
+	"NOT ILIKE":  "ILIKE",
+	"REGEXP":     "NOT REGEXP",
+	"NOT REGEXP": "REGEXP",
+	"IN":         "NOT IN",
+	"NOT IN":     "IN",
+	"EXISTS":     "NOT EXISTS",
+	"NOT EXISTS": "EXISTS",
+}
+
+// add ANDs pred into w: its plain conditions, however deep in ANDs, join
+// Conditions, and each OR joins Predicates.
+func (w *WhereClause) add(pred Predicate) {
+	switch pred.Op {
+	case "":
+		w.Conditions = append(w.Conditions, pred.Cond)
+	case "AND":
+		for _, arg := range pred.Args {
+			w.add(arg)
+		}
+	default:
+		w.Predicates = append(w.Predicates, pred)
+	}
+}
+
+func (w *WhereClause) String() string {
+	terms := make([]string, 0, len(w.Conditions)+len(w.Predicates))
+	for _, cond := range w.Conditions {
+		terms = append(terms, cond.String())
+	}
+	for _, pred := range w.Predicates {
+		terms = append(terms, pred.String())
+	}
+	return "[" + strings.Join(terms, " ") + "]"
+}
+
+// parseOr parses conditions joined by OR, AND binding tighter, or with
+// negate their negation.
+func (p *Parser) parseOr(negate bool) (Predicate, error) {
+	return p.parseJoined("OR", negate, p.parseAnd)
+}
+
+func (p *Parser) parseAnd(negate bool) (Predicate, error) {
+	return p.parseJoined("AND", negate, p.parseNot)
+}
+
+// parseJoined parses operands joined by op. Negated, they are joined by
+// the other of AND and OR instead.
+func (p *Parser) parseJoined(op string, negate bool, operand func(bool) (Predicate, error)) (Predicate, error) {
+	first, err := operand(negate)
+	if err != nil {
+		return Predicate{}, err
+	}
+	if !p.curKeywordIs(op) {
+		return first, nil
+	}
+
+	joined := Predicate{Op: op}
+	if negate {
+		joined.Op = map[string]string{"AND": "OR", "OR": "AND"}[op]
+	}
+	joined.join(first)
+	for p.curKeywordIs(op) {
+		p.nextToken()
+		next, err := operand(negate)
+		if err != nil {
+			return Predicate{}, err
+		}
+		joined.join(next)
+	}
+	return joined, nil
+}
+
+// join adds arg to the Args of p, flattening an arg with the same Op.
+func (p *Predicate) join(arg Predicate) {
+	if arg.Op == p.Op {
+		p.Args = append(p.Args, arg.Args...)
+		return
+	}
+	p.Args = append(p.Args, arg)
+}
+
+// parseNot parses a condition, a parenthesized predicate or NOT before
+// either.
+func (p *Parser) parseNot(negate bool) (Predicate, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return Predicate{}, fmt.Errorf("condition nested too deeply")
+	}
+
+	// NOT EXISTS is a condition of its own.
+	if p.curKeywordIs("NOT") && !p.peekWordIs("EXISTS") {
+		p.nextToken()
+		return p.parseNot(!negate)
+	}
+
+	if p.curTok.Type == LPAREN {
+		p.nextToken()
+		pred, err := p.parseOr(negate)
+		if err != nil {
+			return Predicate{}, err
+		}
+		if p.curTok.Type != RPAREN {
+			return Predicate{}, fmt.Errorf("expected ) after condition, got %s", p.curTok.Literal)
+		}
+		p.nextToken()
+		return pred, nil
+	}
+
+	cond, err := p.parseCondition()
+	if err != nil {
+		return Predicate{}, err
+	}
+	if negate {
+		op, ok := negatedOperators[cond.Operator]
+		if !ok {
+			return Predicate{}, fmt.Errorf("cannot negate operator %s", cond.Operator)
+		}
+		cond.Operator = op
+	}
+	return Predicate{Cond: cond}, nil
+}
+
+// Conditions returns the conditions of p, left to right.
+func (p Predicate) Conditions() []Condition {
+	if p.Op == "" {
+		return []Condition{p.Cond}
+	}
+	var conds []Condition
+	for _, arg := range p.Args {
+		conds = append(conds, arg.Conditions()...)
+	}
+	return conds
+}
@@ -0,0 +1,589 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CreateProcedureStmt is CREATE PROCEDURE. Source is the statement as
+// written, which is what the catalog keeps.
+type CreateProcedureStmt struct {
+	Name   string
+	Params []ColumnDef
+	Body   []ProcStmt
+	Source string
+}
+
+func (c *CreateProcedureStmt) String() string {
+	params := make([]string, len(c.Params))
+	for i, param := range c.Params {
+		params[i] = param.String()
+	}
+	return fmt.Sprintf("CREATE PROCEDURE %s(%s)", c.Name, strings.Join(params, ", "))
+}
+
+type DropProcedureStmt struct {
+	Name string
+}
+
+func (d *DropProcedureStmt) String() string {
+	return fmt.Sprintf("DROP PROCEDURE %s", d.Name)
+}
+
+type CallStmt struct {
+	Name string
+	Args []Value
+}
+
+func (c *CallStmt) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("CALL %s(%s)", c.Name, strings.Join(args, ", "))
+}
+
+// ProcStmt is a statement in the body of a procedure.
+type ProcStmt interface {
+	String() string
+}
+
+// DeclareStmt declares a variable, which is NULL unless it has a Default.
+type DeclareStmt struct {
+	Name    string
+	Type    string
+	Default *Value
+}
+
+func (d *DeclareStmt) String() string {
+	if d.Default != nil {
+		return fmt.Sprintf("DECLARE %s %s DEFAULT %s", d.Name, d.Type, d.Default)
+	}
+	return fmt.Sprintf("DECLARE %s %s", d.Name, d.Type)
+}
+
+// AssignStmt sets a variable to Value, or, when Query is set, to the first
+// column of the first row the query returns.
+type AssignStmt struct {
+	Name  string
+	Value Value
+	Query string
+}
+
+func (a *AssignStmt) String() string {
+	if a.Query != "" {
+		return fmt.Sprintf("SET %s = (%s)", a.Name, a.Query)
+	}
+	return fmt.Sprintf("SET %s = %s", a.Name, a.Value)
+}
+
+// IfStmt runs the body of the first branch whose conditions all hold, or
+// Else if none does.
+type IfStmt struct {
+	Branches []IfBranch
+	Else     []ProcStmt
+}
+
+type IfBranch struct {
+	Conditions []ProcCondition
+	Body       []ProcStmt
+}
+
+func (i *IfStmt) String() string {
+	var b strings.Builder
+	for n, branch := range i.Branches {
+		if n == 0 {
+			b.WriteString("IF ")
+		} else {
+			b.WriteString(" ELSIF ")
+		}
+		fmt.Fprintf(&b, "%v THEN %v", branch.Conditions, branch.Body)
+	}
+	if i.Else != nil {
+		fmt.Fprintf(&b, " ELSE %v", i.Else)
+	}
+	return b.String() + " END IF"
+}
+
+// ProcCondition is a condition in a procedure: a Condition comparing
+// variables and values, or, when Query is set, EXISTS or NOT EXISTS, as
+// Operator says, over the query.
+type ProcCondition struct {
+	Condition
+	Query string
+}
+
+func (c ProcCondition) String() string {
+	if c.Query != "" {
+		return fmt.Sprintf("%s (%s)", c.Operator, c.Query)
+	}
+	return c.Condition.String()
+}
+
+// ForStmt runs Body once for each row Query returns, with Var naming the
+// row.
+type ForStmt struct {
+	Var   string
+	Query string
+	Body  []ProcStmt
+}
+
+func (f *ForStmt) String() string {
+	return fmt.Sprintf("FOR %s IN %s LOOP %v END LOOP", f.Var, f.Query, f.Body)
+}
+
+// SQLStmt is any other statement, kept as written. Variables in it are
+// replaced by their values before it runs.
+type SQLStmt struct {
+	Source string
+}
+
+func (s *SQLStmt) String() string {
+	return s.Source
+}
+
+// parseCreateProcedure parses CREATE PROCEDURE from its name on; start is
+// the offset of CREATE.
+func (p *Parser) parseCreateProcedure(start int) (*CreateProcedureStmt, error) {
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected procedure name, got %s", p.curTok.Literal)
+	}
+	stmt := &CreateProcedureStmt{Name: p.curTok.Literal}
+	p.nextToken()
+
+	if p.curTok.Type != LPAREN {
+		return nil, fmt.Errorf("expected ( after procedure name, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+	if p.curTok.Type != RPAREN {
+		params, err := p.parseColumnDefList()
+		if err != nil {
+			return nil, err
+		}
+		if p.curTok.Type != RPAREN {
+			return nil, fmt.Errorf("expected ) after parameters, got %s", p.curTok.Literal)
+		}
+		stmt.Params = params
+	}
+	p.nextToken()
+
+	if p.curKeywordIs("AS") {
+		p.nextToken()
+	}
+	if !p.curWordIs("BEGIN") {
+		return nil, fmt.Errorf("expected BEGIN, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+
+	body, err := p.parseProcBody("END")
+	if err != nil {
+		return nil, err
+	}
+	stmt.Body = body
+	p.nextToken()
+
+	stmt.Source = strings.TrimSpace(p.lexer.input[start:p.curTok.Pos])
+	return stmt, nil
+}
+
+// parseProcBody parses statements up to one of the words that end a block,
+// which it leaves as the current token.
+func (p *Parser) parseProcBody(ends ...string) ([]ProcStmt, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, fmt.Errorf("procedure blocks nested too deeply")
+	}
+
+	body := []ProcStmt{}
+	for {
+		for _, end := range ends {
+			if p.curWordIs(end) {
+				return body, nil
+			}
+		}
+		if p.curTok.Type == EOF {
+			return nil, fmt.Errorf("expected %s before end of procedure", strings.Join(ends, " or "))
+		}
+
+		var stmt ProcStmt
+		var err error
+		switch {
+		case p.curWordIs("DECLARE"):
+			stmt, err = p.parseDeclare()
+		case p.curKeywordIs("SET"):
+			stmt, err = p.parseAssign()
+		case p.curWordIs("IF"):
+			stmt, err = p.parseIf()
+		case p.curWordIs("FOR"):
+			stmt, err = p.parseFor()
+		default:
+			stmt, err = p.parseSQLStmt()
+		}
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, stmt)
+	}
+}
+
+func (p *Parser) expectSemicolon(after string) error {
+	if p.curTok.Type != SEMICOLON {
+		return fmt.Errorf("expected ; after %s, got %s", after, p.curTok.Literal)
+	}
+	p.nextToken()
+	return nil
+}
+
+// expectWords consumes words, such as END IF, in order.
+func (p *Parser) expectWords(words ...string) error {
+	for _, word := range words {
+		if !p.curWordIs(word) {
+			return fmt.Errorf("expected %s, got %s", strings.Join(words, " "), p.curTok.Literal)
+		}
+		p.nextToken()
+	}
+	return nil
+}
+
+func (p *Parser) parseDeclare() (*DeclareStmt, error) {
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected variable name, got %s", p.curTok.Literal)
+	}
+	stmt := &DeclareStmt{Name: p.curTok.Literal}
+	p.nextToken()
+
+	if p.curTok.Type != KEYWORD && p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected data type, got %s", p.curTok.Literal)
+	}
+	stmt.Type = p.curTok.Literal
+	p.nextToken()
+
+	if p.curWordIs("DEFAULT") || (p.curTok.Type == OPERATOR && p.curTok.Literal == "=") {
+		p.nextToken()
+		value, err := p.parseProcValue()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Default = &value
+	}
+	return stmt, p.expectSemicolon("DECLARE")
+}
+
+func (p *Parser) parseAssign() (*AssignStmt, error) {
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected variable name after SET, got %s", p.curTok.Literal)
+	}
+	stmt := &AssignStmt{Name: p.curTok.Literal}
+	p.nextToken()
+
+	if p.curTok.Type != OPERATOR || p.curTok.Literal != "=" {
+		return nil, fmt.Errorf("expected = after %s, got %s", stmt.Name, p.curTok.Literal)
+	}
+	p.nextToken()
+
+	if p.curTok.Type == LPAREN {
+		query, err := p.parseQueryText()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Query = query
+	} else {
+		value, err := p.parseProcValue()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Value = value
+	}
+	return stmt, p.expectSemicolon("SET")
+}
+
+// parseProcValue parses a value, which may name a variable or, as r.id, a
+// column of a FOR loop's row.
+func (p *Parser) parseProcValue() (Value, error) {
+	value, ok, err := p.parseValue()
+	if err != nil {
+		return value, err
+	}
+	if !ok {
+		return value, fmt.Errorf("expected value, got %s", p.curTok.Literal)
+	}
+	if value.Kind == Identifier && p.curTok.Type == DOT {
+		p.nextToken()
+		if p.curTok.Type != IDENTIFIER {
+			return value, fmt.Errorf("expected identifier after dot, got %s", p.curTok.Literal)
+		}
+		value.Text = value.Text + "." + p.curTok.Literal
+		p.nextToken()
+	}
+	return value, nil
+}
+
+func (p *Parser) parseIf() (*IfStmt, error) {
+	stmt := &IfStmt{}
+	for {
+		p.nextToken()
+		conditions, err := p.parseProcConditions()
+		if err != nil {
+			return nil, err
+		}
+		if !p.curWordIs("THEN") {
+			return nil, fmt.Errorf("expected THEN, got %s", p.curTok.Literal)
+		}
+		p.nextToken()
+
+		body, err := p.parseProcBody("ELSIF", "ELSE", "END")
+		if err != nil {
+			return nil, err
+		}
+		stmt.Branches = append(stmt.Branches, IfBranch{Conditions: conditions, Body: body})
+		if !p.curWordIs("ELSIF") {
+			break
+		}
+	}
+
+	if p.curWordIs("ELSE") {
+		p.nextToken()
+		body, err := p.parseProcBody("END")
+		if err != nil {
+			return nil, err
+		}
+		stmt.Else = body
+	}
+	if err := p.expectWords("END", "IF"); err != nil {
+		return nil, err
+	}
+	return stmt, p.expectSemicolon("END IF")
+}
+
+// parseProcConditions parses conditions joined by AND, up to THEN.
+func (p *Parser) parseProcConditions() ([]ProcCondition, error) {
+	var conditions []ProcCondition
+	for {
+		var cond ProcCondition
+		if (p.curWordIs("EXISTS") && p.peekTok.Type == LPAREN) || (p.curKeywordIs("NOT") && p.peekWordIs("EXISTS")) {
+			cond.Operator = "EXISTS"
+			if p.curKeywordIs("NOT") {
+				cond.Operator = "NOT EXISTS"
+				p.nextToken()
+			}
+			p.nextToken()
+			query, err := p.parseQueryText()
+			if err != nil {
+				return nil, err
+			}
+			cond.Query = query
+		} else {
+			c, err := p.parseCondition()
+			if err != nil {
+				return nil, err
+			}
+			if c.Subquery != nil {
+				return nil, fmt.Errorf("%s (SELECT ...) is not supported in a procedure condition; use EXISTS", c.Operator)
+			}
+			cond.Condition = c
+		}
+		conditions = append(conditions, cond)
+
+		if p.curKeywordIs("OR") {
+			return nil, fmt.Errorf("OR is not supported in a procedure condition")
+		}
+		if !p.curKeywordIs("AND") {
+			return conditions, nil
+		}
+		p.nextToken()
+	}
+}
+
+func (p *Parser) parseFor() (*ForStmt, error) {
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected loop variable after FOR, got %s", p.curTok.Literal)
+	}
+	stmt := &ForStmt{Var: p.curTok.Literal}
+	p.nextToken()
+
+	if !p.curWordIs("IN") {
+		return nil, fmt.Errorf("expected IN after %s, got %s", stmt.Var, p.curTok.Literal)
+	}
+	p.nextToken()
+
+	if p.curTok.Type == LPAREN {
+		query, err := p.parseQueryText()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Query = query
+	} else {
+		query, err := p.scanTo(func() bool { return p.curWordIs("LOOP") }, "LOOP")
+		if err != nil {
+			return nil, err
+		}
+		if err := checkQuery(p.maskLoopRows(query)); err != nil {
+			return nil, err
+		}
+		stmt.Query = query
+	}
+	if err := p.expectWords("LOOP"); err != nil {
+		return nil, err
+	}
+
+	p.loops = append(p.loops, stmt.Var)
+	body, err := p.parseProcBody("END")
+	p.loops = p.loops[:len(p.loops)-1]
+	if err != nil {
+		return nil, err
+	}
+	stmt.Body = body
+	if err := p.expectWords("END", "LOOP"); err != nil {
+		return nil, err
+	}
+	return stmt, p.expectSemicolon("END LOOP")
+}
+
+// parseSQLStmt takes the text up to the next semicolon as a statement,
+// checking that it parses.
+func (p *Parser) parseSQLStmt() (*SQLStmt, error) {
+	source, err := p.scanTo(func() bool { return p.curTok.Type == SEMICOLON }, ";")
+	if err != nil {
+		return nil, err
+	}
+	node, err := Parse(p.maskLoopRows(source))
+	if err != nil {
+		return nil, err
+	}
+	switch node.(type) {
+	case *SelectStmt:
+		return nil, fmt.Errorf("a query in a procedure must be the query of FOR or SET")
+	case *CreateProcedureStmt:
+		return nil, fmt.Errorf("procedures cannot be created inside a procedure")
+	}
+	p.nextToken()
+	return &SQLStmt{Source: source}, nil
+}
+
+// parseQueryText parses a SELECT in parentheses and returns its text.
+func (p *Parser) parseQueryText() (string, error) {
+	if p.curTok.Type != LPAREN {
+		return "", fmt.Errorf("expected ( before subquery, got %s", p.curTok.Literal)
+	}
+	p.nextToken()
+	if !p.curKeywordIs("SELECT") {
+		return "", fmt.Errorf("expected SELECT in subquery, got %s", p.curTok.Literal)
+	}
+	start := p.curTok.Pos
+	if _, err := p.parseSelect(); err != nil {
+		return "", err
+	}
+	if p.curTok.Type != RPAREN {
+		return "", fmt.Errorf("expected ) after subquery, got %s", p.curTok.Literal)
+	}
+	query := strings.TrimSpace(p.lexer.input[start:p.curTok.Pos])
+	p.nextToken()
+	return query, nil
+}
+
+// scanTo skips tokens until stop reports true outside any parentheses,
+// and returns the text skipped. The token stop saw is left current.
+func (p *Parser) scanTo(stop func() bool, what string) (string, error) {
+	start := p.curTok.Pos
+	depth := 0
+	for depth > 0 || !stop() {
+		switch p.curTok.Type {
+		case EOF:
+			return "", fmt.Errorf("expected %s before end of procedure", what)
+		case LPAREN:
+			depth++
+		case RPAREN:
+			depth--
+		}
+		p.nextToken()
+	}
+	return strings.TrimSpace(p.lexer.input[start:p.curTok.Pos]), nil
+}
+
+// maskLoopRows replaces each row.column naming a column of a loop's row
+// with NULL, so that a statement using one can be checked before the row
+// exists.
+func (p *Parser) maskLoopRows(sql string) string {
+	if len(p.loops) == 0 {
+		return sql
+	}
+	lexer := NewLexer(sql)
+	var b strings.Builder
+	last := 0
+	tok := lexer.NextToken()
+	for tok.Type != EOF && tok.Type != ILLEGAL {
+		next := lexer.NextToken()
+		if tok.Type == IDENTIFIER && next.Type == DOT && p.inLoop(tok.Literal) {
+			column := lexer.NextToken()
+			if column.Type == IDENTIFIER {
+				b.WriteString(sql[last:tok.Pos])
+				b.WriteString("NULL")
+				last = column.Pos + len(column.Literal)
+				next = lexer.NextToken()
+			}
+		}
+		tok = next
+	}
+	b.WriteString(sql[last:])
+	return b.String()
+}
+
+func (p *Parser) inLoop(name string) bool {
+	for _, loop := range p.loops {
+		if loop == name {
+			return true
+		}
+	}
+	return false
+}
+
+func checkQuery(query string) error {
+	node, err := Parse(query)
+	if err != nil {
+		return err
+	}
+	if _, ok := node.(*SelectStmt); !ok {
+		return fmt.Errorf("expected SELECT, got %s", query)
+	}
+	return nil
+}
+
+func (p *Parser) parseDropProcedure() (*DropProcedureStmt, error) {
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected procedure name, got %s", p.curTok.Literal)
+	}
+	stmt := &DropProcedureStmt{Name: p.curTok.Literal}
+	p.nextToken()
+	return stmt, nil
+}
+
+func (p *Parser) parseCall() (*CallStmt, error) {
+	p.nextToken()
+
+	if p.curTok.Type != IDENTIFIER {
+		return nil, fmt.Errorf("expected procedure name after CALL, got %s", p.curTok.Literal)
+	}
+	stmt := &CallStmt{Name: p.curTok.Literal}
+	p.nextToken()
+
+	if p.curTok.Type != LPAREN {
+		return nil, fmt.Errorf("expected ( after procedure name, got %s", p.curTok.Literal)
+	}
+	args, err := p.parseFunctionArgs(stmt.Name)
+	if err != nil {
+		return nil, err
+	}
+	stmt.Args = args
+	return stmt, nil
+}
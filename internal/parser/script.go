@@ -0,0 +1,59 @@
+package parser
+
+import "strings"
+
+// SplitScript returns the statements of a script, each without the
+// semicolon that ends it. Statements may span lines and share one; only a
+// semicolon between statements splits them, not one in a quoted string or a
+// -- comment, nor one inside the BEGIN ... END body of a procedure. The last
+// statement needs no semicolon, and statements holding nothing but comments
+// are skipped.
+func SplitScript(script string) []string {
+	statements := []string{}
+	lexer := NewLexer(script)
+	start := -1
+	// create is set on the first token of a CREATE statement, and
+	// procedure on the second of a CREATE PROCEDURE.
+	create, procedure := false, false
+	// depth counts the procedure bodies and blocks open. The END of END IF
+	// and END LOOP closes a block inside a body, so its IF or LOOP opens it
+	// again; closed is set right after an END.
+	depth := 0
+	closed := false
+	for {
+		tok := lexer.NextToken()
+		if tok.Type == EOF || (tok.Type == SEMICOLON && depth == 0) {
+			if start >= 0 {
+				statements = append(statements, strings.TrimSpace(script[start:tok.Pos]))
+			}
+			if tok.Type == EOF {
+				return statements
+			}
+			start, create, procedure, depth = -1, false, false, 0
+			continue
+		}
+
+		if start < 0 {
+			start = tok.Pos
+			create = tok.Type == KEYWORD && tok.Value == "CREATE"
+			continue
+		}
+		if create {
+			procedure = tok.Type == IDENTIFIER && strings.EqualFold(tok.Literal, "PROCEDURE")
+			create = false
+		}
+		wasClosed := closed
+		closed = false
+		if procedure && tok.Type == IDENTIFIER {
+			switch {
+			case strings.EqualFold(tok.Literal, "BEGIN") && depth == 0:
+				depth++
+			case strings.EqualFold(tok.Literal, "END") && depth > 0:
+				depth--
+				closed = true
+			case wasClosed && (strings.EqualFold(tok.Literal, "IF") || strings.EqualFold(tok.Literal, "LOOP")):
+				depth++
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitScript(t *testing.T) {
+	for _, tc := range []struct {
+		script string
+		want   []string
+	}{
+		{"", []string{}},
+		{"-- only a comment\n\n", []string{}},
+		{"SELECT a FROM t; DELETE FROM t", []string{"SELECT a FROM t", "DELETE FROM t"}},
+		{"CREATE TABLE t (\n  id INT PRIMARY KEY\n);\n;\n", []string{"CREATE TABLE t (\n  id INT PRIMARY KEY\n)"}},
+		{"INSERT INTO t VALUES ('a;b'); -- x; y\nSELECT \"c;d\" FROM t;", []string{"INSERT INTO t VALUES ('a;b')", "SELECT \"c;d\" FROM t"}},
+		{
+			"CREATE PROCEDURE p(n INT) BEGIN\n  IF n > 1 THEN\n    DELETE FROM t;\n  END IF;\n  FOR r IN SELECT id FROM t LOOP\n    DELETE FROM u WHERE id = r.id;\n  END LOOP;\nEND;\nCALL p(2);",
+			[]string{
+				"CREATE PROCEDURE p(n INT) BEGIN\n  IF n > 1 THEN\n    DELETE FROM t;\n  END IF;\n  FOR r IN SELECT id FROM t LOOP\n    DELETE FROM u WHERE id = r.id;\n  END LOOP;\nEND",
+				"CALL p(2)",
+			},
+		},
+		// Only a procedure has a body; elsewhere BEGIN and END are names.
+		{"SELECT begin FROM t; DELETE FROM t", []string{"SELECT begin FROM t", "DELETE FROM t"}},
+		{"CREATE TABLE u (begin INT); DELETE FROM u", []string{"CREATE TABLE u (begin INT)", "DELETE FROM u"}},
+	} {
+		got := SplitScript(tc.script)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SplitScript(%q) = %q, want %q", tc.script, got, tc.want)
+			continue
+		}
+		for _, statement := range got {
+			if _, err := Parse(statement); err != nil {
+				t.Errorf("parse %q: %v", statement, err)
+			}
+		}
+	}
+}
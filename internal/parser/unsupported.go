@@ -0,0 +1,95 @@
+package parser
+
+import "strings"
+
+// statementFeatures are statements, named by their first words, that are
+// valid SQL elsewhere but not implemented here.
+var statementFeatures = []struct {
+	words   []string
+	feature string
+}{
+	{[]string{"WITH"}, "WITH (common table expressions)"},
+	{[]string{"ALTER", "TABLE"}, "ALTER TABLE"},
+	{[]string{"DROP", "TABLE"}, "DROP TABLE"},
+	{[]string{"DROP", "SCHEMA"}, "DROP SCHEMA"},
+	{[]string{"DROP", "USER"}, "DROP USER"},
+	{[]string{"DROP", "VIEW"}, "views"},
+	{[]string{"CREATE", "VIEW"}, "views"},
+	{[]string{"CREATE", "MATERIALIZED", "VIEW"}, "materialized views"},
+	{[]string{"CREATE", "TRIGGER"}, "triggers"},
+	{[]string{"CREATE", "TEMPORARY"}, "temporary tables"},
+	{[]string{"CREATE", "TEMP"}, "temporary tables"},
+	{[]string{"TRUNCATE"}, "TRUNCATE"},
+	{[]string{"MERGE"}, "MERGE"},
+	{[]string{"REPLACE"}, "REPLACE"},
+	{[]string{"VACUUM"}, "VACUUM as a statement"},
+	{[]string{"BEGIN"}, "transactions"},
+	{[]string{"START", "TRANSACTION"}, "transactions"},
+	{[]string{"COMMIT"}, "transactions"},
+	{[]string{"ROLLBACK"}, "transactions"},
+	{[]string{"SAVEPOINT"}, "transactions"},
+}
+
+// clauseFeatures are words that, anywhere in a statement outside string
+// literals, start a construct that is not implemented.
+var clauseFeatures = map[string]string{
+	"UNION":     "UNION",
+	"INTERSECT": "INTERSECT",
+	"EXCEPT":    "EXCEPT",
+	"CASE":      "CASE expressions",
+	"BETWEEN":   "BETWEEN",
+	"RETURNING": "RETURNING",
+	"LATERAL":   "LATERAL joins",
+}
+
+// unsupportedFeature names a construct in input that is valid SQL but not
+// implemented, or returns "". Parse calls it on input that failed to parse,
+// so that such statements report the missing feature instead of whatever
+// token the parser tripped over.
+func unsupportedFeature(input string) string {
+	lexer := NewLexer(input)
+	var tokens []Token
+	for tok := lexer.NextToken(); tok.Type != EOF && tok.Type != ILLEGAL; tok = lexer.NextToken() {
+		tokens = append(tokens, tok)
+	}
+
+	word := func(i int) string {
+		if i >= len(tokens) || (tokens[i].Type != IDENTIFIER && tokens[i].Type != KEYWORD) {
+			return ""
+		}
+		return strings.ToUpper(tokens[i].Literal)
+	}
+
+	for _, s := range statementFeatures {
+		matched := true
+		for i, w := range s.words {
+			if word(i) != w {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return s.feature
+		}
+	}
+
+	for i := range tokens {
+		w := word(i)
+		if feature, ok := clauseFeatures[w]; ok {
+			return feature
+		}
+		switch {
+		case w == "OVER" && i+1 < len(tokens) && tokens[i+1].Type == LPAREN:
+			return "window functions"
+		case w == "ON" && word(i+1) == "CONFLICT":
+			return "ON CONFLICT"
+		case w == "INSERT" && word(i+1) == "INTO":
+			for j := i + 2; j < len(tokens) && word(j) != "VALUES"; j++ {
+				if word(j) == "SELECT" {
+					return "INSERT ... SELECT"
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,443 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Backups carry a generation. Backup writes the current generation into the
+// copy's header and then increments it, and every page written afterwards is
+// stamped with the new generation in the page map. An incremental backup of
+// a parent with generation g therefore only needs the pages stamped above g.
+//
+// The page map lives in FILE-pagemap, created by the first backup. It is
+// kept in memory and saved on Close; while the database is open the file is
+// marked dirty, so after a crash every page counts as changed and the next
+// incremental backup copies the whole database. Databases outside the
+// operating system's file system have no page map and only take full
+// backups.
+//
+// An incremental backup file holds:
+//
+//	magic       [8]byte  incrMagic
+//	generation  uint64   the backup's generation
+//	parent      uint64   generation of the backup it is relative to
+//	numPages    uint32   page count of the database
+//	count       uint32   number of pages that follow
+//	count times:
+//	  pageNum   uint32   0 is the database header, always included
+//	  data      [PageSize]byte
+//	checksum    uint32   CRC-32 of everything above
+
+var (
+	incrMagic    = [8]byte{'A', 'n', 'u', 'b', 'i', 's', 'I', 'B'}
+	pageMapMagic = [8]byte{'A', 'n', 'u', 'b', 'i', 's', 'P', 'M'}
+)
+
+const (
+	incrHeaderSize    = 32
+	pageMapHeaderSize = 24
+)
+
+// pageMap holds the generation each page was last written in.
+type pageMap struct {
+	path string
+	gens []uint64
+}
+
+// Backup copies the database to dest as a full backup. In WAL mode it first
+// switches to a new segment, so dest is also a base backup that Restore can
+// bring forward with the segments archived from then on.
+func (p *Pager) Backup(dest string) error {
+	return p.backup(dest, func(w io.Writer, gen uint64) error {
+		_, err := io.Copy(w, io.NewSectionReader(p.file, 0, int64(p.numPages+1)*PageSize))
+		return err
+	})
+}
+
+// BackupIncremental writes to dest the pages changed since the backup in
+// parent was taken, which may be a full or an incremental backup of this
+// database. MergeBackups turns a chain of them back into a full copy.
+func (p *Pager) BackupIncremental(dest, parent string) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if !p.onOS() {
+		return errors.New("incremental backups need a database in the operating system's file system")
+	}
+	since, err := BackupGeneration(parent)
+	if err != nil {
+		return err
+	}
+	if since >= p.header.BackupGeneration {
+		return fmt.Errorf("backup %s is not from this database: its generation %d is not older than the database's %d", parent, since, p.header.BackupGeneration)
+	}
+
+	return p.backup(dest, func(w io.Writer, gen uint64) error {
+		pages := []uint32{0}
+		for pageNum := uint32(1); pageNum <= p.numPages; pageNum++ {
+			if p.changes.generation(pageNum) > since {
+				pages = append(pages, pageNum)
+			}
+		}
+
+		hash := crc32.NewIEEE()
+		bw := bufio.NewWriterSize(io.MultiWriter(w, hash), 1<<16)
+
+		header := make([]byte, incrHeaderSize)
+		copy(header[0:8], incrMagic[:])
+		binary.BigEndian.PutUint64(header[8:16], gen)
+		binary.BigEndian.PutUint64(header[16:24], since)
+		binary.BigEndian.PutUint32(header[24:28], p.numPages)
+		binary.BigEndian.PutUint32(header[28:32], uint32(len(pages)))
+		bw.Write(header)
+
+		frame := make([]byte, 4+PageSize)
+		for _, pageNum := range pages {
+			binary.BigEndian.PutUint32(frame[0:4], pageNum)
+			if _, err := p.file.ReadAt(frame[4:], int64(PageSize)*int64(pageNum)); err != nil {
+				return fmt.Errorf("failed to read page %d: %w", pageNum, err)
+			}
+			bw.Write(frame)
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		return binary.Write(w, binary.BigEndian, hash.Sum32())
+	})
+}
+
+// backup writes dest with write once the file is synced and its header
+// carries the backup's generation, then starts the next generation.
+func (p *Pager) backup(dest string, write func(w io.Writer, gen uint64) error) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if p.batch != nil {
+		return ErrBatchActive
+	}
+	if p.wal != nil {
+		if err := p.SwitchWAL(); err != nil {
+			return err
+		}
+	}
+
+	if p.header.BackupGeneration == 0 {
+		p.header.BackupGeneration = 1
+	}
+	if p.changes == nil && p.onOS() {
+		changes, err := createPageMap(p.path, p.numPages, 0)
+		if err != nil {
+			return err
+		}
+		p.changes = changes
+	}
+	if err := p.writeHeader(); err != nil {
+		return err
+	}
+	if err := p.Sync(); err != nil {
+		return err
+	}
+
+	gen := p.header.BackupGeneration
+	err := createFileAtomic(dest, func(w io.Writer) error {
+		return write(w, gen)
+	})
+	if err != nil {
+		return err
+	}
+
+	p.header.BackupGeneration++
+	if err := p.writeHeader(); err != nil {
+		return err
+	}
+	return p.Sync()
+}
+
+// BackupGeneration returns the generation of a full or incremental backup.
+func BackupGeneration(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 36)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, fmt.Errorf("failed to read backup %s: %w", path, err)
+	}
+
+	var gen uint64
+	switch [8]byte(header[0:8]) {
+	case dbMagicNumber:
+		gen = binary.BigEndian.Uint64(header[28:36])
+	case incrMagic:
+		gen = binary.BigEndian.Uint64(header[8:16])
+	default:
+		return 0, fmt.Errorf("%s is not a backup: bad magic number", path)
+	}
+	if gen == 0 {
+		return 0, fmt.Errorf("%s was not written by Backup", path)
+	}
+	return gen, nil
+}
+
+// MergeBackups writes to dest the full backup in full brought forward by
+// each incremental backup in turn. Each incremental must have been taken
+// relative to the one before it, the first relative to full. dest is a full
+// backup of the last generation and can be the parent of later incremental
+// backups.
+func MergeBackups(dest, full string, incrementals ...string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("merge target %s already exists", dest)
+	}
+	if err := removeHotPages(OSFS, dest); err != nil {
+		return err
+	}
+	gen, err := BackupGeneration(full)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(full)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return createFileAtomic(dest, func(w io.Writer) error {
+		if _, err := io.Copy(w, src); err != nil {
+			return err
+		}
+		file := w.(*os.File)
+		for _, path := range incrementals {
+			if gen, err = applyIncremental(file, path, gen); err != nil {
+				return fmt.Errorf("failed to apply %s: %w", path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// applyIncremental writes the pages of the incremental backup in path to
+// file, which must hold the backup of generation gen. It returns the
+// generation file holds afterwards.
+func applyIncremental(file *os.File, path string, gen uint64) (uint64, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	br := bufio.NewReaderSize(in, 1<<16)
+	hash := crc32.NewIEEE()
+	r := io.TeeReader(br, hash)
+
+	header := make([]byte, incrHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	if [8]byte(header[0:8]) != incrMagic {
+		return 0, errors.New("not an incremental backup")
+	}
+	next := binary.BigEndian.Uint64(header[8:16])
+	if parent := binary.BigEndian.Uint64(header[16:24]); parent != gen {
+		return 0, fmt.Errorf("it follows generation %d, not %d", parent, gen)
+	}
+	numPages := binary.BigEndian.Uint32(header[24:28])
+	count := binary.BigEndian.Uint32(header[28:32])
+	if count > numPages+1 {
+		return 0, errors.New("corrupted incremental backup")
+	}
+
+	frame := make([]byte, 4+PageSize)
+	for i := uint32(0); i < count; i++ {
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return 0, err
+		}
+		pageNum := binary.BigEndian.Uint32(frame[0:4])
+		if pageNum > numPages {
+			return 0, errors.New("corrupted incremental backup")
+		}
+		if _, err := file.WriteAt(frame[4:], int64(PageSize)*int64(pageNum)); err != nil {
+			return 0, err
+		}
+	}
+
+	sum := hash.Sum32()
+	var stored uint32
+	if err := binary.Read(br, binary.BigEndian, &stored); err != nil || stored != sum {
+		return 0, errors.New("checksum mismatch")
+	}
+	if err := file.Truncate(int64(numPages+1) * PageSize); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+func (p *Pager) onOS() bool {
+	_, ok := p.vfs.(osFS)
+	return ok
+}
+
+func pageMapPath(path string) string {
+	return path + "-pagemap"
+}
+
+// openPageMap loads the page map once a backup has been taken. A map that
+// is missing, was not saved by Close, or belongs to another generation is
+// replaced by one marking every page as changed.
+func (p *Pager) openPageMap() error {
+	gen := p.header.BackupGeneration
+	if gen == 0 || !p.onOS() {
+		return nil
+	}
+
+	changes, err := loadPageMap(pageMapPath(p.path), gen)
+	if err != nil {
+		changes, err = createPageMap(p.path, p.numPages, gen)
+		if err != nil {
+			return err
+		}
+	}
+	if err := changes.markDirty(); err != nil {
+		return err
+	}
+	p.changes = changes
+	return nil
+}
+
+func createPageMap(path string, numPages uint32, gen uint64) (*pageMap, error) {
+	m := &pageMap{path: pageMapPath(path), gens: make([]uint64, numPages+1)}
+	for i := range m.gens {
+		m.gens[i] = gen
+	}
+	if err := m.save(gen); err != nil {
+		return nil, err
+	}
+	if err := m.markDirty(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func loadPageMap(path string, gen uint64) (*pageMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < pageMapHeaderSize || [8]byte(data[0:8]) != pageMapMagic {
+		return nil, errors.New("bad page map")
+	}
+	if data[8] != 1 {
+		return nil, errors.New("page map was not saved")
+	}
+	if binary.BigEndian.Uint64(data[16:24]) != gen {
+		return nil, errors.New("page map is from another generation")
+	}
+
+	body := data[pageMapHeaderSize:]
+	m := &pageMap{path: path, gens: make([]uint64, len(body)/8)}
+	for i := range m.gens {
+		m.gens[i] = binary.BigEndian.Uint64(body[i*8:])
+	}
+	return m, nil
+}
+
+// save writes the map and then marks it clean.
+func (m *pageMap) save(gen uint64) error {
+	data := make([]byte, pageMapHeaderSize+8*len(m.gens))
+	copy(data[0:8], pageMapMagic[:])
+	binary.BigEndian.PutUint64(data[16:24], gen)
+	for i, g := range m.gens {
+		binary.BigEndian.PutUint64(data[pageMapHeaderSize+i*8:], g)
+	}
+	if err := writeFileAtomic(m.path, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	return m.setClean(1)
+}
+
+// markDirty records that the map on disk no longer follows the database.
+func (m *pageMap) markDirty() error {
+	return m.setClean(0)
+}
+
+func (m *pageMap) setClean(clean byte) error {
+	file, err := os.OpenFile(m.path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteAt([]byte{clean}, 8); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (m *pageMap) generation(pageNum uint32) uint64 {
+	if int(pageNum) >= len(m.gens) {
+		return 0
+	}
+	return m.gens[pageNum]
+}
+
+// markChanged stamps pageNum with the current backup generation.
+func (p *Pager) markChanged(pageNum uint32) {
+	m := p.changes
+	if m == nil {
+		return
+	}
+	for int(pageNum) >= len(m.gens) {
+		m.gens = append(m.gens, 0)
+	}
+	m.gens[pageNum] = p.header.BackupGeneration
+}
+
+// writeFileAtomic writes r to path through a temporary file, so path is
+// either complete or absent.
+func writeFileAtomic(path string, r io.Reader) error {
+	return createFileAtomic(path, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// createFileAtomic creates path with the contents write gives the temporary
+// file it is built in, which is an *os.File.
+func createFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
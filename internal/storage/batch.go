@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrBatchActive = errors.New("a batch is already active")
+
+// batch holds the pages written since Begin. Nothing reaches the file or the
+// page cache until Commit, so Rollback only has to forget them.
+type batch struct {
+	pages    map[uint32][]byte
+	numPages uint32
+	header   DatabaseHeader
+
+	// noSync leaves the commit unsynced, see BeginStatement.
+	noSync bool
+}
+
+// Begin starts a batch: page writes and allocations are kept in memory until
+// Commit writes them out and syncs the file once, or Rollback drops them.
+func (p *Pager) Begin() error {
+	return p.begin(false)
+}
+
+// BeginStatement starts a batch for a single statement in WAL mode, so that
+// read-only handles see all of it or none. Its Commit logs the pages
+// without syncing; they are as durable as unbatched writes.
+func (p *Pager) BeginStatement() error {
+	return p.begin(true)
+}
+
+func (p *Pager) begin(noSync bool) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if p.batch != nil {
+		return ErrBatchActive
+	}
+	p.batch = &batch{pages: make(map[uint32][]byte), numPages: p.numPages, header: p.header, noSync: noSync}
+	return nil
+}
+
+func (p *Pager) InBatch() bool {
+	return p.batch != nil
+}
+
+// Commit writes the batch's pages in page order, cuts the file short if the
+// batch released pages from its end, and syncs. A failure part way leaves
+// the pages written so far on disk. In WAL mode the batch is instead logged
+// as one record and synced, and its pages reach the file at the next
+// checkpoint.
+func (p *Pager) Commit() error {
+	if p.batch == nil {
+		return errors.New("no active batch")
+	}
+	b := p.batch
+	p.batch = nil
+
+	// The header may have changed again since it was written to the batch.
+	if _, ok := b.pages[0]; ok {
+		b.pages[0] = p.encodeHeader()
+	}
+
+	pageNums := make([]uint32, 0, len(b.pages))
+	for pageNum := range b.pages {
+		// Pages past the end were released by the batch.
+		if pageNum <= p.numPages {
+			pageNums = append(pageNums, pageNum)
+		}
+	}
+	sort.Slice(pageNums, func(i, j int) bool { return pageNums[i] < pageNums[j] })
+
+	if p.wal != nil {
+		pages := make([]walPage, len(pageNums))
+		for i, pageNum := range pageNums {
+			pages[i] = walPage{pageNum, b.pages[pageNum]}
+		}
+		if err := p.logPages(p.numPages, pages, !b.noSync); err != nil {
+			p.numPages, p.header = b.numPages, b.header
+			return err
+		}
+		for _, pageNum := range pageNums {
+			p.markChanged(pageNum)
+			if pageNum != 0 {
+				p.cachePage(pageNum, b.pages[pageNum])
+			}
+		}
+		return p.checkpointIfFull()
+	}
+
+	for _, pageNum := range pageNums {
+		data := b.pages[pageNum]
+		p.markChanged(pageNum)
+		if _, err := p.file.WriteAt(data, int64(PageSize)*int64(pageNum)); err != nil {
+			if invalidateErr := p.InvalidateCache(); invalidateErr != nil {
+				fmt.Printf("Warning: failed to invalidate page cache: %v\n", invalidateErr)
+			}
+			return fmt.Errorf("failed to write page %d: %w", pageNum, err)
+		}
+		p.cachePage(pageNum, data)
+	}
+	if err := p.saveChangeCounter(); err != nil {
+		return err
+	}
+	if p.numPages < b.numPages {
+		if err := p.file.Truncate(int64(p.numPages+1) * PageSize); err != nil {
+			return fmt.Errorf("failed to truncate the file: %w", err)
+		}
+	}
+	return p.Sync()
+}
+
+// Rollback discards every page written or allocated since Begin.
+func (p *Pager) Rollback() {
+	if p.batch == nil {
+		return
+	}
+	p.numPages, p.header = p.batch.numPages, p.batch.header
+	p.batch = nil
+}
+
+// batchPage returns the batch's copy of pageNum, if it has one.
+func (p *Pager) batchPage(pageNum uint32) ([]byte, bool) {
+	if p.batch == nil {
+		return nil, false
+	}
+	data, ok := p.batch.pages[pageNum]
+	return data, ok
+}
+
+func (p *Pager) writeBatchPage(pageNum uint32, data []byte) {
+	buf := make([]byte, PageSize)
+	copy(buf, data)
+	p.batch.pages[pageNum] = buf
+}
@@ -3,12 +3,22 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"slices"
+)
+
+var (
+	ErrKeyNotFound  = errors.New("key not found")
+	ErrDuplicateKey = errors.New("duplicate key")
 )
 
 type BTree struct {
 	pager   *Pager
 	root    uint32
 	isIndex bool
+
+	// fillFactor is the percentage of a page filled from left to right
+	// that is used; see SetFillFactor.
+	fillFactor int
 }
 
 type Entry struct {
@@ -19,7 +29,7 @@ type Entry struct {
 func NewBTree(pager *Pager, isIndex bool) (*BTree, error) {
 	pageType := PageTypeLeafTable
 	if isIndex {
-		pageType = PageTypeLeafIndex
+		pageType = PageTypeLeafIndexPrefix
 	}
 
 	rootNum, root, err := pager.AllocatePage(pageType, 0)
@@ -52,14 +62,16 @@ func LoadBTree(pager *Pager, rootPage uint32, isIndex bool) (*BTree, error) {
 		return nil, fmt.Errorf("failed to load root page: %w", err)
 	}
 
-	expectedLeafType := PageTypeLeafTable
-	expectedInteriorType := PageTypeInteriorTable
+	// Index trees written before pages could store a key prefix may still
+	// have pages of the older types.
+	var expected []PageType
 	if isIndex {
-		expectedLeafType = PageTypeLeafIndex
-		expectedInteriorType = PageTypeInteriorIndex
+		expected = []PageType{PageTypeLeafIndexPrefix, PageTypeInteriorIndexPrefix, PageTypeLeafIndex, PageTypeInteriorIndex}
+	} else {
+		expected = []PageType{PageTypeLeafTable, PageTypeInteriorTable}
 	}
 
-	if root.Header.PageType != expectedLeafType && root.Header.PageType != expectedInteriorType {
+	if !slices.Contains(expected, root.Header.PageType) {
 		return nil, fmt.Errorf("root page has incorrect type: %d", root.Header.PageType)
 	}
 
@@ -72,14 +84,14 @@ func LoadBTree(pager *Pager, rootPage uint32, isIndex bool) (*BTree, error) {
 
 func (tree *BTree) getLeafPageType() PageType {
 	if tree.isIndex {
-		return PageTypeLeafIndex
+		return PageTypeLeafIndexPrefix
 	}
 	return PageTypeLeafTable
 }
 
 func (tree *BTree) getInteriorPageType() PageType {
 	if tree.isIndex {
-		return PageTypeInteriorIndex
+		return PageTypeInteriorIndexPrefix
 	}
 	return PageTypeInteriorTable
 }
@@ -94,6 +106,7 @@ func (tree *BTree) Search(key Key) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer tree.pager.ReleasePage(leaf)
 
 	idx, found, err := leaf.SearchCell(key)
 	if err != nil {
@@ -101,7 +114,7 @@ func (tree *BTree) Search(key Key) ([]byte, error) {
 	}
 
 	if !found {
-		return nil, errors.New("key not found")
+		return nil, ErrKeyNotFound
 	}
 
 	cell, err := leaf.GetLeafCell(idx)
@@ -117,6 +130,7 @@ func (tree *BTree) navigateToLeaf(nodeNum uint32, key Key) (uint32, error) {
 	if err != nil {
 		return 0, err
 	}
+	defer tree.pager.ReleasePage(node)
 
 	if isLeaf(node.Header.PageType) {
 		return nodeNum, nil
@@ -162,21 +176,41 @@ func (tree *BTree) Insert(key Key, value []byte) error {
 	}
 
 	if _, found, _ := leaf.SearchCell(key); found {
-		return errors.New("duplicate key")
+		tree.releasePath(leaf, path)
+		return ErrDuplicateKey
 	}
 
 	cell := NewLeafCell(key, value)
 
-	if leaf.CanFit(cell.Size()) {
+	fits := leaf.CanFitCell(key, cell.Size())
+	if !fits {
+		// A longer key prefix may make room without a split.
+		if err := leaf.compressKeys(); err != nil {
+			return err
+		}
+		fits = leaf.CanFitCell(key, cell.Size())
+	}
+	if fits {
 		if err := leaf.InsertLeafCell(cell); err != nil {
 			return err
 		}
-		return tree.pager.WritePage(leafNum, leaf)
+		// WritePage copies the page, so nothing here is needed afterwards.
+		err := tree.pager.WritePage(leafNum, leaf)
+		tree.releasePath(leaf, path)
+		return err
 	}
 
 	return tree.insertAndSplit(leafNum, leaf, cell, path)
 }
 
+// releasePath hands back the pages of an insert that did not split.
+func (tree *BTree) releasePath(leaf *Page, path []*pathNode) {
+	tree.pager.ReleasePage(leaf)
+	for _, node := range path {
+		tree.pager.ReleasePage(node.page)
+	}
+}
+
 type pathNode struct {
 	pageNum uint32
 	page    *Page
@@ -216,7 +250,21 @@ func (tree *BTree) insertAndSplit(leafNum uint32, leaf *Page, newCell *LeafCell,
 
 	tree.sortLeafCells(cells)
 
+	// Splitting the last leaf for a key past all the others is what
+	// inserting in key order does; an even split would leave every leaf
+	// behind half empty.
+	appending := leaf.Header.NextLeaf == 0 && cells[len(cells)-1] == newCell
+
+	keys := make([]Key, len(cells))
+	sizes := make([]uint32, len(cells))
+	for i, c := range cells {
+		keys[i], sizes[i] = c.Key, c.Size()
+	}
+
 	mid := len(cells) / 2
+	if appending {
+		mid = tree.rightSplit(leaf, sizes, 1)
+	}
 
 	if mid == 0 {
 		mid = 1
@@ -232,13 +280,20 @@ func (tree *BTree) insertAndSplit(leafNum uint32, leaf *Page, newCell *LeafCell,
 
 	tree.resetPage(leaf)
 	tree.resetPage(sibling)
+	mid = leaf.fitSplit(keys, sizes, mid, 0)
 
+	if err := leaf.startPrefix(keys[0], keys[mid-1]); err != nil {
+		return err
+	}
 	for i := 0; i < mid; i++ {
 		if err := leaf.InsertLeafCell(cells[i]); err != nil {
 			return fmt.Errorf("failed to insert cell into left leaf: %w", err)
 		}
 	}
 
+	if err := sibling.startPrefix(keys[mid], keys[len(keys)-1]); err != nil {
+		return err
+	}
 	for i := mid; i < len(cells); i++ {
 		if err := sibling.InsertLeafCell(cells[i]); err != nil {
 			return fmt.Errorf("failed to insert cell into right leaf: %w", err)
@@ -273,7 +328,7 @@ func (tree *BTree) insertAndSplit(leafNum uint32, leaf *Page, newCell *LeafCell,
 
 	splitKey := cells[mid].Key
 
-	return tree.insertIntoParent(leafNum, splitKey, siblingNum, path)
+	return tree.insertIntoParent(leafNum, splitKey, siblingNum, path, appending)
 }
 
 func (tree *BTree) sortLeafCells(cells []*LeafCell) {
@@ -298,7 +353,16 @@ func (tree *BTree) sortInternalCells(cells []*InteriorCell) {
 	}
 }
 
+// resetPage empties page. An index page of a type without a key prefix
+// becomes one with.
 func (tree *BTree) resetPage(page *Page) {
+	switch page.Header.PageType {
+	case PageTypeLeafIndex:
+		page.Header.PageType = PageTypeLeafIndexPrefix
+	case PageTypeInteriorIndex:
+		page.Header.PageType = PageTypeInteriorIndexPrefix
+	}
+	page.Header.PrefixLen = 0
 	offset := page.GetHeaderSize()
 
 	for i := offset; i < len(page.Data); i++ {
@@ -306,11 +370,15 @@ func (tree *BTree) resetPage(page *Page) {
 	}
 	page.Header.NumCells = 0
 	page.Header.CellContentOffset = uint16(PageSize)
+	page.Header.FirstFreeblock = 0
 	page.Header.FragmentedBytes = 0
 	page.writeHeader()
 }
 
-func (tree *BTree) insertIntoParent(leftChild uint32, splitKey Key, rightChild uint32, path []*pathNode) error {
+// insertIntoParent adds the separator for a split child to its parent.
+// appending is set when the split was at the right edge of the tree, which
+// then so is every ancestor's.
+func (tree *BTree) insertIntoParent(leftChild uint32, splitKey Key, rightChild uint32, path []*pathNode, appending bool) error {
 	if len(path) == 0 {
 		return tree.createNewRoot(leftChild, splitKey, rightChild)
 	}
@@ -318,33 +386,75 @@ func (tree *BTree) insertIntoParent(leftChild uint32, splitKey Key, rightChild u
 	parent := path[len(path)-1]
 	path = path[:len(path)-1]
 
-	cell := NewInteriorCell(splitKey, leftChild)
+	cells := make([]*InteriorCell, 0, parent.page.Header.NumCells+1)
+	for i := uint16(0); i < parent.page.Header.NumCells; i++ {
+		c, err := parent.page.GetInteriorCell(i)
+		if err != nil {
+			return fmt.Errorf("failed to get interior cell: %w", err)
+		}
+		cells = append(cells, c)
+	}
 
-	if parent.page.CanFit(cell.Size()) {
-		if err := parent.page.InsertInteriorCell(cell); err != nil {
-			return err
+	// The pointer that used to reference the split child now covers only the
+	// upper half of its old range, so it is redirected to the new sibling and
+	// a separator for the lower half is inserted pointing at the old child.
+	rightmost := parent.page.Header.RightmostPointer
+	if rightmost == leftChild {
+		rightmost = rightChild
+	} else {
+		redirected := false
+		for _, c := range cells {
+			if c.ChildPage == leftChild {
+				c.ChildPage = rightChild
+				redirected = true
+				break
+			}
+		}
+		if !redirected {
+			return fmt.Errorf("page %d is not a child of page %d", leftChild, parent.pageNum)
 		}
-		return tree.pager.WritePage(parent.pageNum, parent.page)
 	}
 
-	return tree.splitInternalNode(parent.pageNum, parent.page, cell, path)
-}
+	cells = append(cells, NewInteriorCell(splitKey, leftChild))
+	tree.sortInternalCells(cells)
 
-func (tree *BTree) splitInternalNode(nodeNum uint32, node *Page, newCell *InteriorCell, path []*pathNode) error {
-	cells := make([]*InteriorCell, 0, node.Header.NumCells+1)
+	// Emptied, the parent can take the cells' shared key prefix.
+	tree.resetPage(parent.page)
+	keys := make([]Key, len(cells))
+	sizes := make([]uint32, len(cells))
+	for i, c := range cells {
+		keys[i], sizes[i] = c.Key, c.Size()
+	}
 
-	for i := uint16(0); i < node.Header.NumCells; i++ {
-		c, err := node.GetInteriorCell(i)
-		if err != nil {
-			return fmt.Errorf("failed to get interior cell during split: %w", err)
+	if parent.page.packedSize(keys, sizes) <= PageSize-parent.page.GetHeaderSize() {
+		if err := parent.page.startPrefix(keys[0], keys[len(keys)-1]); err != nil {
+			return err
 		}
-		cells = append(cells, c)
+		for _, c := range cells {
+			if err := parent.page.InsertInteriorCell(c); err != nil {
+				return err
+			}
+		}
+		parent.page.Header.RightmostPointer = rightmost
+		parent.page.writeHeader()
+		return tree.pager.WritePage(parent.pageNum, parent.page)
 	}
-	cells = append(cells, newCell)
 
-	tree.sortInternalCells(cells)
+	return tree.splitInternalNode(parent.pageNum, parent.page, cells, rightmost, path, appending)
+}
+
+func (tree *BTree) splitInternalNode(nodeNum uint32, node *Page, cells []*InteriorCell, rightmost uint32, path []*pathNode, appending bool) error {
+	keys := make([]Key, len(cells))
+	sizes := make([]uint32, len(cells))
+	for i, c := range cells {
+		keys[i], sizes[i] = c.Key, c.Size()
+	}
 
 	mid := len(cells) / 2
+	if appending && len(cells) > 2 {
+		// cells[mid] moves up, and the sibling keeps at least one.
+		mid = tree.rightSplit(node, sizes, 2)
+	}
 
 	if mid == 0 {
 		mid = 1
@@ -360,7 +470,11 @@ func (tree *BTree) splitInternalNode(nodeNum uint32, node *Page, newCell *Interi
 
 	tree.resetPage(node)
 	tree.resetPage(sibling)
+	mid = node.fitSplit(keys, sizes, mid, 1)
 
+	if err := node.startPrefix(keys[0], keys[mid-1]); err != nil {
+		return err
+	}
 	for i := 0; i < mid; i++ {
 		if err := node.InsertInteriorCell(cells[i]); err != nil {
 			return fmt.Errorf("failed to insert cell into left interior: %w", err)
@@ -369,13 +483,16 @@ func (tree *BTree) splitInternalNode(nodeNum uint32, node *Page, newCell *Interi
 
 	pushUpKey := cells[mid].Key
 
+	if err := sibling.startPrefix(keys[mid+1], keys[len(keys)-1]); err != nil {
+		return err
+	}
 	for i := mid + 1; i < len(cells); i++ {
 		if err := sibling.InsertInteriorCell(cells[i]); err != nil {
 			return fmt.Errorf("failed to insert cell into right interior: %w", err)
 		}
 	}
 
-	sibling.Header.RightmostPointer = node.Header.RightmostPointer
+	sibling.Header.RightmostPointer = rightmost
 	node.Header.RightmostPointer = cells[mid].ChildPage
 
 	node.writeHeader()
@@ -388,28 +505,58 @@ func (tree *BTree) splitInternalNode(nodeNum uint32, node *Page, newCell *Interi
 		return err
 	}
 
-	return tree.insertIntoParent(nodeNum, pushUpKey, siblingNum, path)
+	return tree.insertIntoParent(nodeNum, pushUpKey, siblingNum, path, appending)
 }
 
+// createNewRoot grows the tree by one level. The root page number is recorded
+// in the catalog, so rather than allocating a new root the old root's
+// contents move to a fresh page and the root page becomes the new interior
+// node above it.
 func (tree *BTree) createNewRoot(leftChild uint32, key Key, rightChild uint32) error {
-	newRootNum, newRoot, err := tree.pager.AllocatePage(tree.getInteriorPageType(), 0)
+	oldRoot, err := tree.pager.ReadPage(leftChild)
 	if err != nil {
 		return err
 	}
 
-	cell := NewInteriorCell(key, leftChild)
-	if err := newRoot.InsertInteriorCell(cell); err != nil {
+	movedNum, moved, err := tree.pager.AllocatePage(oldRoot.Header.PageType, tree.root)
+	if err != nil {
 		return err
 	}
-	newRoot.Header.RightmostPointer = rightChild
-	newRoot.writeHeader()
 
-	if err := tree.pager.WritePage(newRootNum, newRoot); err != nil {
+	copy(moved.Data, oldRoot.Data)
+	if err := moved.readHeader(); err != nil {
 		return err
 	}
+	moved.Header.ParentPage = tree.root
+	moved.writeHeader()
 
-	tree.root = newRootNum
-	return nil
+	if err := tree.pager.WritePage(movedNum, moved); err != nil {
+		return err
+	}
+
+	if isLeaf(moved.Header.PageType) && rightChild != 0 {
+		right, err := tree.pager.ReadPage(rightChild)
+		if err != nil {
+			return err
+		}
+		right.Header.PrevLeaf = movedNum
+		right.writeHeader()
+		if err := tree.pager.WritePage(rightChild, right); err != nil {
+			return err
+		}
+	}
+
+	root := oldRoot
+	root.Header = PageHeader{PageType: tree.getInteriorPageType()}
+	tree.resetPage(root)
+
+	if err := root.InsertInteriorCell(NewInteriorCell(key, movedNum)); err != nil {
+		return err
+	}
+	root.Header.RightmostPointer = rightChild
+	root.writeHeader()
+
+	return tree.pager.WritePage(tree.root, root)
 }
 
 func (tree *BTree) Delete(key Key) error {
@@ -429,7 +576,7 @@ func (tree *BTree) Delete(key Key) error {
 	}
 
 	if !found {
-		return errors.New("key not found")
+		return ErrKeyNotFound
 	}
 
 	if err := leaf.deleteCell(idx); err != nil {
@@ -463,7 +610,7 @@ func (tree *BTree) Update(key Key, newValue []byte) error {
 	}
 
 	if !found {
-		return errors.New("key not found")
+		return ErrKeyNotFound
 	}
 
 	oldCell, err := leaf.GetLeafCell(idx)
@@ -473,7 +620,7 @@ func (tree *BTree) Update(key Key, newValue []byte) error {
 
 	newCell := NewLeafCell(key, newValue)
 
-	if newCell.Size() <= oldCell.Size() || leaf.CanFit(newCell.Size()) {
+	if newCell.Size() <= oldCell.Size() || leaf.CanFitCell(key, newCell.Size()) {
 
 		if err := leaf.deleteCell(idx); err != nil {
 			return err
@@ -491,9 +638,10 @@ func (tree *BTree) Update(key Key, newValue []byte) error {
 	}
 
 	if err := leaf.InsertLeafCell(newCell); err != nil {
-
-		leaf, err = tree.pager.ReadPage(leafNum)
-		if err != nil {
+		// The new cell does not fit even in place of the old one. The leaf
+		// is written without the old cell first, so that the split below,
+		// which reads it again, does not keep both.
+		if err := tree.pager.WritePage(leafNum, leaf); err != nil {
 			return err
 		}
 
@@ -549,6 +697,7 @@ func (tree *BTree) Scan() ([]Entry, error) {
 		}
 
 		currentNum = current.Header.NextLeaf
+		tree.pager.ReleasePage(current)
 	}
 
 	return result, nil
@@ -564,6 +713,7 @@ func (tree *BTree) findLeftmostLeaf() (uint32, error) {
 		}
 
 		if isLeaf(current.Header.PageType) {
+			tree.pager.ReleasePage(current)
 			return currentNum, nil
 		}
 
@@ -576,6 +726,7 @@ func (tree *BTree) findLeftmostLeaf() (uint32, error) {
 		} else {
 			currentNum = current.Header.RightmostPointer
 		}
+		tree.pager.ReleasePage(current)
 
 		if currentNum == 0 {
 			return 0, errors.New("invalid child pointer (0) encountered")
@@ -583,50 +734,58 @@ func (tree *BTree) findLeftmostLeaf() (uint32, error) {
 	}
 }
 
-func (tree *BTree) RangeSearch(start, end Key) ([]Entry, error) {
-	leafNum, err := tree.navigateToLeaf(tree.root, start)
-	if err != nil {
-		return nil, err
-	}
-
-	var result []Entry
-	currentNum := leafNum
-	visited := make(map[uint32]bool)
-
-	for currentNum != 0 {
-		if visited[currentNum] {
-			return nil, fmt.Errorf("circular reference detected in leaf chain at page %d", currentNum)
-		}
-		visited[currentNum] = true
+func (tree *BTree) findRightmostLeaf() (uint32, error) {
+	currentNum := tree.root
 
+	for {
 		current, err := tree.pager.ReadPage(currentNum)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read page %d during range search: %w", currentNum, err)
+			return 0, err
 		}
 
-		for i := uint16(0); i < current.Header.NumCells; i++ {
-			cell, err := current.GetLeafCell(i)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read cell %d from page %d: %w", i, currentNum, err)
-			}
-
-			if cell.Key.Compare(start) < 0 {
-				continue
-			}
-
-			if cell.Key.Compare(end) > 0 {
-				return result, nil
-			}
+		if isLeaf(current.Header.PageType) {
+			tree.pager.ReleasePage(current)
+			return currentNum, nil
+		}
 
-			result = append(result, Entry{
-				Key:   cell.Key,
-				Value: cell.Value,
-			})
+		currentNum = current.Header.RightmostPointer
+		tree.pager.ReleasePage(current)
+		if currentNum == 0 {
+			return 0, errors.New("invalid child pointer (0) encountered")
 		}
+	}
+}
 
-		currentNum = current.Header.NextLeaf
+// LastKey returns the largest key in the tree, or nil if the tree is empty.
+func (tree *BTree) LastKey() (Key, error) {
+	it, err := tree.NewReverseIterator()
+	if err != nil {
+		return nil, err
+	}
+	if !it.HasNext() {
+		return nil, it.Err()
+	}
+	key, _, err := it.Next()
+	return key, err
+}
+
+func (tree *BTree) RangeSearch(start, end Key) ([]Entry, error) {
+	it, err := tree.NewRangeIterator(start, end)
+	if err != nil {
+		return nil, err
 	}
 
+	var result []Entry
+	for it.HasNext() {
+		key, value, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("range search failed: %w", err)
+		}
+		result = append(result, Entry{Key: key, Value: value})
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("range search failed: %w", err)
+	}
 	return result, nil
 }
 
@@ -634,12 +793,28 @@ func (tree *BTree) GetAllEntries() ([]Entry, error) {
 	return tree.Scan()
 }
 
+// Count adds up the cells of every leaf without decoding them.
 func (tree *BTree) Count() (int, error) {
-	entries, err := tree.Scan()
+	currentNum, err := tree.findLeftmostLeaf()
 	if err != nil {
 		return 0, err
 	}
-	return len(entries), nil
+
+	count := 0
+	for hops := uint32(0); currentNum != 0; hops++ {
+		if hops > tree.pager.GetNumPages() {
+			return 0, fmt.Errorf("circular reference detected in leaf chain at page %d", currentNum)
+		}
+
+		current, err := tree.pager.ReadPage(currentNum)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read page %d during count: %w", currentNum, err)
+		}
+		count += int(current.Header.NumCells)
+		currentNum = current.Header.NextLeaf
+		tree.pager.ReleasePage(current)
+	}
+	return count, nil
 }
 
 func (tree *BTree) ForEach(fn func(key Key, value []byte) bool) error {
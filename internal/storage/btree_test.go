@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// newTestPager opens a fresh database in memory, closed when the test ends.
+func newTestPager(tb testing.TB) *Pager {
+	tb.Helper()
+	pager, err := OpenPager(NewMemFS(), "test.db")
+	if err != nil {
+		tb.Fatalf("open pager: %v", err)
+	}
+	tb.Cleanup(func() { pager.Close() })
+	return pager
+}
+
+// TestUpdateGrowingRows grows every row until the leaves holding them split,
+// which must move each row rather than leave its old copy behind.
+func TestUpdateGrowingRows(t *testing.T) {
+	const rows = 2000
+	tree, err := NewBTree(newTestPager(t), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < rows; i++ {
+		if err := tree.Insert(NewIntKey(int64(i)), value); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	for round := 1; round <= 3; round++ {
+		for i := 0; i < rows; i++ {
+			grown := append(bytes.Repeat([]byte("v"), 100+40*round), fmt.Sprint(i)...)
+			if err := tree.Update(NewIntKey(int64(i)), grown); err != nil {
+				t.Fatalf("round %d: update %d: %v", round, i, err)
+			}
+		}
+	}
+
+	entries, err := tree.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != rows {
+		t.Errorf("got %d rows after the updates, want %d", len(entries), rows)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i-1].Key.Compare(entries[i].Key) >= 0 {
+			t.Fatalf("key %v follows %v", entries[i].Key, entries[i-1].Key)
+		}
+	}
+	for i := 0; i < rows; i++ {
+		got, err := tree.Search(NewIntKey(int64(i)))
+		if err != nil {
+			t.Fatalf("search %d: %v", i, err)
+		}
+		if want := append(bytes.Repeat([]byte("v"), 220), fmt.Sprint(i)...); !bytes.Equal(got, want) {
+			t.Fatalf("row %d has %d bytes, want the last update's %d", i, len(got), len(want))
+		}
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// benchRows is how many rows BenchmarkScan reads per iteration.
+const benchRows = 10000
+
+func BenchmarkInsert(b *testing.B) {
+	tree, err := NewBTree(newTestPager(b), false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	value := bytes.Repeat([]byte("v"), 100)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := tree.Insert(NewIntKey(int64(i)), value); err != nil {
+			b.Fatalf("insert %d: %v", i, err)
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	tree, err := NewBTree(newTestPager(b), false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < benchRows; i++ {
+		if err := tree.Insert(NewIntKey(int64(i)), value); err != nil {
+			b.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entries, err := tree.Scan()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(entries) != benchRows {
+			b.Fatalf("scanned %d rows, want %d", len(entries), benchRows)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// bulkNode is a page built by BulkLoad and the smallest key beneath it.
+type bulkNode struct {
+	pageNum uint32
+	first   Key
+}
+
+// BulkLoad fills an empty tree from entries, which must be sorted by key
+// with no duplicates. Leaves are packed to the fill factor from left to
+// right and each interior level is built over the one below, so every page
+// is written once instead of being split repeatedly.
+func (tree *BTree) BulkLoad(entries []Entry) error {
+	root, err := tree.pager.ReadPage(tree.root)
+	if err != nil {
+		return err
+	}
+	if !isLeaf(root.Header.PageType) || root.Header.NumCells != 0 {
+		return errors.New("bulk load needs an empty tree")
+	}
+
+	for i := 1; i < len(entries); i++ {
+		switch entries[i-1].Key.Compare(entries[i].Key) {
+		case 0:
+			return fmt.Errorf("key %s: %w", entries[i].Key, ErrDuplicateKey)
+		case 1:
+			return fmt.Errorf("bulk load entries are not sorted: %s before %s", entries[i-1].Key, entries[i].Key)
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	level, err := tree.bulkLeaves(root, entries)
+	if err != nil {
+		return err
+	}
+	for len(level) > 1 {
+		if level, err = tree.bulkInterior(level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tree *BTree) bulkLeaves(root *Page, entries []Entry) ([]bulkNode, error) {
+	cells := make([]*LeafCell, len(entries))
+	total := 0
+	for i, entry := range entries {
+		cells[i] = NewLeafCell(entry.Key, entry.Value)
+		total += int(cells[i].Size()) + 2
+	}
+
+	if total <= tree.fillLimit(root) {
+		for _, cell := range cells {
+			if err := root.InsertLeafCell(cell); err != nil {
+				return nil, err
+			}
+		}
+		return []bulkNode{{tree.root, entries[0].Key}}, tree.pager.WritePage(tree.root, root)
+	}
+
+	var nodes []bulkNode
+	var pageNum uint32
+	var page *Page
+	for _, cell := range cells {
+		if page != nil {
+			fits, err := tree.fillable(page, cell.Key, cell.Size())
+			if err != nil {
+				return nil, err
+			}
+			if fits {
+				if err := page.InsertLeafCell(cell); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		nextNum, next, err := tree.pager.AllocatePage(tree.getLeafPageType(), 0)
+		if err != nil {
+			return nil, err
+		}
+		if page != nil {
+			next.Header.PrevLeaf = pageNum
+			page.Header.NextLeaf = nextNum
+			if err := tree.pager.WritePage(pageNum, page); err != nil {
+				return nil, err
+			}
+		}
+		if err := next.InsertLeafCell(cell); err != nil {
+			return nil, fmt.Errorf("entry %s does not fit in a page: %w", cell.Key, err)
+		}
+		pageNum, page = nextNum, next
+		nodes = append(nodes, bulkNode{pageNum, cell.Key})
+	}
+	return nodes, tree.pager.WritePage(pageNum, page)
+}
+
+// bulkInterior builds the level above children. Each node holds a separator
+// for every child but its last, keyed by the next child's smallest key, and
+// points at the last through RightmostPointer.
+func (tree *BTree) bulkInterior(children []bulkNode) ([]bulkNode, error) {
+	cells := make([]*InteriorCell, len(children))
+	total := 0
+	for i := 1; i < len(children); i++ {
+		cells[i] = NewInteriorCell(children[i].first, children[i-1].pageNum)
+		total += int(cells[i].Size()) + 2
+	}
+
+	probe, err := NewPage(tree.getInteriorPageType(), 0)
+	if err != nil {
+		return nil, err
+	}
+	oneNode := total <= PageSize-probe.GetHeaderSize()
+
+	var nodes []bulkNode
+	var pageNum uint32
+	var page *Page
+	start := func(first Key) error {
+		if oneNode {
+			root, err := tree.pager.ReadPage(tree.root)
+			if err != nil {
+				return err
+			}
+			root.Header = PageHeader{PageType: tree.getInteriorPageType()}
+			tree.resetPage(root)
+			pageNum, page = tree.root, root
+		} else {
+			nextNum, next, err := tree.pager.AllocatePage(tree.getInteriorPageType(), 0)
+			if err != nil {
+				return err
+			}
+			pageNum, page = nextNum, next
+		}
+		nodes = append(nodes, bulkNode{pageNum, first})
+		return nil
+	}
+	finish := func(rightmost uint32) error {
+		page.Header.RightmostPointer = rightmost
+		page.writeHeader()
+		return tree.pager.WritePage(pageNum, page)
+	}
+
+	if err := start(children[0].first); err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(children); i++ {
+		if page.CanFit(cells[i].Size()) {
+			if err := page.InsertInteriorCell(cells[i]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// The previous child becomes this node's rightmost pointer and
+		// children[i] opens the next node.
+		if err := finish(children[i-1].pageNum); err != nil {
+			return nil, err
+		}
+		if err := start(children[i].first); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, finish(children[len(children)-1].pageNum)
+}
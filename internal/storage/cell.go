@@ -3,8 +3,18 @@ package storage
 import (
 	"encoding/binary"
 	"errors"
+	"sync"
 )
 
+// cellBuffers holds scratch space for serializing a cell that is about to
+// be copied into a page.
+var cellBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 256)
+		return &buf
+	},
+}
+
 type LeafCell struct {
 	Key   Key
 	Value []byte
@@ -18,22 +28,16 @@ func NewLeafCell(key Key, value []byte) *LeafCell {
 }
 
 func (c *LeafCell) Serialize() []byte {
-	keyData := c.Key.Encode()
-	keyLen := len(keyData)
-	valueLen := len(c.Value)
-
-	totalSize := 4 + keyLen + 4 + valueLen
-	buf := make([]byte, totalSize)
-
-	binary.BigEndian.PutUint32(buf[0:4], uint32(keyLen))
-
-	copy(buf[4:4+keyLen], keyData)
-
-	binary.BigEndian.PutUint32(buf[4+keyLen:8+keyLen], uint32(valueLen))
-
-	copy(buf[8+keyLen:], c.Value)
+	return c.appendTo(make([]byte, 0, c.Size()))
+}
 
-	return buf
+// appendTo appends the serialized cell to buf.
+func (c *LeafCell) appendTo(buf []byte) []byte {
+	keyData := c.Key.Encode()
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(keyData)))
+	buf = append(buf, keyData...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(c.Value)))
+	return append(buf, c.Value...)
 }
 
 func DeserializeLeafCell(data []byte) (*LeafCell, error) {
@@ -84,19 +88,15 @@ func NewInteriorCell(key Key, childPage uint32) *InteriorCell {
 }
 
 func (c *InteriorCell) Serialize() []byte {
-	keyData := c.Key.Encode()
-	keyLen := len(keyData)
-
-	totalSize := 4 + 4 + keyLen
-	buf := make([]byte, totalSize)
-
-	binary.BigEndian.PutUint32(buf[0:4], c.ChildPage)
-
-	binary.BigEndian.PutUint32(buf[4:8], uint32(keyLen))
-
-	copy(buf[8:], keyData)
+	return c.appendTo(make([]byte, 0, c.Size()))
+}
 
-	return buf
+// appendTo appends the serialized cell to buf.
+func (c *InteriorCell) appendTo(buf []byte) []byte {
+	keyData := c.Key.Encode()
+	buf = binary.BigEndian.AppendUint32(buf, c.ChildPage)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(keyData)))
+	return append(buf, keyData...)
 }
 
 func DeserializeInteriorCell(data []byte) (*InteriorCell, error) {
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CompositeKey is a tuple of keys, such as the values of a multi-column
+// index. Tuples compare by their components in turn, and a tuple sorts
+// before the longer ones it is a prefix of.
+//
+// Its encoding sorts in the same order. After the type tag, each component
+// is written as its own tag followed by its value in a form whose bytes
+// sort in order, and a 0x00 byte, below every tag, ends the tuple:
+//
+//	int, float  the 8 bytes of its key encoding
+//	boolean     1 byte, 0 or 1
+//	text        the bytes, each 0x00 written as 0x00 0xff, then 0x00 0x01
+//	composite   its components and 0x00, as here
+type CompositeKey struct {
+	Components []Key
+}
+
+func NewCompositeKey(components ...Key) *CompositeKey {
+	return &CompositeKey{Components: components}
+}
+
+// PrefixBounds returns the range of the composite keys that start with the
+// components of prefix: every such key sorts at or after start and before
+// end. end is only for comparing against; it cannot be stored.
+func PrefixBounds(prefix ...Key) (start, end Key) {
+	components := append(append(make([]Key, 0, len(prefix)+1), prefix...), lastKey{})
+	return NewCompositeKey(components[:len(prefix):len(prefix)]...), NewCompositeKey(components...)
+}
+
+// lastKey sorts after every other key. It ends the range of PrefixBounds.
+type lastKey struct{}
+
+func (lastKey) Compare(other Key) int {
+	if _, ok := other.(lastKey); ok {
+		return 0
+	}
+	return 1
+}
+
+func (lastKey) Encode() []byte { return []byte{0xff} }
+
+func (lastKey) Type() KeyType { return 0xff }
+
+func (lastKey) String() string { return "Last" }
+
+func (k *CompositeKey) Compare(other Key) int {
+	otherComposite, ok := other.(*CompositeKey)
+	if !ok {
+		if k.Type() < other.Type() {
+			return -1
+		}
+		return 1
+	}
+
+	for i, component := range k.Components {
+		if i == len(otherComposite.Components) {
+			return 1
+		}
+		if c := component.Compare(otherComposite.Components[i]); c != 0 {
+			return c
+		}
+	}
+	if len(k.Components) < len(otherComposite.Components) {
+		return -1
+	}
+	return 0
+}
+
+func (k *CompositeKey) Encode() []byte {
+	return k.appendTo([]byte{byte(KeyTypeComposite)})
+}
+
+// appendTo appends the components and the terminator to buf.
+func (k *CompositeKey) appendTo(buf []byte) []byte {
+	for _, component := range k.Components {
+		switch c := component.(type) {
+		case *TextKey:
+			buf = append(buf, byte(KeyTypeText))
+			buf = append(buf, strings.ReplaceAll(c.Value, "\x00", "\x00\xff")...)
+			buf = append(buf, 0x00, 0x01)
+		case *CompositeKey:
+			buf = c.appendTo(append(buf, byte(KeyTypeComposite)))
+		default:
+			// Int, float and boolean encodings already sort in order.
+			buf = append(buf, component.Encode()...)
+		}
+	}
+	return append(buf, 0x00)
+}
+
+func (k *CompositeKey) Type() KeyType {
+	return KeyTypeComposite
+}
+
+func (k *CompositeKey) String() string {
+	parts := make([]string, len(k.Components))
+	for i, component := range k.Components {
+		parts[i] = component.String()
+	}
+	return fmt.Sprintf("Composite(%s)", strings.Join(parts, ", "))
+}
+
+// decodeComposite decodes the components of a composite key from data,
+// which starts after its type tag, and returns the bytes after it.
+func decodeComposite(data []byte) (*CompositeKey, []byte, error) {
+	key := &CompositeKey{}
+	for {
+		if len(data) == 0 {
+			return nil, nil, errors.New("composite key data truncated")
+		}
+		tag := KeyType(data[0])
+		if tag == 0x00 {
+			return key, data[1:], nil
+		}
+
+		var component Key
+		switch tag {
+		case KeyTypeInt, KeyTypeFloat:
+			if len(data) < 9 {
+				return nil, nil, errors.New("composite key data truncated")
+			}
+			var err error
+			if component, err = DecodeKey(data[:9]); err != nil {
+				return nil, nil, err
+			}
+			data = data[9:]
+		case KeyTypeBoolean:
+			if len(data) < 2 {
+				return nil, nil, errors.New("composite key data truncated")
+			}
+			component = NewBooleanKey(data[1] != 0)
+			data = data[2:]
+		case KeyTypeText:
+			var text []byte
+			data = data[1:]
+			for {
+				if len(data) < 2 {
+					return nil, nil, errors.New("composite key text truncated")
+				}
+				if data[0] != 0x00 {
+					text = append(text, data[0])
+					data = data[1:]
+					continue
+				}
+				if data[1] == 0x01 {
+					data = data[2:]
+					break
+				}
+				if data[1] != 0xff {
+					return nil, nil, fmt.Errorf("invalid escape 0x00 0x%02x in composite key text", data[1])
+				}
+				text = append(text, 0x00)
+				data = data[2:]
+			}
+			component = NewTextKey(string(text))
+		case KeyTypeComposite:
+			var err error
+			if component, data, err = decodeComposite(data[1:]); err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("unknown key type in composite key: %d", tag)
+		}
+		key.Components = append(key.Components, component)
+	}
+}
@@ -2,6 +2,8 @@ package storage
 
 const (
 	PageSize = 4096
+
+	MaxCachedPages = 1024
 )
 
 type PageType byte
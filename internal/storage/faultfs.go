@@ -0,0 +1,419 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+// ErrFault is returned by the call a FaultFS fails, and by every call after
+// it.
+var ErrFault = errors.New("injected fault")
+
+// FaultOp is a kind of call that a FaultFS counts.
+type FaultOp int
+
+const (
+	OpWrite FaultOp = iota + 1
+	OpTruncate
+	OpSync
+	OpCreate
+	OpRemove
+	OpSyncDir
+)
+
+func (op FaultOp) String() string {
+	switch op {
+	case OpWrite:
+		return "write"
+	case OpTruncate:
+		return "truncate"
+	case OpSync:
+		return "sync"
+	case OpCreate:
+		return "create"
+	case OpRemove:
+		return "remove"
+	case OpSyncDir:
+		return "sync dir"
+	}
+	return fmt.Sprintf("FaultOp(%d)", int(op))
+}
+
+// Fault picks the call a FaultFS fails. Calls that change files are counted
+// in the order they are made, skipping those that do not match Op, Name and
+// Offset; the At-th one fails.
+type Fault struct {
+	// At is the matching call to fail, counting from 1. Zero injects
+	// nothing, which is how a workload's calls are counted.
+	At int
+	// Op limits the fault to one kind of call; zero counts them all.
+	Op FaultOp
+	// Name limits the fault to files whose base name matches the
+	// filepath.Match pattern, such as "*.wal".
+	Name string
+	// Offset, if positive, limits the fault to writes covering that byte.
+	Offset int64
+	// Torn lets a failing write store the first half of its data.
+	Torn bool
+	// KeepUnsynced keeps what was written since each file's last Sync when
+	// the fault crashes the file system. By default it is lost.
+	KeepUnsynced bool
+}
+
+// FaultFS wraps a VFS and fails one chosen call to simulate a crash at that
+// point. From the fault on every call fails with ErrFault, and the files in
+// the wrapped VFS are left as they would be after losing power: unsynced
+// writes are undone unless Fault.KeepUnsynced is set, and a torn write is
+// half done. Reopening the database in the wrapped VFS then shows whether it
+// recovers. Creating and removing files is durable at once.
+type FaultFS struct {
+	base  VFS
+	fault Fault
+
+	mu      sync.Mutex
+	calls   int
+	fired   string
+	crashed bool
+
+	// unsynced holds, per file, how to undo each change made since its
+	// last Sync, oldest first.
+	unsynced map[string][]undo
+}
+
+// undo restores a file's size and the bytes at off that a change
+// overwrote.
+type undo struct {
+	size int64
+	off  int64
+	data []byte
+}
+
+func NewFaultFS(base VFS, fault Fault) *FaultFS {
+	return &FaultFS{base: base, fault: fault, unsynced: make(map[string][]undo)}
+}
+
+// Calls returns the number of matching calls made so far.
+func (f *FaultFS) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// Fired describes the call that failed, or returns "" if none has.
+func (f *FaultFS) Fired() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fired
+}
+
+// Crash fails every call from now on and leaves the wrapped files as a
+// power loss would, as if the fault had just fired.
+func (f *FaultFS) Crash() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.crash()
+}
+
+func (f *FaultFS) crash() error {
+	if f.crashed {
+		return nil
+	}
+	f.crashed = true
+	if f.fault.KeepUnsynced {
+		return nil
+	}
+	for name, undos := range f.unsynced {
+		if err := f.revert(name, undos); err != nil {
+			return fmt.Errorf("failed to undo unsynced writes to %s: %w", name, err)
+		}
+	}
+	f.unsynced = nil
+	return nil
+}
+
+func (f *FaultFS) revert(name string, undos []undo) error {
+	file, err := f.base.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for i := len(undos) - 1; i >= 0; i-- {
+		u := undos[i]
+		if _, err := file.WriteAt(u.data, u.off); err != nil {
+			return err
+		}
+		if err := file.Truncate(u.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check counts a call and reports whether it is the one to fail. It must
+// be called with f.mu held.
+func (f *FaultFS) check(op FaultOp, name string, off, n int64) (bool, error) {
+	if f.crashed {
+		return false, ErrFault
+	}
+	if f.fault.Op != 0 && op != f.fault.Op {
+		return false, nil
+	}
+	if f.fault.Name != "" {
+		if ok, _ := filepath.Match(f.fault.Name, filepath.Base(name)); !ok {
+			return false, nil
+		}
+	}
+	if f.fault.Offset > 0 && (op != OpWrite || f.fault.Offset < off || f.fault.Offset >= off+n) {
+		return false, nil
+	}
+	f.calls++
+	if f.calls != f.fault.At {
+		return false, nil
+	}
+	f.fired = fmt.Sprintf("%s of %s", op, name)
+	if op == OpWrite {
+		f.fired = fmt.Sprintf("write of %d bytes at %d to %s", n, off, name)
+	}
+	return true, nil
+}
+
+// fail crashes the file system at the call check picked.
+func (f *FaultFS) fail() error {
+	if err := f.crash(); err != nil {
+		return err
+	}
+	return ErrFault
+}
+
+// remember records how to undo a change to name that leaves the bytes from
+// off on as they are in file now.
+func (f *FaultFS) remember(name string, file File, off, n int64) error {
+	if f.fault.KeepUnsynced {
+		return nil
+	}
+	size, err := file.Size()
+	if err != nil {
+		return err
+	}
+	u := undo{size: size, off: off}
+	if end := min(off+n, size); end > off {
+		u.data = make([]byte, end-off)
+		if _, err := file.ReadAt(u.data, off); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	f.unsynced[name] = append(f.unsynced[name], u)
+	return nil
+}
+
+func (f *FaultFS) Open(name string) (File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crashed {
+		return nil, ErrFault
+	}
+	file, err := f.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{fs: f, name: filepath.Clean(name), file: file}, nil
+}
+
+func (f *FaultFS) Create(name string) (File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if fire, err := f.check(OpCreate, name, 0, 0); err != nil {
+		return nil, err
+	} else if fire {
+		return nil, f.fail()
+	}
+
+	// Emptying an existing file is a write like any other.
+	file, err := f.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	size, err := file.Size()
+	if err == nil {
+		err = f.remember(name, file, 0, size)
+	}
+	if err == nil {
+		err = file.Truncate(0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &faultFile{fs: f, name: name, file: file}, nil
+}
+
+func (f *FaultFS) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if fire, err := f.check(OpRemove, name, 0, 0); err != nil {
+		return err
+	} else if fire {
+		return f.fail()
+	}
+	if err := f.base.Remove(name); err != nil {
+		return err
+	}
+	delete(f.unsynced, name)
+	return nil
+}
+
+func (f *FaultFS) Exists(name string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crashed {
+		return false, ErrFault
+	}
+	return f.base.Exists(name)
+}
+
+func (f *FaultFS) MkdirAll(dir string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crashed {
+		return ErrFault
+	}
+	return f.base.MkdirAll(dir)
+}
+
+func (f *FaultFS) ReadDir(dir string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crashed {
+		return nil, ErrFault
+	}
+	return f.base.ReadDir(dir)
+}
+
+func (f *FaultFS) SyncDir(dir string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if fire, err := f.check(OpSyncDir, filepath.Clean(dir), 0, 0); err != nil {
+		return err
+	} else if fire {
+		return f.fail()
+	}
+	return f.base.SyncDir(dir)
+}
+
+type faultFile struct {
+	fs   *FaultFS
+	name string
+	file File
+}
+
+func (f *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.fs.crashed {
+		return 0, ErrFault
+	}
+	return f.file.ReadAt(p, off)
+}
+
+func (f *faultFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	fire, err := f.fs.check(OpWrite, f.name, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	if fire {
+		if err := f.fs.fail(); err != ErrFault {
+			return 0, err
+		}
+		if f.fs.fault.Torn {
+			if n, err := f.file.WriteAt(p[:len(p)/2], off); err != nil {
+				return n, err
+			}
+		}
+		return 0, ErrFault
+	}
+	if err := f.fs.remember(f.name, f.file, off, int64(len(p))); err != nil {
+		return 0, err
+	}
+	return f.file.WriteAt(p, off)
+}
+
+func (f *faultFile) Size() (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.fs.crashed {
+		return 0, ErrFault
+	}
+	return f.file.Size()
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if fire, err := f.fs.check(OpTruncate, f.name, 0, 0); err != nil {
+		return err
+	} else if fire {
+		return f.fs.fail()
+	}
+	current, err := f.file.Size()
+	if err != nil {
+		return err
+	}
+	if size < current {
+		if err := f.fs.remember(f.name, f.file, size, current-size); err != nil {
+			return err
+		}
+	} else if err := f.fs.remember(f.name, f.file, current, 0); err != nil {
+		return err
+	}
+	return f.file.Truncate(size)
+}
+
+func (f *faultFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if fire, err := f.fs.check(OpSync, f.name, 0, 0); err != nil {
+		return err
+	} else if fire {
+		return f.fs.fail()
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	delete(f.fs.unsynced, f.name)
+	return nil
+}
+
+func (f *faultFile) Lock(mode LockMode) error {
+	return f.file.Lock(mode)
+}
+
+func (f *faultFile) TryLock(mode LockMode) (bool, error) {
+	return f.file.TryLock(mode)
+}
+
+func (f *faultFile) Unlock() error {
+	return f.file.Unlock()
+}
+
+func (f *faultFile) Close() error {
+	return f.file.Close()
+}
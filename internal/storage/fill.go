@@ -0,0 +1,87 @@
+package storage
+
+import "fmt"
+
+const (
+	// DefaultFillFactor packs pages full.
+	DefaultFillFactor = 100
+
+	// MinFillFactor keeps pages from being left almost empty.
+	MinFillFactor = 10
+)
+
+// ValidFillFactor checks a fill factor, a percentage of each page. Zero
+// means DefaultFillFactor.
+func ValidFillFactor(percent int) error {
+	if percent != 0 && (percent < MinFillFactor || percent > 100) {
+		return fmt.Errorf("fill factor must be between %d and 100, got %d", MinFillFactor, percent)
+	}
+	return nil
+}
+
+// SetFillFactor sets how full, as a percentage, the tree fills the pages it
+// fills from left to right: leaves written by BulkLoad, and the left page
+// when a split at the right edge of the tree suggests keys are arriving in
+// increasing order. Pages then keep the rest free for later inserts and
+// updates among their keys. Other splits still divide a page in half. Zero
+// means DefaultFillFactor. The fill factor is not stored in the tree, so it
+// applies until the tree is loaded again.
+func (tree *BTree) SetFillFactor(percent int) error {
+	if err := ValidFillFactor(percent); err != nil {
+		return err
+	}
+	tree.fillFactor = percent
+	return nil
+}
+
+// FillFactor returns the tree's fill factor.
+func (tree *BTree) FillFactor() int {
+	if tree.fillFactor == 0 {
+		return DefaultFillFactor
+	}
+	return tree.fillFactor
+}
+
+// fillLimit is how many bytes of page, beyond its header, a page filled from
+// left to right may use.
+func (tree *BTree) fillLimit(page *Page) int {
+	return (PageSize - page.GetHeaderSize()) * tree.FillFactor() / 100
+}
+
+// fillable reports whether page, being filled from left to right, takes
+// another cell with key of size bytes. Before saying no, it gives the page
+// the longest key prefix its cells share.
+func (tree *BTree) fillable(page *Page, key Key, size uint32) (bool, error) {
+	fits := func() bool {
+		if !page.CanFitCell(key, size) {
+			return false
+		}
+		used := PageSize - page.GetHeaderSize() - int(page.GetTotalFreeSpace())
+		return used+int(size)-sharedPrefix(page.keyPrefix(), key)+2 <= tree.fillLimit(page)
+	}
+	if fits() {
+		return true, nil
+	}
+	if err := page.compressKeys(); err != nil {
+		return false, err
+	}
+	return fits(), nil
+}
+
+// rightSplit picks the split point of a page at the right edge of the tree
+// whose new cell is its last: the left page keeps the cells, of the given
+// sizes, that fit within the fill factor and the right page starts almost
+// empty, ready for the keys that follow. keepRight is how many cells the
+// right page needs at least.
+func (tree *BTree) rightSplit(page *Page, sizes []uint32, keepRight int) int {
+	limit := tree.fillLimit(page)
+	used, mid := 0, 0
+	for mid < len(sizes)-keepRight && used+int(sizes[mid])+2 <= limit {
+		used += int(sizes[mid]) + 2
+		mid++
+	}
+	if mid == 0 {
+		mid = 1
+	}
+	return mid
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The header's Version is the format version of the file: the newest format
+// whose features the file may use. A build opens files from
+// MinFormatVersion to its own FormatVersion. It refuses newer ones, whose
+// pages it might not read correctly and would not maintain, and older ones
+// it can no longer read, which Catalog.Upgrade converts.
+//
+//	version  adds
+//	1        the original format
+//	2        index pages with key prefixes, the freelist and the pointer map
+//	3        int and float keys encoded so that their bytes sort in order
+//	4        composite keys for multi-column and non-unique indexes and
+//	         for the rows of clustered tables
+//
+// New files get FormatVersion. An older file this build can open is
+// raised to it when it is first written, since any write may use the
+// newer features.
+const (
+	FormatVersion    = 4
+	MinFormatVersion = 4
+)
+
+var (
+	// ErrFormatTooNew is returned when opening a file written by a newer
+	// build.
+	ErrFormatTooNew = errors.New("database file format is newer than this build supports")
+
+	// ErrFormatTooOld is returned when opening a file that must be
+	// upgraded first.
+	ErrFormatTooOld = errors.New("database file format is too old for this build")
+)
+
+func checkFormatVersion(version uint32) error {
+	if version > FormatVersion {
+		return fmt.Errorf("%w: the file has format version %d, this build reads up to %d", ErrFormatTooNew, version, FormatVersion)
+	}
+	return nil
+}
+
+func (p *Pager) checkMinFormatVersion() error {
+	if p.header.Version < MinFormatVersion {
+		return fmt.Errorf("%w: the file has format version %d, this build reads %d and later; run anubisdb upgrade %s", ErrFormatTooOld, p.header.Version, MinFormatVersion, p.path)
+	}
+	return nil
+}
+
+// OpenPagerToUpgrade opens filename like OpenPager, but also if its format
+// version is older than MinFormatVersion, for Catalog.Upgrade. Nothing else
+// may read the file through it until it is upgraded.
+func OpenPagerToUpgrade(vfs VFS, filename string) (*Pager, error) {
+	return openPager(vfs, filename, 1)
+}
+
+// FormatVersion returns the format version of the file.
+func (p *Pager) FormatVersion() uint32 {
+	return p.header.Version
+}
+
+// RaiseFormatVersion sets the file's format version to FormatVersion.
+func (p *Pager) RaiseFormatVersion() error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	old := p.header.Version
+	p.header.Version = FormatVersion
+	if err := p.saveHeader(); err != nil {
+		p.header.Version = old
+		return err
+	}
+	return nil
+}
+
+// MigrateKeys rewrites, in place, the int and float keys of a file older
+// than format version 3 in the current encoding: those of every table
+// tree page, and those in the logs of the LSM trees rooted at lsmRoots.
+// Index pages are left alone, since indexes can be rebuilt from their
+// tables. Keys keep their order, so no page is split or merged.
+func (p *Pager) MigrateKeys(lsmRoots []uint32) error {
+	if p.header.Version >= 3 {
+		return nil
+	}
+	for pageNum := uint32(1); pageNum <= p.numPages; pageNum++ {
+		if p.header.PointerMap && isPtrmapPage(pageNum) {
+			continue
+		}
+		page, err := p.ReadPage(pageNum)
+		if err != nil {
+			return err
+		}
+		changed, err := page.migrateKeys()
+		if err == nil && changed {
+			err = p.WritePage(pageNum, page)
+		}
+		p.ReleasePage(page)
+		if err != nil {
+			return fmt.Errorf("failed to migrate keys of page %d: %w", pageNum, err)
+		}
+	}
+
+	for _, root := range lsmRoots {
+		if err := migrateLogKeys(p, root); err != nil {
+			return fmt.Errorf("failed to migrate keys of LSM tree %d: %w", root, err)
+		}
+	}
+	return nil
+}
+
+// migrateKeys rewrites the keys of a table page, reporting whether any
+// changed.
+func (page *Page) migrateKeys() (bool, error) {
+	var keyLenAt int
+	switch page.Header.PageType {
+	case PageTypeLeafTable:
+		keyLenAt = 0
+	case PageTypeInteriorTable:
+		keyLenAt = 4
+	default:
+		return false, nil
+	}
+
+	changed := false
+	for i := uint16(0); i < page.Header.NumCells; i++ {
+		off, err := page.GetCellPointer(i)
+		if err != nil {
+			return false, err
+		}
+		start := int(off) + keyLenAt + 4
+		if start > len(page.Data) {
+			return false, fmt.Errorf("cell %d offset exceeds page size", i)
+		}
+		keyLen := int(binary.BigEndian.Uint32(page.Data[start-4 : start]))
+		if start+keyLen > len(page.Data) {
+			return false, fmt.Errorf("cell %d key exceeds page size", i)
+		}
+		if migrateKey(page.Data[start : start+keyLen]) {
+			changed = true
+		}
+	}
+	return changed, nil
+}
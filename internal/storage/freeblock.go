@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Space freed by a deleted cell inside the cell content area is kept on a
+// freeblock list, ordered by offset and starting at Header.FirstFreeblock.
+// Each freeblock begins with the offset of the next one (0 ends the list) and
+// its own size, both uint16, so a freeblock is at least 4 bytes; smaller
+// leftovers are counted in Header.FragmentedBytes instead.
+const minFreeblockSize = 4
+
+type freeblock struct {
+	offset uint16
+	size   uint16
+}
+
+func (p *Page) freeblocks() ([]freeblock, error) {
+	var blocks []freeblock
+	minOffset := p.Header.CellContentOffset
+	for offset := p.Header.FirstFreeblock; offset != 0; {
+		if offset < minOffset || int(offset)+minFreeblockSize > len(p.Data) {
+			return nil, fmt.Errorf("freeblock at %d is out of order or outside the content area", offset)
+		}
+		size := binary.BigEndian.Uint16(p.Data[offset+2 : offset+4])
+		if size < minFreeblockSize || int(offset)+int(size) > len(p.Data) {
+			return nil, fmt.Errorf("freeblock at %d has invalid size %d", offset, size)
+		}
+		blocks = append(blocks, freeblock{offset: offset, size: size})
+		minOffset = offset + size
+		offset = binary.BigEndian.Uint16(p.Data[offset : offset+2])
+	}
+	return blocks, nil
+}
+
+func (p *Page) freeblockBytes() uint16 {
+	blocks, err := p.freeblocks()
+	if err != nil {
+		return 0
+	}
+	total := uint16(0)
+	for _, b := range blocks {
+		total += b.size
+	}
+	return total
+}
+
+// setFreeblocks writes blocks, which must be sorted by offset, as the page's
+// freeblock list. Adjacent blocks are merged, and a block touching the start
+// of the content area is returned to the unallocated gap.
+func (p *Page) setFreeblocks(blocks []freeblock) {
+	merged := blocks[:0]
+	for _, b := range blocks {
+		if n := len(merged); n > 0 && merged[n-1].offset+merged[n-1].size == b.offset {
+			merged[n-1].size += b.size
+			continue
+		}
+		merged = append(merged, b)
+	}
+
+	for len(merged) > 0 && merged[0].offset == p.Header.CellContentOffset {
+		p.Header.CellContentOffset += merged[0].size
+		merged = merged[1:]
+	}
+
+	p.Header.FirstFreeblock = 0
+	for i := len(merged) - 1; i >= 0; i-- {
+		b := merged[i]
+		binary.BigEndian.PutUint16(p.Data[b.offset:b.offset+2], p.Header.FirstFreeblock)
+		binary.BigEndian.PutUint16(p.Data[b.offset+2:b.offset+4], b.size)
+		p.Header.FirstFreeblock = b.offset
+	}
+}
+
+// freeSpace returns a deleted cell's bytes to the page.
+func (p *Page) freeSpace(offset, size uint16) error {
+	for i := uint16(0); i < size; i++ {
+		p.Data[offset+i] = 0
+	}
+
+	if size < minFreeblockSize {
+		if int(p.Header.FragmentedBytes)+int(size) > 255 {
+			return p.Defragment()
+		}
+		p.Header.FragmentedBytes += byte(size)
+		return nil
+	}
+
+	blocks, err := p.freeblocks()
+	if err != nil {
+		return err
+	}
+	pos := 0
+	for pos < len(blocks) && blocks[pos].offset < offset {
+		pos++
+	}
+	blocks = append(blocks, freeblock{})
+	copy(blocks[pos+1:], blocks[pos:])
+	blocks[pos] = freeblock{offset: offset, size: size}
+	p.setFreeblocks(blocks)
+	return nil
+}
+
+// freeblockFor carves size bytes out of the first freeblock large enough to
+// hold them. It takes the end of the block so the remainder stays in place; a
+// remainder too small to be a freeblock becomes fragmented bytes.
+func (p *Page) freeblockFor(size uint16) (uint16, bool) {
+	blocks, err := p.freeblocks()
+	if err != nil {
+		return 0, false
+	}
+
+	for i, b := range blocks {
+		if b.size < size {
+			continue
+		}
+		remainder := b.size - size
+		if remainder < minFreeblockSize && int(p.Header.FragmentedBytes)+int(remainder) > 255 {
+			continue
+		}
+
+		offset := b.offset + remainder
+		if remainder < minFreeblockSize {
+			p.Header.FragmentedBytes += byte(remainder)
+			for j := uint16(0); j < remainder; j++ {
+				p.Data[b.offset+j] = 0
+			}
+			blocks = append(blocks[:i], blocks[i+1:]...)
+		} else {
+			blocks[i].size = remainder
+		}
+		p.setFreeblocks(blocks)
+		return offset, true
+	}
+	return 0, false
+}
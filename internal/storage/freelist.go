@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Pages that no tree uses any more, such as those of a dropped table, go on
+// the freelist, and AllocatePage takes pages from it before growing the
+// file. The freelist is a chain of trunk pages starting at the header's
+// FreelistTrunk. Each trunk lists the numbers of some of the free pages,
+// which are rewritten as empty freelist leaves:
+//
+//	offset  size  field
+//	0       8     page header (type PageTypeFreelistTrunk)
+//	8       4     next trunk, 0 for the last one
+//	12      4     number of leaves listed
+//	16      4*n   leaf page numbers
+//
+// The trunks are free pages too: the header's FreePages counts them along
+// with the leaves.
+
+const (
+	trunkHeaderSize = 16
+	trunkCapacity   = (PageSize - trunkHeaderSize) / 4
+)
+
+func trunkNext(page *Page) uint32  { return binary.BigEndian.Uint32(page.Data[8:12]) }
+func trunkCount(page *Page) uint32 { return binary.BigEndian.Uint32(page.Data[12:16]) }
+
+func trunkLeaf(page *Page, i uint32) uint32 {
+	off := trunkHeaderSize + 4*i
+	return binary.BigEndian.Uint32(page.Data[off : off+4])
+}
+
+// FreePage puts pageNum on the freelist. Nothing may use it afterwards.
+func (p *Pager) FreePage(pageNum uint32) error {
+	if pageNum <= 1 || pageNum > p.numPages {
+		return fmt.Errorf("cannot free page %d", pageNum)
+	}
+	if p.header.PointerMap && isPtrmapPage(pageNum) {
+		return fmt.Errorf("cannot free pointer map page %d", pageNum)
+	}
+	delete(p.reads, pageNum)
+
+	if trunkNum := p.header.FreelistTrunk; trunkNum != 0 {
+		trunk, err := p.ReadPage(trunkNum)
+		if err != nil {
+			return fmt.Errorf("failed to read freelist trunk %d: %w", trunkNum, err)
+		}
+		if count := trunkCount(trunk); count < trunkCapacity {
+			off := trunkHeaderSize + 4*count
+			binary.BigEndian.PutUint32(trunk.Data[off:off+4], pageNum)
+			binary.BigEndian.PutUint32(trunk.Data[12:16], count+1)
+			if err := p.WritePage(trunkNum, trunk); err != nil {
+				return err
+			}
+			p.ReleasePage(trunk)
+
+			leaf, err := NewPage(PageTypeFreelistLeaf, pageNum)
+			if err != nil {
+				return err
+			}
+			if err := p.WritePage(pageNum, leaf); err != nil {
+				return err
+			}
+			return p.freed(pageNum)
+		}
+		p.ReleasePage(trunk)
+	}
+
+	trunk, err := NewPage(PageTypeFreelistTrunk, pageNum)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(trunk.Data[8:12], p.header.FreelistTrunk)
+	if err := p.WritePage(pageNum, trunk); err != nil {
+		return err
+	}
+	p.header.FreelistTrunk = pageNum
+	return p.freed(pageNum)
+}
+
+func (p *Pager) freed(pageNum uint32) error {
+	p.header.FreePages++
+	if err := p.saveHeader(); err != nil {
+		return err
+	}
+	return p.setPtrmap(pageNum, ptrmapFree, 0)
+}
+
+// popFreePage takes a page off the freelist, the last leaf of the first
+// trunk or the trunk itself once it lists none. It returns 0 if the
+// freelist is empty.
+func (p *Pager) popFreePage() (uint32, error) {
+	trunkNum := p.header.FreelistTrunk
+	if trunkNum == 0 {
+		return 0, nil
+	}
+	trunk, err := p.ReadPage(trunkNum)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read freelist trunk %d: %w", trunkNum, err)
+	}
+	defer p.ReleasePage(trunk)
+
+	pageNum := trunkNum
+	if count := trunkCount(trunk); count > 0 {
+		pageNum = trunkLeaf(trunk, count-1)
+		binary.BigEndian.PutUint32(trunk.Data[12:16], count-1)
+		if err := p.WritePage(trunkNum, trunk); err != nil {
+			return 0, err
+		}
+	} else {
+		p.header.FreelistTrunk = trunkNext(trunk)
+	}
+	p.header.FreePages--
+	return pageNum, p.saveHeader()
+}
+
+// freePages lists every page on the freelist, trunks included.
+func (p *Pager) freePages() ([]uint32, error) {
+	var pages []uint32
+	seen := make(map[uint32]bool)
+	for trunkNum := p.header.FreelistTrunk; trunkNum != 0; {
+		if trunkNum > p.numPages || seen[trunkNum] {
+			return nil, fmt.Errorf("freelist trunk %d is out of range or repeated", trunkNum)
+		}
+		trunk, err := p.ReadPage(trunkNum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read freelist trunk %d: %w", trunkNum, err)
+		}
+		if trunk.Header.PageType != PageTypeFreelistTrunk {
+			p.ReleasePage(trunk)
+			return nil, fmt.Errorf("freelist trunk %d has page type %s", trunkNum, trunk.Header.PageType)
+		}
+		count := trunkCount(trunk)
+		if count > trunkCapacity {
+			p.ReleasePage(trunk)
+			return nil, fmt.Errorf("freelist trunk %d lists %d leaves", trunkNum, count)
+		}
+		seen[trunkNum] = true
+		pages = append(pages, trunkNum)
+		for i := uint32(0); i < count; i++ {
+			leaf := trunkLeaf(trunk, i)
+			if leaf <= 1 || leaf > p.numPages || seen[leaf] {
+				p.ReleasePage(trunk)
+				return nil, fmt.Errorf("freelist trunk %d lists page %d, which is out of range or repeated", trunkNum, leaf)
+			}
+			seen[leaf] = true
+			pages = append(pages, leaf)
+		}
+		next := trunkNext(trunk)
+		p.ReleasePage(trunk)
+		trunkNum = next
+	}
+	return pages, nil
+}
+
+// ValidateFreelist checks that the freelist holds as many pages as the
+// header says, each once, and that the pointer map, if any, knows them as
+// free.
+func (p *Pager) ValidateFreelist() error {
+	pages, err := p.freePages()
+	if err != nil {
+		return err
+	}
+	if uint32(len(pages)) != p.header.FreePages {
+		return fmt.Errorf("freelist holds %d pages, the header says %d", len(pages), p.header.FreePages)
+	}
+	if !p.header.PointerMap {
+		return nil
+	}
+	for _, pageNum := range pages {
+		kind, _, err := p.ptrmapEntry(pageNum)
+		if err != nil {
+			return err
+		}
+		if kind != ptrmapFree {
+			return fmt.Errorf("free page %d has pointer map entry type %d", pageNum, kind)
+		}
+	}
+	return nil
+}
+
+// saveHeader writes the header after its freelist fields changed.
+func (p *Pager) saveHeader() error {
+	if p.batch != nil {
+		p.writeBatchPage(0, p.encodeHeader())
+		return nil
+	}
+	if err := p.logPages(p.numPages, []walPage{{0, p.encodeHeader()}}, false); err != nil {
+		return err
+	}
+	if p.wal != nil {
+		return p.checkpointIfFull()
+	}
+	if err := p.nextChange(); err != nil {
+		return err
+	}
+	return p.writeHeader()
+}
+
+// Free puts every page of the tree on the freelist. The tree must not be
+// used afterwards.
+func (tree *BTree) Free() error {
+	pages, err := tree.pages()
+	if err != nil {
+		return err
+	}
+	for _, pageNum := range pages {
+		if err := tree.pager.FreePage(pageNum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pages lists the pages of the tree, the root first.
+func (tree *BTree) pages() ([]uint32, error) {
+	pages := []uint32{tree.root}
+	for i := 0; i < len(pages); i++ {
+		page, err := tree.pager.ReadPage(pages[i])
+		if err != nil {
+			return nil, err
+		}
+		if isInterior(page.Header.PageType) {
+			children, err := page.children()
+			if err != nil {
+				tree.pager.ReleasePage(page)
+				return nil, err
+			}
+			pages = append(pages, children...)
+		}
+		tree.pager.ReleasePage(page)
+		if len(pages) > int(tree.pager.GetNumPages()) {
+			return nil, errors.New("tree has more pages than the file")
+		}
+	}
+	return pages, nil
+}
+
+// children lists the child pages of an interior page, the rightmost last.
+func (page *Page) children() ([]uint32, error) {
+	children := make([]uint32, 0, page.Header.NumCells+1)
+	for i := uint16(0); i < page.Header.NumCells; i++ {
+		off, err := page.GetCellPointer(i)
+		if err != nil {
+			return nil, err
+		}
+		if int(off)+4 > len(page.Data) {
+			return nil, fmt.Errorf("cell %d offset exceeds page size", i)
+		}
+		children = append(children, binary.BigEndian.Uint32(page.Data[off:off+4]))
+	}
+	return append(children, page.Header.RightmostPointer), nil
+}
+
+// replaceChild points the interior page's reference to child at to
+// instead, reporting whether it had one.
+func (page *Page) replaceChild(child, to uint32) (bool, error) {
+	if page.Header.RightmostPointer == child {
+		page.Header.RightmostPointer = to
+		return true, nil
+	}
+	for i := uint16(0); i < page.Header.NumCells; i++ {
+		off, err := page.GetCellPointer(i)
+		if err != nil {
+			return false, err
+		}
+		if int(off)+4 > len(page.Data) {
+			return false, fmt.Errorf("cell %d offset exceeds page size", i)
+		}
+		if binary.BigEndian.Uint32(page.Data[off:off+4]) == child {
+			binary.BigEndian.PutUint32(page.Data[off:off+4], to)
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// Once TrackHotPages turns it on, the pager counts the reads of each page so
+// that, after a restart, the pages read most can be loaded into the cache
+// before the first statement needs them. Close then saves the hottest
+// MaxCachedPages of them, by page number, to FILE-hot in the database's
+// VFS:
+//
+//	magic   [8]byte  hotMagic
+//	count   uint32
+//	pages   [count]uint32, hottest first
+//
+// Counts are halved once more than maxHotPages pages have one, so pages
+// that stop being read cool down and the counts stay bounded. A freed page
+// loses its count, and creating a database removes any FILE-hot left by an
+// earlier file of the same name.
+var hotMagic = [8]byte{'A', 'n', 'u', 'b', 'i', 's', 'H', 'P'}
+
+const (
+	hotHeaderSize = 12
+	maxHotPages   = 4 * MaxCachedPages
+)
+
+// PageHeat is a page and the number of times it was read, halved each time
+// the counts are aged.
+type PageHeat struct {
+	Page  uint32
+	Reads uint64
+}
+
+func hotPath(path string) string {
+	return path + "-hot"
+}
+
+// removeHotPages removes the hot page list of the database at path, if it
+// has one.
+func removeHotPages(vfs VFS, path string) error {
+	exists, err := vfs.Exists(hotPath(path))
+	if err != nil || !exists {
+		return err
+	}
+	return vfs.Remove(hotPath(path))
+}
+
+// TrackHotPages turns the counting of page reads on or off; it is off by
+// default. Turning it off forgets the counts, and Close then leaves the
+// saved list alone.
+func (p *Pager) TrackHotPages(on bool) {
+	p.trackReads = on
+	if !on {
+		p.reads = nil
+	}
+}
+
+// countRead records a read of pageNum while reads are tracked.
+func (p *Pager) countRead(pageNum uint32) {
+	if !p.trackReads {
+		return
+	}
+	if p.reads == nil {
+		p.reads = make(map[uint32]uint64)
+	}
+	p.reads[pageNum]++
+	if len(p.reads) <= maxHotPages {
+		return
+	}
+	for page, n := range p.reads {
+		if n /= 2; n == 0 {
+			delete(p.reads, page)
+		} else {
+			p.reads[page] = n
+		}
+	}
+}
+
+// HotPages returns the n pages read most since the database was opened,
+// hottest first, or all of them when n is 0 or less.
+func (p *Pager) HotPages(n int) []PageHeat {
+	hot := make([]PageHeat, 0, len(p.reads))
+	for page, reads := range p.reads {
+		if page <= p.numPages {
+			hot = append(hot, PageHeat{Page: page, Reads: reads})
+		}
+	}
+	sort.Slice(hot, func(i, j int) bool {
+		if hot[i].Reads != hot[j].Reads {
+			return hot[i].Reads > hot[j].Reads
+		}
+		return hot[i].Page < hot[j].Page
+	})
+	if n > 0 && len(hot) > n {
+		hot = hot[:n]
+	}
+	return hot
+}
+
+// SaveHotPages writes the hottest pages to FILE-hot for Warmup to load the
+// next time the database is opened. Close calls it while reads are tracked
+// and returns its error. A read-only handle, or one that has read nothing,
+// leaves the list saved before.
+func (p *Pager) SaveHotPages() error {
+	if p.readOnly || len(p.reads) == 0 {
+		return nil
+	}
+	hot := p.HotPages(MaxCachedPages)
+	data := make([]byte, hotHeaderSize+4*len(hot))
+	copy(data[0:8], hotMagic[:])
+	binary.BigEndian.PutUint32(data[8:12], uint32(len(hot)))
+	for i, h := range hot {
+		binary.BigEndian.PutUint32(data[hotHeaderSize+4*i:], h.Page)
+	}
+
+	file, err := p.vfs.Create(hotPath(p.path))
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Warmup reads the pages saved by SaveHotPages into the cache, hottest
+// first, as many as it holds, so that the first statements after a restart
+// find them there. Pages past the end of the file are skipped, and a
+// database without a saved list is left cold. It returns the number of
+// pages loaded. Reads by Warmup do not count towards HotPages.
+func (p *Pager) Warmup() (int, error) {
+	path := hotPath(p.path)
+	exists, err := p.vfs.Exists(path)
+	if err != nil || !exists {
+		return 0, err
+	}
+	file, err := p.vfs.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	size, err := file.Size()
+	if err != nil {
+		return 0, err
+	}
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, 0); err != nil {
+		return 0, err
+	}
+	if len(data) < hotHeaderSize || [8]byte(data[0:8]) != hotMagic ||
+		len(data) != hotHeaderSize+4*int(binary.BigEndian.Uint32(data[8:12])) {
+		return 0, errors.New("bad hot page list")
+	}
+
+	loaded := 0
+	buf := make([]byte, PageSize)
+	for off := hotHeaderSize; off < len(data) && loaded < MaxCachedPages; off += 4 {
+		pageNum := binary.BigEndian.Uint32(data[off:])
+		if pageNum == 0 || pageNum > p.numPages {
+			continue
+		}
+		if _, ok := p.cache.Peek(pageNum); ok {
+			loaded++
+			continue
+		}
+		if err := p.readPageData(pageNum, buf); err != nil {
+			return loaded, err
+		}
+		p.cachePage(pageNum, buf)
+		loaded++
+	}
+	return loaded, nil
+}
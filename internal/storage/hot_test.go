@@ -0,0 +1,46 @@
+package storage
+
+import "testing"
+
+func TestHotPagesOptIn(t *testing.T) {
+	fs := NewMemFS()
+	for _, track := range []bool{false, true} {
+		pager, err := OpenPager(fs, "test.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pager.TrackHotPages(track)
+		tree, err := NewBTree(pager, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Insert(NewIntKey(1), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tree.Search(NewIntKey(1)); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(pager.HotPages(0)) > 0; got != track {
+			t.Errorf("tracking %v: counted reads %v", track, got)
+		}
+		if err := pager.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if saved, _ := fs.Exists(hotPath("test.db")); saved != track {
+			t.Errorf("tracking %v: saved a hot page list %v", track, saved)
+		}
+	}
+
+	// A new database under the same name must not warm up from the list.
+	if err := fs.Remove("test.db"); err != nil {
+		t.Fatal(err)
+	}
+	pager, err := OpenPager(fs, "test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+	if saved, _ := fs.Exists(hotPath("test.db")); saved {
+		t.Error("creating the database kept the old hot page list")
+	}
+}
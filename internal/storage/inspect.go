@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+func (t PageType) String() string {
+	switch t {
+	case PageTypeInteriorTable:
+		return "interior table"
+	case PageTypeLeafTable:
+		return "leaf table"
+	case PageTypeInteriorIndex:
+		return "interior index"
+	case PageTypeLeafIndex:
+		return "leaf index"
+	case PageTypeInteriorIndexPrefix:
+		return "interior index with key prefix"
+	case PageTypeLeafIndexPrefix:
+		return "leaf index with key prefix"
+	case PageTypeFreelistTrunk:
+		return "freelist trunk"
+	case PageTypeFreelistLeaf:
+		return "freelist leaf"
+	case PageTypeOverflow:
+		return "overflow"
+	case PageTypePointerMap:
+		return "pointer map"
+	}
+	return fmt.Sprintf("unknown (0x%02x)", byte(t))
+}
+
+// PageInfo is a decoded view of a page for debugging. Decoding is lenient: a
+// damaged header or cell is reported in HeaderError or CellInfo.Error instead
+// of failing, so corrupt pages can still be looked at.
+type PageInfo struct {
+	Number      uint32
+	Header      PageHeader
+	HeaderError string
+	Cells       []CellInfo
+	Data        []byte
+}
+
+type CellInfo struct {
+	Offset uint16
+	Size   uint16
+	Key    string
+	Child  uint32 // interior cells only
+	Value  int    // leaf cells only: length of the value in bytes
+	Error  string
+}
+
+// InspectPage decodes page pageNum. Page 0 holds the database header and is
+// returned with only Data set.
+func (p *Pager) InspectPage(pageNum uint32) (*PageInfo, error) {
+	if pageNum > p.numPages {
+		return nil, fmt.Errorf("page %d out of range (last page is %d)", pageNum, p.numPages)
+	}
+
+	info := &PageInfo{Number: pageNum, Data: make([]byte, PageSize)}
+	if cached, ok := p.cache.Get(pageNum); ok && pageNum != 0 {
+		copy(info.Data, cached)
+	} else if err := p.readPageData(pageNum, info.Data); err != nil {
+		return nil, fmt.Errorf("failed to read page %d: %w", pageNum, err)
+	}
+	if pageNum == 0 {
+		return info, nil
+	}
+
+	page := &Page{Data: info.Data}
+	if err := page.readHeader(); err != nil {
+		info.HeaderError = err.Error()
+	}
+	info.Header = page.Header
+	if info.HeaderError != "" || !(isLeaf(page.Header.PageType) || isInterior(page.Header.PageType)) {
+		return info, nil
+	}
+
+	for i := uint16(0); i < page.Header.NumCells; i++ {
+		var cell CellInfo
+		offset, err := page.GetCellPointer(i)
+		if err != nil {
+			cell.Error = err.Error()
+			info.Cells = append(info.Cells, cell)
+			continue
+		}
+		cell.Offset = offset
+
+		if isLeaf(page.Header.PageType) {
+			leaf, err := page.GetLeafCell(i)
+			if err != nil {
+				cell.Error = err.Error()
+			} else {
+				cell.Size = uint16(leaf.Size())
+				cell.Key = leaf.Key.String()
+				cell.Value = len(leaf.Value)
+			}
+		} else {
+			interior, err := page.GetInteriorCell(i)
+			if err != nil {
+				cell.Error = err.Error()
+			} else {
+				cell.Size = uint16(interior.Size())
+				cell.Key = interior.Key.String()
+				cell.Child = interior.ChildPage
+			}
+		}
+		info.Cells = append(info.Cells, cell)
+	}
+	return info, nil
+}
+
+func (info *PageInfo) String() string {
+	var sb strings.Builder
+
+	if info.Number == 0 {
+		fmt.Fprintf(&sb, "page 0: database header\n")
+		fmt.Fprintf(&sb, "  magic=%q version=%d schema_generation=%d\n",
+			info.Data[0:8], binary.BigEndian.Uint32(info.Data[8:12]), binary.BigEndian.Uint64(info.Data[12:20]))
+		fmt.Fprintf(&sb, "  freelist_trunk=%d free_pages=%d pointer_map=%t\n",
+			binary.BigEndian.Uint32(info.Data[36:40]), binary.BigEndian.Uint32(info.Data[40:44]), info.Data[44] != 0)
+		return sb.String()
+	}
+
+	h := info.Header
+	fmt.Fprintf(&sb, "page %d: %s (0x%02x)\n", info.Number, h.PageType, byte(h.PageType))
+	if info.HeaderError != "" {
+		fmt.Fprintf(&sb, "  header error: %s\n", info.HeaderError)
+	}
+	fmt.Fprintf(&sb, "  cells=%d content_offset=%d first_freeblock=%d fragmented=%d\n",
+		h.NumCells, h.CellContentOffset, h.FirstFreeblock, h.FragmentedBytes)
+	switch {
+	case isInterior(h.PageType):
+		fmt.Fprintf(&sb, "  parent=%d rightmost=%d\n", h.ParentPage, h.RightmostPointer)
+	case isLeaf(h.PageType):
+		fmt.Fprintf(&sb, "  parent=%d next_leaf=%d prev_leaf=%d\n", h.ParentPage, h.NextLeaf, h.PrevLeaf)
+	case h.PageType == PageTypeFreelistTrunk && info.HeaderError == "":
+		page := &Page{Header: h, Data: info.Data}
+		fmt.Fprintf(&sb, "  next_trunk=%d leaves=%d\n", trunkNext(page), trunkCount(page))
+	}
+	if h.PrefixLen != 0 {
+		page := &Page{Header: h, Data: info.Data}
+		fmt.Fprintf(&sb, "  key_prefix=%q\n", page.keyPrefix())
+	}
+
+	if h.FirstFreeblock != 0 {
+		page := &Page{Header: h, Data: info.Data}
+		blocks, err := page.freeblocks()
+		sb.WriteString("  freeblocks:")
+		for _, b := range blocks {
+			fmt.Fprintf(&sb, " %d+%d", b.offset, b.size)
+		}
+		if err != nil {
+			fmt.Fprintf(&sb, " error: %v", err)
+		}
+		sb.WriteByte('\n')
+	}
+
+	for i, cell := range info.Cells {
+		fmt.Fprintf(&sb, "  [%d] offset=%d ", i, cell.Offset)
+		switch {
+		case cell.Error != "":
+			fmt.Fprintf(&sb, "error: %s\n", cell.Error)
+		case isInterior(h.PageType):
+			fmt.Fprintf(&sb, "size=%d key=%s child=%d\n", cell.Size, cell.Key, cell.Child)
+		default:
+			fmt.Fprintf(&sb, "size=%d key=%s value=%d bytes\n", cell.Size, cell.Key, cell.Value)
+		}
+	}
+	return sb.String()
+}
+
+// Hexdump returns the raw page bytes in hexdump -C format.
+func (info *PageInfo) Hexdump() string {
+	return hex.Dump(info.Data)
+}
@@ -1,62 +1,217 @@
 package storage
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
+// Iterator walks leaf entries in key order, following NextLeaf links from
+// one leaf to the next, or in descending order along PrevLeaf links. It keeps
+// the current leaf in memory, so changes made to the tree while iterating may
+// not be seen.
 type Iterator struct {
-	tree        *BTree
-	currentPage uint32
-	currentIdx  uint16
+	tree    *BTree
+	pageNum uint32
+	page    *Page
+	reverse bool
+
+	// idx is the next cell to return. A reverse iterator returns the cell
+	// before it, so idx 0 means the leaf is exhausted in either direction.
+	idx uint16
+
+	// stop is the inclusive bound in the direction of travel: the upper
+	// bound going forward, the lower bound in reverse. nil means no bound.
+	stop Key
+
+	// hops counts leaves visited, to stop on a corrupt, circular chain.
+	hops uint32
+	err  error
 }
 
+// NewIterator returns an iterator positioned at the smallest key.
 func (tree *BTree) NewIterator() (*Iterator, error) {
+	return tree.NewRangeIterator(nil, nil)
+}
+
+// NewRangeIterator returns an iterator over the keys k with
+// start <= k <= end. A nil start or end leaves that side unbounded.
+func (tree *BTree) NewRangeIterator(start, end Key) (*Iterator, error) {
+	it := &Iterator{tree: tree, stop: end}
+	if start != nil {
+		if err := it.Seek(start); err != nil {
+			return nil, err
+		}
+		return it, nil
+	}
+
 	leftmost, err := tree.findLeftmostLeaf()
 	if err != nil {
 		return nil, err
 	}
+	if err := it.load(leftmost, 0); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
 
-	return &Iterator{
-		tree:        tree,
-		currentPage: leftmost,
-		currentIdx:  0,
-	}, nil
+// NewReverseIterator returns an iterator positioned at the largest key that
+// walks the tree in descending order.
+func (tree *BTree) NewReverseIterator() (*Iterator, error) {
+	return tree.NewReverseRangeIterator(nil, nil)
 }
 
-func (it *Iterator) HasNext() bool {
-	if it.currentPage == 0 {
-		return false
+// NewReverseRangeIterator returns an iterator over the keys k with
+// start <= k <= end, largest first. A nil start or end leaves that side
+// unbounded.
+func (tree *BTree) NewReverseRangeIterator(start, end Key) (*Iterator, error) {
+	it := &Iterator{tree: tree, reverse: true, stop: start}
+	if end != nil {
+		if err := it.Seek(end); err != nil {
+			return nil, err
+		}
+		return it, nil
 	}
 
-	page, err := it.tree.pager.ReadPage(it.currentPage)
+	rightmost, err := tree.findRightmostLeaf()
 	if err != nil {
-		return false
+		return nil, err
+	}
+	if err := it.load(rightmost, 0); err != nil {
+		return nil, err
+	}
+	it.idx = it.page.Header.NumCells
+	return it, nil
+}
+
+// Seek positions the iterator at the first key >= key, or for a reverse
+// iterator at the last key <= key, descending from the root. The bound, if
+// any, is kept.
+func (it *Iterator) Seek(key Key) error {
+	leafNum, err := it.tree.navigateToLeaf(it.tree.root, key)
+	if err != nil {
+		return err
+	}
+
+	page, err := it.tree.pager.ReadPage(leafNum)
+	if err != nil {
+		return err
+	}
+
+	idx, found, err := page.SearchCell(key)
+	if err != nil {
+		it.tree.pager.ReleasePage(page)
+		return err
+	}
+	if it.reverse && found {
+		idx++
 	}
 
-	return it.currentIdx < page.Header.NumCells || page.Header.NextLeaf != 0
+	it.tree.pager.ReleasePage(it.page)
+	it.pageNum, it.page, it.idx = leafNum, page, idx
+	it.hops, it.err = 0, nil
+	return nil
 }
 
-func (it *Iterator) Next() (Key, []byte, error) {
-	page, err := it.tree.pager.ReadPage(it.currentPage)
+func (it *Iterator) load(pageNum uint32, idx uint16) error {
+	page, err := it.tree.pager.ReadPage(pageNum)
 	if err != nil {
-		return nil, nil, err
+		return fmt.Errorf("failed to read page %d: %w", pageNum, err)
+	}
+	// Cells are copied out of the leaf, so the one being left is unused.
+	it.tree.pager.ReleasePage(it.page)
+	it.pageNum, it.page, it.idx = pageNum, page, idx
+	return nil
+}
+
+// exhausted reports whether the current leaf has no cells left to return.
+func (it *Iterator) exhausted() bool {
+	if it.reverse {
+		return it.idx == 0
+	}
+	return it.idx >= it.page.Header.NumCells
+}
+
+// current is the index of the cell the next call to Next returns.
+func (it *Iterator) current() uint16 {
+	if it.reverse {
+		return it.idx - 1
+	}
+	return it.idx
+}
+
+// settle moves past exhausted and empty leaves so that current names a cell,
+// and reports whether one was found.
+func (it *Iterator) settle() bool {
+	if it.err != nil || it.page == nil {
+		return false
 	}
 
-	if it.currentIdx >= page.Header.NumCells {
-		if page.Header.NextLeaf == 0 {
-			return nil, nil, errors.New("no more entries")
+	for it.exhausted() {
+		next := it.page.Header.NextLeaf
+		if it.reverse {
+			next = it.page.Header.PrevLeaf
+		}
+		if next == 0 {
+			return false
+		}
+
+		it.hops++
+		if it.hops > it.tree.pager.GetNumPages() {
+			it.err = fmt.Errorf("circular reference detected in leaf chain at page %d", next)
+			return false
 		}
-		it.currentPage = page.Header.NextLeaf
-		it.currentIdx = 0
-		page, err = it.tree.pager.ReadPage(it.currentPage)
-		if err != nil {
-			return nil, nil, err
+		if err := it.load(next, 0); err != nil {
+			it.err = err
+			return false
+		}
+		if it.reverse {
+			it.idx = it.page.Header.NumCells
+		}
+	}
+	return true
+}
+
+func (it *Iterator) HasNext() bool {
+	if !it.settle() {
+		return false
+	}
+	if it.stop == nil {
+		return true
+	}
+
+	key, err := it.page.GetCellKey(it.current())
+	if err != nil {
+		it.err = err
+		return false
+	}
+	if it.reverse {
+		return key.Compare(it.stop) >= 0
+	}
+	return key.Compare(it.stop) <= 0
+}
+
+func (it *Iterator) Next() (Key, []byte, error) {
+	if !it.HasNext() {
+		if it.err != nil {
+			return nil, nil, it.err
 		}
+		return nil, nil, errors.New("no more entries")
 	}
 
-	cell, err := page.GetLeafCell(it.currentIdx)
+	cell, err := it.page.GetLeafCell(it.current())
 	if err != nil {
 		return nil, nil, err
 	}
 
-	it.currentIdx++
+	if it.reverse {
+		it.idx--
+	} else {
+		it.idx++
+	}
 	return cell.Key, cell.Value, nil
 }
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
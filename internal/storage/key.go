@@ -10,10 +10,11 @@ import (
 type KeyType byte
 
 const (
-	KeyTypeInt     KeyType = 0x01
-	KeyTypeText    KeyType = 0x02
-	KeyTypeFloat   KeyType = 0x03
-	KeyTypeBoolean KeyType = 0x04
+	KeyTypeInt       KeyType = 0x01
+	KeyTypeText      KeyType = 0x02
+	KeyTypeFloat     KeyType = 0x03
+	KeyTypeBoolean   KeyType = 0x04
+	KeyTypeComposite KeyType = 0x05
 )
 
 type Key interface {
@@ -52,10 +53,12 @@ func (k *IntKey) Compare(other Key) int {
 	return 0
 }
 
+// Encode writes the value big-endian with its sign bit flipped, so that
+// the bytes of negative values sort before those of positive ones.
 func (k *IntKey) Encode() []byte {
 	buf := make([]byte, 9)
 	buf[0] = byte(KeyTypeInt)
-	binary.BigEndian.PutUint64(buf[1:9], uint64(k.Value))
+	binary.BigEndian.PutUint64(buf[1:9], uint64(k.Value)^(1<<63))
 	return buf
 }
 
@@ -128,18 +131,50 @@ func (k *FloatKey) Compare(other Key) int {
 		return 1
 	}
 
-	if k.Value < otherFloat.Value {
+	return compareFloats(k.Value, otherFloat.Value)
+}
+
+// compareFloats orders floats by value, with NaN after every other value
+// and equal to any NaN, so that the order is total.
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
 		return -1
-	} else if k.Value > otherFloat.Value {
+	case a > b:
+		return 1
+	case a == b:
+		return 0
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
 		return 1
 	}
-	return 0
+	return -1
 }
 
+// canonicalNaN is the bits every NaN key is encoded as.
+const canonicalNaN = 0x7ff8000000000000
+
+// Encode writes the value's bits big-endian with the sign bit flipped or,
+// for a negative value, every bit, so that the bytes sort in the order of
+// the values. Keys that compare equal are written as the same bytes: -0 as
+// 0 and every NaN as canonicalNaN, which sorts last.
 func (k *FloatKey) Encode() []byte {
+	bits := math.Float64bits(k.Value)
+	switch {
+	case k.Value == 0:
+		bits = 0
+	case math.IsNaN(k.Value):
+		bits = canonicalNaN
+	}
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
 	buf := make([]byte, 9)
 	buf[0] = byte(KeyTypeFloat)
-	binary.BigEndian.PutUint64(buf[1:9], math.Float64bits(k.Value))
+	binary.BigEndian.PutUint64(buf[1:9], bits)
 	return buf
 }
 
@@ -208,7 +243,7 @@ func DecodeKey(data []byte) (Key, error) {
 		if len(data) < 9 {
 			return nil, errors.New("invalid int key data")
 		}
-		value := int64(binary.BigEndian.Uint64(data[1:9]))
+		value := int64(binary.BigEndian.Uint64(data[1:9]) ^ (1 << 63))
 		return NewIntKey(value), nil
 
 	case KeyTypeText:
@@ -227,6 +262,11 @@ func DecodeKey(data []byte) (Key, error) {
 			return nil, errors.New("invalid float key data")
 		}
 		bits := binary.BigEndian.Uint64(data[1:9])
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
 		value := math.Float64frombits(bits)
 		return NewFloatKey(value), nil
 
@@ -237,11 +277,40 @@ func DecodeKey(data []byte) (Key, error) {
 		value := data[1] != 0
 		return NewBooleanKey(value), nil
 
+	case KeyTypeComposite:
+		key, rest, err := decodeComposite(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) != 0 {
+			return nil, errors.New("composite key has trailing data")
+		}
+		return key, nil
+
 	default:
 		return nil, fmt.Errorf("unknown key type: %d", keyType)
 	}
 }
 
+// migrateKey rewrites an int or float key encoded before format version 3,
+// when they were stored as their raw bits, in the current encoding. It
+// reports whether it was one.
+func migrateKey(data []byte) bool {
+	if len(data) != 9 {
+		return false
+	}
+	bits := binary.BigEndian.Uint64(data[1:9])
+	switch KeyType(data[0]) {
+	case KeyTypeInt:
+		copy(data, NewIntKey(int64(bits)).Encode())
+	case KeyTypeFloat:
+		copy(data, NewFloatKey(math.Float64frombits(bits)).Encode())
+	default:
+		return false
+	}
+	return true
+}
+
 func KeysEqual(a, b Key) bool {
 	return a.Compare(b) == 0
 }
@@ -0,0 +1,971 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// lsmMemtableBytes is roughly how much the memtable holds before it is
+	// flushed to a new run.
+	lsmMemtableBytes = 64 * 1024
+
+	// lsmMergeWidth is how many runs of similar size are merged into one.
+	lsmMergeWidth = 4
+
+	// lsmMaxRuns caps the runs a lookup may have to search; past it every
+	// run is merged.
+	lsmMaxRuns = 12
+)
+
+// Every value in a run or the log starts with a tag saying whether the key
+// was written or deleted.
+const (
+	lsmTombstone byte = 0
+	lsmPut       byte = 1
+)
+
+// lsmLogKey is the manifest entry holding the log's root page. Runs are
+// stored under keys from 1 up, a newer run under a larger key.
+var lsmLogKey = NewIntKey(0)
+
+// LSMTree is a log-structured merge tree. Writes go to an in-memory
+// memtable, and to an append-only log so they survive a restart; a full
+// memtable is written out as a sorted run, and runs of similar size are
+// merged in the background of later writes. A lookup checks the memtable,
+// then the runs from newest to oldest.
+//
+// The tree lives in the pager's file: the manifest, a B-tree at the root
+// page, lists the log and the runs, each of which is a B-tree too.
+type LSMTree struct {
+	pager    *Pager
+	root     uint32
+	manifest *BTree
+
+	log    *BTree
+	logSeq int64
+
+	// mem holds the writes since the last flush, sorted by key.
+	mem      []lsmEntry
+	memBytes int
+
+	// runs are ordered newest first.
+	runs []lsmRun
+
+	// stale is set when the file may have changed under the tree, which
+	// then reloads before its next operation.
+	stale bool
+}
+
+type lsmEntry struct {
+	key     Key
+	value   []byte
+	deleted bool
+}
+
+type lsmRun struct {
+	id      int64
+	tree    *BTree
+	entries int64
+}
+
+// NewLSMTree allocates an empty tree.
+func NewLSMTree(pager *Pager) (*LSMTree, error) {
+	manifest, err := NewBTree(pager, false)
+	if err != nil {
+		return nil, err
+	}
+	log, err := NewBTree(pager, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := manifest.Insert(lsmLogKey, encodePageNum(log.root)); err != nil {
+		return nil, fmt.Errorf("failed to write LSM manifest: %w", err)
+	}
+
+	return &LSMTree{pager: pager, root: manifest.root, manifest: manifest, log: log}, nil
+}
+
+// LoadLSMTree opens the tree whose manifest is at rootPage, replaying its
+// log into the memtable.
+func LoadLSMTree(pager *Pager, rootPage uint32) (*LSMTree, error) {
+	tree := &LSMTree{pager: pager, root: rootPage}
+	if err := tree.load(); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func (t *LSMTree) load() error {
+	manifest, err := LoadBTree(t.pager, t.root, false)
+	if err != nil {
+		return fmt.Errorf("failed to load LSM manifest: %w", err)
+	}
+	entries, err := manifest.Scan()
+	if err != nil {
+		return fmt.Errorf("failed to read LSM manifest: %w", err)
+	}
+
+	t.manifest, t.log, t.runs = manifest, nil, nil
+	for _, entry := range entries {
+		id, ok := entry.Key.(*IntKey)
+		if !ok {
+			return fmt.Errorf("LSM manifest at page %d has a key of the wrong type", t.root)
+		}
+
+		if id.Value == 0 {
+			if len(entry.Value) < 4 {
+				return errors.New("LSM manifest has a truncated log entry")
+			}
+			if t.log, err = LoadBTree(t.pager, binary.BigEndian.Uint32(entry.Value), false); err != nil {
+				return fmt.Errorf("failed to load LSM log: %w", err)
+			}
+			continue
+		}
+
+		if len(entry.Value) < 12 {
+			return fmt.Errorf("LSM manifest has a truncated entry for run %d", id.Value)
+		}
+		run, err := LoadBTree(t.pager, binary.BigEndian.Uint32(entry.Value), false)
+		if err != nil {
+			return fmt.Errorf("failed to load LSM run %d: %w", id.Value, err)
+		}
+		t.runs = append([]lsmRun{{
+			id:      id.Value,
+			tree:    run,
+			entries: int64(binary.BigEndian.Uint64(entry.Value[4:12])),
+		}}, t.runs...)
+	}
+	if t.log == nil {
+		return fmt.Errorf("LSM manifest at page %d has no log", t.root)
+	}
+
+	return t.replayLog()
+}
+
+// replayLog rebuilds the memtable from the log.
+func (t *LSMTree) replayLog() error {
+	t.mem, t.memBytes, t.logSeq = nil, 0, 0
+
+	records, err := t.log.Scan()
+	if err != nil {
+		return fmt.Errorf("failed to read LSM log: %w", err)
+	}
+	for _, record := range records {
+		seq, ok := record.Key.(*IntKey)
+		if !ok {
+			return errors.New("LSM log has a key of the wrong type")
+		}
+		entry, err := decodeLogRecord(record.Value)
+		if err != nil {
+			return fmt.Errorf("LSM log record %d: %w", seq.Value, err)
+		}
+		t.setMem(entry)
+		t.logSeq = seq.Value
+	}
+	return nil
+}
+
+// Invalidate makes the tree reload from the file before its next
+// operation, for when pages were rolled back or written by someone else.
+func (t *LSMTree) Invalidate() {
+	t.stale = true
+}
+
+func (t *LSMTree) refresh() error {
+	if !t.stale {
+		return nil
+	}
+	if err := t.load(); err != nil {
+		return err
+	}
+	t.stale = false
+	return nil
+}
+
+func (t *LSMTree) Search(key Key) ([]byte, error) {
+	if err := t.refresh(); err != nil {
+		return nil, err
+	}
+	value, found, err := t.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+// get looks key up in the memtable, then in each run from the newest.
+func (t *LSMTree) get(key Key) ([]byte, bool, error) {
+	if i, ok := t.memIndex(key); ok {
+		entry := t.mem[i]
+		return entry.value, !entry.deleted, nil
+	}
+
+	for _, run := range t.runs {
+		data, err := run.tree.Search(key)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		value, deleted, err := untag(data)
+		if err != nil {
+			return nil, false, fmt.Errorf("LSM run %d: %w", run.id, err)
+		}
+		return value, !deleted, nil
+	}
+	return nil, false, nil
+}
+
+func (t *LSMTree) Insert(key Key, value []byte) error {
+	if err := t.refresh(); err != nil {
+		return err
+	}
+	if _, found, err := t.get(key); err != nil {
+		return err
+	} else if found {
+		return ErrDuplicateKey
+	}
+	return t.put(lsmEntry{key: key, value: value})
+}
+
+func (t *LSMTree) Update(key Key, value []byte) error {
+	if err := t.refresh(); err != nil {
+		return err
+	}
+	if _, found, err := t.get(key); err != nil {
+		return err
+	} else if !found {
+		return ErrKeyNotFound
+	}
+	return t.put(lsmEntry{key: key, value: value})
+}
+
+func (t *LSMTree) Delete(key Key) error {
+	if err := t.refresh(); err != nil {
+		return err
+	}
+	if _, found, err := t.get(key); err != nil {
+		return err
+	} else if !found {
+		return ErrKeyNotFound
+	}
+	return t.put(lsmEntry{key: key, deleted: true})
+}
+
+// put logs a write and applies it to the memtable, flushing the memtable
+// once it is full.
+func (t *LSMTree) put(entry lsmEntry) error {
+	seq := t.logSeq + 1
+	if err := t.log.Insert(NewIntKey(seq), encodeLogRecord(entry)); err != nil {
+		return fmt.Errorf("failed to append to LSM log: %w", err)
+	}
+	t.logSeq = seq
+
+	t.setMem(entry)
+	if t.memBytes >= lsmMemtableBytes {
+		return t.flush()
+	}
+	return nil
+}
+
+func (t *LSMTree) memIndex(key Key) (int, bool) {
+	i := sort.Search(len(t.mem), func(i int) bool {
+		return t.mem[i].key.Compare(key) >= 0
+	})
+	return i, i < len(t.mem) && t.mem[i].key.Compare(key) == 0
+}
+
+func (t *LSMTree) setMem(entry lsmEntry) {
+	i, found := t.memIndex(entry.key)
+	if found {
+		t.memBytes -= len(t.mem[i].value)
+		t.mem[i] = entry
+		t.memBytes += len(entry.value)
+		return
+	}
+
+	t.mem = append(t.mem, lsmEntry{})
+	copy(t.mem[i+1:], t.mem[i:])
+	t.mem[i] = entry
+	t.memBytes += len(entry.key.Encode()) + len(entry.value) + 1
+}
+
+// flush writes the memtable out as the newest run and starts a new log.
+func (t *LSMTree) flush() error {
+	if len(t.mem) == 0 {
+		return nil
+	}
+
+	entries := make([]Entry, len(t.mem))
+	for i, entry := range t.mem {
+		entries[i] = Entry{Key: entry.key, Value: tag(entry)}
+	}
+	run, err := t.writeRun(t.nextRunID(), entries)
+	if err != nil {
+		return err
+	}
+	if err := t.manifest.Insert(NewIntKey(run.id), encodeRun(run)); err != nil {
+		return fmt.Errorf("failed to record LSM run: %w", err)
+	}
+
+	log, err := NewBTree(t.pager, false)
+	if err != nil {
+		return err
+	}
+	if err := t.manifest.Update(lsmLogKey, encodePageNum(log.root)); err != nil {
+		return fmt.Errorf("failed to record LSM log: %w", err)
+	}
+	// Nothing reads the log but load, so its pages can go at once.
+	if err := t.log.Free(); err != nil {
+		return fmt.Errorf("failed to free LSM log: %w", err)
+	}
+
+	t.log, t.logSeq = log, 0
+	t.mem, t.memBytes = nil, 0
+	t.runs = append([]lsmRun{run}, t.runs...)
+	return t.maybeMerge()
+}
+
+func (t *LSMTree) nextRunID() int64 {
+	if len(t.runs) == 0 {
+		return 1
+	}
+	return t.runs[0].id + 1
+}
+
+func (t *LSMTree) writeRun(id int64, entries []Entry) (lsmRun, error) {
+	tree, err := NewBTree(t.pager, false)
+	if err != nil {
+		return lsmRun{}, err
+	}
+	if err := tree.BulkLoad(entries); err != nil {
+		return lsmRun{}, fmt.Errorf("failed to write LSM run: %w", err)
+	}
+	return lsmRun{id: id, tree: tree, entries: int64(len(entries))}, nil
+}
+
+// maybeMerge merges the newest runs once enough of them are of similar
+// size: each run joins the merge if it is no larger than the newer runs
+// before it put together. Merging by size keeps the number of runs
+// logarithmic in the size of the table while each entry is rewritten only
+// a few times.
+func (t *LSMTree) maybeMerge() error {
+	if len(t.runs) > lsmMaxRuns {
+		return t.merge(len(t.runs))
+	}
+
+	n, total := 1, t.runs[0].entries
+	for n < len(t.runs) && t.runs[n].entries <= total {
+		total += t.runs[n].entries
+		n++
+	}
+	if n < lsmMergeWidth {
+		return nil
+	}
+	return t.merge(n)
+}
+
+// merge replaces the n newest runs with one. Deletions are dropped when the
+// oldest run takes part, since there is nothing older left for them to hide.
+func (t *LSMTree) merge(n int) error {
+	merged := t.runs[:n]
+	keepTombstones := n < len(t.runs)
+
+	sources := make([]EntryIterator, n)
+	for i, run := range merged {
+		it, err := run.tree.NewIterator()
+		if err != nil {
+			return err
+		}
+		sources[i] = it
+	}
+	it := newLSMIterator(sources, false, true, keepTombstones)
+
+	var entries []Entry
+	for it.HasNext() {
+		key, value, err := it.Next()
+		if err != nil {
+			return fmt.Errorf("failed to merge LSM runs: %w", err)
+		}
+		entries = append(entries, Entry{Key: key, Value: value})
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("failed to merge LSM runs: %w", err)
+	}
+
+	for _, run := range merged[1:] {
+		if err := t.manifest.Delete(NewIntKey(run.id)); err != nil {
+			return fmt.Errorf("failed to remove LSM run %d: %w", run.id, err)
+		}
+	}
+
+	var runs []lsmRun
+	if len(entries) == 0 {
+		if err := t.manifest.Delete(NewIntKey(merged[0].id)); err != nil {
+			return fmt.Errorf("failed to remove LSM run %d: %w", merged[0].id, err)
+		}
+	} else {
+		run, err := t.writeRun(merged[0].id, entries)
+		if err != nil {
+			return err
+		}
+		if err := t.manifest.Update(NewIntKey(run.id), encodeRun(run)); err != nil {
+			return fmt.Errorf("failed to record LSM run: %w", err)
+		}
+		runs = []lsmRun{run}
+	}
+	t.runs = append(runs, t.runs[n:]...)
+
+	// Like the log's, the merged runs' pages can go once the manifest no
+	// longer lists them.
+	for _, run := range merged {
+		if err := run.tree.Free(); err != nil {
+			return fmt.Errorf("failed to free LSM run %d: %w", run.id, err)
+		}
+	}
+	return nil
+}
+
+// Compact flushes the memtable and merges every run into one, dropping
+// deleted rows, so lookups search a single run.
+func (t *LSMTree) Compact() error {
+	if err := t.refresh(); err != nil {
+		return err
+	}
+	if err := t.flush(); err != nil {
+		return err
+	}
+	if len(t.runs) == 0 {
+		return nil
+	}
+	return t.merge(len(t.runs))
+}
+
+// Runs returns the number of runs a lookup missing the memtable searches.
+func (t *LSMTree) Runs() (int, error) {
+	if err := t.refresh(); err != nil {
+		return 0, err
+	}
+	return len(t.runs), nil
+}
+
+// iterator merges the memtable and every run over the keys k with
+// start <= k <= end; a nil bound leaves that side open.
+func (t *LSMTree) iterator(start, end Key, reverse bool) (*lsmIterator, error) {
+	if err := t.refresh(); err != nil {
+		return nil, err
+	}
+
+	sources := []EntryIterator{t.memIterator(start, end, reverse)}
+	for _, run := range t.runs {
+		var it *Iterator
+		var err error
+		if reverse {
+			it, err = run.tree.NewReverseRangeIterator(start, end)
+		} else {
+			it, err = run.tree.NewRangeIterator(start, end)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, it)
+	}
+	return newLSMIterator(sources, reverse, false, false), nil
+}
+
+// memIterator walks a copy of the memtable's entries within the bounds, so
+// writes made while iterating do not disturb it.
+func (t *LSMTree) memIterator(start, end Key, reverse bool) *memIterator {
+	lo, hi := 0, len(t.mem)
+	if start != nil {
+		lo, _ = t.memIndex(start)
+	}
+	if end != nil {
+		hi = sort.Search(len(t.mem), func(i int) bool {
+			return t.mem[i].key.Compare(end) > 0
+		})
+	}
+	if hi < lo {
+		hi = lo
+	}
+
+	entries := make([]Entry, hi-lo)
+	for i, entry := range t.mem[lo:hi] {
+		entries[i] = Entry{Key: entry.key, Value: tag(entry)}
+	}
+	if reverse {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	return &memIterator{entries: entries}
+}
+
+func (t *LSMTree) Entries(reverse bool) (EntryIterator, error) {
+	return t.iterator(nil, nil, reverse)
+}
+
+func (t *LSMTree) EntriesFrom(start Key) (EntryIterator, error) {
+	return t.iterator(start, nil, false)
+}
+
+func (t *LSMTree) Scan() ([]Entry, error) {
+	return t.RangeSearch(nil, nil)
+}
+
+func (t *LSMTree) RangeSearch(start, end Key) ([]Entry, error) {
+	it, err := t.iterator(start, end, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Entry
+	for it.HasNext() {
+		key, value, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("range search failed: %w", err)
+		}
+		result = append(result, Entry{Key: key, Value: value})
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("range search failed: %w", err)
+	}
+	return result, nil
+}
+
+// LastKey returns the largest key in the tree, or nil if the tree is empty.
+func (t *LSMTree) LastKey() (Key, error) {
+	it, err := t.iterator(nil, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if !it.HasNext() {
+		return nil, it.Err()
+	}
+	key, _, err := it.Next()
+	return key, err
+}
+
+// Count merges every run to skip deleted and overwritten keys, so it reads
+// the whole tree.
+func (t *LSMTree) Count() (int, error) {
+	it, err := t.iterator(nil, nil, false)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for it.HasNext() {
+		if _, _, err := it.Next(); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, it.Err()
+}
+
+// BulkLoad writes entries, which must be sorted by key with no duplicates,
+// straight into a new run. The tree must hold no rows, though it may still
+// remember deleted ones.
+func (t *LSMTree) BulkLoad(entries []Entry) error {
+	it, err := t.iterator(nil, nil, false)
+	if err != nil {
+		return err
+	}
+	if it.HasNext() {
+		return errors.New("bulk load needs an empty tree")
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if err := t.flush(); err != nil {
+		return err
+	}
+
+	tagged := make([]Entry, len(entries))
+	for i, entry := range entries {
+		tagged[i] = Entry{Key: entry.Key, Value: tag(lsmEntry{value: entry.Value})}
+	}
+	run, err := t.writeRun(t.nextRunID(), tagged)
+	if err != nil {
+		return err
+	}
+	if err := t.manifest.Insert(NewIntKey(run.id), encodeRun(run)); err != nil {
+		return fmt.Errorf("failed to record LSM run: %w", err)
+	}
+	t.runs = append([]lsmRun{run}, t.runs...)
+	return nil
+}
+
+// Validate checks the manifest, the log and every run.
+func (t *LSMTree) Validate() error {
+	if err := t.refresh(); err != nil {
+		return err
+	}
+	if err := t.manifest.Validate(); err != nil {
+		return fmt.Errorf("LSM manifest: %w", err)
+	}
+	if err := t.log.Validate(); err != nil {
+		return fmt.Errorf("LSM log: %w", err)
+	}
+	for _, run := range t.runs {
+		if err := run.tree.Validate(); err != nil {
+			return fmt.Errorf("LSM run %d: %w", run.id, err)
+		}
+	}
+	return nil
+}
+
+// Stats adds up the pages of the manifest, the log and every run. Entries
+// counts what is stored, including overwritten and deleted keys.
+func (t *LSMTree) Stats() (TreeStats, error) {
+	if err := t.refresh(); err != nil {
+		return TreeStats{}, err
+	}
+
+	trees := []*BTree{t.manifest, t.log}
+	for _, run := range t.runs {
+		trees = append(trees, run.tree)
+	}
+
+	total := TreeStats{RootPage: t.root}
+	for i, tree := range trees {
+		stats, err := tree.Stats()
+		if err != nil {
+			return TreeStats{}, err
+		}
+		if stats.Depth > total.Depth {
+			total.Depth = stats.Depth
+		}
+		total.InteriorPages += stats.InteriorPages
+		total.LeafPages += stats.LeafPages
+		if i > 0 {
+			total.Entries += stats.Entries
+		}
+		total.PayloadBytes += stats.PayloadBytes
+		total.UnusedBytes += stats.UnusedBytes
+		total.FragmentedBytes += stats.FragmentedBytes
+		total.OutOfOrderLeaves += stats.OutOfOrderLeaves
+	}
+	return total, nil
+}
+
+// Free puts the pages of the manifest, the log and every run on the
+// freelist. The tree must not be used afterwards.
+func (t *LSMTree) Free() error {
+	if err := t.refresh(); err != nil {
+		return err
+	}
+	trees := []*BTree{t.log, t.manifest}
+	for _, run := range t.runs {
+		trees = append(trees, run.tree)
+	}
+	for _, tree := range trees {
+		if err := tree.Free(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Roots lists the root pages of the manifest, the log and every run.
+func (t *LSMTree) Roots() ([]uint32, error) {
+	if err := t.refresh(); err != nil {
+		return nil, err
+	}
+	roots := []uint32{t.root, t.log.root}
+	for _, run := range t.runs {
+		roots = append(roots, run.tree.root)
+	}
+	return roots, nil
+}
+
+// RootsMoved follows roots moved by IncrementalVacuum: the manifest's own,
+// and those of the log and runs, which the manifest records. The tree
+// reloads before its next operation.
+func (t *LSMTree) RootsMoved(moved map[uint32]uint32) error {
+	if to, ok := moved[t.root]; ok {
+		t.root = to
+	}
+	manifest, err := LoadBTree(t.pager, t.root, false)
+	if err != nil {
+		return fmt.Errorf("failed to load LSM manifest: %w", err)
+	}
+	entries, err := manifest.Scan()
+	if err != nil {
+		return fmt.Errorf("failed to read LSM manifest: %w", err)
+	}
+	for _, entry := range entries {
+		if len(entry.Value) < 4 {
+			continue
+		}
+		to, ok := moved[binary.BigEndian.Uint32(entry.Value)]
+		if !ok {
+			continue
+		}
+		value := append([]byte(nil), entry.Value...)
+		binary.BigEndian.PutUint32(value, to)
+		if err := manifest.Update(entry.Key, value); err != nil {
+			return fmt.Errorf("failed to update LSM manifest: %w", err)
+		}
+	}
+	t.Invalidate()
+	return nil
+}
+
+func (t *LSMTree) GetRootPage() uint32 {
+	return t.root
+}
+
+// lsmIterator merges sources ordered newest first. Where several hold the
+// same key the newest wins, and deleted keys are skipped unless
+// tombstones is set. With raw set, values keep their tag.
+type lsmIterator struct {
+	sources    []EntryIterator
+	heads      []*Entry
+	reverse    bool
+	raw        bool
+	tombstones bool
+
+	next  *Entry
+	ready bool
+	err   error
+}
+
+func newLSMIterator(sources []EntryIterator, reverse, raw, tombstones bool) *lsmIterator {
+	it := &lsmIterator{
+		sources:    sources,
+		heads:      make([]*Entry, len(sources)),
+		reverse:    reverse,
+		raw:        raw,
+		tombstones: tombstones,
+	}
+	for i := range sources {
+		it.advance(i)
+	}
+	return it
+}
+
+func (it *lsmIterator) advance(i int) {
+	src := it.sources[i]
+	if !src.HasNext() {
+		it.heads[i] = nil
+		if err := src.Err(); err != nil && it.err == nil {
+			it.err = err
+		}
+		return
+	}
+
+	key, value, err := src.Next()
+	if err != nil {
+		it.heads[i] = nil
+		if it.err == nil {
+			it.err = err
+		}
+		return
+	}
+	it.heads[i] = &Entry{Key: key, Value: value}
+}
+
+// prepare finds the next entry to return.
+func (it *lsmIterator) prepare() {
+	it.next = nil
+	for it.err == nil {
+		best := -1
+		for i, head := range it.heads {
+			if head == nil {
+				continue
+			}
+			if best < 0 {
+				best = i
+				continue
+			}
+			cmp := head.Key.Compare(it.heads[best].Key)
+			if it.reverse {
+				cmp = -cmp
+			}
+			if cmp < 0 {
+				best = i
+			}
+		}
+		if best < 0 {
+			return
+		}
+
+		winner := *it.heads[best]
+		for i, head := range it.heads {
+			if head != nil && head.Key.Compare(winner.Key) == 0 {
+				it.advance(i)
+			}
+		}
+
+		value, deleted, err := untag(winner.Value)
+		if err != nil {
+			it.err = err
+			return
+		}
+		if deleted && !it.tombstones {
+			continue
+		}
+		if !it.raw {
+			winner.Value = value
+		}
+		it.next = &winner
+		return
+	}
+}
+
+func (it *lsmIterator) HasNext() bool {
+	if !it.ready {
+		it.prepare()
+		it.ready = true
+	}
+	return it.next != nil
+}
+
+func (it *lsmIterator) Next() (Key, []byte, error) {
+	if !it.HasNext() {
+		if it.err != nil {
+			return nil, nil, it.err
+		}
+		return nil, nil, errors.New("no more entries")
+	}
+	entry := it.next
+	it.ready = false
+	return entry.Key, entry.Value, nil
+}
+
+func (it *lsmIterator) Err() error {
+	return it.err
+}
+
+// memIterator walks a slice of entries.
+type memIterator struct {
+	entries []Entry
+}
+
+func (it *memIterator) HasNext() bool {
+	return len(it.entries) > 0
+}
+
+func (it *memIterator) Next() (Key, []byte, error) {
+	if len(it.entries) == 0 {
+		return nil, nil, errors.New("no more entries")
+	}
+	entry := it.entries[0]
+	it.entries = it.entries[1:]
+	return entry.Key, entry.Value, nil
+}
+
+func (it *memIterator) Err() error {
+	return nil
+}
+
+func tag(entry lsmEntry) []byte {
+	if entry.deleted {
+		return []byte{lsmTombstone}
+	}
+	data := make([]byte, 1+len(entry.value))
+	data[0] = lsmPut
+	copy(data[1:], entry.value)
+	return data
+}
+
+func untag(data []byte) (value []byte, deleted bool, err error) {
+	if len(data) == 0 {
+		return nil, false, errors.New("LSM entry has no tag")
+	}
+	switch data[0] {
+	case lsmPut:
+		return data[1:], false, nil
+	case lsmTombstone:
+		return nil, true, nil
+	default:
+		return nil, false, fmt.Errorf("LSM entry has an unknown tag %d", data[0])
+	}
+}
+
+// A log record is the tagged value with the encoded key in front of it,
+// preceded by its length.
+func encodeLogRecord(entry lsmEntry) []byte {
+	key := entry.key.Encode()
+	data := binary.AppendUvarint(nil, uint64(len(key)))
+	data = append(data, key...)
+	return append(data, tag(entry)...)
+}
+
+func decodeLogRecord(data []byte) (lsmEntry, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return lsmEntry{}, errors.New("truncated key")
+	}
+	key, err := DecodeKey(data[n : n+int(size)])
+	if err != nil {
+		return lsmEntry{}, err
+	}
+	value, deleted, err := untag(data[n+int(size):])
+	if err != nil {
+		return lsmEntry{}, err
+	}
+	return lsmEntry{key: key, value: append([]byte(nil), value...), deleted: deleted}, nil
+}
+
+// migrateLogKeys rewrites the keys in the log records of the LSM tree at
+// root, see Pager.MigrateKeys, whose pages must be migrated already.
+func migrateLogKeys(pager *Pager, root uint32) error {
+	manifest, err := LoadBTree(pager, root, false)
+	if err != nil {
+		return fmt.Errorf("failed to load LSM manifest: %w", err)
+	}
+	entry, err := manifest.Search(lsmLogKey)
+	if err != nil {
+		return fmt.Errorf("failed to read LSM manifest: %w", err)
+	}
+	if len(entry) < 4 {
+		return errors.New("LSM manifest has a truncated log entry")
+	}
+	log, err := LoadBTree(pager, binary.BigEndian.Uint32(entry), false)
+	if err != nil {
+		return fmt.Errorf("failed to load LSM log: %w", err)
+	}
+
+	records, err := log.Scan()
+	if err != nil {
+		return fmt.Errorf("failed to read LSM log: %w", err)
+	}
+	for _, record := range records {
+		size, n := binary.Uvarint(record.Value)
+		if n <= 0 || uint64(len(record.Value)-n) < size {
+			return errors.New("truncated key")
+		}
+		if !migrateKey(record.Value[n : n+int(size)]) {
+			continue
+		}
+		if err := log.Update(record.Key, record.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeRun(run lsmRun) []byte {
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data[0:4], run.tree.root)
+	binary.BigEndian.PutUint64(data[4:12], uint64(run.entries))
+	return data
+}
+
+func encodePageNum(pageNum uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, pageNum)
+}
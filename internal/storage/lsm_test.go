@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// TestLSMCompactionReusesPages rewrites the same rows and compacts over and
+// over, which must reuse the pages of the runs each merge replaces rather
+// than grow the file.
+func TestLSMCompactionReusesPages(t *testing.T) {
+	const rows = 500
+	pager := newTestPager(t)
+	tree, err := NewLSMTree(pager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pages uint32
+	for round := 0; round < 20; round++ {
+		value := bytes.Repeat([]byte(fmt.Sprint(round%10)), 50)
+		for i := 0; i < rows; i++ {
+			key := NewIntKey(int64(i))
+			if round == 0 {
+				err = tree.Insert(key, value)
+			} else {
+				err = tree.Update(key, value)
+			}
+			if err != nil {
+				t.Fatalf("round %d: write %d: %v", round, i, err)
+			}
+		}
+		if err := tree.Compact(); err != nil {
+			t.Fatalf("round %d: compact: %v", round, err)
+		}
+		if round == 4 {
+			pages = pager.GetNumPages()
+		} else if round > 4 && pager.GetNumPages() > pages {
+			t.Fatalf("round %d: file grew from %d to %d pages", round, pages, pager.GetNumPages())
+		}
+	}
+
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := tree.Count(); err != nil || n != rows {
+		t.Fatalf("count = %d, %v; want %d", n, err, rows)
+	}
+}
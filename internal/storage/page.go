@@ -16,10 +16,11 @@ const (
 	PageTypeFreelistLeaf  PageType = 0x03
 	PageTypeOverflow      PageType = 0x00
 	PageTypePointerMap    PageType = 0x04
-)
 
-const (
-	MinFreeSpaceThreshold = 64
+	// Index pages of these types may store a prefix shared by their keys
+	// once instead of in every cell; see prefix.go.
+	PageTypeInteriorIndexPrefix PageType = 0x0B
+	PageTypeLeafIndexPrefix     PageType = 0x0E
 )
 
 type PageHeader struct {
@@ -34,6 +35,10 @@ type PageHeader struct {
 	ParentPage uint32
 	NextLeaf   uint32
 	PrevLeaf   uint32
+
+	// PrefixLen is the length of the key prefix stored after the header of
+	// a page whose type allows one.
+	PrefixLen uint16
 }
 
 type Page struct {
@@ -67,19 +72,25 @@ func NewPage(pageType PageType, pageNum uint32) (*Page, error) {
 }
 
 func isLeaf(t PageType) bool {
-	return t == PageTypeLeafTable || t == PageTypeLeafIndex
+	return t == PageTypeLeafTable || t == PageTypeLeafIndex || t == PageTypeLeafIndexPrefix
 }
 
 func isInterior(t PageType) bool {
-	return t == PageTypeInteriorTable || t == PageTypeInteriorIndex
+	return t == PageTypeInteriorTable || t == PageTypeInteriorIndex || t == PageTypeInteriorIndexPrefix
 }
 
+// GetHeaderSize is where the cell pointer array starts. On a page with a
+// key prefix, that is after the prefix.
 func (p *Page) GetHeaderSize() int {
 	switch p.Header.PageType {
 	case PageTypeInteriorTable, PageTypeInteriorIndex:
 		return 16
 	case PageTypeLeafTable, PageTypeLeafIndex:
 		return 20
+	case PageTypeInteriorIndexPrefix:
+		return 18 + int(p.Header.PrefixLen)
+	case PageTypeLeafIndexPrefix:
+		return 22 + int(p.Header.PrefixLen)
 	default:
 		return 8
 	}
@@ -102,6 +113,13 @@ func (p *Page) writeHeader() {
 		binary.BigEndian.PutUint32(p.Data[12:16], h.NextLeaf)
 		binary.BigEndian.PutUint32(p.Data[16:20], h.PrevLeaf)
 	}
+
+	switch h.PageType {
+	case PageTypeInteriorIndexPrefix:
+		binary.BigEndian.PutUint16(p.Data[16:18], h.PrefixLen)
+	case PageTypeLeafIndexPrefix:
+		binary.BigEndian.PutUint16(p.Data[20:22], h.PrefixLen)
+	}
 }
 
 func (p *Page) readHeader() error {
@@ -130,6 +148,14 @@ func (p *Page) readHeader() error {
 		p.Header.PrevLeaf = binary.BigEndian.Uint32(p.Data[16:20])
 	}
 
+	p.Header.PrefixLen = 0
+	switch p.Header.PageType {
+	case PageTypeInteriorIndexPrefix:
+		p.Header.PrefixLen = binary.BigEndian.Uint16(p.Data[16:18])
+	case PageTypeLeafIndexPrefix:
+		p.Header.PrefixLen = binary.BigEndian.Uint16(p.Data[20:22])
+	}
+
 	if err := p.validateHeader(); err != nil {
 		return fmt.Errorf("invalid page header: %w", err)
 	}
@@ -138,6 +164,9 @@ func (p *Page) readHeader() error {
 }
 
 func (p *Page) validateHeader() error {
+	if p.GetHeaderSize() > PageSize {
+		return fmt.Errorf("invalid PrefixLen: %d", p.Header.PrefixLen)
+	}
 	headerSize := uint16(p.GetHeaderSize())
 
 	if p.Header.CellContentOffset < headerSize || p.Header.CellContentOffset > uint16(PageSize) {
@@ -200,8 +229,10 @@ func (p *Page) GetFreeSpace() uint16 {
 	return freeSpace
 }
 
+// GetTotalFreeSpace includes freeblocks and fragmented bytes, which are only
+// usable after defragmenting.
 func (p *Page) GetTotalFreeSpace() uint16 {
-	return p.GetFreeSpace() + uint16(p.Header.FragmentedBytes)
+	return p.GetFreeSpace() + p.freeblockBytes() + uint16(p.Header.FragmentedBytes)
 }
 
 func (p *Page) CanFit(cellSize uint32) bool {
@@ -220,11 +251,27 @@ func (p *Page) CanFit(cellSize uint32) bool {
 }
 
 func (p *Page) InsertLeafCell(cell *LeafCell) error {
-	return p.insertCell(cell.Key, cell.Serialize())
+	stored, err := p.storedKey(cell.Key)
+	if err != nil {
+		return err
+	}
+	buf := cellBuffers.Get().(*[]byte)
+	*buf = (&LeafCell{Key: stored, Value: cell.Value}).appendTo((*buf)[:0])
+	err = p.insertCell(cell.Key, *buf)
+	cellBuffers.Put(buf)
+	return err
 }
 
 func (p *Page) InsertInteriorCell(cell *InteriorCell) error {
-	return p.insertCell(cell.Key, cell.Serialize())
+	stored, err := p.storedKey(cell.Key)
+	if err != nil {
+		return err
+	}
+	buf := cellBuffers.Get().(*[]byte)
+	*buf = (&InteriorCell{Key: stored, ChildPage: cell.ChildPage}).appendTo((*buf)[:0])
+	err = p.insertCell(cell.Key, *buf)
+	cellBuffers.Put(buf)
+	return err
 }
 
 func (p *Page) insertCell(key Key, data []byte) error {
@@ -234,27 +281,33 @@ func (p *Page) insertCell(key Key, data []byte) error {
 		return errors.New("not enough space")
 	}
 
-	if p.GetFreeSpace() < uint16(cellSize)+2 && p.GetTotalFreeSpace() >= uint16(cellSize)+2 {
-		if err := p.Defragment(); err != nil {
-			return fmt.Errorf("defragmentation failed: %w", err)
-		}
+	// The new cell pointer always comes out of the unallocated gap. The cell
+	// itself goes into a freeblock if one fits, so the gap is left for
+	// pointers, and otherwise at the start of the content area.
+	size := uint16(cellSize)
+	var offset uint16
+	found := false
+	if p.GetFreeSpace() >= 2 {
+		offset, found = p.freeblockFor(size)
 	}
-
-	if p.GetFreeSpace() < uint16(cellSize)+2 {
-		return errors.New("not enough space after defragmentation")
+	if !found && p.GetFreeSpace() >= size+2 {
+		offset = p.Header.CellContentOffset - size
+		p.Header.CellContentOffset = offset
+		found = true
 	}
 
-	newOffset := p.Header.CellContentOffset - uint16(cellSize)
-	headerSize := uint16(p.GetHeaderSize())
-	ptrArrayEnd := headerSize + (p.Header.NumCells+1)*2
-
-	if newOffset < ptrArrayEnd {
-		return errors.New("cell content would overlap with pointer array")
+	if !found {
+		if err := p.Defragment(); err != nil {
+			return fmt.Errorf("defragmentation failed: %w", err)
+		}
+		if p.GetFreeSpace() < size+2 {
+			return errors.New("not enough space after defragmentation")
+		}
+		offset = p.Header.CellContentOffset - size
+		p.Header.CellContentOffset = offset
 	}
 
 	pos := p.findInsertPosition(key)
-	p.Header.CellContentOffset = newOffset
-	offset := p.Header.CellContentOffset
 
 	if int(offset)+len(data) > len(p.Data) {
 		return errors.New("cell data exceeds page size")
@@ -327,17 +380,28 @@ func (p *Page) GetCellKey(cellNum uint16) (Key, error) {
 		return nil, err
 	}
 
-	if int(offset)+4 > len(p.Data) {
+	// Interior cells start with the 4-byte child pointer; leaf cells start
+	// directly with the key length.
+	start := int(offset)
+	if isInterior(p.Header.PageType) {
+		start += 4
+	}
+
+	if start+4 > len(p.Data) {
 		return nil, errors.New("key length field exceeds page size")
 	}
 
-	keyLen := binary.BigEndian.Uint32(p.Data[offset : offset+4])
+	keyLen := int(binary.BigEndian.Uint32(p.Data[start : start+4]))
 
-	if int(offset)+4+int(keyLen) > len(p.Data) {
+	if start+4+keyLen > len(p.Data) {
 		return nil, fmt.Errorf("key data exceeds page size (offset=%d, keyLen=%d)", offset, keyLen)
 	}
 
-	return DecodeKey(p.Data[offset+4 : offset+4+uint16(keyLen)])
+	key, err := DecodeKey(p.Data[start+4 : start+4+keyLen])
+	if err != nil {
+		return nil, err
+	}
+	return p.fullKey(key)
 }
 
 func (p *Page) GetLeafCell(cellNum uint16) (*LeafCell, error) {
@@ -350,7 +414,14 @@ func (p *Page) GetLeafCell(cellNum uint16) (*LeafCell, error) {
 		return nil, errors.New("cell offset exceeds page size")
 	}
 
-	return DeserializeLeafCell(p.Data[offset:])
+	cell, err := DeserializeLeafCell(p.Data[offset:])
+	if err != nil {
+		return nil, err
+	}
+	if cell.Key, err = p.fullKey(cell.Key); err != nil {
+		return nil, err
+	}
+	return cell, nil
 }
 
 func (p *Page) GetInteriorCell(cellNum uint16) (*InteriorCell, error) {
@@ -363,7 +434,14 @@ func (p *Page) GetInteriorCell(cellNum uint16) (*InteriorCell, error) {
 		return nil, errors.New("cell offset exceeds page size")
 	}
 
-	return DeserializeInteriorCell(p.Data[offset:])
+	cell, err := DeserializeInteriorCell(p.Data[offset:])
+	if err != nil {
+		return nil, err
+	}
+	if cell.Key, err = p.fullKey(cell.Key); err != nil {
+		return nil, err
+	}
+	return cell, nil
 }
 
 func (p *Page) SearchCell(key Key) (uint16, bool, error) {
@@ -442,32 +520,9 @@ func (p *Page) deleteCell(cellNum uint16) error {
 		return err
 	}
 
-	var cellSize uint16
-	if isLeaf(p.Header.PageType) {
-		cell, err := p.GetLeafCell(cellNum)
-		if err == nil {
-			cellSize = uint16(cell.Size())
-		}
-	} else {
-		cell, err := p.GetInteriorCell(cellNum)
-		if err == nil {
-			cellSize = uint16(cell.Size())
-		}
-	}
-
-	if cellSize > 0 && int(offset)+int(cellSize) <= len(p.Data) {
-		for i := uint16(0); i < cellSize; i++ {
-			p.Data[offset+i] = 0
-		}
-	}
-
-	if cellSize > 0 {
-		newFragmented := uint16(p.Header.FragmentedBytes) + cellSize
-		if newFragmented > 255 {
-			p.Header.FragmentedBytes = 255
-		} else {
-			p.Header.FragmentedBytes = byte(newFragmented)
-		}
+	cellSize, err := p.GetCellSize(cellNum)
+	if err != nil {
+		cellSize = 0
 	}
 
 	ptrs := p.GetCellPointerArrayOffset()
@@ -486,12 +541,14 @@ func (p *Page) deleteCell(cellNum uint16) error {
 	}
 
 	p.Header.NumCells--
-	p.writeHeader()
 
-	if p.Header.FragmentedBytes > MinFreeSpaceThreshold {
-		_ = p.Defragment()
+	if cellSize > 0 && int(offset)+int(cellSize) <= len(p.Data) {
+		if err := p.freeSpace(offset, cellSize); err != nil {
+			return fmt.Errorf("failed to free cell: %w", err)
+		}
 	}
 
+	p.writeHeader()
 	return nil
 }
 
@@ -499,6 +556,7 @@ func (p *Page) Defragment() error {
 	if p.Header.NumCells == 0 {
 
 		p.Header.CellContentOffset = uint16(PageSize)
+		p.Header.FirstFreeblock = 0
 		p.Header.FragmentedBytes = 0
 		p.writeHeader()
 		return nil
@@ -517,20 +575,11 @@ func (p *Page) Defragment() error {
 			return err
 		}
 
-		var cellData []byte
-		if isLeaf(p.Header.PageType) {
-			cell, err := p.GetLeafCell(i)
-			if err != nil {
-				return err
-			}
-			cellData = cell.Serialize()
-		} else {
-			cell, err := p.GetInteriorCell(i)
-			if err != nil {
-				return err
-			}
-			cellData = cell.Serialize()
+		size, err := p.GetCellSize(i)
+		if err != nil {
+			return err
 		}
+		cellData := append([]byte(nil), p.Data[offset:offset+size]...)
 
 		cells[i] = cellInfo{
 			offset: offset,
@@ -551,25 +600,44 @@ func (p *Page) Defragment() error {
 		}
 	}
 
+	for i := p.GetCellPointerArrayOffset() + int(p.Header.NumCells)*2; i < int(newContentOffset); i++ {
+		p.Data[i] = 0
+	}
+
 	p.Header.CellContentOffset = newContentOffset
+	p.Header.FirstFreeblock = 0
 	p.Header.FragmentedBytes = 0
 	p.writeHeader()
 
 	return nil
 }
 
+// GetCellSize is the number of bytes the cell takes in the page, which is
+// less than its Size when the page stores part of its key as the prefix.
 func (p *Page) GetCellSize(cellNum uint16) (uint16, error) {
+	offset, err := p.GetCellPointer(cellNum)
+	if err != nil {
+		return 0, err
+	}
+
+	// A leaf cell is its key and value, each after a 4-byte length; an
+	// interior cell is a 4-byte child pointer and its key.
+	end := int(offset)
+	if isInterior(p.Header.PageType) {
+		end += 4
+	}
+	fields := 1
 	if isLeaf(p.Header.PageType) {
-		cell, err := p.GetLeafCell(cellNum)
-		if err != nil {
-			return 0, err
-		}
-		return uint16(cell.Size()), nil
-	} else {
-		cell, err := p.GetInteriorCell(cellNum)
-		if err != nil {
-			return 0, err
+		fields = 2
+	}
+	for i := 0; i < fields; i++ {
+		if end+4 > len(p.Data) {
+			return 0, errors.New("cell exceeds page size")
 		}
-		return uint16(cell.Size()), nil
+		end += 4 + int(binary.BigEndian.Uint32(p.Data[end:end+4]))
+	}
+	if end > len(p.Data) {
+		return 0, errors.New("cell exceeds page size")
 	}
+	return uint16(end - int(offset)), nil
 }
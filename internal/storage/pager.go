@@ -3,53 +3,141 @@ package storage
 import (
 	"encoding/binary"
 	"errors"
-	"os"
+	"fmt"
+	"sync"
+
+	"github.com/kithinjibrian/anubisdb/internal/utils"
 )
 
 var (
 	dbMagicNumber = [8]byte{'A', 'n', 'u', 'b', 'i', 's', 'D', 'B'}
 )
 
+// pageBuffers recycles the buffers of pages handed back with ReleasePage,
+// so walking a tree does not leave a page-sized buffer behind for every
+// page it reads.
+var pageBuffers = sync.Pool{
+	New: func() interface{} { return new([PageSize]byte) },
+}
+
 type DatabaseHeader struct {
-	MagicNumber [8]byte
-	Version     uint32
-	Reserved    [PageSize - 12]byte
+	MagicNumber      [8]byte
+	Version          uint32
+	SchemaGeneration uint64
+	// CheckpointSegment is the first WAL segment whose changes may not be
+	// in the file yet; every earlier one is. 0 means the WAL was never used.
+	CheckpointSegment uint64
+	// BackupGeneration is the generation of the next backup, see backup.go.
+	// 0 means no backup was ever taken.
+	BackupGeneration uint64
+	// FreelistTrunk is the first trunk page of the freelist and FreePages
+	// the number of pages on it, trunks included; see freelist.go.
+	FreelistTrunk uint32
+	FreePages     uint32
+	// PointerMap is set when the file keeps a pointer map, see ptrmap.go.
+	PointerMap bool
+	// ChangeCounter goes up with every write outside WAL mode, so that
+	// another handle on the file knows to drop its cached pages, see
+	// Refresh.
+	ChangeCounter uint64
+	Reserved      [PageSize - 53]byte
 }
 
+// changeCounterOffset is where the header keeps ChangeCounter.
+const changeCounterOffset = 45
+
 type Pager struct {
-	file     *os.File
+	vfs      VFS
+	file     File
+	path     string
 	numPages uint32
 	header   DatabaseHeader
+	cache    *utils.LRUCache[uint32, []byte]
+	batch    *batch
+	clock    utils.Clock
+
+	// wal is set while the database is in WAL mode, see wal.go.
+	wal *wal
+
+	// changes records when each page last changed once a backup has been
+	// taken, see backup.go.
+	changes *pageMap
+
+	// reads counts the reads of each page while trackReads is set, see
+	// hot.go.
+	reads      map[uint32]uint64
+	trackReads bool
+
+	// readOnly is set for a handle that reads alongside the one writing the
+	// database; snap is what its statements read, see snapshot.go.
+	readOnly bool
+	snap     *snapshot
+	reading  int
+
+	closed bool
 }
 
 func NewPager(filename string) (*Pager, error) {
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	return OpenPager(OSFS, filename)
+}
+
+// OpenPager opens the database file filename in vfs, creating it if it does
+// not exist.
+func OpenPager(vfs VFS, filename string) (*Pager, error) {
+	return openPager(vfs, filename, MinFormatVersion)
+}
+
+// openPager opens filename, failing if its format version is older than
+// minVersion.
+func openPager(vfs VFS, filename string, minVersion uint32) (*Pager, error) {
+	file, err := vfs.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	stat, err := file.Stat()
+	size, err := file.Size()
 	if err != nil {
 		file.Close()
 		return nil, err
 	}
 
-	p := &Pager{file: file}
+	p := &Pager{
+		vfs:   vfs,
+		file:  file,
+		path:  filename,
+		cache: utils.NewLRUCache[uint32, []byte](MaxCachedPages),
+		clock: utils.SystemClock,
+	}
 
-	if stat.Size() == 0 {
+	if size == 0 {
 		p.header = DatabaseHeader{
 			MagicNumber: dbMagicNumber,
-			Version:     1,
+			Version:     FormatVersion,
 		}
 		if err := p.writeHeader(); err != nil {
 			file.Close()
 			return nil, err
 		}
+		// A list left by an earlier file of the same name is not this one's.
+		if err := removeHotPages(vfs, filename); err != nil {
+			file.Close()
+			return nil, err
+		}
 		p.numPages = 0
 	} else {
-		if stat.Size()%PageSize != 0 {
-			file.Close()
-			return nil, errors.New("corrupted database file: size not multiple of page size")
+		if size%PageSize != 0 {
+			// A crash part way through adding a page leaves part of it. In
+			// WAL mode the page is logged, so recovery writes it again.
+			inWAL, err := vfs.Exists(walDir(filename))
+			if err != nil || !inWAL {
+				file.Close()
+				return nil, errors.New("corrupted database file: size not multiple of page size")
+			}
+			size -= size % PageSize
+			if err := file.Truncate(size); err != nil {
+				file.Close()
+				return nil, err
+			}
 		}
 
 		if err := p.readHeader(); err != nil {
@@ -57,7 +145,7 @@ func NewPager(filename string) (*Pager, error) {
 			return nil, err
 		}
 
-		totalPages := uint32(stat.Size()) / PageSize
+		totalPages := uint32(size / PageSize)
 		if totalPages > 0 {
 			p.numPages = totalPages - 1
 		} else {
@@ -65,6 +153,19 @@ func NewPager(filename string) (*Pager, error) {
 		}
 	}
 
+	if err := p.recoverWAL(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if p.header.Version < minVersion {
+		p.Close()
+		return nil, p.checkMinFormatVersion()
+	}
+	if err := p.openPageMap(); err != nil {
+		p.Close()
+		return nil, err
+	}
+
 	return p, nil
 }
 
@@ -74,32 +175,110 @@ func (p *Pager) readHeader() error {
 	if err != nil {
 		return err
 	}
-
-	copy(p.header.MagicNumber[:], buf[0:8])
-	p.header.Version = binary.BigEndian.Uint32(buf[8:12])
-	copy(p.header.Reserved[:], buf[12:PageSize])
-
-	if p.header.MagicNumber != dbMagicNumber {
-		return errors.New("invalid database file: bad magic number")
+	header, err := decodeHeader(buf)
+	if err != nil {
+		return err
 	}
-
+	p.header = header
 	return nil
 }
 
+func decodeHeader(buf []byte) (DatabaseHeader, error) {
+	var header DatabaseHeader
+	copy(header.MagicNumber[:], buf[0:8])
+	header.Version = binary.BigEndian.Uint32(buf[8:12])
+	header.SchemaGeneration = binary.BigEndian.Uint64(buf[12:20])
+	header.CheckpointSegment = binary.BigEndian.Uint64(buf[20:28])
+	header.BackupGeneration = binary.BigEndian.Uint64(buf[28:36])
+	header.FreelistTrunk = binary.BigEndian.Uint32(buf[36:40])
+	header.FreePages = binary.BigEndian.Uint32(buf[40:44])
+	header.PointerMap = buf[44] != 0
+	header.ChangeCounter = binary.BigEndian.Uint64(buf[45:53])
+	copy(header.Reserved[:], buf[53:PageSize])
+
+	if header.MagicNumber != dbMagicNumber {
+		return header, errors.New("invalid database file: bad magic number")
+	}
+	return header, checkFormatVersion(header.Version)
+}
+
 func (p *Pager) writeHeader() error {
+	_, err := p.file.WriteAt(p.encodeHeader(), 0)
+	return err
+}
+
+func (p *Pager) encodeHeader() []byte {
 	buf := make([]byte, PageSize)
 	copy(buf[0:8], p.header.MagicNumber[:])
 	binary.BigEndian.PutUint32(buf[8:12], p.header.Version)
-	copy(buf[12:PageSize], p.header.Reserved[:])
-
-	_, err := p.file.WriteAt(buf, 0)
-	return err
+	binary.BigEndian.PutUint64(buf[12:20], p.header.SchemaGeneration)
+	binary.BigEndian.PutUint64(buf[20:28], p.header.CheckpointSegment)
+	binary.BigEndian.PutUint64(buf[28:36], p.header.BackupGeneration)
+	binary.BigEndian.PutUint32(buf[36:40], p.header.FreelistTrunk)
+	binary.BigEndian.PutUint32(buf[40:44], p.header.FreePages)
+	if p.header.PointerMap {
+		buf[44] = 1
+	}
+	binary.BigEndian.PutUint64(buf[45:53], p.header.ChangeCounter)
+	copy(buf[53:PageSize], p.header.Reserved[:])
+	return buf
 }
 
+// Close leaves the file complete on its own: in WAL mode it checkpoints, so
+// the next open has nothing to replay, and otherwise it syncs. It then
+// releases the handle's locks. A batch must not be running. Closing twice
+// does nothing.
 func (p *Pager) Close() error {
-	return p.file.Close()
+	if p.closed {
+		return nil
+	}
+	if p.batch != nil {
+		return ErrBatchActive
+	}
+	p.closed = true
+
+	var flushErr error
+	if p.wal != nil {
+		flushErr = p.checkpointOnClose()
+	} else if !p.readOnly {
+		flushErr = p.Sync()
+	}
+
+	var hotErr error
+	if flushErr == nil && p.trackReads {
+		hotErr = p.SaveHotPages()
+	}
+	if p.changes != nil {
+		if err := p.changes.save(p.header.BackupGeneration); err != nil {
+			fmt.Printf("Warning: failed to save page map: %v\n", err)
+		}
+		p.changes = nil
+	}
+	if p.wal != nil {
+		if err := p.wal.close(); err != nil {
+			p.file.Close()
+			return err
+		}
+		p.wal = nil
+	}
+	if p.snap != nil {
+		p.snap.close()
+		p.snap = nil
+	}
+	if err := p.file.Close(); err != nil {
+		return err
+	}
+	if hotErr != nil {
+		return fmt.Errorf("failed to save hot pages: %w", hotErr)
+	}
+	return flushErr
 }
 
+// ReadPage returns a copy of page pageNum that the caller owns: it may
+// change the page and pass it to WritePage, which copies it, and no other
+// reader sees those changes until then. The page's buffer comes from
+// pageBuffers; a caller done with it should hand it back with ReleasePage.
+// One that never does only leaves the buffer to the garbage collector.
 func (p *Pager) ReadPage(pageNum uint32) (*Page, error) {
 
 	if pageNum == 0 {
@@ -109,24 +288,44 @@ func (p *Pager) ReadPage(pageNum uint32) (*Page, error) {
 	if pageNum > p.numPages {
 		return nil, errors.New("page number out of range")
 	}
+	p.countRead(pageNum)
 
 	page := &Page{
-		Data: make([]byte, PageSize),
+		Data: pageBuffers.Get().(*[PageSize]byte)[:],
 	}
 
-	offset := int64(PageSize) * int64(pageNum)
-	_, err := p.file.ReadAt(page.Data, offset)
-	if err != nil {
-		return nil, err
+	if pending, ok := p.batchPage(pageNum); ok {
+		copy(page.Data, pending)
+	} else if cached, ok := p.cache.Get(pageNum); ok {
+		copy(page.Data, cached)
+	} else {
+		if err := p.readPageData(pageNum, page.Data); err != nil {
+			p.ReleasePage(page)
+			return nil, err
+		}
+		p.cachePage(pageNum, page.Data)
 	}
 
 	if err := page.readHeader(); err != nil {
+		p.ReleasePage(page)
 		return nil, err
 	}
 
 	return page, nil
 }
 
+// ReleasePage hands back the buffer of a page from ReadPage for the next
+// ReadPage to reuse. The caller must be done with the page and hold no
+// slice of its Data, which the next page read into the buffer overwrites.
+// The page must not be used afterwards, nor released twice.
+func (p *Pager) ReleasePage(page *Page) {
+	if page == nil || len(page.Data) != PageSize || cap(page.Data) != PageSize {
+		return
+	}
+	pageBuffers.Put((*[PageSize]byte)(page.Data))
+	page.Data = nil
+}
+
 func (p *Pager) WritePage(pageNum uint32, page *Page) error {
 
 	if pageNum == 0 {
@@ -137,33 +336,125 @@ func (p *Pager) WritePage(pageNum uint32, page *Page) error {
 		return errors.New("page number out of range")
 	}
 
+	if p.readOnly {
+		return ErrReadOnly
+	}
 	page.writeHeader()
 
-	offset := int64(PageSize) * int64(pageNum)
-	_, err := p.file.WriteAt(page.Data, offset)
+	if p.header.Version < FormatVersion {
+		if err := p.RaiseFormatVersion(); err != nil {
+			return err
+		}
+	}
+
+	if p.header.PointerMap && isInterior(page.Header.PageType) {
+		if err := p.updateChildren(pageNum, page); err != nil {
+			return err
+		}
+	}
+
+	if p.batch != nil {
+		p.writeBatchPage(pageNum, page.Data)
+		return nil
+	}
+
+	if err := p.logPages(p.numPages, []walPage{{pageNum, page.Data}}, false); err != nil {
+		return err
+	}
+
+	p.markChanged(pageNum)
+	if p.wal == nil {
+		offset := int64(PageSize) * int64(pageNum)
+		if _, err := p.file.WriteAt(page.Data, offset); err != nil {
+			p.cache.Delete(pageNum)
+			return err
+		}
+		if err := p.saveChangeCounter(); err != nil {
+			p.cache.Delete(pageNum)
+			return err
+		}
+	}
+
+	p.cachePage(pageNum, page.Data)
+	return p.checkpointIfFull()
+}
+
+// readPageData reads pageNum as of the last write: from the WAL if the
+// page changed since the checkpoint, otherwise from the file.
+func (p *Pager) readPageData(pageNum uint32, buf []byte) error {
+	if ok, err := p.readWALPage(pageNum, buf); ok || err != nil {
+		return err
+	}
+	_, err := p.file.ReadAt(buf, int64(PageSize)*int64(pageNum))
 	return err
 }
 
-func (p *Pager) AllocatePage(pageType PageType, parent uint32) (uint32, *Page, error) {
+// cachePage stores a private copy so callers mutating a *Page they got from
+// ReadPage never change what other readers see until WritePage is called.
+func (p *Pager) cachePage(pageNum uint32, data []byte) {
+	buf := make([]byte, PageSize)
+	copy(buf, data)
+	p.cache.Put(pageNum, buf)
+}
 
-	pageNum := p.numPages + 1
+func (p *Pager) CacheStats() utils.CacheStats {
+	return p.cache.Stats()
+}
 
-	page, err := NewPage(pageType, pageNum)
+// AllocatePage returns a new page of pageType, taking it from the freelist
+// if it has one and otherwise adding it to the end of the file.
+func (p *Pager) AllocatePage(pageType PageType, parent uint32) (uint32, *Page, error) {
+	if p.readOnly {
+		return 0, nil, ErrReadOnly
+	}
+
+	pageNum, err := p.popFreePage()
 	if err != nil {
 		return 0, nil, err
 	}
+	appended := pageNum == 0
+	if appended {
+		if pageNum, err = p.appendPage(); err != nil {
+			return 0, nil, err
+		}
+	}
 
-	page.Header.ParentPage = parent
-	page.writeHeader()
-
-	offset := int64(PageSize) * int64(pageNum)
-	_, err = p.file.WriteAt(page.Data, offset)
+	page, err := NewPage(pageType, pageNum)
+	if err == nil {
+		page.Header.ParentPage = parent
+		err = p.WritePage(pageNum, page)
+	}
 	if err != nil {
+		if appended {
+			p.numPages--
+		}
 		return 0, nil, err
 	}
+	if err := p.setPtrmap(pageNum, ptrmapRoot, 0); err != nil {
+		return 0, nil, err
+	}
+	return pageNum, page, nil
+}
 
+// appendPage adds an empty page to the end of the file and returns its
+// number, first adding a pointer map page if the file keeps a map and one
+// belongs there.
+func (p *Pager) appendPage() (uint32, error) {
+	pageNum := p.numPages + 1
+	if p.header.PointerMap && isPtrmapPage(pageNum) {
+		p.numPages++
+		page, err := NewPage(PageTypePointerMap, pageNum)
+		if err == nil {
+			err = p.WritePage(pageNum, page)
+		}
+		if err != nil {
+			p.numPages--
+			return 0, err
+		}
+		pageNum++
+	}
 	p.numPages++
-	return pageNum, page, nil
+	return pageNum, nil
 }
 
 func (p *Pager) ReadOrAllocatePage(pageNum uint32, pageType PageType, parent uint32) (*Page, error) {
@@ -183,6 +474,155 @@ func (p *Pager) Sync() error {
 	return p.file.Sync()
 }
 
+// ReadSchemaGeneration reads the schema generation straight from disk so that
+// changes made through another handle on the same file are observed. In WAL
+// mode this handle is the only writer, and a read-only handle reads the
+// header of its snapshot, so both already know it.
+func (p *Pager) ReadSchemaGeneration() (uint64, error) {
+	if p.wal != nil || p.readOnly {
+		return p.header.SchemaGeneration, nil
+	}
+	buf := make([]byte, 8)
+	if _, err := p.file.ReadAt(buf, 12); err != nil {
+		return 0, err
+	}
+	p.header.SchemaGeneration = binary.BigEndian.Uint64(buf)
+	return p.header.SchemaGeneration, nil
+}
+
+// Refresh drops the cached pages and re-reads the header if another handle
+// wrote to the file since this one last wrote or looked, and reports
+// whether it did. Like ReadSchemaGeneration it reads the change counter
+// straight from disk, except in WAL mode and for a read-only handle, which
+// see every change through the log. A batch that has written pages keeps
+// what it read, which its writes were based on.
+func (p *Pager) Refresh() (bool, error) {
+	if p.wal != nil || p.readOnly || (p.batch != nil && len(p.batch.pages) > 0) {
+		return false, nil
+	}
+	counter, err := p.readChangeCounter()
+	if err != nil || counter == p.header.ChangeCounter {
+		return false, err
+	}
+
+	p.cache.Clear()
+	if err := p.readHeader(); err != nil {
+		return true, err
+	}
+	size, err := p.file.Size()
+	if err != nil {
+		return true, err
+	}
+	if totalPages := uint32(size / PageSize); totalPages > 0 {
+		p.numPages = totalPages - 1
+	}
+	if p.batch != nil {
+		p.batch.header, p.batch.numPages = p.header, p.numPages
+	}
+	return true, nil
+}
+
+func (p *Pager) readChangeCounter() (uint64, error) {
+	buf := make([]byte, 8)
+	if _, err := p.file.ReadAt(buf, changeCounterOffset); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+// nextChange moves the change counter on from its value on disk, for a
+// write about to reach the file. If another handle wrote since this one
+// last looked, the cached pages may be out of date and are dropped.
+func (p *Pager) nextChange() error {
+	counter, err := p.readChangeCounter()
+	if err != nil {
+		return err
+	}
+	if counter != p.header.ChangeCounter {
+		p.cache.Clear()
+	}
+	p.header.ChangeCounter = counter + 1
+	return nil
+}
+
+// saveChangeCounter moves the change counter on and writes it, after a
+// write outside WAL mode.
+func (p *Pager) saveChangeCounter() error {
+	if err := p.nextChange(); err != nil {
+		return err
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, p.header.ChangeCounter)
+	_, err := p.file.WriteAt(buf, changeCounterOffset)
+	return err
+}
+
+func (p *Pager) BumpSchemaGeneration() (uint64, error) {
+	if p.readOnly {
+		return 0, ErrReadOnly
+	}
+	if _, err := p.ReadSchemaGeneration(); err != nil {
+		return 0, err
+	}
+
+	p.header.SchemaGeneration++
+	if p.wal != nil && p.batch != nil {
+		p.writeBatchPage(0, p.encodeHeader())
+		return p.header.SchemaGeneration, nil
+	}
+	if err := p.logPages(p.numPages, []walPage{{0, p.encodeHeader()}}, false); err != nil {
+		p.header.SchemaGeneration--
+		return 0, err
+	}
+	if p.wal != nil {
+		return p.header.SchemaGeneration, p.checkpointIfFull()
+	}
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, p.header.SchemaGeneration)
+	if _, err := p.file.WriteAt(buf, 12); err != nil {
+		return 0, err
+	}
+	if err := p.checkpointIfFull(); err != nil {
+		return 0, err
+	}
+	return p.header.SchemaGeneration, nil
+}
+
+// InvalidateCache drops every cached page and re-reads the page count and,
+// outside a batch, the header, used when another handle may have modified
+// the file underneath us.
+func (p *Pager) InvalidateCache() error {
+	p.cache.Clear()
+	if p.wal != nil || p.readOnly {
+		return nil
+	}
+	if p.batch == nil {
+		if err := p.readHeader(); err != nil {
+			return err
+		}
+	}
+
+	size, err := p.file.Size()
+	if err != nil {
+		return err
+	}
+	if totalPages := uint32(size / PageSize); totalPages > 0 {
+		p.numPages = totalPages - 1
+	}
+	return nil
+}
+
+// SetClock sets the clock that timestamps WAL records and the catalog's
+// statistics.
+func (p *Pager) SetClock(clock utils.Clock) {
+	p.clock = clock
+}
+
+func (p *Pager) Clock() utils.Clock {
+	return p.clock
+}
+
 func (p *Pager) GetHeader() DatabaseHeader {
 	return p.header
 }
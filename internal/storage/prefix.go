@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Index pages of the prefix types store the longest prefix that all their
+// keys share once, after the header, and each cell only the rest of its
+// key. Index keys on TEXT columns such as emails and URLs often share long
+// prefixes within a page, so more of them fit and the tree stays shallower.
+// Only text keys are shortened, as text keys of their suffixes; a page of
+// other keys has an empty prefix.
+//
+// A page takes a longer prefix when it is filled by a split or a bulk load,
+// or when an insert would otherwise split it. A key that does not start
+// with the prefix shortens it, rewriting the page's cells.
+
+var errPageFull = errors.New("not enough space")
+
+// keyPrefix is the prefix the page's keys share, nil if it stores none.
+func (p *Page) keyPrefix() []byte {
+	if p.Header.PrefixLen == 0 {
+		return nil
+	}
+	end := p.GetHeaderSize()
+	return p.Data[end-int(p.Header.PrefixLen) : end]
+}
+
+// fullKey restores a key as stored in a cell to the whole key.
+func (p *Page) fullKey(stored Key) (Key, error) {
+	prefix := p.keyPrefix()
+	if prefix == nil {
+		return stored, nil
+	}
+	suffix, ok := stored.(*TextKey)
+	if !ok {
+		return nil, fmt.Errorf("%s key in a page with a key prefix", stored)
+	}
+	return NewTextKey(string(prefix) + suffix.Value), nil
+}
+
+// storedKey is key as a cell of the page stores it. If key does not start
+// with the prefix, the prefix is shortened to what they share first.
+func (p *Page) storedKey(key Key) (Key, error) {
+	prefix := p.keyPrefix()
+	if prefix == nil {
+		return key, nil
+	}
+	if text, ok := key.(*TextKey); ok && len(text.Value) >= len(prefix) && text.Value[:len(prefix)] == string(prefix) {
+		return NewTextKey(text.Value[len(prefix):]), nil
+	}
+
+	if err := p.setKeyPrefix(prefix[:sharedPrefix(prefix, key)]); err != nil {
+		return nil, err
+	}
+	return p.storedKey(key)
+}
+
+// sharedPrefix is how many bytes of prefix key starts with.
+func sharedPrefix(prefix []byte, key Key) int {
+	text, ok := key.(*TextKey)
+	if !ok {
+		return 0
+	}
+	n := 0
+	for n < len(prefix) && n < len(text.Value) && prefix[n] == text.Value[n] {
+		n++
+	}
+	return n
+}
+
+// keysPrefix is the prefix shared by sorted keys from first to last, which
+// is the one first and last share, or nil if page cannot store one.
+func (p *Page) keysPrefix(first, last Key) []byte {
+	if p.Header.PageType != PageTypeLeafIndexPrefix && p.Header.PageType != PageTypeInteriorIndexPrefix {
+		return nil
+	}
+	a, ok := first.(*TextKey)
+	if !ok {
+		return nil
+	}
+	prefix := []byte(a.Value)
+	n := sharedPrefix(prefix, last)
+	if n == 0 {
+		return nil
+	}
+	return prefix[:n]
+}
+
+// setKeyPrefix rewrites the page's cells with prefix stored once, which
+// every key must start with. It fails, changing nothing, if they would no
+// longer fit.
+func (p *Page) setKeyPrefix(prefix []byte) error {
+	if bytes.Equal(prefix, p.keyPrefix()) {
+		return nil
+	}
+	prefix = bytes.Clone(prefix) // it may be the page's own
+
+	var leaves []*LeafCell
+	var interiors []*InteriorCell
+	size := 0
+	for i := uint16(0); i < p.Header.NumCells; i++ {
+		var key Key
+		if isLeaf(p.Header.PageType) {
+			cell, err := p.GetLeafCell(i)
+			if err != nil {
+				return err
+			}
+			leaves = append(leaves, cell)
+			key, size = cell.Key, size+int(cell.Size())
+		} else {
+			cell, err := p.GetInteriorCell(i)
+			if err != nil {
+				return err
+			}
+			interiors = append(interiors, cell)
+			key, size = cell.Key, size+int(cell.Size())
+		}
+		if sharedPrefix(prefix, key) < len(prefix) {
+			return fmt.Errorf("key %s does not start with the page's key prefix", key)
+		}
+		size += 2 - len(prefix)
+	}
+
+	base := p.GetHeaderSize() - int(p.Header.PrefixLen)
+	if base+len(prefix)+size > PageSize {
+		return errPageFull
+	}
+
+	for i := base; i < len(p.Data); i++ {
+		p.Data[i] = 0
+	}
+	copy(p.Data[base:], prefix)
+	p.Header.PrefixLen = uint16(len(prefix))
+	p.Header.NumCells = 0
+	p.Header.CellContentOffset = uint16(PageSize)
+	p.Header.FirstFreeblock = 0
+	p.Header.FragmentedBytes = 0
+	p.writeHeader()
+
+	for _, cell := range leaves {
+		if err := p.InsertLeafCell(cell); err != nil {
+			return err
+		}
+	}
+	for _, cell := range interiors {
+		if err := p.InsertInteriorCell(cell); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressKeys stores the longest prefix the page's keys share, if it is
+// longer than the one the page has.
+func (p *Page) compressKeys() error {
+	if p.Header.NumCells == 0 {
+		return nil
+	}
+	first, err := p.GetCellKey(0)
+	if err != nil {
+		return err
+	}
+	last, err := p.GetCellKey(p.Header.NumCells - 1)
+	if err != nil {
+		return err
+	}
+	prefix := p.keysPrefix(first, last)
+	if len(prefix) <= int(p.Header.PrefixLen) {
+		return nil
+	}
+	return p.setKeyPrefix(prefix)
+}
+
+// CanFitCell is CanFit for a cell with key whose size, with the whole key,
+// is size. The cell is smaller if key starts with the page's key prefix;
+// if it does not, every other cell grows.
+func (p *Page) CanFitCell(key Key, size uint32) bool {
+	prefix := p.keyPrefix()
+	n := sharedPrefix(prefix, key)
+	if n == len(prefix) {
+		return p.CanFit(size - uint32(n))
+	}
+
+	// Shortening the prefix frees its bytes after the header and adds them
+	// back to every cell; the page is rewritten without free blocks.
+	grown := int(p.Header.NumCells)*(len(prefix)-n) - (len(prefix) - n)
+	need := int(size) - n + 2 + grown
+	return need <= int(p.GetTotalFreeSpace())
+}
+
+// packedSize is the space sorted cells with the given whole keys and sizes
+// take in an empty page of p's type, with their shared prefix stored once.
+func (p *Page) packedSize(keys []Key, sizes []uint32) int {
+	if len(keys) == 0 {
+		return 0
+	}
+	prefix := p.keysPrefix(keys[0], keys[len(keys)-1])
+	size := len(prefix)
+	for _, s := range sizes {
+		size += int(s) + 2 - len(prefix)
+	}
+	return size
+}
+
+// fitSplit checks a split of sorted cells between two empty pages of p's
+// type at mid, the left page taking the cells before it and the right page
+// those from mid+skip on, and moves it if either half would not fit. That
+// happens when the cell being added does not share the key prefix of the
+// page being split, leaving the half it joins with a short prefix; giving
+// that cell, first or last, a page of its own always fits.
+func (p *Page) fitSplit(keys []Key, sizes []uint32, mid, skip int) int {
+	room := PageSize - (p.GetHeaderSize() - int(p.Header.PrefixLen))
+	for _, m := range []int{mid, 1, len(keys) - 1 - skip} {
+		if m < 1 || m+skip >= len(keys) {
+			continue
+		}
+		if p.packedSize(keys[:m], sizes[:m]) <= room && p.packedSize(keys[m+skip:], sizes[m+skip:]) <= room {
+			return m
+		}
+	}
+	return mid
+}
+
+// startPrefix gives empty page p the key prefix for sorted keys from first
+// to last, which are about to be inserted.
+func (p *Page) startPrefix(first, last Key) error {
+	return p.setKeyPrefix(p.keysPrefix(first, last))
+}
@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A file with a pointer map records, for every page, whether it is the
+// root of a tree, the child of an interior page or free, and for a child
+// which page points to it. That is what IncrementalVacuum needs to move a
+// page: the one reference to it is found without searching every tree.
+//
+// The map is kept in pointer map pages at fixed places. Page 2 is the
+// first; it holds the entries of the ptrmapEntries pages after it, and the
+// page after those is the next map page. Pages 0 to 2 have no entry. After
+// its 8-byte page header, a map page holds one 5-byte entry per page:
+//
+//	offset  size  field
+//	0       1     type: ptrmapRoot, ptrmapChild or ptrmapFree
+//	1       4     parent page, for ptrmapChild
+//
+// Only a file that has no pages beyond the catalog can start keeping a
+// pointer map, see EnablePointerMap, since the pages it already has may sit
+// where map pages belong.
+
+const (
+	ptrmapRoot  byte = 1
+	ptrmapChild byte = 2
+	ptrmapFree  byte = 3
+
+	ptrmapEntrySize = 5
+	ptrmapEntries   = (PageSize - 8) / ptrmapEntrySize
+)
+
+// isPtrmapPage reports whether pageNum is a pointer map page in a file
+// that keeps one.
+func isPtrmapPage(pageNum uint32) bool {
+	return pageNum >= 2 && (pageNum-2)%(ptrmapEntries+1) == 0
+}
+
+// ptrmapLocation is the map page holding pageNum's entry and the entry's
+// offset in it.
+func ptrmapLocation(pageNum uint32) (uint32, int) {
+	mapPage := 2 + (pageNum-2)/(ptrmapEntries+1)*(ptrmapEntries+1)
+	return mapPage, 8 + int(pageNum-mapPage-1)*ptrmapEntrySize
+}
+
+// EnablePointerMap makes the file keep a pointer map from now on. The file
+// must not have pages beyond the first yet.
+func (p *Pager) EnablePointerMap() error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if p.header.PointerMap {
+		return nil
+	}
+	if p.numPages > 1 || p.header.FreePages > 0 {
+		return errors.New("a pointer map can only be enabled on an empty database")
+	}
+	p.header.PointerMap = true
+	if err := p.saveHeader(); err != nil {
+		p.header.PointerMap = false
+		return err
+	}
+	return nil
+}
+
+// HasPointerMap reports whether the file keeps a pointer map.
+func (p *Pager) HasPointerMap() bool {
+	return p.header.PointerMap
+}
+
+func (p *Pager) ptrmapEntry(pageNum uint32) (byte, uint32, error) {
+	if pageNum <= 2 || isPtrmapPage(pageNum) || pageNum > p.numPages {
+		return 0, 0, fmt.Errorf("page %d has no pointer map entry", pageNum)
+	}
+	mapNum, off := ptrmapLocation(pageNum)
+	page, err := p.ReadPage(mapNum)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read pointer map page %d: %w", mapNum, err)
+	}
+	defer p.ReleasePage(page)
+	return page.Data[off], binary.BigEndian.Uint32(page.Data[off+1 : off+5]), nil
+}
+
+// setPtrmap records pageNum's entry, if the file keeps a pointer map.
+func (p *Pager) setPtrmap(pageNum uint32, kind byte, parent uint32) error {
+	if !p.header.PointerMap || pageNum <= 2 {
+		return nil
+	}
+	return p.setPtrmapEntries(map[uint32]uint32{pageNum: parent}, kind)
+}
+
+// setPtrmapEntries records an entry of type kind for each page in parents,
+// with the parent it maps to, writing only the map pages that change.
+func (p *Pager) setPtrmapEntries(parents map[uint32]uint32, kind byte) error {
+	maps := make(map[uint32]*Page)
+	dirty := make(map[uint32]bool)
+	defer func() {
+		for _, page := range maps {
+			p.ReleasePage(page)
+		}
+	}()
+
+	for pageNum, parent := range parents {
+		if pageNum <= 2 || isPtrmapPage(pageNum) {
+			continue
+		}
+		mapNum, off := ptrmapLocation(pageNum)
+		page := maps[mapNum]
+		if page == nil {
+			var err error
+			if page, err = p.ReadPage(mapNum); err != nil {
+				return fmt.Errorf("failed to read pointer map page %d: %w", mapNum, err)
+			}
+			maps[mapNum] = page
+		}
+		if page.Data[off] == kind && binary.BigEndian.Uint32(page.Data[off+1:off+5]) == parent {
+			continue
+		}
+		page.Data[off] = kind
+		binary.BigEndian.PutUint32(page.Data[off+1:off+5], parent)
+		dirty[mapNum] = true
+	}
+
+	for mapNum := range dirty {
+		if err := p.WritePage(mapNum, maps[mapNum]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateChildren points the entries of an interior page's children at it.
+func (p *Pager) updateChildren(pageNum uint32, page *Page) error {
+	children, err := page.children()
+	if err != nil {
+		return fmt.Errorf("failed to read children of page %d: %w", pageNum, err)
+	}
+	parents := make(map[uint32]uint32, len(children))
+	for _, child := range children {
+		if child != 0 && child <= p.numPages {
+			parents[child] = pageNum
+		}
+	}
+	return p.setPtrmapEntries(parents, ptrmapChild)
+}
+
+// IncrementalVacuum shrinks the file by up to limit pages, or as far as it
+// can if limit is 0: it moves the pages in use at the end of the file into
+// free pages nearer the start and cuts the end off. Only the roots that
+// movable accepts are moved; it stops at the first other root it meets
+// from the end. It returns the number of pages released and where each
+// moved root went, which the caller must record wherever the old page
+// number is kept before the batch commits.
+func (p *Pager) IncrementalVacuum(limit int, movable func(root uint32) bool) (int, map[uint32]uint32, error) {
+	if p.readOnly {
+		return 0, nil, ErrReadOnly
+	}
+	if !p.header.PointerMap {
+		return 0, nil, errors.New("incremental vacuum needs a database with a pointer map")
+	}
+
+	free, err := p.freePages()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read freelist: %w", err)
+	}
+	if len(free) == 0 {
+		return 0, nil, nil
+	}
+	isFree := make(map[uint32]bool, len(free))
+	for _, pageNum := range free {
+		isFree[pageNum] = true
+	}
+
+	// Every page cut off other than a map page is either free already or
+	// moves into a free page, so the file can lose as many of them as
+	// there are free pages.
+	target, tail := p.numPages, 0
+	for target > 2 && (limit <= 0 || int(p.numPages-target) < limit) {
+		if isPtrmapPage(target) {
+			target--
+			continue
+		}
+		if tail == len(free) {
+			break
+		}
+		if !isFree[target] {
+			kind, _, err := p.ptrmapEntry(target)
+			if err != nil {
+				return 0, nil, err
+			}
+			if kind != ptrmapChild && !(kind == ptrmapRoot && movable(target)) {
+				break
+			}
+		}
+		tail++
+		target--
+	}
+	if target == p.numPages {
+		return 0, nil, nil
+	}
+
+	var slots []uint32
+	for _, pageNum := range free {
+		if pageNum <= target {
+			slots = append(slots, pageNum)
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	roots := make(map[uint32]uint32)
+	for pageNum := p.numPages; pageNum > target; pageNum-- {
+		if isFree[pageNum] || isPtrmapPage(pageNum) {
+			continue
+		}
+		to := slots[0]
+		slots = slots[1:]
+		root, err := p.movePage(pageNum, to)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to move page %d to %d: %w", pageNum, to, err)
+		}
+		if root {
+			roots[pageNum] = to
+		}
+	}
+
+	// The free pages left are put back on a new freelist.
+	released := int(p.numPages - target)
+	p.numPages = target
+	p.header.FreelistTrunk, p.header.FreePages = 0, 0
+	if err := p.saveHeader(); err != nil {
+		return 0, nil, err
+	}
+	for _, pageNum := range slots {
+		if err := p.FreePage(pageNum); err != nil {
+			return 0, nil, err
+		}
+	}
+	if p.batch == nil && p.wal == nil {
+		if err := p.file.Truncate(int64(p.numPages+1) * PageSize); err != nil {
+			return 0, nil, err
+		}
+	}
+	return released, roots, nil
+}
+
+// movePage copies page from to the free page to and points its parent, or
+// for a leaf its siblings, at the copy. It reports whether from was a
+// root, which has no parent to update.
+func (p *Pager) movePage(from, to uint32) (bool, error) {
+	kind, parentNum, err := p.ptrmapEntry(from)
+	if err != nil {
+		return false, err
+	}
+	page, err := p.ReadPage(from)
+	if err != nil {
+		return false, err
+	}
+	defer p.ReleasePage(page)
+	if err := p.WritePage(to, page); err != nil {
+		return false, err
+	}
+	if kind == ptrmapRoot {
+		return true, p.setPtrmap(to, ptrmapRoot, 0)
+	}
+
+	parent, err := p.ReadPage(parentNum)
+	if err != nil {
+		return false, err
+	}
+	defer p.ReleasePage(parent)
+	if !isInterior(parent.Header.PageType) {
+		return false, fmt.Errorf("pointer map names page %d, which is not interior, as its parent", parentNum)
+	}
+	found, err := parent.replaceChild(from, to)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, fmt.Errorf("pointer map names page %d as its parent, which does not point to it", parentNum)
+	}
+	if err := p.WritePage(parentNum, parent); err != nil {
+		return false, err
+	}
+
+	if !isLeaf(page.Header.PageType) {
+		return false, nil
+	}
+	for _, sibling := range []uint32{page.Header.PrevLeaf, page.Header.NextLeaf} {
+		if sibling == 0 {
+			continue
+		}
+		leaf, err := p.ReadPage(sibling)
+		if err != nil {
+			return false, err
+		}
+		if leaf.Header.NextLeaf == from {
+			leaf.Header.NextLeaf = to
+		}
+		if leaf.Header.PrevLeaf == from {
+			leaf.Header.PrevLeaf = to
+		}
+		err = p.WritePage(sibling, leaf)
+		p.ReleasePage(leaf)
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/kithinjibrian/anubisdb/internal/utils"
+)
+
+// A read-only handle reads a database that another process writes in WAL
+// mode. Each statement it runs sees the database as of one commit: BeginRead
+// takes a shared lock on the file, so that no checkpoint changes the file
+// underneath it, and indexes the records logged since it last looked. Pages
+// are read from the latest image in that index, otherwise from the file.
+// Records are only indexed once whole, so a commit is seen entirely or not
+// at all.
+
+// snapshot is the WAL index of a read-only handle.
+type snapshot struct {
+	// checkpoint is the file's CheckpointSegment when the index was built.
+	checkpoint uint64
+	pages      map[uint32]walPos
+	segments   map[uint64]File
+
+	// seq and size are where the next record is read from.
+	seq  uint64
+	size int64
+
+	// numPages is the page count of the latest record, if hasRecords.
+	numPages   uint32
+	hasRecords bool
+}
+
+// walPos is where a page image is in the WAL.
+type walPos struct {
+	seq uint64
+	off int64
+}
+
+func (s *snapshot) close() {
+	for _, file := range s.segments {
+		file.Close()
+	}
+	s.segments = nil
+}
+
+// OpenPagerReadOnly opens an existing database file in vfs for reading
+// alongside the handle that writes it. It does not recover the WAL, which
+// is the writer's job, and every write through it fails with ErrReadOnly.
+func OpenPagerReadOnly(vfs VFS, filename string) (*Pager, error) {
+	exists, err := vfs.Exists(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("database %s does not exist", filename)
+	}
+	file, err := vfs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pager{
+		vfs:      vfs,
+		file:     file,
+		path:     filename,
+		cache:    utils.NewLRUCache[uint32, []byte](MaxCachedPages),
+		clock:    utils.SystemClock,
+		readOnly: true,
+	}
+	if _, err := p.BeginRead(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	p.EndRead()
+	if err := p.checkMinFormatVersion(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// ReadOnly reports whether the handle was opened with OpenPagerReadOnly.
+func (p *Pager) ReadOnly() bool {
+	return p.readOnly
+}
+
+// BeginRead starts a statement of a read-only handle, which then reads the
+// database as of the latest commit until EndRead. It reports whether the
+// database changed since the last statement, in which case the caller's
+// own caches are stale. For any other handle it does nothing.
+func (p *Pager) BeginRead() (bool, error) {
+	if !p.readOnly {
+		return false, nil
+	}
+	p.reading++
+	if p.reading > 1 {
+		return false, nil
+	}
+	if err := p.file.Lock(LockShared); err != nil {
+		p.reading--
+		return false, err
+	}
+	changed, err := p.refreshSnapshot()
+	if err != nil {
+		p.EndRead()
+		return false, err
+	}
+	return changed, nil
+}
+
+// EndRead ends the statement started by BeginRead.
+func (p *Pager) EndRead() {
+	if !p.readOnly || p.reading == 0 {
+		return
+	}
+	p.reading--
+	if p.reading == 0 {
+		p.file.Unlock()
+	}
+}
+
+// refreshSnapshot brings the snapshot up to the latest commit. It must be
+// called with the file locked.
+func (p *Pager) refreshSnapshot() (bool, error) {
+	size, err := p.file.Size()
+	if err != nil {
+		return false, err
+	}
+	if size < PageSize {
+		return false, errors.New("invalid database file: too short")
+	}
+	buf := make([]byte, PageSize)
+	if _, err := p.file.ReadAt(buf, 0); err != nil {
+		return false, err
+	}
+	header, err := decodeHeader(buf)
+	if err != nil {
+		return false, err
+	}
+
+	dir := walDir(p.path)
+	inWAL, err := p.vfs.Exists(dir)
+	if err != nil {
+		return false, err
+	}
+	s := p.snap
+	if s == nil || !inWAL || s.checkpoint != header.CheckpointSegment {
+		// Outside WAL mode the writer changes the file in place, so
+		// nothing of the last statement can be trusted.
+		if s != nil {
+			s.close()
+		}
+		s = &snapshot{checkpoint: header.CheckpointSegment, pages: make(map[uint32]walPos), segments: make(map[uint64]File)}
+		p.snap = s
+		p.header = header
+		p.cache.Clear()
+		if !inWAL {
+			p.numPages = uint32(size/PageSize) - 1
+			return true, nil
+		}
+		_, err := p.indexWAL(dir, size)
+		return true, err
+	}
+	return p.indexWAL(dir, size)
+}
+
+// indexWAL adds the records logged since the snapshot was last refreshed.
+// size is the size of the database file.
+func (p *Pager) indexWAL(dir string, size int64) (bool, error) {
+	s := p.snap
+	seqs, err := listSegments(p.vfs, dir)
+	if err != nil {
+		return false, fmt.Errorf("failed to read WAL directory: %w", err)
+	}
+	changed := false
+	for _, seq := range seqs {
+		if seq < s.checkpoint || seq < s.seq {
+			continue
+		}
+		if seq > s.seq {
+			s.seq, s.size = seq, 0
+		}
+		file, ok := s.segments[seq]
+		if !ok {
+			if file, err = p.vfs.Open(filepath.Join(dir, segmentName(seq))); err != nil {
+				return changed, err
+			}
+			s.segments[seq] = file
+		}
+		n, err := p.indexSegment(file, seq, s.size)
+		if n > 0 {
+			changed = true
+		}
+		if err != nil {
+			return changed, fmt.Errorf("failed to read WAL segment %s: %w", segmentName(seq), err)
+		}
+	}
+
+	p.numPages = uint32(size/PageSize) - 1
+	if s.hasRecords {
+		p.numPages = s.numPages
+	}
+	return changed, nil
+}
+
+// indexSegment indexes the whole records of segment seq from off on and
+// returns how many there were.
+func (p *Pager) indexSegment(file File, seq uint64, off int64) (int, error) {
+	s := p.snap
+	end, err := file.Size()
+	if err != nil {
+		return 0, err
+	}
+	if off == 0 {
+		if end < walHeaderSize {
+			// Still being created.
+			return 0, nil
+		}
+		header := make([]byte, walHeaderSize)
+		if _, err := file.ReadAt(header, 0); err != nil {
+			return 0, err
+		}
+		if [8]byte(header[0:8]) != walMagic {
+			return 0, errors.New("bad magic number")
+		}
+		off = walHeaderSize
+		s.size = off
+	}
+
+	r := io.NewSectionReader(file, off, end-off)
+	count := 0
+	for {
+		rec, n, ok := readRecord(r)
+		if !ok {
+			return count, nil
+		}
+		for i, page := range rec.pages {
+			s.pages[page.pageNum] = walPos{seq, pageOffset(s.size, i)}
+			p.cache.Delete(page.pageNum)
+			if page.pageNum == 0 {
+				if p.header, err = decodeHeader(page.data); err != nil {
+					return count, err
+				}
+			}
+		}
+		s.size += n
+		s.numPages, s.hasRecords = rec.numPages, true
+		count++
+	}
+}
+
+// readWALPage reads pageNum from the WAL into buf if it changed since the
+// checkpoint, reporting whether it did.
+func (p *Pager) readWALPage(pageNum uint32, buf []byte) (bool, error) {
+	var file File
+	var off int64
+	if p.wal != nil {
+		pos, ok := p.wal.index[pageNum]
+		if !ok {
+			return false, nil
+		}
+		file, off = p.wal.file, pos
+	} else if p.snap != nil {
+		pos, ok := p.snap.pages[pageNum]
+		if !ok {
+			return false, nil
+		}
+		file, off = p.snap.segments[pos.seq], pos.off
+	} else {
+		return false, nil
+	}
+	if _, err := file.ReadAt(buf[:PageSize], off); err != nil {
+		return true, fmt.Errorf("failed to read page %d from the WAL: %w", pageNum, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,102 @@
+package storage
+
+import "fmt"
+
+// TreeStats describes the pages of one B-tree.
+type TreeStats struct {
+	RootPage      uint32
+	Depth         int
+	InteriorPages int
+	LeafPages     int
+	Entries       int
+
+	// PayloadBytes is the space taken by cells, UnusedBytes the space that
+	// could still hold cells, FragmentedBytes the part of it left behind by
+	// deleted cells, in freeblocks or fragments.
+	PayloadBytes    int
+	UnusedBytes     int
+	FragmentedBytes int
+
+	// OutOfOrderLeaves counts leaves whose successor is not the next page
+	// in the file, which costs a seek during a full scan.
+	OutOfOrderLeaves int
+}
+
+func (s TreeStats) Pages() int {
+	return s.InteriorPages + s.LeafPages
+}
+
+// FillFactor is the fraction of cell space in use, between 0 and 1.
+func (s TreeStats) FillFactor() float64 {
+	total := s.PayloadBytes + s.UnusedBytes
+	if total == 0 {
+		return 0
+	}
+	return float64(s.PayloadBytes) / float64(total)
+}
+
+// Fragmentation is the fraction of leaf-to-leaf steps that are not
+// sequential on disk, between 0 and 1.
+func (s TreeStats) Fragmentation() float64 {
+	if s.LeafPages < 2 {
+		return 0
+	}
+	return float64(s.OutOfOrderLeaves) / float64(s.LeafPages-1)
+}
+
+// Stats visits every page of the tree.
+func (tree *BTree) Stats() (TreeStats, error) {
+	stats := TreeStats{RootPage: tree.root}
+	if err := tree.collectStats(tree.root, 1, &stats); err != nil {
+		return TreeStats{}, err
+	}
+	return stats, nil
+}
+
+func (tree *BTree) collectStats(pageNum uint32, depth int, stats *TreeStats) error {
+	if pageNum == 0 {
+		return fmt.Errorf("invalid child pointer (0) encountered")
+	}
+
+	page, err := tree.pager.ReadPage(pageNum)
+	if err != nil {
+		return fmt.Errorf("failed to read page %d: %w", pageNum, err)
+	}
+
+	if depth > stats.Depth {
+		stats.Depth = depth
+	}
+
+	payload := 0
+	for i := uint16(0); i < page.Header.NumCells; i++ {
+		size, err := page.GetCellSize(i)
+		if err != nil {
+			return fmt.Errorf("page %d cell %d: %w", pageNum, i, err)
+		}
+		payload += int(size) + 2
+	}
+	stats.PayloadBytes += payload
+	stats.UnusedBytes += PageSize - page.GetHeaderSize() - payload
+	stats.FragmentedBytes += int(page.freeblockBytes()) + int(page.Header.FragmentedBytes)
+
+	if isLeaf(page.Header.PageType) {
+		stats.LeafPages++
+		stats.Entries += int(page.Header.NumCells)
+		if page.Header.NextLeaf != 0 && page.Header.NextLeaf != pageNum+1 {
+			stats.OutOfOrderLeaves++
+		}
+		return nil
+	}
+
+	stats.InteriorPages++
+	for i := uint16(0); i < page.Header.NumCells; i++ {
+		cell, err := page.GetInteriorCell(i)
+		if err != nil {
+			return fmt.Errorf("page %d cell %d: %w", pageNum, i, err)
+		}
+		if err := tree.collectStats(cell.ChildPage, depth+1, stats); err != nil {
+			return err
+		}
+	}
+	return tree.collectStats(page.Header.RightmostPointer, depth+1, stats)
+}
@@ -5,7 +5,12 @@ type Storage struct {
 }
 
 func NewStorage(filename string) (*Storage, error) {
-	pager, err := NewPager(filename)
+	return OpenStorage(OSFS, filename)
+}
+
+// OpenStorage opens the database file filename in vfs.
+func OpenStorage(vfs VFS, filename string) (*Storage, error) {
+	pager, err := OpenPager(vfs, filename)
 	if err != nil {
 		return nil, err
 	}
@@ -17,6 +22,16 @@ func NewStorage(filename string) (*Storage, error) {
 	return s, nil
 }
 
+// OpenStorageReadOnly opens the database file filename in vfs for reading
+// alongside the process writing it, see OpenPagerReadOnly.
+func OpenStorageReadOnly(vfs VFS, filename string) (*Storage, error) {
+	pager, err := OpenPagerReadOnly(vfs, filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{Pager: pager}, nil
+}
+
 func (s *Storage) Close() error {
 	return s.Pager.Close()
 }
@@ -0,0 +1,53 @@
+package storage
+
+// Store keeps a table's rows keyed by primary key. BTree is the default
+// engine; LSMTree suits tables that take many more writes than reads.
+type Store interface {
+	Search(key Key) ([]byte, error)
+	Insert(key Key, value []byte) error
+	Update(key Key, value []byte) error
+	Delete(key Key) error
+
+	Scan() ([]Entry, error)
+	RangeSearch(start, end Key) ([]Entry, error)
+	Entries(reverse bool) (EntryIterator, error)
+	// EntriesFrom iterates over the keys from start on, in ascending order.
+	EntriesFrom(start Key) (EntryIterator, error)
+	LastKey() (Key, error)
+	Count() (int, error)
+
+	// BulkLoad fills an empty store from entries sorted by key.
+	BulkLoad(entries []Entry) error
+
+	Validate() error
+	Stats() (TreeStats, error)
+	GetRootPage() uint32
+
+	// Free puts every page of the store on the freelist.
+	Free() error
+}
+
+// EntryIterator walks the entries of a Store in key order, or in reverse.
+type EntryIterator interface {
+	HasNext() bool
+	Next() (Key, []byte, error)
+	Err() error
+}
+
+var (
+	_ Store = (*BTree)(nil)
+	_ Store = (*LSMTree)(nil)
+)
+
+// Entries returns an iterator over the whole tree, largest key first if
+// reverse is set.
+func (tree *BTree) Entries(reverse bool) (EntryIterator, error) {
+	if reverse {
+		return tree.NewReverseIterator()
+	}
+	return tree.NewIterator()
+}
+
+func (tree *BTree) EntriesFrom(start Key) (EntryIterator, error) {
+	return tree.NewRangeIterator(start, nil)
+}
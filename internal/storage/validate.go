@@ -0,0 +1,179 @@
+package storage
+
+import "fmt"
+
+// ValidationError is the first structural problem Validate found.
+type ValidationError struct {
+	Page uint32
+	Msg  string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("page %d: %s", e.Page, e.Msg)
+}
+
+// keyRange bounds the keys a subtree may hold: lower <= key < upper. A nil
+// bound is open.
+type keyRange struct {
+	lower, upper Key
+}
+
+type validator struct {
+	tree      *BTree
+	visited   map[uint32]bool
+	leafDepth int
+	prevLeaf  uint32
+	prevKey   Key
+}
+
+// Validate walks the whole tree and checks that keys are strictly ordered
+// within each page and fall inside the range their parent assigns, that all
+// leaves are at the same depth, that the leaf sibling links form one
+// chain in key order, and that the pointer map, if the file keeps one,
+// knows each page's parent. It returns the first violation as a
+// *ValidationError.
+func (tree *BTree) Validate() error {
+	v := &validator{tree: tree, visited: make(map[uint32]bool)}
+	if err := v.visit(tree.root, 0, 1, keyRange{}); err != nil {
+		return err
+	}
+	if v.prevLeaf != 0 {
+		last, err := tree.pager.ReadPage(v.prevLeaf)
+		if err != nil {
+			return &ValidationError{Page: v.prevLeaf, Msg: err.Error()}
+		}
+		if last.Header.NextLeaf != 0 {
+			return &ValidationError{Page: v.prevLeaf, Msg: fmt.Sprintf("last leaf links to next leaf %d", last.Header.NextLeaf)}
+		}
+	}
+	return nil
+}
+
+func (v *validator) visit(pageNum, parent uint32, depth int, bounds keyRange) error {
+	if pageNum == 0 || pageNum > v.tree.pager.GetNumPages() {
+		return &ValidationError{Page: pageNum, Msg: "child pointer out of range"}
+	}
+	if v.visited[pageNum] {
+		return &ValidationError{Page: pageNum, Msg: "page is referenced more than once"}
+	}
+	v.visited[pageNum] = true
+
+	if err := v.checkPtrmap(pageNum, parent); err != nil {
+		return err
+	}
+
+	page, err := v.tree.pager.ReadPage(pageNum)
+	if err != nil {
+		return &ValidationError{Page: pageNum, Msg: err.Error()}
+	}
+
+	if _, err := page.freeblocks(); err != nil {
+		return &ValidationError{Page: pageNum, Msg: err.Error()}
+	}
+
+	// Index pages written before pages could store a key prefix keep their
+	// older types until a split rewrites them.
+	switch t := page.Header.PageType; {
+	case t == v.tree.getLeafPageType() || v.tree.isIndex && t == PageTypeLeafIndex:
+		return v.visitLeaf(pageNum, page, depth, bounds)
+	case t == v.tree.getInteriorPageType() || v.tree.isIndex && t == PageTypeInteriorIndex:
+		return v.visitInterior(pageNum, page, depth, bounds)
+	}
+	return &ValidationError{Page: pageNum, Msg: fmt.Sprintf("unexpected page type %s", page.Header.PageType)}
+}
+
+func (v *validator) checkPtrmap(pageNum, parent uint32) error {
+	pager := v.tree.pager
+	if !pager.header.PointerMap || pageNum <= 2 {
+		return nil
+	}
+	if isPtrmapPage(pageNum) {
+		return &ValidationError{Page: pageNum, Msg: "tree uses a pointer map page"}
+	}
+	kind, mapped, err := pager.ptrmapEntry(pageNum)
+	if err != nil {
+		return &ValidationError{Page: pageNum, Msg: err.Error()}
+	}
+	switch {
+	case parent == 0 && kind != ptrmapRoot:
+		return &ValidationError{Page: pageNum, Msg: fmt.Sprintf("root has pointer map entry type %d", kind)}
+	case parent != 0 && (kind != ptrmapChild || mapped != parent):
+		return &ValidationError{Page: pageNum, Msg: fmt.Sprintf("pointer map entry is type %d parent %d, expected a child of %d", kind, mapped, parent)}
+	}
+	return nil
+}
+
+func (v *validator) checkKeys(pageNum uint32, page *Page, bounds keyRange) ([]Key, error) {
+	keys := make([]Key, page.Header.NumCells)
+	for i := range keys {
+		key, err := page.GetCellKey(uint16(i))
+		if err != nil {
+			return nil, &ValidationError{Page: pageNum, Msg: fmt.Sprintf("cell %d: %v", i, err)}
+		}
+		if i > 0 && keys[i-1].Compare(key) >= 0 {
+			return nil, &ValidationError{Page: pageNum, Msg: fmt.Sprintf("cell %d key %s is not greater than %s", i, key, keys[i-1])}
+		}
+		if bounds.lower != nil && key.Compare(bounds.lower) < 0 {
+			return nil, &ValidationError{Page: pageNum, Msg: fmt.Sprintf("cell %d key %s is below the parent's lower bound %s", i, key, bounds.lower)}
+		}
+		if bounds.upper != nil && key.Compare(bounds.upper) >= 0 {
+			return nil, &ValidationError{Page: pageNum, Msg: fmt.Sprintf("cell %d key %s is not below the parent's upper bound %s", i, key, bounds.upper)}
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+func (v *validator) visitLeaf(pageNum uint32, page *Page, depth int, bounds keyRange) error {
+	if v.leafDepth == 0 {
+		v.leafDepth = depth
+	} else if depth != v.leafDepth {
+		return &ValidationError{Page: pageNum, Msg: fmt.Sprintf("leaf at depth %d, expected %d", depth, v.leafDepth)}
+	}
+
+	keys, err := v.checkKeys(pageNum, page, bounds)
+	if err != nil {
+		return err
+	}
+
+	if page.Header.PrevLeaf != v.prevLeaf {
+		return &ValidationError{Page: pageNum, Msg: fmt.Sprintf("previous leaf link is %d, expected %d", page.Header.PrevLeaf, v.prevLeaf)}
+	}
+	if v.prevLeaf != 0 {
+		prev, err := v.tree.pager.ReadPage(v.prevLeaf)
+		if err != nil {
+			return &ValidationError{Page: v.prevLeaf, Msg: err.Error()}
+		}
+		if prev.Header.NextLeaf != pageNum {
+			return &ValidationError{Page: v.prevLeaf, Msg: fmt.Sprintf("next leaf link is %d, expected %d", prev.Header.NextLeaf, pageNum)}
+		}
+	}
+	if len(keys) > 0 {
+		if v.prevKey != nil && keys[0].Compare(v.prevKey) <= 0 {
+			return &ValidationError{Page: pageNum, Msg: fmt.Sprintf("first key %s is not greater than the previous leaf's last key %s", keys[0], v.prevKey)}
+		}
+		v.prevKey = keys[len(keys)-1]
+	}
+	v.prevLeaf = pageNum
+	return nil
+}
+
+func (v *validator) visitInterior(pageNum uint32, page *Page, depth int, bounds keyRange) error {
+	keys, err := v.checkKeys(pageNum, page, bounds)
+	if err != nil {
+		return err
+	}
+
+	lower := bounds.lower
+	for i, key := range keys {
+		cell, err := page.GetInteriorCell(uint16(i))
+		if err != nil {
+			return &ValidationError{Page: pageNum, Msg: fmt.Sprintf("cell %d: %v", i, err)}
+		}
+		if err := v.visit(cell.ChildPage, pageNum, depth+1, keyRange{lower: lower, upper: key}); err != nil {
+			return err
+		}
+		lower = key
+	}
+	return v.visit(page.Header.RightmostPointer, pageNum, depth+1, keyRange{lower: lower, upper: bounds.upper})
+}
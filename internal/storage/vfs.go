@@ -0,0 +1,371 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// VFS is the file system a Pager keeps the database file and its WAL
+// directory in. OSFS is the operating system's; MemFS keeps files in
+// memory. Other implementations can encrypt pages, store them remotely, or
+// fail on purpose to test recovery. Names are slash- or OS-separated paths.
+//
+// Backups, restores and the page map of incremental backups always use the
+// operating system's files.
+type VFS interface {
+	// Open opens name for reading and writing, creating it if it does not
+	// exist.
+	Open(name string) (File, error)
+	// Create opens name for reading and writing, emptying it first.
+	Create(name string) (File, error)
+	Remove(name string) error
+	Exists(name string) (bool, error)
+	MkdirAll(dir string) error
+	// ReadDir returns the names of the files in dir, sorted.
+	ReadDir(dir string) ([]string, error)
+	// SyncDir makes the creation and removal of files in dir durable.
+	SyncDir(dir string) error
+}
+
+// File is an open file of a VFS.
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	Size() (int64, error)
+	Truncate(size int64) error
+	Sync() error
+	// Lock waits for a lock on the whole file, shared by any number of
+	// holders or held exclusively by one, until Unlock.
+	Lock(mode LockMode) error
+	// TryLock takes the lock like Lock if it is free, and otherwise
+	// reports false at once.
+	TryLock(mode LockMode) (bool, error)
+	Unlock() error
+	Close() error
+}
+
+type LockMode int
+
+const (
+	LockShared LockMode = iota
+	LockExclusive
+)
+
+// OSFS is the operating system's file system.
+var OSFS VFS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	return osFile{file}, nil
+}
+
+func (osFS) Create(name string) (File, error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return osFile{file}, nil
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Exists(name string) (bool, error) {
+	_, err := os.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (osFS) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, 0o755)
+}
+
+func (osFS) ReadDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (osFS) SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+type osFile struct {
+	*os.File
+}
+
+func (f osFile) Size() (int64, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+func (f osFile) Lock(mode LockMode) error {
+	return lockFile(f.File, mode)
+}
+
+func (f osFile) TryLock(mode LockMode) (bool, error) {
+	return tryLockFile(f.File, mode)
+}
+
+func (f osFile) Unlock() error {
+	return unlockFile(f.File)
+}
+
+// MemFS is a VFS in memory, for databases that need not outlive the
+// process. Every Open of a name shares the same contents, and locks work
+// between them as they would between processes. Files can be created in
+// any directory; ReadDir only lists the ones made with MkdirAll.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memData
+	dirs  map[string]bool
+}
+
+type memData struct {
+	mu   sync.RWMutex
+	data []byte
+
+	// lock is held through File.Lock.
+	lock sync.RWMutex
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memData), dirs: make(map[string]bool)}
+}
+
+func (m *MemFS) Open(name string) (File, error) {
+	return m.open(name, false)
+}
+
+func (m *MemFS) Create(name string) (File, error) {
+	return m.open(name, true)
+}
+
+func (m *MemFS) open(name string, truncate bool) (File, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.files[name]
+	if !ok {
+		d = &memData{}
+		m.files[name] = d
+	}
+	if truncate {
+		d.mu.Lock()
+		d.data = nil
+		d.mu.Unlock()
+	}
+	return &memFile{name: name, d: d}, nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemFS) Exists(name string) (bool, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.files[name]
+	return ok || m.dirs[name], nil
+}
+
+func (m *MemFS) MkdirAll(dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for dir = filepath.Clean(dir); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return fmt.Errorf("mkdir %s: not a directory", dir)
+		}
+		m.dirs[dir] = true
+	}
+	return nil
+}
+
+func (m *MemFS) ReadDir(dir string) ([]string, error) {
+	dir = filepath.Clean(dir)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.dirs[dir] {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrNotExist}
+	}
+	var names []string
+	for name := range m.files {
+		if filepath.Dir(name) == dir {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *MemFS) SyncDir(dir string) error {
+	return nil
+}
+
+type memFile struct {
+	name string
+	d    *memData
+
+	// held is the lock this handle holds, if locked.
+	held   LockMode
+	locked bool
+	closed bool
+}
+
+var errFileClosed = errors.New("file already closed")
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, errFileClosed
+	}
+	f.d.mu.RLock()
+	defer f.d.mu.RUnlock()
+
+	if off >= int64(len(f.d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.d.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, errFileClosed
+	}
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+
+	if end := off + int64(len(p)); end > int64(len(f.d.data)) {
+		grown := make([]byte, end)
+		copy(grown, f.d.data)
+		f.d.data = grown
+	}
+	return copy(f.d.data[off:], p), nil
+}
+
+func (f *memFile) Size() (int64, error) {
+	f.d.mu.RLock()
+	defer f.d.mu.RUnlock()
+	return int64(len(f.d.data)), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+
+	if size <= int64(len(f.d.data)) {
+		f.d.data = f.d.data[:size]
+		return nil
+	}
+	grown := make([]byte, size)
+	copy(grown, f.d.data)
+	f.d.data = grown
+	return nil
+}
+
+func (f *memFile) Sync() error {
+	if f.closed {
+		return errFileClosed
+	}
+	return nil
+}
+
+func (f *memFile) Lock(mode LockMode) error {
+	if f.locked {
+		return fmt.Errorf("%s is already locked", f.name)
+	}
+	if mode == LockExclusive {
+		f.d.lock.Lock()
+	} else {
+		f.d.lock.RLock()
+	}
+	f.held, f.locked = mode, true
+	return nil
+}
+
+func (f *memFile) TryLock(mode LockMode) (bool, error) {
+	if f.locked {
+		return false, fmt.Errorf("%s is already locked", f.name)
+	}
+	var ok bool
+	if mode == LockExclusive {
+		ok = f.d.lock.TryLock()
+	} else {
+		ok = f.d.lock.TryRLock()
+	}
+	if ok {
+		f.held, f.locked = mode, true
+	}
+	return ok, nil
+}
+
+func (f *memFile) Unlock() error {
+	if !f.locked {
+		return nil
+	}
+	if f.held == LockExclusive {
+		f.d.lock.Unlock()
+	} else {
+		f.d.lock.RUnlock()
+	}
+	f.locked = false
+	return nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return errFileClosed
+	}
+	f.Unlock()
+	f.closed = true
+	return nil
+}
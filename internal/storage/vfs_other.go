@@ -0,0 +1,19 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// Without flock, OS files are not locked; handles in one process still
+// serialize through the engine.
+func lockFile(file *os.File, mode LockMode) error {
+	return nil
+}
+
+func tryLockFile(file *os.File, mode LockMode) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
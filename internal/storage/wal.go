@@ -0,0 +1,710 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The write-ahead log records every change as full page images before they
+// reach the database file. It lives in the directory FILE-wal as numbered
+// segments; once a segment is complete and the file has been synced past
+// it, the segment is handed to the Archive, and a base backup plus the
+// archived segments can be replayed up to any point in time with Restore.
+//
+// A segment starts with walMagic and its sequence number. It then holds one
+// record per commit: a batch, or a single page write outside a batch.
+//
+//	timestamp  int64   unix nanoseconds of the commit
+//	numPages   uint32  page count of the file after the commit
+//	count      uint32  number of pages that follow
+//	count times:
+//	  pageNum  uint32  0 is the database header
+//	  data     [PageSize]byte
+//	checksum   uint32  CRC-32 of everything above
+//
+// A record cut short by a crash fails its checksum and is dropped along with
+// anything after it.
+//
+// Pages reach the database file only at a checkpoint. Until then the WAL
+// index maps each page changed in the current segment to its latest image
+// there, and ReadPage looks it up before the file. A checkpoint copies those
+// pages into the file under an exclusive lock on it, which read-only handles
+// in other processes hold shared while a statement runs, see snapshot.go.
+// Only one handle writes: it holds FILE-wal/writer.lock for as long as it is
+// open in WAL mode.
+
+var walMagic = [8]byte{'A', 'n', 'u', 'b', 'i', 's', 'W', 'L'}
+
+const (
+	// DefaultWALSegmentSize is the size past which a segment is completed
+	// and a new one started.
+	DefaultWALSegmentSize = 16 << 20
+
+	walHeaderSize       = 16
+	walRecordHeaderSize = 16
+)
+
+// WALConfig configures WAL mode. A nil Archive keeps completed segments in
+// the WAL directory until an archive is configured.
+type WALConfig struct {
+	Archive     Archive
+	SegmentSize int64
+}
+
+// Archive stores completed WAL segments by name. Get returns an error
+// wrapping fs.ErrNotExist for a segment it does not have. DirArchive keeps
+// them in a directory; an object store needs only these two methods.
+type Archive interface {
+	Put(name string, r io.Reader) error
+	Get(name string) (io.ReadCloser, error)
+}
+
+// DirArchive is an Archive in a local directory.
+type DirArchive string
+
+func (d DirArchive) Put(name string, r io.Reader) error {
+	if err := os.MkdirAll(string(d), 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(string(d), name)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("archive already has %s", name)
+	}
+	return writeFileAtomic(path, r)
+}
+
+func (d DirArchive) Get(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), name))
+}
+
+// walPage is one page image of a record.
+type walPage struct {
+	pageNum uint32
+	data    []byte
+}
+
+type walRecord struct {
+	timestamp time.Time
+	numPages  uint32
+	pages     []walPage
+}
+
+type wal struct {
+	vfs    VFS
+	dir    string
+	config WALConfig
+	seq    uint64
+	file   File
+	size   int64
+
+	// index maps each page logged in the current segment to the offset of
+	// its latest image.
+	index map[uint32]int64
+
+	// lock is writer.lock, held exclusively.
+	lock File
+}
+
+const writerLockName = "writer.lock"
+
+// ErrReadOnly is returned for a write through a handle opened read-only.
+var ErrReadOnly = errors.New("the database is open read-only")
+
+// lockWriter makes the handle the database's only writer in WAL mode.
+func lockWriter(vfs VFS, dir string) (File, error) {
+	lock, err := vfs.Open(filepath.Join(dir, writerLockName))
+	if err != nil {
+		return nil, err
+	}
+	ok, err := lock.TryLock(LockExclusive)
+	if err == nil && !ok {
+		err = errors.New("the database is open for writing elsewhere; open it read-only to read alongside")
+	}
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+func walDir(path string) string {
+	return path + "-wal"
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%016x.wal", seq)
+}
+
+// EnableWAL puts the database in WAL mode, or changes the configuration if
+// it already is. WAL mode lasts as long as the FILE-wal directory exists:
+// OpenPager recovers from it and keeps logging to it.
+func (p *Pager) EnableWAL(config WALConfig) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = DefaultWALSegmentSize
+	}
+	if p.wal != nil {
+		p.wal.config = config
+		return p.archiveSegments()
+	}
+	if p.batch != nil {
+		return ErrBatchActive
+	}
+
+	dir := walDir(p.path)
+	if err := p.vfs.MkdirAll(dir); err != nil {
+		return fmt.Errorf("failed to create WAL directory: %w", err)
+	}
+
+	// Everything so far must be on disk before the log takes over.
+	if err := p.Sync(); err != nil {
+		return err
+	}
+	seq := p.header.CheckpointSegment
+	if seq == 0 {
+		seq = 1
+	}
+	lock, err := lockWriter(p.vfs, dir)
+	if err != nil {
+		return err
+	}
+	w := &wal{vfs: p.vfs, dir: dir, config: config, index: make(map[uint32]int64), lock: lock}
+	if err := w.create(seq); err != nil {
+		lock.Close()
+		return err
+	}
+	p.wal = w
+	if err := p.writeCheckpoint(seq); err != nil {
+		return err
+	}
+	return p.archiveSegments()
+}
+
+// InWAL reports whether the database is in WAL mode.
+func (p *Pager) InWAL() bool {
+	return p.wal != nil
+}
+
+// SwitchWAL completes the current WAL segment and archives it, so that every
+// change made so far can be restored from the archive.
+func (p *Pager) SwitchWAL() error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if p.wal == nil {
+		return errors.New("the database is not in WAL mode")
+	}
+	if p.batch != nil {
+		return ErrBatchActive
+	}
+	if err := p.checkpoint(); err != nil {
+		return err
+	}
+	return p.archiveSegments()
+}
+
+// Restore copies the base backup to dest and replays the archived WAL
+// segments that follow it, stopping before the first commit made after
+// until; a zero until replays everything in the archive. It returns the
+// time of the last commit replayed, which is zero if there was none. dest
+// must not exist and is not in WAL mode afterwards.
+func Restore(base string, archive Archive, until time.Time, dest string) (time.Time, error) {
+	var last time.Time
+
+	if _, err := os.Stat(dest); err == nil {
+		return last, fmt.Errorf("restore target %s already exists", dest)
+	}
+	if err := removeHotPages(OSFS, dest); err != nil {
+		return last, err
+	}
+	src, err := os.Open(base)
+	if err != nil {
+		return last, err
+	}
+	err = writeFileAtomic(dest, src)
+	src.Close()
+	if err != nil {
+		return last, err
+	}
+
+	file, err := os.OpenFile(dest, os.O_RDWR, 0)
+	if err != nil {
+		return last, err
+	}
+	defer file.Close()
+
+	header := make([]byte, PageSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return last, fmt.Errorf("failed to read base backup: %w", err)
+	}
+	if [8]byte(header[0:8]) != dbMagicNumber {
+		return last, errors.New("invalid base backup: bad magic number")
+	}
+	seq := binary.BigEndian.Uint64(header[20:28])
+	if seq == 0 {
+		return last, errors.New("the base backup was not taken in WAL mode")
+	}
+
+	var numPages uint32
+	done := false
+	for ; !done; seq++ {
+		r, err := archive.Get(segmentName(seq))
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return last, fmt.Errorf("failed to read WAL segment %s: %w", segmentName(seq), err)
+		}
+		_, err = readSegment(r, seq, func(rec walRecord) error {
+			if !until.IsZero() && rec.timestamp.After(until) {
+				done = true
+				return errStopReplay
+			}
+			if err := applyRecord(file, rec); err != nil {
+				return err
+			}
+			last, numPages = rec.timestamp, rec.numPages
+			return nil
+		})
+		r.Close()
+		if err != nil && err != errStopReplay {
+			return last, fmt.Errorf("failed to replay WAL segment %s: %w", segmentName(seq), err)
+		}
+	}
+
+	if numPages > 0 {
+		if err := file.Truncate(int64(numPages+1) * PageSize); err != nil {
+			return last, err
+		}
+	}
+
+	// Segments after the ones replayed belong to the original database; a
+	// WAL enabled on the restored one starts numbering after them.
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return last, err
+	}
+	binary.BigEndian.PutUint64(header[20:28], seq)
+	if _, err := file.WriteAt(header, 0); err != nil {
+		return last, err
+	}
+	return last, file.Sync()
+}
+
+// errStopReplay ends readSegment early without an error.
+var errStopReplay = errors.New("stop replay")
+
+// logPages appends a record of pages to the WAL, if the database is in WAL
+// mode. numPages is the page count once they are written.
+func (p *Pager) logPages(numPages uint32, pages []walPage, sync bool) error {
+	if p.wal == nil {
+		return nil
+	}
+	if err := p.wal.append(p.clock.Now(), numPages, pages, sync); err != nil {
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+	return nil
+}
+
+// checkpointIfFull checkpoints once the current segment reaches the segment
+// size. Archiving failures are only warned about: the segment stays
+// in the WAL directory and is archived with the next one.
+func (p *Pager) checkpointIfFull() error {
+	if p.wal == nil || p.batch != nil || p.wal.size < p.wal.config.SegmentSize {
+		return nil
+	}
+	if err := p.checkpoint(); err != nil {
+		return err
+	}
+	if err := p.archiveSegments(); err != nil {
+		fmt.Printf("Warning: failed to archive WAL: %v\n", err)
+	}
+	return nil
+}
+
+// checkpoint writes the pages of the current segment into the file and
+// syncs it, so the segment is no longer needed for recovery, and moves on
+// to the next segment. It waits for statements of read-only handles, which
+// may still need the file as it was.
+func (p *Pager) checkpoint() error {
+	if err := p.file.Lock(LockExclusive); err != nil {
+		return err
+	}
+	defer p.file.Unlock()
+
+	w := p.wal
+	pageNums := make([]uint32, 0, len(w.index))
+	for pageNum := range w.index {
+		// The header is written from p.header below, and pages past the
+		// end were released since they were logged.
+		if pageNum != 0 && pageNum <= p.numPages {
+			pageNums = append(pageNums, pageNum)
+		}
+	}
+	sort.Slice(pageNums, func(i, j int) bool { return pageNums[i] < pageNums[j] })
+	buf := make([]byte, PageSize)
+	for _, pageNum := range pageNums {
+		if _, err := w.file.ReadAt(buf, w.index[pageNum]); err != nil {
+			return fmt.Errorf("failed to read page %d from the WAL: %w", pageNum, err)
+		}
+		if _, err := p.file.WriteAt(buf, int64(PageSize)*int64(pageNum)); err != nil {
+			return fmt.Errorf("failed to write page %d: %w", pageNum, err)
+		}
+	}
+
+	size, err := p.file.Size()
+	if err != nil {
+		return err
+	}
+	if end := int64(p.numPages+1) * PageSize; size > end {
+		if err := p.file.Truncate(end); err != nil {
+			return fmt.Errorf("failed to truncate the file: %w", err)
+		}
+	}
+	if err := p.Sync(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close WAL segment: %w", err)
+	}
+	w.index = make(map[uint32]int64)
+	if err := w.create(w.seq + 1); err != nil {
+		return err
+	}
+	return p.writeCheckpoint(w.seq)
+}
+
+// checkpointOnClose checkpoints if anything was logged since the last
+// checkpoint and archives the segment it completes.
+func (p *Pager) checkpointOnClose() error {
+	if p.wal.size <= walHeaderSize {
+		return nil
+	}
+	if err := p.checkpoint(); err != nil {
+		return fmt.Errorf("failed to checkpoint: %w", err)
+	}
+	if err := p.archiveSegments(); err != nil {
+		fmt.Printf("Warning: failed to archive WAL: %v\n", err)
+	}
+	return nil
+}
+
+func (p *Pager) writeCheckpoint(seq uint64) error {
+	p.header.CheckpointSegment = seq
+	if err := p.writeHeader(); err != nil {
+		return err
+	}
+	return p.Sync()
+}
+
+// archiveSegments hands every completed segment to the archive, oldest
+// first, and removes it from the WAL directory.
+func (p *Pager) archiveSegments() error {
+	w := p.wal
+	if w.config.Archive == nil {
+		return nil
+	}
+	seqs, err := listSegments(w.vfs, w.dir)
+	if err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		if seq >= p.header.CheckpointSegment {
+			break
+		}
+		path := filepath.Join(w.dir, segmentName(seq))
+		file, size, err := openSegment(w.vfs, path)
+		if err != nil {
+			return err
+		}
+		err = w.config.Archive.Put(segmentName(seq), io.NewSectionReader(file, 0, size))
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to archive WAL segment %s: %w", segmentName(seq), err)
+		}
+		if err := w.vfs.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverWAL replays the segments the file may not contain yet, if the
+// database is in WAL mode, and continues logging to the last one.
+func (p *Pager) recoverWAL() error {
+	dir := walDir(p.path)
+	if exists, err := p.vfs.Exists(dir); err != nil || !exists {
+		return nil
+	}
+	if p.numPages == 0 && p.header.CheckpointSegment == 0 {
+		return fmt.Errorf("WAL directory %s exists without its database", dir)
+	}
+	lock, err := lockWriter(p.vfs, dir)
+	if err != nil {
+		return err
+	}
+	w := &wal{vfs: p.vfs, dir: dir, config: WALConfig{SegmentSize: DefaultWALSegmentSize}, index: make(map[uint32]int64), lock: lock}
+	if err := p.replayWAL(w); err != nil {
+		if w.file != nil {
+			w.file.Close()
+		}
+		lock.Close()
+		return err
+	}
+	p.wal = w
+
+	// A replayed header page replaces the one read before.
+	if err := p.readHeader(); err != nil {
+		return err
+	}
+	p.cache.Clear()
+	return nil
+}
+
+// replayWAL writes the segments from the checkpoint on into the file and
+// leaves w logging to the last of them. Read-only handles wait meanwhile.
+func (p *Pager) replayWAL(w *wal) error {
+	if err := p.file.Lock(LockExclusive); err != nil {
+		return err
+	}
+	defer p.file.Unlock()
+
+	seqs, err := listSegments(p.vfs, w.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read WAL directory: %w", err)
+	}
+	for i, seq := range seqs {
+		if seq < p.header.CheckpointSegment {
+			continue
+		}
+		path := filepath.Join(w.dir, segmentName(seq))
+		file, size, err := openSegment(p.vfs, path)
+		if err != nil {
+			return err
+		}
+		valid, err := readSegment(io.NewSectionReader(file, 0, size), seq, func(rec walRecord) error {
+			if err := applyRecord(p.file, rec); err != nil {
+				return err
+			}
+			p.numPages = rec.numPages
+			return nil
+		})
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to recover WAL segment %s: %w", segmentName(seq), err)
+		}
+
+		if i < len(seqs)-1 {
+			file.Close()
+			continue
+		}
+		if valid < walHeaderSize {
+			// Cut short while being created.
+			file.Close()
+			if err := w.create(seq); err != nil {
+				return err
+			}
+			break
+		}
+		if err := file.Truncate(valid); err != nil {
+			file.Close()
+			return err
+		}
+		w.file, w.seq, w.size = file, seq, valid
+	}
+
+	if w.file == nil {
+		seq := p.header.CheckpointSegment
+		if seq == 0 {
+			seq = 1
+		}
+		return w.create(seq)
+	}
+	return nil
+}
+
+func listSegments(vfs VFS, dir string) ([]uint64, error) {
+	names, err := vfs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, name := range names {
+		name, ok := strings.CutSuffix(name, ".wal")
+		if !ok || len(name) != 16 {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 16, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+func openSegment(vfs VFS, path string) (File, int64, error) {
+	file, err := vfs.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := file.Size()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, size, nil
+}
+
+// create starts segment seq, replacing any file of that name.
+func (w *wal) create(seq uint64) error {
+	path := filepath.Join(w.dir, segmentName(seq))
+	file, err := w.vfs.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create WAL segment: %w", err)
+	}
+
+	header := make([]byte, walHeaderSize)
+	copy(header[0:8], walMagic[:])
+	binary.BigEndian.PutUint64(header[8:16], seq)
+	if _, err := file.WriteAt(header, 0); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to create WAL segment: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to create WAL segment: %w", err)
+	}
+	w.vfs.SyncDir(w.dir)
+
+	w.file, w.seq, w.size = file, seq, walHeaderSize
+	return nil
+}
+
+func (w *wal) append(now time.Time, numPages uint32, pages []walPage, sync bool) error {
+	buf := make([]byte, walRecordHeaderSize, walRecordHeaderSize+len(pages)*(4+PageSize)+4)
+	binary.BigEndian.PutUint64(buf[0:8], uint64(now.UnixNano()))
+	binary.BigEndian.PutUint32(buf[8:12], numPages)
+	binary.BigEndian.PutUint32(buf[12:16], uint32(len(pages)))
+	for _, page := range pages {
+		buf = binary.BigEndian.AppendUint32(buf, page.pageNum)
+		buf = append(buf, page.data[:PageSize]...)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	// A record that failed is overwritten by the next one, so that it does
+	// not hide the records after it from recovery.
+	off := w.size
+	if _, err := w.file.WriteAt(buf, off); err != nil {
+		return err
+	}
+	if sync {
+		if err := w.file.Sync(); err != nil {
+			return err
+		}
+	}
+	w.size += int64(len(buf))
+	for i, page := range pages {
+		w.index[page.pageNum] = pageOffset(off, i)
+	}
+	return nil
+}
+
+// pageOffset returns the offset of the i-th page image of the record at
+// off.
+func pageOffset(off int64, i int) int64 {
+	return off + walRecordHeaderSize + int64(i)*(4+PageSize) + 4
+}
+
+func (w *wal) close() error {
+	defer w.lock.Close()
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// readSegment calls fn with each intact record of segment seq and returns
+// the length of the segment up to the end of the last of them.
+func readSegment(r io.Reader, seq uint64, fn func(walRecord) error) (int64, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return 0, nil
+	}
+	if [8]byte(header[0:8]) != walMagic {
+		return 0, errors.New("bad magic number")
+	}
+	if got := binary.BigEndian.Uint64(header[8:16]); got != seq {
+		return 0, fmt.Errorf("segment holds sequence number %d", got)
+	}
+
+	valid := int64(walHeaderSize)
+	for {
+		rec, n, ok := readRecord(br)
+		if !ok {
+			return valid, nil
+		}
+		if err := fn(rec); err != nil {
+			return valid, err
+		}
+		valid += n
+	}
+}
+
+// readRecord reads the next record, reporting false at the end of the
+// segment or at a record that is incomplete or fails its checksum.
+func readRecord(r io.Reader) (walRecord, int64, bool) {
+	var rec walRecord
+	hash := crc32.NewIEEE()
+	tr := io.TeeReader(r, hash)
+
+	header := make([]byte, walRecordHeaderSize)
+	if _, err := io.ReadFull(tr, header); err != nil {
+		return rec, 0, false
+	}
+	rec.timestamp = time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8])))
+	rec.numPages = binary.BigEndian.Uint32(header[8:12])
+	count := binary.BigEndian.Uint32(header[12:16])
+	if count > rec.numPages+1 {
+		return rec, 0, false
+	}
+
+	for i := uint32(0); i < count; i++ {
+		frame := make([]byte, 4+PageSize)
+		if _, err := io.ReadFull(tr, frame); err != nil {
+			return rec, 0, false
+		}
+		rec.pages = append(rec.pages, walPage{binary.BigEndian.Uint32(frame[0:4]), frame[4:]})
+	}
+
+	sum := make([]byte, 4)
+	if _, err := io.ReadFull(r, sum); err != nil || binary.BigEndian.Uint32(sum) != hash.Sum32() {
+		return rec, 0, false
+	}
+	return rec, walRecordHeaderSize + int64(count)*(4+PageSize) + 4, true
+}
+
+func applyRecord(file io.WriterAt, rec walRecord) error {
+	for _, page := range rec.pages {
+		if _, err := file.WriteAt(page.data, int64(PageSize)*int64(page.pageNum)); err != nil {
+			return fmt.Errorf("failed to write page %d: %w", page.pageNum, err)
+		}
+	}
+	return nil
+}
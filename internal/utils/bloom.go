@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// BloomFilter answers whether a key may have been added: a "no" is always
+// right, a "yes" is wrong about as often as the false positive rate it
+// was sized for, as long as no more keys than that were added. Keys cannot
+// be removed.
+type BloomFilter struct {
+	bits   []uint64
+	hashes int
+	added  int
+	size   int
+}
+
+// NewBloomFilter returns a filter sized to hold n keys with a false
+// positive rate of about rate.
+func NewBloomFilter(n int, rate float64) *BloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(rate) / (math.Ln2 * math.Ln2))
+	words := int(math.Ceil(m / 64))
+	hashes := int(math.Round(m / float64(n) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &BloomFilter{bits: make([]uint64, words), hashes: hashes, size: n}
+}
+
+// locations derives the filter's bit positions for key from two halves of
+// one 64-bit hash, as in Kirsch and Mitzenmacher's double hashing.
+func (f *BloomFilter) locations(key []byte, fn func(bit uint64)) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	nbits := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		fn((h1 + i*h2) % nbits)
+	}
+}
+
+func (f *BloomFilter) Add(key []byte) {
+	f.locations(key, func(bit uint64) {
+		f.bits[bit/64] |= 1 << (bit % 64)
+	})
+	f.added++
+}
+
+// MayContain reports whether key may have been added. False means it
+// certainly was not.
+func (f *BloomFilter) MayContain(key []byte) bool {
+	found := true
+	f.locations(key, func(bit uint64) {
+		found = found && f.bits[bit/64]&(1<<(bit%64)) != 0
+	})
+	return found
+}
+
+// Full reports whether more keys were added than the filter was sized
+// for, past which its false positive rate climbs.
+func (f *BloomFilter) Full() bool {
+	return f.added > f.size
+}
+
+// Bytes is the memory the filter's bits take.
+func (f *BloomFilter) Bytes() int {
+	return len(f.bits) * 8
+}
@@ -0,0 +1,115 @@
+// Package sqlerr gives engine errors stable, SQLSTATE-style codes so callers
+// can branch on the kind of failure instead of matching message text.
+package sqlerr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code is a five-character SQLSTATE. The first two characters are its class.
+// The values follow PostgreSQL where it has an equivalent.
+type Code string
+
+const (
+	FeatureNotSupported       Code = "0A000"
+	InvalidLimitValue         Code = "2201W"
+	InvalidOffsetValue        Code = "2201X"
+	InvalidParameterValue     Code = "22023"
+	InvalidTextRepresentation Code = "22P02"
+	NotNullViolation          Code = "23502"
+	UniqueViolation           Code = "23505"
+	CheckViolation            Code = "23514"
+	ReadOnlySQLTransaction    Code = "25006"
+	InvalidPassword           Code = "28P01"
+	InsufficientPrivilege     Code = "42501"
+	SyntaxError               Code = "42601"
+	AmbiguousColumn           Code = "42702"
+	UndefinedColumn           Code = "42703"
+	DatatypeMismatch          Code = "42804"
+	UndefinedFunction         Code = "42883"
+	UndefinedTable            Code = "42P01"
+	DuplicateTable            Code = "42P07"
+	InvalidTableDefinition    Code = "42P16"
+	UndefinedObject           Code = "42704"
+	DuplicateObject           Code = "42710"
+	TooManyConnections        Code = "53300"
+	QueryCanceled             Code = "57014"
+	AdminShutdown             Code = "57P01"
+	IdleSessionTimeout        Code = "57P05"
+	InternalError             Code = "XX000"
+	DataCorrupted             Code = "XX001"
+)
+
+// Class returns the two-character class of the code, e.g. "23" for integrity
+// constraint violations.
+func (c Code) Class() string {
+	return string(c[:2])
+}
+
+// Error attaches a code to an error. Its message is the wrapped error's, so
+// adding a code never changes what users see.
+type Error struct {
+	Code Code
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New formats an error like fmt.Errorf and tags it with code.
+func New(code Code, format string, args ...interface{}) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// Wrap tags err with code. It returns nil for a nil err.
+func Wrap(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// CodeOf returns the code of the outermost Error in err's chain, or
+// InternalError if there is none. It returns "" for a nil err.
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return InternalError
+}
+
+func IsConstraintViolation(err error) bool {
+	return err != nil && CodeOf(err).Class() == "23"
+}
+
+func IsSyntaxError(err error) bool {
+	return CodeOf(err) == SyntaxError
+}
+
+func IsNotFound(err error) bool {
+	switch CodeOf(err) {
+	case UndefinedTable, UndefinedColumn, UndefinedObject, UndefinedFunction:
+		return true
+	}
+	return false
+}
+
+// IsConflict reports whether err came from creating something that already
+// exists.
+func IsConflict(err error) bool {
+	switch CodeOf(err) {
+	case DuplicateTable, DuplicateObject:
+		return true
+	}
+	return false
+}